@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+	"github.com/TheusHen/I6P/i6p/transfer/erasure"
+)
+
+// maxPayload bounds what a receiver agrees to buffer for one run.
+const maxPayload = 64 << 30
+
+// Config describes one benchmark run.
+type Config struct {
+	Size     int64
+	Transfer transfer.TransferConfig
+}
+
+// header is sent by the sender on the first stream before any data.
+type header struct {
+	Size          int64  `json:"size"`         // payload bytes before erasure coding
+	WireSize      int64  `json:"wire_size"`    // bytes handed to the bulk sender
+	ChunkSize     int    `json:"chunk_size"`   // bulk transfer chunk size
+	Chunks        int    `json:"chunks"`       // chunks the receiver should expect
+	ErasureData   int    `json:"erasure_data"` // 0 when erasure coding is off
+	ErasureParity int    `json:"erasure_parity"`
+	ShardSize     int    `json:"shard_size"`
+	Digest        []byte `json:"digest"` // SHA-256 of the original payload
+}
+
+// Result is what each side reports at the end of a run.
+type Result struct {
+	Role       string        `json:"role"`
+	Bytes      int64         `json:"bytes"`      // payload bytes
+	WireBytes  int64         `json:"wire_bytes"` // compressed bytes sent, including parity
+	Elapsed    time.Duration `json:"elapsed_ns"`
+	CPU        time.Duration `json:"cpu_ns"` // process CPU time; 0 where unavailable
+	Chunks     int64         `json:"chunks"`
+	Missing    int64         `json:"missing"`
+	Duplicates int64         `json:"duplicates"`
+	Erasure    string        `json:"erasure,omitempty"`
+	Verified   bool          `json:"verified"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// Throughput returns payload bytes per second.
+func (r Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+// WireThroughput returns bytes per second actually sent.
+func (r Result) WireThroughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.WireBytes) / r.Elapsed.Seconds()
+}
+
+// CompressionRatio returns payload bytes per wire byte.
+func (r Result) CompressionRatio() float64 {
+	if r.WireBytes == 0 {
+		return 1
+	}
+	return float64(r.Bytes) / float64(r.WireBytes)
+}
+
+type ready struct{}
+
+// sessionOpener lets a bulk sender open streams on a session.
+type sessionOpener struct{ s *session.Session }
+
+func (o sessionOpener) OpenStreamSync(ctx context.Context) (io.ReadWriteCloser, error) {
+	return o.s.OpenStream(ctx)
+}
+
+// Send runs the sender side: it announces the run, pushes data through a bulk
+// sender and waits for the receiver's result. Elapsed covers the whole
+// exchange, up to the receiver confirming it has every chunk.
+func Send(ctx context.Context, sess *session.Session, data []byte, cfg Config) (Result, error) {
+	start, cpu0 := time.Now(), cpuTime()
+	digest := sha256.Sum256(data)
+	h := header{
+		Size:          int64(len(data)),
+		ChunkSize:     cfg.Transfer.ChunkSize,
+		ErasureData:   cfg.Transfer.ErasureData,
+		ErasureParity: cfg.Transfer.ErasureParity,
+		Digest:        digest[:],
+	}
+	payload := data
+	if h.ErasureData > 0 {
+		codec, err := erasure.NewCodec(h.ErasureData, h.ErasureParity)
+		if err != nil {
+			return Result{}, err
+		}
+		shards, err := codec.EncodeData(data)
+		if err != nil {
+			return Result{}, err
+		}
+		h.ShardSize = len(shards[0])
+		payload = bytes.Join(shards, nil)
+	}
+	h.WireSize = int64(len(payload))
+	h.Chunks = int((h.WireSize + int64(h.ChunkSize) - 1) / int64(h.ChunkSize))
+
+	ctl, err := sess.OpenStream(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer ctl.Close()
+	stop := context.AfterFunc(ctx, func() { _ = ctl.SetReadDeadline(time.Now()) })
+	defer stop()
+	if err := json.NewEncoder(ctl).Encode(h); err != nil {
+		return Result{}, err
+	}
+	dec := json.NewDecoder(ctl)
+	if err := dec.Decode(&ready{}); err != nil {
+		return Result{}, fmt.Errorf("waiting for receiver: %w", err)
+	}
+
+	bs := transfer.NewBulkSender(sessionOpener{sess}, cfg.Transfer)
+	defer bs.Close()
+	flow := func(f protocol.Frame) { _ = bs.FlowControl().HandleFrame(f) }
+	sess.HandleFrame(protocol.MessageTypeAck, flow)
+	sess.HandleFrame(protocol.MessageTypeWindowUpdate, flow)
+	defer sess.HandleFrame(protocol.MessageTypeAck, nil)
+	defer sess.HandleFrame(protocol.MessageTypeWindowUpdate, nil)
+	if _, err := bs.Send(ctx, payload); err != nil {
+		return Result{}, err
+	}
+	var remote Result
+	if err := dec.Decode(&remote); err != nil {
+		return Result{}, fmt.Errorf("waiting for result: %w", err)
+	}
+	if remote.Error != "" {
+		return remote, errors.New(remote.Error)
+	}
+
+	return Result{
+		Role:       "send",
+		Bytes:      h.Size,
+		WireBytes:  bs.Stats().CompressedBytes.Load(),
+		Elapsed:    time.Since(start),
+		CPU:        cpuTime() - cpu0,
+		Chunks:     remote.Chunks,
+		Missing:    remote.Missing,
+		Duplicates: remote.Duplicates,
+		Erasure:    remote.Erasure,
+		Verified:   remote.Verified,
+	}, nil
+}
+
+// Receive runs the receiver side of one run on sess and sends the result back.
+func Receive(ctx context.Context, sess *session.Session) (Result, error) {
+	ctl, err := sess.AcceptStream(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer ctl.Close()
+	stop := context.AfterFunc(ctx, func() { _ = ctl.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	var h header
+	if err := json.NewDecoder(ctl).Decode(&h); err != nil {
+		return Result{}, fmt.Errorf("read header: %w", err)
+	}
+	if h.ChunkSize <= 0 || h.Chunks <= 0 || h.WireSize <= 0 || h.WireSize > maxPayload {
+		return Result{}, fmt.Errorf("invalid header: %+v", h)
+	}
+
+	br := transfer.NewBulkReceiver(transfer.TransferConfig{ChunkSize: h.ChunkSize})
+	br.SetExpectedChunks(h.Chunks)
+	// Acknowledge each chunk, in case the sender runs with a flow window.
+	br.SetFlowAcks(transfer.NewFlowAcks(sess.SendFrame, 0, 1))
+	enc := json.NewEncoder(ctl)
+	if err := enc.Encode(ready{}); err != nil {
+		return Result{}, err
+	}
+	start, cpu0 := time.Now(), cpuTime()
+
+	res, err := receive(ctx, sess, br, h)
+	res.Role = "recv"
+	res.Elapsed = time.Since(start)
+	res.CPU = cpuTime() - cpu0
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if werr := enc.Encode(res); werr != nil && err == nil {
+		err = werr
+	}
+	// Wait for the sender to close its side, so closing the session does not
+	// discard the result in flight.
+	_, _ = io.Copy(io.Discard, ctl)
+	return res, err
+}
+
+func receive(ctx context.Context, sess *session.Session, br *transfer.BulkReceiver, h header) (Result, error) {
+	complete := make(chan struct{})
+	var once sync.Once
+	var wire atomic.Int64
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		for {
+			st, err := sess.AcceptStream(rctx)
+			if err != nil {
+				return
+			}
+			go func() {
+				defer st.Close()
+				for {
+					b, err := transfer.ReadBatchContext(rctx, st)
+					if err != nil {
+						return
+					}
+					for _, cc := range b.Chunks {
+						wire.Add(int64(len(cc.Data)))
+					}
+					// Corrupt chunks are counted in the stats and reported as missing.
+					_ = br.ReceiveBatch(b)
+					if br.IsComplete() {
+						once.Do(func() { close(complete) })
+					}
+				}
+			}()
+		}
+	}()
+
+	stats := br.Stats()
+	res := Result{Bytes: h.Size}
+	select {
+	case <-complete:
+	case <-ctx.Done():
+	}
+	res.WireBytes = wire.Load()
+	res.Chunks = stats.ChunksReceived.Load()
+	res.Duplicates = stats.Duplicates.Load()
+	res.Missing = int64(h.Chunks) - res.Chunks
+	if res.Missing > 0 {
+		return res, fmt.Errorf("run ended with %d of %d chunks missing", res.Missing, h.Chunks)
+	}
+
+	out, err := br.Assemble(nil)
+	if err != nil {
+		return res, err
+	}
+	if h.ErasureData > 0 {
+		res.Erasure = fmt.Sprintf("%d+%d", h.ErasureData, h.ErasureParity)
+		if out, err = decodeErasure(out, h); err != nil {
+			return res, err
+		}
+	}
+	digest := sha256.Sum256(out)
+	res.Verified = bytes.Equal(digest[:], h.Digest)
+	return res, nil
+}
+
+// decodeErasure checks the parity shards and joins the data shards. The
+// stream transport is reliable, so this measures the decoding cost rather
+// than recovering losses.
+func decodeErasure(wire []byte, h header) ([]byte, error) {
+	codec, err := erasure.NewCodec(h.ErasureData, h.ErasureParity)
+	if err != nil {
+		return nil, err
+	}
+	total := codec.TotalShards()
+	if h.ShardSize <= 0 || len(wire) != total*h.ShardSize {
+		return nil, fmt.Errorf("erasure payload is %d bytes, want %d shards of %d", len(wire), total, h.ShardSize)
+	}
+	shards := make([][]byte, total)
+	for i := range shards {
+		shards[i] = wire[i*h.ShardSize : (i+1)*h.ShardSize]
+	}
+	if ok, err := codec.Verify(shards); err != nil || !ok {
+		return nil, errors.New("erasure parity does not match data")
+	}
+	return codec.Join(shards, int(h.Size))
+}
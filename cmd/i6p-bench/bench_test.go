@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+func TestBenchRun(t *testing.T) {
+	for _, erasureSpec := range []string{"", "4+2"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		serverKP, _ := identity.GenerateKeyPair()
+		clientKP, _ := identity.GenerateKeyPair()
+		server := i6p.NewPeer(serverKP, nil)
+		network := memory.NewNetwork()
+		ln, _ := network.Listen("recv")
+		server.Serve(ln)
+
+		recv := make(chan Result, 1)
+		go func() {
+			sess, err := server.Accept(ctx)
+			if err != nil {
+				t.Errorf("Accept: %v", err)
+				return
+			}
+			res, err := Receive(ctx, sess)
+			if err != nil {
+				t.Errorf("Receive: %v", err)
+			}
+			recv <- res
+		}()
+
+		conn, _ := network.Dial(ctx, "recv")
+		sess, err := i6p.NewPeer(clientKP, nil).Connect(ctx, conn)
+		if err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		cfg, err := parseConfig("3MiB", "64KiB", "fast", erasureSpec, 4, 4)
+		if err != nil {
+			t.Fatalf("parseConfig: %v", err)
+		}
+		data := make([]byte, cfg.Size)
+		_, _ = rand.Read(data)
+
+		sent, err := Send(ctx, sess, data, cfg)
+		if err != nil {
+			t.Fatalf("Send (erasure %q): %v", erasureSpec, err)
+		}
+		got := <-recv
+		if !sent.Verified || !got.Verified || sent.Missing != 0 {
+			t.Fatalf("run not verified: sent %+v, received %+v", sent, got)
+		}
+		if sent.Bytes != 3<<20 || sent.Throughput() <= 0 || got.WireBytes == 0 {
+			t.Fatalf("unexpected result %+v / %+v", sent, got)
+		}
+		if (erasureSpec != "") != (got.Erasure != "") {
+			t.Fatalf("erasure %q reported as %q", erasureSpec, got.Erasure)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{"4096": 4096, "512KiB": 512 << 10, "1GiB": 1 << 30, "8M": 8 << 20} {
+		got, err := parseSize(in)
+		if err != nil || got != want {
+			t.Fatalf("parseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "-1", "MiB", "1TiB"} {
+		if _, err := parseSize(in); err == nil {
+			t.Fatalf("parseSize(%q) accepted", in)
+		}
+	}
+	if _, err := parseConfig("1MiB", "64KiB", "fast", "4-2", 1, 1); err == nil {
+		t.Fatalf("bad erasure spec accepted")
+	}
+}
@@ -0,0 +1,195 @@
+// Command i6p-bench measures end-to-end I6P throughput between two machines.
+//
+// Start a receiver on one host:
+//
+//	i6p-bench -role recv -addr [::]:4433
+//
+// and a sender on the other:
+//
+//	i6p-bench -role send -addr [2001:db8::1]:4433 -size 1GiB -streams 8 -erasure 10+4
+//
+// Both sides print throughput, CPU time and chunk-level loss when the run ends.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/transfer"
+)
+
+func main() {
+	var (
+		role        = flag.String("role", "", "send or recv")
+		addr        = flag.String("addr", "[::]:4433", "listen address (recv) or receiver address (send)")
+		size        = flag.String("size", "256MiB", "bytes to send, with optional KiB/MiB/GiB suffix")
+		chunk       = flag.String("chunk", "256KiB", "chunk size")
+		compression = flag.String("compression", "fast", "compression level: fast, default, best or lz4-0 to lz4-9")
+		erasureSpec = flag.String("erasure", "", "erasure coding as DATA+PARITY shards, e.g. 10+4 (empty disables)")
+		streams     = flag.Int("streams", 8, "parallel streams")
+		workers     = flag.Int("workers", 4, "sender worker goroutines")
+		pattern     = flag.String("data", "random", "payload: random (incompressible) or zero (compressible)")
+		timeout     = flag.Duration("timeout", 10*time.Minute, "abort a run after this long")
+		once        = flag.Bool("once", false, "recv: exit after the first run")
+		jsonOut     = flag.Bool("json", false, "print the result as JSON")
+	)
+	flag.Parse()
+
+	kp, err := identity.GenerateKeyPair()
+	if err != nil {
+		log.Fatalf("generate identity: %v", err)
+	}
+	peer := i6p.NewPeer(kp, map[string]string{"role": "bench"})
+
+	var res Result
+	switch *role {
+	case "recv":
+		if err := peer.Listen(*addr); err != nil {
+			log.Fatalf("listen: %v", err)
+		}
+		defer peer.Close()
+		log.Printf("listening on %s as %s", peer.ListenAddr(), kp.PeerID())
+		for {
+			sess, err := peer.Accept(context.Background())
+			if err != nil {
+				log.Fatalf("accept: %v", err)
+			}
+			log.Printf("run from %s", sess.RemotePeerID())
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			res, err = Receive(ctx, sess)
+			cancel()
+			_ = sess.CloseWithError(0, "done")
+			if err != nil {
+				log.Printf("run failed: %v", err)
+				continue
+			}
+			printResult(res, *jsonOut)
+			if *once {
+				return
+			}
+		}
+
+	case "send":
+		cfg, err := parseConfig(*size, *chunk, *compression, *erasureSpec, *streams, *workers)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		data := make([]byte, cfg.Size)
+		if *pattern == "random" {
+			if _, err := rand.Read(data); err != nil {
+				log.Fatalf("generate payload: %v", err)
+			}
+		} else if *pattern != "zero" {
+			log.Fatalf("unknown -data %q", *pattern)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		sess, err := peer.Dial(ctx, *addr)
+		if err != nil {
+			log.Fatalf("dial: %v", err)
+		}
+		defer sess.CloseWithError(0, "done")
+		res, err = Send(ctx, sess, data, cfg)
+		if err != nil {
+			log.Fatalf("send: %v", err)
+		}
+		printResult(res, *jsonOut)
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func parseConfig(size, chunk, compression, erasureSpec string, streams, workers int) (Config, error) {
+	var cfg Config
+	n, err := parseSize(size)
+	if err != nil {
+		return cfg, fmt.Errorf("-size: %w", err)
+	}
+	c, err := parseSize(chunk)
+	if err != nil {
+		return cfg, fmt.Errorf("-chunk: %w", err)
+	}
+	cfg.Size = n
+	cfg.Transfer = transfer.DefaultTransferConfig()
+	cfg.Transfer.ChunkSize = int(c)
+	cfg.Transfer.ParallelStreams = streams
+	cfg.Transfer.ParallelWorkers = workers
+	switch compression {
+	case "fast":
+		cfg.Transfer.Compression = transfer.CompressionFast
+	case "default":
+		cfg.Transfer.Compression = transfer.CompressionDefault
+	case "best":
+		cfg.Transfer.Compression = transfer.CompressionBest
+	default:
+		n, err := strconv.Atoi(strings.TrimPrefix(compression, "lz4-"))
+		if err != nil || !strings.HasPrefix(compression, "lz4-") {
+			return cfg, fmt.Errorf("-compression: unknown level %q", compression)
+		}
+		if cfg.Transfer.Codec, err = transfer.NewLZ4(transfer.LZ4Options{Level: n}); err != nil {
+			return cfg, fmt.Errorf("-compression: %w", err)
+		}
+	}
+	if erasureSpec != "" {
+		d, p, ok := strings.Cut(erasureSpec, "+")
+		data, err1 := strconv.Atoi(d)
+		parity, err2 := strconv.Atoi(p)
+		if !ok || err1 != nil || err2 != nil || data <= 0 || parity < 0 {
+			return cfg, fmt.Errorf("-erasure: want DATA+PARITY, got %q", erasureSpec)
+		}
+		cfg.Transfer.ErasureData, cfg.Transfer.ErasureParity = data, parity
+	}
+	return cfg, nil
+}
+
+// parseSize parses a byte count such as 4096, 512KiB or 1GiB.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}}
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSuffix(s, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+func printResult(r Result, asJSON bool) {
+	if asJSON {
+		_ = json.NewEncoder(os.Stdout).Encode(r)
+		return
+	}
+	fmt.Printf("role        %s\n", r.Role)
+	fmt.Printf("payload     %d bytes (%d on the wire, %.2fx compression)\n", r.Bytes, r.WireBytes, r.CompressionRatio())
+	fmt.Printf("elapsed     %v\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Printf("throughput  %.2f MiB/s (%.2f Mbit/s on the wire)\n", r.Throughput()/(1<<20), r.WireThroughput()*8/1e6)
+	if r.CPU > 0 {
+		fmt.Printf("cpu         %v (%.0f%% of one core)\n", r.CPU.Round(time.Millisecond), 100*r.CPU.Seconds()/r.Elapsed.Seconds())
+	}
+	fmt.Printf("chunks      %d received, %d missing, %d duplicate\n", r.Chunks, r.Missing, r.Duplicates)
+	if r.Erasure != "" {
+		fmt.Printf("erasure     %s\n", r.Erasure)
+	}
+	fmt.Printf("verified    %v\n", r.Verified)
+}
@@ -0,0 +1,55 @@
+// Command i6p-vectors publishes the I6P golden wire-format vectors and checks
+// vector files produced by other implementations.
+//
+// Write the golden vectors for another implementation's test suite:
+//
+//	i6p-vectors -dump > vectors.json
+//
+// Check a vector file generated by another implementation against this one:
+//
+//	i6p-vectors -check their-vectors.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/TheusHen/I6P/i6p/testvectors"
+)
+
+func main() {
+	var (
+		dump  = flag.Bool("dump", false, "print the golden vectors as JSON")
+		check = flag.String("check", "", "verify the vectors in this JSON file")
+	)
+	flag.Parse()
+
+	switch {
+	case *dump:
+		os.Stdout.Write(testvectors.Golden())
+	case *check != "":
+		b, err := os.ReadFile(*check)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var s testvectors.Set
+		if err := json.Unmarshal(b, &s); err != nil {
+			log.Fatalf("parse %s: %v", *check, err)
+		}
+		failures := testvectors.Verify(&s)
+		for _, f := range failures {
+			fmt.Println("FAIL", f.Error())
+		}
+		if len(failures) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("ok: %d frames, %d hellos, %d batches, %d tickets, %d ratchets\n",
+			len(s.Frames), len(s.Hellos), len(s.Batches), len(s.Tickets), len(s.Ratchets))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/TheusHen/I6P/i6p/discovery"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+)
+
+// The control API is JSON over HTTP, served on a local socket:
+//
+//	GET    /v1/info              identity and listen address
+//	GET    /v1/sessions          open sessions
+//	POST   /v1/sessions          dial {"peer_id"} from the peerstore, or {"uri"} / {"addr"}
+//	DELETE /v1/sessions/{peer}   close sessions with a peer
+//	GET    /v1/transfers         all transfers
+//	POST   /v1/transfers         send a file {"peer_id", "path"}
+//	GET    /v1/transfers/{id}    one transfer
+//	GET    /v1/stats             counters
+//	GET    /v1/peers             the peerstore
+//	POST   /v1/peers             add {"uri"} to the peerstore
+//	DELETE /v1/peers/{peer}      remove a peer from the peerstore
+//
+// Errors are returned as {"error": "..."} with a 4xx or 5xx status.
+
+type infoResponse struct {
+	PeerID     string `json:"peer_id"`
+	ListenAddr string `json:"listen_addr"`
+}
+
+type sessionInfo struct {
+	PeerID        string            `json:"peer_id"`
+	RemoteAddr    string            `json:"remote_addr"`
+	Capabilities  map[string]string `json:"capabilities"`
+	ActiveStreams int               `json:"active_streams"`
+}
+
+type dialRequest struct {
+	PeerID string `json:"peer_id,omitempty"`
+	URI    string `json:"uri,omitempty"`
+	Addr   string `json:"addr,omitempty"`
+}
+
+type sendRequest struct {
+	PeerID string `json:"peer_id"`
+	Path   string `json:"path"`
+}
+
+type peerInfo struct {
+	PeerID string `json:"peer_id"`
+	URI    string `json:"uri"`
+}
+
+type statsResponse struct {
+	Sessions  int            `json:"sessions"`
+	Peers     int            `json:"peers"`
+	Transfers map[string]int `json:"transfers"` // by state
+	BytesSent int64          `json:"bytes_sent"`
+	BytesRecv int64          `json:"bytes_received"`
+}
+
+func (d *Daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/info", d.handleInfo)
+	mux.HandleFunc("GET /v1/sessions", d.handleSessions)
+	mux.HandleFunc("POST /v1/sessions", d.handleDial)
+	mux.HandleFunc("DELETE /v1/sessions/{peer}", d.handleDisconnect)
+	mux.HandleFunc("GET /v1/transfers", d.handleTransfers)
+	mux.HandleFunc("POST /v1/transfers", d.handleSend)
+	mux.HandleFunc("GET /v1/transfers/{id}", d.handleTransfer)
+	mux.HandleFunc("GET /v1/stats", d.handleStats)
+	mux.HandleFunc("GET /v1/peers", d.handlePeers)
+	mux.HandleFunc("POST /v1/peers", d.handleAddPeer)
+	mux.HandleFunc("DELETE /v1/peers/{peer}", d.handleRemovePeer)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func peerParam(w http.ResponseWriter, s string) (identity.PeerID, bool) {
+	id, err := identity.ParsePeerIDHex(s)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return identity.PeerID{}, false
+	}
+	return id, true
+}
+
+func describe(s *session.Session) sessionInfo {
+	return sessionInfo{
+		PeerID:        s.RemotePeerID().String(),
+		RemoteAddr:    s.Connection().RemoteAddr().String(),
+		Capabilities:  s.RemoteCapabilities(),
+		ActiveStreams: s.ActiveStreams(),
+	}
+}
+
+func (d *Daemon) handleInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, infoResponse{
+		PeerID:     d.peer.Config().KeyPair.PeerID().String(),
+		ListenAddr: d.peer.ListenAddr(),
+	})
+}
+
+func (d *Daemon) handleSessions(w http.ResponseWriter, r *http.Request) {
+	out := []sessionInfo{}
+	for _, s := range d.peer.Sessions() {
+		out = append(out, describe(s))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (d *Daemon) handleDial(w http.ResponseWriter, r *http.Request) {
+	var req dialRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	var (
+		s   *session.Session
+		err error
+	)
+	switch {
+	case req.PeerID != "":
+		id, ok := peerParam(w, req.PeerID)
+		if !ok {
+			return
+		}
+		s, err = d.connectPeer(r.Context(), id)
+	case req.URI != "":
+		var info discovery.AddrInfo
+		if info, err = discovery.ParseURI(req.URI); err == nil {
+			s, err = d.connect(r.Context(), info)
+		}
+	case req.Addr != "":
+		if s, err = d.dial(r.Context(), req.Addr); err == nil {
+			go d.serveSession(s)
+		}
+	default:
+		err = errors.New("one of peer_id, uri or addr is required")
+	}
+	switch {
+	case errors.Is(err, errUnknownPeer):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+	default:
+		writeJSON(w, http.StatusOK, describe(s))
+	}
+}
+
+func (d *Daemon) handleDisconnect(w http.ResponseWriter, r *http.Request) {
+	id, ok := peerParam(w, r.PathValue("peer"))
+	if !ok {
+		return
+	}
+	if err := d.disconnect(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *Daemon) handleTransfers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, d.Transfers())
+}
+
+func (d *Daemon) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	t, ok := d.Transfer(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no such transfer"))
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (d *Daemon) handleSend(w http.ResponseWriter, r *http.Request) {
+	var req sendRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	id, ok := peerParam(w, req.PeerID)
+	if !ok {
+		return
+	}
+	s, err := d.connectPeer(r.Context(), id)
+	if errors.Is(err, errUnknownPeer) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	t, err := d.startSend(s, req.Path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, t)
+}
+
+func (d *Daemon) handleStats(w http.ResponseWriter, r *http.Request) {
+	st := statsResponse{
+		Sessions:  len(d.peer.Sessions()),
+		Peers:     len(d.store.List()),
+		Transfers: map[string]int{},
+	}
+	for _, t := range d.Transfers() {
+		st.Transfers[t.State]++
+		if t.State != "done" {
+			continue
+		}
+		if t.Direction == "send" {
+			st.BytesSent += t.Size
+		} else {
+			st.BytesRecv += t.Size
+		}
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (d *Daemon) handlePeers(w http.ResponseWriter, r *http.Request) {
+	out := []peerInfo{}
+	for _, info := range d.store.List() {
+		out = append(out, peerInfo{PeerID: info.PeerID.String(), URI: discovery.FormatURI(info)})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (d *Daemon) handleAddPeer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URI string `json:"uri"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	info, err := discovery.ParseURI(req.URI)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := d.store.Add(info); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, peerInfo{PeerID: info.PeerID.String(), URI: discovery.FormatURI(info)})
+}
+
+func (d *Daemon) handleRemovePeer(w http.ResponseWriter, r *http.Request) {
+	id, ok := peerParam(w, r.PathValue("peer"))
+	if !ok {
+		return
+	}
+	found, err := d.store.Remove(id)
+	switch {
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	case !found:
+		writeError(w, http.StatusNotFound, errUnknownPeer)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
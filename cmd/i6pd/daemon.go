@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/discovery"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+)
+
+var (
+	errNoSession    = errors.New("no session with peer")
+	errUnknownPeer  = errors.New("peer is not in the peerstore")
+	errInboxMissing = errors.New("daemon has no inbox; incoming transfers are refused")
+	errBadFileName  = errors.New("invalid file name")
+)
+
+// maxHeader bounds the JSON header of an incoming file transfer.
+const maxHeader = 16 << 20
+
+// fileHeader precedes the batches of a file sent between daemons. It is
+// length-prefixed so the batches that follow are not consumed by the decoder.
+type fileHeader struct {
+	Name     string             `json:"name"`
+	Manifest *transfer.Manifest `json:"manifest"`
+}
+
+// fileAck is the receiver's reply once the file is complete or has failed.
+type fileAck struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Transfer is the state of one file transfer, as reported by the control API.
+type Transfer struct {
+	ID         string    `json:"id"`
+	Direction  string    `json:"direction"` // "send" or "recv"
+	PeerID     string    `json:"peer_id"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Chunks     int       `json:"chunks"`
+	ChunksDone int       `json:"chunks_done"`
+	State      string    `json:"state"` // "running", "done" or "failed"
+	Error      string    `json:"error,omitempty"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished,omitzero"`
+}
+
+// Daemon runs a Peer on behalf of local clients of the control API.
+type Daemon struct {
+	peer  *i6p.Peer
+	store *peerStore
+	inbox string // directory for received files; empty refuses transfers
+
+	// dial connects to addr; it is Peer.Dial outside of tests.
+	dial func(ctx context.Context, addr string) (*session.Session, error)
+
+	mu        sync.Mutex
+	transfers map[string]*Transfer
+	nextID    int
+}
+
+func newDaemon(peer *i6p.Peer, store *peerStore, inbox string) *Daemon {
+	return &Daemon{
+		peer:      peer,
+		store:     store,
+		inbox:     inbox,
+		dial:      peer.Dial,
+		transfers: make(map[string]*Transfer),
+	}
+}
+
+// acceptLoop serves incoming sessions until the peer stops listening.
+func (d *Daemon) acceptLoop(ctx context.Context) {
+	for {
+		s, err := d.peer.Accept(ctx)
+		if err != nil {
+			return
+		}
+		go d.serveSession(s)
+	}
+}
+
+// serveSession handles streams opened by the remote side of s.
+func (d *Daemon) serveSession(s *session.Session) {
+	ctx := s.Connection().Context()
+	for {
+		st, err := s.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		go d.receiveFile(s, st)
+	}
+}
+
+// session returns an open session with id.
+func (d *Daemon) session(id identity.PeerID) (*session.Session, bool) {
+	for _, s := range d.peer.Sessions() {
+		if s.RemotePeerID() == id {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// connect dials info unless a session with it is already open.
+func (d *Daemon) connect(ctx context.Context, info discovery.AddrInfo) (*session.Session, error) {
+	if s, ok := d.session(info.PeerID); ok {
+		return s, nil
+	}
+	addr, err := info.DialAddr()
+	if err != nil {
+		return nil, err
+	}
+	s, err := d.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.RemotePeerID() != info.PeerID {
+		_ = s.CloseWithError(0, "peer id mismatch")
+		return nil, i6p.ErrPeerIDMismatch
+	}
+	go d.serveSession(s)
+	return s, nil
+}
+
+// connectPeer connects to a peer from the peerstore.
+func (d *Daemon) connectPeer(ctx context.Context, id identity.PeerID) (*session.Session, error) {
+	if s, ok := d.session(id); ok {
+		return s, nil
+	}
+	info, ok := d.store.Get(id)
+	if !ok {
+		return nil, errUnknownPeer
+	}
+	return d.connect(ctx, info)
+}
+
+// disconnect closes every session with id.
+func (d *Daemon) disconnect(id identity.PeerID) error {
+	found := false
+	for _, s := range d.peer.Sessions() {
+		if s.RemotePeerID() == id {
+			_ = s.CloseWithError(0, "disconnected")
+			found = true
+		}
+	}
+	if !found {
+		return errNoSession
+	}
+	return nil
+}
+
+func (d *Daemon) newTransfer(dir string, peer identity.PeerID, name string) *Transfer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	t := &Transfer{
+		ID:        strconv.Itoa(d.nextID),
+		Direction: dir,
+		PeerID:    peer.String(),
+		Name:      name,
+		State:     "running",
+		Started:   time.Now(),
+	}
+	d.transfers[t.ID] = t
+	return t
+}
+
+// update changes a transfer under the daemon lock.
+func (d *Daemon) update(t *Transfer, fn func(t *Transfer)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(t)
+}
+
+func (d *Daemon) finish(t *Transfer, err error) {
+	d.update(t, func(t *Transfer) {
+		t.Finished = time.Now()
+		if err != nil {
+			t.State, t.Error = "failed", err.Error()
+			return
+		}
+		t.State = "done"
+	})
+}
+
+// Transfers returns a snapshot of all transfers, oldest first.
+func (d *Daemon) Transfers() []Transfer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]Transfer, 0, len(d.transfers))
+	for i := 1; i <= d.nextID; i++ {
+		if t, ok := d.transfers[strconv.Itoa(i)]; ok {
+			out = append(out, *t)
+		}
+	}
+	return out
+}
+
+// Transfer returns a snapshot of one transfer.
+func (d *Daemon) Transfer(id string) (Transfer, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.transfers[id]
+	if !ok {
+		return Transfer{}, false
+	}
+	return *t, true
+}
+
+// startSend reads path and sends it to the peer on s in the background.
+func (d *Daemon) startSend(s *session.Session, path string) (Transfer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Transfer{}, err
+	}
+	if len(data) == 0 {
+		return Transfer{}, errors.New("cannot send an empty file")
+	}
+	m, err := transfer.BuildManifest(data, transfer.DefaultChunkSize)
+	if err != nil {
+		return Transfer{}, err
+	}
+	t := d.newTransfer("send", s.RemotePeerID(), filepath.Base(path))
+	d.update(t, func(t *Transfer) { t.Size, t.Chunks = m.Size, m.NumChunks() })
+	go func() {
+		d.finish(t, d.sendFile(s, t, data, m))
+	}()
+	return *t, nil
+}
+
+func (d *Daemon) sendFile(s *session.Session, t *Transfer, data []byte, m *transfer.Manifest) error {
+	ctx := s.Connection().Context()
+	st, err := s.OpenStream(ctx)
+	if err != nil {
+		return err
+	}
+	hdr, err := json.Marshal(fileHeader{Name: t.Name, Manifest: m})
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(st, binary.BigEndian, uint32(len(hdr))); err != nil {
+		return err
+	}
+	if _, err := st.Write(hdr); err != nil {
+		return err
+	}
+	for i, c := range transfer.NewChunker(m.ChunkSize).Split(data) {
+		batch := transfer.NewBatch()
+		batch.Add(transfer.CompressChunk(c, transfer.CompressionFast))
+		if err := transfer.WriteBatch(st, batch); err != nil {
+			return err
+		}
+		d.update(t, func(t *Transfer) { t.ChunksDone = i + 1 })
+	}
+	if err := st.Close(); err != nil {
+		return err
+	}
+
+	var ack fileAck
+	if err := json.NewDecoder(st).Decode(&ack); err != nil {
+		return fmt.Errorf("waiting for receiver: %w", err)
+	}
+	if ack.Error != "" {
+		return fmt.Errorf("receiver: %s", ack.Error)
+	}
+	return nil
+}
+
+// receiveFile handles a file sent on st by the peer on s.
+func (d *Daemon) receiveFile(s *session.Session, st io.ReadWriteCloser) {
+	defer st.Close()
+	err := d.readFile(s, st)
+	ack := fileAck{}
+	if err != nil {
+		ack.Error = err.Error()
+		log.Printf("transfer from %s failed: %v", s.RemotePeerID(), err)
+	}
+	_ = json.NewEncoder(st).Encode(ack)
+	if err != nil {
+		// The sender reads the ack only after writing everything.
+		_, _ = io.Copy(io.Discard, st)
+	}
+}
+
+func (d *Daemon) readFile(s *session.Session, st io.Reader) error {
+	if d.inbox == "" {
+		return errInboxMissing
+	}
+	var n uint32
+	if err := binary.Read(st, binary.BigEndian, &n); err != nil {
+		return err
+	}
+	if n > maxHeader {
+		return errors.New("transfer header too large")
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(st, b); err != nil {
+		return err
+	}
+	var h fileHeader
+	if err := json.Unmarshal(b, &h); err != nil {
+		return err
+	}
+	if h.Manifest == nil {
+		return transfer.ErrManifestInvalid
+	}
+	name := filepath.Base(h.Name)
+	if name != h.Name || name == "." || name == ".." || strings.HasPrefix(name, ".") {
+		return errBadFileName
+	}
+
+	t := d.newTransfer("recv", s.RemotePeerID(), name)
+	d.update(t, func(t *Transfer) { t.Size, t.Chunks = h.Manifest.Size, h.Manifest.NumChunks() })
+	err := d.readChunks(st, t, h.Manifest, name)
+	d.finish(t, err)
+	return err
+}
+
+func (d *Daemon) readChunks(st io.Reader, t *Transfer, m *transfer.Manifest, name string) error {
+	fr, err := transfer.NewFileReceiver(m, filepath.Join(d.inbox, "."+name+".part"))
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+	for {
+		batch, err := transfer.ReadBatch(st)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := fr.ReceiveBatch(batch); err != nil {
+			return err
+		}
+		done := int(fr.Stats().ChunksReceived.Load())
+		d.update(t, func(t *Transfer) { t.ChunksDone = done })
+	}
+	return fr.Finalize(filepath.Join(d.inbox, name))
+}
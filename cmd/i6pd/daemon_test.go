@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/discovery"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+// startDaemon runs a daemon listening at addr on network.
+func startDaemon(t *testing.T, ctx context.Context, network *memory.Network, addr, inbox string) (*Daemon, identity.KeyPair, *httptest.Server) {
+	t.Helper()
+	kp, _ := identity.GenerateKeyPair()
+	peer := i6p.NewPeer(kp, nil)
+	ln, err := network.Listen(addr)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	peer.Serve(ln)
+	store, _ := openPeerStore(filepath.Join(t.TempDir(), "peers.json"))
+	d := newDaemon(peer, store, inbox)
+	d.dial = func(ctx context.Context, addr string) (*session.Session, error) {
+		conn, err := network.Dial(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		return peer.Connect(ctx, conn)
+	}
+	go d.acceptLoop(ctx)
+	srv := httptest.NewServer(d.handler())
+	t.Cleanup(srv.Close)
+	return d, kp, srv
+}
+
+func call(t *testing.T, srv *httptest.Server, method, path string, body, out any) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, srv.URL+path, &buf)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		_ = json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+func TestDaemonSendFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	network := memory.NewNetwork()
+	inbox := t.TempDir()
+	_, _, alice := startDaemon(t, ctx, network, "[2001:db8::1]:4433", "")
+	_, bobKP, _ := startDaemon(t, ctx, network, "[2001:db8::2]:4433", inbox)
+
+	bobURI := discovery.FormatURI(discovery.AddrInfo{
+		PeerID: bobKP.PeerID(),
+		Addr:   netip.MustParseAddr("2001:db8::2"),
+		Port:   4433,
+	})
+	if code := call(t, alice, "POST", "/v1/peers", map[string]string{"uri": bobURI}, nil); code != http.StatusOK {
+		t.Fatalf("add peer: %d", code)
+	}
+	var peers []peerInfo
+	call(t, alice, "GET", "/v1/peers", nil, &peers)
+	if len(peers) != 1 || peers[0].URI != bobURI {
+		t.Fatalf("unexpected peerstore %+v", peers)
+	}
+
+	bob := bobKP.PeerID().String()
+	var sess sessionInfo
+	if code := call(t, alice, "POST", "/v1/sessions", dialRequest{PeerID: bob}, &sess); code != http.StatusOK || sess.PeerID != bob {
+		t.Fatalf("dial: %d %+v", code, sess)
+	}
+
+	data := make([]byte, 600<<10)
+	_, _ = rand.Read(data)
+	src := filepath.Join(t.TempDir(), "payload.bin")
+	_ = os.WriteFile(src, data, 0o600)
+	var tr Transfer
+	if code := call(t, alice, "POST", "/v1/transfers", sendRequest{PeerID: bob, Path: src}, &tr); code != http.StatusAccepted {
+		t.Fatalf("send: %d", code)
+	}
+	for tr.State == "running" {
+		time.Sleep(10 * time.Millisecond)
+		call(t, alice, "GET", "/v1/transfers/"+tr.ID, nil, &tr)
+	}
+	if tr.State != "done" || tr.ChunksDone != tr.Chunks || tr.Chunks != 3 {
+		t.Fatalf("transfer ended as %+v", tr)
+	}
+	got, err := os.ReadFile(filepath.Join(inbox, "payload.bin"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("received file mismatch: %v", err)
+	}
+
+	var stats statsResponse
+	call(t, alice, "GET", "/v1/stats", nil, &stats)
+	if stats.Sessions != 1 || stats.BytesSent != int64(len(data)) || stats.Transfers["done"] != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if code := call(t, alice, "DELETE", "/v1/sessions/"+bob, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("disconnect: %d", code)
+	}
+	if code := call(t, alice, "DELETE", "/v1/peers/"+bob, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("remove peer: %d", code)
+	}
+	if code := call(t, alice, "POST", "/v1/sessions", dialRequest{PeerID: bob}, nil); code != http.StatusNotFound {
+		t.Fatalf("dial of removed peer: %d", code)
+	}
+}
+
+func TestDaemonRefusesWithoutInbox(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	network := memory.NewNetwork()
+	_, _, alice := startDaemon(t, ctx, network, "[2001:db8::1]:4433", "")
+	_, bobKP, _ := startDaemon(t, ctx, network, "[2001:db8::2]:4433", "")
+	uri := discovery.FormatURI(discovery.AddrInfo{PeerID: bobKP.PeerID(), Addr: netip.MustParseAddr("2001:db8::2"), Port: 4433})
+	call(t, alice, "POST", "/v1/sessions", dialRequest{URI: uri}, nil)
+
+	src := filepath.Join(t.TempDir(), "f")
+	_ = os.WriteFile(src, []byte("x"), 0o600)
+	var tr Transfer
+	// The session is open, so the peer does not need to be in the peerstore.
+	if code := call(t, alice, "POST", "/v1/transfers", sendRequest{PeerID: bobKP.PeerID().String(), Path: src}, &tr); code != http.StatusAccepted {
+		t.Fatalf("send: %d", code)
+	}
+	for tr.State == "running" {
+		time.Sleep(10 * time.Millisecond)
+		call(t, alice, "GET", "/v1/transfers/"+tr.ID, nil, &tr)
+	}
+	if tr.State != "failed" || tr.Error == "" {
+		t.Fatalf("expected refused transfer, got %+v", tr)
+	}
+}
+
+func TestLoadIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	a, err := loadIdentity(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	b, err := loadIdentity(path)
+	if err != nil || a.PeerID() != b.PeerID() {
+		t.Fatalf("identity not persisted: %v", err)
+	}
+}
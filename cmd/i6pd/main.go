@@ -0,0 +1,112 @@
+// Command i6pd runs an I6P peer as a daemon controlled over a local socket.
+//
+// The control API is JSON over HTTP on a Unix socket, so any language (or
+// curl --unix-socket) can list sessions, dial and disconnect peers, send
+// files, read stats and manage the peerstore. See api.go for the endpoints.
+//
+//	i6pd -listen [::]:4433 -key ~/.i6p/key -peers ~/.i6p/peers.json -inbox ~/Downloads
+//	curl --unix-socket /tmp/i6pd.sock localhost/v1/sessions
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+func main() {
+	var (
+		listen  = flag.String("listen", "[::]:4433", "QUIC listen address")
+		control = flag.String("control", filepath.Join(os.TempDir(), "i6pd.sock"), "control API Unix socket")
+		keyPath = flag.String("key", "", "identity file, created if missing (empty: ephemeral identity)")
+		peers   = flag.String("peers", "", "peerstore file (empty: in memory)")
+		inbox   = flag.String("inbox", "", "directory for received files (empty: refuse transfers)")
+	)
+	flag.Parse()
+
+	kp, err := loadIdentity(*keyPath)
+	if err != nil {
+		log.Fatalf("identity: %v", err)
+	}
+	store, err := openPeerStore(*peers)
+	if err != nil {
+		log.Fatalf("peerstore: %v", err)
+	}
+	peer := i6p.NewPeer(kp, map[string]string{"agent": "i6pd"})
+	if err := peer.Listen(*listen); err != nil {
+		log.Fatalf("listen: %v", err)
+	}
+	d := newDaemon(peer, store, *inbox)
+
+	_ = os.Remove(*control)
+	ln, err := net.Listen("unix", *control)
+	if err != nil {
+		log.Fatalf("control socket: %v", err)
+	}
+	if err := os.Chmod(*control, 0o600); err != nil {
+		log.Fatalf("control socket: %v", err)
+	}
+	srv := &http.Server{Handler: d.handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go d.acceptLoop(ctx)
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("control API: %v", err)
+		}
+	}()
+	log.Printf("peer %s listening on %s, control API on %s", kp.PeerID(), peer.ListenAddr(), *control)
+
+	<-ctx.Done()
+	log.Printf("shutting down")
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdown)
+	if err := peer.Drain(shutdown); err != nil {
+		log.Printf("drain: %v", err)
+	}
+	_ = os.Remove(*control)
+}
+
+// loadIdentity reads a hex Ed25519 seed from path, creating the file with a
+// new identity if it does not exist.
+func loadIdentity(path string) (identity.KeyPair, error) {
+	if path == "" {
+		return identity.GenerateKeyPair()
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return identity.KeyPair{}, err
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(seed)+"\n"), 0o600); err != nil {
+			return identity.KeyPair{}, err
+		}
+		b = []byte(hex.EncodeToString(seed))
+	} else if err != nil {
+		return identity.KeyPair{}, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return identity.KeyPair{}, errors.New("identity file must hold a hex Ed25519 seed")
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	return identity.NewKeyPair(priv.Public().(ed25519.PublicKey), priv)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/TheusHen/I6P/i6p/discovery"
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// peerStore remembers known peers, persisted as a JSON list of i6p:// URIs.
+type peerStore struct {
+	mu    sync.Mutex
+	path  string // empty keeps the store in memory only
+	peers map[identity.PeerID]discovery.AddrInfo
+}
+
+func openPeerStore(path string) (*peerStore, error) {
+	ps := &peerStore{path: path, peers: make(map[identity.PeerID]discovery.AddrInfo)}
+	if path == "" {
+		return ps, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var uris []string
+	if err := json.Unmarshal(b, &uris); err != nil {
+		return nil, err
+	}
+	for _, u := range uris {
+		info, err := discovery.ParseURI(u)
+		if err != nil {
+			return nil, err
+		}
+		ps.peers[info.PeerID] = info
+	}
+	return ps, nil
+}
+
+func (ps *peerStore) Add(info discovery.AddrInfo) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.peers[info.PeerID] = info
+	return ps.saveLocked()
+}
+
+func (ps *peerStore) Remove(id identity.PeerID) (bool, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if _, ok := ps.peers[id]; !ok {
+		return false, nil
+	}
+	delete(ps.peers, id)
+	return true, ps.saveLocked()
+}
+
+func (ps *peerStore) Get(id identity.PeerID) (discovery.AddrInfo, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	info, ok := ps.peers[id]
+	return info, ok
+}
+
+func (ps *peerStore) List() []discovery.AddrInfo {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	out := make([]discovery.AddrInfo, 0, len(ps.peers))
+	for _, info := range ps.peers {
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PeerID.String() < out[j].PeerID.String() })
+	return out
+}
+
+// saveLocked writes the store through a temp file so a crash never leaves it
+// half written.
+func (ps *peerStore) saveLocked() error {
+	if ps.path == "" {
+		return nil
+	}
+	uris := make([]string, 0, len(ps.peers))
+	for _, info := range ps.peers {
+		uris = append(uris, discovery.FormatURI(info))
+	}
+	sort.Strings(uris)
+	b, err := json.MarshalIndent(uris, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ps.path), ".peers-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), ps.path)
+}
@@ -3,18 +3,22 @@ module github.com/TheusHen/I6P
 go 1.24
 
 require (
+	filippo.io/edwards25519 v1.1.0
 	github.com/klauspost/reedsolomon v1.12.4
 	github.com/pierrec/lz4/v4 v4.1.21
 	github.com/quic-go/quic-go v0.54.1
+	github.com/quic-go/webtransport-go v0.9.0
 	golang.org/x/crypto v0.31.0
 )
 
 require (
 	github.com/klauspost/cpuid/v2 v2.2.8 // indirect
+	github.com/quic-go/qpack v0.5.1 // indirect
 	go.uber.org/mock v0.5.0 // indirect
 	golang.org/x/mod v0.18.0 // indirect
 	golang.org/x/net v0.28.0 // indirect
-	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 	golang.org/x/tools v0.22.0 // indirect
 )
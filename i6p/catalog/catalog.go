@@ -0,0 +1,245 @@
+// Package catalog lets a peer list the objects it offers, so clients can
+// browse them before fetching one with package fetch.
+//
+// The peer keeps its items in a Catalog. Every version of the catalog is
+// summed up by a Head, signed by the peer's key, that commits to all items
+// through a Merkle tree. Listings are returned in pages: each item comes
+// with its proof against the head, so a page, even one filtered by tag, is
+// checked against the peer's signature, and a client can tell whether the
+// catalog changed while it paged through it. A head with its pages can also
+// be handed on and verified by anyone who knows the publisher's PeerID.
+//
+// One request travels per stream, tagged ProtocolName on sessions that
+// negotiated stream protocols:
+//
+//	head: client -> request;                     peer -> response with the head
+//	list: client -> request with cursor and tag; peer -> response with a page
+package catalog
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/transfer"
+)
+
+// ProtocolName tags catalog streams (see session.OpenProtocolStream).
+const ProtocolName = "i6p/catalog/1"
+
+const (
+	// DefaultPage is the number of items a page holds when the request
+	// does not say.
+	DefaultPage = 64
+	// MaxPage bounds the items of one page.
+	MaxPage = 256
+	// MaxItems bounds the items of a catalog, and so the Size of a head.
+	MaxItems = 1 << 31
+)
+
+// maxMessage bounds one control message.
+const maxMessage = 4 << 20
+
+var (
+	ErrItem       = errors.New("catalog: invalid item")
+	ErrFull       = errors.New("catalog: too many items")
+	ErrSignature  = errors.New("catalog: invalid signature")
+	ErrProof      = errors.New("catalog: item not in the catalog")
+	ErrChanged    = errors.New("catalog: catalog changed while listing")
+	ErrMessage    = errors.New("catalog: malformed message")
+	ErrTooLarge   = errors.New("catalog: message too large")
+	ErrRemoteFail = errors.New("catalog: remote failed")
+)
+
+// Item describes one object a peer offers.
+type Item struct {
+	ID   []byte   `json:"id"` // the object's manifest root, as fetch names it
+	Name string   `json:"name"`
+	Size int64    `json:"size"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// NewItem returns the item offering the object described by m.
+func NewItem(m *transfer.Manifest, name string, tags ...string) Item {
+	return Item{ID: m.Root, Name: name, Size: m.Size, Tags: tags}
+}
+
+// HasTag reports whether it is tagged tag.
+func (it Item) HasTag(tag string) bool {
+	for _, t := range it.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (it Item) validate() error {
+	if len(it.ID) != 32 || it.Size < 0 || len(it.Name) > 1<<10 || len(it.Tags) > 64 {
+		return ErrItem
+	}
+	for _, t := range it.Tags {
+		if t == "" || len(t) > 1<<8 {
+			return ErrItem
+		}
+	}
+	return nil
+}
+
+// leafHash returns the hash of it as a leaf of the catalog tree.
+func (it Item) leafHash() []byte {
+	b := append([]byte(nil), it.ID...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(it.Name)))
+	b = append(b, it.Name...)
+	b = binary.BigEndian.AppendUint64(b, uint64(it.Size))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(it.Tags)))
+	for _, t := range it.Tags {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(t)))
+		b = append(b, t...)
+	}
+	return transfer.HashChunk(b)
+}
+
+// Head is the signed summary of one version of a catalog: Size items,
+// ordered by ID, with Merkle root Root.
+type Head struct {
+	Publisher ed25519.PublicKey `json:"publisher"`
+	Version   uint64            `json:"version"`
+	Size      uint64            `json:"size"`
+	Root      []byte            `json:"root"`
+	Time      time.Time         `json:"time"`
+	Signature []byte            `json:"signature"`
+}
+
+// SigningBytes returns the bytes the publisher signs for h.
+func (h Head) SigningBytes() []byte {
+	b := append([]byte(nil), h.Publisher...)
+	b = binary.BigEndian.AppendUint64(b, h.Version)
+	b = binary.BigEndian.AppendUint64(b, h.Size)
+	b = append(b, h.Root...)
+	return binary.BigEndian.AppendUint64(b, uint64(h.Time.UnixNano()))
+}
+
+// Verify checks that h is signed by the peer publisher.
+func (h Head) Verify(publisher identity.PeerID) error {
+	if h.Size > MaxItems {
+		return fmt.Errorf("%w: %d items", ErrMessage, h.Size)
+	}
+	if len(h.Publisher) != ed25519.PublicKeySize || identity.PeerIDFromPublicKey(h.Publisher) != publisher {
+		return fmt.Errorf("%w: not signed by %s", ErrSignature, publisher)
+	}
+	if len(h.Root) != 32 || !identity.VerifyContext(h.Publisher, identity.ContextCatalog, h.SigningBytes(), h.Signature) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Entry is an item with its Merkle proof as leaf Index of a head's tree.
+type Entry struct {
+	Item     Item     `json:"item"`
+	Index    int      `json:"index"`
+	Siblings [][]byte `json:"siblings,omitempty"`
+	IsLeft   []bool   `json:"is_left,omitempty"`
+}
+
+// Page is one page of a listing. Next is the cursor of the following page,
+// empty on the last one.
+type Page struct {
+	Head    Head    `json:"head"`
+	Entries []Entry `json:"entries"`
+	Next    []byte  `json:"next,omitempty"`
+}
+
+// Verify checks the head signature and that every entry is in the catalog
+// it commits to, in ID order.
+func (p Page) Verify(publisher identity.PeerID) error {
+	if err := p.Head.Verify(publisher); err != nil {
+		return err
+	}
+	var prev []byte
+	for _, e := range p.Entries {
+		if err := e.Item.validate(); err != nil {
+			return err
+		}
+		if prev != nil && bytes.Compare(prev, e.Item.ID) >= 0 {
+			return fmt.Errorf("%w: out of order", ErrMessage)
+		}
+		prev = e.Item.ID
+		proof := transfer.Proof{ChunkIndex: e.Index, ChunkHash: e.Item.leafHash(), Siblings: e.Siblings, IsLeft: e.IsLeft}
+		if e.Index < 0 || uint64(e.Index) >= p.Head.Size ||
+			transfer.VerifyProofAt(proof, p.Head.Root, int(p.Head.Size)) != nil {
+			return fmt.Errorf("%w: %x", ErrProof, e.Item.ID)
+		}
+	}
+	return nil
+}
+
+// Items returns the items of p.
+func (p Page) Items() []Item {
+	items := make([]Item, len(p.Entries))
+	for i, e := range p.Entries {
+		items[i] = e.Item
+	}
+	return items
+}
+
+const (
+	opHead = "head"
+	opList = "list"
+)
+
+type request struct {
+	Op    string `json:"op"`
+	After []byte `json:"after,omitempty"` // list: cursor
+	Limit int    `json:"limit,omitempty"` // list
+	Tag   string `json:"tag,omitempty"`   // list: only items with this tag
+}
+
+type response struct {
+	Error string `json:"error,omitempty"`
+	Head  *Head  `json:"head,omitempty"` // head
+	Page  *Page  `json:"page,omitempty"` // list
+}
+
+// writeMsg sends v as length-prefixed JSON.
+func writeMsg(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMessage {
+		return ErrTooLarge
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readMsg(r io.Reader, v any) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxMessage {
+		return ErrTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMessage, err)
+	}
+	return nil
+}
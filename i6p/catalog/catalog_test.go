@@ -0,0 +1,142 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+func testItem(i int) Item {
+	id := sha256.Sum256([]byte(fmt.Sprint(i)))
+	it := Item{ID: id[:], Name: fmt.Sprintf("object-%d", i), Size: int64(i) * 1000}
+	if i%3 == 0 {
+		it.Tags = []string{"video"}
+	}
+	return it
+}
+
+func TestBrowseCatalog(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	caps := map[string]string{session.StreamProtocolCapability: "1"}
+	network := memory.NewNetwork()
+	aliceKP, _ := identity.GenerateKeyPair()
+	bobKP, _ := identity.GenerateKeyPair()
+	cat := NewCatalog(bobKP)
+	for i := 0; i < 600; i++ {
+		if err := cat.Add(testItem(i)); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	bob := i6p.NewPeer(bobKP, caps)
+	ln, _ := network.Listen("bob")
+	bob.Serve(ln)
+	go func() {
+		s, err := bob.Accept(ctx)
+		if err == nil {
+			_ = cat.Serve(ctx, s)
+		}
+	}()
+	conn, _ := network.Dial(ctx, "bob")
+	sess, err := i6p.NewPeer(aliceKP, caps).Connect(ctx, conn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	c := NewClient(sess)
+
+	items, head, err := c.All(ctx, "")
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	if len(items) != 600 || head.Size != 600 || head.Version != cat.Version() {
+		t.Fatalf("listed %d items, head %+v", len(items), head)
+	}
+	for i := 1; i < len(items); i++ {
+		if bytes.Compare(items[i-1].ID, items[i].ID) >= 0 {
+			t.Fatal("items out of order")
+		}
+	}
+	videos, _, err := c.All(ctx, "video")
+	if err != nil || len(videos) != 200 {
+		t.Fatalf("tagged items: %d, %v", len(videos), err)
+	}
+
+	first, err := c.List(ctx, nil, 10, "")
+	if err != nil || len(first.Entries) != 10 || len(first.Next) == 0 {
+		t.Fatalf("List: %d entries, %v", len(first.Entries), err)
+	}
+	cat.Remove(first.Entries[0].Item.ID)
+	second, err := c.List(ctx, first.Next, 10, "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if second.Head.Version == first.Head.Version || second.Head.Size != 599 {
+		t.Fatalf("head after removal %+v", second.Head)
+	}
+
+	h, err := c.Head(ctx)
+	if err != nil || h.Version != second.Head.Version {
+		t.Fatalf("Head: %v", err)
+	}
+}
+
+func TestPageVerify(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	other, _ := identity.GenerateKeyPair()
+	publisher := identity.PeerIDFromPublicKey(kp.PublicKey)
+	cat := NewCatalog(kp)
+	data := []byte("published object")
+	m, _ := transfer.BuildManifest(data, 4)
+	if err := cat.Add(NewItem(m, "notes.txt", "text")); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		_ = cat.Add(testItem(i))
+	}
+	if err := cat.Add(Item{ID: []byte("short"), Name: "bad"}); !errors.Is(err, ErrItem) {
+		t.Fatalf("Add with a short ID: %v", err)
+	}
+
+	p := cat.List(nil, 5, "")
+	if err := p.Verify(publisher); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := p.Verify(identity.PeerIDFromPublicKey(other.PublicKey)); !errors.Is(err, ErrSignature) {
+		t.Fatalf("Verify for another peer: %v", err)
+	}
+	text := cat.List(nil, 0, "text")
+	if len(text.Entries) != 1 || !bytes.Equal(text.Entries[0].Item.ID, m.Root) || text.Verify(publisher) != nil {
+		t.Fatalf("tagged page %+v", text.Entries)
+	}
+
+	p.Entries[2].Item.Size++
+	if err := p.Verify(publisher); !errors.Is(err, ErrProof) {
+		t.Fatalf("Verify with an altered item: %v", err)
+	}
+	p.Entries[2].Item.Size--
+	p.Head.Size++
+	if err := p.Verify(publisher); !errors.Is(err, ErrSignature) {
+		t.Fatalf("Verify with an altered head: %v", err)
+	}
+
+	// A head claiming more items than a tree can hold is refused before
+	// any proof is checked, even when signed.
+	huge := cat.Head()
+	huge.Size = 1 << 63
+	huge.Signature, _ = kp.SignContext(identity.ContextCatalog, huge.SigningBytes())
+	p.Head = huge
+	if err := p.Verify(publisher); !errors.Is(err, ErrMessage) {
+		t.Fatalf("Verify with an oversized head: %v", err)
+	}
+}
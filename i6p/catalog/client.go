@@ -0,0 +1,111 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// Client browses the catalog of the peer at the other end of a session.
+// Everything it returns is verified against that peer's key.
+type Client struct {
+	s *session.Session
+}
+
+// NewClient creates a client for the catalog on s.
+func NewClient(s *session.Session) *Client {
+	return &Client{s: s}
+}
+
+// exchange sends req on a new stream and reads the response, bounded by ctx.
+func (c *Client) exchange(ctx context.Context, req request) (response, error) {
+	var resp response
+	st, err := c.s.OpenProtocolStream(ctx, ProtocolName)
+	if err != nil {
+		return resp, err
+	}
+	defer st.Close()
+	stop := context.AfterFunc(ctx, func() { _ = st.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	err = roundTrip(st, req, &resp)
+	if ctx.Err() != nil {
+		return resp, ctx.Err()
+	}
+	if err == nil && resp.Error != "" {
+		err = fmt.Errorf("%w: %s", ErrRemoteFail, resp.Error)
+	}
+	return resp, err
+}
+
+func roundTrip(st transport.Stream, req request, resp *response) error {
+	if err := writeMsg(st, req); err != nil {
+		return err
+	}
+	return readMsg(st, resp)
+}
+
+// Head returns the peer's current catalog head.
+func (c *Client) Head(ctx context.Context) (Head, error) {
+	resp, err := c.exchange(ctx, request{Op: opHead})
+	if err != nil {
+		return Head{}, err
+	}
+	if resp.Head == nil {
+		return Head{}, fmt.Errorf("%w: missing head", ErrMessage)
+	}
+	if err := resp.Head.Verify(c.s.RemotePeerID()); err != nil {
+		return Head{}, err
+	}
+	return *resp.Head, nil
+}
+
+// List returns the page of up to limit items after the cursor after (nil
+// for the first page), only those tagged tag if it is not empty. Pass the
+// page's Next as after to continue.
+func (c *Client) List(ctx context.Context, after []byte, limit int, tag string) (Page, error) {
+	resp, err := c.exchange(ctx, request{Op: opList, After: after, Limit: limit, Tag: tag})
+	if err != nil {
+		return Page{}, err
+	}
+	if resp.Page == nil {
+		return Page{}, fmt.Errorf("%w: missing page", ErrMessage)
+	}
+	p := *resp.Page
+	if err := p.Verify(c.s.RemotePeerID()); err != nil {
+		return Page{}, err
+	}
+	// The page must move past the cursor, or paging would not end.
+	if len(p.Entries) > 0 && after != nil && bytes.Compare(p.Entries[0].Item.ID, after) <= 0 ||
+		len(p.Next) > 0 && bytes.Compare(p.Next, after) <= 0 {
+		return Page{}, fmt.Errorf("%w: page does not follow its cursor", ErrMessage)
+	}
+	return p, nil
+}
+
+// All pages through the whole catalog, or the items tagged tag, and
+// returns the items with the head they belong to. It fails with
+// ErrChanged if the catalog changed meanwhile; callers may retry.
+func (c *Client) All(ctx context.Context, tag string) ([]Item, Head, error) {
+	var items []Item
+	var head Head
+	var after []byte
+	for {
+		p, err := c.List(ctx, after, MaxPage, tag)
+		if err != nil {
+			return nil, Head{}, err
+		}
+		if after != nil && p.Head.Version != head.Version {
+			return nil, Head{}, ErrChanged
+		}
+		head = p.Head
+		items = append(items, p.Items()...)
+		if len(p.Next) == 0 {
+			return items, head, nil
+		}
+		after = p.Next
+	}
+}
@@ -0,0 +1,176 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// Catalog is the list of items a peer offers, signed with the peer's key.
+// It is safe for concurrent use.
+type Catalog struct {
+	kp identity.KeyPair
+
+	mu      sync.Mutex
+	items   []Item // ordered by ID
+	version uint64
+	tree    *transfer.MerkleTree // of items, nil until needed
+}
+
+// NewCatalog creates an empty catalog signed with kp, the peer's identity.
+func NewCatalog(kp identity.KeyPair) *Catalog {
+	return &Catalog{kp: kp}
+}
+
+// Add offers it, replacing any item with the same ID.
+func (c *Catalog) Add(it Item) error {
+	if err := it.validate(); err != nil {
+		return err
+	}
+	it.Tags = slices.Clone(it.Tags)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, found := c.search(it.ID)
+	switch {
+	case found:
+		c.items[i] = it
+	case uint64(len(c.items)) >= MaxItems:
+		return ErrFull
+	default:
+		c.items = slices.Insert(c.items, i, it)
+	}
+	c.changed()
+	return nil
+}
+
+// Remove withdraws the item with the given ID.
+func (c *Catalog) Remove(id []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if i, found := c.search(id); found {
+		c.items = slices.Delete(c.items, i, i+1)
+		c.changed()
+	}
+}
+
+// Version returns the catalog version, which every change increments.
+func (c *Catalog) Version() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+func (c *Catalog) search(id []byte) (int, bool) {
+	return slices.BinarySearchFunc(c.items, id, func(it Item, id []byte) int {
+		return bytes.Compare(it.ID, id)
+	})
+}
+
+func (c *Catalog) changed() {
+	c.version++
+	c.tree = nil
+}
+
+// Head returns the head of the current version, signed now.
+func (c *Catalog) Head() Head {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.headLocked()
+}
+
+func (c *Catalog) headLocked() Head {
+	root := transfer.HashChunk(nil)
+	if len(c.items) > 0 {
+		if c.tree == nil {
+			leaves := make([][]byte, len(c.items))
+			for i, it := range c.items {
+				leaves[i] = it.leafHash()
+			}
+			// Items are validated, so the tree cannot fail to build.
+			c.tree, _ = transfer.BuildMerkleTree(leaves)
+		}
+		root = c.tree.Root()
+	}
+	h := Head{
+		Publisher: c.kp.PublicKey,
+		Version:   c.version,
+		Size:      uint64(len(c.items)),
+		Root:      root,
+		Time:      time.Now(),
+	}
+	// SignContext fails only for an invalid context.
+	h.Signature, _ = c.kp.SignContext(identity.ContextCatalog, h.SigningBytes())
+	return h
+}
+
+// List returns the page of up to limit items (DefaultPage if limit <= 0,
+// at most MaxPage) with IDs after the cursor after, restricted to items
+// tagged tag if tag is not empty.
+func (c *Catalog) List(after []byte, limit int, tag string) Page {
+	if limit <= 0 {
+		limit = DefaultPage
+	}
+	limit = min(limit, MaxPage)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := Page{Head: c.headLocked(), Entries: []Entry{}}
+	i, found := c.search(after)
+	if found {
+		i++
+	}
+	for ; i < len(c.items); i++ {
+		it := c.items[i]
+		if tag != "" && !it.HasTag(tag) {
+			continue
+		}
+		if len(p.Entries) == limit {
+			p.Next = p.Entries[limit-1].Item.ID
+			break
+		}
+		proof, _ := c.tree.GenerateProof(i)
+		p.Entries = append(p.Entries, Entry{Item: it, Index: i, Siblings: proof.Siblings, IsLeft: proof.IsLeft})
+	}
+	return p
+}
+
+// Serve answers catalog requests on s until the session ends or ctx is done.
+// Streams of other protocols are left to the session's other handlers, and
+// errors go to its stream error hook.
+func (c *Catalog) Serve(ctx context.Context, s *session.Session) error {
+	return s.ServeProtocol(ctx, ProtocolName, func(st transport.Stream) error {
+		return c.Handle(st)
+	})
+}
+
+// Handle answers one request read from rw.
+func (c *Catalog) Handle(rw io.ReadWriter) (err error) {
+	defer i6perrors.Recover(&err, "catalog")
+	var req request
+	if err := readMsg(rw, &req); err != nil {
+		return err
+	}
+	var resp response
+	switch req.Op {
+	case opHead:
+		h := c.Head()
+		resp.Head = &h
+	case opList:
+		p := c.List(req.After, req.Limit, req.Tag)
+		resp.Page = &p
+	default:
+		err = fmt.Errorf("%w: unknown op %q", ErrMessage, req.Op)
+		_ = writeMsg(rw, response{Error: err.Error()})
+		return err
+	}
+	return writeMsg(rw, resp)
+}
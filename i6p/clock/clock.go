@@ -0,0 +1,204 @@
+// Package clock abstracts the passage of time, so expiry and timeout logic
+// can be tested without sleeping.
+//
+// Components that keep time take a Clock. The default is System, the real
+// clock; a nil Clock is treated the same way. Tests pass a Fake and move it
+// forward with Advance. Network deadlines on streams and connections always
+// follow the system clock.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and makes timers. Implementations must be safe for
+// concurrent use.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a time.Timer made by a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker made by a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// System is the Clock of the operating system.
+var System Clock = system{}
+
+type system struct{}
+
+func (system) Now() time.Time                   { return time.Now() }
+func (system) NewTimer(d time.Duration) Timer   { return systemTimer{time.NewTimer(d)} }
+func (system) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time        { return t.t.C }
+func (t systemTimer) Stop() bool                 { return t.t.Stop() }
+func (t systemTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+func (t systemTicker) Stop()               { t.t.Stop() }
+
+// Or returns c, or System if c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return System
+	}
+	return c
+}
+
+// Fake is a Clock that only moves when told to. Its timers and tickers fire
+// during Advance and Set; like the real ones, a ticker whose tick was not
+// received drops the ticks that follow.
+type Fake struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFake returns a fake clock reading now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(d, 0)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(d, d)}
+}
+
+func (f *Fake) add(d, period time.Duration) *fakeTimer {
+	t := &fakeTimer{f: f, c: make(chan time.Time, 1), period: period}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scheduleLocked(t, d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers and tickers due
+// on the way in order.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	now := f.now.Add(d)
+	f.mu.Unlock()
+	f.Set(now)
+}
+
+// Set moves the clock to now, firing the timers and tickers due by then.
+// Setting it back fires nothing.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		sort.SliceStable(f.timers, func(i, j int) bool { return f.timers[i].at.Before(f.timers[j].at) })
+		if len(f.timers) == 0 || f.timers[0].at.After(now) {
+			break
+		}
+		t := f.timers[0]
+		f.now = t.at
+		select {
+		case t.c <- t.at:
+		default:
+		}
+		f.removeLocked(t)
+		if t.period > 0 {
+			f.scheduleLocked(t, t.period)
+		}
+	}
+	f.now = now
+}
+
+// Waiters returns the number of pending timers and running tickers.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// BlockUntil waits until at least n timers and tickers are pending, so a
+// test can let a goroutine arm its timer before calling Advance.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.timers) < n {
+		f.cond.Wait()
+	}
+}
+
+func (f *Fake) scheduleLocked(t *fakeTimer, d time.Duration) {
+	t.at = f.now.Add(d)
+	t.active = true
+	f.timers = append(f.timers, t)
+	f.cond.Broadcast()
+}
+
+func (f *Fake) removeLocked(t *fakeTimer) bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i, other := range f.timers {
+		if other == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+type fakeTimer struct {
+	f      *Fake
+	c      chan time.Time
+	at     time.Time
+	period time.Duration
+	active bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	return t.f.removeLocked(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	active := t.f.removeLocked(t)
+	t.f.scheduleLocked(t, d)
+	return active
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
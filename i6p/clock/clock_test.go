@@ -0,0 +1,74 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeTimers(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	timer := f.NewTimer(time.Minute)
+	ticker := f.NewTicker(20 * time.Second)
+	if f.Waiters() != 2 {
+		t.Fatalf("Waiters = %d, want 2", f.Waiters())
+	}
+
+	f.Advance(30 * time.Second)
+	if got := <-ticker.C(); !got.Equal(start.Add(20 * time.Second)) {
+		t.Fatalf("tick at %v", got)
+	}
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	// Ticks not received are dropped, as with time.Ticker.
+	f.Advance(40 * time.Second)
+	if got := <-timer.C(); !got.Equal(start.Add(time.Minute)) {
+		t.Fatalf("timer fired at %v", got)
+	}
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Fatal("dropped tick delivered")
+	default:
+	}
+	if !f.Now().Equal(start.Add(70 * time.Second)) {
+		t.Fatalf("Now = %v", f.Now())
+	}
+
+	if timer.Stop() {
+		t.Fatal("Stop of a fired timer reported it active")
+	}
+	if timer.Reset(time.Second) {
+		t.Fatal("Reset of a fired timer reported it active")
+	}
+	ticker.Stop()
+	if f.Waiters() != 1 {
+		t.Fatalf("Waiters = %d after Stop, want 1", f.Waiters())
+	}
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	done := make(chan struct{})
+	go func() {
+		<-f.NewTimer(time.Hour).C()
+		close(done)
+	}()
+	f.BlockUntil(1)
+	f.Advance(time.Hour)
+	<-done
+}
+
+func TestOr(t *testing.T) {
+	if Or(nil) != System {
+		t.Fatal("Or(nil) is not System")
+	}
+	f := NewFake(time.Unix(0, 0))
+	if Or(f) != f {
+		t.Fatal("Or did not keep the clock")
+	}
+}
@@ -0,0 +1,146 @@
+package i6p
+
+import (
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/clock"
+	"github.com/TheusHen/I6P/i6p/discovery"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+)
+
+// DefaultHandshakeTimeout bounds handshakes run by a Peer, since Accept and
+// Dial are usually given long-lived contexts.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// WithHandshakeTimeout sets the handshake timeout of the peer's sessions.
+// A negative value disables it, leaving only the caller's context.
+func WithHandshakeTimeout(d time.Duration) PeerOption {
+	return func(p *Peer) {
+		p.handshakeTimeout = d
+	}
+}
+
+// WithLatencies makes the peer record dial round-trip times in l instead of
+// a store of its own, e.g. to share measurements between peers.
+func WithLatencies(l *discovery.Latencies) PeerOption {
+	return func(p *Peer) {
+		p.latencies = l
+	}
+}
+
+// WithClock sets the clock the peer's handshakes timestamp HELLOs and
+// refresh its cached HELLO by, so tests can control time.
+func WithClock(c clock.Clock) PeerOption {
+	return func(p *Peer) {
+		p.clock = c
+	}
+}
+
+// AcceptPolicy decides whether an authenticated remote peer may keep its
+// session. Returning an error closes the session.
+type AcceptPolicy func(remote identity.PeerID, capabilities map[string]string) error
+
+// RequireDifficulty returns an AcceptPolicy that refuses peers whose PeerID
+// has fewer than bits leading zero bits (see identity.GrindKeyPair).
+func RequireDifficulty(bits int) AcceptPolicy {
+	return func(remote identity.PeerID, _ map[string]string) error {
+		return remote.CheckDifficulty(bits)
+	}
+}
+
+// PeerConfig holds the settings that can be changed while a Peer runs.
+// New values apply to future handshakes; established sessions are unaffected.
+type PeerConfig struct {
+	KeyPair       *identity.KeyPair // new identity for future handshakes; nil keeps the current one
+	Capabilities  map[string]string
+	AcceptPolicy  AcceptPolicy // nil accepts every authenticated peer
+	MaxAcceptRate float64      // incoming handshakes per second; 0 means unlimited
+
+	// Tickets, if set, has its key rotated to TicketKey. Tickets issued under
+	// the previous key keep working until the next rotation.
+	Tickets   *session.TicketStore
+	TicketKey *[session.TicketKeySize]byte
+}
+
+// UpdateConfig applies cfg at runtime without dropping sessions.
+func (p *Peer) UpdateConfig(cfg PeerConfig) {
+	caps := make(map[string]string, len(cfg.Capabilities))
+	for k, v := range cfg.Capabilities {
+		caps[k] = v
+	}
+	if cfg.Tickets != nil && cfg.TicketKey != nil {
+		cfg.Tickets.RotateKey(*cfg.TicketKey)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cfg.KeyPair != nil {
+		p.KeyPair = *cfg.KeyPair
+	}
+	p.Capabilities = caps
+	p.acceptPolicy = cfg.AcceptPolicy
+	if cfg.MaxAcceptRate != p.acceptRate {
+		p.acceptRate = cfg.MaxAcceptRate
+		p.acceptTokens = max(1, cfg.MaxAcceptRate)
+		p.acceptLast = time.Now()
+	}
+}
+
+// Config returns the peer's current runtime configuration.
+func (p *Peer) Config() PeerConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	caps := make(map[string]string, len(p.Capabilities))
+	for k, v := range p.Capabilities {
+		caps[k] = v
+	}
+	kp := p.KeyPair
+	return PeerConfig{KeyPair: &kp, Capabilities: caps, AcceptPolicy: p.acceptPolicy, MaxAcceptRate: p.acceptRate}
+}
+
+// handshakeParams returns the identity and options for the next handshake.
+func (p *Peer) handshakeParams() (identity.KeyPair, session.HandshakeOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	opts := session.HandshakeOptions{Capabilities: p.Capabilities, Interceptors: p.interceptors, HandshakeTimeout: p.handshakeTimeout, Clock: p.clock}
+	if opts.HandshakeTimeout == 0 {
+		opts.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	// The signed server HELLO is shared by all connections until the identity
+	// or capabilities change.
+	if p.helloCache == nil || !p.helloCache.Matches(p.KeyPair, opts) {
+		p.helloCache = session.NewHelloCache(p.KeyPair, opts, 0)
+	}
+	opts.HelloCache = p.helloCache
+	return p.KeyPair, opts
+}
+
+// allowAccept takes a token from the accept rate limiter (a token bucket with
+// a burst of one second's worth of handshakes).
+func (p *Peer) allowAccept() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.acceptRate <= 0 {
+		return true
+	}
+	now := time.Now()
+	burst := max(1, p.acceptRate)
+	p.acceptTokens = min(burst, p.acceptTokens+now.Sub(p.acceptLast).Seconds()*p.acceptRate)
+	p.acceptLast = now
+	if p.acceptTokens < 1 {
+		return false
+	}
+	p.acceptTokens--
+	return true
+}
+
+func (p *Peer) checkPolicy(s *session.Session) error {
+	p.mu.Lock()
+	policy := p.acceptPolicy
+	p.mu.Unlock()
+	if policy == nil {
+		return nil
+	}
+	return policy(s.RemotePeerID(), s.RemoteCapabilities())
+}
@@ -6,64 +6,186 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
-	"sync/atomic"
+	"sync"
 
+	"github.com/TheusHen/I6P/i6p/crypto/ratchet"
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
 var (
 	ErrCiphertextTooShort = errors.New("crypto: ciphertext too short")
 	ErrDecryptionFailed   = errors.New("crypto: decryption failed")
+	ErrUnknownSuite       = errors.New("crypto: unknown AEAD suite")
+	ErrKeyExpired         = errors.New("crypto: key usage limit reached, rekey required")
 )
 
+// Suite selects the AEAD construction.
+type Suite uint8
+
+const (
+	// SuiteChaCha20Poly1305 uses 96-bit counter nonces (default).
+	SuiteChaCha20Poly1305 Suite = iota
+	// SuiteXChaCha20Poly1305 uses 192-bit random nonces. Nonces never depend on
+	// in-memory counters, so restoring a persisted key after a crash cannot
+	// cause nonce reuse.
+	SuiteXChaCha20Poly1305
+)
+
+func (s Suite) String() string {
+	switch s {
+	case SuiteChaCha20Poly1305:
+		return "chacha20-poly1305"
+	case SuiteXChaCha20Poly1305:
+		return "xchacha20-poly1305"
+	default:
+		return "unknown"
+	}
+}
+
+// UsageLimits bounds how much data a single key may protect.
+// Zero fields mean "no limit".
+type UsageLimits struct {
+	MaxMessages uint64
+	MaxBytes    uint64
+}
+
+// DefaultUsageLimits returns conservative per-key limits, the same the
+// ratchet applies to its message keys. The bounds sit well below the point
+// where nonce collisions or forgery probabilities become meaningful for
+// either suite, so keys are retired early rather than late.
+func DefaultUsageLimits() UsageLimits {
+	return UsageLimits(ratchet.DefaultUsageLimits)
+}
+
 // AEAD wraps ChaCha20-Poly1305 with automatic nonce management.
-// It uses a 64-bit counter + 32-bit random prefix for the 96-bit nonce.
-// This allows ~2^64 messages per key with no nonce reuse.
+// With the default suite it uses a 64-bit counter + 32-bit random prefix for the
+// 96-bit nonce, allowing ~2^64 messages per key with no nonce reuse.
+// With SuiteXChaCha20Poly1305 every nonce is 24 random bytes.
 type AEAD struct {
 	aead   cipher.AEAD
+	suite  Suite
 	prefix [4]byte
-	seq    atomic.Uint64
+
+	mu     sync.Mutex
+	seq    uint64
+	sealed uint64 // plaintext bytes sealed
+	limits UsageLimits
 }
 
-// NewAEAD creates a new AEAD cipher from a 32-byte key.
+// NewAEAD creates a new ChaCha20-Poly1305 AEAD cipher from a 32-byte key.
 func NewAEAD(key []byte) (*AEAD, error) {
+	return NewAEADWithSuite(key, SuiteChaCha20Poly1305)
+}
+
+// NewAEADWithSuite creates a new AEAD cipher for the given suite from a 32-byte key.
+func NewAEADWithSuite(key []byte, suite Suite) (*AEAD, error) {
 	if len(key) != chacha20poly1305.KeySize {
 		return nil, errors.New("crypto: invalid key size for ChaCha20-Poly1305")
 	}
-	aead, err := chacha20poly1305.New(key)
+	var (
+		aead cipher.AEAD
+		err  error
+	)
+	switch suite {
+	case SuiteChaCha20Poly1305:
+		aead, err = chacha20poly1305.New(key)
+	case SuiteXChaCha20Poly1305:
+		aead, err = chacha20poly1305.NewX(key)
+	default:
+		return nil, ErrUnknownSuite
+	}
 	if err != nil {
 		return nil, err
 	}
-	a := &AEAD{aead: aead}
+	a := &AEAD{aead: aead, suite: suite, limits: DefaultUsageLimits()}
 	if _, err := io.ReadFull(rand.Reader, a.prefix[:]); err != nil {
 		return nil, err
 	}
 	return a, nil
 }
 
-func (a *AEAD) nextNonce() []byte {
-	seq := a.seq.Add(1)
-	nonce := make([]byte, chacha20poly1305.NonceSize) // 12 bytes
+// reserve accounts for one message of n plaintext bytes and returns its
+// nonce. With enforce it fails with ErrKeyExpired instead if the message
+// would pass the usage limits.
+func (a *AEAD) reserve(n int, enforce bool) ([]byte, error) {
+	a.mu.Lock()
+	if enforce && a.exceedsLocked(1, uint64(n)) {
+		a.mu.Unlock()
+		return nil, ErrKeyExpired
+	}
+	a.seq++
+	a.sealed += uint64(n)
+	seq := a.seq
+	a.mu.Unlock()
+
+	nonce := make([]byte, a.aead.NonceSize())
+	if a.suite == SuiteXChaCha20Poly1305 {
+		// crypto/rand.Read never returns an error.
+		_, _ = rand.Read(nonce)
+		return nonce, nil
+	}
 	copy(nonce[:4], a.prefix[:])
 	binary.BigEndian.PutUint64(nonce[4:], seq)
-	return nonce
+	return nonce, nil
+}
+
+func (a *AEAD) exceedsLocked(messages, bytes uint64) bool {
+	return a.limits.MaxMessages != 0 && a.seq+messages > a.limits.MaxMessages ||
+		a.limits.MaxBytes != 0 && a.sealed+bytes > a.limits.MaxBytes
 }
 
 // Seal encrypts and authenticates plaintext.
-// Returns: nonce (12 bytes) || ciphertext || tag (16 bytes)
+// Usage is counted but limits are not enforced; keys sealing more than one
+// message should use TrySeal.
+// Returns: nonce (NonceSize bytes) || ciphertext || tag (16 bytes)
 func (a *AEAD) Seal(plaintext, additionalData []byte) []byte {
-	nonce := a.nextNonce()
-	ciphertext := a.aead.Seal(nil, nonce, plaintext, additionalData)
-	out := make([]byte, len(nonce)+len(ciphertext))
+	nonce, _ := a.reserve(len(plaintext), false)
+	return a.seal(nonce, plaintext, additionalData)
+}
+
+func (a *AEAD) seal(nonce, plaintext, additionalData []byte) []byte {
+	out := make([]byte, len(nonce), len(nonce)+len(plaintext)+a.aead.Overhead())
 	copy(out, nonce)
-	copy(out[len(nonce):], ciphertext)
-	return out
+	return a.aead.Seal(out, nonce, plaintext, additionalData)
+}
+
+// TrySeal is like Seal but enforces the key's usage limits.
+// Once a limit is reached it returns ErrKeyExpired for every further call;
+// the caller must step its ratchet or rekey. The check and the accounting
+// are atomic, so concurrent callers cannot overrun the limits together.
+func (a *AEAD) TrySeal(plaintext, additionalData []byte) ([]byte, error) {
+	nonce, err := a.reserve(len(plaintext), true)
+	if err != nil {
+		return nil, err
+	}
+	return a.seal(nonce, plaintext, additionalData), nil
+}
+
+// SetUsageLimits overrides the default usage limits for this key.
+func (a *AEAD) SetUsageLimits(l UsageLimits) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.limits = l
+}
+
+// Usage returns the number of messages and plaintext bytes sealed so far.
+func (a *AEAD) Usage() (messages, bytes uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.seq, a.sealed
+}
+
+// Expired reports whether the key has reached its usage limits.
+func (a *AEAD) Expired() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.exceedsLocked(1, 0) || a.limits.MaxBytes != 0 && a.sealed >= a.limits.MaxBytes
 }
 
 // Open decrypts and verifies ciphertext.
-// Input format: nonce (12 bytes) || ciphertext || tag (16 bytes)
+// Input format: nonce (NonceSize bytes) || ciphertext || tag (16 bytes)
 func (a *AEAD) Open(ciphertext, additionalData []byte) ([]byte, error) {
-	nonceSize := chacha20poly1305.NonceSize
+	nonceSize := a.aead.NonceSize()
 	if len(ciphertext) < nonceSize+a.aead.Overhead() {
 		return nil, ErrCiphertextTooShort
 	}
@@ -80,4 +202,7 @@ func (a *AEAD) Open(ciphertext, additionalData []byte) ([]byte, error) {
 func (a *AEAD) Overhead() int { return a.aead.Overhead() }
 
 // NonceSize returns the nonce size.
-func (a *AEAD) NonceSize() int { return chacha20poly1305.NonceSize }
+func (a *AEAD) NonceSize() int { return a.aead.NonceSize() }
+
+// Suite returns the AEAD suite.
+func (a *AEAD) Suite() Suite { return a.suite }
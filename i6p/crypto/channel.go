@@ -1,6 +1,8 @@
 package crypto
 
 import (
+	"context"
+	"crypto/sha256"
 	"errors"
 	"sync"
 
@@ -9,6 +11,7 @@ import (
 
 var (
 	ErrChannelNotEstablished = errors.New("crypto: secure channel not established")
+	ErrSASNotConfirmed       = errors.New("crypto: short authentication string not confirmed")
 )
 
 // SecureChannel provides an end-to-end encrypted channel with forward secrecy.
@@ -21,6 +24,11 @@ type SecureChannel struct {
 	remoteEphPub [32]byte
 	sendChain    *ratchet.Chain
 	recvChain    *ratchet.Receiver
+	padding      PaddingPolicy
+	limits       UsageLimits
+	batch        ratchet.BatchPolicy
+	strictSAS    bool // Encrypt waits for ConfirmSAS
+	sasConfirmed bool
 }
 
 // NewSecureChannelInitiator creates a channel as the initiating party.
@@ -32,6 +40,7 @@ func NewSecureChannelInitiator() (*SecureChannel, error) {
 	return &SecureChannel{
 		isInitiator: true,
 		localEph:    eph,
+		limits:      DefaultUsageLimits(),
 	}, nil
 }
 
@@ -44,6 +53,7 @@ func NewSecureChannelResponder() (*SecureChannel, error) {
 	return &SecureChannel{
 		isInitiator: false,
 		localEph:    eph,
+		limits:      DefaultUsageLimits(),
 	}, nil
 }
 
@@ -104,11 +114,125 @@ func (sc *SecureChannel) Complete(peerEphPub [32]byte) error {
 	if err != nil {
 		return err
 	}
+	sc.applyChainPolicyLocked()
 
 	sc.established = true
 	return nil
 }
 
+// batchWindow is how many generations of batched messages a channel with a
+// batch policy can still open out of order.
+const batchWindow = 4
+
+// SetUsageLimits bounds what each message key of the channel may protect
+// (DefaultUsageLimits unless set). A key that reaches them is retired and
+// the ratchet steps, so the limits never stop the channel.
+func (sc *SecureChannel) SetUsageLimits(l UsageLimits) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.limits = l
+	sc.applyChainPolicyLocked()
+}
+
+// SetBatchPolicy lets the channel reuse a message key for several messages
+// (see ratchet.BatchPolicy). Both ends must set it, since it also opens the
+// receive window for batched messages.
+func (sc *SecureChannel) SetBatchPolicy(p ratchet.BatchPolicy) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.batch = p
+	sc.applyChainPolicyLocked()
+}
+
+func (sc *SecureChannel) applyChainPolicyLocked() {
+	if sc.sendChain == nil {
+		return
+	}
+	sc.sendChain.SetUsageLimits(ratchet.UsageLimits(sc.limits))
+	sc.sendChain.SetBatchPolicy(sc.batch)
+	if sc.batch.Messages > 1 || sc.batch.Interval > 0 {
+		sc.recvChain.SetBatchWindow(batchWindow)
+	} else {
+		sc.recvChain.SetBatchWindow(0)
+	}
+}
+
+// SetPadding sets the length padding policy applied to every message.
+// Both ends must use the same policy. Channels made by
+// session.Session.NewSecureChannel use the one negotiated in the handshake.
+func (sc *SecureChannel) SetPadding(p PaddingPolicy) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.padding = p
+}
+
+// Padding returns the active padding policy.
+func (sc *SecureChannel) Padding() PaddingPolicy {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.padding
+}
+
+// SetStrictSAS makes Encrypt and EncryptBatch fail with ErrSASNotConfirmed
+// until ConfirmSAS is called, so nothing is sent before the users compared
+// the short authentication string. Decryption is not gated.
+func (sc *SecureChannel) SetStrictSAS(strict bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.strictSAS = strict
+}
+
+// Transcript returns a hash binding both ephemeral public keys, in
+// initiator-then-responder order. Both ends compute the same value unless a
+// man in the middle substituted a key.
+func (sc *SecureChannel) Transcript() ([32]byte, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if !sc.established {
+		return [32]byte{}, ErrChannelNotEstablished
+	}
+	initiatorPub, responderPub := sc.localEph.PublicKey, sc.remoteEphPub
+	if !sc.isInitiator {
+		initiatorPub, responderPub = responderPub, initiatorPub
+	}
+	h := sha256.New()
+	h.Write([]byte("i6p channel transcript"))
+	h.Write(initiatorPub[:])
+	h.Write(responderPub[:])
+	var out [32]byte
+	h.Sum(out[:0])
+	return out, nil
+}
+
+// SAS returns the short authentication string of the channel transcript,
+// for the users to compare before calling ConfirmSAS.
+func (sc *SecureChannel) SAS() (ShortAuthString, error) {
+	t, err := sc.Transcript()
+	if err != nil {
+		return ShortAuthString{}, err
+	}
+	return SAS(t[:]), nil
+}
+
+// ConfirmSAS records that the users found matching short authentication
+// strings, which releases Encrypt in strict mode.
+func (sc *SecureChannel) ConfirmSAS() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if !sc.established {
+		return ErrChannelNotEstablished
+	}
+	sc.sasConfirmed = true
+	return nil
+}
+
+// SASConfirmed reports whether ConfirmSAS was called.
+func (sc *SecureChannel) SASConfirmed() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.sasConfirmed
+}
+
 // IsEstablished returns true if the channel is ready for use.
 func (sc *SecureChannel) IsEstablished() bool {
 	sc.mu.Lock()
@@ -124,14 +248,140 @@ func (sc *SecureChannel) Encrypt(plaintext, ad []byte) ([]byte, error) {
 	if !sc.established {
 		return nil, ErrChannelNotEstablished
 	}
+	if sc.strictSAS && !sc.sasConfirmed {
+		return nil, ErrSASNotConfirmed
+	}
 
-	msg, err := sc.sendChain.Seal(plaintext, ad)
+	msg, err := sc.sendChain.Seal(sc.padding.Pad(plaintext), ad)
 	if err != nil {
 		return nil, err
 	}
 	return msg.Encode(), nil
 }
 
+// EncryptBatch encrypts several messages at once. The channel lock is taken once,
+// the ratchet is stepped len(plaintexts) times, and all ciphertexts share a single
+// backing buffer. Each result is identical to what Encrypt would have produced.
+func (sc *SecureChannel) EncryptBatch(plaintexts [][]byte, ad []byte) ([][]byte, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if err := sc.checkSendLocked(); err != nil {
+		return nil, err
+	}
+	return sc.encryptBatchLocked(plaintexts, ad)
+}
+
+// batchCancelInterval is how many messages the context-aware batch methods
+// process between checks of their context.
+const batchCancelInterval = 64
+
+// EncryptBatchContext is EncryptBatch that checks ctx between groups of
+// messages. Once ctx is done it returns the ciphertexts produced so far
+// with ctx.Err(); the ratchet has advanced past them, so they should still
+// be sent or the peer will see a gap.
+func (sc *SecureChannel) EncryptBatchContext(ctx context.Context, plaintexts [][]byte, ad []byte) ([][]byte, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if err := sc.checkSendLocked(); err != nil {
+		return nil, err
+	}
+	out := make([][]byte, 0, len(plaintexts))
+	for len(plaintexts) > 0 {
+		if err := ctx.Err(); err != nil {
+			return out, err
+		}
+		n := min(len(plaintexts), batchCancelInterval)
+		cts, err := sc.encryptBatchLocked(plaintexts[:n], ad)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, cts...)
+		plaintexts = plaintexts[n:]
+	}
+	return out, nil
+}
+
+func (sc *SecureChannel) checkSendLocked() error {
+	if !sc.established {
+		return ErrChannelNotEstablished
+	}
+	if sc.strictSAS && !sc.sasConfirmed {
+		return ErrSASNotConfirmed
+	}
+	return nil
+}
+
+func (sc *SecureChannel) encryptBatchLocked(plaintexts [][]byte, ad []byte) ([][]byte, error) {
+	if len(plaintexts) == 0 {
+		return nil, nil
+	}
+
+	aeads, gen, err := sc.sendChain.StepBatch(len(plaintexts))
+	if err != nil {
+		return nil, err
+	}
+
+	overhead := ratchet.HeaderSize + aeads[0].NonceSize() + aeads[0].Overhead()
+	version := sc.sendChain.Version()
+	total := 0
+	for _, pt := range plaintexts {
+		total += overhead + sc.padding.PaddedLen(len(pt))
+	}
+
+	buf := make([]byte, 0, total)
+	out := make([][]byte, len(plaintexts))
+	for i, pt := range plaintexts {
+		start := len(buf)
+		buf = ratchet.EncryptedMessage{Version: version, Generation: gen + uint64(i)}.AppendHeader(buf)
+		if buf, err = aeads[i].SealAppend(buf, sc.padding.Pad(pt), ad); err != nil {
+			return nil, err
+		}
+		out[i] = buf[start:len(buf):len(buf)]
+	}
+	return out, nil
+}
+
+// DecryptBatch decrypts several messages under a single channel lock.
+// On failure it returns the plaintexts decrypted before the failing message
+// together with the error; later messages are not processed.
+func (sc *SecureChannel) DecryptBatch(ciphertexts [][]byte, ad []byte) ([][]byte, error) {
+	return sc.DecryptBatchContext(context.Background(), ciphertexts, ad)
+}
+
+// DecryptBatchContext is DecryptBatch that also stops, returning ctx.Err(),
+// once ctx is done. Messages not yet decrypted can be passed again later.
+func (sc *SecureChannel) DecryptBatchContext(ctx context.Context, ciphertexts [][]byte, ad []byte) ([][]byte, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if !sc.established {
+		return nil, ErrChannelNotEstablished
+	}
+
+	out := make([][]byte, 0, len(ciphertexts))
+	for i, ct := range ciphertexts {
+		if i%batchCancelInterval == 0 && ctx.Err() != nil {
+			return out, ctx.Err()
+		}
+		msg, err := ratchet.DecodeEncryptedMessage(ct)
+		if err != nil {
+			return out, err
+		}
+		pt, err := sc.recvChain.Open(msg, ad)
+		if err != nil {
+			return out, err
+		}
+		pt, err = sc.padding.Unpad(pt)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, pt)
+	}
+	return out, nil
+}
+
 // Decrypt decrypts a message.
 func (sc *SecureChannel) Decrypt(ciphertext, ad []byte) ([]byte, error) {
 	sc.mu.Lock()
@@ -145,7 +395,11 @@ func (sc *SecureChannel) Decrypt(ciphertext, ad []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return sc.recvChain.Open(msg, ad)
+	pt, err := sc.recvChain.Open(msg, ad)
+	if err != nil {
+		return nil, err
+	}
+	return sc.padding.Unpad(pt)
 }
 
 // SendGeneration returns the current send generation.
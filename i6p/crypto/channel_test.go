@@ -2,7 +2,12 @@ package crypto
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+
+	"github.com/TheusHen/I6P/i6p/crypto/ratchet"
 )
 
 func TestSecureChannelRoundTrip(t *testing.T) {
@@ -100,6 +105,216 @@ func TestSecureChannelOutOfOrder(t *testing.T) {
 	}
 }
 
+func TestSecureChannelPadding(t *testing.T) {
+	initiator, _ := NewSecureChannelInitiator()
+	responder, _ := NewSecureChannelResponder()
+	_ = initiator.Complete(responder.LocalEphemeralPublic())
+	_ = responder.Complete(initiator.LocalEphemeralPublic())
+
+	local := map[string]string{PaddingCapability: "bucket/64"}
+	remote := map[string]string{PaddingCapability: "constant/256"}
+	policy := NegotiatePaddingCapabilities(local, remote)
+	if policy != NegotiatePaddingCapabilities(remote, local) {
+		t.Fatalf("negotiation is not symmetric")
+	}
+	if policy.Mode != PaddingConstant || policy.Size != 256 {
+		t.Fatalf("unexpected policy %s", policy)
+	}
+	initiator.SetPadding(policy)
+	responder.SetPadding(policy)
+
+	var sizes []int
+	for _, msg := range [][]byte{{}, []byte("short"), bytes.Repeat([]byte{0x80}, 200)} {
+		ct, err := initiator.Encrypt(msg, nil)
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		sizes = append(sizes, len(ct))
+		pt, err := responder.Decrypt(ct, nil)
+		if err != nil {
+			t.Fatalf("Decrypt: %v", err)
+		}
+		if !bytes.Equal(pt, msg) {
+			t.Fatalf("message mismatch")
+		}
+	}
+	for _, n := range sizes[1:] {
+		if n != sizes[0] {
+			t.Fatalf("ciphertext sizes leak plaintext length: %v", sizes)
+		}
+	}
+
+	if NegotiatePaddingCapabilities(local, map[string]string{}).Mode != PaddingNone {
+		t.Fatalf("expected no padding when peer does not advertise it")
+	}
+}
+
+func TestPaddingPolicyBucket(t *testing.T) {
+	p, err := ParsePaddingPolicy("bucket/32")
+	if err != nil {
+		t.Fatalf("ParsePaddingPolicy: %v", err)
+	}
+	for n, want := range map[int]int{0: 32, 31: 32, 32: 64, 100: 128} {
+		if got := p.PaddedLen(n); got != want {
+			t.Fatalf("PaddedLen(%d) = %d, want %d", n, got, want)
+		}
+	}
+	if _, err := p.Unpad(make([]byte, 32)); err != ErrInvalidPadding {
+		t.Fatalf("expected ErrInvalidPadding, got %v", err)
+	}
+}
+
+func TestPaddingPolicyOversized(t *testing.T) {
+	if _, err := ParsePaddingPolicy("constant/2000000000"); err != ErrInvalidPaddingPolicy {
+		t.Fatalf("expected ErrInvalidPaddingPolicy, got %v", err)
+	}
+	if _, err := ParsePaddingPolicy(fmt.Sprintf("bucket/%d", MaxPaddingSize)); err != nil {
+		t.Fatalf("ParsePaddingPolicy at the limit: %v", err)
+	}
+	local := map[string]string{PaddingCapability: "bucket/64"}
+	remote := map[string]string{PaddingCapability: "constant/2000000000"}
+	if p := NegotiatePaddingCapabilities(local, remote); p.Mode != PaddingNone {
+		t.Fatalf("oversized advertisement negotiated %s", p)
+	}
+	huge := PaddingPolicy{Mode: PaddingConstant, Size: MaxPaddingSize + 1}
+	if p := NegotiatePadding(PaddingPolicy{Mode: PaddingBucket, Size: 64}, huge); p.Mode != PaddingNone {
+		t.Fatalf("oversized policy negotiated %s", p)
+	}
+}
+
+func TestSecureChannelBatch(t *testing.T) {
+	initiator, _ := NewSecureChannelInitiator()
+	responder, _ := NewSecureChannelResponder()
+	_ = initiator.Complete(responder.LocalEphemeralPublic())
+	_ = responder.Complete(initiator.LocalEphemeralPublic())
+
+	msgs := [][]byte{[]byte("a"), []byte("bb"), []byte("ccc"), {}}
+	cts, err := initiator.EncryptBatch(msgs, []byte("ad"))
+	if err != nil {
+		t.Fatalf("EncryptBatch: %v", err)
+	}
+	if initiator.SendGeneration() != uint64(len(msgs)) {
+		t.Fatalf("expected generation %d, got %d", len(msgs), initiator.SendGeneration())
+	}
+
+	// Single-message API interoperates with batches.
+	single, _ := initiator.Encrypt([]byte("dddd"), []byte("ad"))
+	pt, err := responder.Decrypt(single, []byte("ad"))
+	if err != nil || string(pt) != "dddd" {
+		t.Fatalf("Decrypt single after batch: %v", err)
+	}
+
+	pts, err := responder.DecryptBatch(cts, []byte("ad"))
+	if err != nil {
+		t.Fatalf("DecryptBatch: %v", err)
+	}
+	for i := range msgs {
+		if !bytes.Equal(pts[i], msgs[i]) {
+			t.Fatalf("message %d mismatch", i)
+		}
+	}
+
+	// A failure returns the messages decrypted so far.
+	cts, _ = initiator.EncryptBatch(msgs[:2], nil)
+	cts[1][len(cts[1])-1] ^= 0xff
+	pts, err = responder.DecryptBatch(cts, nil)
+	if err == nil || len(pts) != 1 {
+		t.Fatalf("expected partial result and error, got %d, %v", len(pts), err)
+	}
+}
+
+func TestSecureChannelUsageLimits(t *testing.T) {
+	initiator, _ := NewSecureChannelInitiator()
+	responder, _ := NewSecureChannelResponder()
+	_ = initiator.Complete(responder.LocalEphemeralPublic())
+	_ = responder.Complete(initiator.LocalEphemeralPublic())
+
+	// Batched keys would carry all ten messages; the limit retires each
+	// after three, so the ratchet steps four times.
+	policy := ratchet.BatchPolicy{Messages: 100}
+	initiator.SetBatchPolicy(policy)
+	responder.SetBatchPolicy(policy)
+	initiator.SetUsageLimits(UsageLimits{MaxMessages: 3})
+	for i := 0; i < 10; i++ {
+		ct, err := initiator.Encrypt([]byte{byte(i)}, nil)
+		if err != nil {
+			t.Fatalf("Encrypt %d: %v", i, err)
+		}
+		pt, err := responder.Decrypt(ct, nil)
+		if err != nil || !bytes.Equal(pt, []byte{byte(i)}) {
+			t.Fatalf("Decrypt %d: %v", i, err)
+		}
+	}
+	if g := initiator.SendGeneration(); g != 4 {
+		t.Fatalf("expected 4 generations, got %d", g)
+	}
+
+	// A message no fresh key may carry fails instead of looping.
+	initiator.SetUsageLimits(UsageLimits{MaxBytes: 4})
+	if _, err := initiator.Encrypt([]byte("too long"), nil); !errors.Is(err, ratchet.ErrKeyExpired) {
+		t.Fatalf("expected ErrKeyExpired, got %v", err)
+	}
+	if _, err := initiator.EncryptBatch([][]byte{[]byte("too long")}, nil); !errors.Is(err, ratchet.ErrKeyExpired) {
+		t.Fatalf("EncryptBatch: expected ErrKeyExpired, got %v", err)
+	}
+}
+
+func TestSecureChannelBatchContext(t *testing.T) {
+	initiator, _ := NewSecureChannelInitiator()
+	responder, _ := NewSecureChannelResponder()
+	_ = initiator.Complete(responder.LocalEphemeralPublic())
+	_ = responder.Complete(initiator.LocalEphemeralPublic())
+
+	msgs := make([][]byte, 3*batchCancelInterval)
+	for i := range msgs {
+		msgs[i] = []byte{byte(i)}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cts, err := initiator.EncryptBatchContext(ctx, msgs, nil)
+	if !errors.Is(err, context.Canceled) || len(cts) != 0 {
+		t.Fatalf("cancelled EncryptBatchContext: %d, %v", len(cts), err)
+	}
+	if initiator.SendGeneration() != 0 {
+		t.Fatalf("cancelled batch used %d nonces", initiator.SendGeneration())
+	}
+
+	cts, err = initiator.EncryptBatchContext(context.Background(), msgs, nil)
+	if err != nil {
+		t.Fatalf("EncryptBatchContext: %v", err)
+	}
+	pts, err := responder.DecryptBatchContext(ctx, cts, nil)
+	if !errors.Is(err, context.Canceled) || len(pts) != 0 {
+		t.Fatalf("cancelled DecryptBatchContext: %d, %v", len(pts), err)
+	}
+	pts, err = responder.DecryptBatchContext(context.Background(), cts, nil)
+	if err != nil || len(pts) != len(msgs) {
+		t.Fatalf("DecryptBatchContext: %d, %v", len(pts), err)
+	}
+	for i := range msgs {
+		if !bytes.Equal(pts[i], msgs[i]) {
+			t.Fatalf("message %d mismatch", i)
+		}
+	}
+}
+
+func BenchmarkSecureChannelEncryptBatch(b *testing.B) {
+	initiator, _ := NewSecureChannelInitiator()
+	responder, _ := NewSecureChannelResponder()
+	_ = initiator.Complete(responder.LocalEphemeralPublic())
+	_ = responder.Complete(initiator.LocalEphemeralPublic())
+
+	msgs := make([][]byte, 64)
+	for i := range msgs {
+		msgs[i] = make([]byte, 1024)
+	}
+	b.SetBytes(int64(len(msgs) * 1024))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = initiator.EncryptBatch(msgs, nil)
+	}
+}
+
 func BenchmarkSecureChannelEncrypt(b *testing.B) {
 	initiator, _ := NewSecureChannelInitiator()
 	responder, _ := NewSecureChannelResponder()
@@ -0,0 +1,16 @@
+package crypto
+
+import "crypto/subtle"
+
+// Equal reports whether a and b hold the same bytes.
+// The comparison runs in constant time with respect to the contents, so it is
+// safe for hashes, Merkle roots, MACs and other secret-derived values.
+// Slices of different length compare unequal without leaking their contents.
+func Equal(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// Equal32 is Equal for fixed-size 32-byte values such as PeerIDs and keys.
+func Equal32(a, b [32]byte) bool {
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
@@ -2,6 +2,8 @@ package crypto
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"testing"
 )
 
@@ -63,6 +65,58 @@ func TestAEADRoundTrip(t *testing.T) {
 	}
 }
 
+func TestAEADXChaCha(t *testing.T) {
+	key := make([]byte, 32)
+	aead, err := NewAEADWithSuite(key, SuiteXChaCha20Poly1305)
+	if err != nil {
+		t.Fatalf("NewAEADWithSuite: %v", err)
+	}
+	if aead.NonceSize() != 24 {
+		t.Fatalf("expected 24-byte nonce, got %d", aead.NonceSize())
+	}
+
+	// A second instance over the same key (e.g. restored after a crash)
+	// must still interoperate and never repeat nonces.
+	restored, _ := NewAEADWithSuite(key, SuiteXChaCha20Poly1305)
+	ct1 := aead.Seal([]byte("msg"), nil)
+	ct2 := restored.Seal([]byte("msg"), nil)
+	if bytes.Equal(ct1[:24], ct2[:24]) {
+		t.Fatalf("nonce reused across instances")
+	}
+	pt, err := restored.Open(ct1, nil)
+	if err != nil || string(pt) != "msg" {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if _, err := NewAEADWithSuite(key, Suite(99)); err != ErrUnknownSuite {
+		t.Fatalf("expected ErrUnknownSuite, got %v", err)
+	}
+}
+
+func TestAEADUsageLimits(t *testing.T) {
+	aead, _ := NewAEAD(make([]byte, 32))
+	aead.SetUsageLimits(UsageLimits{MaxMessages: 2, MaxBytes: 10})
+
+	if _, err := aead.TrySeal([]byte("12345"), nil); err != nil {
+		t.Fatalf("TrySeal 1: %v", err)
+	}
+	if _, err := aead.TrySeal([]byte("123456"), nil); err != ErrKeyExpired {
+		t.Fatalf("expected byte limit to trip, got %v", err)
+	}
+	if _, err := aead.TrySeal([]byte("1"), nil); err != nil {
+		t.Fatalf("TrySeal 2: %v", err)
+	}
+	if !aead.Expired() {
+		t.Fatalf("expected key to be expired after message limit")
+	}
+	if _, err := aead.TrySeal(nil, nil); err != ErrKeyExpired {
+		t.Fatalf("expected ErrKeyExpired, got %v", err)
+	}
+	if msgs, n := aead.Usage(); msgs != 2 || n != 6 {
+		t.Fatalf("unexpected usage %d msgs / %d bytes", msgs, n)
+	}
+}
+
 func TestDeriveSessionKeys(t *testing.T) {
 	alice, _ := GenerateX25519()
 	bob, _ := GenerateX25519()
@@ -80,6 +134,31 @@ func TestDeriveSessionKeys(t *testing.T) {
 	}
 }
 
+func TestEqual(t *testing.T) {
+	a := []byte("merkle-root-0123456789abcdef")
+	b := append([]byte(nil), a...)
+	if !Equal(a, b) {
+		t.Fatalf("expected equal slices")
+	}
+	b[len(b)-1] ^= 0x01
+	if Equal(a, b) {
+		t.Fatalf("expected unequal slices")
+	}
+	if Equal(a, a[:len(a)-1]) {
+		t.Fatalf("expected length mismatch to compare unequal")
+	}
+
+	var x, y [32]byte
+	x[0], y[0] = 1, 1
+	if !Equal32(x, y) {
+		t.Fatalf("expected equal arrays")
+	}
+	y[31] = 1
+	if Equal32(x, y) {
+		t.Fatalf("expected unequal arrays")
+	}
+}
+
 func BenchmarkAEADSeal(b *testing.B) {
 	key := make([]byte, 32)
 	aead, _ := NewAEAD(key)
@@ -102,3 +181,88 @@ func BenchmarkAEADOpen(b *testing.B) {
 		_, _ = aead.Open(ciphertext, nil)
 	}
 }
+
+func TestECDHRFC7748Vector(t *testing.T) {
+	var alicePriv, bobPub [32]byte
+	copy(alicePriv[:], mustHex(t, "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"))
+	copy(bobPub[:], mustHex(t, "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f"))
+	shared, err := ECDH(alicePriv, bobPub)
+	if err != nil {
+		t.Fatalf("ECDH: %v", err)
+	}
+	if want := mustHex(t, "4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742"); !bytes.Equal(shared, want) {
+		t.Fatalf("shared = %x", shared)
+	}
+}
+
+func TestECDHRejectsBadPoints(t *testing.T) {
+	priv, _ := GenerateX25519()
+	bad := map[string]string{
+		"zero":                     "0000000000000000000000000000000000000000000000000000000000000000",
+		"one":                      "0100000000000000000000000000000000000000000000000000000000000000",
+		"order 8 (a)":              "e0eb7a7c3b41b8ae1656e3faf19fc46ada098deb9c32b1fd866205165f49b800",
+		"order 8 (b)":              "5f9c95bca3508c24b1d0b1559c83ef5b04445cc4581c8e86d8224eddd09f1157",
+		"p-1":                      "ecffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		"p (zero, non-canonical)":  "edffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		"p+1 (one, non-canonical)": "eeffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		"top bit set":              "e0eb7a7c3b41b8ae1656e3faf19fc46ada098deb9c32b1fd866205165f49b880",
+		"2^256-1":                  "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+	}
+	for name, h := range bad {
+		var pub [32]byte
+		copy(pub[:], mustHex(t, h))
+		if _, err := ECDH(priv.PrivateKey, pub); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("%s: err = %v, want ErrInvalidPublicKey", name, err)
+		}
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSealToPeer(t *testing.T) {
+	bob, _ := GenerateX25519()
+	msg := []byte("meet at the usual place")
+	sealed, err := SealToPeer(bob.PublicKey, msg)
+	if err != nil {
+		t.Fatalf("SealToPeer: %v", err)
+	}
+	if len(sealed) != len(msg)+SealedOverhead {
+		t.Fatalf("sealed length %d", len(sealed))
+	}
+	got, err := OpenSealed(bob, sealed)
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("OpenSealed: %q, %v", got, err)
+	}
+
+	// A fresh ephemeral key makes every sealing different.
+	again, _ := SealToPeer(bob.PublicKey, msg)
+	if bytes.Equal(again, sealed) {
+		t.Fatalf("two sealings are identical")
+	}
+
+	eve, _ := GenerateX25519()
+	if _, err := OpenSealed(eve, sealed); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("OpenSealed with another key: %v", err)
+	}
+	for _, i := range []int{0, 40, len(sealed) - 1} {
+		bad := append([]byte(nil), sealed...)
+		bad[i] ^= 1
+		if _, err := OpenSealed(bob, bad); err == nil {
+			t.Fatalf("tampered byte %d accepted", i)
+		}
+	}
+	if _, err := OpenSealed(bob, sealed[:SealedOverhead-1]); !errors.Is(err, ErrCiphertextTooShort) {
+		t.Fatalf("short message: %v", err)
+	}
+	var zero [32]byte
+	if _, err := SealToPeer(zero, msg); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Fatalf("SealToPeer to a low-order point: %v", err)
+	}
+}
@@ -5,5 +5,6 @@
 //   - Forward secrecy via ephemeral X25519 key exchange
 //   - AEAD encryption via ChaCha20-Poly1305 (RFC 8439)
 //   - Key derivation via HKDF-SHA256
+//   - Non-interactive encryption to a peer's static key (SealToPeer)
 //   - Constant-time operations where applicable
 package crypto
@@ -0,0 +1,391 @@
+// Package frost implements FROST threshold signing for Ed25519 (RFC 9591,
+// FROST(Ed25519, SHA-512)): a group key is split into n shares, any
+// threshold of which can cooperate to produce a signature that verifies
+// with crypto/ed25519 under the group key. No share holder ever learns the
+// group secret.
+//
+// Signing takes two rounds. Each signer first publishes a Commitment to
+// fresh single-use Nonces (Commit); once the commitments of the chosen
+// signers are collected, each signer produces a SignatureShare over the
+// SigningRequest (KeyShare.Sign), and Aggregate checks the shares and
+// combines them. Signer and Cosigner run these rounds over I6P sessions.
+//
+// A SigningRequest with a Context produces an Ed25519ctx signature (RFC
+// 8032), as identity.SignContext does, so a group key can sign HELLOs,
+// records and rosters. Without a Context the output is a plain Ed25519
+// signature and the construction is exactly RFC 9591.
+package frost
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"filippo.io/edwards25519"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// contextString is the RFC 9591 ciphersuite label.
+const contextString = "FROST-ED25519-SHA512-v1"
+
+var (
+	ErrThreshold    = errors.New("frost: threshold must be between 1 and the number of shares")
+	ErrShare        = errors.New("frost: invalid key share")
+	ErrCommitments  = errors.New("frost: invalid commitment list")
+	ErrNoncesUsed   = errors.New("frost: nonces already used")
+	ErrInvalidShare = errors.New("frost: signature share does not verify")
+	ErrContext      = errors.New("frost: context must be at most 255 bytes")
+)
+
+// KeyShare is one participant's share of a group key. Secret must stay on
+// the participant's device.
+type KeyShare struct {
+	ID        uint16            `json:"id"`
+	Threshold int               `json:"threshold"`
+	Secret    []byte            `json:"secret"`
+	GroupKey  ed25519.PublicKey `json:"group_key"`
+}
+
+// PublicKeyPackage is what an aggregator needs: the group key, the
+// threshold and each participant's verifying share (its secret times the
+// base point).
+type PublicKeyPackage struct {
+	GroupKey  ed25519.PublicKey `json:"group_key"`
+	Threshold int               `json:"threshold"`
+	Shares    map[uint16][]byte `json:"shares"`
+}
+
+// GenerateShares creates a fresh group key split into n shares, threshold
+// of which can sign, as the trusted dealer of RFC 9591 appendix C does.
+func GenerateShares(threshold, n int) ([]KeyShare, PublicKeyPackage, error) {
+	s, err := randomScalar()
+	if err != nil {
+		return nil, PublicKeyPackage{}, err
+	}
+	return split(s, threshold, n)
+}
+
+// SplitKey splits an existing identity into n shares, threshold of which can
+// sign for kp.PublicKey, so a peer identity becomes group-controlled. The
+// caller should destroy kp afterwards.
+func SplitKey(kp identity.KeyPair, threshold, n int) ([]KeyShare, PublicKeyPackage, error) {
+	if len(kp.PrivateKey) != ed25519.PrivateKeySize {
+		return nil, PublicKeyPackage{}, ErrShare
+	}
+	h := sha512.Sum512(kp.PrivateKey.Seed())
+	s, err := edwards25519.NewScalar().SetBytesWithClamping(h[:32])
+	if err != nil {
+		return nil, PublicKeyPackage{}, err
+	}
+	return split(s, threshold, n)
+}
+
+func split(secret *edwards25519.Scalar, threshold, n int) ([]KeyShare, PublicKeyPackage, error) {
+	if threshold < 1 || threshold > n || n > 0xffff {
+		return nil, PublicKeyPackage{}, ErrThreshold
+	}
+	coeffs := []*edwards25519.Scalar{secret}
+	for range threshold - 1 {
+		c, err := randomScalar()
+		if err != nil {
+			return nil, PublicKeyPackage{}, err
+		}
+		coeffs = append(coeffs, c)
+	}
+	groupKey := new(edwards25519.Point).ScalarBaseMult(secret).Bytes()
+	pub := PublicKeyPackage{GroupKey: groupKey, Threshold: threshold, Shares: map[uint16][]byte{}}
+	shares := make([]KeyShare, n)
+	for i := range shares {
+		id := uint16(i + 1)
+		// Horner's rule: f(x) = c0 + x(c1 + x(c2 + ...)).
+		x := idScalar(id)
+		y := edwards25519.NewScalar()
+		for j := len(coeffs) - 1; j >= 0; j-- {
+			y.MultiplyAdd(y, x, coeffs[j])
+		}
+		shares[i] = KeyShare{ID: id, Threshold: threshold, Secret: y.Bytes(), GroupKey: groupKey}
+		pub.Shares[id] = new(edwards25519.Point).ScalarBaseMult(y).Bytes()
+	}
+	return shares, pub, nil
+}
+
+// Commitment is a signer's public commitment to its nonces for one signing.
+type Commitment struct {
+	ID      uint16 `json:"id"`
+	Hiding  []byte `json:"hiding"`
+	Binding []byte `json:"binding"`
+}
+
+// Nonces are the secret nonces behind a Commitment. They sign one message
+// and are then erased.
+type Nonces struct {
+	hiding, binding *edwards25519.Scalar
+	commitment      Commitment
+}
+
+// Commit runs round one for share: it draws fresh nonces, bound to the
+// secret share as RFC 9591 requires, and returns them with their public
+// commitment.
+func (share KeyShare) Commit() (*Nonces, Commitment, error) {
+	if _, err := share.scalar(); err != nil {
+		return nil, Commitment{}, err
+	}
+	hiding, err := nonce(share.Secret)
+	if err != nil {
+		return nil, Commitment{}, err
+	}
+	binding, err := nonce(share.Secret)
+	if err != nil {
+		return nil, Commitment{}, err
+	}
+	c := Commitment{
+		ID:      share.ID,
+		Hiding:  new(edwards25519.Point).ScalarBaseMult(hiding).Bytes(),
+		Binding: new(edwards25519.Point).ScalarBaseMult(binding).Bytes(),
+	}
+	return &Nonces{hiding: hiding, binding: binding, commitment: c}, c, nil
+}
+
+// SigningRequest is what signers sign in round two: the message, the
+// optional Ed25519ctx context and the commitments of every signer taking
+// part, including their own.
+type SigningRequest struct {
+	Message     []byte           `json:"message"`
+	Context     identity.Context `json:"context,omitempty"`
+	Commitments []Commitment     `json:"commitments"`
+}
+
+// SignatureShare is one signer's part of a signature.
+type SignatureShare struct {
+	ID uint16 `json:"id"`
+	Z  []byte `json:"z"`
+}
+
+// Sign runs round two: it signs req with share and the nonces of its own
+// commitment in req. The nonces are erased whatever the outcome.
+func (share KeyShare) Sign(nonces *Nonces, req SigningRequest) (SignatureShare, error) {
+	if nonces == nil || nonces.hiding == nil {
+		return SignatureShare{}, ErrNoncesUsed
+	}
+	hiding, binding, own := nonces.hiding, nonces.binding, nonces.commitment
+	nonces.hiding, nonces.binding = nil, nil
+
+	s, err := share.scalar()
+	if err != nil {
+		return SignatureShare{}, err
+	}
+	st, err := prepare(share.GroupKey, req)
+	if err != nil {
+		return SignatureShare{}, err
+	}
+	i, ok := st.index[share.ID]
+	if !ok || !equalCommitment(req.Commitments[i], own) {
+		return SignatureShare{}, fmt.Errorf("%w: own commitment missing", ErrCommitments)
+	}
+	// z = d + e*rho + lambda*s*c
+	z := edwards25519.NewScalar().Multiply(st.lambda(share.ID), s)
+	z.Multiply(z, st.challenge)
+	z.MultiplyAdd(binding, st.rho[i], z)
+	z.Add(z, hiding)
+	return SignatureShare{ID: share.ID, Z: z.Bytes()}, nil
+}
+
+// Aggregate verifies the shares of the signers of req and combines them
+// into a 64-byte signature of req.Message under the group key. A share that
+// does not verify yields ErrInvalidShare naming its signer.
+func Aggregate(pub PublicKeyPackage, req SigningRequest, shares []SignatureShare) ([]byte, error) {
+	st, err := prepare(pub.GroupKey, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Commitments) < pub.Threshold || len(shares) != len(req.Commitments) {
+		return nil, fmt.Errorf("%w: %d shares for %d commitments, threshold %d", ErrCommitments, len(shares), len(req.Commitments), pub.Threshold)
+	}
+	z := edwards25519.NewScalar()
+	seen := map[uint16]bool{}
+	for _, sh := range shares {
+		i, ok := st.index[sh.ID]
+		if !ok || seen[sh.ID] {
+			return nil, fmt.Errorf("%w: unexpected share from %d", ErrCommitments, sh.ID)
+		}
+		seen[sh.ID] = true
+		zi, err := edwards25519.NewScalar().SetCanonicalBytes(sh.Z)
+		if err != nil {
+			return nil, fmt.Errorf("%w: signer %d", ErrInvalidShare, sh.ID)
+		}
+		vs, ok := pub.Shares[sh.ID]
+		if !ok {
+			return nil, fmt.Errorf("%w: no verifying share for %d", ErrShare, sh.ID)
+		}
+		y, err := new(edwards25519.Point).SetBytes(vs)
+		if err != nil {
+			return nil, fmt.Errorf("%w: verifying share %d", ErrShare, sh.ID)
+		}
+		// z_i * B == D_i + rho_i * E_i + (c * lambda_i) * Y_i
+		cl := edwards25519.NewScalar().Multiply(st.challenge, st.lambda(sh.ID))
+		want := new(edwards25519.Point).ScalarMult(cl, y)
+		want.Add(want, st.commitment(i))
+		if new(edwards25519.Point).ScalarBaseMult(zi).Equal(want) != 1 {
+			return nil, fmt.Errorf("%w: signer %d", ErrInvalidShare, sh.ID)
+		}
+		z.Add(z, zi)
+	}
+	return append(st.r.Bytes(), z.Bytes()...), nil
+}
+
+// signingState holds what round two derives from a SigningRequest.
+type signingState struct {
+	ids       []*edwards25519.Scalar
+	index     map[uint16]int
+	hiding    []*edwards25519.Point
+	binding   []*edwards25519.Point
+	rho       []*edwards25519.Scalar
+	r         *edwards25519.Point
+	challenge *edwards25519.Scalar
+}
+
+func prepare(groupKey ed25519.PublicKey, req SigningRequest) (*signingState, error) {
+	if len(req.Context) > 255 {
+		return nil, ErrContext
+	}
+	if len(groupKey) != ed25519.PublicKeySize {
+		return nil, ErrShare
+	}
+	if len(req.Commitments) == 0 {
+		return nil, ErrCommitments
+	}
+	st := &signingState{index: map[uint16]int{}}
+	var list []byte
+	for i, c := range req.Commitments {
+		// RFC 9591 orders the list by identifier.
+		if c.ID == 0 || i > 0 && c.ID <= req.Commitments[i-1].ID {
+			return nil, fmt.Errorf("%w: identifiers must be nonzero and increasing", ErrCommitments)
+		}
+		d, err1 := new(edwards25519.Point).SetBytes(c.Hiding)
+		e, err2 := new(edwards25519.Point).SetBytes(c.Binding)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%w: commitment of %d", ErrCommitments, c.ID)
+		}
+		st.index[c.ID] = i
+		st.ids = append(st.ids, idScalar(c.ID))
+		st.hiding = append(st.hiding, d)
+		st.binding = append(st.binding, e)
+		list = append(list, idScalar(c.ID).Bytes()...)
+		list = append(list, c.Hiding...)
+		list = append(list, c.Binding...)
+	}
+
+	msg := signedMessage(req)
+	prefix := append([]byte(nil), groupKey...)
+	prefix = append(prefix, h("msg", msg)...)
+	prefix = append(prefix, h("com", list)...)
+	st.r = edwards25519.NewIdentityPoint()
+	for i, id := range st.ids {
+		st.rho = append(st.rho, hScalar("rho", append(append([]byte(nil), prefix...), id.Bytes()...)))
+		st.r.Add(st.r, st.commitment(i))
+	}
+
+	// The Ed25519 challenge: SHA-512(dom2 || R || A || M), as ed25519 verifies.
+	ch := sha512.New()
+	ch.Write(dom2(string(req.Context)))
+	ch.Write(st.r.Bytes())
+	ch.Write(groupKey)
+	ch.Write(req.Message)
+	st.challenge, _ = edwards25519.NewScalar().SetUniformBytes(ch.Sum(nil))
+	return st, nil
+}
+
+// commitment returns D_i + rho_i * E_i.
+func (st *signingState) commitment(i int) *edwards25519.Point {
+	p := new(edwards25519.Point).ScalarMult(st.rho[i], st.binding[i])
+	return p.Add(p, st.hiding[i])
+}
+
+// lambda returns the Lagrange coefficient of id over the signers.
+func (st *signingState) lambda(id uint16) *edwards25519.Scalar {
+	x := idScalar(id)
+	num := scalarOne()
+	den := scalarOne()
+	for _, xj := range st.ids {
+		if xj.Equal(x) == 1 {
+			continue
+		}
+		num.Multiply(num, xj)
+		den.Multiply(den, edwards25519.NewScalar().Subtract(xj, x))
+	}
+	return num.Multiply(num, den.Invert(den))
+}
+
+// signedMessage is the message the binding factors commit to: the message,
+// preceded by its Ed25519ctx domain when there is a context.
+func signedMessage(req SigningRequest) []byte {
+	return append(dom2(string(req.Context)), req.Message...)
+}
+
+// dom2 is the RFC 8032 Ed25519ctx prefix, empty for plain Ed25519.
+func dom2(context string) []byte {
+	if context == "" {
+		return nil
+	}
+	b := []byte("SigEd25519 no Ed25519 collisions")
+	b = append(b, 0, byte(len(context)))
+	return append(b, context...)
+}
+
+func h(label string, m []byte) []byte {
+	d := sha512.New()
+	d.Write([]byte(contextString + label))
+	d.Write(m)
+	return d.Sum(nil)
+}
+
+func hScalar(label string, m []byte) *edwards25519.Scalar {
+	s, _ := edwards25519.NewScalar().SetUniformBytes(h(label, m))
+	return s
+}
+
+// nonce derives a nonce from fresh randomness and the secret share, so a
+// weak random source alone does not leak the share (RFC 9591 section 4.1).
+func nonce(secret []byte) (*edwards25519.Scalar, error) {
+	var r [32]byte
+	if _, err := rand.Read(r[:]); err != nil {
+		return nil, err
+	}
+	return hScalar("nonce", append(r[:], secret...)), nil
+}
+
+func randomScalar() (*edwards25519.Scalar, error) {
+	var b [64]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	return edwards25519.NewScalar().SetUniformBytes(b[:])
+}
+
+func idScalar(id uint16) *edwards25519.Scalar {
+	var b [32]byte
+	binary.LittleEndian.PutUint16(b[:], id)
+	s, _ := edwards25519.NewScalar().SetCanonicalBytes(b[:])
+	return s
+}
+
+func scalarOne() *edwards25519.Scalar { return idScalar(1) }
+
+func (share KeyShare) scalar() (*edwards25519.Scalar, error) {
+	if share.ID == 0 || len(share.GroupKey) != ed25519.PublicKeySize {
+		return nil, ErrShare
+	}
+	s, err := edwards25519.NewScalar().SetCanonicalBytes(share.Secret)
+	if err != nil {
+		return nil, ErrShare
+	}
+	return s, nil
+}
+
+func equalCommitment(a, b Commitment) bool {
+	return a.ID == b.ID && string(a.Hiding) == string(b.Hiding) && string(a.Binding) == string(b.Binding)
+}
@@ -0,0 +1,194 @@
+package frost
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/groups"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+// signWith runs both rounds locally with the given shares.
+func signWith(t *testing.T, pub PublicKeyPackage, shares []KeyShare, c identity.Context, msg []byte) ([]byte, error) {
+	t.Helper()
+	req := SigningRequest{Message: msg, Context: c}
+	nonces := make([]*Nonces, len(shares))
+	for i, sh := range shares {
+		n, com, err := sh.Commit()
+		if err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		nonces[i] = n
+		req.Commitments = append(req.Commitments, com)
+	}
+	var sigShares []SignatureShare
+	for i, sh := range shares {
+		s, err := sh.Sign(nonces[i], req)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		sigShares = append(sigShares, s)
+	}
+	return Aggregate(pub, req, sigShares)
+}
+
+func TestThresholdSignatures(t *testing.T) {
+	shares, pub, err := GenerateShares(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("roster v7")
+	for _, set := range [][]KeyShare{{shares[0], shares[1]}, {shares[1], shares[2]}, {shares[0], shares[2]}, shares} {
+		sig, err := signWith(t, pub, set, "", msg)
+		if err != nil {
+			t.Fatalf("Aggregate: %v", err)
+		}
+		if !ed25519.Verify(pub.GroupKey, msg, sig) {
+			t.Fatalf("signature of %d signers does not verify", len(set))
+		}
+	}
+
+	sig, err := signWith(t, pub, shares[1:], identity.ContextGroup, msg)
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	if !identity.VerifyContext(pub.GroupKey, identity.ContextGroup, msg, sig) {
+		t.Fatal("Ed25519ctx signature does not verify")
+	}
+	if identity.VerifyContext(pub.GroupKey, identity.ContextHello, msg, sig) || ed25519.Verify(pub.GroupKey, msg, sig) {
+		t.Fatal("signature verifies for another context")
+	}
+
+	if _, err := signWith(t, pub, shares[:1], "", msg); !errors.Is(err, ErrCommitments) {
+		t.Fatalf("below threshold: err = %v, want ErrCommitments", err)
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	shares, pub, err := SplitKey(kp, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.GroupKey.Equal(kp.PublicKey) {
+		t.Fatal("group key differs from the split identity")
+	}
+	msg := []byte("hello")
+	if _, err := signWith(t, pub, []KeyShare{shares[0], shares[2]}, "", msg); err == nil {
+		t.Fatal("two signers aggregated with threshold three")
+	}
+	sig, err := signWith(t, pub, []KeyShare{shares[0], shares[2], shares[4]}, identity.ContextHello, msg)
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	if !identity.VerifyContext(kp.PublicKey, identity.ContextHello, msg, sig) {
+		t.Fatal("signature does not verify under the identity key")
+	}
+	if _, _, err := SplitKey(kp, 4, 3); !errors.Is(err, ErrThreshold) {
+		t.Fatalf("err = %v, want ErrThreshold", err)
+	}
+}
+
+func TestInvalidShare(t *testing.T) {
+	shares, pub, _ := GenerateShares(2, 2)
+	req := SigningRequest{Message: []byte("m")}
+	n1, c1, _ := shares[0].Commit()
+	n2, c2, _ := shares[1].Commit()
+	req.Commitments = []Commitment{c1, c2}
+	s1, _ := shares[0].Sign(n1, req)
+	s2, _ := shares[1].Sign(n2, req)
+	s2.Z[0] ^= 1
+	if _, err := Aggregate(pub, req, []SignatureShare{s1, s2}); !errors.Is(err, ErrInvalidShare) {
+		t.Fatalf("err = %v, want ErrInvalidShare", err)
+	}
+	if _, err := shares[0].Sign(n1, req); !errors.Is(err, ErrNoncesUsed) {
+		t.Fatalf("reused nonces: err = %v, want ErrNoncesUsed", err)
+	}
+	n3, _, _ := shares[0].Commit()
+	if _, err := shares[0].Sign(n3, req); !errors.Is(err, ErrCommitments) {
+		t.Fatalf("foreign commitment: err = %v, want ErrCommitments", err)
+	}
+}
+
+// startCosigner runs a Cosigner on a new peer listening at addr.
+func startCosigner(ctx context.Context, t *testing.T, network *memory.Network, addr string, c *Cosigner) {
+	t.Helper()
+	kp, _ := identity.GenerateKeyPair()
+	p := i6p.NewPeer(kp, map[string]string{session.StreamProtocolCapability: "1"})
+	ln, err := network.Listen(addr)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	p.Serve(ln)
+	go func() {
+		for {
+			s, err := p.Accept(ctx)
+			if err != nil {
+				return
+			}
+			go func() { _ = c.Serve(ctx, s) }()
+		}
+	}()
+}
+
+func TestSigner(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	shares, pub, _ := GenerateShares(3, 4)
+	network := memory.NewNetwork()
+	refuse := errors.New("not confirmed")
+	startCosigner(ctx, t, network, "phone", &Cosigner{Share: shares[1]})
+	startCosigner(ctx, t, network, "tablet", &Cosigner{Share: shares[2]})
+	startCosigner(ctx, t, network, "watch", &Cosigner{Share: shares[3], Approve: func(identity.PeerID, SigningRequest) error {
+		return refuse
+	}})
+
+	kp, _ := identity.GenerateKeyPair()
+	p := i6p.NewPeer(kp, map[string]string{session.StreamProtocolCapability: "1"})
+	var sessions []*session.Session
+	for _, addr := range []string{"phone", "tablet"} {
+		conn, err := network.Dial(ctx, addr)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		s, err := p.Connect(ctx, conn)
+		if err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	signer := &Signer{Package: pub, Local: &shares[0], Sessions: sessions}
+	g, err := groups.New("family", 1, pub.GroupKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := groups.Update{Group: "family", Version: 1, Op: groups.OpJoin, Member: kp.PeerID()}
+	if err := u.SignWith(signer); err != nil {
+		t.Fatalf("SignWith: %v", err)
+	}
+	if err := g.Apply(u); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	// Without the local share, the refusing watch is needed and signing fails.
+	conn, _ := network.Dial(ctx, "watch")
+	watch, err := p.Connect(ctx, conn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	signer = &Signer{Package: pub, Sessions: append(sessions, watch)}
+	if _, err := signer.SignContext(ctx, "", []byte("m")); !errors.Is(err, ErrRemoteFail) {
+		t.Fatalf("err = %v, want ErrRemoteFail", err)
+	}
+	signer.Sessions = sessions
+	if _, err := signer.SignContext(ctx, "", []byte("m")); !errors.Is(err, ErrNotEnough) {
+		t.Fatalf("err = %v, want ErrNotEnough", err)
+	}
+}
@@ -0,0 +1,355 @@
+package frost
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// ProtocolName tags FROST signing streams (see session.OpenProtocolStream).
+//
+// One signing runs on one stream, so a cosigner's nonces never outlive it:
+//
+//	coordinator -> empty message;           cosigner -> commitment
+//	coordinator -> signing request;         cosigner -> signature share
+const ProtocolName = "i6p/frost/1"
+
+// DefaultSignTimeout bounds a Signer's signing when Timeout is zero.
+const DefaultSignTimeout = 30 * time.Second
+
+// maxMessage bounds one protocol message, which carries the signed message.
+const maxMessage = 1 << 20
+
+var (
+	ErrMessage       = errors.New("frost: malformed message")
+	ErrTooLarge      = errors.New("frost: message too large")
+	ErrRemoteFail    = errors.New("frost: remote failed")
+	ErrNotEnough     = errors.New("frost: not enough cosigners")
+	ErrSignerOptions = errors.New("frost: signing a prehashed message is not supported")
+)
+
+type message struct {
+	Error      string          `json:"error,omitempty"`
+	Commitment *Commitment     `json:"commitment,omitempty"`
+	Request    *SigningRequest `json:"request,omitempty"`
+	Share      *SignatureShare `json:"share,omitempty"`
+}
+
+// Cosigner holds a share on one device and signs for the coordinators that
+// ask it.
+type Cosigner struct {
+	Share KeyShare
+	// Approve decides whether to sign req for the authenticated peer from,
+	// for instance by checking that the message is a roster update the user
+	// confirmed. A non-nil error refuses. Nil approves every request.
+	Approve func(from identity.PeerID, req SigningRequest) error
+}
+
+// Serve answers signing streams on s until the session ends or ctx is done.
+// Streams of other protocols are left to the session's other handlers, and
+// errors go to its stream error hook.
+func (c *Cosigner) Serve(ctx context.Context, s *session.Session) error {
+	return s.ServeProtocol(ctx, ProtocolName, func(st transport.Stream) error {
+		return c.Handle(st, s.RemotePeerID())
+	})
+}
+
+// Handle runs one signing read from rw, coordinated by the authenticated
+// peer from.
+func (c *Cosigner) Handle(rw io.ReadWriter, from identity.PeerID) (err error) {
+	defer i6perrors.Recover(&err, "frost")
+	var m message
+	if err := readMsg(rw, &m); err != nil {
+		return err
+	}
+	nonces, com, err := c.Share.Commit()
+	if err != nil {
+		_ = writeMsg(rw, message{Error: err.Error()})
+		return err
+	}
+	if err := writeMsg(rw, message{Commitment: &com}); err != nil {
+		return err
+	}
+	if err := readMsg(rw, &m); err != nil {
+		return err
+	}
+	if m.Request == nil {
+		err = fmt.Errorf("%w: missing signing request", ErrMessage)
+	} else if c.Approve != nil {
+		err = c.Approve(from, *m.Request)
+	}
+	var share SignatureShare
+	if err == nil {
+		share, err = c.Share.Sign(nonces, *m.Request)
+	}
+	if err != nil {
+		_ = writeMsg(rw, message{Error: err.Error()})
+		return err
+	}
+	return writeMsg(rw, message{Share: &share})
+}
+
+// Signer signs with a group key by coordinating cosigners over sessions. It
+// implements crypto.Signer, so it can stand in wherever an Ed25519 private
+// key signs; pass *ed25519.Options with a Context for Ed25519ctx.
+type Signer struct {
+	Package PublicKeyPackage
+	// Local is this device's share, if it holds one; it then counts towards
+	// the threshold without a round trip.
+	Local *KeyShare
+	// Sessions reach the devices running a Cosigner. Signing asks all of
+	// them and uses the first that commit, so unreachable devices only cost
+	// time when too few others answer.
+	Sessions []*session.Session
+	// Timeout bounds Sign (DefaultSignTimeout if zero). SignContext uses its
+	// context instead.
+	Timeout time.Duration
+}
+
+// Public returns the group key, an ed25519.PublicKey.
+func (s *Signer) Public() crypto.PublicKey { return s.Package.GroupKey }
+
+// Sign signs msg with the group key. opts must be crypto.Hash(0) or an
+// *ed25519.Options without a hash; rand is unused.
+func (s *Signer) Sign(_ io.Reader, msg []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var sigContext identity.Context
+	if o, ok := opts.(*ed25519.Options); ok {
+		if o.Hash != crypto.Hash(0) {
+			return nil, ErrSignerOptions
+		}
+		sigContext = identity.Context(o.Context)
+	} else if opts != nil && opts.HashFunc() != crypto.Hash(0) {
+		return nil, ErrSignerOptions
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSignTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.SignContext(ctx, sigContext, msg)
+}
+
+// cosign is one remote signing in progress.
+type cosign struct {
+	st         io.ReadWriteCloser
+	stop       func() bool
+	commitment Commitment
+}
+
+func (cs cosign) close() {
+	cs.stop()
+	_ = cs.st.Close()
+}
+
+// SignContext signs msg for the purpose c (empty for a plain Ed25519
+// signature) with threshold of the cosigners.
+func (s *Signer) SignContext(ctx context.Context, c identity.Context, msg []byte) ([]byte, error) {
+	need := s.Package.Threshold
+	var commitments []Commitment
+	var localNonces *Nonces
+	if s.Local != nil {
+		nonces, com, err := s.Local.Commit()
+		if err != nil {
+			return nil, err
+		}
+		localNonces = nonces
+		commitments = append(commitments, com)
+		need--
+	}
+
+	cosigns, err := s.commit(ctx, need, commitments)
+	for _, cs := range cosigns {
+		defer cs.close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, cs := range cosigns {
+		commitments = append(commitments, cs.commitment)
+	}
+	slices.SortFunc(commitments, func(a, b Commitment) int { return int(a.ID) - int(b.ID) })
+	req := SigningRequest{Message: msg, Context: c, Commitments: commitments}
+
+	type result struct {
+		share SignatureShare
+		err   error
+	}
+	results := make(chan result, len(cosigns))
+	for _, cs := range cosigns {
+		go func() {
+			var m message
+			err := writeMsg(cs.st, message{Request: &req})
+			if err == nil {
+				err = readMsg(cs.st, &m)
+			}
+			switch {
+			case err != nil:
+			case m.Error != "":
+				err = fmt.Errorf("%w: cosigner %d: %s", ErrRemoteFail, cs.commitment.ID, m.Error)
+			case m.Share == nil:
+				err = fmt.Errorf("%w: missing signature share", ErrMessage)
+			default:
+				results <- result{share: *m.Share}
+				return
+			}
+			results <- result{err: err}
+		}()
+	}
+	var shares []SignatureShare
+	if s.Local != nil {
+		share, err := s.Local.Sign(localNonces, req)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	for range cosigns {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				return nil, r.err
+			}
+			shares = append(shares, r.share)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return Aggregate(s.Package, req, shares)
+}
+
+// commit runs round one with the cosigners and returns need of them, whose
+// identifiers differ from those of have. The streams of the others are
+// closed.
+func (s *Signer) commit(ctx context.Context, need int, have []Commitment) ([]cosign, error) {
+	if need <= 0 {
+		return nil, nil
+	}
+	type result struct {
+		cs  cosign
+		err error
+	}
+	results := make(chan result, len(s.Sessions))
+	for _, sess := range s.Sessions {
+		go func() {
+			cs, err := openCosign(ctx, sess)
+			results <- result{cs, err}
+		}()
+	}
+
+	var chosen []cosign
+	var errs []error
+	seen := map[uint16]bool{}
+	for _, c := range have {
+		seen[c.ID] = true
+	}
+	for received := 1; received <= len(s.Sessions); received++ {
+		r := <-results
+		switch {
+		case r.err != nil:
+			errs = append(errs, r.err)
+		case seen[r.cs.commitment.ID] || s.Package.Shares[r.cs.commitment.ID] == nil:
+			r.cs.close()
+		default:
+			seen[r.cs.commitment.ID] = true
+			chosen = append(chosen, r.cs)
+			if len(chosen) == need {
+				// The slower cosigners are dropped as their answers arrive.
+				go func(left int) {
+					for range left {
+						if r := <-results; r.err == nil {
+							r.cs.close()
+						}
+					}
+				}(len(s.Sessions) - received)
+				return chosen, nil
+			}
+		}
+	}
+	for _, cs := range chosen {
+		cs.close()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.Join(append([]error{fmt.Errorf("%w: %d of %d committed", ErrNotEnough, len(chosen), need)}, errs...)...)
+}
+
+// openCosign opens a signing stream on sess and reads the cosigner's
+// commitment. The stream is bound to ctx until it is closed.
+func openCosign(ctx context.Context, sess *session.Session) (cosign, error) {
+	st, err := sess.OpenProtocolStream(ctx, ProtocolName)
+	if err != nil {
+		return cosign{}, err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = st.SetDeadline(time.Unix(1, 0)) })
+	var m message
+	err = writeMsg(st, message{})
+	if err == nil {
+		err = readMsg(st, &m)
+	}
+	switch {
+	case err != nil:
+	case m.Error != "":
+		err = fmt.Errorf("%w: %s", ErrRemoteFail, m.Error)
+	case m.Commitment == nil:
+		err = fmt.Errorf("%w: missing commitment", ErrMessage)
+	default:
+		return cosign{st: st, stop: stop, commitment: *m.Commitment}, nil
+	}
+	stop()
+	_ = st.Close()
+	if ctx.Err() != nil {
+		return cosign{}, ctx.Err()
+	}
+	return cosign{}, err
+}
+
+// writeMsg sends v as length-prefixed JSON.
+func writeMsg(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMessage {
+		return ErrTooLarge
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readMsg(r io.Reader, v any) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxMessage {
+		return ErrTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMessage, err)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+// Package group provides sender-key encryption for group messaging.
+//
+// Each member owns a sending chain (a symmetric ratchet) and an Ed25519 signing
+// key. The member distributes its chain state to every other member once, over
+// pairwise SecureChannels; afterwards a broadcast costs a single encryption no
+// matter how large the group is. Signatures stop members from forging messages
+// in each other's name, since every member knows every chain key.
+package group
@@ -0,0 +1,82 @@
+package group
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+)
+
+func TestGroupBroadcast(t *testing.T) {
+	groupID := []byte("room-1")
+
+	alice, err := NewSender(groupID)
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+
+	// Alice shares her sender key with Bob over a pairwise channel.
+	a2b, _ := crypto.NewSecureChannelInitiator()
+	b2a, _ := crypto.NewSecureChannelResponder()
+	_ = a2b.Complete(b2a.LocalEphemeralPublic())
+	_ = b2a.Complete(a2b.LocalEphemeralPublic())
+
+	dist, err := alice.DistributeTo(a2b)
+	if err != nil {
+		t.Fatalf("DistributeTo: %v", err)
+	}
+	bob := NewGroup(groupID)
+	if err := bob.AcceptDistribution(b2a, dist); err != nil {
+		t.Fatalf("AcceptDistribution: %v", err)
+	}
+
+	// Carol joins later and only sees messages from that point on.
+	m0, _ := alice.Encrypt([]byte("m0"))
+	carol := NewGroup(groupID)
+	if err := carol.AddSender(alice.Distribution()); err != nil {
+		t.Fatalf("AddSender: %v", err)
+	}
+	m1, _ := alice.Encrypt([]byte("m1"))
+
+	for i, m := range [][]byte{m0, m1} {
+		from, pt, err := bob.Decrypt(m)
+		if err != nil {
+			t.Fatalf("bob Decrypt %d: %v", i, err)
+		}
+		if !bytes.Equal(from, alice.SigningKey()) {
+			t.Fatalf("unexpected sender")
+		}
+		if string(pt) != []string{"m0", "m1"}[i] {
+			t.Fatalf("message %d mismatch", i)
+		}
+	}
+	if _, pt, err := carol.Decrypt(m1); err != nil || string(pt) != "m1" {
+		t.Fatalf("carol Decrypt m1: %v", err)
+	}
+	if _, _, err := carol.Decrypt(m0); err == nil {
+		t.Fatalf("expected late joiner to be unable to read earlier messages")
+	}
+
+	// Tampering breaks the signature.
+	m2, _ := alice.Encrypt([]byte("m2"))
+	m2[40] ^= 0xff
+	if _, _, err := bob.Decrypt(m2); err != ErrBadSignature {
+		t.Fatalf("expected ErrBadSignature, got %v", err)
+	}
+
+	bob.RemoveSender(alice.SigningKey())
+	m3, _ := alice.Encrypt([]byte("m3"))
+	if _, _, err := bob.Decrypt(m3); err != ErrUnknownSender {
+		t.Fatalf("expected ErrUnknownSender, got %v", err)
+	}
+}
+
+func TestDistributionGroupMismatch(t *testing.T) {
+	s, _ := NewSender([]byte("a"))
+	if err := NewGroup([]byte("b")).AddSender(s.Distribution()); err != ErrGroupMismatch {
+		t.Fatalf("expected ErrGroupMismatch, got %v", err)
+	}
+	if _, err := DecodeDistribution([]byte{0, 1}); err != ErrMalformedDistribution {
+		t.Fatalf("expected ErrMalformedDistribution, got %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package group
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+	"github.com/TheusHen/I6P/i6p/crypto/ratchet"
+)
+
+var (
+	ErrUnknownSender         = errors.New("group: unknown sender key")
+	ErrBadSignature          = errors.New("group: invalid message signature")
+	ErrMalformedMessage      = errors.New("group: malformed message")
+	ErrMalformedDistribution = errors.New("group: malformed sender key distribution")
+	ErrGroupMismatch         = errors.New("group: message is for a different group")
+)
+
+// MaxSkip is the out-of-order tolerance per sender chain.
+const MaxSkip = 1000
+
+// Distribution carries one member's sender key to another member.
+// It must only ever be sent over an authenticated, encrypted pairwise channel.
+//
+// Format:
+//
+//	2 bytes: group ID length
+//	N bytes: group ID
+//	1 byte: derivation version
+//	8 bytes: generation
+//	32 bytes: chain key
+//	32 bytes: signing public key
+type Distribution struct {
+	GroupID    []byte
+	Version    uint8
+	Generation uint64
+	ChainKey   [32]byte
+	SigningKey ed25519.PublicKey
+}
+
+// Encode serializes the distribution message.
+func (d Distribution) Encode() []byte {
+	out := make([]byte, 0, 2+len(d.GroupID)+1+8+32+ed25519.PublicKeySize)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(d.GroupID)))
+	out = append(out, d.GroupID...)
+	out = append(out, d.Version)
+	out = binary.BigEndian.AppendUint64(out, d.Generation)
+	out = append(out, d.ChainKey[:]...)
+	return append(out, d.SigningKey...)
+}
+
+// DecodeDistribution parses a distribution message.
+func DecodeDistribution(b []byte) (Distribution, error) {
+	if len(b) < 2 {
+		return Distribution{}, ErrMalformedDistribution
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) != n+1+8+32+ed25519.PublicKeySize {
+		return Distribution{}, ErrMalformedDistribution
+	}
+	d := Distribution{GroupID: append([]byte(nil), b[:n]...)}
+	b = b[n:]
+	d.Version = b[0]
+	d.Generation = binary.BigEndian.Uint64(b[1:9])
+	copy(d.ChainKey[:], b[9:41])
+	d.SigningKey = append(ed25519.PublicKey(nil), b[41:]...)
+	return d, nil
+}
+
+// Sender is the local member's sending side.
+type Sender struct {
+	groupID []byte
+	chain   *ratchet.Chain
+	pub     ed25519.PublicKey
+	priv    ed25519.PrivateKey
+}
+
+// NewSender creates a fresh sender key for the group.
+func NewSender(groupID []byte) (*Sender, error) {
+	var chainKey [32]byte
+	if _, err := io.ReadFull(rand.Reader, chainKey[:]); err != nil {
+		return nil, err
+	}
+	chain, err := ratchet.NewChain(chainKey[:])
+	if err != nil {
+		return nil, err
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Sender{
+		groupID: append([]byte(nil), groupID...),
+		chain:   chain,
+		pub:     pub,
+		priv:    priv,
+	}, nil
+}
+
+// Distribution returns the current sender key state for new members.
+// Members receiving it can decrypt messages from the current generation onward,
+// but not earlier ones.
+func (s *Sender) Distribution() Distribution {
+	chainKey, gen := s.chain.Export()
+	return Distribution{
+		GroupID:    append([]byte(nil), s.groupID...),
+		Version:    s.chain.Version(),
+		Generation: gen,
+		ChainKey:   chainKey,
+		SigningKey: append(ed25519.PublicKey(nil), s.pub...),
+	}
+}
+
+// DistributeTo encrypts the current Distribution for one member over a pairwise channel.
+func (s *Sender) DistributeTo(ch *crypto.SecureChannel) ([]byte, error) {
+	return ch.Encrypt(s.Distribution().Encode(), []byte("i6p-group-distribution"))
+}
+
+// SigningKey returns the public key identifying this sender within the group.
+func (s *Sender) SigningKey() ed25519.PublicKey { return s.pub }
+
+// Encrypt encrypts and signs a message for the whole group.
+//
+// Format:
+//
+//	32 bytes: sender signing key
+//	N bytes: ratchet message (header + ciphertext)
+//	64 bytes: Ed25519 signature over everything before it
+func (s *Sender) Encrypt(plaintext []byte) ([]byte, error) {
+	em, err := s.chain.Seal(plaintext, s.groupID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, ed25519.PublicKeySize+ratchet.HeaderSize+len(em.Ciphertext)+ed25519.SignatureSize)
+	out = append(out, s.pub...)
+	out = append(out, em.Encode()...)
+	return append(out, ed25519.Sign(s.priv, out)...), nil
+}
+
+// Group holds the sender keys of the other members and decrypts their messages.
+type Group struct {
+	mu      sync.Mutex
+	groupID []byte
+	members map[[32]byte]*member
+}
+
+type member struct {
+	pub      ed25519.PublicKey
+	receiver *ratchet.Receiver
+}
+
+// NewGroup creates an empty receive-side view of a group.
+func NewGroup(groupID []byte) *Group {
+	return &Group{
+		groupID: append([]byte(nil), groupID...),
+		members: make(map[[32]byte]*member),
+	}
+}
+
+// AddSender installs a member's sender key. Re-adding a key replaces its state.
+func (g *Group) AddSender(d Distribution) error {
+	if !crypto.Equal(d.GroupID, g.groupID) {
+		return ErrGroupMismatch
+	}
+	if len(d.SigningKey) != ed25519.PublicKeySize {
+		return ErrMalformedDistribution
+	}
+	r, err := ratchet.NewReceiverFromState(d.ChainKey, d.Generation, MaxSkip, ratchet.ChainOptions{Version: d.Version})
+	if err != nil {
+		return err
+	}
+	var id [32]byte
+	copy(id[:], d.SigningKey)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.members[id] = &member{pub: append(ed25519.PublicKey(nil), d.SigningKey...), receiver: r}
+	return nil
+}
+
+// AcceptDistribution decrypts a distribution sent via Sender.DistributeTo and installs it.
+func (g *Group) AcceptDistribution(ch *crypto.SecureChannel, data []byte) error {
+	plain, err := ch.Decrypt(data, []byte("i6p-group-distribution"))
+	if err != nil {
+		return err
+	}
+	d, err := DecodeDistribution(plain)
+	if err != nil {
+		return err
+	}
+	return g.AddSender(d)
+}
+
+// RemoveSender forgets a member's sender key, e.g. when it leaves the group.
+// Remaining members should also rotate their own sender keys.
+func (g *Group) RemoveSender(signingKey ed25519.PublicKey) {
+	var id [32]byte
+	copy(id[:], signingKey)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.members, id)
+}
+
+// Decrypt verifies and decrypts a group message from any known member.
+// It returns the sender's signing key alongside the plaintext.
+func (g *Group) Decrypt(data []byte) (ed25519.PublicKey, []byte, error) {
+	if len(data) < ed25519.PublicKeySize+ratchet.HeaderSize+ed25519.SignatureSize {
+		return nil, nil, ErrMalformedMessage
+	}
+	var id [32]byte
+	copy(id[:], data[:ed25519.PublicKeySize])
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	m, ok := g.members[id]
+	if !ok {
+		return nil, nil, ErrUnknownSender
+	}
+
+	signed := data[:len(data)-ed25519.SignatureSize]
+	sig := data[len(data)-ed25519.SignatureSize:]
+	if !ed25519.Verify(m.pub, signed, sig) {
+		return nil, nil, ErrBadSignature
+	}
+
+	em, err := ratchet.DecodeEncryptedMessage(signed[ed25519.PublicKeySize:])
+	if err != nil {
+		return nil, nil, err
+	}
+	pt, err := m.receiver.Open(em, g.groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m.pub, pt, nil
+}
@@ -0,0 +1,173 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPadding       = errors.New("crypto: invalid message padding")
+	ErrInvalidPaddingPolicy = errors.New("crypto: invalid padding policy")
+)
+
+// PaddingCapability is the capability key used to advertise a padding policy,
+// in its String form, in the session HELLO. Sessions negotiate the policy of
+// their secure channels from it (see session.Session.Padding).
+const PaddingCapability = "i6p.padding"
+
+// MaxPaddingSize bounds the Size of a padding policy, the same as
+// protocol.MaxFramePayload, so a peer advertising a huge size cannot make
+// every message allocate that much.
+const MaxPaddingSize = 1 << 20
+
+// PaddingMode selects how plaintexts are padded before encryption.
+type PaddingMode uint8
+
+const (
+	// PaddingNone leaves message sizes untouched.
+	PaddingNone PaddingMode = iota
+	// PaddingBucket rounds each message up to the next power-of-two multiple of Size.
+	PaddingBucket
+	// PaddingConstant pads every message to Size bytes (larger messages are
+	// rounded up to a multiple of Size).
+	PaddingConstant
+)
+
+func (m PaddingMode) String() string {
+	switch m {
+	case PaddingNone:
+		return "none"
+	case PaddingBucket:
+		return "bucket"
+	case PaddingConstant:
+		return "constant"
+	default:
+		return "unknown"
+	}
+}
+
+// PaddingPolicy hides plaintext lengths from on-path observers.
+// Padding uses the ISO/IEC 7816-4 scheme: a single 0x80 byte followed by zeros,
+// so it can always be removed unambiguously.
+type PaddingPolicy struct {
+	Mode PaddingMode
+	Size int // bucket granularity or constant size, in bytes
+}
+
+// ParsePaddingPolicy parses a capability value such as "bucket/256" or
+// "constant/1024". Sizes above MaxPaddingSize are rejected.
+func ParsePaddingPolicy(s string) (PaddingPolicy, error) {
+	if s == "" || s == "none" {
+		return PaddingPolicy{}, nil
+	}
+	mode, size, ok := strings.Cut(s, "/")
+	if !ok {
+		return PaddingPolicy{}, ErrInvalidPaddingPolicy
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil || n <= 0 || n > MaxPaddingSize {
+		return PaddingPolicy{}, ErrInvalidPaddingPolicy
+	}
+	switch mode {
+	case "bucket":
+		return PaddingPolicy{Mode: PaddingBucket, Size: n}, nil
+	case "constant":
+		return PaddingPolicy{Mode: PaddingConstant, Size: n}, nil
+	default:
+		return PaddingPolicy{}, ErrInvalidPaddingPolicy
+	}
+}
+
+// String returns the capability encoding of the policy.
+func (p PaddingPolicy) String() string {
+	if p.Mode == PaddingNone || p.Size <= 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%s/%d", p.Mode, p.Size)
+}
+
+// NegotiatePadding combines the local policy with the one advertised by the peer.
+// The result is symmetric, so both sides arrive at the same policy: padding is
+// disabled unless both sides enable it, otherwise the stronger mode and the
+// larger size win. A policy with a size above MaxPaddingSize disables it.
+func NegotiatePadding(local, remote PaddingPolicy) PaddingPolicy {
+	if local.Mode == PaddingNone || remote.Mode == PaddingNone || local.Size <= 0 || remote.Size <= 0 {
+		return PaddingPolicy{}
+	}
+	if local.Size > MaxPaddingSize || remote.Size > MaxPaddingSize {
+		return PaddingPolicy{}
+	}
+	out := local
+	if remote.Mode > out.Mode {
+		out.Mode = remote.Mode
+	}
+	if remote.Size > out.Size {
+		out.Size = remote.Size
+	}
+	return out
+}
+
+// NegotiatePaddingCapabilities negotiates padding from HELLO capability maps.
+// Unparseable values are treated as no padding.
+func NegotiatePaddingCapabilities(local, remote map[string]string) PaddingPolicy {
+	l, err := ParsePaddingPolicy(local[PaddingCapability])
+	if err != nil {
+		return PaddingPolicy{}
+	}
+	r, err := ParsePaddingPolicy(remote[PaddingCapability])
+	if err != nil {
+		return PaddingPolicy{}
+	}
+	return NegotiatePadding(l, r)
+}
+
+// PaddedLen returns the padded length for a plaintext of n bytes.
+func (p PaddingPolicy) PaddedLen(n int) int {
+	if p.Mode == PaddingNone || p.Size <= 0 {
+		return n
+	}
+	need := n + 1 // room for the 0x80 marker
+	target := p.Size
+	switch p.Mode {
+	case PaddingBucket:
+		for target < need {
+			target *= 2
+		}
+	case PaddingConstant:
+		if need > target {
+			target = (need + p.Size - 1) / p.Size * p.Size
+		}
+	}
+	return target
+}
+
+// Pad returns plaintext padded according to the policy.
+func (p PaddingPolicy) Pad(plaintext []byte) []byte {
+	if p.Mode == PaddingNone || p.Size <= 0 {
+		return plaintext
+	}
+	out := make([]byte, p.PaddedLen(len(plaintext)))
+	copy(out, plaintext)
+	out[len(plaintext)] = 0x80
+	return out
+}
+
+// Unpad strips padding added by Pad.
+func (p PaddingPolicy) Unpad(padded []byte) ([]byte, error) {
+	if p.Mode == PaddingNone || p.Size <= 0 {
+		return padded, nil
+	}
+	for i := len(padded) - 1; i >= 0; i-- {
+		switch padded[i] {
+		case 0x00:
+			continue
+		case 0x80:
+			return padded[:i], nil
+		default:
+			return nil, ErrInvalidPadding
+		}
+	}
+	return nil, ErrInvalidPadding
+}
@@ -6,7 +6,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
-	"sync/atomic"
+	"sync"
 
 	"golang.org/x/crypto/chacha20poly1305"
 )
@@ -14,18 +14,41 @@ import (
 var (
 	ErrCiphertextTooShort = errors.New("ratchet: ciphertext too short")
 	ErrDecryptionFailed   = errors.New("ratchet: decryption failed")
+	ErrKeyExpired         = errors.New("ratchet: key usage limit reached")
 )
 
+// UsageLimits bounds how much data a single message key may protect.
+// Zero fields mean "no limit".
+type UsageLimits struct {
+	MaxMessages uint64
+	MaxBytes    uint64
+}
+
+// DefaultUsageLimits are the limits of keys created by a Chain unless
+// Chain.SetUsageLimits says otherwise. They sit well below the forgery and
+// nonce bounds of ChaCha20-Poly1305, so keys retire early rather than late.
+var DefaultUsageLimits = UsageLimits{MaxMessages: 1 << 48, MaxBytes: 1 << 60}
+
 // AEAD wraps ChaCha20-Poly1305 with automatic nonce management.
 // It uses a 64-bit counter + 32-bit random prefix for the 96-bit nonce.
+// Sealing fails with ErrKeyExpired once the key's usage limits are reached.
 type AEAD struct {
 	aead   cipher.AEAD
 	prefix [4]byte
-	seq    atomic.Uint64
+	limits UsageLimits
+
+	mu     sync.Mutex
+	seq    uint64
+	sealed uint64 // plaintext bytes sealed
 }
 
-// NewAEAD creates a new AEAD cipher from a 32-byte key.
+// NewAEAD creates a new AEAD cipher from a 32-byte key with the default
+// usage limits.
 func NewAEAD(key []byte) (*AEAD, error) {
+	return newAEAD(key, DefaultUsageLimits)
+}
+
+func newAEAD(key []byte, limits UsageLimits) (*AEAD, error) {
 	if len(key) != chacha20poly1305.KeySize {
 		return nil, errors.New("ratchet: invalid key size for ChaCha20-Poly1305")
 	}
@@ -33,30 +56,52 @@ func NewAEAD(key []byte) (*AEAD, error) {
 	if err != nil {
 		return nil, err
 	}
-	a := &AEAD{aead: aead}
+	a := &AEAD{aead: aead, limits: limits}
 	if _, err := io.ReadFull(rand.Reader, a.prefix[:]); err != nil {
 		return nil, err
 	}
 	return a, nil
 }
 
-func (a *AEAD) nextNonce() []byte {
-	seq := a.seq.Add(1)
-	nonce := make([]byte, chacha20poly1305.NonceSize) // 12 bytes
+// reserve accounts for one message of n plaintext bytes and returns its
+// nonce, or fails with ErrKeyExpired if that would pass the usage limits.
+func (a *AEAD) reserve(n int) ([chacha20poly1305.NonceSize]byte, error) {
+	var nonce [chacha20poly1305.NonceSize]byte
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.limits.MaxMessages != 0 && a.seq >= a.limits.MaxMessages ||
+		a.limits.MaxBytes != 0 && a.sealed+uint64(n) > a.limits.MaxBytes {
+		return nonce, ErrKeyExpired
+	}
+	a.seq++
+	a.sealed += uint64(n)
 	copy(nonce[:4], a.prefix[:])
-	binary.BigEndian.PutUint64(nonce[4:], seq)
-	return nonce
+	binary.BigEndian.PutUint64(nonce[4:], a.seq)
+	return nonce, nil
 }
 
 // Seal encrypts and authenticates plaintext.
 // Returns: nonce (12 bytes) || ciphertext || tag (16 bytes)
-func (a *AEAD) Seal(plaintext, additionalData []byte) []byte {
-	nonce := a.nextNonce()
-	ciphertext := a.aead.Seal(nil, nonce, plaintext, additionalData)
-	out := make([]byte, len(nonce)+len(ciphertext))
-	copy(out, nonce)
-	copy(out[len(nonce):], ciphertext)
-	return out
+func (a *AEAD) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	return a.SealAppend(nil, plaintext, additionalData)
+}
+
+// SealAppend is like Seal but appends nonce || ciphertext || tag to dst,
+// reusing its capacity when possible.
+func (a *AEAD) SealAppend(dst, plaintext, additionalData []byte) ([]byte, error) {
+	nonce, err := a.reserve(len(plaintext))
+	if err != nil {
+		return dst, err
+	}
+	dst = append(dst, nonce[:]...)
+	return a.aead.Seal(dst, nonce[:], plaintext, additionalData), nil
+}
+
+// Usage returns the number of messages and plaintext bytes sealed so far.
+func (a *AEAD) Usage() (messages, bytes uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.seq, a.sealed
 }
 
 // Open decrypts and verifies ciphertext.
@@ -1,20 +1,24 @@
 package ratchet
 
 import (
-	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"sync"
+	"time"
 )
 
 var (
 	ErrRatchetExhausted  = errors.New("ratchet: maximum generation reached")
 	ErrInvalidGeneration = errors.New("ratchet: invalid generation number")
+	ErrReplayedMessage   = errors.New("ratchet: replayed message")
+	ErrVersionMismatch   = errors.New("ratchet: message derivation version does not match the receiver")
 )
 
 const (
 	// MaxGeneration is the maximum number of ratchet steps before re-keying is required.
 	MaxGeneration = 1 << 32
+	// MaxBatchCounter is the largest intra-batch counter that fits in the message header.
+	MaxBatchCounter = 1<<24 - 1
 )
 
 // Chain is a symmetric key ratchet for forward secrecy.
@@ -23,36 +27,77 @@ type Chain struct {
 	mu         sync.Mutex
 	chainKey   [32]byte
 	generation uint64
+	version    uint8
+	salt       []byte
+	limits     UsageLimits
+
+	policy     BatchPolicy
+	batchAEAD  *AEAD
+	batchGen   uint64
+	batchCount uint32
+	batchStart time.Time
 }
 
-// NewChain creates a new ratchet chain from an initial 32-byte key.
+// BatchPolicy lets a Chain reuse one message key for several messages,
+// trading a little forward-secrecy granularity for far fewer chain steps at
+// very high message rates. The zero value steps the ratchet for every message.
+type BatchPolicy struct {
+	Messages int           // messages per key; 0 means no count bound
+	Interval time.Duration // maximum key lifetime; 0 means no time bound
+}
+
+func (p BatchPolicy) enabled() bool {
+	return p.Messages > 1 || p.Interval > 0
+}
+
+// SetBatchPolicy configures per-batch key advancement. The receiving side must
+// enable a batch window (Receiver.SetBatchWindow) to accept batched messages.
+func (c *Chain) SetBatchPolicy(p BatchPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = p
+	c.batchAEAD = nil
+}
+
+// ChainOptions configures key derivation for a Chain or Receiver.
+type ChainOptions struct {
+	Version uint8  // derivation version (default CurrentVersion via NewChain)
+	Salt    []byte // optional per-session HKDF salt; both sides must agree
+}
+
+// NewChain creates a new ratchet chain from an initial 32-byte key
+// using the current derivation version and no salt.
 func NewChain(initialKey []byte) (*Chain, error) {
+	return NewChainWithOptions(initialKey, ChainOptions{Version: CurrentVersion})
+}
+
+// NewChainWithOptions creates a new ratchet chain with explicit derivation options.
+func NewChainWithOptions(initialKey []byte, opts ChainOptions) (*Chain, error) {
 	if len(initialKey) != 32 {
 		return nil, errors.New("ratchet: initial key must be 32 bytes")
 	}
-	c := &Chain{}
+	if opts.Version > CurrentVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	c := &Chain{version: opts.Version, salt: append([]byte(nil), opts.Salt...), limits: DefaultUsageLimits}
 	copy(c.chainKey[:], initialKey)
 	return c, nil
 }
 
-// deriveKeys derives (nextChainKey, messageKey) from the current chain key.
-func (c *Chain) deriveKeys() ([32]byte, [32]byte) {
-	// Use HKDF-like expansion with SHA-256
-	// chainKey || 0x01 -> messageKey
-	// chainKey || 0x02 -> nextChainKey
-	h1 := sha256.New()
-	h1.Write(c.chainKey[:])
-	h1.Write([]byte{0x01})
-	var messageKey [32]byte
-	copy(messageKey[:], h1.Sum(nil))
+// SetUsageLimits sets the usage limits of the message keys the chain
+// creates from now on. Seal steps to a new key when its key reaches them.
+func (c *Chain) SetUsageLimits(l UsageLimits) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limits = l
+}
 
-	h2 := sha256.New()
-	h2.Write(c.chainKey[:])
-	h2.Write([]byte{0x02})
-	var nextChainKey [32]byte
-	copy(nextChainKey[:], h2.Sum(nil))
+// Version returns the derivation version used by this chain.
+func (c *Chain) Version() uint8 { return c.version }
 
-	return nextChainKey, messageKey
+// deriveKeys derives (nextChainKey, messageKey) from the current chain key.
+func (c *Chain) deriveKeys() ([32]byte, [32]byte, error) {
+	return deriveKeys(c.version, c.salt, c.chainKey)
 }
 
 // Step advances the ratchet and returns an AEAD cipher for the current message.
@@ -60,12 +105,18 @@ func (c *Chain) deriveKeys() ([32]byte, [32]byte) {
 func (c *Chain) Step() (*AEAD, uint64, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.stepLocked()
+}
 
+func (c *Chain) stepLocked() (*AEAD, uint64, error) {
 	if c.generation >= MaxGeneration {
 		return nil, 0, ErrRatchetExhausted
 	}
 
-	nextChain, msgKey := c.deriveKeys()
+	nextChain, msgKey, err := c.deriveKeys()
+	if err != nil {
+		return nil, 0, err
+	}
 	gen := c.generation
 
 	// Advance chain
@@ -74,13 +125,40 @@ func (c *Chain) Step() (*AEAD, uint64, error) {
 
 	// Zeroize old key material is automatic since we replaced it
 
-	aead, err := NewAEAD(msgKey[:])
+	aead, err := newAEAD(msgKey[:], c.limits)
 	if err != nil {
 		return nil, 0, err
 	}
 	return aead, gen, nil
 }
 
+// StepBatch advances the ratchet n times under a single lock and returns one AEAD
+// per step. The first AEAD belongs to generation first; the rest follow consecutively.
+func (c *Chain) StepBatch(n int) (aeads []*AEAD, first uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation+uint64(n) > MaxGeneration {
+		return nil, 0, ErrRatchetExhausted
+	}
+
+	first = c.generation
+	aeads = make([]*AEAD, n)
+	for i := range aeads {
+		nextChain, msgKey, err := c.deriveKeys()
+		if err != nil {
+			return nil, 0, err
+		}
+		c.chainKey = nextChain
+		c.generation++
+		aeads[i], err = newAEAD(msgKey[:], c.limits)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return aeads, first, nil
+}
+
 // Generation returns the current generation number.
 func (c *Chain) Generation() uint64 {
 	c.mu.Lock()
@@ -98,18 +176,80 @@ func (c *Chain) Export() (chainKey [32]byte, generation uint64) {
 
 // EncryptedMessage represents a ratcheted encrypted message.
 type EncryptedMessage struct {
+	Version    uint8 // key derivation version
 	Generation uint64
+	Counter    uint32 // position within a batch; always 0 without a BatchPolicy
 	Ciphertext []byte
 }
 
 // Seal encrypts plaintext, advances the ratchet, and returns the encrypted message.
+// With a BatchPolicy the ratchet only advances once the current batch is full or
+// its interval has elapsed, or its key reached its usage limits.
 func (c *Chain) Seal(plaintext, ad []byte) (EncryptedMessage, error) {
-	aead, gen, err := c.Step()
+	aead, gen, counter, err := c.nextSealer(nil)
+	if err != nil {
+		return EncryptedMessage{}, err
+	}
+	msg := EncryptedMessage{Version: c.version, Generation: gen, Counter: counter}
+	ct, err := aead.Seal(plaintext, msg.additionalData(ad))
+	if err == ErrKeyExpired {
+		// The batch key is used up: retire it and seal under a fresh one.
+		// A fresh key that cannot take the message fails for good.
+		if aead, gen, counter, err = c.nextSealer(aead); err != nil {
+			return EncryptedMessage{}, err
+		}
+		msg = EncryptedMessage{Version: c.version, Generation: gen, Counter: counter}
+		ct, err = aead.Seal(plaintext, msg.additionalData(ad))
+	}
 	if err != nil {
 		return EncryptedMessage{}, err
 	}
-	ct := aead.Seal(plaintext, ad)
-	return EncryptedMessage{Generation: gen, Ciphertext: ct}, nil
+	msg.Ciphertext = ct
+	return msg, nil
+}
+
+// additionalData returns the AEAD additional data m is sealed under. Batched
+// messages share a key, so for them the header is authenticated along with
+// ad: otherwise a rewritten counter would replay a message past the
+// receiver's duplicate check. The first message of a key, with counter 0, is
+// sealed under ad alone, as messages of unbatched chains always are; its
+// generation and version are already bound by the key it is sealed under.
+func (m EncryptedMessage) additionalData(ad []byte) []byte {
+	if m.Counter == 0 {
+		return ad
+	}
+	return append(m.AppendHeader(make([]byte, 0, HeaderSize+len(ad))), ad...)
+}
+
+// nextSealer returns the key for the next message. If expired is the
+// current batch key, it is retired first.
+func (c *Chain) nextSealer(expired *AEAD) (*AEAD, uint64, uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if expired != nil && expired == c.batchAEAD {
+		c.batchAEAD = nil
+	}
+	if !c.policy.enabled() {
+		aead, gen, err := c.stepLocked()
+		return aead, gen, 0, err
+	}
+
+	full := c.policy.Messages > 0 && int(c.batchCount) >= c.policy.Messages
+	stale := c.policy.Interval > 0 && time.Since(c.batchStart) >= c.policy.Interval
+	if c.batchAEAD == nil || full || stale || c.batchCount > MaxBatchCounter {
+		aead, gen, err := c.stepLocked()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		c.batchAEAD = aead
+		c.batchGen = gen
+		c.batchCount = 0
+		c.batchStart = time.Now()
+	}
+	counter := c.batchCount
+	c.batchCount++
+	return c.batchAEAD, c.batchGen, counter, nil
 }
 
 // Receiver manages decryption with out-of-order tolerance.
@@ -119,69 +259,140 @@ type Receiver struct {
 	current    [32]byte
 	currentGen uint64
 	maxSkip    int
+
+	version     uint8 // derivation version; messages declaring another are rejected
+	salt        []byte
+	batchWindow int
+	batchKeys   map[uint64]*batchKey // message keys retained for batched generations
+}
+
+type batchKey struct {
+	key  [32]byte
+	seen map[uint32]struct{}
+}
+
+// SetBatchWindow enables reception of batched messages (see BatchPolicy).
+// Message keys for the last window generations are retained so that later
+// messages of the same batch can still be opened; replays are rejected.
+// A window of 0 restores strict one-key-per-message behaviour.
+func (r *Receiver) SetBatchWindow(window int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batchWindow = window
+	if window <= 0 {
+		r.batchKeys = nil
+	} else if r.batchKeys == nil {
+		r.batchKeys = make(map[uint64]*batchKey)
+	}
+}
+
+func (r *Receiver) rememberBatchKey(gen uint64, msgKey [32]byte, counter uint32) {
+	if r.batchWindow <= 0 {
+		return
+	}
+	r.batchKeys[gen] = &batchKey{key: msgKey, seen: map[uint32]struct{}{counter: {}}}
+	for g := range r.batchKeys {
+		if g+uint64(r.batchWindow) < r.currentGen {
+			delete(r.batchKeys, g)
+		}
+	}
 }
 
-// NewReceiver creates a receiver ratchet from the initial key.
+// NewReceiver creates a receiver ratchet from the initial key for messages
+// sealed with the current derivation version and no salt.
 func NewReceiver(initialKey []byte, maxSkip int) (*Receiver, error) {
+	return NewReceiverWithOptions(initialKey, maxSkip, ChainOptions{Version: CurrentVersion})
+}
+
+// NewReceiverWithOptions creates a receiver ratchet with explicit derivation
+// options, which must match the sending Chain's. The version is pinned: the
+// version a message declares is not authenticated, so messages declaring
+// another one are rejected rather than opened under it.
+func NewReceiverWithOptions(initialKey []byte, maxSkip int, opts ChainOptions) (*Receiver, error) {
 	if len(initialKey) != 32 {
 		return nil, errors.New("ratchet: initial key must be 32 bytes")
 	}
+	if opts.Version > CurrentVersion {
+		return nil, ErrUnsupportedVersion
+	}
 	r := &Receiver{
 		chains:  make(map[uint64][32]byte),
 		maxSkip: maxSkip,
+		version: opts.Version,
+		salt:    append([]byte(nil), opts.Salt...),
 	}
 	copy(r.current[:], initialKey)
 	return r, nil
 }
 
-func deriveKeysStatic(chainKey [32]byte) ([32]byte, [32]byte) {
-	h1 := sha256.New()
-	h1.Write(chainKey[:])
-	h1.Write([]byte{0x01})
-	var messageKey [32]byte
-	copy(messageKey[:], h1.Sum(nil))
-
-	h2 := sha256.New()
-	h2.Write(chainKey[:])
-	h2.Write([]byte{0x02})
-	var nextChainKey [32]byte
-	copy(nextChainKey[:], h2.Sum(nil))
-
-	return nextChainKey, messageKey
+// NewReceiverFromState creates a receiver positioned at a chain state previously
+// obtained from Chain.Export, so it can open messages from that generation on.
+func NewReceiverFromState(chainKey [32]byte, generation uint64, maxSkip int, opts ChainOptions) (*Receiver, error) {
+	r, err := NewReceiverWithOptions(chainKey[:], maxSkip, opts)
+	if err != nil {
+		return nil, err
+	}
+	r.currentGen = generation
+	return r, nil
 }
 
+// Version returns the derivation version the receiver accepts.
+func (r *Receiver) Version() uint8 { return r.version }
+
 // Open decrypts an encrypted message, handling out-of-order delivery.
+// The receiver's state only changes once the message has authenticated, so
+// a forged message cannot move it.
 func (r *Receiver) Open(msg EncryptedMessage, ad []byte) ([]byte, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if msg.Version != r.version {
+		return nil, ErrVersionMismatch
+	}
 	gen := msg.Generation
 
+	// Later message of a batch whose key we already hold.
+	if bk, ok := r.batchKeys[gen]; ok {
+		if _, dup := bk.seen[msg.Counter]; dup {
+			return nil, ErrReplayedMessage
+		}
+		pt, err := openWithKey(bk.key, msg, ad)
+		if err != nil {
+			return nil, err
+		}
+		bk.seen[msg.Counter] = struct{}{}
+		return pt, nil
+	}
+
 	// Expected next message in-order.
 	if gen == r.currentGen {
-		nextChain, msgKey := deriveKeysStatic(r.current)
-		aead, err := NewAEAD(msgKey[:])
+		nextChain, msgKey, err := deriveKeys(r.version, r.salt, r.current)
 		if err != nil {
 			return nil, err
 		}
-		pt, err := aead.Open(msg.Ciphertext, ad)
+		pt, err := openWithKey(msgKey, msg, ad)
 		if err != nil {
 			return nil, err
 		}
 		r.current = nextChain
 		r.currentGen++
+		r.rememberBatchKey(gen, msgKey, msg.Counter)
 		return pt, nil
 	}
 
 	// Check if we have a cached key for this generation
 	if cachedKey, ok := r.chains[gen]; ok {
-		_, msgKey := deriveKeysStatic(cachedKey)
-		aead, err := NewAEAD(msgKey[:])
+		_, msgKey, err := deriveKeys(r.version, r.salt, cachedKey)
+		if err != nil {
+			return nil, err
+		}
+		pt, err := openWithKey(msgKey, msg, ad)
 		if err != nil {
 			return nil, err
 		}
 		delete(r.chains, gen)
-		return aead.Open(msg.Ciphertext, ad)
+		r.rememberBatchKey(gen, msgKey, msg.Counter)
+		return pt, nil
 	}
 
 	// Message is from the future; need to skip ahead
@@ -190,44 +401,82 @@ func (r *Receiver) Open(msg EncryptedMessage, ad []byte) ([]byte, error) {
 		if skip > r.maxSkip {
 			return nil, ErrInvalidGeneration
 		}
-		// Cache intermediate keys
+		// Derive the skipped chain keys aside; they are cached only if the
+		// message opens.
+		skipped := make([][32]byte, 0, skip)
 		chainKey := r.current
 		for i := r.currentGen; i < gen; i++ {
-			nextChain, _ := deriveKeysStatic(chainKey)
-			r.chains[i] = chainKey
+			nextChain, _, err := deriveKeys(r.version, r.salt, chainKey)
+			if err != nil {
+				return nil, err
+			}
+			skipped = append(skipped, chainKey)
 			chainKey = nextChain
 		}
 		// Now chainKey is at generation `gen`
-		nextChain, msgKey := deriveKeysStatic(chainKey)
-		r.current = nextChain
-		r.currentGen = gen + 1
-
-		aead, err := NewAEAD(msgKey[:])
+		nextChain, msgKey, err := deriveKeys(r.version, r.salt, chainKey)
+		if err != nil {
+			return nil, err
+		}
+		pt, err := openWithKey(msgKey, msg, ad)
 		if err != nil {
 			return nil, err
 		}
-		return aead.Open(msg.Ciphertext, ad)
+		for i, k := range skipped {
+			r.chains[r.currentGen+uint64(i)] = k
+		}
+		r.current = nextChain
+		r.currentGen = gen + 1
+		r.rememberBatchKey(gen, msgKey, msg.Counter)
+		return pt, nil
 	}
 
 	// Message is from the past and we don't have the key
 	return nil, ErrInvalidGeneration
 }
 
+func openWithKey(msgKey [32]byte, msg EncryptedMessage, ad []byte) ([]byte, error) {
+	aead, err := NewAEAD(msgKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(msg.Ciphertext, msg.additionalData(ad))
+}
+
+// HeaderSize is the size of the EncryptedMessage wire header.
+const HeaderSize = 8
+
 // Encode serializes an EncryptedMessage for wire transmission.
+// Generations never exceed MaxGeneration (2^32), so the version and batch
+// counter share the upper 32 bits of the 8-byte header. Legacy senders wrote a
+// plain 64-bit generation there, which decodes as version 0, counter 0.
+//
+//	1 byte: derivation version
+//	3 bytes: batch counter
+//	4 bytes: generation
+//	N bytes: ciphertext
 func (m EncryptedMessage) Encode() []byte {
-	out := make([]byte, 8+len(m.Ciphertext))
-	binary.BigEndian.PutUint64(out[:8], m.Generation)
-	copy(out[8:], m.Ciphertext)
-	return out
+	out := make([]byte, 0, HeaderSize+len(m.Ciphertext))
+	out = m.AppendHeader(out)
+	return append(out, m.Ciphertext...)
+}
+
+// AppendHeader appends the 8-byte wire header of m to dst.
+func (m EncryptedMessage) AppendHeader(dst []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(m.Version)<<24|m.Counter&MaxBatchCounter)
+	return binary.BigEndian.AppendUint32(dst, uint32(m.Generation))
 }
 
 // DecodeEncryptedMessage deserializes an EncryptedMessage.
 func DecodeEncryptedMessage(data []byte) (EncryptedMessage, error) {
-	if len(data) < 8 {
+	if len(data) < HeaderSize {
 		return EncryptedMessage{}, errors.New("ratchet: message too short")
 	}
+	hi := binary.BigEndian.Uint32(data[:4])
 	return EncryptedMessage{
-		Generation: binary.BigEndian.Uint64(data[:8]),
-		Ciphertext: data[8:],
+		Version:    uint8(hi >> 24),
+		Counter:    hi & MaxBatchCounter,
+		Generation: uint64(binary.BigEndian.Uint32(data[4:8])),
+		Ciphertext: data[HeaderSize:],
 	}, nil
 }
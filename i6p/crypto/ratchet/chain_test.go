@@ -97,6 +97,193 @@ func TestEncodeDecodeMessage(t *testing.T) {
 	}
 }
 
+func TestChainDerivationVersions(t *testing.T) {
+	key := make([]byte, 32)
+	legacy, err := NewChainWithOptions(key, ChainOptions{Version: VersionLegacy})
+	if err != nil {
+		t.Fatalf("NewChainWithOptions: %v", err)
+	}
+	current, _ := NewChain(key)
+	if current.Version() != VersionHKDF {
+		t.Fatalf("expected NewChain to use HKDF derivation")
+	}
+
+	// Legacy and HKDF derivations must produce different keys.
+	lc, _ := legacy.Seal([]byte("x"), nil)
+	cc, _ := current.Seal([]byte("x"), nil)
+	r, _ := NewReceiver(key, 10)
+	if _, err := r.Open(EncryptedMessage{Version: VersionHKDF, Generation: 0, Ciphertext: lc.Ciphertext}, nil); err == nil {
+		t.Fatalf("expected legacy ciphertext to fail under HKDF keys")
+	}
+
+	// A receiver pinned to the sender's version opens its messages.
+	for _, em := range []EncryptedMessage{lc, cc} {
+		r, _ := NewReceiverWithOptions(key, 10, ChainOptions{Version: em.Version})
+		decoded, _ := DecodeEncryptedMessage(em.Encode())
+		if decoded.Version != em.Version {
+			t.Fatalf("version not preserved on the wire")
+		}
+		if _, err := r.Open(decoded, nil); err != nil {
+			t.Fatalf("Open version %d: %v", em.Version, err)
+		}
+	}
+
+	// The pre-versioning wire format (plain 64-bit generation) decodes as legacy.
+	old := make([]byte, 8)
+	old[7] = 5
+	decoded, _ := DecodeEncryptedMessage(old)
+	if decoded.Version != VersionLegacy || decoded.Generation != 5 || decoded.Counter != 0 {
+		t.Fatalf("unexpected decode of legacy header: %+v", decoded)
+	}
+
+	if _, err := NewChainWithOptions(key, ChainOptions{Version: 9}); err != ErrUnsupportedVersion {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestReceiverForgedMessage(t *testing.T) {
+	key := make([]byte, 32)
+	sender, _ := NewChain(key)
+	receiver, _ := NewReceiver(key, 100)
+
+	first, _ := sender.Seal([]byte("first"), nil)
+	second, _ := sender.Seal([]byte("second"), nil)
+
+	// Forged headers: far ahead, at a skipped generation, and under the
+	// other derivation version. None may move the receiver.
+	forged := []EncryptedMessage{
+		{Version: CurrentVersion, Generation: 50, Ciphertext: make([]byte, 40)},
+		{Version: CurrentVersion, Generation: 0, Ciphertext: make([]byte, 40)},
+		{Version: VersionLegacy, Generation: 1, Ciphertext: second.Ciphertext},
+	}
+	if _, err := receiver.Open(forged[0], nil); err == nil {
+		t.Fatal("forged message opened")
+	}
+	if pt, err := receiver.Open(second, nil); err != nil || string(pt) != "second" {
+		t.Fatalf("Open after forged skip: %q, %v", pt, err)
+	}
+	for _, f := range forged[1:] {
+		if _, err := receiver.Open(f, nil); err == nil {
+			t.Fatalf("forged message %+v opened", f)
+		}
+	}
+	if pt, err := receiver.Open(first, nil); err != nil || string(pt) != "first" {
+		t.Fatalf("Open of skipped message after forgery: %q, %v", pt, err)
+	}
+	if _, err := receiver.Open(forged[2], nil); err != ErrVersionMismatch {
+		t.Fatalf("expected ErrVersionMismatch, got %v", err)
+	}
+}
+
+func TestChainSalt(t *testing.T) {
+	key := make([]byte, 32)
+	sender, _ := NewChainWithOptions(key, ChainOptions{Version: VersionHKDF, Salt: []byte("session-a")})
+	good, _ := NewReceiverWithOptions(key, 10, ChainOptions{Version: VersionHKDF, Salt: []byte("session-a")})
+	bad, _ := NewReceiverWithOptions(key, 10, ChainOptions{Version: VersionHKDF, Salt: []byte("session-b")})
+
+	em, _ := sender.Seal([]byte("salted"), nil)
+	if _, err := good.Open(em, nil); err != nil {
+		t.Fatalf("Open with matching salt: %v", err)
+	}
+	if _, err := bad.Open(em, nil); err == nil {
+		t.Fatalf("expected failure with mismatched salt")
+	}
+}
+
+func TestChainBatchPolicy(t *testing.T) {
+	key := make([]byte, 32)
+	sender, _ := NewChain(key)
+	sender.SetBatchPolicy(BatchPolicy{Messages: 3})
+	receiver, _ := NewReceiver(key, 100)
+	receiver.SetBatchWindow(2)
+
+	var msgs []EncryptedMessage
+	for i := 0; i < 7; i++ {
+		em, err := sender.Seal([]byte{byte(i)}, nil)
+		if err != nil {
+			t.Fatalf("Seal: %v", err)
+		}
+		if em.Generation != uint64(i/3) || em.Counter != uint32(i%3) {
+			t.Fatalf("message %d: got gen %d counter %d", i, em.Generation, em.Counter)
+		}
+		msgs = append(msgs, em)
+	}
+	if sender.Generation() != 3 {
+		t.Fatalf("expected 3 chain steps, got %d", sender.Generation())
+	}
+
+	// Deliver out of order, through the wire encoding.
+	for _, i := range []int{1, 0, 2, 4, 3, 6, 5} {
+		decoded, err := DecodeEncryptedMessage(msgs[i].Encode())
+		if err != nil {
+			t.Fatalf("DecodeEncryptedMessage: %v", err)
+		}
+		pt, err := receiver.Open(decoded, nil)
+		if err != nil {
+			t.Fatalf("Open %d: %v", i, err)
+		}
+		if pt[0] != byte(i) {
+			t.Fatalf("message %d mismatch", i)
+		}
+	}
+
+	if _, err := receiver.Open(msgs[5], nil); err != ErrReplayedMessage {
+		t.Fatalf("expected ErrReplayedMessage, got %v", err)
+	}
+}
+
+func TestChainBatchTamperedCounter(t *testing.T) {
+	key := make([]byte, 32)
+	sender, _ := NewChain(key)
+	sender.SetBatchPolicy(BatchPolicy{Messages: 4})
+	receiver, _ := NewReceiver(key, 100)
+	receiver.SetBatchWindow(2)
+
+	var msgs []EncryptedMessage
+	for i := range 3 {
+		em, err := sender.Seal([]byte("pay 100"), nil)
+		if err != nil {
+			t.Fatalf("Seal %d: %v", i, err)
+		}
+		msgs = append(msgs, em)
+	}
+	for i, em := range msgs[:2] {
+		if _, err := receiver.Open(em, nil); err != nil {
+			t.Fatalf("Open %d: %v", i, err)
+		}
+	}
+
+	// Replaying an opened message under an unused counter must not pass.
+	for _, counter := range []uint32{2, 3, 0} {
+		for i, em := range msgs[:2] {
+			em.Counter = counter
+			decoded, _ := DecodeEncryptedMessage(em.Encode())
+			if _, err := receiver.Open(decoded, nil); err == nil {
+				t.Fatalf("message %d opened with counter %d", i, counter)
+			}
+		}
+	}
+	if _, err := receiver.Open(msgs[2], nil); err != nil {
+		t.Fatalf("Open 2: %v", err)
+	}
+}
+
+func TestChainBatchRequiresWindow(t *testing.T) {
+	key := make([]byte, 32)
+	sender, _ := NewChain(key)
+	sender.SetBatchPolicy(BatchPolicy{Messages: 2})
+	receiver, _ := NewReceiver(key, 100)
+
+	em0, _ := sender.Seal([]byte("a"), nil)
+	em1, _ := sender.Seal([]byte("b"), nil)
+	if _, err := receiver.Open(em0, nil); err != nil {
+		t.Fatalf("Open em0: %v", err)
+	}
+	if _, err := receiver.Open(em1, nil); err != ErrInvalidGeneration {
+		t.Fatalf("expected ErrInvalidGeneration without batch window, got %v", err)
+	}
+}
+
 func BenchmarkChainSeal(b *testing.B) {
 	key := make([]byte, 32)
 	chain, _ := NewChain(key)
@@ -0,0 +1,71 @@
+package ratchet
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("ratchet: unsupported derivation version")
+)
+
+// Derivation versions. The version travels in every EncryptedMessage; a
+// Receiver is pinned to one and rejects messages declaring another.
+const (
+	// VersionLegacy derives keys as SHA-256(chainKey || 0x01/0x02).
+	VersionLegacy uint8 = 0
+	// VersionHKDF derives keys with HKDF-SHA256 and explicit domain-separation labels.
+	VersionHKDF uint8 = 1
+	// CurrentVersion is used by chains created with NewChain.
+	CurrentVersion = VersionHKDF
+)
+
+const (
+	labelMessageKey = "i6p-ratchet-msg"
+	labelChainKey   = "i6p-ratchet-chain"
+)
+
+// deriveKeys derives (nextChainKey, messageKey) from chainKey.
+func deriveKeys(version uint8, salt []byte, chainKey [32]byte) (next [32]byte, msg [32]byte, err error) {
+	switch version {
+	case VersionLegacy:
+		next, msg = deriveKeysLegacy(chainKey)
+		return next, msg, nil
+	case VersionHKDF:
+		if err := hkdfExpand(chainKey[:], salt, labelMessageKey, msg[:]); err != nil {
+			return next, msg, err
+		}
+		if err := hkdfExpand(chainKey[:], salt, labelChainKey, next[:]); err != nil {
+			return next, msg, err
+		}
+		return next, msg, nil
+	default:
+		return next, msg, ErrUnsupportedVersion
+	}
+}
+
+func hkdfExpand(secret, salt []byte, label string, out []byte) error {
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(label)), out)
+	return err
+}
+
+func deriveKeysLegacy(chainKey [32]byte) ([32]byte, [32]byte) {
+	// chainKey || 0x01 -> messageKey
+	// chainKey || 0x02 -> nextChainKey
+	h1 := sha256.New()
+	h1.Write(chainKey[:])
+	h1.Write([]byte{0x01})
+	var messageKey [32]byte
+	copy(messageKey[:], h1.Sum(nil))
+
+	h2 := sha256.New()
+	h2.Write(chainKey[:])
+	h2.Write([]byte{0x02})
+	var nextChainKey [32]byte
+	copy(nextChainKey[:], h2.Sum(nil))
+
+	return nextChainKey, messageKey
+}
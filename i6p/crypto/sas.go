@@ -0,0 +1,120 @@
+package crypto
+
+import "strings"
+
+// SASWords is the number of words in a short authentication string.
+const SASWords = 6
+
+// SASEmoji is the number of emoji in a short authentication string.
+const SASEmoji = 7
+
+// ShortAuthString is a short authentication string: a few bits derived from
+// a handshake transcript that two users compare out of band ("do you see
+// the same six words?"). A man in the middle ends up with a different
+// transcript on each side and has one chance in 2^48 (words) or 2^42 (emoji)
+// of producing matching strings.
+type ShortAuthString struct {
+	bits [SASWords]byte
+}
+
+// SAS derives the short authentication string for transcript, typically
+// session.Session.TranscriptHash or SecureChannel.Transcript.
+func SAS(transcript []byte) ShortAuthString {
+	var s ShortAuthString
+	b, err := DeriveKey(transcript, nil, []byte("i6p sas v1"), len(s.bits))
+	if err != nil {
+		panic(err) // HKDF only fails for outputs longer than 255 hashes
+	}
+	copy(s.bits[:], b)
+	return s
+}
+
+// Words returns SASWords words, one per byte of the string.
+func (s ShortAuthString) Words() []string {
+	out := make([]string, len(s.bits))
+	for i, b := range s.bits {
+		out[i] = sasWordList[b]
+	}
+	return out
+}
+
+// Emoji returns SASEmoji emoji, one per 6 bits of the string, using the
+// emoji table of the Matrix SAS verification so users may recognize it.
+func (s ShortAuthString) Emoji() []SASSymbol {
+	var v uint64
+	for _, b := range s.bits {
+		v = v<<8 | uint64(b)
+	}
+	out := make([]SASSymbol, SASEmoji)
+	for i := range out {
+		shift := 8*len(s.bits) - 6*(i+1)
+		out[i] = sasEmojiTable[v>>shift&0x3f]
+	}
+	return out
+}
+
+// String returns the words separated by spaces.
+func (s ShortAuthString) String() string {
+	return strings.Join(s.Words(), " ")
+}
+
+// SASSymbol is an emoji with the name to read out alongside it.
+type SASSymbol struct {
+	Emoji string
+	Name  string
+}
+
+var sasWordList = [256]string{
+	"acid", "acorn", "actor", "adult", "agent", "alarm", "album", "alien",
+	"alley", "amber", "angel", "anvil", "ankle", "apple", "apron", "arena",
+	"armor", "arrow", "atlas", "attic", "audio", "award", "bacon", "badge",
+	"bagel", "baker", "banjo", "barn", "basil", "basin", "beach", "beard",
+	"bench", "berry", "bison", "blade", "blank", "blaze", "blimp", "bloom",
+	"board", "bonus", "boots", "brain", "brass", "bread", "brick", "bride",
+	"broom", "brush", "buddy", "bugle", "cabin", "cable", "camel", "candy",
+	"canoe", "cargo", "cedar", "chalk", "charm", "chess", "chief", "cider",
+	"civic", "clamp", "cliff", "clock", "cloud", "coast", "cobra", "cocoa",
+	"comet", "coral", "cotton", "couch", "cowboy", "crane", "crater", "crayon",
+	"creek", "crown", "cube", "daisy", "dancer", "delta", "denim", "desert",
+	"diary", "dingo", "disco", "donkey", "dragon", "drum", "eagle", "easel",
+	"echo", "elbow", "elder", "ember", "engine", "falcon", "fence", "ferry",
+	"fiddle", "film", "flame", "flint", "flute", "fossil", "fox", "frost",
+	"galaxy", "garden", "garlic", "gecko", "geyser", "ghost", "giant", "ginger",
+	"globe", "goat", "gravel", "guitar", "hammer", "harbor", "harp", "hawk",
+	"hazel", "helmet", "hermit", "honey", "hornet", "hotel", "igloo", "island",
+	"ivory", "jacket", "jaguar", "jelly", "jewel", "jockey", "juice", "jungle",
+	"kayak", "kernel", "kettle", "kiosk", "kitten", "koala", "ladder", "lagoon",
+	"laser", "lemon", "lily", "lizard", "llama", "locket", "magnet", "mango",
+	"maple", "marble", "meadow", "melon", "meteor", "mirror", "mitten",
+	"monkey", "moose", "mosaic", "motor", "muffin", "nectar", "needle",
+	"nickel", "noodle", "nugget", "oasis", "ocean", "olive", "onion", "orbit",
+	"orchid", "otter", "oven", "owl", "paddle", "palace", "panda", "parrot",
+	"pebble", "pepper", "piano", "pickle", "pilot", "planet", "plum", "pocket",
+	"poet", "pony", "potato", "prism", "puzzle", "quartz", "quill", "rabbit",
+	"radar", "radio", "raven", "reef", "ribbon", "river", "robot", "rocket",
+	"saddle", "salmon", "satin", "scarf", "shadow", "shark", "shell", "silver",
+	"sketch", "sloth", "snail", "sonar", "spider", "sponge", "squid", "statue",
+	"stereo", "summit", "sunset", "swan", "tablet", "tango", "temple", "tiger",
+	"toast", "tomato", "torch", "tulip", "tunnel", "turtle", "valley", "velvet",
+	"violin", "waffle", "walnut", "walrus", "whale", "wizard", "yacht",
+	"yogurt", "zebra", "zipper",
+}
+
+var sasEmojiTable = [64]SASSymbol{
+	{"🐶", "Dog"}, {"🐱", "Cat"}, {"🦁", "Lion"}, {"🐎", "Horse"},
+	{"🦄", "Unicorn"}, {"🐷", "Pig"}, {"🐘", "Elephant"}, {"🐰", "Rabbit"},
+	{"🐼", "Panda"}, {"🐓", "Rooster"}, {"🐧", "Penguin"}, {"🐢", "Turtle"},
+	{"🐟", "Fish"}, {"🐙", "Octopus"}, {"🦋", "Butterfly"}, {"🌷", "Flower"},
+	{"🌳", "Tree"}, {"🌵", "Cactus"}, {"🍄", "Mushroom"}, {"🌏", "Globe"},
+	{"🌙", "Moon"}, {"☁️", "Cloud"}, {"🔥", "Fire"}, {"🍌", "Banana"},
+	{"🍎", "Apple"}, {"🍓", "Strawberry"}, {"🌽", "Corn"}, {"🍕", "Pizza"},
+	{"🎂", "Cake"}, {"❤️", "Heart"}, {"😀", "Smiley"}, {"🤖", "Robot"},
+	{"🎩", "Hat"}, {"👓", "Glasses"}, {"🔧", "Spanner"}, {"🎅", "Santa"},
+	{"👍", "Thumbs Up"}, {"☂️", "Umbrella"}, {"⌛", "Hourglass"}, {"⏰", "Clock"},
+	{"🎁", "Gift"}, {"💡", "Light Bulb"}, {"📕", "Book"}, {"✏️", "Pencil"},
+	{"📎", "Paperclip"}, {"✂️", "Scissors"}, {"🔒", "Lock"}, {"🔑", "Key"},
+	{"🔨", "Hammer"}, {"☎️", "Telephone"}, {"🏁", "Flag"}, {"🚂", "Train"},
+	{"🚲", "Bicycle"}, {"✈️", "Aeroplane"}, {"🚀", "Rocket"}, {"🏆", "Trophy"},
+	{"⚽", "Ball"}, {"🎸", "Guitar"}, {"🎺", "Trumpet"}, {"🔔", "Bell"},
+	{"⚓", "Anchor"}, {"🎧", "Headphones"}, {"📁", "Folder"}, {"📌", "Pin"},
+}
@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSASTables(t *testing.T) {
+	seen := map[string]bool{}
+	for _, w := range sasWordList {
+		if w == "" || seen[w] {
+			t.Fatalf("word %q empty or repeated", w)
+		}
+		seen[w] = true
+	}
+	names := map[string]bool{}
+	for _, e := range sasEmojiTable {
+		if e.Emoji == "" || names[e.Name] {
+			t.Fatalf("emoji %q empty or repeated", e.Name)
+		}
+		names[e.Name] = true
+	}
+}
+
+func TestSASDeterministic(t *testing.T) {
+	a, b := SAS([]byte("transcript")), SAS([]byte("transcript"))
+	if a.String() != b.String() {
+		t.Fatal("same transcript, different SAS")
+	}
+	if len(a.Words()) != SASWords || len(a.Emoji()) != SASEmoji {
+		t.Fatalf("got %d words, %d emoji", len(a.Words()), len(a.Emoji()))
+	}
+	if strings.Count(a.String(), " ") != SASWords-1 {
+		t.Fatalf("String() = %q", a.String())
+	}
+	if SAS([]byte("other")).String() == a.String() {
+		t.Fatal("different transcripts, same SAS")
+	}
+}
+
+func TestSecureChannelStrictSAS(t *testing.T) {
+	initiator, _ := NewSecureChannelInitiator()
+	responder, _ := NewSecureChannelResponder()
+	initiator.SetStrictSAS(true)
+	if err := initiator.ConfirmSAS(); !errors.Is(err, ErrChannelNotEstablished) {
+		t.Fatalf("ConfirmSAS before Complete: %v", err)
+	}
+	if err := initiator.Complete(responder.LocalEphemeralPublic()); err != nil {
+		t.Fatal(err)
+	}
+	if err := responder.Complete(initiator.LocalEphemeralPublic()); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := initiator.SAS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := responder.SAS()
+	if a.String() != b.String() {
+		t.Fatalf("SAS differ: %q vs %q", a, b)
+	}
+
+	if _, err := initiator.Encrypt([]byte("x"), nil); !errors.Is(err, ErrSASNotConfirmed) {
+		t.Fatalf("Encrypt before ConfirmSAS: %v", err)
+	}
+	if _, err := initiator.EncryptBatch([][]byte{[]byte("x")}, nil); !errors.Is(err, ErrSASNotConfirmed) {
+		t.Fatalf("EncryptBatch before ConfirmSAS: %v", err)
+	}
+	// Non-strict peers are not gated.
+	ct, err := responder.Encrypt([]byte("hi"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := initiator.Decrypt(ct, nil); err != nil {
+		t.Fatalf("Decrypt before ConfirmSAS: %v", err)
+	}
+
+	if err := initiator.ConfirmSAS(); err != nil {
+		t.Fatal(err)
+	}
+	if !initiator.SASConfirmed() {
+		t.Fatal("SASConfirmed = false")
+	}
+	if _, err := initiator.Encrypt([]byte("x"), nil); err != nil {
+		t.Fatalf("Encrypt after ConfirmSAS: %v", err)
+	}
+}
+
+func TestSecureChannelSASDetectsMITM(t *testing.T) {
+	// Each victim completes with the attacker's key instead of the other's.
+	initiator, _ := NewSecureChannelInitiator()
+	responder, _ := NewSecureChannelResponder()
+	mitmR, _ := NewSecureChannelResponder()
+	mitmI, _ := NewSecureChannelInitiator()
+	_ = initiator.Complete(mitmR.LocalEphemeralPublic())
+	_ = responder.Complete(mitmI.LocalEphemeralPublic())
+	a, _ := initiator.SAS()
+	b, _ := responder.SAS()
+	if a.String() == b.String() {
+		t.Fatal("SAS match across a man in the middle")
+	}
+}
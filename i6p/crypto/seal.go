@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"crypto/cipher"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// SealedOverhead is the number of bytes SealToPeer adds to a plaintext.
+const SealedOverhead = 32 + chacha20poly1305.Overhead
+
+// sealInfo labels the HKDF derivation of sealed message keys.
+const sealInfo = "i6p-sealed-message"
+
+// SealToPeer encrypts plaintext to the holder of the X25519 key recipientPub
+// without an interactive handshake, as ECIES does: a fresh ephemeral key
+// agrees a secret with recipientPub, and the message key is derived from it
+// with HKDF-SHA256 over both public keys. The output carries no sender
+// identity; authenticate the sender inside plaintext if it matters.
+//
+// Output format: ephemeral public key (32 bytes) || ciphertext || tag (16 bytes)
+func SealToPeer(recipientPub [32]byte, plaintext []byte) ([]byte, error) {
+	eph, err := GenerateX25519()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := sealAEAD(eph.PrivateKey, recipientPub, eph.PublicKey, recipientPub)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 32, SealedOverhead+len(plaintext))
+	copy(out, eph.PublicKey[:])
+	// Each key seals a single message, so a zero nonce is safe.
+	var nonce [chacha20poly1305.NonceSize]byte
+	return aead.Seal(out, nonce[:], plaintext, out[:32]), nil
+}
+
+// OpenSealed decrypts a message sealed by SealToPeer to recipient's public
+// key. Any tampering, or a message sealed to another key, yields
+// ErrDecryptionFailed.
+func OpenSealed(recipient X25519KeyPair, sealed []byte) ([]byte, error) {
+	if len(sealed) < SealedOverhead {
+		return nil, ErrCiphertextTooShort
+	}
+	var ephPub [32]byte
+	copy(ephPub[:], sealed[:32])
+	aead, err := sealAEAD(recipient.PrivateKey, ephPub, ephPub, recipient.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [chacha20poly1305.NonceSize]byte
+	plaintext, err := aead.Open(nil, nonce[:], sealed[32:], sealed[:32])
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// sealAEAD derives the cipher of one sealed message from the ECDH of priv
+// and peerPub, bound to the ephemeral and recipient public keys.
+func sealAEAD(priv, peerPub, ephPub, recipientPub [32]byte) (cipher.AEAD, error) {
+	shared, err := ECDH(priv, peerPub)
+	if err != nil {
+		return nil, err
+	}
+	info := make([]byte, 0, len(sealInfo)+64)
+	info = append(info, sealInfo...)
+	info = append(info, ephPub[:]...)
+	info = append(info, recipientPub[:]...)
+	key, err := DeriveKey(shared, nil, info, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
@@ -1,11 +1,9 @@
 package crypto
 
 import (
+	"crypto/ecdh"
 	"crypto/rand"
 	"errors"
-	"io"
-
-	"golang.org/x/crypto/curve25519"
 )
 
 // X25519KeyPair represents an ephemeral ECDH keypair.
@@ -20,30 +18,54 @@ var (
 
 // GenerateX25519 generates a new ephemeral X25519 keypair.
 func GenerateX25519() (X25519KeyPair, error) {
-	var kp X25519KeyPair
-	if _, err := io.ReadFull(rand.Reader, kp.PrivateKey[:]); err != nil {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
 		return X25519KeyPair{}, err
 	}
-	// Clamp private key per RFC 7748
-	kp.PrivateKey[0] &= 248
-	kp.PrivateKey[31] &= 127
-	kp.PrivateKey[31] |= 64
-
-	curve25519.ScalarBaseMult(&kp.PublicKey, &kp.PrivateKey)
+	var kp X25519KeyPair
+	copy(kp.PrivateKey[:], priv.Bytes())
+	copy(kp.PublicKey[:], priv.PublicKey().Bytes())
 	return kp, nil
 }
 
+// canonicalX25519 reports whether u is a canonical encoding: the unused top
+// bit is clear and the value is below p = 2^255 - 19.
+func canonicalX25519(u [32]byte) bool {
+	if u[31] < 0x7f {
+		return true
+	}
+	if u[31] > 0x7f {
+		return false
+	}
+	for _, b := range u[1:31] {
+		if b != 0xff {
+			return true
+		}
+	}
+	return u[0] < 0xed
+}
+
 // ECDH computes the shared secret using X25519.
 // Returns 32 bytes of raw shared secret (should be passed to HKDF).
+//
+// Peer keys in non-canonical encoding are rejected, and so is every
+// low-order point: the all-zero shared secret they produce is refused as
+// required by RFC 7748 section 6.1.
 func ECDH(privateKey, peerPublicKey [32]byte) ([]byte, error) {
-	// Check for low-order points (all zeros is invalid)
-	var zero [32]byte
-	if peerPublicKey == zero {
+	if !canonicalX25519(peerPublicKey) {
 		return nil, ErrInvalidPublicKey
 	}
-	shared, err := curve25519.X25519(privateKey[:], peerPublicKey[:])
+	priv, err := ecdh.X25519().NewPrivateKey(privateKey[:])
 	if err != nil {
 		return nil, err
 	}
+	pub, err := ecdh.X25519().NewPublicKey(peerPublicKey[:])
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, ErrInvalidPublicKey // low-order point
+	}
 	return shared, nil
 }
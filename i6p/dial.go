@@ -0,0 +1,77 @@
+package i6p
+
+import (
+	"context"
+	"errors"
+
+	"github.com/TheusHen/I6P/i6p/discovery"
+	"github.com/TheusHen/I6P/i6p/session"
+)
+
+// DialRace is how many providers DialService dials at once.
+const DialRace = 3
+
+// DialService connects to a provider of service found through r, preferring
+// the lowest-latency ones. Providers are ordered by the round-trip times in
+// Latencies, unmeasured ones last and least loaded first, and dialed
+// DialRace at a time: the first handshake to complete wins and the others
+// are abandoned. Each completed dial refines the measurements, so later
+// calls converge on the nearest providers.
+func (p *Peer) DialService(ctx context.Context, r discovery.Resolver, service string) (*session.Session, error) {
+	providers, err := discovery.FindProviders(r, service)
+	if err != nil {
+		return nil, err
+	}
+	p.latencies.Sort(providers)
+	var errs []error
+	for len(providers) > 0 && ctx.Err() == nil {
+		n := min(DialRace, len(providers))
+		s, err := p.dialFirst(ctx, providers[:n])
+		if err == nil {
+			return s, nil
+		}
+		errs = append(errs, err)
+		providers = providers[n:]
+	}
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// dialFirst dials candidates concurrently and returns the first session
+// established.
+func (p *Peer) dialFirst(ctx context.Context, candidates []discovery.AddrInfo) (*session.Session, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	type result struct {
+		s   *session.Session
+		err error
+	}
+	results := make(chan result, len(candidates))
+	for _, info := range candidates {
+		go func() {
+			s, err := p.DialInfo(ctx, info)
+			results <- result{s, err}
+		}()
+	}
+	var errs []error
+	for i := range candidates {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		// Abandon the slower dials, closing those that complete anyway.
+		cancel()
+		go func(left int) {
+			for range left {
+				if r := <-results; r.s != nil {
+					_ = r.s.CloseWithError(0, "slower candidate")
+				}
+			}
+		}(len(candidates) - i - 1)
+		return res.s, nil
+	}
+	cancel()
+	return nil, errors.Join(errs...)
+}
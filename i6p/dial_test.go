@@ -0,0 +1,64 @@
+package i6p
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/discovery"
+	discmem "github.com/TheusHen/I6P/i6p/discovery/memory"
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+func TestDialServicePrefersMeasuredProviders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store := discmem.New()
+	caps := map[string]string{}
+	discovery.AdvertiseServices(caps, discovery.Service{Name: "i6p.storage", Version: 1})
+
+	serverKP, _ := identity.GenerateKeyPair()
+	server := NewPeer(serverKP, caps)
+	if err := server.Listen("[::1]:0"); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer server.Close()
+	go func() {
+		for {
+			if _, err := server.Accept(ctx); err != nil {
+				return
+			}
+		}
+	}()
+	addr := netip.MustParseAddrPort(server.ListenAddr())
+	_ = store.Announce(discovery.AddrInfo{PeerID: serverKP.PeerID(), Addr: addr.Addr(), Port: addr.Port(), Capabilities: caps})
+
+	// A provider that is listed but never answers.
+	deadKP, _ := identity.GenerateKeyPair()
+	_ = store.Announce(discovery.AddrInfo{PeerID: deadKP.PeerID(), Addr: netip.MustParseAddr("::1"), Port: 9, Capabilities: caps})
+
+	clientKP, _ := identity.GenerateKeyPair()
+	client := NewPeer(clientKP, nil)
+	s, err := client.DialService(ctx, store, "i6p.storage/1")
+	if err != nil {
+		t.Fatalf("DialService: %v", err)
+	}
+	if s.RemotePeerID() != serverKP.PeerID() {
+		t.Fatalf("connected to the wrong provider")
+	}
+	// Abandoning the dead provider must not affect the session.
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-s.Done():
+		t.Fatalf("session closed: %v", s.CloseReason())
+	default:
+	}
+	if _, ok := client.Latencies().RTT(serverKP.PeerID()); !ok {
+		t.Fatalf("dial RTT not recorded")
+	}
+	if _, ok := client.Latencies().RTT(deadKP.PeerID()); ok {
+		t.Fatalf("RTT recorded for a provider that never answered")
+	}
+}
@@ -0,0 +1,170 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// DefaultLookupBudget bounds a CompositeResolver lookup when Budget is zero.
+const DefaultLookupBudget = 2 * time.Second
+
+// Answer is a record together with the backend that returned it.
+type Answer struct {
+	AddrInfo
+	From Resolver
+}
+
+// CompositeResolver queries several backends as one Resolver. Resolvers are
+// in priority order, typically mDNS, then a DHT, then a bootstrap list: when
+// two backends know the same peer, the record of the earlier one wins.
+type CompositeResolver struct {
+	Resolvers []Resolver
+	// Budget bounds each lookup. The backends are raced; a lookup returns as
+	// soon as no backend of higher priority than the best answer is still
+	// pending, or with the best answer so far once Budget runs out
+	// (DefaultLookupBudget if zero).
+	Budget time.Duration
+}
+
+// Composite returns a resolver over resolvers, in priority order.
+func Composite(resolvers ...Resolver) *CompositeResolver {
+	return &CompositeResolver{Resolvers: resolvers}
+}
+
+// Announce announces info to every backend. It fails only if all of them
+// do, with their errors joined.
+func (c *CompositeResolver) Announce(info AddrInfo) error {
+	var errs []error
+	for _, r := range c.Resolvers {
+		if err := r.Announce(info); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) < len(c.Resolvers) {
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+func (c *CompositeResolver) Lookup(peerID identity.PeerID) (AddrInfo, error) {
+	a, err := c.LookupAnswer(context.Background(), peerID)
+	return a.AddrInfo, err
+}
+
+// LookupAnswer races the backends for peerID as Budget describes and
+// reports which one answered. If none does, the error matches ErrNotFound
+// and carries the backend failures other than ErrNotFound.
+func (c *CompositeResolver) LookupAnswer(ctx context.Context, peerID identity.PeerID) (Answer, error) {
+	budget := c.Budget
+	if budget <= 0 {
+		budget = DefaultLookupBudget
+	}
+	lctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	type result struct {
+		i    int
+		info AddrInfo
+		err  error
+	}
+	// Buffered, so backends that answer after the lookup returns do not leak.
+	results := make(chan result, len(c.Resolvers))
+	for i, r := range c.Resolvers {
+		go func() {
+			info, err := r.Lookup(peerID)
+			results <- result{i, info, err}
+		}()
+	}
+
+	pending := make([]bool, len(c.Resolvers))
+	for i := range pending {
+		pending[i] = true
+	}
+	best := -1
+	var info AddrInfo
+	var errs []error
+wait:
+	for range c.Resolvers {
+		select {
+		case res := <-results:
+			pending[res.i] = false
+			switch {
+			case res.err == nil && (best < 0 || res.i < best):
+				best, info = res.i, res.info
+			case res.err != nil && !errors.Is(res.err, ErrNotFound):
+				errs = append(errs, res.err)
+			}
+		case <-lctx.Done():
+			break wait
+		}
+		if best >= 0 && !anyTrue(pending[:best]) {
+			break
+		}
+	}
+	if best >= 0 {
+		return Answer{AddrInfo: info, From: c.Resolvers[best]}, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return Answer{}, err
+	}
+	return Answer{}, errors.Join(append([]error{ErrNotFound}, errs...)...)
+}
+
+func anyTrue(b []bool) bool {
+	for _, v := range b {
+		if v {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CompositeResolver) List() ([]AddrInfo, error) {
+	answers, err := c.ListAnswers()
+	out := make([]AddrInfo, len(answers))
+	for i, a := range answers {
+		out[i] = a.AddrInfo
+	}
+	return out, err
+}
+
+// ListAnswers merges the peers of every backend, one answer per peer from
+// the backend of highest priority that knows it. Failing backends are
+// skipped; it fails only if all of them do.
+func (c *CompositeResolver) ListAnswers() ([]Answer, error) {
+	lists := make([][]AddrInfo, len(c.Resolvers))
+	errs := make([]error, len(c.Resolvers))
+	done := make(chan struct{})
+	for i, r := range c.Resolvers {
+		go func() {
+			lists[i], errs[i] = r.List()
+			done <- struct{}{}
+		}()
+	}
+	for range c.Resolvers {
+		<-done
+	}
+
+	var out []Answer
+	seen := map[identity.PeerID]bool{}
+	failed := 0
+	for i, list := range lists {
+		if errs[i] != nil {
+			failed++
+			continue
+		}
+		for _, info := range list {
+			if !seen[info.PeerID] {
+				seen[info.PeerID] = true
+				out = append(out, Answer{AddrInfo: info, From: c.Resolvers[i]})
+			}
+		}
+	}
+	if failed > 0 && failed == len(c.Resolvers) {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
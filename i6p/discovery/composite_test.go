@@ -0,0 +1,121 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// slowResolver answers from peers after delay, or fails with err.
+type slowResolver struct {
+	peers map[identity.PeerID]AddrInfo
+	delay time.Duration
+	err   error
+}
+
+func (r *slowResolver) Announce(info AddrInfo) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.peers[info.PeerID] = info
+	return nil
+}
+
+func (r *slowResolver) Lookup(id identity.PeerID) (AddrInfo, error) {
+	time.Sleep(r.delay)
+	if r.err != nil {
+		return AddrInfo{}, r.err
+	}
+	info, ok := r.peers[id]
+	if !ok {
+		return AddrInfo{}, ErrNotFound
+	}
+	return info, nil
+}
+
+func (r *slowResolver) List() ([]AddrInfo, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	var out []AddrInfo
+	for _, info := range r.peers {
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+func TestCompositeLookup(t *testing.T) {
+	id := identity.PeerIDFromPublicKey([]byte("peer"))
+	local := &slowResolver{peers: map[identity.PeerID]AddrInfo{id: {PeerID: id, Port: 1}}, delay: 30 * time.Millisecond}
+	dht := &slowResolver{peers: map[identity.PeerID]AddrInfo{id: {PeerID: id, Port: 2}}}
+	c := Composite(local, dht)
+
+	// The faster DHT answers first, but the local backend has priority.
+	a, err := c.LookupAnswer(context.Background(), id)
+	if err != nil {
+		t.Fatalf("LookupAnswer: %v", err)
+	}
+	if a.Port != 1 || a.From != local {
+		t.Fatalf("answer from port %d", a.Port)
+	}
+
+	// Past the budget, the best answer so far is used.
+	local.delay = time.Second
+	c.Budget = 50 * time.Millisecond
+	start := time.Now()
+	a, err = c.LookupAnswer(context.Background(), id)
+	if err != nil {
+		t.Fatalf("LookupAnswer: %v", err)
+	}
+	if a.Port != 2 || a.From != dht {
+		t.Fatalf("answer from port %d", a.Port)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("lookup took %v with a 50ms budget", d)
+	}
+}
+
+func TestCompositeNotFound(t *testing.T) {
+	boom := errors.New("backend down")
+	c := Composite(&slowResolver{peers: map[identity.PeerID]AddrInfo{}}, &slowResolver{err: boom})
+	_, err := c.Lookup(identity.PeerIDFromPublicKey([]byte("missing")))
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, boom) {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if err := c.Announce(AddrInfo{Port: 1}); err != nil {
+		t.Fatalf("Announce with one healthy backend: %v", err)
+	}
+	if err := Composite(&slowResolver{err: boom}).Announce(AddrInfo{}); !errors.Is(err, boom) {
+		t.Fatalf("Announce: %v", err)
+	}
+}
+
+func TestCompositeList(t *testing.T) {
+	a := identity.PeerIDFromPublicKey([]byte("a"))
+	b := identity.PeerIDFromPublicKey([]byte("b"))
+	first := &slowResolver{peers: map[identity.PeerID]AddrInfo{a: {PeerID: a, Port: 1}}}
+	second := &slowResolver{peers: map[identity.PeerID]AddrInfo{a: {PeerID: a, Port: 2}, b: {PeerID: b, Port: 3}}}
+	down := &slowResolver{err: errors.New("backend down")}
+
+	answers, err := Composite(first, down, second).ListAnswers()
+	if err != nil {
+		t.Fatalf("ListAnswers: %v", err)
+	}
+	if len(answers) != 2 {
+		t.Fatalf("got %d answers, want 2", len(answers))
+	}
+	for _, ans := range answers {
+		if ans.PeerID == a && (ans.Port != 1 || ans.From != first) {
+			t.Fatalf("peer a answered by port %d", ans.Port)
+		}
+		if ans.PeerID == b && ans.From != second {
+			t.Fatalf("peer b answered by the wrong backend")
+		}
+	}
+	if _, err := Composite(down).List(); err == nil {
+		t.Fatalf("List with every backend down succeeded")
+	}
+}
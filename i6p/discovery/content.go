@@ -0,0 +1,35 @@
+package discovery
+
+import (
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	ErrInvalidContentKey = errors.New("invalid content key")
+)
+
+// ContentKey identifies a piece of content, e.g. a transfer manifest's Merkle root.
+type ContentKey [32]byte
+
+// ContentKeyFromBytes builds a ContentKey from a 32-byte hash.
+func ContentKeyFromBytes(b []byte) (ContentKey, error) {
+	if len(b) != 32 {
+		return ContentKey{}, ErrInvalidContentKey
+	}
+	var k ContentKey
+	copy(k[:], b)
+	return k, nil
+}
+
+func (k ContentKey) String() string {
+	return hex.EncodeToString(k[:])
+}
+
+// ContentRouter publishes and discovers provider records: "peer X has content K".
+// Downloaders use it to find sources for swarm downloads.
+// Implementations can be backed by a DHT (PROVIDE / FIND_PROVIDERS) or kept in memory.
+type ContentRouter interface {
+	Provide(key ContentKey, provider AddrInfo) error
+	FindContentProviders(key ContentKey) ([]AddrInfo, error)
+}
@@ -0,0 +1,69 @@
+package discovery
+
+import (
+	"context"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// difficultyResolver hides peers whose PeerID does not meet a proof-of-work
+// requirement.
+type difficultyResolver struct {
+	Resolver
+	bits int
+}
+
+// RequireDifficulty wraps r so that peers whose PeerID has fewer than bits
+// leading zero bits can neither be announced nor found, and List omits them.
+func RequireDifficulty(r Resolver, bits int) Resolver {
+	return difficultyResolver{Resolver: r, bits: bits}
+}
+
+func (d difficultyResolver) Announce(info AddrInfo) error {
+	if err := info.PeerID.CheckDifficulty(d.bits); err != nil {
+		return err
+	}
+	return d.Resolver.Announce(info)
+}
+
+func (d difficultyResolver) Lookup(peerID identity.PeerID) (AddrInfo, error) {
+	if peerID.Difficulty() < d.bits {
+		return AddrInfo{}, ErrNotFound
+	}
+	return d.Resolver.Lookup(peerID)
+}
+
+func (d difficultyResolver) List() ([]AddrInfo, error) {
+	all, err := d.Resolver.List()
+	if err != nil {
+		return nil, err
+	}
+	out := all[:0:0]
+	for _, info := range all {
+		if info.PeerID.Difficulty() >= d.bits {
+			out = append(out, info)
+		}
+	}
+	return out, nil
+}
+
+// Watch reports the changes of the wrapped resolver, omitting peers below
+// the required difficulty.
+func (d difficultyResolver) Watch(ctx context.Context) <-chan Event {
+	in := Watch(ctx, d.Resolver, 0)
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		for e := range in {
+			if e.Info.PeerID.Difficulty() < d.bits {
+				continue
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
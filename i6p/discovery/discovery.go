@@ -3,6 +3,7 @@ package discovery
 import (
 	"errors"
 	"net/netip"
+	"time"
 
 	"github.com/TheusHen/I6P/i6p/identity"
 )
@@ -11,6 +12,9 @@ var (
 	ErrNotFound = errors.New("peer not found")
 )
 
+// DefaultTTL is the record lifetime Peer.AnnounceLoop uses by default.
+const DefaultTTL = 30 * time.Minute
+
 // AddrInfo is the minimal set of information discovery provides.
 // The application is responsible for deciding how to use capabilities.
 type AddrInfo struct {
@@ -18,10 +22,35 @@ type AddrInfo struct {
 	Addr         netip.Addr
 	Port         uint16
 	Capabilities map[string]string
+	// ExpiresAt is when the record goes stale unless announced again. The
+	// zero time means it never expires.
+	ExpiresAt time.Time
+}
+
+// WithTTL returns a with ExpiresAt set ttl from now.
+func (a AddrInfo) WithTTL(ttl time.Duration) AddrInfo {
+	a.ExpiresAt = time.Now().Add(ttl)
+	return a
+}
+
+// TTL returns how long the record stays fresh after now: 0 once it has
+// expired, and -1 if it never expires.
+func (a AddrInfo) TTL(now time.Time) time.Duration {
+	if a.ExpiresAt.IsZero() {
+		return -1
+	}
+	return max(a.ExpiresAt.Sub(now), 0)
+}
+
+// Expired reports whether the record is stale at now.
+func (a AddrInfo) Expired(now time.Time) bool {
+	return !a.ExpiresAt.IsZero() && !now.Before(a.ExpiresAt)
 }
 
 // Resolver is a generic discovery interface.
 // Implementations can be backed by DHT, mDNS/DNS-SD, bootstrap lists, etc.
+// Resolvers must not return expired records; a record announced again
+// replaces the previous one, refreshing its ExpiresAt.
 type Resolver interface {
 	Announce(info AddrInfo) error
 	Lookup(peerID identity.PeerID) (AddrInfo, error)
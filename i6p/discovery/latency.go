@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// Latencies records round-trip times measured to peers, smoothed like TCP's
+// SRTT (each sample weighs 1/8), so dialers can prefer nearby providers. It
+// is safe for concurrent use.
+type Latencies struct {
+	mu  sync.RWMutex
+	rtt map[identity.PeerID]time.Duration
+}
+
+func NewLatencies() *Latencies {
+	return &Latencies{rtt: map[identity.PeerID]time.Duration{}}
+}
+
+// Record adds a round-trip time measured to id.
+func (l *Latencies) Record(id identity.PeerID, rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if old, ok := l.rtt[id]; ok {
+		rtt = old + (rtt-old)/8
+	}
+	l.rtt[id] = rtt
+}
+
+// RTT returns the smoothed round-trip time to id, and false if it was never
+// measured.
+func (l *Latencies) RTT(id identity.PeerID) (time.Duration, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	rtt, ok := l.rtt[id]
+	return rtt, ok
+}
+
+// Forget drops the measurements of id.
+func (l *Latencies) Forget(id identity.PeerID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.rtt, id)
+}
+
+// Sort orders infos by increasing RTT. Peers never measured come last, in
+// their original order, so an order such as FindProviders' least loaded
+// first still applies to them.
+func (l *Latencies) Sort(infos []AddrInfo) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	sort.SliceStable(infos, func(i, j int) bool {
+		a, aok := l.rtt[infos[i].PeerID]
+		b, bok := l.rtt[infos[j].PeerID]
+		if aok != bok {
+			return aok
+		}
+		return aok && a < b
+	})
+}
@@ -0,0 +1,39 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+func TestLatenciesSort(t *testing.T) {
+	var ids []identity.PeerID
+	var infos []AddrInfo
+	for _, name := range []string{"unmeasured-a", "slow", "unmeasured-b", "fast"} {
+		id := identity.PeerIDFromPublicKey([]byte(name))
+		ids = append(ids, id)
+		infos = append(infos, AddrInfo{PeerID: id})
+	}
+	l := NewLatencies()
+	l.Record(ids[1], 80*time.Millisecond)
+	l.Record(ids[3], 10*time.Millisecond)
+	l.Sort(infos)
+
+	want := []identity.PeerID{ids[3], ids[1], ids[0], ids[2]}
+	for i, info := range infos {
+		if info.PeerID != want[i] {
+			t.Fatalf("position %d holds the wrong peer", i)
+		}
+	}
+
+	// Samples are smoothed rather than replacing the estimate.
+	l.Record(ids[3], 90*time.Millisecond)
+	if rtt, _ := l.RTT(ids[3]); rtt != 20*time.Millisecond {
+		t.Fatalf("RTT = %v, want 20ms", rtt)
+	}
+	l.Forget(ids[3])
+	if _, ok := l.RTT(ids[3]); ok {
+		t.Fatalf("RTT kept after Forget")
+	}
+}
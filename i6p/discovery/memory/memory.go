@@ -1,7 +1,9 @@
 package memory
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"github.com/TheusHen/I6P/i6p/discovery"
 	"github.com/TheusHen/I6P/i6p/identity"
@@ -9,24 +11,61 @@ import (
 
 // Store is an in-memory discovery resolver.
 // It is useful for tests, examples and embedding in applications.
+// Expired records are hidden at once and dropped by the next write or Sweep.
 type Store struct {
-	mu    sync.RWMutex
-	peers map[identity.PeerID]discovery.AddrInfo
+	mu        sync.RWMutex
+	ttl       time.Duration
+	peers     map[identity.PeerID]discovery.AddrInfo
+	providers map[discovery.ContentKey]map[identity.PeerID]discovery.AddrInfo
+	watchers  map[*watcher]struct{}
 }
 
 func New() *Store {
-	return &Store{peers: map[identity.PeerID]discovery.AddrInfo{}}
+	return &Store{
+		peers:     map[identity.PeerID]discovery.AddrInfo{},
+		providers: map[discovery.ContentKey]map[identity.PeerID]discovery.AddrInfo{},
+		watchers:  map[*watcher]struct{}{},
+	}
+}
+
+// NewWithTTL creates a store that gives records announced without an
+// ExpiresAt a lifetime of ttl, so peers that stop announcing disappear.
+func NewWithTTL(ttl time.Duration) *Store {
+	s := New()
+	s.ttl = ttl
+	return s
+}
+
+// prepare copies the capabilities of info and applies the default TTL.
+func (s *Store) prepare(info discovery.AddrInfo) discovery.AddrInfo {
+	info.Capabilities = copyCaps(info.Capabilities)
+	if info.ExpiresAt.IsZero() && s.ttl > 0 {
+		info.ExpiresAt = time.Now().Add(s.ttl)
+	}
+	return info
+}
+
+func copyCaps(caps map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range caps {
+		out[k] = v
+	}
+	return out
 }
 
 func (s *Store) Announce(info discovery.AddrInfo) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	copyCaps := map[string]string{}
-	for k, v := range info.Capabilities {
-		copyCaps[k] = v
-	}
-	info.Capabilities = copyCaps
+	s.sweepLocked(time.Now())
+	info = s.prepare(info)
+	old, ok := s.peers[info.PeerID]
 	s.peers[info.PeerID] = info
+	switch {
+	case !ok:
+		s.notify(discovery.EventAdded, info)
+	case !old.SameRecord(info):
+		s.notify(discovery.EventUpdated, info)
+	}
 	return nil
 }
 
@@ -34,28 +73,194 @@ func (s *Store) Lookup(peerID identity.PeerID) (discovery.AddrInfo, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	info, ok := s.peers[peerID]
-	if !ok {
+	if !ok || info.Expired(time.Now()) {
 		return discovery.AddrInfo{}, discovery.ErrNotFound
 	}
-	copyCaps := map[string]string{}
-	for k, v := range info.Capabilities {
-		copyCaps[k] = v
-	}
-	info.Capabilities = copyCaps
+	info.Capabilities = copyCaps(info.Capabilities)
 	return info, nil
 }
 
 func (s *Store) List() ([]discovery.AddrInfo, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	now := time.Now()
 	out := make([]discovery.AddrInfo, 0, len(s.peers))
 	for _, info := range s.peers {
-		copyCaps := map[string]string{}
-		for k, v := range info.Capabilities {
-			copyCaps[k] = v
+		if info.Expired(now) {
+			continue
 		}
-		info.Capabilities = copyCaps
+		info.Capabilities = copyCaps(info.Capabilities)
 		out = append(out, info)
 	}
 	return out, nil
 }
+
+// Provide records that provider has the content identified by key.
+func (s *Store) Provide(key discovery.ContentKey, provider discovery.AddrInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(time.Now())
+	set, ok := s.providers[key]
+	if !ok {
+		set = map[identity.PeerID]discovery.AddrInfo{}
+		s.providers[key] = set
+	}
+	set[provider.PeerID] = s.prepare(provider)
+	return nil
+}
+
+// FindContentProviders returns all peers that provide the content identified by key.
+func (s *Store) FindContentProviders(key discovery.ContentKey) ([]discovery.AddrInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	out := make([]discovery.AddrInfo, 0, len(s.providers[key]))
+	for _, info := range s.providers[key] {
+		if info.Expired(now) {
+			continue
+		}
+		info.Capabilities = copyCaps(info.Capabilities)
+		out = append(out, info)
+	}
+	if len(out) == 0 {
+		return nil, discovery.ErrNotFound
+	}
+	return out, nil
+}
+
+// Sweep drops the records expired at now and returns how many it dropped.
+func (s *Store) Sweep(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sweepLocked(now)
+}
+
+func (s *Store) sweepLocked(now time.Time) int {
+	n := 0
+	for id, info := range s.peers {
+		if info.Expired(now) {
+			delete(s.peers, id)
+			s.notify(discovery.EventRemoved, info)
+			n++
+		}
+	}
+	for key, set := range s.providers {
+		for id, info := range set {
+			if info.Expired(now) {
+				delete(set, id)
+				n++
+			}
+		}
+		if len(set) == 0 {
+			delete(s.providers, key)
+		}
+	}
+	return n
+}
+
+// watcher queues the events of one Watch call, so writers never block on a
+// slow reader.
+type watcher struct {
+	mu    sync.Mutex
+	queue []discovery.Event
+	wake  chan struct{}
+}
+
+func (w *watcher) push(e discovery.Event) {
+	w.mu.Lock()
+	w.queue = append(w.queue, e)
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) peek() (discovery.Event, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.queue) == 0 {
+		return discovery.Event{}, false
+	}
+	return w.queue[0], true
+}
+
+func (w *watcher) pop() {
+	w.mu.Lock()
+	w.queue = w.queue[1:]
+	w.mu.Unlock()
+}
+
+// notify queues an event for every watcher. s.mu must be held.
+func (s *Store) notify(t discovery.EventType, info discovery.AddrInfo) {
+	for w := range s.watchers {
+		info.Capabilities = copyCaps(info.Capabilities)
+		w.push(discovery.Event{Type: t, Info: info})
+	}
+}
+
+// Watch implements discovery.Watcher. While it runs, expired records are
+// swept as they expire, so their EventRemoved arrives on time.
+func (s *Store) Watch(ctx context.Context) <-chan discovery.Event {
+	w := &watcher{wake: make(chan struct{}, 1)}
+	s.mu.Lock()
+	now := time.Now()
+	for _, info := range s.peers {
+		if !info.Expired(now) {
+			info.Capabilities = copyCaps(info.Capabilities)
+			w.queue = append(w.queue, discovery.Event{Type: discovery.EventAdded, Info: info})
+		}
+	}
+	s.watchers[w] = struct{}{}
+	s.mu.Unlock()
+
+	out := make(chan discovery.Event)
+	go s.runWatcher(ctx, w, out)
+	return out
+}
+
+func (s *Store) runWatcher(ctx context.Context, w *watcher, out chan<- discovery.Event) {
+	defer close(out)
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, w)
+		s.mu.Unlock()
+	}()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		var send chan<- discovery.Event
+		e, ok := w.peek()
+		if ok {
+			send = out
+		}
+		var expire <-chan time.Time
+		if at, ok := s.nextExpiry(); ok {
+			timer.Reset(time.Until(at))
+			expire = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case send <- e:
+			w.pop()
+		case <-w.wake:
+		case <-expire:
+			s.Sweep(time.Now())
+		}
+		timer.Stop()
+	}
+}
+
+// nextExpiry returns the earliest ExpiresAt of the peer records.
+func (s *Store) nextExpiry() (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var next time.Time
+	for _, info := range s.peers {
+		if !info.ExpiresAt.IsZero() && (next.IsZero() || info.ExpiresAt.Before(next)) {
+			next = info.ExpiresAt
+		}
+	}
+	return next, !next.IsZero()
+}
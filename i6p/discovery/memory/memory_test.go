@@ -1,8 +1,11 @@
 package memory
 
 import (
+	"context"
+	"errors"
 	"net/netip"
 	"testing"
+	"time"
 
 	"github.com/TheusHen/I6P/i6p/discovery"
 	"github.com/TheusHen/I6P/i6p/identity"
@@ -38,3 +41,180 @@ func TestStoreAnnounceLookup(t *testing.T) {
 		t.Fatalf("unexpected capabilities")
 	}
 }
+
+func TestStoreFindProviders(t *testing.T) {
+	s := New()
+	var ids []identity.PeerID
+	for _, load := range []uint8{80, 10} {
+		kp, _ := identity.GenerateKeyPair()
+		caps := map[string]string{}
+		discovery.AdvertiseServices(caps, discovery.Service{Name: "i6p.storage", Version: 1, Load: load})
+		_ = s.Announce(discovery.AddrInfo{PeerID: kp.PeerID(), Addr: netip.MustParseAddr("2001:db8::1"), Port: 4242, Capabilities: caps})
+		ids = append(ids, kp.PeerID())
+	}
+	kp, _ := identity.GenerateKeyPair()
+	_ = s.Announce(discovery.AddrInfo{PeerID: kp.PeerID(), Capabilities: map[string]string{"role": "seed"}})
+
+	got, err := discovery.FindProviders(s, "i6p.storage/1")
+	if err != nil {
+		t.Fatalf("FindProviders: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 providers, got %d", len(got))
+	}
+	if got[0].PeerID != ids[1] {
+		t.Fatalf("expected least loaded provider first")
+	}
+
+	if _, err := discovery.FindProviders(s, "i6p.relay"); err != discovery.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoreContentProviders(t *testing.T) {
+	var _ discovery.ContentRouter = New()
+
+	s := New()
+	key, err := discovery.ContentKeyFromBytes(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("ContentKeyFromBytes: %v", err)
+	}
+	if _, err := s.FindContentProviders(key); err != discovery.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		kp, _ := identity.GenerateKeyPair()
+		info := discovery.AddrInfo{PeerID: kp.PeerID(), Addr: netip.MustParseAddr("2001:db8::1"), Port: uint16(4000 + i)}
+		if err := s.Provide(key, info); err != nil {
+			t.Fatalf("Provide: %v", err)
+		}
+		// Re-providing the same record must not duplicate it.
+		_ = s.Provide(key, info)
+	}
+
+	got, err := s.FindContentProviders(key)
+	if err != nil {
+		t.Fatalf("FindContentProviders: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 providers, got %d", len(got))
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	s := New()
+	kp, _ := identity.GenerateKeyPair()
+	info := discovery.AddrInfo{PeerID: kp.PeerID(), Port: 1}
+	_ = s.Announce(info.WithTTL(-time.Second))
+	if _, err := s.Lookup(kp.PeerID()); !errors.Is(err, discovery.ErrNotFound) {
+		t.Fatalf("Lookup expired: %v", err)
+	}
+	if all, _ := s.List(); len(all) != 0 {
+		t.Fatalf("List returned expired records: %v", all)
+	}
+	key, _ := discovery.ContentKeyFromBytes(make([]byte, 32))
+	_ = s.Provide(key, info.WithTTL(-time.Second))
+	if _, err := s.FindContentProviders(key); !errors.Is(err, discovery.ErrNotFound) {
+		t.Fatalf("FindContentProviders expired: %v", err)
+	}
+	// Provide already dropped the expired announcement.
+	if n := s.Sweep(time.Now()); n != 1 {
+		t.Fatalf("Sweep dropped %d records, want 1", n)
+	}
+
+	// Announcing again refreshes the record.
+	_ = s.Announce(info.WithTTL(time.Minute))
+	got, err := s.Lookup(kp.PeerID())
+	if err != nil {
+		t.Fatalf("Lookup refreshed: %v", err)
+	}
+	if ttl := got.TTL(time.Now()); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("TTL = %v", ttl)
+	}
+	if s.Sweep(time.Now().Add(2*time.Minute)) != 1 {
+		t.Fatalf("Sweep kept a stale record")
+	}
+}
+
+func TestStoreDefaultTTL(t *testing.T) {
+	s := NewWithTTL(time.Minute)
+	kp, _ := identity.GenerateKeyPair()
+	_ = s.Announce(discovery.AddrInfo{PeerID: kp.PeerID()})
+	got, err := s.Lookup(kp.PeerID())
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if got.ExpiresAt.IsZero() || got.Expired(time.Now()) || !got.Expired(time.Now().Add(time.Minute)) {
+		t.Fatalf("ExpiresAt = %v", got.ExpiresAt)
+	}
+}
+
+func TestRequireDifficulty(t *testing.T) {
+	r := discovery.RequireDifficulty(New(), 8)
+	var strong, weak identity.PeerID
+	strong[1], weak[0] = 1, 1
+	if err := r.Announce(discovery.AddrInfo{PeerID: strong, Port: 1}); err != nil {
+		t.Fatalf("Announce strong: %v", err)
+	}
+	if err := r.Announce(discovery.AddrInfo{PeerID: weak, Port: 2}); !errors.Is(err, identity.ErrInsufficientWork) {
+		t.Fatalf("Announce weak: %v", err)
+	}
+	if _, err := r.Lookup(strong); err != nil {
+		t.Fatalf("Lookup strong: %v", err)
+	}
+	if _, err := r.Lookup(weak); !errors.Is(err, discovery.ErrNotFound) {
+		t.Fatalf("Lookup weak: %v", err)
+	}
+	all, _ := r.List()
+	if len(all) != 1 || all[0].PeerID != strong {
+		t.Fatalf("List = %v", all)
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	s := New()
+	kp1, _ := identity.GenerateKeyPair()
+	kp2, _ := identity.GenerateKeyPair()
+	_ = s.Announce(discovery.AddrInfo{PeerID: kp1.PeerID(), Port: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := discovery.Watch(ctx, s, time.Hour)
+	next := func() discovery.Event {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatalf("no event")
+			return discovery.Event{}
+		}
+	}
+
+	if e := next(); e.Type != discovery.EventAdded || e.Info.PeerID != kp1.PeerID() {
+		t.Fatalf("initial event = %v", e.Type)
+	}
+	_ = s.Announce(discovery.AddrInfo{PeerID: kp1.PeerID(), Port: 1}.WithTTL(time.Minute))
+	_ = s.Announce(discovery.AddrInfo{PeerID: kp1.PeerID(), Port: 2})
+	if e := next(); e.Type != discovery.EventUpdated || e.Info.Port != 2 {
+		t.Fatalf("update event = %v port %d", e.Type, e.Info.Port)
+	}
+	_ = s.Announce(discovery.AddrInfo{PeerID: kp2.PeerID(), Port: 3}.WithTTL(20 * time.Millisecond))
+	if e := next(); e.Type != discovery.EventAdded || e.Info.PeerID != kp2.PeerID() {
+		t.Fatalf("add event = %v", e.Type)
+	}
+	// The record expires with no further writes.
+	if e := next(); e.Type != discovery.EventRemoved || e.Info.PeerID != kp2.PeerID() {
+		t.Fatalf("remove event = %v", e.Type)
+	}
+
+	cancel()
+	for range events {
+	}
+	s.mu.RLock()
+	n := len(s.watchers)
+	s.mu.RUnlock()
+	if n != 0 {
+		t.Fatalf("%d watchers left after cancel", n)
+	}
+}
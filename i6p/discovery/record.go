@@ -0,0 +1,271 @@
+package discovery
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// recordVersion is the first byte of an encoded SignedRecord.
+const recordVersion = 1
+
+var (
+	ErrInvalidRecord   = errors.New("discovery: invalid peer record")
+	ErrRecordSignature = errors.New("discovery: peer record signature does not verify")
+	ErrRecordTooLarge  = errors.New("discovery: peer record capability too large")
+)
+
+// SignedRecord is an AddrInfo signed by the identity it describes, compact
+// enough for a QR code: with an IPv6 address and no capabilities it takes
+// 121 bytes, or 182 characters of Base45. Anyone can check it came from the peer, so it
+// can be passed along by untrusted channels such as a camera scan.
+//
+// Encoding:
+//
+//	1 byte:   version (1)
+//	32 bytes: Ed25519 public key (the PeerID is its hash)
+//	1 byte:   address length (0, 4 or 16), then the address; zones are dropped
+//	2 bytes:  port (big endian)
+//	4 bytes:  expiry, Unix seconds (big endian); 0 never expires
+//	1 byte:   capability count, then for each, sorted by key:
+//		1 byte key length, key, 1 byte value length, value
+//	64 bytes: signature of the bytes above, identity.ContextRecord
+type SignedRecord struct {
+	PublicKey ed25519.PublicKey
+	Info      AddrInfo
+	Signature []byte
+}
+
+// SignRecord signs info with kp. The PeerID of info is set to kp's, and
+// ExpiresAt is truncated to the second.
+func SignRecord(kp identity.KeyPair, info AddrInfo) (SignedRecord, error) {
+	info.PeerID = kp.PeerID()
+	info.Addr = info.Addr.WithZone("")
+	if !info.ExpiresAt.IsZero() {
+		info.ExpiresAt = time.Unix(info.ExpiresAt.Unix(), 0)
+	}
+	r := SignedRecord{PublicKey: kp.PublicKey, Info: info}
+	body, err := r.body()
+	if err != nil {
+		return SignedRecord{}, err
+	}
+	if r.Signature, err = kp.SignContext(identity.ContextRecord, body); err != nil {
+		return SignedRecord{}, err
+	}
+	return r, nil
+}
+
+// body encodes the signed part of the record.
+func (r SignedRecord) body() ([]byte, error) {
+	if len(r.PublicKey) != ed25519.PublicKeySize || len(r.Info.Capabilities) > 255 {
+		return nil, ErrInvalidRecord
+	}
+	b := make([]byte, 0, 128)
+	b = append(b, recordVersion)
+	b = append(b, r.PublicKey...)
+	switch {
+	case !r.Info.Addr.IsValid():
+		b = append(b, 0)
+	case r.Info.Addr.Is4():
+		a := r.Info.Addr.As4()
+		b = append(append(b, 4), a[:]...)
+	default:
+		a := r.Info.Addr.As16()
+		b = append(append(b, 16), a[:]...)
+	}
+	b = binary.BigEndian.AppendUint16(b, r.Info.Port)
+	var exp uint32
+	if !r.Info.ExpiresAt.IsZero() {
+		exp = uint32(r.Info.ExpiresAt.Unix())
+	}
+	b = binary.BigEndian.AppendUint32(b, exp)
+	keys := make([]string, 0, len(r.Info.Capabilities))
+	for k := range r.Info.Capabilities {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	b = append(b, byte(len(keys)))
+	for _, k := range keys {
+		v := r.Info.Capabilities[k]
+		if len(k) > 255 || len(v) > 255 {
+			return nil, ErrRecordTooLarge
+		}
+		b = append(append(b, byte(len(k))), k...)
+		b = append(append(b, byte(len(v))), v...)
+	}
+	return b, nil
+}
+
+// Marshal returns the binary encoding of r.
+func (r SignedRecord) Marshal() ([]byte, error) {
+	b, err := r.body()
+	if err != nil {
+		return nil, err
+	}
+	return append(b, r.Signature...), nil
+}
+
+// UnmarshalRecord decodes a record and verifies its signature.
+func UnmarshalRecord(b []byte) (SignedRecord, error) {
+	if len(b) < 1+32+1+2+4+1+ed25519.SignatureSize || b[0] != recordVersion {
+		return SignedRecord{}, ErrInvalidRecord
+	}
+	body, sig := b[:len(b)-ed25519.SignatureSize], b[len(b)-ed25519.SignatureSize:]
+	r := SignedRecord{
+		PublicKey: ed25519.PublicKey(append([]byte(nil), body[1:33]...)),
+		Signature: append([]byte(nil), sig...),
+	}
+	rest := body[33:]
+	take := func(n int) ([]byte, bool) {
+		if len(rest) < n {
+			return nil, false
+		}
+		out := rest[:n]
+		rest = rest[n:]
+		return out, true
+	}
+	// takeLV takes a length-prefixed string.
+	takeLV := func() ([]byte, bool) {
+		n, ok := take(1)
+		if !ok {
+			return nil, false
+		}
+		return take(int(n[0]))
+	}
+	n, ok := take(1)
+	if !ok || (n[0] != 0 && n[0] != 4 && n[0] != 16) {
+		return SignedRecord{}, ErrInvalidRecord
+	}
+	addr, ok := take(int(n[0]))
+	if !ok {
+		return SignedRecord{}, ErrInvalidRecord
+	}
+	if len(addr) > 0 {
+		r.Info.Addr, _ = netip.AddrFromSlice(addr)
+	}
+	fixed, ok := take(2 + 4 + 1)
+	if !ok {
+		return SignedRecord{}, ErrInvalidRecord
+	}
+	r.Info.Port = binary.BigEndian.Uint16(fixed)
+	if exp := binary.BigEndian.Uint32(fixed[2:]); exp != 0 {
+		r.Info.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	if count := int(fixed[6]); count > 0 {
+		r.Info.Capabilities = make(map[string]string, count)
+		for range count {
+			k, ok1 := takeLV()
+			v, ok2 := takeLV()
+			if !ok1 || !ok2 {
+				return SignedRecord{}, ErrInvalidRecord
+			}
+			r.Info.Capabilities[string(k)] = string(v)
+		}
+	}
+	if len(rest) != 0 {
+		return SignedRecord{}, ErrInvalidRecord
+	}
+	if !identity.VerifyContext(r.PublicKey, identity.ContextRecord, body, r.Signature) {
+		return SignedRecord{}, ErrRecordSignature
+	}
+	r.Info.PeerID = identity.PeerIDFromPublicKey(r.PublicKey)
+	return r, nil
+}
+
+// Base45 returns the record in the Base45 encoding of RFC 9285, whose
+// alphabet is the QR alphanumeric mode, so QR codes store it compactly.
+func (r SignedRecord) Base45() (string, error) {
+	b, err := r.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return encodeBase45(b), nil
+}
+
+// ParseRecordBase45 decodes and verifies a record from Base45.
+func ParseRecordBase45(s string) (SignedRecord, error) {
+	b, err := decodeBase45(s)
+	if err != nil {
+		return SignedRecord{}, err
+	}
+	return UnmarshalRecord(b)
+}
+
+// Base64 returns the record in unpadded base64url, for links and text.
+func (r SignedRecord) Base64() (string, error) {
+	b, err := r.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ParseRecordBase64 decodes and verifies a record from unpadded base64url.
+func ParseRecordBase64(s string) (SignedRecord, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return SignedRecord{}, ErrInvalidRecord
+	}
+	return UnmarshalRecord(b)
+}
+
+const base45Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+func encodeBase45(b []byte) string {
+	var sb strings.Builder
+	sb.Grow((len(b)*3 + 1) / 2)
+	for i := 0; i+1 < len(b); i += 2 {
+		n := int(b[i])<<8 | int(b[i+1])
+		sb.WriteByte(base45Alphabet[n%45])
+		sb.WriteByte(base45Alphabet[n/45%45])
+		sb.WriteByte(base45Alphabet[n/2025])
+	}
+	if len(b)%2 == 1 {
+		n := int(b[len(b)-1])
+		sb.WriteByte(base45Alphabet[n%45])
+		sb.WriteByte(base45Alphabet[n/45])
+	}
+	return sb.String()
+}
+
+func decodeBase45(s string) ([]byte, error) {
+	if len(s)%3 == 1 {
+		return nil, ErrInvalidRecord
+	}
+	digit := func(c byte) (int, bool) {
+		i := strings.IndexByte(base45Alphabet, c)
+		return i, i >= 0
+	}
+	out := make([]byte, 0, len(s)*2/3)
+	for i := 0; i < len(s); i += 3 {
+		chunk := s[i:min(i+3, len(s))]
+		n, mul := 0, 1
+		for j := range len(chunk) {
+			d, ok := digit(chunk[j])
+			if !ok {
+				return nil, ErrInvalidRecord
+			}
+			n += d * mul
+			mul *= 45
+		}
+		if len(chunk) == 3 {
+			if n > 0xffff {
+				return nil, ErrInvalidRecord
+			}
+			out = append(out, byte(n>>8), byte(n))
+		} else {
+			if n > 0xff {
+				return nil, ErrInvalidRecord
+			}
+			out = append(out, byte(n))
+		}
+	}
+	return out, nil
+}
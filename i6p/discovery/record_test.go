@@ -0,0 +1,89 @@
+package discovery
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+func TestBase45RFC9285(t *testing.T) {
+	for in, want := range map[string]string{
+		"AB":      "BB8",
+		"Hello!!": "%69 VD92EX0",
+		"base-45": "UJCLQE7W581",
+		"ietf!":   "QED8WEX0",
+	} {
+		if got := encodeBase45([]byte(in)); got != want {
+			t.Errorf("encode %q = %q, want %q", in, got, want)
+		}
+		if got, err := decodeBase45(want); err != nil || string(got) != in {
+			t.Errorf("decode %q = %q, %v", want, got, err)
+		}
+	}
+	for _, bad := range []string{"GGW", "A", "ab"} {
+		if _, err := decodeBase45(bad); err == nil {
+			t.Errorf("decode %q succeeded", bad)
+		}
+	}
+}
+
+func TestSignedRecordRoundTrip(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	infos := []AddrInfo{
+		{},
+		{Addr: netip.MustParseAddr("192.0.2.1"), Port: 4242},
+		{
+			Addr:         netip.MustParseAddr("fe80::1%eth0"),
+			Port:         4242,
+			Capabilities: map[string]string{"svc.i6p.storage/1": "10", "agent": "i6p"},
+			ExpiresAt:    time.Now().Add(time.Hour),
+		},
+	}
+	for i, info := range infos {
+		r, err := SignRecord(kp, info)
+		if err != nil {
+			t.Fatalf("%d: SignRecord: %v", i, err)
+		}
+		b45, _ := r.Base45()
+		b64, _ := r.Base64()
+		for _, parse := range []func() (SignedRecord, error){
+			func() (SignedRecord, error) { return ParseRecordBase45(b45) },
+			func() (SignedRecord, error) { return ParseRecordBase64(b64) },
+		} {
+			got, err := parse()
+			if err != nil {
+				t.Fatalf("%d: parse: %v", i, err)
+			}
+			if got.Info.PeerID != kp.PeerID() || !got.Info.SameRecord(r.Info) || !got.Info.ExpiresAt.Equal(r.Info.ExpiresAt) {
+				t.Fatalf("%d: got %+v, want %+v", i, got.Info, r.Info)
+			}
+		}
+	}
+	if r, _ := SignRecord(kp, infos[2]); r.Info.Addr.Zone() != "" {
+		t.Fatalf("zone kept in signed record")
+	}
+}
+
+func TestSignedRecordRejectsTampering(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	r, _ := SignRecord(kp, AddrInfo{Addr: netip.MustParseAddr("2001:db8::1"), Port: 4242})
+	b, _ := r.Marshal()
+	if b45, _ := r.Base45(); len(b) != 121 || len(b45) != 182 {
+		t.Fatalf("record is %d bytes, %d Base45 characters", len(b), len(b45))
+	}
+	for _, i := range []int{1, 40, len(b) - 1} {
+		bad := append([]byte(nil), b...)
+		bad[i] ^= 1
+		if _, err := UnmarshalRecord(bad); !errors.Is(err, ErrRecordSignature) {
+			t.Errorf("byte %d flipped: err = %v", i, err)
+		}
+	}
+	for _, bad := range [][]byte{b[:50], append(append([]byte(nil), b...), 0), {2}} {
+		if _, err := UnmarshalRecord(bad); err == nil {
+			t.Errorf("malformed record of %d bytes accepted", len(bad))
+		}
+	}
+}
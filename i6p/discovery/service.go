@@ -0,0 +1,134 @@
+package discovery
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidService = errors.New("invalid service name")
+)
+
+// ServiceCapabilityPrefix is the capabilities namespace used for service advertisement.
+// A service "i6p.storage/1" is advertised as the key "svc.i6p.storage/1" whose value
+// is the load hint.
+const ServiceCapabilityPrefix = "svc."
+
+// Service describes a service offered by a peer, e.g. "i6p.storage/1".
+type Service struct {
+	Name    string
+	Version int
+	Load    uint8 // load hint, 0 (idle) to 100 (saturated)
+}
+
+// ParseService parses "name/version". The version is optional; 0 means any version.
+func ParseService(s string) (Service, error) {
+	name, ver, hasVer := strings.Cut(s, "/")
+	if name == "" || strings.ContainsAny(name, "/=") {
+		return Service{}, ErrInvalidService
+	}
+	svc := Service{Name: name}
+	if hasVer {
+		v, err := strconv.Atoi(ver)
+		if err != nil || v <= 0 {
+			return Service{}, ErrInvalidService
+		}
+		svc.Version = v
+	}
+	return svc, nil
+}
+
+// String returns the "name/version" form of the service.
+func (s Service) String() string {
+	if s.Version == 0 {
+		return s.Name
+	}
+	return s.Name + "/" + strconv.Itoa(s.Version)
+}
+
+// Matches reports whether s satisfies the wanted service. A wanted version of 0
+// matches any version.
+func (s Service) Matches(want Service) bool {
+	return s.Name == want.Name && (want.Version == 0 || s.Version == want.Version)
+}
+
+// AdvertiseServices writes services into a capabilities map.
+func AdvertiseServices(caps map[string]string, services ...Service) {
+	for _, s := range services {
+		load := s.Load
+		if load > 100 {
+			load = 100
+		}
+		caps[ServiceCapabilityPrefix+s.String()] = strconv.Itoa(int(load))
+	}
+}
+
+// ServicesFromCapabilities extracts advertised services from a capabilities map.
+// Malformed entries are skipped.
+func ServicesFromCapabilities(caps map[string]string) []Service {
+	var out []Service
+	for k, v := range caps {
+		rest, ok := strings.CutPrefix(k, ServiceCapabilityPrefix)
+		if !ok {
+			continue
+		}
+		svc, err := ParseService(rest)
+		if err != nil || svc.Version == 0 {
+			continue
+		}
+		if load, err := strconv.Atoi(v); err == nil && load >= 0 && load <= 100 {
+			svc.Load = uint8(load)
+		}
+		out = append(out, svc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].String() < out[j].String() })
+	return out
+}
+
+// ProviderFinder is implemented by resolvers that can index services natively
+// (for example a DHT). Resolvers without it fall back to filtering List().
+type ProviderFinder interface {
+	FindProviders(service string) ([]AddrInfo, error)
+}
+
+// FindProviders returns peers that advertise the given service, least loaded first.
+// The service may omit the version ("i6p.storage") to match any version.
+func FindProviders(r Resolver, service string) ([]AddrInfo, error) {
+	if pf, ok := r.(ProviderFinder); ok {
+		return pf.FindProviders(service)
+	}
+	want, err := ParseService(service)
+	if err != nil {
+		return nil, err
+	}
+	all, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		info AddrInfo
+		load uint8
+	}
+	var found []candidate
+	for _, info := range all {
+		for _, svc := range ServicesFromCapabilities(info.Capabilities) {
+			if svc.Matches(want) {
+				found = append(found, candidate{info: info, load: svc.Load})
+				break
+			}
+		}
+	}
+	if len(found) == 0 {
+		return nil, ErrNotFound
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].load < found[j].load })
+
+	out := make([]AddrInfo, len(found))
+	for i, c := range found {
+		out[i] = c.info
+	}
+	return out, nil
+}
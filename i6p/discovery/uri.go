@@ -0,0 +1,90 @@
+package discovery
+
+import (
+	"errors"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+var (
+	ErrInvalidURI  = errors.New("invalid i6p URI")
+	ErrMissingZone = errors.New("link-local address requires a zone")
+)
+
+// URIScheme is the scheme of peer URIs.
+const URIScheme = "i6p"
+
+// AddrPort returns the peer's address and port. The zone of a link-local
+// address is preserved.
+func (a AddrInfo) AddrPort() netip.AddrPort {
+	return netip.AddrPortFrom(a.Addr, a.Port)
+}
+
+// DialAddr returns the "host:port" string to pass to a transport dialer,
+// e.g. "[fe80::1%eth0]:4242". Link-local addresses must carry a zone, since
+// the same fe80::/10 address may exist on every interface.
+func (a AddrInfo) DialAddr() (string, error) {
+	if a.Addr.Is6() && a.Addr.IsLinkLocalUnicast() && a.Addr.Zone() == "" {
+		return "", ErrMissingZone
+	}
+	return a.AddrPort().String(), nil
+}
+
+// FormatURI encodes a as "i6p://<peer-id>@[addr%25zone]:port?key=value".
+// The zone is percent-encoded as in RFC 6874 and capabilities become query
+// parameters.
+func FormatURI(a AddrInfo) string {
+	u := url.URL{
+		Scheme: URIScheme,
+		User:   url.User(a.PeerID.String()),
+		Host:   a.AddrPort().String(),
+	}
+	if len(a.Capabilities) > 0 {
+		keys := make([]string, 0, len(a.Capabilities))
+		for k := range a.Capabilities {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		q := url.Values{}
+		for _, k := range keys {
+			q.Set(k, a.Capabilities[k])
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// ParseURI decodes a URI produced by FormatURI.
+func ParseURI(s string) (AddrInfo, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != URIScheme || u.User == nil || u.Path != "" {
+		return AddrInfo{}, ErrInvalidURI
+	}
+	id, err := identity.ParsePeerIDHex(u.User.Username())
+	if err != nil {
+		return AddrInfo{}, ErrInvalidURI
+	}
+	addr, err := netip.ParseAddr(u.Hostname())
+	if err != nil {
+		return AddrInfo{}, ErrInvalidURI
+	}
+	port, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil {
+		return AddrInfo{}, ErrInvalidURI
+	}
+	info := AddrInfo{PeerID: id, Addr: addr, Port: uint16(port)}
+	if addr.Is6() && addr.IsLinkLocalUnicast() && addr.Zone() == "" {
+		return AddrInfo{}, ErrMissingZone
+	}
+	if q := u.Query(); len(q) > 0 {
+		info.Capabilities = make(map[string]string, len(q))
+		for k, v := range q {
+			info.Capabilities[k] = v[0]
+		}
+	}
+	return info, nil
+}
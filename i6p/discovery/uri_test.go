@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+func TestURIRoundTripLinkLocal(t *testing.T) {
+	info := AddrInfo{
+		PeerID:       identity.PeerIDFromPublicKey([]byte("peer")),
+		Addr:         netip.MustParseAddr("fe80::1%eth0"),
+		Port:         4242,
+		Capabilities: map[string]string{"svc.i6p.storage/1": "10"},
+	}
+
+	uri := FormatURI(info)
+	if !strings.Contains(uri, "[fe80::1%25eth0]:4242") {
+		t.Fatalf("zone not RFC 6874 encoded: %s", uri)
+	}
+	got, err := ParseURI(uri)
+	if err != nil {
+		t.Fatalf("ParseURI: %v", err)
+	}
+	if got.PeerID != info.PeerID || got.Addr != info.Addr || got.Port != info.Port {
+		t.Fatalf("round trip mismatch: %+v", got)
+	}
+	if got.Addr.Zone() != "eth0" {
+		t.Fatalf("zone lost: %q", got.Addr.Zone())
+	}
+	if got.Capabilities["svc.i6p.storage/1"] != "10" {
+		t.Fatalf("capabilities lost: %v", got.Capabilities)
+	}
+	addr, err := got.DialAddr()
+	if err != nil || addr != "[fe80::1%eth0]:4242" {
+		t.Fatalf("DialAddr = %q, %v", addr, err)
+	}
+}
+
+func TestLinkLocalRequiresZone(t *testing.T) {
+	info := AddrInfo{Addr: netip.MustParseAddr("fe80::1"), Port: 1}
+	if _, err := info.DialAddr(); err != ErrMissingZone {
+		t.Fatalf("expected ErrMissingZone, got %v", err)
+	}
+	if _, err := ParseURI(FormatURI(info)); err != ErrMissingZone {
+		t.Fatalf("expected ErrMissingZone, got %v", err)
+	}
+
+	global := AddrInfo{Addr: netip.MustParseAddr("2001:db8::1"), Port: 1}
+	if _, err := global.DialAddr(); err != nil {
+		t.Fatalf("DialAddr: %v", err)
+	}
+}
+
+func TestParseURIRejectsGarbage(t *testing.T) {
+	for _, s := range []string{
+		"http://x@[::1]:1",
+		"i6p://[::1]:1",
+		"i6p://zz@[::1]:1",
+		"i6p://" + strings.Repeat("00", 32) + "@[::1]",
+	} {
+		if _, err := ParseURI(s); err != ErrInvalidURI {
+			t.Fatalf("%s: expected ErrInvalidURI, got %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package discovery
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// DefaultWatchInterval is how often Watch polls resolvers that are not a
+// Watcher.
+const DefaultWatchInterval = 10 * time.Second
+
+// EventType is the kind of change an Event reports.
+type EventType int
+
+const (
+	EventAdded   EventType = iota // a peer appeared
+	EventUpdated                  // a peer's address or capabilities changed
+	EventRemoved                  // a peer's record expired or was withdrawn
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAdded:
+		return "added"
+	case EventUpdated:
+		return "updated"
+	case EventRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event reports a change in the peers a resolver knows. Info is the new
+// record, or the last one seen for EventRemoved.
+type Event struct {
+	Type EventType
+	Info AddrInfo
+}
+
+// Watcher is implemented by resolvers that push changes instead of being
+// polled. Watch first reports every known peer as EventAdded, then each
+// change, until ctx is done; the channel is then closed. Announcements that
+// only refresh ExpiresAt are not reported.
+type Watcher interface {
+	Watch(ctx context.Context) <-chan Event
+}
+
+// SameRecord reports whether a and b advertise the same peer at the same
+// address with the same capabilities, ignoring ExpiresAt.
+func (a AddrInfo) SameRecord(b AddrInfo) bool {
+	return a.PeerID == b.PeerID && a.Addr == b.Addr && a.Port == b.Port &&
+		maps.Equal(a.Capabilities, b.Capabilities)
+}
+
+// Watch returns the changes of r as Watcher describes them. Resolvers that
+// are not a Watcher are polled with List every interval (DefaultWatchInterval
+// if interval is zero), and polls that fail are skipped.
+func Watch(ctx context.Context, r Resolver, interval time.Duration) <-chan Event {
+	if w, ok := r.(Watcher); ok {
+		return w.Watch(ctx)
+	}
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	out := make(chan Event)
+	go poll(ctx, r, interval, out)
+	return out
+}
+
+func poll(ctx context.Context, r Resolver, interval time.Duration, out chan<- Event) {
+	defer close(out)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	known := map[identity.PeerID]AddrInfo{}
+	for {
+		if all, err := r.List(); err == nil {
+			for _, e := range diff(known, all) {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// diff updates known to all and returns the events between them.
+func diff(known map[identity.PeerID]AddrInfo, all []AddrInfo) []Event {
+	var events []Event
+	seen := make(map[identity.PeerID]bool, len(all))
+	for _, info := range all {
+		seen[info.PeerID] = true
+		old, ok := known[info.PeerID]
+		switch {
+		case !ok:
+			events = append(events, Event{Type: EventAdded, Info: info})
+		case !old.SameRecord(info):
+			events = append(events, Event{Type: EventUpdated, Info: info})
+		}
+		known[info.PeerID] = info
+	}
+	for id, info := range known {
+		if !seen[id] {
+			events = append(events, Event{Type: EventRemoved, Info: info})
+			delete(known, id)
+		}
+	}
+	return events
+}
@@ -0,0 +1,77 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// listResolver is a Resolver that only supports List.
+type listResolver struct {
+	mu    sync.Mutex
+	peers []AddrInfo
+}
+
+func (r *listResolver) set(peers ...AddrInfo) {
+	r.mu.Lock()
+	r.peers = peers
+	r.mu.Unlock()
+}
+
+func (r *listResolver) Announce(AddrInfo) error { return nil }
+
+func (r *listResolver) Lookup(identity.PeerID) (AddrInfo, error) { return AddrInfo{}, ErrNotFound }
+
+func (r *listResolver) List() ([]AddrInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]AddrInfo(nil), r.peers...), nil
+}
+
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("no event")
+		return Event{}
+	}
+}
+
+func TestWatchPolls(t *testing.T) {
+	a := AddrInfo{PeerID: identity.PeerIDFromPublicKey([]byte("a")), Port: 1}
+	b := AddrInfo{PeerID: identity.PeerIDFromPublicKey([]byte("b")), Port: 2}
+	r := &listResolver{}
+	r.set(a)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := Watch(ctx, r, 5*time.Millisecond)
+	if e := nextEvent(t, events); e.Type != EventAdded || e.Info.PeerID != a.PeerID {
+		t.Fatalf("first event = %v %v", e.Type, e.Info.PeerID)
+	}
+
+	// A refresh that only moves ExpiresAt is not a change.
+	r.set(a.WithTTL(time.Minute), b)
+	if e := nextEvent(t, events); e.Type != EventAdded || e.Info.PeerID != b.PeerID {
+		t.Fatalf("second event = %v %v", e.Type, e.Info.PeerID)
+	}
+
+	a.Port = 3
+	r.set(a)
+	got := map[EventType]identity.PeerID{}
+	for range 2 {
+		e := nextEvent(t, events)
+		got[e.Type] = e.Info.PeerID
+	}
+	if got[EventUpdated] != a.PeerID || got[EventRemoved] != b.PeerID {
+		t.Fatalf("events = %v", got)
+	}
+
+	cancel()
+	for range events {
+	}
+}
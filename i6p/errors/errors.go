@@ -0,0 +1,266 @@
+// Package errors classifies I6P failures so callers can tell them apart
+// programmatically: which layer failed (Category), what happened (Code), and
+// whether trying again may help (Retryable).
+//
+// Codes are also the application error codes I6P peers close connections
+// with, so a session's CloseReason carries the peer's code as an *Error with
+// Remote set. Both codes and categories work with errors.Is:
+//
+//	if errors.Is(err, i6perrors.Handshake) { ... }
+//	if errors.Is(err, i6perrors.CodeRateLimited) { ... }
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// Category is the layer a failure comes from. It implements error so it
+// can be the target of errors.Is.
+type Category uint8
+
+const (
+	Unknown Category = iota
+	Transport
+	Handshake
+	Crypto
+	Transfer
+	Discovery
+)
+
+func (c Category) String() string {
+	switch c {
+	case Transport:
+		return "transport"
+	case Handshake:
+		return "handshake"
+	case Crypto:
+		return "crypto"
+	case Transfer:
+		return "transfer"
+	case Discovery:
+		return "discovery"
+	default:
+		return "unknown"
+	}
+}
+
+func (c Category) Error() string { return "i6p: " + c.String() + " error" }
+
+// Code identifies a failure. The second byte is its Category. Code
+// implements error so it can be the target of errors.Is.
+type Code uint64
+
+const (
+	CodeNone     Code = 0x000 // normal close, no error
+	CodeUnknown  Code = 0x001 // an error I6P did not classify
+	CodeInternal Code = 0x002 // a local bug, such as a recovered panic
+
+	CodeTransport   Code = 0x100 // transport failure
+	CodeTimeout     Code = 0x101 // the peer stopped responding
+	CodeRateLimited Code = 0x102 // too many connections; try later
+	CodeDraining    Code = 0x103 // the peer is shutting down
+	CodeReplaced    Code = 0x104 // a newer connection to the same peer took over
+
+	CodeHandshake         Code = 0x200 // handshake failure
+	CodeHandshakeTimeout  Code = 0x201 // the handshake did not finish in time
+	CodeRejected          Code = 0x202 // the accept policy refused the peer
+	CodePeerMismatch      Code = 0x203 // the peer is not the one dialed
+	CodeProtocolViolation Code = 0x204 // the peer broke the wire protocol
+	CodeVersion           Code = 0x205 // no common protocol version
+
+	CodeCrypto         Code = 0x300 // cryptographic failure
+	CodeAuthentication Code = 0x301 // a signature or MAC did not verify
+	CodeReplay         Code = 0x302 // a message or ticket was replayed
+
+	CodeTransfer  Code = 0x400 // transfer failure
+	CodeIntegrity Code = 0x401 // data did not match its hash
+	CodeQuota     Code = 0x402 // a storage or mailbox quota is used up
+	CodeTooLarge  Code = 0x403 // a message or object exceeds a limit
+
+	CodeDiscovery Code = 0x500 // discovery failure
+	CodeNotFound  Code = 0x501 // no record for the peer
+)
+
+var codeNames = map[Code]string{
+	CodeNone:              "no error",
+	CodeUnknown:           "unknown error",
+	CodeInternal:          "internal error",
+	CodeTransport:         "transport error",
+	CodeTimeout:           "timeout",
+	CodeRateLimited:       "rate limited",
+	CodeDraining:          "draining",
+	CodeReplaced:          "replaced",
+	CodeHandshake:         "handshake error",
+	CodeHandshakeTimeout:  "handshake timeout",
+	CodeRejected:          "rejected",
+	CodePeerMismatch:      "peer id mismatch",
+	CodeProtocolViolation: "protocol violation",
+	CodeVersion:           "version mismatch",
+	CodeCrypto:            "crypto error",
+	CodeAuthentication:    "authentication failed",
+	CodeReplay:            "replay",
+	CodeTransfer:          "transfer error",
+	CodeIntegrity:         "integrity check failed",
+	CodeQuota:             "quota exceeded",
+	CodeTooLarge:          "too large",
+	CodeDiscovery:         "discovery error",
+	CodeNotFound:          "not found",
+}
+
+// retryable lists the codes whose failures may succeed if tried again,
+// possibly after a backoff.
+var retryable = map[Code]bool{
+	CodeTransport:        true,
+	CodeTimeout:          true,
+	CodeRateLimited:      true,
+	CodeDraining:         true,
+	CodeReplaced:         true,
+	CodeHandshakeTimeout: true,
+	CodeIntegrity:        true,
+	CodeNotFound:         true,
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("code %#x", uint64(c))
+}
+
+func (c Code) Error() string { return "i6p: " + c.String() }
+
+// Category returns the layer c belongs to.
+func (c Code) Category() Category {
+	if c>>8 > Code(Discovery) {
+		return Unknown
+	}
+	return Category(c >> 8)
+}
+
+// Retryable reports whether a failure with code c may succeed if tried
+// again.
+func (c Code) Retryable() bool { return retryable[c] }
+
+// Error is an I6P failure with a code.
+type Error struct {
+	Code   Code
+	Op     string // what failed, e.g. "dial" or "handshake"; may be empty
+	Remote bool   // the peer reported the failure when closing the connection
+	Err    error  // the underlying error; may be nil
+}
+
+// New returns an *Error for code with msg as its cause.
+func New(code Code, op, msg string) error {
+	return &Error{Code: code, Op: op, Err: stderrors.New(msg)}
+}
+
+// Wrap returns err classified as code, or nil if err is nil.
+func Wrap(code Code, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Op: op, Err: err}
+}
+
+func (e *Error) Error() string {
+	s := "i6p: "
+	if e.Op != "" {
+		s += e.Op + ": "
+	}
+	if e.Remote {
+		s += "closed by peer: "
+	}
+	if e.Err == nil {
+		return s + e.Code.String()
+	}
+	return s + e.Err.Error() + " [" + e.Code.String() + "]"
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is matches a Code equal to e.Code, or the Category of e.Code.
+func (e *Error) Is(target error) bool {
+	switch t := target.(type) {
+	case Code:
+		return e.Code == t
+	case Category:
+		return e.Code.Category() == t
+	}
+	return false
+}
+
+// CodeOf returns the code of the first *Error in err's chain, or else the
+// code of a transport.CloseError. Other errors get CodeTimeout if they are
+// timeouts and CodeUnknown if not; a nil err gets CodeNone.
+func CodeOf(err error) Code {
+	if err == nil {
+		return CodeNone
+	}
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e.Code
+	}
+	var ce *transport.CloseError
+	if stderrors.As(err, &ce) {
+		return Code(ce.Code)
+	}
+	var t interface{ Timeout() bool }
+	if stderrors.As(err, &t) && t.Timeout() {
+		return CodeTimeout
+	}
+	return CodeUnknown
+}
+
+// IsRetryable reports whether the operation that failed with err may
+// succeed if tried again.
+func IsRetryable(err error) bool {
+	return err != nil && CodeOf(err).Retryable()
+}
+
+// FromClose converts the close error of a connection to an *Error carrying
+// the code the connection was closed with. Other errors are returned as is.
+func FromClose(err error) error {
+	var ce *transport.CloseError
+	if !stderrors.As(err, &ce) {
+		return err
+	}
+	var e *Error
+	if stderrors.As(err, &e) {
+		return err
+	}
+	return &Error{Code: Code(ce.Code), Remote: ce.Remote, Err: err}
+}
+
+// PanicError is a panic recovered by Recover.
+type PanicError struct {
+	Value any    // the value passed to panic
+	Stack []byte // the stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string { return fmt.Sprintf("panic: %v", e.Value) }
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Recover turns a panic into an *Error with CodeInternal wrapping a
+// *PanicError, stored in *errp. It must be deferred directly:
+//
+//	func handle(b []byte) (err error) {
+//		defer i6perrors.Recover(&err, "handle")
+//		...
+//	}
+//
+// Code that parses input from peers runs under Recover at its entry points,
+// so a malformed message fails one request instead of the process.
+func Recover(errp *error, op string) {
+	if v := recover(); v != nil {
+		*errp = &Error{Code: CodeInternal, Op: op, Err: &PanicError{Value: v, Stack: debug.Stack()}}
+	}
+}
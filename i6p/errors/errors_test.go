@@ -0,0 +1,75 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+func TestIs(t *testing.T) {
+	cause := stderrors.New("no HELLO")
+	err := fmt.Errorf("dial: %w", Wrap(CodeHandshakeTimeout, "handshake", cause))
+	if !stderrors.Is(err, CodeHandshakeTimeout) || !stderrors.Is(err, Handshake) || !stderrors.Is(err, cause) {
+		t.Fatalf("errors.Is does not see through %v", err)
+	}
+	if stderrors.Is(err, CodeHandshake) || stderrors.Is(err, Transport) {
+		t.Fatalf("%v matches another code or category", err)
+	}
+	if !IsRetryable(err) || IsRetryable(New(CodeRejected, "", "no")) || IsRetryable(nil) {
+		t.Fatal("retryable classification wrong")
+	}
+	if Wrap(CodeTransfer, "", nil) != nil {
+		t.Fatal("Wrap(nil) is not nil")
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want Code
+	}{
+		{nil, CodeNone},
+		{stderrors.New("boom"), CodeUnknown},
+		{fmt.Errorf("read: %w", os.ErrDeadlineExceeded), CodeTimeout},
+		{&transport.CloseError{Code: uint64(CodeQuota)}, CodeQuota},
+		{Wrap(CodeIntegrity, "fetch", &transport.CloseError{Code: 7}), CodeIntegrity},
+	} {
+		if got := CodeOf(tc.err); got != tc.want {
+			t.Errorf("CodeOf(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+	if CodeQuota.Category() != Transfer || Code(0x9901).Category() != Unknown || CodeUnknown.Category() != Unknown {
+		t.Fatal("Category wrong")
+	}
+}
+
+func TestFromClose(t *testing.T) {
+	err := FromClose(&transport.CloseError{Code: uint64(CodeRateLimited), Message: "busy", Remote: true})
+	var e *Error
+	if !stderrors.As(err, &e) || e.Code != CodeRateLimited || !e.Remote || !IsRetryable(err) {
+		t.Fatalf("FromClose = %#v", err)
+	}
+	plain := stderrors.New("eof")
+	if FromClose(plain) != plain {
+		t.Fatal("FromClose changed an error that is not a close")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	handle := func(b []byte) (err error) {
+		defer Recover(&err, "handle")
+		_ = b[4]
+		return nil
+	}
+	err := handle(nil)
+	var p *PanicError
+	if !stderrors.Is(err, CodeInternal) || !stderrors.As(err, &p) || len(p.Stack) == 0 {
+		t.Fatalf("recovered panic = %v", err)
+	}
+	if err := handle(make([]byte, 5)); err != nil {
+		t.Fatalf("Recover without a panic: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package fault defines hooks for injecting failures into transports and
+// transfers, so resilience can be tested without a real lossy network.
+//
+// Components that support injection take an Injector and consult it at named
+// points. The default is Nop, which injects nothing; a nil Injector is treated
+// the same way.
+package fault
+
+import (
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Point names a place where faults can be injected.
+type Point string
+
+const (
+	// StreamWrite is a single Write on a memory transport stream.
+	StreamWrite Point = "stream.write"
+	// BatchRead is a batch received by a transfer.ParallelReader.
+	BatchRead Point = "batch.read"
+	// ChunkData is the payload of one chunk received in a batch.
+	ChunkData Point = "chunk.data"
+)
+
+// Injector decides which faults happen at a point. Implementations must be
+// safe for concurrent use.
+type Injector interface {
+	// Drop reports whether the unit at p is silently lost.
+	Drop(p Point) bool
+	// Delay returns how long to hold the unit at p before handling it.
+	Delay(p Point) time.Duration
+	// Corrupt returns b, or a damaged copy of it. It must not modify b.
+	Corrupt(p Point, b []byte) []byte
+}
+
+// Nop is the Injector that injects nothing.
+var Nop Injector = nop{}
+
+type nop struct{}
+
+func (nop) Drop(Point) bool                  { return false }
+func (nop) Delay(Point) time.Duration        { return 0 }
+func (nop) Corrupt(_ Point, b []byte) []byte { return b }
+
+// Or returns inj, or Nop if inj is nil.
+func Or(inj Injector) Injector {
+	if inj == nil {
+		return Nop
+	}
+	return inj
+}
+
+// Config sets the probabilities used by a Random injector.
+type Config struct {
+	DropRate    float64       // probability a unit is dropped
+	CorruptRate float64       // probability a unit has one byte flipped
+	DelayRate   float64       // probability a unit is delayed
+	MaxDelay    time.Duration // delays are drawn uniformly from [0, MaxDelay)
+	Points      []Point       // points to act on; empty means all
+}
+
+// Random injects faults with fixed probabilities, drawing from a seeded
+// source so a failing run can be reproduced.
+type Random struct {
+	cfg Config
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewRandom creates a Random injector.
+func NewRandom(seed int64, cfg Config) *Random {
+	return &Random{cfg: cfg, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (r *Random) applies(p Point) bool {
+	return len(r.cfg.Points) == 0 || slices.Contains(r.cfg.Points, p)
+}
+
+func (r *Random) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Float64() < rate
+}
+
+func (r *Random) Drop(p Point) bool {
+	return r.applies(p) && r.chance(r.cfg.DropRate)
+}
+
+func (r *Random) Delay(p Point) time.Duration {
+	if !r.applies(p) || r.cfg.MaxDelay <= 0 || !r.chance(r.cfg.DelayRate) {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Duration(r.rng.Int63n(int64(r.cfg.MaxDelay)))
+}
+
+func (r *Random) Corrupt(p Point, b []byte) []byte {
+	if len(b) == 0 || !r.applies(p) || !r.chance(r.cfg.CorruptRate) {
+		return b
+	}
+	r.mu.Lock()
+	i := r.rng.Intn(len(b))
+	bit := byte(1) << r.rng.Intn(8)
+	r.mu.Unlock()
+	out := append([]byte(nil), b...)
+	out[i] ^= bit
+	return out
+}
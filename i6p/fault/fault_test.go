@@ -0,0 +1,55 @@
+package fault
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRandomIsSeeded(t *testing.T) {
+	cfg := Config{DropRate: 0.5, DelayRate: 0.5, MaxDelay: time.Second}
+	a, b := NewRandom(9, cfg), NewRandom(9, cfg)
+	drops := 0
+	for i := 0; i < 200; i++ {
+		da, db := a.Drop(StreamWrite), b.Drop(StreamWrite)
+		if da != db {
+			t.Fatalf("draw %d differs between injectors with the same seed", i)
+		}
+		if da {
+			drops++
+		}
+		if a.Delay(BatchRead) != b.Delay(BatchRead) {
+			t.Fatalf("delay %d differs between injectors with the same seed", i)
+		}
+	}
+	if drops < 60 || drops > 140 {
+		t.Fatalf("dropped %d of 200 at 50%%", drops)
+	}
+}
+
+func TestRandomCorruptAndPoints(t *testing.T) {
+	r := NewRandom(1, Config{CorruptRate: 1, DropRate: 1, Points: []Point{ChunkData}})
+	in := []byte("payload")
+	out := r.Corrupt(ChunkData, in)
+	if string(in) != "payload" {
+		t.Fatalf("Corrupt modified its input")
+	}
+	diff := 0
+	for i := range in {
+		if in[i] != out[i] {
+			diff++
+		}
+	}
+	if diff != 1 {
+		t.Fatalf("expected one damaged byte, got %d", diff)
+	}
+	if r.Drop(StreamWrite) || !bytes.Equal(r.Corrupt(StreamWrite, in), in) {
+		t.Fatalf("injector acted on a point it was not configured for")
+	}
+	if !r.Drop(ChunkData) {
+		t.Fatalf("expected drop at configured point")
+	}
+	if Or(nil) != Nop || Nop.Drop(ChunkData) || Nop.Delay(ChunkData) != 0 {
+		t.Fatalf("Nop injected a fault")
+	}
+}
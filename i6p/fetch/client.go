@@ -0,0 +1,102 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+)
+
+// DefaultWindow is how many chunks Fetch requests per round trip by
+// default.
+const DefaultWindow = 16
+
+// Options configures Fetch.
+type Options struct {
+	// Quota, if set, must admit the object's size for the serving peer
+	// before any chunk is requested; the reservation is released when
+	// Fetch returns.
+	Quota *transfer.Quota
+	// Window is the number of chunks requested per round trip, and so the
+	// number held in memory at once (default DefaultWindow).
+	Window int
+	// Progress, if set, is called with the bytes written so far after each
+	// window.
+	Progress func(done, total int64)
+}
+
+// Fetch downloads the object with Merkle root root from the server on s
+// into w and returns its manifest. The manifest is checked against root
+// and every chunk against the manifest before it is written, so w only
+// receives verified data, though an error can leave it partly written.
+// Quota rejections are returned as *transfer.PreflightError.
+func Fetch(ctx context.Context, s *session.Session, root []byte, w io.WriterAt, opts Options) (*transfer.Manifest, error) {
+	st, err := s.OpenProtocolStream(ctx, ProtocolName)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	stop := context.AfterFunc(ctx, func() { _ = st.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	m, err := fetch(ctx, s, st, root, w, opts)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return m, err
+}
+
+func fetch(ctx context.Context, s *session.Session, rw io.ReadWriter, root []byte, w io.WriterAt, opts Options) (*transfer.Manifest, error) {
+	if err := writeMsg(rw, request{Root: root}); err != nil {
+		return nil, err
+	}
+	var resp response
+	if err := readMsg(rw, &resp); err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.Error == ErrNotFound.Error():
+		return nil, ErrNotFound
+	case resp.Error != "":
+		return nil, fmt.Errorf("%w: %s", ErrRemoteFail, resp.Error)
+	case resp.Manifest == nil:
+		return nil, ErrMessage
+	}
+	m := resp.Manifest
+	if err := m.Validate(root); err != nil {
+		return nil, err
+	}
+	if opts.Quota != nil {
+		release, err := opts.Quota.Preflight(s.RemotePeerID(), m)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	rr, err := transfer.NewRangeReader(ctx, rw, m)
+	if err != nil {
+		return nil, err
+	}
+	window := opts.Window
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	step := int64(window) * int64(m.ChunkSize)
+	for off := int64(0); off < m.Size; off += step {
+		data, err := rr.ReadRange(ctx, off, min(step, m.Size-off))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.WriteAt(data, off); err != nil {
+			return nil, err
+		}
+		if opts.Progress != nil {
+			opts.Progress(off+int64(len(data)), m.Size)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,84 @@
+// Package fetch lets a receiver download an object from a peer that
+// publishes it, driving the transfer itself: it asks for the manifest, then
+// for the chunks it wants with GET_CHUNKS, so it paces the transfer, can
+// check its quota first and verifies every chunk against the manifest's
+// Merkle root as it arrives.
+//
+// An object is named by its manifest's Merkle root. One fetch travels per
+// stream, tagged ProtocolName on sessions that negotiated stream protocols:
+//
+//	receiver -> request with the root;  server -> response with the manifest
+//	receiver -> GET_CHUNKS frames;      server -> CHUNK_DATA / CHUNKS_UNAVAILABLE frames
+//	receiver closes its side when done
+package fetch
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/TheusHen/I6P/i6p/transfer"
+)
+
+// ProtocolName tags fetch streams (see session.OpenProtocolStream).
+const ProtocolName = "i6p/fetch/1"
+
+// maxMessage bounds one control message. A manifest lists 32 bytes per
+// chunk, so this allows objects of several hundred thousand chunks.
+const maxMessage = 16 << 20
+
+var (
+	ErrMessage    = errors.New("fetch: malformed message")
+	ErrTooLarge   = errors.New("fetch: message too large")
+	ErrNotFound   = errors.New("fetch: object not published")
+	ErrRemoteFail = errors.New("fetch: remote failed")
+)
+
+type request struct {
+	Root []byte `json:"root"`
+}
+
+type response struct {
+	Error    string             `json:"error,omitempty"`
+	Manifest *transfer.Manifest `json:"manifest,omitempty"`
+}
+
+// writeMsg sends v as length-prefixed JSON.
+func writeMsg(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMessage {
+		return ErrTooLarge
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// readMsg reads a message written by writeMsg into v.
+func readMsg(r io.Reader, v any) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxMessage {
+		return ErrTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMessage, err)
+	}
+	return nil
+}
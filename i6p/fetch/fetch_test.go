@@ -0,0 +1,84 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+func TestFetch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	caps := map[string]string{session.StreamProtocolCapability: "1"}
+	network := memory.NewNetwork()
+	aliceKP, _ := identity.GenerateKeyPair()
+	bobKP, _ := identity.GenerateKeyPair()
+	bob := i6p.NewPeer(bobKP, caps)
+	ln, _ := network.Listen("bob")
+	bob.Serve(ln)
+	server := NewServer()
+	go func() {
+		s, err := bob.Accept(ctx)
+		if err == nil {
+			_ = server.Serve(ctx, s)
+		}
+	}()
+	conn, _ := network.Dial(ctx, "bob")
+	sess, err := i6p.NewPeer(aliceKP, caps).Connect(ctx, conn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	data := make([]byte, 40*1024+77)
+	rand.New(rand.NewSource(1)).Read(data)
+	published, err := server.PublishData(data, 1024)
+	if err != nil {
+		t.Fatalf("PublishData: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "object")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var progress []int64
+	m, err := Fetch(ctx, sess, published.Root, f, Options{
+		Window:   8,
+		Progress: func(done, _ int64) { progress = append(progress, done) },
+	})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	got, _ := os.ReadFile(path)
+	if !bytes.Equal(got, data) || m.Size != int64(len(data)) {
+		t.Fatal("fetched data differs")
+	}
+	if len(progress) != 6 || progress[5] != int64(len(data)) {
+		t.Fatalf("progress %v", progress)
+	}
+
+	// The quota is checked against the manifest before any chunk.
+	quota := transfer.NewQuota(int64(len(data))-1, "")
+	var pe *transfer.PreflightError
+	if _, err := Fetch(ctx, sess, published.Root, f, Options{Quota: quota}); !errors.As(err, &pe) {
+		t.Fatalf("Fetch over quota: %v", err)
+	}
+
+	server.Unpublish(published.Root)
+	if _, err := Fetch(ctx, sess, published.Root, f, Options{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Fetch of unpublished object: %v", err)
+	}
+}
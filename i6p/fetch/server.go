@@ -0,0 +1,101 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+type object struct {
+	m      *transfer.Manifest
+	lookup transfer.ChunkLookup
+}
+
+// Server answers fetches for the objects published on it. It is safe for
+// concurrent use.
+type Server struct {
+	mu      sync.RWMutex
+	objects map[string]object
+}
+
+// NewServer creates a server with nothing published.
+func NewServer() *Server {
+	return &Server{objects: make(map[string]object)}
+}
+
+// Publish offers the object described by m, whose chunks lookup returns,
+// under m.Root.
+func (sv *Server) Publish(m *transfer.Manifest, lookup transfer.ChunkLookup) error {
+	if err := m.Validate(nil); err != nil {
+		return err
+	}
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.objects[string(m.Root)] = object{m: m, lookup: lookup}
+	return nil
+}
+
+// PublishData chunks data, publishes it and returns its manifest. Chunks
+// are compressed as they are requested.
+func (sv *Server) PublishData(data []byte, chunkSize int) (*transfer.Manifest, error) {
+	m, err := transfer.BuildManifest(data, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	lookup := func(index int) (transfer.CompressedChunk, bool) {
+		if index < 0 || index >= m.NumChunks() {
+			return transfer.CompressedChunk{}, false
+		}
+		start := int64(index) * int64(m.ChunkSize)
+		c := transfer.Chunk{
+			Index: index,
+			Data:  data[start : start+int64(m.ChunkLen(index))],
+			Hash:  m.ChunkHashes[index],
+		}
+		return transfer.CompressChunk(c, transfer.CompressionFast), true
+	}
+	return m, sv.Publish(m, lookup)
+}
+
+// Unpublish withdraws the object with Merkle root root. Fetches already
+// under way keep being served.
+func (sv *Server) Unpublish(root []byte) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	delete(sv.objects, string(root))
+}
+
+// Serve answers fetches on s until the session ends or ctx is done. Streams
+// of other protocols are left to the session's other handlers, and errors go
+// to its stream error hook.
+func (sv *Server) Serve(ctx context.Context, s *session.Session) error {
+	return s.ServeProtocol(ctx, ProtocolName, func(st transport.Stream) error {
+		return sv.Handle(st)
+	})
+}
+
+// Handle answers one fetch read from rw, until the receiver closes its
+// side.
+func (sv *Server) Handle(rw io.ReadWriter) (err error) {
+	defer i6perrors.Recover(&err, "fetch")
+	var req request
+	if err := readMsg(rw, &req); err != nil {
+		return err
+	}
+	sv.mu.RLock()
+	obj, ok := sv.objects[string(req.Root)]
+	sv.mu.RUnlock()
+	if !ok {
+		_ = writeMsg(rw, response{Error: ErrNotFound.Error()})
+		return ErrNotFound
+	}
+	if err := writeMsg(rw, response{Manifest: obj.m}); err != nil {
+		return err
+	}
+	return transfer.ServeGetChunks(rw, obj.m.Root, obj.lookup)
+}
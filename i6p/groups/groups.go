@@ -0,0 +1,174 @@
+// Package groups restricts peers to the members of a group, such as the
+// devices of one team, whose roster is controlled by admins.
+//
+// Every device keeps a Group: the admins' Ed25519 keys it trusts, the number
+// of them (Threshold) that must sign a change, and the current roster of
+// member PeerIDs. Admins publish signed Updates, which add or remove one
+// member, and signed Rosters, full snapshots that can also change the admins
+// and the threshold. Both carry a version, so stale or replayed changes are
+// refused, and both must be signed by Threshold admins of the current
+// roster. Updates apply in order; a Roster may skip versions.
+// Group.AcceptPolicy then limits a Peer to members.
+package groups
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+var (
+	ErrThreshold = errors.New("groups: threshold must be between 1 and the number of admins")
+	ErrGroup     = errors.New("groups: change is for another group")
+	ErrVersion   = errors.New("groups: change does not follow the current version")
+	ErrApproval  = errors.New("groups: not enough admin signatures")
+	ErrOp        = errors.New("groups: invalid membership change")
+	ErrNotMember = errors.New("groups: peer is not a member")
+	ErrSigner    = errors.New("groups: signer does not hold an Ed25519 key")
+)
+
+// Group is a device's view of a group. It is safe for concurrent use.
+type Group struct {
+	mu        sync.RWMutex
+	name      string
+	version   uint64
+	threshold int
+	admins    []ed25519.PublicKey
+	members   map[identity.PeerID]bool
+}
+
+// New creates a group at version 0 trusting admins, threshold of which must
+// sign every change. Admins are members.
+func New(name string, threshold int, admins ...ed25519.PublicKey) (*Group, error) {
+	g := &Group{name: name}
+	if err := g.set(Roster{Group: name, Threshold: threshold, Admins: admins}); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// set replaces the state with r. g.mu must be held or g unshared.
+func (g *Group) set(r Roster) error {
+	if r.Threshold < 1 || r.Threshold > len(r.Admins) {
+		return ErrThreshold
+	}
+	g.version = r.Version
+	g.threshold = r.Threshold
+	g.admins = make([]ed25519.PublicKey, len(r.Admins))
+	g.members = map[identity.PeerID]bool{}
+	for i, a := range r.Admins {
+		if len(a) != ed25519.PublicKeySize {
+			return fmt.Errorf("%w: admin key %d", ErrOp, i)
+		}
+		g.admins[i] = slices.Clone(a)
+		g.members[identity.PeerIDFromPublicKey(a)] = true
+	}
+	for _, m := range r.Members {
+		g.members[m] = true
+	}
+	return nil
+}
+
+// Name returns the group name.
+func (g *Group) Name() string { return g.name }
+
+// Version returns the version of the current roster.
+func (g *Group) Version() uint64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.version
+}
+
+// IsMember reports whether id is in the current roster.
+func (g *Group) IsMember(id identity.PeerID) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.members[id]
+}
+
+// Roster returns an unsigned snapshot of the current state.
+func (g *Group) Roster() Roster {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	r := Roster{Group: g.name, Version: g.version, Threshold: g.threshold}
+	for _, a := range g.admins {
+		r.Admins = append(r.Admins, slices.Clone(a))
+	}
+	for id := range g.members {
+		r.Members = append(r.Members, id)
+	}
+	return r
+}
+
+// check verifies that a change of group name to version is signed by enough
+// current admins. Snapshots may skip versions; updates must not. g.mu must
+// be held.
+func (g *Group) check(name string, version uint64, snapshot bool, sigs []Signature, msg []byte) error {
+	if name != g.name {
+		return ErrGroup
+	}
+	if version <= g.version || !snapshot && version != g.version+1 {
+		return fmt.Errorf("%w: %d after %d", ErrVersion, version, g.version)
+	}
+	if n := approvals(g.admins, sigs, msg); n < g.threshold {
+		return fmt.Errorf("%w: %d of %d", ErrApproval, n, g.threshold)
+	}
+	return nil
+}
+
+// Apply verifies u against the current roster and applies it. Admins cannot
+// leave through an Update; a Roster removes them.
+func (g *Group) Apply(u Update) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.check(u.Group, u.Version, false, u.Signatures, u.SigningBytes()); err != nil {
+		return err
+	}
+	switch u.Op {
+	case OpJoin:
+		g.members[u.Member] = true
+	case OpLeave:
+		for _, a := range g.admins {
+			if identity.PeerIDFromPublicKey(a) == u.Member {
+				return fmt.Errorf("%w: admins leave through a roster", ErrOp)
+			}
+		}
+		delete(g.members, u.Member)
+	default:
+		return ErrOp
+	}
+	g.version = u.Version
+	return nil
+}
+
+// Replace verifies r, which must be newer than the current roster and signed
+// by Threshold current admins, and makes it the current roster.
+func (g *Group) Replace(r Roster) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.check(r.Group, r.Version, true, r.Signatures, r.SigningBytes()); err != nil {
+		return err
+	}
+	next := &Group{name: g.name}
+	if err := next.set(r); err != nil {
+		return err
+	}
+	g.version, g.threshold, g.admins, g.members = next.version, next.threshold, next.admins, next.members
+	return nil
+}
+
+// AcceptPolicy returns a policy that lets only current members keep a
+// session (see i6p.PeerConfig). It follows later changes to the roster.
+func (g *Group) AcceptPolicy() i6p.AcceptPolicy {
+	return func(remote identity.PeerID, _ map[string]string) error {
+		if !g.IsMember(remote) {
+			return fmt.Errorf("%w: %s", ErrNotMember, remote)
+		}
+		return nil
+	}
+}
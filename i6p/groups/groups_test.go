@@ -0,0 +1,127 @@
+package groups
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+func keys(t *testing.T, n int) []identity.KeyPair {
+	t.Helper()
+	out := make([]identity.KeyPair, n)
+	for i := range out {
+		out[i], _ = identity.GenerateKeyPair()
+	}
+	return out
+}
+
+func TestUpdates(t *testing.T) {
+	admins := keys(t, 3)
+	g, err := New("team", 2, admins[0].PublicKey, admins[1].PublicKey, admins[2].PublicKey)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	device, _ := identity.GenerateKeyPair()
+	if !g.IsMember(admins[0].PeerID()) || g.IsMember(device.PeerID()) {
+		t.Fatalf("initial membership wrong")
+	}
+
+	join := Update{Group: "team", Version: 1, Op: OpJoin, Member: device.PeerID()}
+	_ = join.Sign(admins[0])
+	if err := g.Apply(join); !errors.Is(err, ErrApproval) {
+		t.Fatalf("Apply with one signature: %v", err)
+	}
+	// A second signature from the same admin does not count twice.
+	_ = join.Sign(admins[0])
+	if err := g.Apply(join); !errors.Is(err, ErrApproval) {
+		t.Fatalf("Apply with a duplicate signature: %v", err)
+	}
+	_ = join.Sign(admins[2])
+	// The update survives a JSON round trip.
+	b, _ := json.Marshal(join)
+	var decoded Update
+	_ = json.Unmarshal(b, &decoded)
+	if err := g.Apply(decoded); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if !g.IsMember(device.PeerID()) || g.Version() != 1 {
+		t.Fatalf("device did not join")
+	}
+	if err := g.Apply(join); !errors.Is(err, ErrVersion) {
+		t.Fatalf("replayed update: %v", err)
+	}
+
+	policy := g.AcceptPolicy()
+	if err := policy(device.PeerID(), nil); err != nil {
+		t.Fatalf("policy refused a member: %v", err)
+	}
+	leave := Update{Group: "team", Version: 2, Op: OpLeave, Member: device.PeerID()}
+	_ = leave.Sign(admins[1])
+	_ = leave.Sign(admins[2])
+	if err := g.Apply(leave); err != nil {
+		t.Fatalf("Apply leave: %v", err)
+	}
+	if err := policy(device.PeerID(), nil); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("policy accepted a former member: %v", err)
+	}
+
+	// Signatures do not carry over to a changed update.
+	other := Update{Group: "other", Version: 3, Op: OpJoin, Member: device.PeerID()}
+	_ = other.Sign(admins[0])
+	_ = other.Sign(admins[1])
+	if err := g.Apply(other); !errors.Is(err, ErrGroup) {
+		t.Fatalf("update for another group: %v", err)
+	}
+	forged := other
+	forged.Group = "team"
+	if err := g.Apply(forged); !errors.Is(err, ErrApproval) {
+		t.Fatalf("forged update: %v", err)
+	}
+}
+
+func TestRosterReplace(t *testing.T) {
+	admins := keys(t, 2)
+	g, _ := New("team", 1, admins[0].PublicKey)
+	device, _ := identity.GenerateKeyPair()
+
+	// admins[0] hands control to admins[1] and adds a device.
+	r := g.Roster()
+	r.Version = 5
+	r.Admins = []ed25519.PublicKey{admins[1].PublicKey}
+	r.Members = append(r.Members, device.PeerID())
+	_ = r.Sign(admins[1])
+	if err := g.Replace(r); !errors.Is(err, ErrApproval) {
+		t.Fatalf("roster signed by a non-admin: %v", err)
+	}
+	r.Signatures = nil
+	_ = r.Sign(admins[0])
+	if err := g.Replace(r); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	if g.Version() != 5 || !g.IsMember(device.PeerID()) || !g.IsMember(admins[1].PeerID()) {
+		t.Fatalf("roster not applied")
+	}
+
+	// The old admin no longer controls the group.
+	u := Update{Group: "team", Version: 6, Op: OpJoin, Member: identity.PeerIDFromPublicKey([]byte("x"))}
+	_ = u.Sign(admins[0])
+	if err := g.Apply(u); !errors.Is(err, ErrApproval) {
+		t.Fatalf("update by a removed admin: %v", err)
+	}
+	if err := g.Replace(r); !errors.Is(err, ErrVersion) {
+		t.Fatalf("replayed roster: %v", err)
+	}
+
+	bad := g.Roster()
+	bad.Version, bad.Threshold = 6, 3
+	_ = bad.Sign(admins[1])
+	if err := g.Replace(bad); !errors.Is(err, ErrThreshold) {
+		t.Fatalf("roster with an impossible threshold: %v", err)
+	}
+	if _, err := New("team", 0, admins[0].PublicKey); !errors.Is(err, ErrThreshold) {
+		t.Fatalf("New with threshold 0: %v", err)
+	}
+}
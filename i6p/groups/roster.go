@@ -0,0 +1,161 @@
+package groups
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/binary"
+	"slices"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// Op is the change an Update makes to a roster.
+type Op uint8
+
+const (
+	OpJoin  Op = 1 // add Member
+	OpLeave Op = 2 // remove Member
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpJoin:
+		return "join"
+	case OpLeave:
+		return "leave"
+	default:
+		return "unknown"
+	}
+}
+
+// Signature is one admin's signature of an Update or a Roster.
+type Signature struct {
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Signature []byte            `json:"signature"`
+}
+
+// Signing-bytes kinds, so an update never verifies as a roster.
+const (
+	kindUpdate = 1
+	kindRoster = 2
+)
+
+// Update adds or removes one member. It moves the roster from Version-1 to
+// Version and needs the signatures of Threshold current admins.
+type Update struct {
+	Group      string          `json:"group"`
+	Version    uint64          `json:"version"`
+	Op         Op              `json:"op"`
+	Member     identity.PeerID `json:"member"`
+	Signatures []Signature     `json:"signatures,omitempty"`
+}
+
+// SigningBytes returns the bytes admins sign for u.
+func (u Update) SigningBytes() []byte {
+	b := []byte{kindUpdate}
+	b = appendString(b, u.Group)
+	b = binary.BigEndian.AppendUint64(b, u.Version)
+	b = append(b, byte(u.Op))
+	return append(b, u.Member[:]...)
+}
+
+// Sign adds kp's signature to u.
+func (u *Update) Sign(kp identity.KeyPair) error {
+	return sign(&u.Signatures, kp, u.SigningBytes())
+}
+
+// SignWith adds the signature of an admin whose key is held by signer, such
+// as a threshold frost.Signer, to u.
+func (u *Update) SignWith(signer crypto.Signer) error {
+	return signWith(&u.Signatures, signer, u.SigningBytes())
+}
+
+// Roster is a full, signed snapshot of a group. A newer roster signed by
+// Threshold current admins replaces the group state, which is how admins,
+// the threshold, or many members change at once, and how a new device
+// catches up without replaying every Update.
+type Roster struct {
+	Group      string              `json:"group"`
+	Version    uint64              `json:"version"`
+	Threshold  int                 `json:"threshold"`
+	Admins     []ed25519.PublicKey `json:"admins"`
+	Members    []identity.PeerID   `json:"members"`
+	Signatures []Signature         `json:"signatures,omitempty"`
+}
+
+// SigningBytes returns the bytes admins sign for r. Admins and members are
+// sorted first, so their order does not matter.
+func (r Roster) SigningBytes() []byte {
+	admins := slices.Clone(r.Admins)
+	slices.SortFunc(admins, func(a, b ed25519.PublicKey) int { return bytes.Compare(a, b) })
+	members := slices.Clone(r.Members)
+	slices.SortFunc(members, func(a, b identity.PeerID) int { return bytes.Compare(a[:], b[:]) })
+
+	b := []byte{kindRoster}
+	b = appendString(b, r.Group)
+	b = binary.BigEndian.AppendUint64(b, r.Version)
+	b = binary.BigEndian.AppendUint32(b, uint32(r.Threshold))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(admins)))
+	for _, a := range admins {
+		b = append(b, a...)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(members)))
+	for _, m := range members {
+		b = append(b, m[:]...)
+	}
+	return b
+}
+
+// Sign adds kp's signature to r.
+func (r *Roster) Sign(kp identity.KeyPair) error {
+	return sign(&r.Signatures, kp, r.SigningBytes())
+}
+
+// SignWith adds the signature of an admin whose key is held by signer, such
+// as a threshold frost.Signer, to r.
+func (r *Roster) SignWith(signer crypto.Signer) error {
+	return signWith(&r.Signatures, signer, r.SigningBytes())
+}
+
+func sign(sigs *[]Signature, kp identity.KeyPair, msg []byte) error {
+	sig, err := kp.SignContext(identity.ContextGroup, msg)
+	if err != nil {
+		return err
+	}
+	*sigs = append(*sigs, Signature{PublicKey: kp.PublicKey, Signature: sig})
+	return nil
+}
+
+func signWith(sigs *[]Signature, signer crypto.Signer, msg []byte) error {
+	pub, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return ErrSigner
+	}
+	sig, err := signer.Sign(nil, msg, &ed25519.Options{Context: string(identity.ContextGroup)})
+	if err != nil {
+		return err
+	}
+	*sigs = append(*sigs, Signature{PublicKey: pub, Signature: sig})
+	return nil
+}
+
+// approvals counts the distinct admins whose signature of msg verifies.
+func approvals(admins []ed25519.PublicKey, sigs []Signature, msg []byte) int {
+	seen := map[string]bool{}
+	for _, s := range sigs {
+		k := string(s.PublicKey)
+		if seen[k] || !slices.ContainsFunc(admins, func(a ed25519.PublicKey) bool { return a.Equal(s.PublicKey) }) {
+			continue
+		}
+		if identity.VerifyContext(s.PublicKey, identity.ContextGroup, msg, s.Signature) {
+			seen[k] = true
+		}
+	}
+	return len(seen)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
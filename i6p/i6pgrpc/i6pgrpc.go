@@ -0,0 +1,82 @@
+// Package i6pgrpc runs gRPC between I6P peers.
+//
+// The package does not import gRPC. Streams of an I6P session are already
+// encrypted and bound to both peers' identities, so gRPC needs no transport
+// security of its own: serve on an i6p.NetListener, dial with Dialer, and use
+// insecure transport credentials on both sides.
+//
+//	// server
+//	ln, _ := i6p.NewNetListener(peer)
+//	srv := grpc.NewServer(grpc.Creds(insecure.NewCredentials()))
+//	go srv.Serve(ln)
+//
+//	// client; the target is the server's peer ID
+//	conn, _ := grpc.NewClient("passthrough:///"+serverID.String(),
+//		grpc.WithContextDialer(i6pgrpc.Dialer(peer, resolver)),
+//		grpc.WithTransportCredentials(insecure.NewCredentials()))
+//
+// In a handler, the caller's identity is PeerID(p.Addr) where p comes from
+// peer.FromContext.
+package i6pgrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/discovery"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+)
+
+// ErrNoRoute is returned when the target peer has no open session and no
+// resolver was given.
+var ErrNoRoute = errors.New("i6pgrpc: no session with peer and no resolver")
+
+// Dialer returns a dial function for grpc.WithContextDialer. The target is
+// the remote peer ID in hex. An open session of peer with that peer is
+// reused; otherwise the peer is looked up in r (which may be nil) and dialed.
+func Dialer(peer *i6p.Peer, r discovery.Resolver) func(ctx context.Context, target string) (net.Conn, error) {
+	return func(ctx context.Context, target string) (net.Conn, error) {
+		id, err := identity.ParsePeerIDHex(target)
+		if err != nil {
+			return nil, err
+		}
+		s, err := sessionWith(ctx, peer, r, id)
+		if err != nil {
+			return nil, err
+		}
+		st, err := s.OpenStream(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return s.StreamConn(st), nil
+	}
+}
+
+func sessionWith(ctx context.Context, peer *i6p.Peer, r discovery.Resolver, id identity.PeerID) (*session.Session, error) {
+	for _, s := range peer.Sessions() {
+		if s.RemotePeerID() == id && s.Connection().Context().Err() == nil {
+			return s, nil
+		}
+	}
+	if r == nil {
+		return nil, ErrNoRoute
+	}
+	info, err := r.Lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	return peer.DialInfo(ctx, info)
+}
+
+// PeerID returns the peer identity carried by an address of a connection
+// made over I6P, such as the peer address gRPC reports to handlers.
+func PeerID(a net.Addr) (identity.PeerID, bool) {
+	sa, ok := a.(session.Addr)
+	if !ok {
+		return identity.PeerID{}, false
+	}
+	return sa.PeerID, true
+}
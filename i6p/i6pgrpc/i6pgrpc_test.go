@@ -0,0 +1,74 @@
+package i6pgrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+func TestDialerReusesSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	network := memory.NewNetwork()
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+	server := i6p.NewPeer(serverKP, nil)
+	mln, _ := network.Listen("server")
+	server.Serve(mln)
+	ln, err := i6p.NewNetListener(server)
+	if err != nil {
+		t.Fatalf("NewNetListener: %v", err)
+	}
+	defer ln.Close()
+	callers := make(chan net.Addr, 1)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			callers <- c.RemoteAddr()
+			go func() { _, _ = io.Copy(c, c); _ = c.Close() }()
+		}
+	}()
+
+	client := i6p.NewPeer(clientKP, nil)
+	conn, _ := network.Dial(ctx, "server")
+	if _, err := client.Connect(ctx, conn); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	dial := Dialer(client, nil)
+	c, err := dial(ctx, serverKP.PeerID().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	if id, ok := PeerID(c.RemoteAddr()); !ok || id != serverKP.PeerID() {
+		t.Fatalf("unexpected remote address %v", c.RemoteAddr())
+	}
+	_, _ = c.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo: %q %v", buf, err)
+	}
+	if id, ok := PeerID(<-callers); !ok || id != clientKP.PeerID() {
+		t.Fatal("server did not see the client's peer ID")
+	}
+
+	other, _ := identity.GenerateKeyPair()
+	if _, err := dial(ctx, other.PeerID().String()); !errors.Is(err, ErrNoRoute) {
+		t.Fatalf("expected ErrNoRoute, got %v", err)
+	}
+	if _, ok := PeerID(&net.TCPAddr{}); ok {
+		t.Fatal("PeerID accepted a non-I6P address")
+	}
+}
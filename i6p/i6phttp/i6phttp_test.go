@@ -0,0 +1,96 @@
+package i6phttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+func TestTransportAndAuthorize(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	network := memory.NewNetwork()
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+	strangerKP, _ := identity.GenerateKeyPair()
+
+	server := i6p.NewPeer(serverKP, nil)
+	ln, _ := network.Listen("server")
+	server.Serve(ln)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := RemotePeer(r)
+		_, _ = io.WriteString(w, id.String())
+	})
+	mux.Handle("/private", Authorize(AllowPeers(clientKP.PeerID()), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secret")
+	})))
+	go func() { _ = Serve(server, mux) }()
+
+	client := func(kp identity.KeyPair, dials *atomic.Int32) *http.Client {
+		peer := i6p.NewPeer(kp, nil)
+		return &http.Client{Transport: &Transport{
+			Peer: peer,
+			Dial: func(ctx context.Context, id identity.PeerID) (*session.Session, error) {
+				dials.Add(1)
+				conn, err := network.Dial(ctx, "server")
+				if err != nil {
+					return nil, err
+				}
+				return peer.Connect(ctx, conn)
+			},
+		}}
+	}
+	get := func(c *http.Client, path string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(ctx, "GET", "http://"+serverKP.PeerID().String()+path, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	var dials, strangerDials atomic.Int32
+	alice := client(clientKP, &dials)
+	for i := 0; i < 3; i++ {
+		if code, body := get(alice, "/whoami"); code != http.StatusOK || body != clientKP.PeerID().String() {
+			t.Fatalf("whoami: %d %q", code, body)
+		}
+	}
+	if code, body := get(alice, "/private"); code != http.StatusOK || body != "secret" {
+		t.Fatalf("authorized request: %d %q", code, body)
+	}
+	if n := dials.Load(); n != 1 {
+		t.Fatalf("expected one session for all requests, dialed %d", n)
+	}
+
+	stranger := client(strangerKP, &strangerDials)
+	if code, _ := get(stranger, "/private"); code != http.StatusForbidden {
+		t.Fatalf("unauthorized request: %d", code)
+	}
+}
+
+func TestTransportRejectsNonPeerHost(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	c := &http.Client{Transport: &Transport{Peer: i6p.NewPeer(kp, nil)}}
+	if _, err := c.Get("http://example.com/"); err == nil {
+		t.Fatal("expected error for a host that is not a peer ID")
+	}
+	other, _ := identity.GenerateKeyPair()
+	if _, err := c.Get("http://" + other.PeerID().String() + "/"); err == nil {
+		t.Fatal("expected error without a route to the peer")
+	}
+}
@@ -0,0 +1,83 @@
+// Package i6phttp carries HTTP between authenticated I6P peers.
+//
+// Requests travel as HTTP/1.1 over I6P streams: every connection the
+// standard library would open is a stream of a session, so requests to the
+// same peer share one encrypted session. Peers are addressed by their ID in
+// the URL host (http://<peer id hex>/path), and handlers learn which peer
+// called them with RemotePeer.
+package i6phttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+)
+
+type peerKey struct{}
+
+// ConnContext records the remote peer of c in ctx. NewServer installs it as
+// http.Server.ConnContext; set it yourself on servers built by hand.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if a, ok := c.RemoteAddr().(session.Addr); ok {
+		ctx = context.WithValue(ctx, peerKey{}, a.PeerID)
+	}
+	return ctx
+}
+
+// RemotePeer returns the authenticated ID of the peer that sent r. It is
+// false for requests that did not arrive over I6P.
+func RemotePeer(r *http.Request) (identity.PeerID, bool) {
+	id, ok := r.Context().Value(peerKey{}).(identity.PeerID)
+	return id, ok
+}
+
+// NewServer returns an http.Server for h that records the calling peer of
+// every request.
+func NewServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ConnContext:       ConnContext,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+}
+
+// Serve serves h on every stream remote peers open to peer, which must be
+// listening. It takes over peer.Accept and returns when the peer stops
+// accepting sessions.
+func Serve(peer *i6p.Peer, h http.Handler) error {
+	ln, err := i6p.NewNetListener(peer)
+	if err != nil {
+		return err
+	}
+	return NewServer(h).Serve(ln)
+}
+
+// Authorize lets requests through to h only from peers that allow accepts.
+// Other requests, including those that did not arrive over I6P, get 403.
+func Authorize(allow func(identity.PeerID) bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := RemotePeer(r)
+		if !ok || !allow(id) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// AllowPeers returns an Authorize policy that accepts exactly ids.
+func AllowPeers(ids ...identity.PeerID) func(identity.PeerID) bool {
+	set := make(map[identity.PeerID]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return func(id identity.PeerID) bool {
+		_, ok := set[id]
+		return ok
+	}
+}
@@ -0,0 +1,144 @@
+package i6phttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/discovery"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+)
+
+// ErrNoRoute is returned when a request targets a peer with no open session
+// and the Transport has no way to reach it.
+var ErrNoRoute = errors.New("i6phttp: no session with peer and no resolver")
+
+// Transport is an http.RoundTripper that sends requests to the peer named
+// by the URL host, a peer ID in hex. It reuses any open session of Peer with
+// that peer, including sessions the peer accepted, and otherwise dials one.
+type Transport struct {
+	Peer *i6p.Peer
+
+	// Resolver locates peers without an open session. If nil (and Dial is
+	// nil) only peers with an open session can be reached.
+	Resolver discovery.Resolver
+
+	// Dial, if set, establishes a session with id instead of resolving and
+	// dialing it with Peer.DialInfo.
+	Dial func(ctx context.Context, id identity.PeerID) (*session.Session, error)
+
+	once sync.Once
+	rt   *http.Transport
+
+	mu      sync.Mutex
+	pending map[identity.PeerID]*dialCall
+}
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+// dialCall is a session dial shared by concurrent requests to one peer.
+type dialCall struct {
+	done chan struct{}
+	s    *session.Session
+	err  error
+}
+
+func (t *Transport) init() {
+	t.rt = &http.Transport{
+		DialContext:         t.dialContext,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	t.pending = make(map[identity.PeerID]*dialCall)
+}
+
+// RoundTrip sends req to the peer named by req.URL.Host.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.once.Do(t.init)
+	if _, err := identity.ParsePeerIDHex(req.URL.Hostname()); err != nil {
+		return nil, fmt.Errorf("i6phttp: host %q is not a peer ID: %w", req.URL.Host, err)
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// CloseIdleConnections closes streams kept open for reuse. Sessions stay
+// open.
+func (t *Transport) CloseIdleConnections() {
+	t.once.Do(t.init)
+	t.rt.CloseIdleConnections()
+}
+
+func (t *Transport) dialContext(ctx context.Context, _, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	id, err := identity.ParsePeerIDHex(host)
+	if err != nil {
+		return nil, err
+	}
+	s, err := t.session(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.OpenStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.StreamConn(st), nil
+}
+
+// session returns an open session with id, dialing one if needed.
+func (t *Transport) session(ctx context.Context, id identity.PeerID) (*session.Session, error) {
+	for _, s := range t.Peer.Sessions() {
+		if s.RemotePeerID() == id && s.Connection().Context().Err() == nil {
+			return s, nil
+		}
+	}
+
+	t.mu.Lock()
+	c, ok := t.pending[id]
+	if !ok {
+		c = &dialCall{done: make(chan struct{})}
+		t.pending[id] = c
+		go func() {
+			// The dial outlives the request that started it, since others
+			// may be waiting for it.
+			c.s, c.err = t.dial(context.WithoutCancel(ctx), id)
+			t.mu.Lock()
+			delete(t.pending, id)
+			t.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.s, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *Transport) dial(ctx context.Context, id identity.PeerID) (*session.Session, error) {
+	if t.Dial != nil {
+		return t.Dial(ctx, id)
+	}
+	if t.Resolver == nil {
+		return nil, ErrNoRoute
+	}
+	info, err := t.Resolver.Lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return t.Peer.DialInfo(ctx, info)
+}
@@ -0,0 +1,64 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"runtime"
+	"sync"
+)
+
+// BatchEntry is one signature checked by VerifyBatch.
+type BatchEntry struct {
+	PublicKey ed25519.PublicKey
+	Context   Context // empty for a plain Ed25519 signature
+	Message   []byte
+	Signature []byte
+}
+
+func (e BatchEntry) verify() bool {
+	if e.Context == "" {
+		return len(e.PublicKey) == ed25519.PublicKeySize && Verify(e.PublicKey, e.Message, e.Signature)
+	}
+	return VerifyContext(e.PublicKey, e.Context, e.Message, e.Signature)
+}
+
+// minParallelBatch is the batch size below which VerifyBatch stays on the
+// calling goroutine.
+const minParallelBatch = 32
+
+// VerifyBatch verifies many signatures, such as the peer records of a
+// discovery response, and reports whether all are valid along with the
+// result of each entry.
+//
+// The standard library exposes no curve arithmetic, so this is not a
+// random-linear-combination batch check: entries are verified individually,
+// spread over GOMAXPROCS goroutines. Every result is exact, and an invalid
+// entry never hides behind valid ones.
+func VerifyBatch(entries []BatchEntry) (bool, []bool) {
+	valid := make([]bool, len(entries))
+	workers := runtime.GOMAXPROCS(0)
+	if len(entries) < minParallelBatch || workers == 1 {
+		for i, e := range entries {
+			valid[i] = e.verify()
+		}
+	} else {
+		var wg sync.WaitGroup
+		per := (len(entries) + workers - 1) / workers
+		for start := 0; start < len(entries); start += per {
+			end := min(start+per, len(entries))
+			wg.Add(1)
+			go func(part []BatchEntry, out []bool) {
+				defer wg.Done()
+				for i, e := range part {
+					out[i] = e.verify()
+				}
+			}(entries[start:end], valid[start:end])
+		}
+		wg.Wait()
+	}
+	for _, ok := range valid {
+		if !ok {
+			return false, valid
+		}
+	}
+	return true, valid
+}
@@ -0,0 +1,48 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"errors"
+)
+
+// Context separates signatures made for different purposes. Signatures with
+// a context use Ed25519ctx (RFC 8032), so a signature made under one context
+// never verifies under another, nor as a plain Ed25519 signature.
+type Context string
+
+const (
+	ContextHello    Context = "i6p-hello"    // session HELLO
+	ContextRecord   Context = "i6p-record"   // signed peer and discovery records
+	ContextRotation Context = "i6p-rotation" // identity key rotation statements
+	ContextGroup    Context = "i6p-group"    // group rosters and membership updates
+	ContextLog      Context = "i6p-log"      // transparency log tree heads
+	ContextCatalog  Context = "i6p-catalog"  // content catalog snapshots
+)
+
+var ErrInvalidContext = errors.New("identity: signing context must be 1 to 255 bytes")
+
+func (c Context) options() (*ed25519.Options, error) {
+	if len(c) == 0 || len(c) > 255 {
+		return nil, ErrInvalidContext
+	}
+	return &ed25519.Options{Context: string(c)}, nil
+}
+
+// SignContext signs message for the purpose c.
+func (kp KeyPair) SignContext(c Context, message []byte) ([]byte, error) {
+	opts, err := c.options()
+	if err != nil {
+		return nil, err
+	}
+	return kp.PrivateKey.Sign(nil, message, opts)
+}
+
+// VerifyContext reports whether signature is a valid signature of message
+// by publicKey for the purpose c.
+func VerifyContext(publicKey ed25519.PublicKey, c Context, message, signature []byte) bool {
+	opts, err := c.options()
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.VerifyWithOptions(publicKey, message, signature, opts) == nil
+}
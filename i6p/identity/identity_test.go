@@ -2,6 +2,8 @@ package identity
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"testing"
 )
 
@@ -54,3 +56,102 @@ func TestSignVerify(t *testing.T) {
 		t.Fatalf("unexpected zeroed signature")
 	}
 }
+
+func TestSignContextSeparation(t *testing.T) {
+	kp, _ := GenerateKeyPair()
+	msg := []byte("statement")
+	sig, err := kp.SignContext(ContextRecord, msg)
+	if err != nil {
+		t.Fatalf("SignContext: %v", err)
+	}
+	if !VerifyContext(kp.PublicKey, ContextRecord, msg, sig) {
+		t.Fatal("context signature did not verify")
+	}
+	for _, c := range []Context{ContextHello, ContextRotation} {
+		if VerifyContext(kp.PublicKey, c, msg, sig) {
+			t.Fatalf("%s signature verified as %s", ContextRecord, c)
+		}
+	}
+	if Verify(kp.PublicKey, msg, sig) {
+		t.Fatal("context signature verified as plain Ed25519")
+	}
+	if VerifyContext(kp.PublicKey, ContextRecord, msg, kp.Sign(msg)) {
+		t.Fatal("plain signature verified under a context")
+	}
+	if _, err := kp.SignContext("", msg); err != ErrInvalidContext {
+		t.Fatalf("empty context: %v", err)
+	}
+}
+
+func TestVerifyBatch(t *testing.T) {
+	var entries []BatchEntry
+	for i := 0; i < 100; i++ {
+		kp, _ := GenerateKeyPair()
+		msg := []byte{byte(i)}
+		e := BatchEntry{PublicKey: kp.PublicKey, Message: msg, Signature: kp.Sign(msg)}
+		if i%2 == 1 {
+			e.Context = ContextRecord
+			e.Signature, _ = kp.SignContext(ContextRecord, msg)
+		}
+		entries = append(entries, e)
+	}
+	if ok, valid := VerifyBatch(entries); !ok || len(valid) != len(entries) {
+		t.Fatalf("VerifyBatch = %v, %d results", ok, len(valid))
+	}
+
+	entries[37].Message = []byte("forged")
+	entries[62].PublicKey = entries[61].PublicKey
+	entries[80].PublicKey = nil
+	ok, valid := VerifyBatch(entries)
+	if ok {
+		t.Fatal("batch with forged entries passed")
+	}
+	for i, v := range valid {
+		if v == (i == 37 || i == 62 || i == 80) {
+			t.Fatalf("entry %d: valid = %v", i, v)
+		}
+	}
+}
+
+func BenchmarkVerifyBatch(b *testing.B) {
+	entries := make([]BatchEntry, 256)
+	for i := range entries {
+		kp, _ := GenerateKeyPair()
+		msg := []byte("record")
+		sig, _ := kp.SignContext(ContextRecord, msg)
+		entries[i] = BatchEntry{PublicKey: kp.PublicKey, Context: ContextRecord, Message: msg, Signature: sig}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		VerifyBatch(entries)
+	}
+}
+
+func TestPeerIDDifficulty(t *testing.T) {
+	var id PeerID
+	if id.Difficulty() != 256 {
+		t.Fatalf("zero ID difficulty = %d", id.Difficulty())
+	}
+	id[1] = 0x10
+	if id.Difficulty() != 11 {
+		t.Fatalf("difficulty = %d, want 11", id.Difficulty())
+	}
+	if id.CheckDifficulty(11) != nil || !errors.Is(id.CheckDifficulty(12), ErrInsufficientWork) {
+		t.Fatal("CheckDifficulty disagrees with Difficulty")
+	}
+}
+
+func TestGrindKeyPair(t *testing.T) {
+	kp, err := GrindKeyPair(context.Background(), 8)
+	if err != nil {
+		t.Fatalf("GrindKeyPair: %v", err)
+	}
+	if kp.PeerID().Difficulty() < 8 {
+		t.Fatalf("difficulty %d", kp.PeerID().Difficulty())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := GrindKeyPair(ctx, 200); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled grind: %v", err)
+	}
+}
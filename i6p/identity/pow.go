@@ -0,0 +1,81 @@
+package identity
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/bits"
+	"runtime"
+)
+
+var ErrInsufficientWork = errors.New("identity: peer ID below required difficulty")
+
+// Difficulty returns the number of leading zero bits of the PeerID. Each
+// extra bit doubles the expected work to generate an identity that meets a
+// requirement, which open networks can use to make mass identity creation
+// expensive.
+func (id PeerID) Difficulty() int {
+	n := 0
+	for _, b := range id {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// CheckDifficulty returns ErrInsufficientWork if id has fewer than want
+// leading zero bits.
+func (id PeerID) CheckDifficulty(want int) error {
+	if got := id.Difficulty(); got < want {
+		return fmt.Errorf("%w: %d bits, need %d", ErrInsufficientWork, got, want)
+	}
+	return nil
+}
+
+// GrindKeyPair generates key pairs on every CPU until one has a PeerID of at
+// least difficulty leading zero bits, or ctx ends. The expected number of
+// attempts is 2^difficulty.
+func GrindKeyPair(ctx context.Context, difficulty int) (KeyPair, error) {
+	if difficulty <= 0 {
+		return GenerateKeyPair()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := make(chan KeyPair, 1)
+	errs := make(chan error, 1)
+	for range runtime.GOMAXPROCS(0) {
+		go func() {
+			seed := make([]byte, ed25519.SeedSize)
+			for ctx.Err() == nil {
+				if _, err := rand.Read(seed); err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					return
+				}
+				priv := ed25519.NewKeyFromSeed(seed)
+				pub := priv.Public().(ed25519.PublicKey)
+				if PeerIDFromPublicKey(pub).Difficulty() >= difficulty {
+					select {
+					case found <- KeyPair{PublicKey: pub, PrivateKey: priv}:
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+	select {
+	case kp := <-found:
+		return kp, nil
+	case err := <-errs:
+		return KeyPair{}, err
+	case <-ctx.Done():
+		return KeyPair{}, ctx.Err()
+	}
+}
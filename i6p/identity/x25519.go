@@ -0,0 +1,96 @@
+package identity
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"math/big"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+)
+
+var ErrInvalidPublicKey = errors.New("identity: invalid Ed25519 public key")
+
+var (
+	// p = 2^255 - 19, the field of both curves.
+	curveP = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	// d = -121665/121666, the Edwards curve constant.
+	curveD = func() *big.Int {
+		d := new(big.Int).ModInverse(big.NewInt(121666), curveP)
+		d.Mul(d, big.NewInt(-121665))
+		return d.Mod(d, curveP)
+	}()
+)
+
+// X25519 converts the Ed25519 identity into the X25519 key pair of the same
+// secret, as libsodium does: the private key is the clamped scalar Ed25519
+// derives from the seed, and the public key equals X25519PublicKey of
+// kp.PublicKey. Peers can then encrypt to an identity (crypto.SealToPeer)
+// knowing only its Ed25519 public key.
+func (kp KeyPair) X25519() (crypto.X25519KeyPair, error) {
+	if len(kp.PrivateKey) != ed25519.PrivateKeySize {
+		return crypto.X25519KeyPair{}, errors.New("invalid Ed25519 private key size")
+	}
+	h := sha512.Sum512(kp.PrivateKey.Seed())
+	var out crypto.X25519KeyPair
+	copy(out.PrivateKey[:], h[:32])
+	out.PrivateKey[0] &= 248
+	out.PrivateKey[31] &= 127
+	out.PrivateKey[31] |= 64
+	priv, err := ecdh.X25519().NewPrivateKey(out.PrivateKey[:])
+	if err != nil {
+		return crypto.X25519KeyPair{}, err
+	}
+	copy(out.PublicKey[:], priv.PublicKey().Bytes())
+	return out, nil
+}
+
+// X25519PublicKey maps an Ed25519 public key to the Montgomery form used by
+// X25519, u = (1 + y) / (1 - y). Encodings that are not a point of the
+// curve, and the neutral point, fail with ErrInvalidPublicKey.
+func X25519PublicKey(pub ed25519.PublicKey) ([32]byte, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return [32]byte{}, ErrInvalidPublicKey
+	}
+	var le [32]byte
+	copy(le[:], pub)
+	sign := le[31] >> 7
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le[:]))
+	one := big.NewInt(1)
+	if y.Cmp(curveP) >= 0 || y.Cmp(one) == 0 {
+		return [32]byte{}, ErrInvalidPublicKey
+	}
+
+	// The point must exist: x^2 = (y^2 - 1) / (d y^2 + 1) has a root.
+	y2 := new(big.Int).Mul(y, y)
+	num := new(big.Int).Sub(y2, one)
+	den := new(big.Int).Mul(curveD, y2)
+	den.Add(den, one).Mod(den, curveP)
+	x2 := num.Mul(num, new(big.Int).ModInverse(den, curveP))
+	x2.Mod(x2, curveP)
+	if x2.Sign() == 0 {
+		if sign == 1 {
+			return [32]byte{}, ErrInvalidPublicKey
+		}
+	} else if new(big.Int).ModSqrt(x2, curveP) == nil {
+		return [32]byte{}, ErrInvalidPublicKey
+	}
+
+	u := new(big.Int).Add(one, y)
+	inv := new(big.Int).Sub(one, y)
+	inv.Mod(inv, curveP).ModInverse(inv, curveP)
+	u.Mul(u, inv).Mod(u, curveP)
+	var out [32]byte
+	u.FillBytes(out[:])
+	return [32]byte(reverse(out[:])), nil
+}
+
+// reverse reverses b in place, converting between little and big endian.
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
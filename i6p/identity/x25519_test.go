@@ -0,0 +1,76 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+)
+
+// libsodium's crypto_sign_ed25519_pk_to_curve25519 / sk_to_curve25519 vector.
+func TestX25519Vector(t *testing.T) {
+	seed, _ := hex.DecodeString("421151a459faeade3d247115f94aedae42318124095afabe4d1451a559faedee")
+	priv := ed25519.NewKeyFromSeed(seed)
+	kp, _ := NewKeyPair(priv.Public().(ed25519.PublicKey), priv)
+	if got := hex.EncodeToString(kp.PublicKey); got != "b5076a8474a832daee4dd5b4040983b6623b5f344aca57d4d6ee4baf3f259e6e" {
+		t.Fatalf("ed25519 public key %s", got)
+	}
+	x, err := kp.X25519()
+	if err != nil {
+		t.Fatalf("X25519: %v", err)
+	}
+	if got := hex.EncodeToString(x.PrivateKey[:]); got != "8052030376d47112be7f73ed7a019293dd12ad910b654455798b4667d73de166" {
+		t.Fatalf("private key %s", got)
+	}
+	if got := hex.EncodeToString(x.PublicKey[:]); got != "f1814f0e8ff1043d8a44d25babff3cedcae6c22c3edaa48f857ae70de2baae50" {
+		t.Fatalf("public key %s", got)
+	}
+}
+
+func TestX25519Conversion(t *testing.T) {
+	alice, _ := GenerateKeyPair()
+	bob, _ := GenerateKeyPair()
+	ax, err := alice.X25519()
+	if err != nil {
+		t.Fatalf("X25519: %v", err)
+	}
+	bx, _ := bob.X25519()
+
+	// The public key converts the same way from either side.
+	pub, err := X25519PublicKey(bob.PublicKey)
+	if err != nil || pub != bx.PublicKey {
+		t.Fatalf("X25519PublicKey disagrees with KeyPair.X25519: %v", err)
+	}
+	s1, _ := crypto.ECDH(ax.PrivateKey, pub)
+	apub, _ := X25519PublicKey(alice.PublicKey)
+	s2, _ := crypto.ECDH(bx.PrivateKey, apub)
+	if !bytes.Equal(s1, s2) {
+		t.Fatalf("shared secrets differ")
+	}
+
+	sealed, _ := crypto.SealToPeer(pub, []byte("hi bob"))
+	if msg, err := crypto.OpenSealed(bx, sealed); err != nil || string(msg) != "hi bob" {
+		t.Fatalf("OpenSealed: %q, %v", msg, err)
+	}
+}
+
+func TestX25519PublicKeyRejects(t *testing.T) {
+	identityPoint := make([]byte, 32)
+	identityPoint[0] = 1
+	tooBig := bytes.Repeat([]byte{0xff}, 32)
+	tooBig[31] = 0x7f
+	notOnCurve, _ := hex.DecodeString("0200000000000000000000000000000000000000000000000000000000000000")
+	for name, pub := range map[string][]byte{
+		"short":        make([]byte, 31),
+		"neutral":      identityPoint,
+		"y >= p":       tooBig,
+		"not on curve": notOnCurve,
+	} {
+		if _, err := X25519PublicKey(pub); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package i6p
+
+import (
+	"context"
+
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// HandshakeFunc runs the session handshake over conn.
+type HandshakeFunc func(ctx context.Context, conn transport.Conn) (*session.Session, error)
+
+// HandshakeInterceptor wraps every handshake the peer runs, in the style of a
+// gRPC interceptor: code before next runs pre-handshake (e.g. to refuse an
+// address), code after it sees the authenticated session (e.g. to log or
+// check the remote identity). server reports which side conn is on.
+// Returning an error aborts the handshake; a session returned by next should
+// be closed by the interceptor if it rejects it.
+type HandshakeInterceptor func(ctx context.Context, conn transport.Conn, server bool, next HandshakeFunc) (*session.Session, error)
+
+// PeerOption configures a Peer in NewPeer.
+type PeerOption func(*Peer)
+
+// WithHandshakeInterceptor adds handshake interceptors. The first one
+// registered is the outermost.
+func WithHandshakeInterceptor(interceptors ...HandshakeInterceptor) PeerOption {
+	return func(p *Peer) {
+		p.handshakeInterceptors = append(p.handshakeInterceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptor adds interceptors run on every stream opened or
+// accepted on the peer's sessions.
+func WithStreamInterceptor(interceptors ...session.StreamInterceptor) PeerOption {
+	return func(p *Peer) {
+		p.interceptors.Stream = append(p.interceptors.Stream, interceptors...)
+	}
+}
+
+// WithFrameInterceptor adds interceptors run on every control-stream frame
+// read or written by the peer's sessions.
+func WithFrameInterceptor(interceptors ...session.FrameInterceptor) PeerOption {
+	return func(p *Peer) {
+		p.interceptors.Frame = append(p.interceptors.Frame, interceptors...)
+	}
+}
+
+// handshake runs the client or server handshake through the interceptor chain.
+func (p *Peer) handshake(ctx context.Context, conn transport.Conn, server bool) (*session.Session, error) {
+	kp, opts := p.handshakeParams()
+	var next HandshakeFunc = func(ctx context.Context, conn transport.Conn) (*session.Session, error) {
+		if server {
+			return session.HandshakeServer(ctx, conn, kp, opts)
+		}
+		return session.HandshakeClient(ctx, conn, kp, opts)
+	}
+	for i := len(p.handshakeInterceptors) - 1; i >= 0; i-- {
+		ic, inner := p.handshakeInterceptors[i], next
+		next = func(ctx context.Context, conn transport.Conn) (*session.Session, error) {
+			return ic(ctx, conn, server, inner)
+		}
+	}
+	return next(ctx, conn)
+}
@@ -0,0 +1,94 @@
+package mailbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// Client talks to the mailbox server at the other end of a session.
+type Client struct {
+	s *session.Session
+}
+
+// NewClient creates a client for the server on s.
+func NewClient(s *session.Session) *Client {
+	return &Client{s: s}
+}
+
+// exchange sends req on a new stream and reads the response, bounded by ctx.
+func (c *Client) exchange(ctx context.Context, req request) (response, error) {
+	var resp response
+	st, err := c.s.OpenProtocolStream(ctx, ProtocolName)
+	if err != nil {
+		return resp, err
+	}
+	defer st.Close()
+	stop := context.AfterFunc(ctx, func() { _ = st.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	err = roundTrip(st, req, &resp)
+	if ctx.Err() != nil {
+		return resp, ctx.Err()
+	}
+	if err == nil && resp.Error != "" {
+		err = fmt.Errorf("%w: %s", ErrRemoteFail, resp.Error)
+	}
+	return resp, err
+}
+
+func roundTrip(st transport.Stream, req request, resp *response) error {
+	if err := writeMsg(st, req); err != nil {
+		return err
+	}
+	return readMsg(st, resp)
+}
+
+// Send deposits payload for to and returns its message ID. The message
+// expires after ttl, or the server default if ttl is zero; servers cap the
+// TTL they grant.
+func (c *Client) Send(ctx context.Context, to identity.PeerID, payload []byte, ttl time.Duration) ([]byte, error) {
+	resp, err := c.exchange(ctx, request{Op: opSend, To: to.String(), TTL: int64(ttl / time.Second), Payload: payload})
+	return resp.ID, err
+}
+
+// Fetch returns the oldest messages held for the local peer. They stay on
+// the server until acknowledged, so a batch may be fetched more than once.
+func (c *Client) Fetch(ctx context.Context) ([]Message, error) {
+	resp, err := c.exchange(ctx, request{Op: opFetch})
+	return resp.Messages, err
+}
+
+// Ack deletes the messages with the given IDs from the server.
+func (c *Client) Ack(ctx context.Context, ids ...[]byte) error {
+	_, err := c.exchange(ctx, request{Op: opAck, IDs: ids})
+	return err
+}
+
+// Receive fetches and acknowledges messages until the mailbox is empty,
+// calling fn for each. A message is acknowledged only after fn accepts it;
+// if fn fails, Receive stops and the message stays on the server.
+func (c *Client) Receive(ctx context.Context, fn func(Message) error) error {
+	for {
+		msgs, err := c.Fetch(ctx)
+		if err != nil || len(msgs) == 0 {
+			return err
+		}
+		ids := make([][]byte, 0, len(msgs))
+		for _, m := range msgs {
+			if err := fn(m); err != nil {
+				if len(ids) > 0 {
+					_ = c.Ack(ctx, ids...)
+				}
+				return err
+			}
+			ids = append(ids, m.ID)
+		}
+		if err := c.Ack(ctx, ids...); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Package mailbox stores messages for peers that are offline and delivers
+// them when they reconnect.
+//
+// A Server runs on a peer that stays reachable. Senders deposit messages
+// addressed to a PeerID; the recipient connects later, fetches its messages
+// and acknowledges them, and only then are they deleted, so a delivery lost
+// with the connection is retried. Only the authenticated recipient of a
+// session can fetch its messages. Messages expire after their TTL, and the
+// server bounds what each recipient can be sent.
+//
+// The server never looks inside payloads: senders should Seal them to the
+// recipient's identity key so the server stores only ciphertext, which the
+// recipient decrypts with Message.Open.
+//
+// One request travels per stream, tagged ProtocolName on sessions that
+// negotiated stream protocols:
+//
+//	send:  client -> request with recipient, TTL, payload; server -> response with the message ID
+//	fetch: client -> request;                              server -> response with the messages
+//	ack:   client -> request with message IDs;             server -> response
+package mailbox
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// ProtocolName tags mailbox streams (see session.OpenProtocolStream).
+const ProtocolName = "i6p/mailbox/1"
+
+// maxMessage bounds one control message, which carries whole payloads.
+const maxMessage = 8 << 20
+
+var (
+	ErrMessage    = errors.New("mailbox: malformed message")
+	ErrTooLarge   = errors.New("mailbox: message too large")
+	ErrRemoteFail = errors.New("mailbox: remote failed")
+)
+
+const (
+	opSend  = "send"
+	opFetch = "fetch"
+	opAck   = "ack"
+)
+
+type request struct {
+	Op      string   `json:"op"`
+	To      string   `json:"to,omitempty"`      // send: recipient PeerID, hex
+	TTL     int64    `json:"ttl,omitempty"`     // send: seconds; 0 selects the server default
+	Payload []byte   `json:"payload,omitempty"` // send
+	IDs     [][]byte `json:"ids,omitempty"`     // ack
+}
+
+type response struct {
+	Error    string    `json:"error,omitempty"`
+	ID       []byte    `json:"id,omitempty"`       // send
+	Messages []Message `json:"messages,omitempty"` // fetch
+}
+
+// Message is a stored message as its recipient fetches it.
+type Message struct {
+	ID        []byte          `json:"id"`
+	From      identity.PeerID `json:"from"`
+	Sent      time.Time       `json:"sent"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	Payload   []byte          `json:"payload"`
+}
+
+// Seal encrypts plaintext for the peer whose Ed25519 identity key is
+// recipient, as a payload for Client.Send.
+func Seal(recipient ed25519.PublicKey, plaintext []byte) ([]byte, error) {
+	pub, err := identity.X25519PublicKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.SealToPeer(pub, plaintext)
+}
+
+// Open decrypts a payload sealed to kp by Seal.
+func (m Message) Open(kp identity.KeyPair) ([]byte, error) {
+	x, err := kp.X25519()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.OpenSealed(x, m.Payload)
+}
+
+// writeMsg sends v as length-prefixed JSON.
+func writeMsg(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMessage {
+		return ErrTooLarge
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readMsg(r io.Reader, v any) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxMessage {
+		return ErrTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMessage, err)
+	}
+	return nil
+}
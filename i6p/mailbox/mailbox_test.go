@@ -0,0 +1,171 @@
+package mailbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+// connect returns a client session from a new peer to the mailbox server.
+func connect(ctx context.Context, t *testing.T, network *memory.Network, caps map[string]string) (*Client, identity.PeerID) {
+	t.Helper()
+	kp, _ := identity.GenerateKeyPair()
+	conn, err := network.Dial(ctx, "mailbox")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	s, err := i6p.NewPeer(kp, caps).Connect(ctx, conn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	return NewClient(s), kp.PeerID()
+}
+
+func startServer(ctx context.Context, t *testing.T, srv *Server) (*memory.Network, map[string]string) {
+	t.Helper()
+	caps := map[string]string{session.StreamProtocolCapability: "1"}
+	network := memory.NewNetwork()
+	kp, _ := identity.GenerateKeyPair()
+	p := i6p.NewPeer(kp, caps)
+	ln, err := network.Listen("mailbox")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	p.Serve(ln)
+	go func() {
+		for {
+			s, err := p.Accept(ctx)
+			if err != nil {
+				return
+			}
+			go func() { _ = srv.Serve(ctx, s) }()
+		}
+	}()
+	return network, caps
+}
+
+func TestStoreAndForward(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := NewServer()
+	network, caps := startServer(ctx, t, srv)
+
+	alice, aliceID := connect(ctx, t, network, caps)
+	bob, bobID := connect(ctx, t, network, caps)
+	for i := range 3 {
+		if _, err := alice.Send(ctx, bobID, []byte(fmt.Sprintf("sealed %d", i)), time.Hour); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if n := srv.Pending(bobID); n != 3 {
+		t.Fatalf("Pending = %d, want 3", n)
+	}
+
+	// Alice cannot read Bob's mailbox: fetches are for the session's peer.
+	if msgs, err := alice.Fetch(ctx); err != nil || len(msgs) != 0 {
+		t.Fatalf("Alice fetched %d messages, err %v", len(msgs), err)
+	}
+
+	// Unacknowledged messages are delivered again.
+	msgs, err := bob.Fetch(ctx)
+	if err != nil || len(msgs) != 3 {
+		t.Fatalf("Fetch: %d messages, err %v", len(msgs), err)
+	}
+	if msgs[0].From != aliceID || !bytes.Equal(msgs[0].Payload, []byte("sealed 0")) {
+		t.Fatalf("unexpected first message %+v", msgs[0])
+	}
+	if err := bob.Ack(ctx, msgs[0].ID); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	var got []string
+	errStop := errors.New("stop")
+	err = bob.Receive(ctx, func(m Message) error {
+		if len(got) == 1 {
+			return errStop
+		}
+		got = append(got, string(m.Payload))
+		return nil
+	})
+	if !errors.Is(err, errStop) || len(got) != 1 || got[0] != "sealed 1" {
+		t.Fatalf("Receive = %v, got %q", err, got)
+	}
+	if err := bob.Receive(ctx, func(m Message) error { got = append(got, string(m.Payload)); return nil }); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if len(got) != 2 || got[1] != "sealed 2" {
+		t.Fatalf("got %q", got)
+	}
+	if n := srv.Pending(bobID); n != 0 {
+		t.Fatalf("Pending after Receive = %d", n)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := NewServer()
+	srv.MaxPending = 2
+	srv.MaxPayload = 8
+	srv.MaxTTL = time.Minute
+	network, caps := startServer(ctx, t, srv)
+	alice, _ := connect(ctx, t, network, caps)
+	bob, bobID := connect(ctx, t, network, caps)
+
+	if _, err := alice.Send(ctx, bobID, make([]byte, 9), 0); !errors.Is(err, ErrRemoteFail) {
+		t.Fatalf("oversized Send: %v", err)
+	}
+	for range 2 {
+		if _, err := alice.Send(ctx, bobID, []byte("hi"), 24*time.Hour); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if _, err := alice.Send(ctx, bobID, []byte("hi"), 0); !errors.Is(err, ErrRemoteFail) {
+		t.Fatalf("Send over quota: %v", err)
+	}
+
+	msgs, err := bob.Fetch(ctx)
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("Fetch: %d messages, err %v", len(msgs), err)
+	}
+	if ttl := msgs[0].ExpiresAt.Sub(msgs[0].Sent); ttl != time.Minute {
+		t.Fatalf("granted TTL %v, want the 1m cap", ttl)
+	}
+
+	// Expired messages are dropped and free the quota.
+	srv.mu.Lock()
+	for i := range srv.boxes[bobID] {
+		srv.boxes[bobID][i].ExpiresAt = time.Now().Add(-time.Second)
+	}
+	srv.mu.Unlock()
+	if n := srv.Pending(bobID); n != 0 {
+		t.Fatalf("Pending with expired messages = %d", n)
+	}
+	if _, err := alice.Send(ctx, bobID, []byte("hi"), 0); err != nil {
+		t.Fatalf("Send after expiry: %v", err)
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	bob, _ := identity.GenerateKeyPair()
+	eve, _ := identity.GenerateKeyPair()
+	payload, err := Seal(bob.PublicKey, []byte("for bob only"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	m := Message{Payload: payload}
+	if got, err := m.Open(bob); err != nil || string(got) != "for bob only" {
+		t.Fatalf("Open: %q, %v", got, err)
+	}
+	if _, err := m.Open(eve); err == nil {
+		t.Fatalf("Open with the wrong key succeeded")
+	}
+}
@@ -0,0 +1,190 @@
+package mailbox
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// Defaults of Server limits.
+const (
+	DefaultTTL        = 24 * time.Hour
+	DefaultMaxTTL     = 7 * 24 * time.Hour
+	DefaultMaxPayload = 64 << 10
+	DefaultMaxPending = 256     // messages per recipient
+	DefaultMaxBytes   = 4 << 20 // payload bytes per recipient
+)
+
+// maxFetch bounds the payload bytes of one fetch response, so it stays
+// under maxMessage once encoded.
+const maxFetch = 4 << 20
+
+var (
+	ErrPayloadTooLarge = errors.New("mailbox: payload too large")
+	ErrQuota           = errors.New("mailbox: recipient mailbox full")
+	ErrRecipient       = errors.New("mailbox: invalid recipient")
+)
+
+// Server stores messages for offline recipients in memory. The zero value
+// of each limit selects its default.
+type Server struct {
+	TTL        time.Duration // lifetime of messages sent without a TTL
+	MaxTTL     time.Duration // longest TTL a sender may ask for
+	MaxPayload int           // largest payload accepted
+	MaxPending int           // messages held per recipient
+	MaxBytes   int           // payload bytes held per recipient
+
+	mu    sync.Mutex
+	boxes map[identity.PeerID][]Message
+}
+
+// NewServer creates a server with the default limits.
+func NewServer() *Server {
+	return &Server{boxes: map[identity.PeerID][]Message{}}
+}
+
+func orDefault[T int | time.Duration](v, def T) T {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// Serve answers mailbox requests on s until the session ends or ctx is done.
+// Streams of other protocols are left to the session's other handlers, and
+// errors go to its stream error hook.
+func (srv *Server) Serve(ctx context.Context, s *session.Session) error {
+	return s.ServeProtocol(ctx, ProtocolName, func(st transport.Stream) error {
+		return srv.Handle(st, s.RemotePeerID())
+	})
+}
+
+// Handle answers one request read from rw, sent by the authenticated peer
+// from.
+func (srv *Server) Handle(rw io.ReadWriter, from identity.PeerID) (err error) {
+	defer i6perrors.Recover(&err, "mailbox")
+	var req request
+	if err := readMsg(rw, &req); err != nil {
+		return err
+	}
+	var resp response
+	switch req.Op {
+	case opSend:
+		resp.ID, err = srv.send(from, req)
+	case opFetch:
+		resp.Messages = srv.fetch(from)
+	case opAck:
+		srv.ack(from, req.IDs)
+	default:
+		err = fmt.Errorf("%w: unknown op %q", ErrMessage, req.Op)
+	}
+	if err != nil {
+		_ = writeMsg(rw, response{Error: err.Error()})
+		return err
+	}
+	return writeMsg(rw, resp)
+}
+
+// Pending returns the number of messages held for id.
+func (srv *Server) Pending(id identity.PeerID) int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return len(srv.liveLocked(id, time.Now()))
+}
+
+// liveLocked drops the expired messages of id and returns the others.
+func (srv *Server) liveLocked(id identity.PeerID, now time.Time) []Message {
+	box := srv.boxes[id]
+	live := box[:0]
+	for _, m := range box {
+		if now.Before(m.ExpiresAt) {
+			live = append(live, m)
+		}
+	}
+	clear(box[len(live):])
+	if len(live) == 0 {
+		delete(srv.boxes, id)
+		return nil
+	}
+	srv.boxes[id] = live
+	return live
+}
+
+func (srv *Server) send(from identity.PeerID, req request) ([]byte, error) {
+	to, err := identity.ParsePeerIDHex(req.To)
+	if err != nil {
+		return nil, ErrRecipient
+	}
+	if len(req.Payload) > orDefault(srv.MaxPayload, DefaultMaxPayload) {
+		return nil, ErrPayloadTooLarge
+	}
+	ttl := orDefault(time.Duration(req.TTL)*time.Second, orDefault(srv.TTL, DefaultTTL))
+	ttl = min(ttl, orDefault(srv.MaxTTL, DefaultMaxTTL))
+
+	now := time.Now()
+	m := Message{ID: make([]byte, 16), From: from, Sent: now, ExpiresAt: now.Add(ttl), Payload: req.Payload}
+	if _, err := rand.Read(m.ID); err != nil {
+		return nil, err
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.boxes == nil {
+		srv.boxes = map[identity.PeerID][]Message{}
+	}
+	box := srv.liveLocked(to, now)
+	used := len(m.Payload)
+	for _, old := range box {
+		used += len(old.Payload)
+	}
+	if len(box) >= orDefault(srv.MaxPending, DefaultMaxPending) || used > orDefault(srv.MaxBytes, DefaultMaxBytes) {
+		return nil, ErrQuota
+	}
+	srv.boxes[to] = append(box, m)
+	return m.ID, nil
+}
+
+// fetch returns the oldest messages of id, as many as fit one response.
+func (srv *Server) fetch(id identity.PeerID) []Message {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	box := srv.liveLocked(id, time.Now())
+	size := 0
+	for i, m := range box {
+		if size += len(m.Payload); size > maxFetch && i > 0 {
+			return append([]Message(nil), box[:i]...)
+		}
+	}
+	return append([]Message(nil), box...)
+}
+
+func (srv *Server) ack(id identity.PeerID, ids [][]byte) {
+	done := make(map[string]bool, len(ids))
+	for _, mid := range ids {
+		done[string(mid)] = true
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	box := srv.boxes[id]
+	if len(box) == 0 {
+		return
+	}
+	kept := box[:0]
+	for _, m := range box {
+		if !done[string(m.ID)] {
+			kept = append(kept, m)
+		}
+	}
+	clear(box[len(kept):])
+	srv.boxes[id] = kept
+	srv.liveLocked(id, time.Now())
+}
@@ -0,0 +1,121 @@
+package i6p
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/session"
+)
+
+// NetListener exposes the streams opened by remote peers as a net.Listener,
+// so servers written against the standard library (net/http, gRPC, SSH) can
+// serve over I6P unmodified. Every incoming session is accepted and every
+// stream it opens becomes a net.Conn whose addresses carry the peers' IDs.
+type NetListener struct {
+	peer   *Peer
+	conns  chan net.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	errOnce sync.Once
+	err     error // why the session accept loop stopped
+	done    chan struct{}
+}
+
+var _ net.Listener = (*NetListener)(nil)
+
+// NewNetListener starts accepting sessions on peer, which must be listening.
+// The listener takes over peer.Accept: do not call it elsewhere.
+func NewNetListener(peer *Peer) (*NetListener, error) {
+	if peer.listener == nil {
+		return nil, ErrNotListening
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &NetListener{
+		peer:   peer,
+		conns:  make(chan net.Conn),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go l.acceptSessions()
+	return l, nil
+}
+
+func (l *NetListener) fail(err error) {
+	l.errOnce.Do(func() {
+		l.err = err
+		close(l.done)
+	})
+}
+
+func (l *NetListener) acceptSessions() {
+	for {
+		s, err := l.peer.Accept(l.ctx)
+		if err != nil {
+			l.fail(err)
+			return
+		}
+		go l.acceptStreams(s)
+	}
+}
+
+func (l *NetListener) acceptStreams(s *session.Session) {
+	for {
+		st, err := s.AcceptStream(l.ctx)
+		if err != nil {
+			return
+		}
+		c := s.StreamConn(st)
+		select {
+		case l.conns <- c:
+		case <-l.ctx.Done():
+			_ = c.Close()
+			return
+		}
+	}
+}
+
+// Accept returns the next stream opened by any remote peer.
+func (l *NetListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		if l.ctx.Err() != nil {
+			return nil, net.ErrClosed
+		}
+		return nil, l.err
+	}
+}
+
+// Close stops accepting streams and sessions. Established sessions and
+// connections keep working.
+func (l *NetListener) Close() error {
+	l.cancel()
+	l.fail(net.ErrClosed)
+	return nil
+}
+
+// Addr returns the local peer's address.
+func (l *NetListener) Addr() net.Addr {
+	return session.Addr{PeerID: l.peer.Config().KeyPair.PeerID(), Addr: l.peer.listener.Addr()}
+}
+
+// DialConn opens a stream to the peer at addr as a net.Conn, e.g. for
+// http.Transport.DialContext. Each call dials a new session; use
+// Session.OpenStream with Session.StreamConn to reuse one.
+func (p *Peer) DialConn(ctx context.Context, addr string) (net.Conn, error) {
+	s, err := p.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.OpenStream(ctx)
+	if err != nil {
+		_ = s.CloseWithError(uint64(i6perrors.CodeTransport), "open stream failed")
+		return nil, err
+	}
+	return s.StreamConn(st), nil
+}
@@ -0,0 +1,162 @@
+package onion
+
+import (
+	"errors"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+var (
+	ErrTooFewHops    = errors.New("onion: circuit needs at least MinHops relays")
+	ErrTooManyHops   = errors.New("onion: circuit exceeds MaxHops relays")
+	ErrMalformedCell = errors.New("onion: malformed cell")
+)
+
+const (
+	// MinHops is the minimum number of relays in a circuit.
+	MinHops = 2
+	// MaxHops is the maximum number of relays in a circuit.
+	MaxHops = 3
+)
+
+// Command tells a relay what to do with a peeled cell.
+type Command uint8
+
+const (
+	// CommandForward asks the relay to pass the payload on to Cell.Next.
+	CommandForward Command = 1
+	// CommandDeliver marks the relay as the exit; the payload is the application message.
+	CommandDeliver Command = 2
+)
+
+// Cell is a single decrypted onion layer.
+// Format:
+//
+//	1 byte: command
+//	32 bytes: next hop PeerID (zero for CommandDeliver)
+//	N bytes: payload
+type Cell struct {
+	Command Command
+	Next    identity.PeerID
+	Payload []byte
+}
+
+const cellHeaderSize = 1 + 32
+
+func (c Cell) encode() []byte {
+	out := make([]byte, cellHeaderSize+len(c.Payload))
+	out[0] = byte(c.Command)
+	copy(out[1:cellHeaderSize], c.Next[:])
+	copy(out[cellHeaderSize:], c.Payload)
+	return out
+}
+
+func decodeCell(b []byte) (Cell, error) {
+	if len(b) < cellHeaderSize {
+		return Cell{}, ErrMalformedCell
+	}
+	c := Cell{Command: Command(b[0]), Payload: b[cellHeaderSize:]}
+	copy(c.Next[:], b[1:cellHeaderSize])
+	switch c.Command {
+	case CommandForward, CommandDeliver:
+		return c, nil
+	default:
+		return Cell{}, ErrMalformedCell
+	}
+}
+
+// Hop is one relay of a circuit as seen by the originator.
+// Channel must already be established with that relay.
+type Hop struct {
+	PeerID  identity.PeerID
+	Channel *crypto.SecureChannel
+}
+
+// Circuit is the originator's view of a multi-hop path.
+type Circuit struct {
+	hops []Hop
+}
+
+// NewCircuit creates a circuit over the given hops, ordered from entry to exit.
+func NewCircuit(hops []Hop) (*Circuit, error) {
+	if len(hops) < MinHops {
+		return nil, ErrTooFewHops
+	}
+	if len(hops) > MaxHops {
+		return nil, ErrTooManyHops
+	}
+	for _, h := range hops {
+		if h.Channel == nil || !h.Channel.IsEstablished() {
+			return nil, crypto.ErrChannelNotEstablished
+		}
+	}
+	return &Circuit{hops: append([]Hop(nil), hops...)}, nil
+}
+
+// Len returns the number of hops.
+func (c *Circuit) Len() int { return len(c.hops) }
+
+// Entry returns the PeerID of the first relay; wrapped messages are sent there.
+func (c *Circuit) Entry() identity.PeerID { return c.hops[0].PeerID }
+
+// Exit returns the PeerID of the last relay.
+func (c *Circuit) Exit() identity.PeerID { return c.hops[len(c.hops)-1].PeerID }
+
+// Wrap onion-encrypts payload for delivery at the exit relay.
+func (c *Circuit) Wrap(payload []byte) ([]byte, error) {
+	last := len(c.hops) - 1
+	data, err := c.hops[last].Channel.Encrypt(Cell{Command: CommandDeliver, Payload: payload}.encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := last - 1; i >= 0; i-- {
+		cell := Cell{Command: CommandForward, Next: c.hops[i+1].PeerID, Payload: data}
+		data, err = c.hops[i].Channel.Encrypt(cell.encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Unwrap removes every layer from a reply that travelled back through the circuit.
+func (c *Circuit) Unwrap(data []byte) ([]byte, error) {
+	var err error
+	for _, h := range c.hops {
+		data, err = h.Channel.Decrypt(data, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Relay is a circuit hop as seen by the relay itself.
+type Relay struct {
+	channel *crypto.SecureChannel
+}
+
+// NewRelay creates the relay side of a hop from the channel shared with the originator.
+func NewRelay(ch *crypto.SecureChannel) (*Relay, error) {
+	if ch == nil || !ch.IsEstablished() {
+		return nil, crypto.ErrChannelNotEstablished
+	}
+	return &Relay{channel: ch}, nil
+}
+
+// Peel removes this relay's layer from a forward-direction message.
+func (r *Relay) Peel(data []byte) (Cell, error) {
+	plain, err := r.channel.Decrypt(data, nil)
+	if err != nil {
+		return Cell{}, err
+	}
+	return decodeCell(plain)
+}
+
+// WrapReply adds this relay's layer to a backward-direction message.
+// The exit relay calls it on the application reply; every earlier relay calls it
+// on what it received from the next hop.
+func (r *Relay) WrapReply(data []byte) ([]byte, error) {
+	return r.channel.Encrypt(data, nil)
+}
@@ -0,0 +1,10 @@
+// Package onion provides layered (onion) encryption for multi-hop circuits.
+//
+// A circuit is a path of 2–3 relays. The originator shares a SecureChannel with
+// every hop and wraps each message once per hop, innermost layer first. Each relay
+// peels exactly one layer, learning only the next hop, so no single relay links
+// the sender to the final recipient.
+//
+// This package only handles the cryptographic cells; moving them between relays
+// is left to the transport layer.
+package onion
@@ -0,0 +1,97 @@
+package onion
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+func newChannelPair(t *testing.T) (*crypto.SecureChannel, *crypto.SecureChannel) {
+	t.Helper()
+	a, err := crypto.NewSecureChannelInitiator()
+	if err != nil {
+		t.Fatalf("NewSecureChannelInitiator: %v", err)
+	}
+	b, err := crypto.NewSecureChannelResponder()
+	if err != nil {
+		t.Fatalf("NewSecureChannelResponder: %v", err)
+	}
+	_ = a.Complete(b.LocalEphemeralPublic())
+	_ = b.Complete(a.LocalEphemeralPublic())
+	return a, b
+}
+
+func TestCircuitRoundTrip(t *testing.T) {
+	var hops []Hop
+	var relays []*Relay
+	for i := 0; i < MaxHops; i++ {
+		kp, _ := identity.GenerateKeyPair()
+		client, relay := newChannelPair(t)
+		hops = append(hops, Hop{PeerID: kp.PeerID(), Channel: client})
+		r, err := NewRelay(relay)
+		if err != nil {
+			t.Fatalf("NewRelay: %v", err)
+		}
+		relays = append(relays, r)
+	}
+
+	circuit, err := NewCircuit(hops)
+	if err != nil {
+		t.Fatalf("NewCircuit: %v", err)
+	}
+
+	msg := []byte("metadata-sensitive payload")
+	data, err := circuit.Wrap(msg)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	// Forward path: each relay learns only the next hop.
+	for i, r := range relays {
+		cell, err := r.Peel(data)
+		if err != nil {
+			t.Fatalf("Peel hop %d: %v", i, err)
+		}
+		if i < len(relays)-1 {
+			if cell.Command != CommandForward || cell.Next != hops[i+1].PeerID {
+				t.Fatalf("hop %d: unexpected cell %v", i, cell.Command)
+			}
+		} else if cell.Command != CommandDeliver || !bytes.Equal(cell.Payload, msg) {
+			t.Fatalf("exit: unexpected delivery")
+		}
+		data = cell.Payload
+	}
+
+	// Backward path: exit wraps first, entry wraps last.
+	reply := []byte("reply")
+	for i := len(relays) - 1; i >= 0; i-- {
+		data, err = relays[i].WrapReply(reply)
+		if err != nil {
+			t.Fatalf("WrapReply hop %d: %v", i, err)
+		}
+		reply = data
+	}
+	got, err := circuit.Unwrap(data)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if string(got) != "reply" {
+		t.Fatalf("reply mismatch")
+	}
+}
+
+func TestCircuitHopBounds(t *testing.T) {
+	client, _ := newChannelPair(t)
+	if _, err := NewCircuit([]Hop{{Channel: client}}); err != ErrTooFewHops {
+		t.Fatalf("expected ErrTooFewHops, got %v", err)
+	}
+	hops := make([]Hop, MaxHops+1)
+	for i := range hops {
+		hops[i] = Hop{Channel: client}
+	}
+	if _, err := NewCircuit(hops); err != ErrTooManyHops {
+		t.Fatalf("expected ErrTooManyHops, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package pairing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"io"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/discovery"
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// Inviter issues invitations for a peer and answers their redemption.
+type Inviter struct {
+	// Store provides the records sent to redeemers and receives theirs. It
+	// may be nil.
+	Store discovery.Resolver
+	// Paired, if set, is called with each peer that redeemed an invitation.
+	Paired func(remote identity.PeerID)
+
+	self identity.PeerID
+	addr netip.AddrPort
+
+	mu      sync.Mutex
+	pending map[[SecretSize]byte]time.Time
+}
+
+// NewInviter creates an inviter for the peer self, reachable at addr.
+func NewInviter(self identity.PeerID, addr netip.AddrPort, store discovery.Resolver) *Inviter {
+	return &Inviter{Store: store, self: self, addr: addr, pending: map[[SecretSize]byte]time.Time{}}
+}
+
+// Invite creates an invitation valid for ttl (DefaultTTL if zero) and for a
+// single redemption.
+func (iv *Inviter) Invite(ttl time.Duration) (Invitation, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	inv := Invitation{PeerID: iv.self, Addr: iv.addr, ExpiresAt: time.Now().Add(ttl).Truncate(time.Second)}
+	if _, err := rand.Read(inv.Secret[:]); err != nil {
+		return Invitation{}, err
+	}
+	iv.mu.Lock()
+	defer iv.mu.Unlock()
+	iv.pending[inv.Secret] = inv.ExpiresAt
+	return inv, nil
+}
+
+// Revoke cancels an invitation that was not redeemed yet.
+func (iv *Inviter) Revoke(inv Invitation) {
+	iv.mu.Lock()
+	defer iv.mu.Unlock()
+	delete(iv.pending, inv.Secret)
+}
+
+// redeem finds and consumes the pending invitation whose secret produced
+// p on the session with transcript t.
+func (iv *Inviter) redeem(p []byte, t [32]byte) ([SecretSize]byte, bool) {
+	iv.mu.Lock()
+	defer iv.mu.Unlock()
+	now := time.Now()
+	for secret, exp := range iv.pending {
+		if !now.Before(exp) {
+			delete(iv.pending, secret)
+			continue
+		}
+		if hmac.Equal(p, proof(secret, t, "redeem")) {
+			delete(iv.pending, secret)
+			return secret, true
+		}
+	}
+	return [SecretSize]byte{}, false
+}
+
+// Serve answers pairing requests on s until the session ends or ctx is done.
+// Streams of other protocols are left to the session's other handlers, and
+// errors go to its stream error hook.
+func (iv *Inviter) Serve(ctx context.Context, s *session.Session) error {
+	return s.ServeProtocol(ctx, ProtocolName, func(st transport.Stream) error {
+		return iv.Handle(st, s)
+	})
+}
+
+// Handle answers one redemption read from rw, a stream of s.
+func (iv *Inviter) Handle(rw io.ReadWriter, s *session.Session) (err error) {
+	defer i6perrors.Recover(&err, "pairing")
+	var req message
+	if err := readMsg(rw, &req); err != nil {
+		return err
+	}
+	t := s.TranscriptHash()
+	secret, ok := iv.redeem(req.Proof, t)
+	if !ok {
+		_ = writeMsg(rw, message{Error: ErrRejected.Error()})
+		return ErrRejected
+	}
+	if err := writeMsg(rw, message{Proof: proof(secret, t, "accept"), Records: records(iv.Store)}); err != nil {
+		return err
+	}
+	learn(iv.Store, req.Records, iv.self)
+	if iv.Paired != nil {
+		iv.Paired(s.RemotePeerID())
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+// Package pairing onboards two peers that have never met, such as two
+// devices of the same user, with a short-lived invitation code.
+//
+// The inviter creates an Invitation holding its PeerID, its address and a
+// one-time secret, and shows its Code. The other peer redeems the code: it
+// dials the address, which authenticates the inviter's PeerID, then both
+// sides prove knowledge of the secret with an HMAC over the session's
+// transcript hash, so a proof cannot be replayed on another session. Once
+// verified, the peers exchange the records of their discovery stores and
+// announce what they received. The secret is consumed by the first
+// successful redemption and invitations expire after their TTL.
+//
+// The exchange travels on one stream tagged ProtocolName:
+//
+//	redeemer -> proof, records;  inviter -> proof, records
+package pairing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/discovery"
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// ProtocolName tags pairing streams (see session.OpenProtocolStream).
+const ProtocolName = "i6p/pairing/1"
+
+// DefaultTTL is the lifetime of an invitation created without one.
+const DefaultTTL = 10 * time.Minute
+
+// SecretSize is the size of the one-time secret of an invitation.
+const SecretSize = 16
+
+// codeVersion is the first byte of an encoded invitation.
+const codeVersion = 1
+
+// codeSize is the size of an encoded invitation: version, PeerID, IPv6 (or
+// IPv4-mapped) address, port, expiry in Unix seconds and secret.
+const codeSize = 1 + 32 + 16 + 2 + 4 + SecretSize
+
+// maxMessage bounds one pairing message.
+const maxMessage = 1 << 20
+
+var (
+	ErrInvalidCode = errors.New("pairing: invalid invitation code")
+	ErrExpired     = errors.New("pairing: invitation expired")
+	ErrRejected    = errors.New("pairing: invitation rejected")
+	ErrProof       = errors.New("pairing: inviter could not prove the secret")
+	ErrMessage     = errors.New("pairing: malformed message")
+	ErrTooLarge    = errors.New("pairing: message too large")
+)
+
+// codeEncoding is unpadded base32, which survives being read aloud or typed.
+var codeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Invitation lets one peer connect to and pair with its creator.
+type Invitation struct {
+	PeerID    identity.PeerID
+	Addr      netip.AddrPort
+	ExpiresAt time.Time
+	Secret    [SecretSize]byte
+}
+
+// Code encodes the invitation as a base32 string. It carries the secret, so
+// it must only be shown to the intended peer.
+func (inv Invitation) Code() string {
+	b := make([]byte, 0, codeSize)
+	b = append(b, codeVersion)
+	b = append(b, inv.PeerID[:]...)
+	addr := inv.Addr.Addr().As16()
+	b = append(b, addr[:]...)
+	b = binary.BigEndian.AppendUint16(b, inv.Addr.Port())
+	b = binary.BigEndian.AppendUint32(b, uint32(inv.ExpiresAt.Unix()))
+	b = append(b, inv.Secret[:]...)
+	return codeEncoding.EncodeToString(b)
+}
+
+// ParseCode decodes an invitation code. Case, spaces and dashes are ignored
+// so codes can be typed in groups.
+func ParseCode(code string) (Invitation, error) {
+	code = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+	b, err := codeEncoding.DecodeString(code)
+	if err != nil || len(b) != codeSize || b[0] != codeVersion {
+		return Invitation{}, ErrInvalidCode
+	}
+	var inv Invitation
+	b = b[1:]
+	copy(inv.PeerID[:], b[:32])
+	addr := netip.AddrFrom16([16]byte(b[32:48])).Unmap()
+	inv.Addr = netip.AddrPortFrom(addr, binary.BigEndian.Uint16(b[48:50]))
+	inv.ExpiresAt = time.Unix(int64(binary.BigEndian.Uint32(b[50:54])), 0)
+	copy(inv.Secret[:], b[54:])
+	return inv, nil
+}
+
+// Info returns the discovery record to dial the inviter.
+func (inv Invitation) Info() discovery.AddrInfo {
+	return discovery.AddrInfo{PeerID: inv.PeerID, Addr: inv.Addr.Addr(), Port: inv.Addr.Port()}
+}
+
+// proof binds the secret to a session transcript; role separates the two
+// directions.
+func proof(secret [SecretSize]byte, transcript [32]byte, role string) []byte {
+	m := hmac.New(sha256.New, secret[:])
+	m.Write([]byte("i6p-pairing-" + role))
+	m.Write(transcript[:])
+	return m.Sum(nil)
+}
+
+type message struct {
+	Error   string               `json:"error,omitempty"`
+	Proof   []byte               `json:"proof,omitempty"`
+	Records []discovery.AddrInfo `json:"records,omitempty"`
+}
+
+// records lists the live records of store for the other peer.
+func records(store discovery.Resolver) []discovery.AddrInfo {
+	if store == nil {
+		return nil
+	}
+	all, _ := store.List()
+	return all
+}
+
+// learn announces the records received from the other peer to store.
+func learn(store discovery.Resolver, recs []discovery.AddrInfo, self identity.PeerID) {
+	if store == nil {
+		return
+	}
+	now := time.Now()
+	for _, r := range recs {
+		if r.PeerID != self && !r.Expired(now) {
+			_ = store.Announce(r)
+		}
+	}
+}
+
+// writeMsg sends v as length-prefixed JSON.
+func writeMsg(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMessage {
+		return ErrTooLarge
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readMsg(r io.Reader, v any) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxMessage {
+		return ErrTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMessage, err)
+	}
+	return nil
+}
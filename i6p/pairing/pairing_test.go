@@ -0,0 +1,116 @@
+package pairing
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/discovery"
+	discmem "github.com/TheusHen/I6P/i6p/discovery/memory"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+)
+
+func TestCodeRoundTrip(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	for _, addr := range []string{"[2001:db8::7]:4242", "192.0.2.1:9"} {
+		iv := NewInviter(kp.PeerID(), netip.MustParseAddrPort(addr), nil)
+		inv, err := iv.Invite(time.Minute)
+		if err != nil {
+			t.Fatalf("Invite: %v", err)
+		}
+		code := inv.Code()
+		// Typed codes may be lowercase and grouped.
+		typed := strings.ToLower(code[:10]) + " - " + code[10:]
+		got, err := ParseCode(typed)
+		if err != nil {
+			t.Fatalf("ParseCode: %v", err)
+		}
+		if got != inv {
+			t.Fatalf("round trip: got %+v, want %+v", got, inv)
+		}
+	}
+	if _, err := ParseCode("AAAA"); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("ParseCode of garbage: %v", err)
+	}
+}
+
+func TestPairing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	caps := map[string]string{session.StreamProtocolCapability: "1"}
+	inviterKP, _ := identity.GenerateKeyPair()
+	inviter := i6p.NewPeer(inviterKP, caps)
+	if err := inviter.Listen("[::1]:0"); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer inviter.Close()
+
+	// Each side knows a peer the other should learn about.
+	laptop := discovery.AddrInfo{PeerID: identity.PeerIDFromPublicKey([]byte("laptop")), Port: 1}
+	phone := discovery.AddrInfo{PeerID: identity.PeerIDFromPublicKey([]byte("phone")), Port: 2}
+	inviterStore, redeemerStore := discmem.New(), discmem.New()
+	_ = inviterStore.Announce(laptop)
+	_ = redeemerStore.Announce(phone)
+
+	iv := NewInviter(inviterKP.PeerID(), netip.MustParseAddrPort(inviter.ListenAddr()), inviterStore)
+	paired := make(chan identity.PeerID, 1)
+	iv.Paired = func(remote identity.PeerID) { paired <- remote }
+	go func() {
+		for {
+			s, err := inviter.Accept(ctx)
+			if err != nil {
+				return
+			}
+			go func() { _ = iv.Serve(ctx, s) }()
+		}
+	}()
+
+	inv, _ := iv.Invite(0)
+	redeemerKP, _ := identity.GenerateKeyPair()
+	redeemer := i6p.NewPeer(redeemerKP, caps)
+	s, err := Redeem(ctx, redeemer, inv.Code(), redeemerStore)
+	if err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+	if s.RemotePeerID() != inviterKP.PeerID() {
+		t.Fatalf("paired with the wrong peer")
+	}
+	if got := <-paired; got != redeemerKP.PeerID() {
+		t.Fatalf("inviter paired with the wrong peer")
+	}
+	if _, err := redeemerStore.Lookup(laptop.PeerID); err != nil {
+		t.Fatalf("redeemer did not learn the inviter's records: %v", err)
+	}
+	if _, err := inviterStore.Lookup(phone.PeerID); err != nil {
+		t.Fatalf("inviter did not learn the redeemer's records: %v", err)
+	}
+
+	// The secret is single-use.
+	if err := inv.Redeem(ctx, s, nil); !errors.Is(err, ErrRejected) {
+		t.Fatalf("second redemption: %v", err)
+	}
+
+	// A guessed secret is rejected, and so is a revoked invitation.
+	inv2, _ := iv.Invite(0)
+	guess := inv2
+	guess.Secret[0] ^= 1
+	if err := guess.Redeem(ctx, s, nil); !errors.Is(err, ErrRejected) {
+		t.Fatalf("wrong secret: %v", err)
+	}
+	iv.Revoke(inv2)
+	if err := inv2.Redeem(ctx, s, nil); !errors.Is(err, ErrRejected) {
+		t.Fatalf("revoked invitation: %v", err)
+	}
+
+	expired := inv2
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	if _, err := Redeem(ctx, redeemer, expired.Code(), nil); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expired code: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package pairing
+
+import (
+	"context"
+	"crypto/hmac"
+	"fmt"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/discovery"
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/session"
+)
+
+// Redeem dials the inviter of code with p and pairs with it, exchanging
+// records with store, which may be nil. It returns the verified session.
+func Redeem(ctx context.Context, p *i6p.Peer, code string, store discovery.Resolver) (*session.Session, error) {
+	inv, err := ParseCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(inv.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	s, err := p.DialInfo(ctx, inv.Info())
+	if err != nil {
+		return nil, err
+	}
+	if err := inv.Redeem(ctx, s, store); err != nil {
+		_ = s.CloseWithError(uint64(i6perrors.CodeRejected), "pairing failed")
+		return nil, err
+	}
+	return s, nil
+}
+
+// Redeem pairs over s, an established session with the inviter, for
+// transports Redeem cannot dial.
+func (inv Invitation) Redeem(ctx context.Context, s *session.Session, store discovery.Resolver) error {
+	if s.RemotePeerID() != inv.PeerID {
+		return i6p.ErrPeerIDMismatch
+	}
+	st, err := s.OpenProtocolStream(ctx, ProtocolName)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	stop := context.AfterFunc(ctx, func() { _ = st.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	t := s.TranscriptHash()
+	req := message{Proof: proof(inv.Secret, t, "redeem"), Records: records(store)}
+	var resp message
+	err = writeMsg(st, req)
+	if err == nil {
+		err = readMsg(st, &resp)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%w: %s", ErrRejected, resp.Error)
+	}
+	if !hmac.Equal(resp.Proof, proof(inv.Secret, t, "accept")) {
+		return ErrProof
+	}
+	learn(store, resp.Records, s.LocalPeerID())
+	return nil
+}
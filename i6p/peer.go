@@ -3,32 +3,64 @@ package i6p
 import (
 	"context"
 	"errors"
+	"net/netip"
+	"sync"
+	"time"
 
+	"github.com/TheusHen/I6P/i6p/clock"
+	"github.com/TheusHen/I6P/i6p/discovery"
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
 	"github.com/TheusHen/I6P/i6p/identity"
 	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport"
 	"github.com/TheusHen/I6P/i6p/transport/quic"
 )
 
-var ErrNotListening = errors.New("peer is not listening")
+var (
+	ErrNotListening   = errors.New("peer is not listening")
+	ErrPeerIDMismatch = errors.New("remote peer ID does not match")
+	ErrDraining       = errors.New("peer is draining")
+)
 
 // Peer is a high-level helper that combines transport + session.
 // It intentionally stays small so applications can customize discovery and higher-level behavior.
 type Peer struct {
 	KeyPair      identity.KeyPair
 	Capabilities map[string]string
-	listener     *quic.Listener
+	Transport    quic.Options // socket tuning for Listen and Dial
+	listener     transport.Listener
+
+	handshakeInterceptors []HandshakeInterceptor
+	interceptors          session.Interceptors
+	handshakeTimeout      time.Duration
+	latencies             *discovery.Latencies
+
+	mu         sync.Mutex
+	sessions   map[*session.Session]struct{}
+	draining   bool
+	helloCache *session.HelloCache
+
+	acceptPolicy AcceptPolicy
+	clock        clock.Clock
+	acceptRate   float64
+	acceptTokens float64
+	acceptLast   time.Time
 }
 
-func NewPeer(kp identity.KeyPair, capabilities map[string]string) *Peer {
+func NewPeer(kp identity.KeyPair, capabilities map[string]string, opts ...PeerOption) *Peer {
 	capsCopy := map[string]string{}
 	for k, v := range capabilities {
 		capsCopy[k] = v
 	}
-	return &Peer{KeyPair: kp, Capabilities: capsCopy}
+	p := &Peer{KeyPair: kp, Capabilities: capsCopy, latencies: discovery.NewLatencies()}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 func (p *Peer) Listen(addr string) error {
-	ln, err := quic.Listen(addr)
+	ln, err := quic.ListenWithOptions(addr, p.Transport)
 	if err != nil {
 		return err
 	}
@@ -36,6 +68,12 @@ func (p *Peer) Listen(addr string) error {
 	return nil
 }
 
+// Serve makes the peer accept sessions from ln instead of a QUIC listener,
+// e.g. a transport/memory listener in tests or for co-located peers.
+func (p *Peer) Serve(ln transport.Listener) {
+	p.listener = ln
+}
+
 func (p *Peer) Close() error {
 	if p.listener == nil {
 		return nil
@@ -47,24 +85,196 @@ func (p *Peer) ListenAddr() string {
 	if p.listener == nil {
 		return ""
 	}
-	return p.listener.AddrString()
+	return p.listener.Addr().String()
 }
 
+// Accept waits for the next incoming session. Connections over the accept
+// rate limit, failed handshakes (including those that time out or that a
+// handshake interceptor refuses) and peers refused by the accept policy are
+// closed, and Accept keeps waiting. It only returns an error when the
+// listener fails or ctx is done.
 func (p *Peer) Accept(ctx context.Context) (*session.Session, error) {
 	if p.listener == nil {
 		return nil, ErrNotListening
 	}
-	conn, err := p.listener.Accept(ctx)
+	for {
+		conn, err := p.listener.Accept(ctx)
+		if err != nil {
+			if p.isDraining() {
+				return nil, ErrDraining
+			}
+			return nil, err
+		}
+		if !p.allowAccept() {
+			_ = conn.CloseWithError(uint64(i6perrors.CodeRateLimited), "rate limited")
+			continue
+		}
+		s, err := p.handshake(ctx, conn, true)
+		if errors.Is(err, session.ErrHandshakeTimeout) {
+			_ = conn.CloseWithError(uint64(i6perrors.CodeHandshakeTimeout), "handshake timeout")
+			continue
+		}
+		if err != nil {
+			code := i6perrors.CodeOf(err)
+			if code == i6perrors.CodeUnknown {
+				code = i6perrors.CodeHandshake
+			}
+			_ = conn.CloseWithError(uint64(code), "handshake failed")
+			continue
+		}
+		if err := p.checkPolicy(s); err != nil {
+			_ = s.CloseWithError(uint64(i6perrors.CodeRejected), "rejected")
+			continue
+		}
+		return p.register(s)
+	}
+}
+
+func (p *Peer) Dial(ctx context.Context, addr string) (*session.Session, error) {
+	conn, err := quic.DialWithOptions(ctx, addr, p.Transport)
+	if err != nil {
+		return nil, err
+	}
+	return p.Connect(ctx, conn)
+}
+
+// Connect runs the client handshake over an already established connection
+// from any transport backend.
+func (p *Peer) Connect(ctx context.Context, conn transport.Conn) (*session.Session, error) {
+	s, err := p.handshake(ctx, conn, false)
 	if err != nil {
 		return nil, err
 	}
-	return session.HandshakeServer(ctx, conn, p.KeyPair, session.HandshakeOptions{Capabilities: p.Capabilities})
+	return p.register(s)
 }
 
-func (p *Peer) Dial(ctx context.Context, addr string) (*session.Session, error) {
-	conn, err := quic.Dial(ctx, addr)
+// register tracks s until its connection ends, so Drain can reach it.
+func (p *Peer) register(s *session.Session) (*session.Session, error) {
+	p.mu.Lock()
+	if p.draining {
+		p.mu.Unlock()
+		_ = s.CloseWithError(uint64(i6perrors.CodeDraining), "draining")
+		return nil, ErrDraining
+	}
+	if p.sessions == nil {
+		p.sessions = make(map[*session.Session]struct{})
+	}
+	p.sessions[s] = struct{}{}
+	p.mu.Unlock()
+
+	go func() {
+		<-s.Done()
+		p.mu.Lock()
+		delete(p.sessions, s)
+		p.mu.Unlock()
+	}()
+	return s, nil
+}
+
+func (p *Peer) isDraining() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.draining
+}
+
+// Sessions returns the peer's open sessions.
+func (p *Peer) Sessions() []*session.Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]*session.Session, 0, len(p.sessions))
+	for s := range p.sessions {
+		out = append(out, s)
+	}
+	return out
+}
+
+// Drain shuts the peer down gracefully: it stops accepting connections, sends
+// GOAWAY on every session so remotes stop opening streams, waits for active
+// streams to be closed until ctx is done, and then closes all sessions.
+// It returns ctx.Err() if streams were still active at the deadline.
+func (p *Peer) Drain(ctx context.Context) error {
+	p.mu.Lock()
+	p.draining = true
+	p.mu.Unlock()
+
+	if p.listener != nil {
+		_ = p.listener.Close()
+	}
+	sessions := p.Sessions()
+	for _, s := range sessions {
+		_ = s.GoAway("draining")
+	}
+
+	var err error
+	for _, s := range sessions {
+		if werr := s.WaitIdle(ctx); werr != nil {
+			err = werr
+			break
+		}
+	}
+	for _, s := range sessions {
+		_ = s.CloseWithError(uint64(i6perrors.CodeDraining), "drained")
+	}
+	return err
+}
+
+// DialInfo dials a peer found through discovery (or parsed from an i6p:// URI)
+// and checks that the remote identity is the one advertised. Link-local
+// addresses must carry their zone, e.g. fe80::1%eth0. The time the QUIC and
+// I6P handshakes took is recorded in Latencies.
+func (p *Peer) DialInfo(ctx context.Context, info discovery.AddrInfo) (*session.Session, error) {
+	addr, err := info.DialAddr()
 	if err != nil {
 		return nil, err
 	}
-	return session.HandshakeClient(ctx, conn, p.KeyPair, session.HandshakeOptions{Capabilities: p.Capabilities})
+	start := time.Now()
+	s, err := p.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.RemotePeerID() != info.PeerID {
+		_ = s.CloseWithError(uint64(i6perrors.CodePeerMismatch), "peer id mismatch")
+		return nil, ErrPeerIDMismatch
+	}
+	p.latencies.Record(info.PeerID, time.Since(start))
+	return s, nil
+}
+
+// Latencies returns the round-trip times the peer measured while dialing.
+func (p *Peer) Latencies() *discovery.Latencies { return p.latencies }
+
+// AnnounceLoop announces the peer's own record, reachable at addr, to r and
+// refreshes it every ttl/3 so it never goes stale while the peer runs. A ttl
+// of zero selects discovery.DefaultTTL. It returns the error of the first
+// announcement; later failures are retried at the next refresh. Otherwise it
+// runs until ctx is done and returns ctx.Err().
+func (p *Peer) AnnounceLoop(ctx context.Context, r discovery.Resolver, addr netip.AddrPort, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = discovery.DefaultTTL
+	}
+	// Each announcement takes the identity and capabilities current at the
+	// time, so it follows UpdateConfig.
+	announce := func() error {
+		cfg := p.Config()
+		info := discovery.AddrInfo{
+			PeerID:       cfg.KeyPair.PeerID(),
+			Addr:         addr.Addr(),
+			Port:         addr.Port(),
+			Capabilities: cfg.Capabilities,
+		}
+		return r.Announce(info.WithTTL(ttl))
+	}
+	if err := announce(); err != nil {
+		return err
+	}
+	t := time.NewTicker(ttl / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			_ = announce()
+		}
+	}
 }
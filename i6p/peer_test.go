@@ -0,0 +1,574 @@
+package i6p
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/discovery"
+	discmem "github.com/TheusHen/I6P/i6p/discovery/memory"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+func TestPeerDrain(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+	server := NewPeer(serverKP, nil)
+	client := NewPeer(clientKP, nil)
+
+	network := memory.NewNetwork()
+	ln, err := network.Listen("server")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	server.Serve(ln)
+
+	accepted := make(chan *session.Session, 1)
+	go func() {
+		s, err := server.Accept(ctx)
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+		}
+		accepted <- s
+	}()
+	conn, err := network.Dial(ctx, "server")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	csess, err := client.Connect(ctx, conn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	ssess := <-accepted
+
+	// An in-flight exchange that finishes after Drain starts.
+	st, err := csess.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	_, _ = st.Write([]byte("req"))
+	sst, err := ssess.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- server.Drain(ctx) }()
+
+	select {
+	case <-csess.GoingAway():
+	case <-time.After(time.Second):
+		t.Fatalf("client did not get GOAWAY")
+	}
+	if _, err := csess.OpenStream(ctx); !errors.Is(err, session.ErrGoingAway) {
+		t.Fatalf("expected ErrGoingAway, got %v", err)
+	}
+	if _, err := network.Dial(ctx, "server"); err != memory.ErrNoListener {
+		t.Fatalf("listener still accepting: %v", err)
+	}
+
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain returned with an active stream: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	_, _ = io.WriteString(sst, "resp")
+	_ = sst.Close()
+
+	if err := <-drained; err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	// Sessions are unregistered asynchronously once their connection ends.
+	deadline := time.Now().Add(time.Second)
+	for len(server.Sessions()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := len(server.Sessions()); n != 0 {
+		t.Fatalf("%d sessions left after Drain", n)
+	}
+}
+
+func TestPeerDrainDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+	server := NewPeer(serverKP, nil)
+	client := NewPeer(clientKP, nil)
+	network := memory.NewNetwork()
+	ln, _ := network.Listen("server")
+	server.Serve(ln)
+
+	accepted := make(chan *session.Session, 1)
+	go func() {
+		s, _ := server.Accept(ctx)
+		accepted <- s
+	}()
+	conn, _ := network.Dial(ctx, "server")
+	csess, err := client.Connect(ctx, conn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	ssess := <-accepted
+	st, _ := csess.OpenStream(ctx)
+	_, _ = st.Write([]byte("x"))
+	if _, err := ssess.AcceptStream(ctx); err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+
+	dctx, dcancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	defer dcancel()
+	if err := server.Drain(dctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	select {
+	case <-csess.Connection().Context().Done():
+	case <-time.After(time.Second):
+		t.Fatalf("session not closed after drain deadline")
+	}
+}
+
+func TestPeerUpdateConfig(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverKP, _ := identity.GenerateKeyPair()
+	server := NewPeer(serverKP, map[string]string{"v": "1"})
+	network := memory.NewNetwork()
+	ln, _ := network.Listen("server")
+	server.Serve(ln)
+
+	accepted := make(chan *session.Session, 4)
+	go func() {
+		for {
+			s, err := server.Accept(ctx)
+			if err != nil {
+				return
+			}
+			accepted <- s
+		}
+	}()
+	dial := func(kp identity.KeyPair) (*session.Session, error) {
+		conn, err := network.Dial(ctx, "server")
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		return NewPeer(kp, nil).Connect(ctx, conn)
+	}
+	connect := func(kp identity.KeyPair) *session.Session {
+		s, err := dial(kp)
+		if err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		return s
+	}
+
+	firstKP, _ := identity.GenerateKeyPair()
+	first := connect(firstKP)
+	<-accepted
+	if first.RemoteCapabilities()["v"] != "1" {
+		t.Fatalf("unexpected capabilities %v", first.RemoteCapabilities())
+	}
+
+	bannedKP, _ := identity.GenerateKeyPair()
+	newServerKP, _ := identity.GenerateKeyPair()
+	server.UpdateConfig(PeerConfig{
+		KeyPair:      &newServerKP,
+		Capabilities: map[string]string{"v": "2"},
+		AcceptPolicy: func(remote identity.PeerID, _ map[string]string) error {
+			if remote == bannedKP.PeerID() {
+				return errors.New("banned")
+			}
+			return nil
+		},
+	})
+
+	// Depending on timing the banned client sees the close during or right
+	// after its handshake.
+	if banned, err := dial(bannedKP); err == nil {
+		select {
+		case <-banned.Connection().Context().Done():
+		case <-time.After(time.Second):
+			t.Fatalf("banned peer was not disconnected")
+		}
+	}
+
+	secondKP, _ := identity.GenerateKeyPair()
+	second := connect(secondKP)
+	if s := <-accepted; s.RemotePeerID() != secondKP.PeerID() {
+		t.Fatalf("unexpected session accepted")
+	}
+	if second.RemoteCapabilities()["v"] != "2" {
+		t.Fatalf("new capabilities not applied: %v", second.RemoteCapabilities())
+	}
+	if second.RemotePeerID() != newServerKP.PeerID() || first.RemotePeerID() != serverKP.PeerID() {
+		t.Fatalf("identity not rotated for new sessions only")
+	}
+
+	// The session established before the update is untouched.
+	select {
+	case <-first.Connection().Context().Done():
+		t.Fatalf("existing session dropped by config update")
+	default:
+	}
+}
+
+func TestPeerAcceptRateLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverKP, _ := identity.GenerateKeyPair()
+	server := NewPeer(serverKP, nil)
+	server.UpdateConfig(PeerConfig{MaxAcceptRate: 1})
+	network := memory.NewNetwork()
+	ln, _ := network.Listen("server")
+	server.Serve(ln)
+	go func() {
+		for {
+			if _, err := server.Accept(ctx); err != nil {
+				return
+			}
+		}
+	}()
+
+	var closed int
+	for i := 0; i < 3; i++ {
+		conn, err := network.Dial(ctx, "server")
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		clientKP, _ := identity.GenerateKeyPair()
+		if _, err := NewPeer(clientKP, nil).Connect(ctx, conn); err != nil {
+			closed++
+		}
+	}
+	if closed != 2 {
+		t.Fatalf("expected 2 rate-limited connections, got %d", closed)
+	}
+}
+
+func TestPeerInterceptors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var events []string
+	record := func(e string) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}
+	logHandshake := func(name string) HandshakeInterceptor {
+		return func(ctx context.Context, conn transport.Conn, server bool, next HandshakeFunc) (*session.Session, error) {
+			record(name + ":pre")
+			s, err := next(ctx, conn)
+			record(name + ":post")
+			return s, err
+		}
+	}
+	var frames atomic.Int32
+	countFrames := func(dir session.FrameDirection, f protocol.Frame) (protocol.Frame, error) {
+		frames.Add(1)
+		return f, nil
+	}
+	var opened, accepted atomic.Int32
+	countStreams := func(ctx context.Context, dir session.StreamDirection, st transport.Stream) (transport.Stream, error) {
+		if dir == session.StreamOpened {
+			opened.Add(1)
+		} else {
+			accepted.Add(1)
+		}
+		return st, nil
+	}
+
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+	server := NewPeer(serverKP, nil, WithStreamInterceptor(countStreams))
+	client := NewPeer(clientKP, nil,
+		WithHandshakeInterceptor(logHandshake("outer"), logHandshake("inner")),
+		WithFrameInterceptor(countFrames),
+		WithStreamInterceptor(countStreams))
+
+	network := memory.NewNetwork()
+	ln, _ := network.Listen("server")
+	server.Serve(ln)
+	sessions := make(chan *session.Session, 1)
+	go func() {
+		s, _ := server.Accept(ctx)
+		sessions <- s
+	}()
+	conn, _ := network.Dial(ctx, "server")
+	csess, err := client.Connect(ctx, conn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	ssess := <-sessions
+
+	want := []string{"outer:pre", "inner:pre", "inner:post", "outer:post"}
+	mu.Lock()
+	got := append([]string(nil), events...)
+	mu.Unlock()
+	if len(got) != len(want) {
+		t.Fatalf("handshake interceptors ran %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("handshake interceptors ran %v, want %v", got, want)
+		}
+	}
+	if n := frames.Load(); n != 2 {
+		t.Fatalf("expected 2 HELLO frames intercepted, got %d", n)
+	}
+
+	st, err := csess.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	_, _ = st.Write([]byte("x"))
+	if _, err := ssess.AcceptStream(ctx); err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	if opened.Load() != 1 || accepted.Load() != 1 {
+		t.Fatalf("stream interceptors: opened=%d accepted=%d", opened.Load(), accepted.Load())
+	}
+
+	_ = ssess.GoAway("bye")
+	<-csess.GoingAway()
+	if n := frames.Load(); n != 3 {
+		t.Fatalf("expected GOAWAY intercepted, got %d frames", n)
+	}
+}
+
+func TestNetListenerServesHTTP(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+	server := NewPeer(serverKP, nil)
+	network := memory.NewNetwork()
+	mln, _ := network.Listen("server")
+	server.Serve(mln)
+
+	ln, err := NewNetListener(server)
+	if err != nil {
+		t.Fatalf("NewNetListener: %v", err)
+	}
+	if a, ok := ln.Addr().(session.Addr); !ok || a.PeerID != serverKP.PeerID() {
+		t.Fatalf("unexpected listener address %v", ln.Addr())
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The remote address identifies the calling peer.
+		_, _ = io.WriteString(w, r.RemoteAddr)
+	})}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	conn, _ := network.Dial(ctx, "server")
+	sess, err := NewPeer(clientKP, nil).Connect(ctx, conn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			st, err := sess.OpenStream(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return sess.StreamConn(st), nil
+		},
+	}}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://server/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if !strings.HasPrefix(string(body), clientKP.PeerID().String()+"@") {
+			t.Fatalf("server saw remote address %q", body)
+		}
+	}
+
+	_ = ln.Close()
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Accept after Close: %v", err)
+	}
+}
+
+func TestPeerAcceptSkipsStalledHandshake(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverKP, _ := identity.GenerateKeyPair()
+	server := NewPeer(serverKP, nil, WithHandshakeTimeout(50*time.Millisecond))
+	network := memory.NewNetwork()
+	ln, _ := network.Listen("server")
+	server.Serve(ln)
+	accepted := make(chan *session.Session, 1)
+	go func() {
+		s, err := server.Accept(ctx)
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+		}
+		accepted <- s
+	}()
+
+	// The first client never sends its HELLO.
+	stalled, err := network.Dial(ctx, "server")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if _, err := stalled.OpenStreamSync(ctx); err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+
+	conn, err := network.Dial(ctx, "server")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	clientKP, _ := identity.GenerateKeyPair()
+	if _, err := NewPeer(clientKP, nil).Connect(ctx, conn); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if s := <-accepted; s == nil || s.RemotePeerID() != clientKP.PeerID() {
+		t.Fatal("Accept did not move past the stalled handshake")
+	}
+}
+
+func TestPeerAcceptSkipsFailedHandshake(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The interceptor refuses the first connection.
+	var calls atomic.Int32
+	refuseFirst := func(ctx context.Context, conn transport.Conn, server bool, next HandshakeFunc) (*session.Session, error) {
+		if calls.Add(1) == 1 {
+			return nil, errors.New("refused")
+		}
+		return next(ctx, conn)
+	}
+	serverKP, _ := identity.GenerateKeyPair()
+	server := NewPeer(serverKP, nil, WithHandshakeInterceptor(refuseFirst))
+	network := memory.NewNetwork()
+	ln, _ := network.Listen("server")
+	server.Serve(ln)
+	accepted := make(chan *session.Session, 1)
+	go func() {
+		s, err := server.Accept(ctx)
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+		}
+		accepted <- s
+	}()
+
+	refused, err := network.Dial(ctx, "server")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	refusedKP, _ := identity.GenerateKeyPair()
+	if _, err := NewPeer(refusedKP, nil).Connect(ctx, refused); err == nil {
+		t.Fatal("Connect succeeded on a refused connection")
+	}
+	select {
+	case <-refused.Context().Done():
+	case <-ctx.Done():
+		t.Fatal("refused connection was not closed")
+	}
+
+	conn, err := network.Dial(ctx, "server")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	clientKP, _ := identity.GenerateKeyPair()
+	if _, err := NewPeer(clientKP, nil).Connect(ctx, conn); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if s := <-accepted; s == nil || s.RemotePeerID() != clientKP.PeerID() {
+		t.Fatal("Accept did not move past the failed handshake")
+	}
+}
+
+func TestPeerAnnounceLoop(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	p := NewPeer(kp, map[string]string{"role": "seed"})
+	store := discmem.New()
+	addr := netip.MustParseAddrPort("[2001:db8::1]:4242")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- p.AnnounceLoop(ctx, store, addr, 60*time.Millisecond) }()
+
+	for {
+		if _, err := store.Lookup(kp.PeerID()); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Across several TTLs the record must stay fresh.
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); {
+		info, err := store.Lookup(kp.PeerID())
+		if err != nil {
+			t.Fatalf("Lookup: %v", err)
+		}
+		if info.Port != 4242 || info.Capabilities["role"] != "seed" {
+			t.Fatalf("unexpected record %+v", info)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("AnnounceLoop: %v", err)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if _, err := store.Lookup(kp.PeerID()); !errors.Is(err, discovery.ErrNotFound) {
+		t.Fatalf("record outlived the loop: %v", err)
+	}
+}
+
+func TestPeerAnnounceLoopFollowsUpdateConfig(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	p := NewPeer(kp, map[string]string{"role": "seed"})
+	store := discmem.New()
+	addr := netip.MustParseAddrPort("[2001:db8::1]:4242")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	// Updates race with the loop's announcements; run under -race.
+	go func() { done <- p.AnnounceLoop(ctx, store, addr, 30*time.Millisecond) }()
+	for i := range 20 {
+		p.UpdateConfig(PeerConfig{Capabilities: map[string]string{"role": "relay", "n": string(rune('a' + i))}})
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		info, err := store.Lookup(kp.PeerID())
+		if err == nil && info.Capabilities["role"] == "relay" && info.Capabilities["n"] == "t" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("record %+v, %v never followed UpdateConfig", info, err)
+		}
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("AnnounceLoop: %v", err)
+	}
+}
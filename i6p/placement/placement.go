@@ -0,0 +1,125 @@
+// Package placement assigns content to peers with rendezvous hashing
+// (highest random weight): every peer gets a pseudo-random score for a key,
+// and the key belongs to the peers with the highest scores. Nodes that hash
+// the same key over the same peer set agree on its placement without
+// coordinating, and adding or removing a peer only moves the keys it wins
+// or held.
+//
+// Keys are arbitrary bytes; ChunkKey names one chunk of a transfer, and
+// AssignShards spreads the erasure shards of a chunk over distinct peers.
+package placement
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"slices"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// Score returns the weight of peer for key. It is the first 8 bytes of
+// SHA-256(key || peer), so every implementation computes the same order.
+func Score(key []byte, peer identity.PeerID) uint64 {
+	h := sha256.New()
+	h.Write(key)
+	h.Write(peer[:])
+	var sum [sha256.Size]byte
+	return binary.BigEndian.Uint64(h.Sum(sum[:0]))
+}
+
+// Rank returns peers ordered by decreasing Score for key. Equal scores, which
+// only collide by chance, are ordered by PeerID. peers is not modified.
+func Rank(key []byte, peers []identity.PeerID) []identity.PeerID {
+	type scored struct {
+		id    identity.PeerID
+		score uint64
+	}
+	s := make([]scored, len(peers))
+	for i, id := range peers {
+		s[i] = scored{id, Score(key, id)}
+	}
+	slices.SortFunc(s, func(a, b scored) int {
+		if a.score != b.score {
+			if a.score > b.score {
+				return -1
+			}
+			return 1
+		}
+		return bytes.Compare(a.id[:], b.id[:])
+	})
+	out := make([]identity.PeerID, len(s))
+	for i, p := range s {
+		out[i] = p.id
+	}
+	return out
+}
+
+// Pick returns the n peers key is assigned to, or all of them if there are
+// fewer than n.
+func Pick(key []byte, peers []identity.PeerID, n int) []identity.PeerID {
+	r := Rank(key, peers)
+	return r[:min(max(n, 0), len(r))]
+}
+
+// ChunkKey is the placement key of chunk index of the transfer whose Merkle
+// root is root.
+func ChunkKey(root []byte, index uint32) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte(nil), root...), index)
+}
+
+// AssignShards returns the peer of each of shards erasure shards stored
+// under key: shard i goes to the i-th ranked peer, wrapping around when
+// there are fewer peers than shards. It returns nil if peers is empty.
+func AssignShards(key []byte, peers []identity.PeerID, shards int) []identity.PeerID {
+	if len(peers) == 0 {
+		return nil
+	}
+	r := Rank(key, peers)
+	out := make([]identity.PeerID, shards)
+	for i := range out {
+		out[i] = r[i%len(r)]
+	}
+	return out
+}
+
+// Node is a peer with a capacity weight, for RankWeighted.
+type Node struct {
+	PeerID identity.PeerID
+	Weight float64 // relative share of keys; nodes with Weight <= 0 get none
+}
+
+// RankWeighted orders nodes for key like Rank, but a node with twice the
+// Weight of another ranks first for about twice as many keys. Nodes with no
+// weight are left out. nodes is not modified.
+func RankWeighted(key []byte, nodes []Node) []Node {
+	type scored struct {
+		n     Node
+		score float64
+	}
+	s := make([]scored, 0, len(nodes))
+	for _, n := range nodes {
+		if n.Weight <= 0 {
+			continue
+		}
+		// Map the hash to (0, 1) and apply the logarithmic method, which keeps
+		// the HRW property that only a changed node's keys move.
+		u := (float64(Score(key, n.PeerID)>>11) + 0.5) / (1 << 53)
+		s = append(s, scored{n, -n.Weight / math.Log(u)})
+	}
+	slices.SortFunc(s, func(a, b scored) int {
+		if a.score != b.score {
+			if a.score > b.score {
+				return -1
+			}
+			return 1
+		}
+		return bytes.Compare(a.n.PeerID[:], b.n.PeerID[:])
+	})
+	out := make([]Node, len(s))
+	for i, p := range s {
+		out[i] = p.n
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+package placement
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+func peers(n int) []identity.PeerID {
+	out := make([]identity.PeerID, n)
+	for i := range out {
+		out[i] = identity.PeerIDFromPublicKey([]byte(fmt.Sprintf("peer-%d", i)))
+	}
+	return out
+}
+
+func TestRankDeterministic(t *testing.T) {
+	ps := peers(8)
+	key := ChunkKey(make([]byte, 32), 3)
+	a := Rank(key, ps)
+	// The order of the input must not matter.
+	rev := make([]identity.PeerID, len(ps))
+	for i, p := range ps {
+		rev[len(ps)-1-i] = p
+	}
+	b := Rank(key, rev)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("rank differs at %d", i)
+		}
+	}
+	if got := Pick(key, ps, 3); len(got) != 3 || got[0] != a[0] || got[2] != a[2] {
+		t.Fatalf("Pick disagrees with Rank")
+	}
+	if got := Pick(key, ps, 20); len(got) != len(ps) {
+		t.Fatalf("Pick returned %d peers", len(got))
+	}
+}
+
+func TestRemovingPeerMovesOnlyItsKeys(t *testing.T) {
+	ps := peers(10)
+	removed := ps[4]
+	rest := append(append([]identity.PeerID(nil), ps[:4]...), ps[5:]...)
+	moved := 0
+	for i := range 2000 {
+		key := ChunkKey(make([]byte, 32), uint32(i))
+		before := Pick(key, ps, 1)[0]
+		after := Pick(key, rest, 1)[0]
+		if before != after {
+			if before != removed {
+				t.Fatalf("key %d moved off a peer that stayed", i)
+			}
+			moved++
+		}
+	}
+	// About a tenth of the keys belonged to the removed peer.
+	if moved < 120 || moved > 280 {
+		t.Fatalf("%d of 2000 keys moved", moved)
+	}
+}
+
+func TestAssignShards(t *testing.T) {
+	ps := peers(4)
+	key := ChunkKey(make([]byte, 32), 0)
+	got := AssignShards(key, ps, 6)
+	r := Rank(key, ps)
+	for i, p := range got {
+		if p != r[i%4] {
+			t.Fatalf("shard %d assigned out of rank order", i)
+		}
+	}
+	if AssignShards(key, nil, 6) != nil {
+		t.Fatalf("expected nil without peers")
+	}
+}
+
+func TestRankWeighted(t *testing.T) {
+	ps := peers(3)
+	nodes := []Node{{ps[0], 1}, {ps[1], 3}, {ps[2], 0}}
+	wins := map[identity.PeerID]int{}
+	const keys = 4000
+	for i := range keys {
+		r := RankWeighted(ChunkKey(make([]byte, 32), uint32(i)), nodes)
+		if len(r) != 2 {
+			t.Fatalf("RankWeighted kept %d nodes", len(r))
+		}
+		wins[r[0].PeerID]++
+	}
+	if share := float64(wins[ps[1]]) / keys; math.Abs(share-0.75) > 0.05 {
+		t.Fatalf("weight 3 node won %.2f of keys, want ~0.75", share)
+	}
+}
@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// RootSize is the size of the Merkle root naming a transfer in chunk frames.
+const RootSize = 32
+
+// MaxChunkIndexes bounds the indexes carried by one GET_CHUNKS or
+// CHUNKS_UNAVAILABLE frame.
+const MaxChunkIndexes = 4096
+
+// chunkDataHeader is the CHUNK_DATA payload before the chunk bytes.
+const chunkDataHeader = RootSize + 4 + 1 + 32
+
+// MaxChunkData is the largest chunk a CHUNK_DATA frame carries.
+const MaxChunkData = MaxFramePayload - chunkDataHeader
+
+var (
+	ErrInvalidChunkRequest = errors.New("protocol invalid GET_CHUNKS/CHUNKS_UNAVAILABLE payload")
+	ErrInvalidChunkData    = errors.New("protocol invalid CHUNK_DATA payload")
+)
+
+// ChunkRequest names chunks of the transfer whose Merkle root is Root. It is
+// the payload of GET_CHUNKS, which asks the peer for the chunks, and of
+// CHUNKS_UNAVAILABLE, which answers that the peer does not have them.
+//
+// Payload format:
+//
+//	32 bytes: root
+//	For each index (1 to MaxChunkIndexes):
+//		4 bytes: index (big endian)
+type ChunkRequest struct {
+	Root    []byte
+	Indexes []uint32
+}
+
+func EncodeChunkRequest(r ChunkRequest) ([]byte, error) {
+	if len(r.Root) != RootSize || len(r.Indexes) == 0 || len(r.Indexes) > MaxChunkIndexes {
+		return nil, ErrInvalidChunkRequest
+	}
+	b := make([]byte, 0, RootSize+4*len(r.Indexes))
+	b = append(b, r.Root...)
+	for _, i := range r.Indexes {
+		b = binary.BigEndian.AppendUint32(b, i)
+	}
+	return b, nil
+}
+
+func DecodeChunkRequest(b []byte) (ChunkRequest, error) {
+	n := (len(b) - RootSize) / 4
+	if len(b) < RootSize+4 || (len(b)-RootSize)%4 != 0 || n > MaxChunkIndexes {
+		return ChunkRequest{}, ErrInvalidChunkRequest
+	}
+	r := ChunkRequest{Root: b[:RootSize:RootSize], Indexes: make([]uint32, n)}
+	for i := range r.Indexes {
+		r.Indexes[i] = binary.BigEndian.Uint32(b[RootSize+4*i:])
+	}
+	return r, nil
+}
+
+// NewGetChunksFrame builds a GET_CHUNKS frame.
+func NewGetChunksFrame(r ChunkRequest) (Frame, error) {
+	p, err := EncodeChunkRequest(r)
+	return Frame{Type: MessageTypeGetChunks, Payload: p}, err
+}
+
+// NewChunksUnavailableFrame builds a CHUNKS_UNAVAILABLE frame.
+func NewChunksUnavailableFrame(r ChunkRequest) (Frame, error) {
+	p, err := EncodeChunkRequest(r)
+	return Frame{Type: MessageTypeChunksUnavailable, Payload: p}, err
+}
+
+// ChunkData carries one chunk requested by GET_CHUNKS. Codec is the
+// compression codec of Data, 0 if it is not compressed. Hash is the SHA-256
+// of the uncompressed chunk, which the receiver checks against its manifest.
+//
+// Payload format:
+//
+//	32 bytes: root
+//	4 bytes: index (big endian)
+//	1 byte: codec
+//	32 bytes: hash
+//	N bytes: data
+type ChunkData struct {
+	Root  []byte
+	Index uint32
+	Codec uint8
+	Hash  []byte
+	Data  []byte
+}
+
+func EncodeChunkData(c ChunkData) ([]byte, error) {
+	if len(c.Root) != RootSize || len(c.Hash) != 32 || len(c.Data) > MaxChunkData {
+		return nil, ErrInvalidChunkData
+	}
+	b := make([]byte, 0, chunkDataHeader+len(c.Data))
+	b = append(b, c.Root...)
+	b = binary.BigEndian.AppendUint32(b, c.Index)
+	b = append(b, c.Codec)
+	b = append(b, c.Hash...)
+	return append(b, c.Data...), nil
+}
+
+func DecodeChunkData(b []byte) (ChunkData, error) {
+	if len(b) < chunkDataHeader {
+		return ChunkData{}, ErrInvalidChunkData
+	}
+	return ChunkData{
+		Root:  b[:RootSize:RootSize],
+		Index: binary.BigEndian.Uint32(b[RootSize:]),
+		Codec: b[RootSize+4],
+		Hash:  b[RootSize+5 : chunkDataHeader : chunkDataHeader],
+		Data:  b[chunkDataHeader:],
+	}, nil
+}
+
+// NewChunkDataFrame builds a CHUNK_DATA frame.
+func NewChunkDataFrame(c ChunkData) (Frame, error) {
+	p, err := EncodeChunkData(c)
+	return Frame{Type: MessageTypeChunkData, Payload: p}, err
+}
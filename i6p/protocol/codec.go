@@ -1,11 +1,11 @@
 package protocol
 
 import (
-	"bufio"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 )
 
 const (
@@ -13,9 +13,13 @@ const (
 	MaxFramePayload = 1 << 20 // 1 MiB
 )
 
+// versionedFrame marks a type byte followed by a version byte.
+const versionedFrame = 0x80
+
 var (
-	ErrFrameTooLarge = errors.New("protocol frame payload too large")
-	ErrInvalidType   = errors.New("protocol invalid message type")
+	ErrFrameTooLarge  = errors.New("protocol frame payload too large")
+	ErrInvalidType    = errors.New("protocol invalid message type")
+	ErrInvalidVersion = errors.New("protocol invalid frame version")
 )
 
 // Frame is the basic wire container.
@@ -25,55 +29,89 @@ var (
 //	4 bytes: payload length (big endian)
 //	N bytes: payload
 //
+// From Version2 on, the type byte has its high bit set and is followed by
+// a version byte:
+//
+//	1 byte: 0x80 | type
+//	1 byte: version
+//	4 bytes: payload length (big endian)
+//	N bytes: payload
+//
 // Frames are intended for a dedicated control stream.
 type Frame struct {
 	Type    MessageType
 	Payload []byte
+	// Version is the protocol version carried in the header. Zero (or
+	// Version1) selects the original header, which carries none.
+	Version uint8
 }
 
-func WriteFrame(w io.Writer, f Frame) error {
-	if f.Type == 0 {
-		return ErrInvalidType
+// maxPooledFrame caps the encode buffers kept for reuse, so one large frame
+// does not pin its buffer.
+const maxPooledFrame = 64 << 10
+
+var framePool = sync.Pool{New: func() any { b := make([]byte, 0, 512); return &b }}
+
+// AppendFrame appends the wire encoding of f to b.
+func AppendFrame(b []byte, f Frame) ([]byte, error) {
+	if f.Type == 0 || f.Type&versionedFrame != 0 {
+		return b, ErrInvalidType
 	}
 	if len(f.Payload) > MaxFramePayload {
-		return ErrFrameTooLarge
+		return b, ErrFrameTooLarge
 	}
-
-	bw := bufio.NewWriter(w)
-	if err := bw.WriteByte(byte(f.Type)); err != nil {
-		return err
+	if f.Version > Version1 {
+		b = append(b, byte(f.Type)|versionedFrame, f.Version)
+	} else {
+		b = append(b, byte(f.Type))
 	}
-	var lenBuf [4]byte
-	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(f.Payload)))
-	if _, err := bw.Write(lenBuf[:]); err != nil {
-		return err
+	b = binary.BigEndian.AppendUint32(b, uint32(len(f.Payload)))
+	return append(b, f.Payload...), nil
+}
+
+// WriteFrame writes f with a single Write, using a pooled buffer.
+func WriteFrame(w io.Writer, f Frame) error {
+	bp := framePool.Get().(*[]byte)
+	b, err := AppendFrame((*bp)[:0], f)
+	if err == nil {
+		_, err = w.Write(b)
 	}
-	if len(f.Payload) > 0 {
-		if _, err := bw.Write(f.Payload); err != nil {
-			return err
-		}
+	if cap(b) <= maxPooledFrame {
+		*bp = b[:0]
+		framePool.Put(bp)
 	}
-	return bw.Flush()
+	return err
 }
 
+// ReadFrame reads exactly one frame from r, never reading past its end.
 func ReadFrame(r io.Reader) (Frame, error) {
-	br := bufio.NewReader(r)
-	t, err := br.ReadByte()
-	if err != nil {
+	var hdr [6]byte
+	if _, err := io.ReadFull(r, hdr[:1]); err != nil {
 		return Frame{}, err
 	}
-	var lenBuf [4]byte
-	if _, err := io.ReadFull(br, lenBuf[:]); err != nil {
-		return Frame{}, err
+	t, rest := hdr[0], hdr[1:5]
+	if t&versionedFrame != 0 {
+		rest = hdr[1:6]
+	}
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return Frame{}, noEOF(err)
 	}
-	payloadLen := binary.BigEndian.Uint32(lenBuf[:])
+	var version uint8
+	if t&versionedFrame != 0 {
+		t &^= versionedFrame
+		version, rest = rest[0], rest[1:]
+		if version <= Version1 {
+			return Frame{}, fmt.Errorf("%w: %d", ErrInvalidVersion, version)
+		}
+	}
+	payloadLen := binary.BigEndian.Uint32(rest)
 	if payloadLen > MaxFramePayload {
 		return Frame{}, fmt.Errorf("%w: %d", ErrFrameTooLarge, payloadLen)
 	}
 	payload := make([]byte, payloadLen)
 	if payloadLen > 0 {
-		if _, err := io.ReadFull(br, payload); err != nil {
-			return Frame{}, err
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return Frame{}, noEOF(err)
 		}
 	}
 
@@ -81,5 +119,13 @@ func ReadFrame(r io.Reader) (Frame, error) {
 	if mt == 0 {
 		return Frame{}, ErrInvalidType
 	}
-	return Frame{Type: mt, Payload: payload}, nil
+	return Frame{Type: mt, Payload: payload, Version: version}, nil
+}
+
+// noEOF turns a clean EOF inside a frame into io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
 }
@@ -2,7 +2,11 @@ package protocol
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"testing"
+
+	"github.com/TheusHen/I6P/i6p/identity"
 )
 
 func TestFrameRoundTrip(t *testing.T) {
@@ -22,3 +26,111 @@ func TestFrameRoundTrip(t *testing.T) {
 		t.Fatalf("payload mismatch")
 	}
 }
+
+func TestFrameVersionedHeader(t *testing.T) {
+	var buf bytes.Buffer
+	in := Frame{Type: MessageTypePing, Payload: []byte("12345678"), Version: Version2}
+	if err := WriteFrame(&buf, in); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	if b := buf.Bytes(); b[0] != byte(MessageTypePing)|0x80 || b[1] != Version2 {
+		t.Fatalf("header = % x", b[:2])
+	}
+	out, err := ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	if out.Type != in.Type || out.Version != Version2 || !bytes.Equal(out.Payload, in.Payload) {
+		t.Fatalf("got %+v", out)
+	}
+
+	if _, err := ReadFrame(bytes.NewReader([]byte{0x87, 1, 0, 0, 0, 0})); !errors.Is(err, ErrInvalidVersion) {
+		t.Fatalf("version 1 in a versioned header: %v", err)
+	}
+	if err := WriteFrame(&buf, Frame{Type: 0x87}); !errors.Is(err, ErrInvalidType) {
+		t.Fatalf("type with the version bit: %v", err)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	cases := []struct {
+		local, remote []uint8
+		want          uint8
+		err           error
+	}{
+		{[]uint8{Version2, Version1}, []uint8{Version2, Version1}, Version2, nil},
+		{[]uint8{Version2, Version1}, []uint8{Version1}, Version1, nil},
+		{[]uint8{Version1, Version2}, []uint8{Version2}, Version2, nil},
+		{[]uint8{Version2, Version1}, nil, Version1, nil},
+		{[]uint8{Version2}, nil, 0, ErrNoCommonVersion},
+		{[]uint8{Version2}, []uint8{3}, 0, ErrNoCommonVersion},
+	}
+	for _, c := range cases {
+		got, err := NegotiateVersion(c.local, c.remote)
+		if got != c.want || !errors.Is(err, c.err) {
+			t.Errorf("NegotiateVersion(%v, %v) = %d, %v", c.local, c.remote, got, err)
+		}
+	}
+}
+
+func TestReadFrameStopsAtFrameEnd(t *testing.T) {
+	var buf bytes.Buffer
+	for i := uint64(1); i <= 3; i++ {
+		if err := WriteFrame(&buf, NewPingFrame(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := uint64(1); i <= 3; i++ {
+		f, err := ReadFrame(&buf)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if seq, _ := DecodePing(f.Payload); seq != i {
+			t.Fatalf("frame %d: seq %d", i, seq)
+		}
+	}
+}
+
+func TestWriteFrameAllocs(t *testing.T) {
+	f := NewPingFrame(1)
+	if n := testing.AllocsPerRun(100, func() { _ = WriteFrame(io.Discard, f) }); n != 0 {
+		t.Fatalf("WriteFrame allocates %.0f times", n)
+	}
+}
+
+// TestDecodersTruncated feeds every prefix and a corrupted copy of valid
+// payloads to the decoders: they may fail, but must not panic.
+func TestDecodersTruncated(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	h, _ := NewHello(kp, map[string]string{"k": "v"})
+	hello, _ := EncodeHello(h)
+	root := bytes.Repeat([]byte{1}, RootSize)
+	req, _ := EncodeChunkRequest(ChunkRequest{Root: root, Indexes: []uint32{1, 2}})
+	data, _ := EncodeChunkData(ChunkData{Root: root, Index: 3, Hash: root, Data: []byte("chunk")})
+	var frame bytes.Buffer
+	_ = WriteFrame(&frame, Frame{Type: MessageTypeHello, Payload: hello})
+
+	decoders := []func([]byte){
+		func(b []byte) { _, _ = DecodeHello(b) },
+		func(b []byte) { _, _ = DecodeChunkRequest(b) },
+		func(b []byte) { _, _ = DecodeChunkData(b) },
+		func(b []byte) { _, _ = DecodePing(b) },
+		func(b []byte) { _, _ = DecodeTimeResponse(b) },
+		func(b []byte) { _, _ = DecodeWindowUpdate(b) },
+		func(b []byte) { _, _ = DecodeAck(b) },
+		func(b []byte) { _, _ = ReadFrame(bytes.NewReader(b)) },
+	}
+	inputs := [][]byte{hello, req, data, frame.Bytes(), EncodePing(7), EncodeWindowUpdate(WindowUpdate{Transfer: 2, Window: 9}), EncodeAck(Ack{Transfer: 2, Chunks: 3})}
+	for _, decode := range decoders {
+		for _, in := range inputs {
+			for n := range len(in) + 1 {
+				decode(in[:n])
+			}
+			for i := range in {
+				corrupt := bytes.Clone(in)
+				corrupt[i] ^= 0xff
+				decode(corrupt)
+			}
+		}
+	}
+}
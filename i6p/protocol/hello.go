@@ -11,6 +11,7 @@ import (
 	"sort"
 	"time"
 
+	"github.com/TheusHen/I6P/i6p/crypto"
 	"github.com/TheusHen/I6P/i6p/identity"
 )
 
@@ -21,16 +22,35 @@ var (
 )
 
 // Hello binds a session to an Ed25519 identity.
-// The signature is computed over SigningBytes().
+// The signatures are computed over SigningBytes().
 type Hello struct {
 	PeerID       string            `json:"peer_id"`
 	PublicKey    []byte            `json:"public_key"`
 	TimestampSec int64             `json:"timestamp_sec"`
 	Nonce        []byte            `json:"nonce"`
 	Capabilities map[string]string `json:"capabilities,omitempty"`
-	Signature    []byte            `json:"signature"`
+	// Versions lists the protocol versions the sender offers. It is empty in
+	// a Version1 HELLO.
+	Versions []uint8 `json:"versions,omitempty"`
+	// Version is the version the responder selected from both offers; zero in
+	// the initiator's HELLO.
+	Version uint8 `json:"version,omitempty"`
+	// Signature is the plain Ed25519 signature over the Version1 signing
+	// bytes, the only one Version1 peers check. A HELLO with versions carries
+	// it only when it offers them (Version is zero), since that HELLO may
+	// reach a Version1 responder.
+	Signature []byte `json:"signature,omitempty"`
+	// VersionSignature signs SigningBytes, versions included, under
+	// identity.ContextHello. Every HELLO with versions carries it, and peers
+	// that read versions check it instead of Signature.
+	VersionSignature []byte `json:"version_signature,omitempty"`
 }
 
+// helloVersionTag starts the signing bytes of a HELLO that carries versions.
+// A Version1 HELLO starts with its PeerID, a SHA-256 output, so the two can
+// never be confused and stripping the versions invalidates the signature.
+var helloVersionTag = []byte("I6P-HELLO-VERSIONS\x00")
+
 func NewHello(kp identity.KeyPair, capabilities map[string]string) (Hello, error) {
 	nonce := make([]byte, 32)
 	if _, err := rand.Read(nonce); err != nil {
@@ -47,10 +67,17 @@ func NewHello(kp identity.KeyPair, capabilities map[string]string) (Hello, error
 		TimestampSec: time.Now().Unix(),
 		Nonce:        nonce,
 		Capabilities: capsCopy,
+		Versions:     append([]uint8(nil), SupportedVersions...),
 	}, nil
 }
 
 func (h Hello) SigningBytes() ([]byte, error) {
+	return h.signingBytes(h.versioned())
+}
+
+// signingBytes returns the signing bytes with or without the version offer;
+// without it they are those of a Version1 HELLO.
+func (h Hello) signingBytes(versioned bool) ([]byte, error) {
 	if len(h.PublicKey) != ed25519.PublicKeySize {
 		return nil, ErrHelloMissingKey
 	}
@@ -60,6 +87,15 @@ func (h Hello) SigningBytes() ([]byte, error) {
 	}
 
 	var b bytes.Buffer
+	if versioned {
+		if len(h.Versions) > 255 {
+			return nil, fmt.Errorf("hello offers too many versions")
+		}
+		b.Write(helloVersionTag)
+		b.WriteByte(h.Version)
+		b.WriteByte(byte(len(h.Versions)))
+		b.Write(h.Versions)
+	}
 	b.Write(id[:])
 	b.Write(h.PublicKey)
 	var ts [8]byte
@@ -86,12 +122,38 @@ func (h Hello) SigningBytes() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// versioned reports whether h carries versions, and so VersionSignature.
+// The offer is part of the signed bytes, so it cannot be altered in transit;
+// stripping it along with VersionSignature leaves a Version1 HELLO, which
+// the handshake detects with MaxVersionCapability.
+func (h Hello) versioned() bool {
+	return len(h.Versions) > 0 || h.Version != 0
+}
+
+// Sign signs h: a Version1 HELLO with a plain signature, a HELLO with
+// versions under identity.ContextHello, plus the plain signature over its
+// Version1 form when it offers versions.
 func (h *Hello) Sign(kp identity.KeyPair) error {
+	h.Signature, h.VersionSignature = nil, nil
+	if !h.versioned() || h.Version == 0 {
+		legacy, err := h.signingBytes(false)
+		if err != nil {
+			return err
+		}
+		h.Signature = kp.Sign(legacy)
+	}
+	if !h.versioned() {
+		return nil
+	}
 	toSign, err := h.SigningBytes()
 	if err != nil {
 		return err
 	}
-	h.Signature = kp.Sign(toSign)
+	sig, err := kp.SignContext(identity.ContextHello, toSign)
+	if err != nil {
+		return err
+	}
+	h.VersionSignature = sig
 	return nil
 }
 
@@ -104,14 +166,19 @@ func (h Hello) Verify() error {
 	if err != nil {
 		return err
 	}
-	if derived != claimed {
+	if !crypto.Equal32(derived, claimed) {
 		return ErrHelloPeerIDMismatch
 	}
 	toVerify, err := h.SigningBytes()
 	if err != nil {
 		return err
 	}
-	if !identity.Verify(ed25519.PublicKey(h.PublicKey), toVerify, h.Signature) {
+	pub := ed25519.PublicKey(h.PublicKey)
+	if h.versioned() {
+		if !identity.VerifyContext(pub, identity.ContextHello, toVerify, h.VersionSignature) {
+			return ErrHelloBadSignature
+		}
+	} else if !identity.Verify(pub, toVerify, h.Signature) {
 		return ErrHelloBadSignature
 	}
 	return nil
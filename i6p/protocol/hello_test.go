@@ -60,7 +60,7 @@ func TestHelloVerifyFailures(t *testing.T) {
 
 	// Tamper with signature
 	tampered := hello
-	tampered.Signature[0] ^= 0xff
+	tampered.VersionSignature[0] ^= 0xff
 	if err := tampered.Verify(); err != ErrHelloBadSignature {
 		t.Fatalf("expected ErrHelloBadSignature, got %v", err)
 	}
@@ -74,3 +74,48 @@ func TestHelloVerifyFailures(t *testing.T) {
 		t.Fatalf("expected ErrHelloPeerIDMismatch, got %v", err)
 	}
 }
+
+func TestHelloSignatureContext(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	h, _ := NewHello(kp, nil)
+	if err := h.Sign(kp); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	sb, _ := h.SigningBytes()
+	if !identity.VerifyContext(kp.PublicKey, identity.ContextHello, sb, h.VersionSignature) {
+		t.Fatal("versioned HELLO not signed under the hello context")
+	}
+	// An offer also carries the plain signature over its Version1 form, for
+	// Version1 responders.
+	v1 := h
+	v1.Versions = nil
+	v1b, _ := v1.SigningBytes()
+	if !identity.Verify(kp.PublicKey, v1b, h.Signature) {
+		t.Fatal("version offer lacks the Version1 signature")
+	}
+	// A plain signature over the same bytes, e.g. from another protocol,
+	// must not pass as a HELLO.
+	plain := h
+	plain.VersionSignature = kp.Sign(sb)
+	if err := plain.Verify(); err != ErrHelloBadSignature {
+		t.Fatalf("plain signature on a versioned HELLO: %v", err)
+	}
+	// A responder's HELLO only reaches peers that read versions.
+	h.Version = Version2
+	if err := h.Sign(kp); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if len(h.Signature) != 0 || h.Verify() != nil {
+		t.Fatal("responder HELLO should carry only the version signature")
+	}
+
+	legacy, _ := NewHello(kp, nil)
+	legacy.Versions = nil
+	if err := legacy.Sign(kp); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	lb, _ := legacy.SigningBytes()
+	if !identity.Verify(kp.PublicKey, lb, legacy.Signature) || legacy.Verify() != nil {
+		t.Fatal("Version1 HELLO must keep the plain signature")
+	}
+}
@@ -0,0 +1,40 @@
+package protocol
+
+// Lane is the priority class of a control frame. When frames are queued for
+// the control stream, every frame of a lower lane is written before any frame
+// of a higher one, so latency-sensitive messages never wait behind bulk ones.
+// Within a lane frames keep their order.
+type Lane uint8
+
+const (
+	LaneUrgent Lane = iota // liveness, timing and flow control: PING, PONG, TIME_*, ACK, WINDOW_UPDATE, GOAWAY, CLOSE
+	LaneNormal             // signaling: PEER_INFO, DATA, GET_CHUNKS, CHUNKS_UNAVAILABLE and unknown types
+	LaneBulk               // large messages: HELLO, CHUNK_DATA
+	NumLanes
+)
+
+func (l Lane) String() string {
+	switch l {
+	case LaneUrgent:
+		return "URGENT"
+	case LaneNormal:
+		return "NORMAL"
+	case LaneBulk:
+		return "BULK"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Lane returns the lane frames of type t are queued in.
+func (t MessageType) Lane() Lane {
+	switch t {
+	case MessageTypePing, MessageTypePong, MessageTypeAck, MessageTypeWindowUpdate,
+		MessageTypeGoAway, MessageTypeClose, MessageTypeTimeRequest, MessageTypeTimeResponse:
+		return LaneUrgent
+	case MessageTypeHello, MessageTypeChunkData:
+		return LaneBulk
+	default:
+		return LaneNormal
+	}
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrInvalidPing = errors.New("protocol invalid PING/PONG payload")
+)
+
+// PING and PONG carry the same payload: the PONG echoes the PING's sequence
+// number so the sender can match replies and measure round-trip time.
+//
+// Payload format:
+//
+//	8 bytes: sequence (big endian)
+func EncodePing(seq uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], seq)
+	return b[:]
+}
+
+func DecodePing(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, ErrInvalidPing
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// NewPingFrame builds a PING frame.
+func NewPingFrame(seq uint64) Frame {
+	return Frame{Type: MessageTypePing, Payload: EncodePing(seq)}
+}
+
+// NewPongFrame builds the PONG reply to the PING with the given sequence.
+func NewPongFrame(seq uint64) Frame {
+	return Frame{Type: MessageTypePong, Payload: EncodePing(seq)}
+}
+
+// NewGoAwayFrame builds a GOAWAY frame. The payload is an optional UTF-8 reason.
+func NewGoAwayFrame(reason string) Frame {
+	return Frame{Type: MessageTypeGoAway, Payload: []byte(reason)}
+}
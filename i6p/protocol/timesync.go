@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidTimeSync = errors.New("protocol invalid TIME_REQUEST/TIME_RESPONSE payload")
+)
+
+// TIME_REQUEST asks the peer for its clock. The payload is an 8-byte
+// sequence number (big endian), as for PING.
+//
+// TimeResponse answers it with the times, on the peer's clock, at which the
+// request was received and the response sent.
+//
+// Payload format:
+//
+//	8 bytes: sequence (big endian)
+//	8 bytes: received, Unix nanoseconds (big endian)
+//	8 bytes: sent, Unix nanoseconds (big endian)
+type TimeResponse struct {
+	Seq      uint64
+	Received time.Time
+	Sent     time.Time
+}
+
+func EncodeTimeResponse(r TimeResponse) []byte {
+	b := make([]byte, 24)
+	binary.BigEndian.PutUint64(b, r.Seq)
+	binary.BigEndian.PutUint64(b[8:], uint64(r.Received.UnixNano()))
+	binary.BigEndian.PutUint64(b[16:], uint64(r.Sent.UnixNano()))
+	return b
+}
+
+func DecodeTimeResponse(b []byte) (TimeResponse, error) {
+	if len(b) != 24 {
+		return TimeResponse{}, ErrInvalidTimeSync
+	}
+	return TimeResponse{
+		Seq:      binary.BigEndian.Uint64(b),
+		Received: time.Unix(0, int64(binary.BigEndian.Uint64(b[8:]))),
+		Sent:     time.Unix(0, int64(binary.BigEndian.Uint64(b[16:]))),
+	}, nil
+}
+
+// NewTimeRequestFrame builds a TIME_REQUEST frame.
+func NewTimeRequestFrame(seq uint64) Frame {
+	return Frame{Type: MessageTypeTimeRequest, Payload: EncodePing(seq)}
+}
+
+// NewTimeResponseFrame builds a TIME_RESPONSE frame.
+func NewTimeResponseFrame(r TimeResponse) Frame {
+	return Frame{Type: MessageTypeTimeResponse, Payload: EncodeTimeResponse(r)}
+}
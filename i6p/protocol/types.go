@@ -3,11 +3,27 @@ package protocol
 type MessageType uint8
 
 const (
-	MessageTypeHello    MessageType = 1
-	MessageTypePeerInfo MessageType = 2
-	MessageTypeData     MessageType = 3
-	MessageTypeAck      MessageType = 4
-	MessageTypeClose    MessageType = 5
+	MessageTypeHello        MessageType = 1
+	MessageTypePeerInfo     MessageType = 2
+	MessageTypeData         MessageType = 3
+	MessageTypeAck          MessageType = 4
+	MessageTypeClose        MessageType = 5
+	MessageTypeWindowUpdate MessageType = 6
+	MessageTypePing         MessageType = 7
+	MessageTypePong         MessageType = 8
+	MessageTypeGoAway       MessageType = 9
+	// MessageTypeStreamProtocol opens an application stream and names its
+	// protocol, on sessions that negotiated stream protocols.
+	MessageTypeStreamProtocol MessageType = 10
+	// MessageTypeGetChunks asks for chunks of a transfer by index; the peer
+	// answers with CHUNK_DATA and CHUNKS_UNAVAILABLE frames.
+	MessageTypeGetChunks         MessageType = 11
+	MessageTypeChunkData         MessageType = 12
+	MessageTypeChunksUnavailable MessageType = 13
+	// MessageTypeTimeRequest asks the peer for its clock; it answers with
+	// TIME_RESPONSE.
+	MessageTypeTimeRequest  MessageType = 14
+	MessageTypeTimeResponse MessageType = 15
 )
 
 func (t MessageType) String() string {
@@ -22,6 +38,26 @@ func (t MessageType) String() string {
 		return "ACK"
 	case MessageTypeClose:
 		return "CLOSE"
+	case MessageTypeWindowUpdate:
+		return "WINDOW_UPDATE"
+	case MessageTypePing:
+		return "PING"
+	case MessageTypePong:
+		return "PONG"
+	case MessageTypeGoAway:
+		return "GOAWAY"
+	case MessageTypeStreamProtocol:
+		return "STREAM_PROTOCOL"
+	case MessageTypeGetChunks:
+		return "GET_CHUNKS"
+	case MessageTypeChunkData:
+		return "CHUNK_DATA"
+	case MessageTypeChunksUnavailable:
+		return "CHUNKS_UNAVAILABLE"
+	case MessageTypeTimeRequest:
+		return "TIME_REQUEST"
+	case MessageTypeTimeResponse:
+		return "TIME_RESPONSE"
 	default:
 		return "UNKNOWN"
 	}
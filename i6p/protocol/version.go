@@ -0,0 +1,54 @@
+package protocol
+
+import "errors"
+
+// Wire protocol versions.
+//
+// Version1 is the original format: a HELLO without a version offer and frames
+// without a version byte. Version2 adds the signed version offer to HELLO and
+// carries the session version in the header of every frame after the
+// handshake. HELLO frames always use the Version1 header so that peers of any
+// version can read them.
+const (
+	Version1 uint8 = 1
+	Version2 uint8 = 2
+
+	CurrentVersion = Version2
+)
+
+var (
+	ErrNoCommonVersion  = errors.New("protocol no common version")
+	ErrVersionDowngrade = errors.New("protocol version downgrade detected")
+)
+
+// MaxVersionCapability is the downgrade sentinel: a peer that speaks
+// versions above Version1 sets it, to its highest version, in the HELLOs a
+// Version1 peer may verify: a client's offer, and a server's HELLO for a
+// client that offered none. Version1 peers ignore it; a peer that speaks
+// later versions and finds it in a HELLO without versions knows they were
+// stripped.
+const MaxVersionCapability = "i6p.max-version"
+
+// SupportedVersions lists the versions this implementation speaks, highest
+// first.
+var SupportedVersions = []uint8{Version2, Version1}
+
+// NegotiateVersion returns the highest version offered by both sides.
+// An empty remote offer is a Version1 peer.
+func NegotiateVersion(local, remote []uint8) (uint8, error) {
+	if len(remote) == 0 {
+		remote = []uint8{Version1}
+	}
+	var best uint8
+	for _, l := range local {
+		for _, r := range remote {
+			if l == r && l > best {
+				best = l
+			}
+		}
+	}
+	if best == 0 {
+		return 0, ErrNoCommonVersion
+	}
+	return best, nil
+}
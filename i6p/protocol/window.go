@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrInvalidWindowUpdate = errors.New("protocol invalid WINDOW_UPDATE payload")
+)
+
+// WindowUpdate tells the sender of one transfer how many chunks it may have
+// in flight. A window of 0 pauses the sender until a later update grows it
+// again.
+//
+// Transfer is the sender-assigned ID of a TransferMux transfer, or 0 for a
+// transfer sent outside one.
+//
+// Payload format:
+//
+//	8 bytes: transfer ID (big endian)
+//	4 bytes: window (big endian)
+type WindowUpdate struct {
+	Transfer uint64
+	Window   uint32
+}
+
+func EncodeWindowUpdate(w WindowUpdate) []byte {
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[:8], w.Transfer)
+	binary.BigEndian.PutUint32(b[8:], w.Window)
+	return b[:]
+}
+
+func DecodeWindowUpdate(b []byte) (WindowUpdate, error) {
+	if len(b) != 12 {
+		return WindowUpdate{}, ErrInvalidWindowUpdate
+	}
+	return WindowUpdate{
+		Transfer: binary.BigEndian.Uint64(b[:8]),
+		Window:   binary.BigEndian.Uint32(b[8:]),
+	}, nil
+}
+
+// NewWindowUpdateFrame builds a WINDOW_UPDATE frame for transfer.
+func NewWindowUpdateFrame(transfer uint64, window uint32) Frame {
+	return Frame{Type: MessageTypeWindowUpdate, Payload: EncodeWindowUpdate(WindowUpdate{Transfer: transfer, Window: window})}
+}
+
+var ErrInvalidAck = errors.New("protocol invalid ACK payload")
+
+// Ack tells the sender of one transfer how many more of its chunks the
+// receiver has consumed, returning that many slots of the window. Chunks
+// count as in flight from when they are sent until they are acknowledged.
+// Transfer is as in WindowUpdate.
+//
+// Payload format:
+//
+//	8 bytes: transfer ID (big endian)
+//	4 bytes: chunks consumed since the previous ACK (big endian)
+type Ack struct {
+	Transfer uint64
+	Chunks   uint32
+}
+
+func EncodeAck(a Ack) []byte {
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[:8], a.Transfer)
+	binary.BigEndian.PutUint32(b[8:], a.Chunks)
+	return b[:]
+}
+
+func DecodeAck(b []byte) (Ack, error) {
+	if len(b) != 12 {
+		return Ack{}, ErrInvalidAck
+	}
+	return Ack{
+		Transfer: binary.BigEndian.Uint64(b[:8]),
+		Chunks:   binary.BigEndian.Uint32(b[8:]),
+	}, nil
+}
+
+// NewAckFrame builds an ACK frame for chunks consumed chunks of transfer.
+func NewAckFrame(transfer uint64, chunks uint32) Frame {
+	return Frame{Type: MessageTypeAck, Payload: EncodeAck(Ack{Transfer: transfer, Chunks: chunks})}
+}
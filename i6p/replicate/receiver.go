@@ -0,0 +1,216 @@
+package replicate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// Receiver applies updates pushed by a remote Replicator to a directory.
+type Receiver struct {
+	dir string
+
+	// mu serializes updates: staged chunks are shared between them.
+	mu sync.Mutex
+}
+
+// NewReceiver creates a receiver writing into dir.
+func NewReceiver(dir string) *Receiver {
+	return &Receiver{dir: dir}
+}
+
+// Serve applies updates pushed on s until the session ends or ctx is done.
+// Streams of other protocols are left to the session's other handlers, and
+// errors go to its stream error hook.
+func (r *Receiver) Serve(ctx context.Context, s *session.Session) error {
+	return s.ServeProtocol(ctx, ProtocolName, func(st transport.Stream) error {
+		return r.Handle(st)
+	})
+}
+
+// Handle applies one update read from rw.
+func (r *Receiver) Handle(rw io.ReadWriter) (err error) {
+	defer i6perrors.Recover(&err, "replicate")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err = r.apply(rw)
+	a := ack{}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	if werr := writeMsg(rw, a); werr != nil && err == nil {
+		err = werr
+	}
+	if err != nil {
+		// The sender reads the ack only after writing everything.
+		_, _ = io.Copy(io.Discard, rw)
+	}
+	return err
+}
+
+func (r *Receiver) apply(rw io.ReadWriter) error {
+	var u update
+	if err := readMsg(rw, &u); err != nil {
+		return err
+	}
+	dst, err := localPath(r.dir, u.Path)
+	if err != nil {
+		return err
+	}
+	if u.Deleted {
+		if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := validate(u); err != nil {
+		return err
+	}
+	staging := filepath.Join(r.dir, stagingDir)
+	if err := os.MkdirAll(staging, 0o700); err != nil {
+		return err
+	}
+
+	// Chunks of the previous version are reused in place.
+	old := make(map[string][]byte)
+	if data, err := os.ReadFile(dst); err == nil {
+		for _, c := range transfer.NewCDCChunker(u.CDC[0], u.CDC[1], u.CDC[2]).Split(data) {
+			old[string(c.Hash)] = c.Data
+		}
+	}
+	var n need
+	wanted := make(map[int]bool)
+	requested := make(map[string]bool)
+	for i, c := range u.Chunks {
+		key := string(c.Hash)
+		if _, ok := old[key]; ok || requested[key] {
+			continue
+		}
+		requested[key] = true
+		if _, err := os.Stat(stagedPath(staging, c.Hash)); err == nil {
+			continue // received by an earlier, interrupted attempt
+		}
+		n.Chunks = append(n.Chunks, i)
+		wanted[i] = true
+	}
+	if err := writeMsg(rw, n); err != nil {
+		return err
+	}
+
+	for len(wanted) > 0 {
+		batch, err := transfer.ReadBatch(rw)
+		if err != nil {
+			return err
+		}
+		for _, cc := range batch.Chunks {
+			if !wanted[cc.Index] || !crypto.Equal(cc.OrigHash, u.Chunks[cc.Index].Hash) {
+				return ErrMessage
+			}
+			c, err := transfer.DecompressChunk(cc)
+			if err != nil {
+				return err
+			}
+			if err := stage(staging, c); err != nil {
+				return err
+			}
+			delete(wanted, cc.Index)
+		}
+	}
+
+	err = assemble(staging, dst, u, old)
+	if err == nil || errors.Is(err, ErrVerify) {
+		// A bad staged chunk must not be reused by the next attempt.
+		for key := range requested {
+			_ = os.Remove(stagedPath(staging, []byte(key)))
+		}
+	}
+	return err
+}
+
+func validate(u update) error {
+	if len(u.Hash) != sha256.Size {
+		return ErrMessage
+	}
+	var total int64
+	for _, c := range u.Chunks {
+		if len(c.Hash) != sha256.Size || c.Size <= 0 || c.Size > u.CDC[2] {
+			return ErrMessage
+		}
+		total += int64(c.Size)
+	}
+	if total != u.Size {
+		return ErrMessage
+	}
+	return nil
+}
+
+func stagedPath(staging string, hash []byte) string {
+	return filepath.Join(staging, hex.EncodeToString(hash))
+}
+
+// stage stores a received chunk, atomically so an interrupted write is never
+// mistaken for a complete chunk.
+func stage(staging string, c transfer.Chunk) error {
+	f, err := os.CreateTemp(staging, "chunk-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(c.Data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), stagedPath(staging, c.Hash))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// assemble writes the new version of dst from old and staged chunks,
+// verifies it and moves it into place.
+func assemble(staging, dst string, u update, old map[string][]byte) error {
+	f, err := os.CreateTemp(staging, "file-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	h := sha256.New()
+	w := io.MultiWriter(f, h)
+	for _, c := range u.Chunks {
+		data, ok := old[string(c.Hash)]
+		if !ok {
+			if data, err = os.ReadFile(stagedPath(staging, c.Hash)); err != nil {
+				_ = f.Close()
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if !crypto.Equal(h.Sum(nil), u.Hash) {
+		return ErrVerify
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
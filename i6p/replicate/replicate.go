@@ -0,0 +1,120 @@
+// Package replicate mirrors a directory to subscribed peers.
+//
+// A Replicator polls a directory for changes and pushes every changed file
+// to each subscribed session. Files are split with a content-defined chunker
+// and the receiver asks only for chunks it does not already hold, from the
+// previous version of the file or from an interrupted earlier attempt, so
+// edits cost roughly their own size and broken transfers resume. Deleted
+// files are sent as tombstones.
+//
+// One update travels per stream, tagged ProtocolName on sessions that
+// negotiated stream protocols:
+//
+//	sender   -> update   path, file hash and size, chunk hashes (or a tombstone)
+//	receiver -> need     indexes of the chunks it is missing
+//	sender   -> batches  the missing chunks, as transfer batches
+//	receiver -> ack      once the file is verified and in place
+package replicate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ProtocolName tags replication streams (see session.OpenProtocolStream).
+const ProtocolName = "i6p/replicate/1"
+
+// stagingDir holds received chunks and partial files inside the replicated
+// directory. It is never replicated itself.
+const stagingDir = ".i6p-staging"
+
+// maxMessage bounds one control message.
+const maxMessage = 64 << 20
+
+var (
+	ErrBadPath    = errors.New("replicate: invalid path")
+	ErrMessage    = errors.New("replicate: malformed message")
+	ErrTooLarge   = errors.New("replicate: message too large")
+	ErrVerify     = errors.New("replicate: file hash mismatch")
+	ErrRemoteFail = errors.New("replicate: remote failed")
+)
+
+type chunkRef struct {
+	Hash []byte `json:"hash"`
+	Size int    `json:"size"`
+}
+
+// update announces a new version of a file, or its deletion.
+type update struct {
+	Path    string     `json:"path"` // slash-separated, relative to the directory
+	Deleted bool       `json:"deleted,omitempty"`
+	Size    int64      `json:"size,omitempty"`
+	Hash    []byte     `json:"hash,omitempty"` // SHA-256 of the whole file
+	CDC     [3]int     `json:"cdc,omitempty"`  // chunker min, avg, max
+	Chunks  []chunkRef `json:"chunks,omitempty"`
+}
+
+// need answers an update. Error is set instead when the receiver refuses
+// the update outright; it shares its field with ack.
+type need struct {
+	Chunks []int  `json:"chunks,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ack struct {
+	Error string `json:"error,omitempty"`
+}
+
+// localPath maps a slash-separated replicated path into dir, refusing paths
+// that leave it or touch the staging directory.
+func localPath(dir, p string) (string, error) {
+	if p == "" || !filepath.IsLocal(filepath.FromSlash(p)) {
+		return "", ErrBadPath
+	}
+	if first, _, _ := strings.Cut(p, "/"); first == stagingDir {
+		return "", ErrBadPath
+	}
+	return filepath.Join(dir, filepath.FromSlash(p)), nil
+}
+
+// writeMsg sends v as length-prefixed JSON.
+func writeMsg(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMessage {
+		return ErrTooLarge
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readMsg(r io.Reader, v any) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxMessage {
+		return ErrTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMessage, err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package replicate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+func randomBytes(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func TestReplicatorMirrorsDirectory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	src, dst := t.TempDir(), t.TempDir()
+	network := memory.NewNetwork()
+	aliceKP, _ := identity.GenerateKeyPair()
+	bobKP, _ := identity.GenerateKeyPair()
+	bob := i6p.NewPeer(bobKP, nil)
+	ln, _ := network.Listen("bob")
+	bob.Serve(ln)
+	go func() {
+		s, err := bob.Accept(ctx)
+		if err == nil {
+			_ = NewReceiver(dst).Serve(ctx, s)
+		}
+	}()
+	conn, _ := network.Dial(ctx, "bob")
+	sess, err := i6p.NewPeer(aliceKP, nil).Connect(ctx, conn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	r := NewReplicator(src, time.Hour)
+	r.Subscribe(sess)
+	scan := func() {
+		t.Helper()
+		if err := r.Scan(ctx); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+	}
+	check := func(name string, want []byte) {
+		t.Helper()
+		got, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("%s not replicated: %v", name, err)
+		}
+	}
+
+	big := randomBytes(1, 2<<20)
+	_ = os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0o644)
+	_ = os.WriteFile(filepath.Join(src, "big.bin"), big, 0o644)
+	_ = os.MkdirAll(filepath.Join(src, "sub", "dir"), 0o755)
+	_ = os.WriteFile(filepath.Join(src, "sub", "dir", "empty"), nil, 0o644)
+	scan()
+	check("a.txt", []byte("hello"))
+	check("big.bin", big)
+	check("sub/dir/empty", nil)
+	if r.Stats().Files.Load() != 3 {
+		t.Fatalf("expected 3 files delivered, got %d", r.Stats().Files.Load())
+	}
+
+	// An edit in the middle of a file sends only the chunks around it.
+	total, sent := r.Stats().ChunksTotal.Load(), r.Stats().ChunksSent.Load()
+	edited := append(append(append([]byte{}, big[:1<<20]...), "an insertion"...), big[1<<20:]...)
+	_ = os.WriteFile(filepath.Join(src, "big.bin"), edited, 0o644)
+	scan()
+	check("big.bin", edited)
+	if n, of := r.Stats().ChunksSent.Load()-sent, r.Stats().ChunksTotal.Load()-total; n == 0 || n > 3 {
+		t.Fatalf("edit sent %d of %d chunks", n, of)
+	}
+
+	// A chunk staged by an interrupted transfer is not sent again.
+	next := randomBytes(2, 1<<20)
+	chunks := r.chunker.Split(next)
+	_ = os.MkdirAll(filepath.Join(dst, stagingDir), 0o700)
+	if err := stage(filepath.Join(dst, stagingDir), chunks[0]); err != nil {
+		t.Fatalf("stage: %v", err)
+	}
+	sent = r.Stats().ChunksSent.Load()
+	_ = os.WriteFile(filepath.Join(src, "next.bin"), next, 0o644)
+	scan()
+	check("next.bin", next)
+	if n := r.Stats().ChunksSent.Load() - sent; n != int64(len(chunks)-1) {
+		t.Fatalf("resumed transfer sent %d chunks, want %d", n, len(chunks)-1)
+	}
+	if left, _ := os.ReadDir(filepath.Join(dst, stagingDir)); len(left) != 0 {
+		t.Fatalf("staging not cleaned up: %d entries", len(left))
+	}
+
+	_ = os.Remove(filepath.Join(src, "a.txt"))
+	scan()
+	if _, err := os.Stat(filepath.Join(dst, "a.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("deletion not replicated: %v", err)
+	}
+	if r.Stats().Tombstones.Load() != 1 {
+		t.Fatalf("expected one tombstone, got %d", r.Stats().Tombstones.Load())
+	}
+}
+
+func TestLocalPathRejectsEscapes(t *testing.T) {
+	for _, p := range []string{"", "../x", "/etc/passwd", "a/../../x", stagingDir + "/abc"} {
+		if _, err := localPath("/srv", p); !errors.Is(err, ErrBadPath) {
+			t.Errorf("localPath(%q) = %v", p, err)
+		}
+	}
+	if _, err := localPath("/srv", "a/b.txt"); err != nil {
+		t.Errorf("valid path rejected: %v", err)
+	}
+}
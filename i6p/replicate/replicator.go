@@ -0,0 +1,278 @@
+package replicate
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+)
+
+// DefaultInterval is how often Run scans the directory.
+const DefaultInterval = 2 * time.Second
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Stats counts what a Replicator has pushed.
+type Stats struct {
+	Files       atomic.Int64 // file versions delivered
+	Tombstones  atomic.Int64 // deletions delivered
+	ChunksTotal atomic.Int64 // chunks in delivered files
+	ChunksSent  atomic.Int64 // chunks the receivers were missing
+	BytesSent   atomic.Int64 // chunk payload bytes sent, after compression
+}
+
+// Replicator watches a directory and pushes its changes to subscribers.
+type Replicator struct {
+	dir      string
+	interval time.Duration
+	chunker  *transfer.CDCChunker
+
+	mu       sync.Mutex
+	snapshot map[string]fileState
+	// pending lists, per subscriber, the paths it has not yet received in
+	// their current state. A failed push stays pending for the next scan.
+	pending map[*session.Session]map[string]struct{}
+
+	stats Stats
+}
+
+// NewReplicator creates a replicator for dir scanning every interval (zero
+// selects DefaultInterval).
+func NewReplicator(dir string, interval time.Duration) *Replicator {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Replicator{
+		dir:      dir,
+		interval: interval,
+		chunker:  transfer.NewCDCChunker(0, 0, 0),
+		snapshot: make(map[string]fileState),
+		pending:  make(map[*session.Session]map[string]struct{}),
+	}
+}
+
+// Stats returns the replicator's counters.
+func (r *Replicator) Stats() *Stats { return &r.stats }
+
+// Subscribe starts replicating to the peer on s, beginning with a full copy
+// of the directory as of the last scan. The remote side must run a Receiver.
+func (r *Replicator) Subscribe(s *session.Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := make(map[string]struct{}, len(r.snapshot))
+	for path := range r.snapshot {
+		p[path] = struct{}{}
+	}
+	r.pending[s] = p
+}
+
+// Unsubscribe stops replicating to s.
+func (r *Replicator) Unsubscribe(s *session.Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, s)
+}
+
+// Run scans the directory until ctx is done.
+func (r *Replicator) Run(ctx context.Context) error {
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+	for {
+		if err := r.Scan(ctx); err != nil && ctx.Err() == nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Scan looks for changed and deleted files once and pushes them to every
+// subscriber. Failures to reach a subscriber are retried on the next scan;
+// subscribers whose session has ended are dropped.
+func (r *Replicator) Scan(ctx context.Context) error {
+	current, err := r.walk()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	var changed []string
+	for path, st := range current {
+		if old, ok := r.snapshot[path]; !ok || old != st {
+			changed = append(changed, path)
+		}
+	}
+	for path := range r.snapshot {
+		if _, ok := current[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	r.snapshot = current
+	subs := make(map[*session.Session][]string, len(r.pending))
+	for s, p := range r.pending {
+		for _, path := range changed {
+			p[path] = struct{}{}
+		}
+		for path := range p {
+			subs[s] = append(subs[s], path)
+		}
+		sort.Strings(subs[s])
+	}
+	r.mu.Unlock()
+
+	for s, paths := range subs {
+		if s.Connection().Context().Err() != nil {
+			r.Unsubscribe(s)
+			continue
+		}
+		for _, path := range paths {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := r.push(ctx, s, path); err != nil {
+				break // keep the rest pending
+			}
+			r.mu.Lock()
+			if p, ok := r.pending[s]; ok {
+				delete(p, path)
+			}
+			r.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// walk lists the regular files under the directory.
+func (r *Replicator) walk() (map[string]fileState, error) {
+	out := make(map[string]fileState)
+	err := filepath.WalkDir(r.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == stagingDir {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(r.dir, p)
+		if err != nil {
+			return err
+		}
+		out[filepath.ToSlash(rel)] = fileState{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return out, err
+}
+
+// push sends the current state of path to the peer on s.
+func (r *Replicator) push(ctx context.Context, s *session.Session, path string) error {
+	lp, err := localPath(r.dir, path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(lp)
+	deleted := errors.Is(err, fs.ErrNotExist)
+	if err != nil && !deleted {
+		return err
+	}
+
+	st, err := s.OpenProtocolStream(ctx, ProtocolName)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	stop := context.AfterFunc(ctx, func() { _ = st.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	u := update{Path: path, Deleted: deleted}
+	var chunks []transfer.Chunk
+	if !deleted {
+		chunks = r.chunker.Split(data)
+		sum := sha256.Sum256(data)
+		minSize, avg, maxSize := r.chunker.Sizes()
+		u.Size, u.Hash, u.CDC = int64(len(data)), sum[:], [3]int{minSize, avg, maxSize}
+		u.Chunks = make([]chunkRef, len(chunks))
+		for i, c := range chunks {
+			u.Chunks[i] = chunkRef{Hash: c.Hash, Size: len(c.Data)}
+		}
+	}
+	if err := writeMsg(st, u); err != nil {
+		return err
+	}
+
+	var sent int64
+	if !deleted {
+		var n need
+		if err := readMsg(st, &n); err != nil {
+			return err
+		}
+		if n.Error != "" {
+			return fmt.Errorf("%w: %s", ErrRemoteFail, n.Error)
+		}
+		codec := transfer.LZ4
+		if transfer.IncompressibleName(path) || transfer.IncompressibleHead(data) {
+			codec = transfer.NoCompression
+		}
+		batch := transfer.NewBatch()
+		for _, i := range n.Chunks {
+			if i < 0 || i >= len(chunks) {
+				return ErrMessage
+			}
+			cc := transfer.CompressChunkWith(chunks[i], codec, transfer.CompressionFast)
+			if batch.Size()+len(cc.Data)+64 > transfer.MaxBatchSize && len(batch.Chunks) > 0 {
+				if err := transfer.WriteBatch(st, batch); err != nil {
+					return err
+				}
+				batch = transfer.NewBatch()
+			}
+			batch.Add(cc)
+			sent += int64(len(cc.Data))
+		}
+		if len(batch.Chunks) > 0 {
+			if err := transfer.WriteBatch(st, batch); err != nil {
+				return err
+			}
+		}
+		r.stats.ChunksTotal.Add(int64(len(chunks)))
+		r.stats.ChunksSent.Add(int64(len(n.Chunks)))
+		r.stats.BytesSent.Add(sent)
+	}
+
+	// Half-close: the receiver may drain the stream before acknowledging.
+	if err := st.Close(); err != nil {
+		return err
+	}
+	var a ack
+	if err := readMsg(st, &a); err != nil {
+		return err
+	}
+	if a.Error != "" {
+		return fmt.Errorf("%w: %s", ErrRemoteFail, a.Error)
+	}
+	if deleted {
+		r.stats.Tombstones.Add(1)
+	} else {
+		r.stats.Files.Add(1)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package session
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// OpenStats is the latency of opening application streams on a session,
+// from the OpenStream call until the stream is usable. It includes waiting
+// for the open budget (SetMaxOpenStreams) and for the peer's stream limit.
+type OpenStats struct {
+	Opened  uint64        // streams opened
+	Queued  uint64        // opens that had to wait for the budget
+	Waiting int           // callers waiting for the budget right now
+	Total   time.Duration // summed open latency
+	Max     time.Duration // longest open latency
+}
+
+// Mean returns the average open latency.
+func (o OpenStats) Mean() time.Duration {
+	if o.Opened == 0 {
+		return 0
+	}
+	return o.Total / time.Duration(o.Opened)
+}
+
+// streamBudget bounds the application streams a session has open locally.
+// Callers over the limit wait in FIFO order, so a bulk transfer opening many
+// streams cannot starve a caller that asked earlier.
+type streamBudget struct {
+	limit   int // 0 means unlimited
+	used    int
+	waiters []chan struct{} // closed when handed a slot
+	stats   OpenStats
+}
+
+// SetMaxOpenStreams bounds the application streams this side keeps open on
+// the session to n; OpenStream then waits, in call order, until a stream is
+// closed locally or its context ends. Streams accepted from the peer do not
+// count. Zero, the default, removes the bound, leaving only the peer's
+// stream limit. Lowering the bound does not close streams already open.
+func (s *Session) SetMaxOpenStreams(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.budget.limit = max(n, 0)
+	s.grantLocked()
+}
+
+// grantLocked hands free slots to waiters in order.
+func (s *Session) grantLocked() {
+	b := &s.budget
+	for len(b.waiters) > 0 && (b.limit == 0 || b.used < b.limit) {
+		b.used++
+		close(b.waiters[0])
+		b.waiters = b.waiters[1:]
+	}
+}
+
+// acquireStream takes a slot of the open budget, waiting for one if needed.
+func (s *Session) acquireStream(ctx context.Context) error {
+	s.mu.Lock()
+	b := &s.budget
+	if len(b.waiters) == 0 && (b.limit == 0 || b.used < b.limit) {
+		b.used++
+		s.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	b.waiters = append(b.waiters, ready)
+	b.stats.Queued++
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	case <-s.ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := slices.Index(b.waiters, ready); i >= 0 {
+		b.waiters = slices.Delete(b.waiters, i, i+1)
+	} else {
+		// Granted while giving up: pass the slot on.
+		b.used--
+		s.grantLocked()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return context.Cause(s.ctx)
+}
+
+// releaseStream returns a slot taken by acquireStream.
+func (s *Session) releaseStream() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.budget.used--
+	s.grantLocked()
+}
+
+// opened records the latency of a successful open.
+func (s *Session) opened(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := &s.budget.stats
+	o.Opened++
+	o.Total += d
+	o.Max = max(o.Max, d)
+}
+
+// OpenStats returns the stream open latency of the session.
+func (s *Session) OpenStats() OpenStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.budget.stats
+	o.Waiting = len(s.budget.waiters)
+	return o
+}
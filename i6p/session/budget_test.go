@@ -0,0 +1,69 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitQueued(t *testing.T, s *Session, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.OpenStats().Waiting != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers waiting, want %d", s.OpenStats().Waiting, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOpenBudget(t *testing.T) {
+	client, _ := sessionPair(t)
+	client.SetMaxOpenStreams(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, err := client.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+
+	// Callers over the budget wait, and are served in order.
+	order := make(chan int, 2)
+	for i := range 2 {
+		go func() {
+			st, err := client.OpenStream(ctx)
+			if err != nil {
+				t.Errorf("queued OpenStream: %v", err)
+				return
+			}
+			order <- i
+			_ = st.Close()
+		}()
+		waitQueued(t, client, i+1)
+	}
+	_ = first.Close()
+	if a, b := <-order, <-order; a != 0 || b != 1 {
+		t.Fatalf("served %d before %d", a, b)
+	}
+
+	// A waiting caller gives up with its context.
+	held, _ := client.OpenStream(ctx)
+	short, cancelShort := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelShort()
+	if _, err := client.OpenStream(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("OpenStream past its deadline: %v", err)
+	}
+	// Raising the budget admits more streams.
+	client.SetMaxOpenStreams(2)
+	if _, err := client.OpenStream(ctx); err != nil {
+		t.Fatalf("OpenStream after raising the budget: %v", err)
+	}
+	_ = held.Close()
+
+	stats := client.OpenStats()
+	if stats.Opened != 5 || stats.Queued != 3 || stats.Waiting != 0 || stats.Max < stats.Mean() || stats.Max == 0 {
+		t.Fatalf("OpenStats = %+v", stats)
+	}
+}
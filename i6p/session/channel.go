@@ -0,0 +1,25 @@
+package session
+
+import "github.com/TheusHen/I6P/i6p/crypto"
+
+// Padding returns the message padding policy negotiated in the handshake
+// from both peers' crypto.PaddingCapability. It is none unless both
+// advertised a policy.
+func (s *Session) Padding() crypto.PaddingPolicy { return s.padding }
+
+// NewSecureChannel returns an end-to-end channel for this session, padded
+// with the negotiated policy. The client of the handshake is the channel's
+// initiator. The application still exchanges the ephemeral public keys
+// (LocalEphemeralPublic, Complete), e.g. on a stream of the session.
+func (s *Session) NewSecureChannel() (*crypto.SecureChannel, error) {
+	newChannel := crypto.NewSecureChannelResponder
+	if s.initiator {
+		newChannel = crypto.NewSecureChannelInitiator
+	}
+	sc, err := newChannel()
+	if err != nil {
+		return nil, err
+	}
+	sc.SetPadding(s.padding)
+	return sc, nil
+}
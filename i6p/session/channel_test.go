@@ -0,0 +1,51 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+func TestSessionPaddingNegotiation(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	padded := func(v string) HandshakeOptions {
+		return HandshakeOptions{Capabilities: map[string]string{crypto.PaddingCapability: v}}
+	}
+	client, server, cerr, serr := versionPair(t, kp, padded("bucket/64"), padded("constant/256"))
+	if cerr != nil || serr != nil {
+		t.Fatalf("handshake: %v, %v", cerr, serr)
+	}
+	want := crypto.PaddingPolicy{Mode: crypto.PaddingConstant, Size: 256}
+	if client.Padding() != want || server.Padding() != want {
+		t.Fatalf("padding %v, %v", client.Padding(), server.Padding())
+	}
+
+	a, err := client.NewSecureChannel()
+	if err != nil {
+		t.Fatalf("NewSecureChannel: %v", err)
+	}
+	b, _ := server.NewSecureChannel()
+	if err := a.Complete(b.LocalEphemeralPublic()); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if err := b.Complete(a.LocalEphemeralPublic()); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	short, _ := a.Encrypt([]byte("hi"), nil)
+	long, _ := a.Encrypt(make([]byte, 200), nil)
+	if len(short) != len(long) {
+		t.Fatalf("ciphertext sizes %d and %d leak the plaintext size", len(short), len(long))
+	}
+	if pt, err := b.Decrypt(short, nil); err != nil || string(pt) != "hi" {
+		t.Fatalf("Decrypt: %q, %v", pt, err)
+	}
+
+	client, server, cerr, serr = versionPair(t, kp, padded("bucket/64"), HandshakeOptions{})
+	if cerr != nil || serr != nil {
+		t.Fatalf("handshake: %v, %v", cerr, serr)
+	}
+	if client.Padding() != (crypto.PaddingPolicy{}) || server.Padding() != (crypto.PaddingPolicy{}) {
+		t.Fatalf("padding without both peers: %v, %v", client.Padding(), server.Padding())
+	}
+}
@@ -0,0 +1,73 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/timesync"
+)
+
+// ClockSamples is the number of round trips ClockOffset times.
+const ClockSamples = 4
+
+// timeReply is a TIME_RESPONSE with the local time it arrived.
+type timeReply struct {
+	protocol.TimeResponse
+	at time.Time
+}
+
+type clockState struct {
+	mu   sync.Mutex // serializes ClockOffset calls
+	seq  uint64
+	last atomic.Pointer[timesync.Estimate]
+}
+
+// ClockOffset estimates how far the peer's clock is ahead of the local one
+// from ClockSamples TIME_REQUEST round trips on the control stream. Peers
+// that predate TIME_REQUEST never answer, so ctx should carry a deadline.
+func (s *Session) ClockOffset(ctx context.Context) (timesync.Estimate, error) {
+	s.clock.mu.Lock()
+	defer s.clock.mu.Unlock()
+
+	samples := make([]timesync.Sample, 0, ClockSamples)
+	for len(samples) < ClockSamples {
+		s.clock.seq++
+		seq := s.clock.seq
+		t1 := time.Now()
+		if err := s.writeFrame(protocol.NewTimeRequestFrame(seq)); err != nil {
+			return timesync.Estimate{}, err
+		}
+	wait:
+		for {
+			select {
+			case r := <-s.times:
+				if r.Seq != seq {
+					continue // a late answer to an abandoned request
+				}
+				samples = append(samples, timesync.Sample{T1: t1, T2: r.Received, T3: r.Sent, T4: r.at})
+				break wait
+			case <-ctx.Done():
+				return timesync.Estimate{}, ctx.Err()
+			case <-s.Done():
+				return timesync.Estimate{}, s.CloseReason()
+			}
+		}
+	}
+	est, err := timesync.Compute(samples)
+	if err == nil {
+		s.clock.last.Store(&est)
+	}
+	return est, err
+}
+
+// LastClockOffset returns the estimate of the last successful ClockOffset
+// call, and false if there was none.
+func (s *Session) LastClockOffset() (timesync.Estimate, bool) {
+	if est := s.clock.last.Load(); est != nil {
+		return *est, true
+	}
+	return timesync.Estimate{}, false
+}
@@ -0,0 +1,35 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClockOffset(t *testing.T) {
+	client, server := sessionPair(t)
+	if _, ok := client.LastClockOffset(); ok {
+		t.Fatal("estimate before any ClockOffset call")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	est, err := client.ClockOffset(ctx)
+	if err != nil {
+		t.Fatalf("ClockOffset: %v", err)
+	}
+	// Both ends share a clock, so the true offset is zero.
+	if est.Samples != ClockSamples || est.Offset.Abs() > est.Error()+time.Millisecond {
+		t.Fatalf("estimate %+v", est)
+	}
+	if last, ok := client.LastClockOffset(); !ok || last != est {
+		t.Fatalf("LastClockOffset %+v, %v", last, ok)
+	}
+
+	silence(server)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.ClockOffset(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ClockOffset to a silent peer: %v", err)
+	}
+}
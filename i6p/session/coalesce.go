@@ -0,0 +1,184 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// Defaults of CoalesceOptions.
+const (
+	DefaultCoalesceDelay    = time.Millisecond
+	DefaultCoalesceMaxBytes = 16 << 10
+)
+
+// CoalesceOptions bound how long and how much a CoalescedStream buffers.
+// The zero value of each field selects its default.
+type CoalesceOptions struct {
+	// Delay is the longest a write waits for more to join it.
+	Delay time.Duration
+	// MaxBytes flushes the buffer once it holds this many bytes. Larger
+	// writes bypass the buffer.
+	MaxBytes int
+}
+
+// CoalesceStats counts the writes a CoalescedStream merged.
+type CoalesceStats struct {
+	Writes  uint64 // Write calls by the application
+	Flushes uint64 // writes to the underlying stream
+}
+
+// CoalescedStream merges small writes to a stream into fewer, larger writes
+// to the underlying stream, like Nagle's algorithm but bounded by Delay and
+// MaxBytes. It suits chatty request/response traffic; streams carrying bulk
+// data gain nothing from it. Reads pass through.
+//
+// Buffered bytes are written when the delay expires, the buffer fills, or
+// on Flush or Close, under the write deadline in effect then. A failed write
+// is returned by every later Write, Flush and Close.
+type CoalescedStream struct {
+	transport.Stream
+	delay    time.Duration
+	maxBytes int
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer // pending delayed flush, or nil
+	err   error
+
+	writes, flushes atomic.Uint64
+}
+
+// NewCoalescedStream wraps st so its writes are coalesced.
+func NewCoalescedStream(st transport.Stream, opts CoalesceOptions) *CoalescedStream {
+	return &CoalescedStream{
+		Stream:   st,
+		delay:    orDuration(opts.Delay, DefaultCoalesceDelay),
+		maxBytes: orInt(opts.MaxBytes, DefaultCoalesceMaxBytes),
+	}
+}
+
+// CoalesceStreams returns a StreamInterceptor that coalesces writes on every
+// application stream of a session.
+func CoalesceStreams(opts CoalesceOptions) StreamInterceptor {
+	return func(_ context.Context, _ StreamDirection, st transport.Stream) (transport.Stream, error) {
+		return NewCoalescedStream(st, opts), nil
+	}
+}
+
+func orDuration(v, def time.Duration) time.Duration {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+func orInt(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// Write buffers p, or writes it through along with the buffer when they
+// reach MaxBytes.
+func (c *CoalescedStream) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(c.buf)+len(p) < c.maxBytes {
+		c.buf = append(c.buf, p...)
+		if c.timer == nil {
+			c.timer = time.AfterFunc(c.delay, c.delayedFlush)
+		}
+		return len(p), nil
+	}
+	if len(c.buf) > 0 && len(p) < c.maxBytes {
+		// Fill up one full-sized write; the rest starts the next batch.
+		n := c.maxBytes - len(c.buf)
+		c.buf = append(c.buf, p[:n]...)
+		if err := c.flushLocked(); err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf, p[n:]...)
+		if len(c.buf) > 0 {
+			c.timer = time.AfterFunc(c.delay, c.delayedFlush)
+		}
+		return len(p), nil
+	}
+	if err := c.flushLocked(); err != nil {
+		return 0, err
+	}
+	c.flushes.Add(1)
+	n, err := c.Stream.Write(p)
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *CoalescedStream) delayedFlush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timer = nil
+	_ = c.flushLocked()
+}
+
+// flushLocked writes the buffer and stops the delayed flush.
+func (c *CoalescedStream) flushLocked() error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.err != nil || len(c.buf) == 0 {
+		return c.err
+	}
+	c.flushes.Add(1)
+	_, err := c.Stream.Write(c.buf)
+	c.buf = c.buf[:0]
+	if err != nil {
+		c.err = err
+	}
+	return err
+}
+
+// Flush writes the buffered bytes now.
+func (c *CoalescedStream) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flushLocked()
+}
+
+// Close flushes the buffer and ends the write direction.
+func (c *CoalescedStream) Close() error {
+	c.mu.Lock()
+	err := c.flushLocked()
+	c.mu.Unlock()
+	if cerr := c.Stream.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CancelWrite discards the buffer and resets the write direction.
+func (c *CoalescedStream) CancelWrite(code transport.StreamErrorCode) {
+	c.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.buf = nil
+	c.mu.Unlock()
+	c.Stream.CancelWrite(code)
+}
+
+// Stats returns the writes merged so far.
+func (c *CoalescedStream) Stats() CoalesceStats {
+	return CoalesceStats{Writes: c.writes.Load(), Flushes: c.flushes.Load()}
+}
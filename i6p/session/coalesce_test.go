@@ -0,0 +1,70 @@
+package session
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestCoalescedStream(t *testing.T) {
+	client, server := sessionPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	raw, err := client.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	st := NewCoalescedStream(raw, CoalesceOptions{Delay: time.Hour, MaxBytes: 64})
+	in, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+
+	// Small writes are held until the buffer fills.
+	for range 10 {
+		if _, err := st.Write([]byte("0123456789")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if got := st.Stats(); got.Writes != 10 || got.Flushes != 1 {
+		t.Fatalf("Stats = %+v", got)
+	}
+	buf := make([]byte, 64)
+	if _, err := io.ReadFull(in, buf); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+
+	// A large write goes through after the buffered bytes.
+	if _, err := st.Write(make([]byte, 100)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_ = st.Close()
+	rest, err := io.ReadAll(in)
+	if err != nil || len(rest) != 136 {
+		t.Fatalf("ReadAll = %d bytes, %v", len(rest), err)
+	}
+	if got := st.Stats(); got.Flushes != 3 {
+		t.Fatalf("Stats after Close = %+v", got)
+	}
+}
+
+func TestCoalesceDelay(t *testing.T) {
+	client, server := sessionPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client.ic.Stream = []StreamInterceptor{CoalesceStreams(CoalesceOptions{Delay: 5 * time.Millisecond})}
+	st, err := client.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	_, _ = st.Write([]byte("ping"))
+	in, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(in, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+}
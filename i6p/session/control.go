@@ -0,0 +1,188 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+func newSession(conn transport.Conn, control transport.Stream, local, remote identity.PeerID, caps map[string]string, version uint8, ic Interceptors) *Session {
+	s := &Session{
+		conn:         conn,
+		control:      control,
+		localPeerID:  local,
+		remotePeerID: remote,
+		caps:         caps,
+		version:      version,
+		ic:           ic,
+		pongs:        make(chan uint64, 8),
+		times:        make(chan timeReply, 8),
+		controlDone:  make(chan struct{}),
+		idle:         make(chan struct{}),
+		goAwayRecv:   make(chan struct{}),
+		frames:       newFrameQueue(),
+		accept: acceptor{
+			streams: make(chan transport.Stream, acceptBacklog),
+			done:    make(chan struct{}),
+		},
+	}
+	s.ctx, s.cancel = context.WithCancelCause(conn.Context())
+	close(s.idle)
+	go s.controlLoop()
+	go s.writeLoop()
+	return s
+}
+
+// frameVersion is the version carried by frame headers after the handshake;
+// Version1 headers carry none.
+func (s *Session) frameVersion() uint8 {
+	if s.version > protocol.Version1 {
+		return s.version
+	}
+	return 0
+}
+
+// writeFrame writes a frame to the control stream, after any queued frames
+// of more urgent lanes.
+func (s *Session) writeFrame(f protocol.Frame) error {
+	return s.sendFrame(f, time.Time{})
+}
+
+// writeFrameTimeout is writeFrame bounded by d, so a peer that stopped reading
+// cannot block the caller indefinitely.
+func (s *Session) writeFrameTimeout(f protocol.Frame, d time.Duration) error {
+	return s.sendFrame(f, time.Now().Add(d))
+}
+
+// FrameHandler receives control frames of one type. It runs on the control
+// loop, after the session's own handling, so it must not block. A handler
+// that panics ends the session with an i6perrors.CodeInternal error.
+type FrameHandler func(f protocol.Frame)
+
+// HandleFrame registers h for control frames of type t, replacing any
+// previous handler; a nil h removes it. PING, PONG and GOAWAY keep their
+// built-in handling and are passed to h afterwards.
+func (s *Session) HandleFrame(t protocol.MessageType, h FrameHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h == nil {
+		delete(s.handlers, t)
+		return
+	}
+	if s.handlers == nil {
+		s.handlers = map[protocol.MessageType]FrameHandler{}
+	}
+	s.handlers[t] = h
+}
+
+// SendFrame writes f to the control stream, for frames the peer handles
+// with HandleFrame, such as the WINDOW_UPDATE and ACK frames of transfer
+// flow control. It returns once f is written or the connection ends.
+func (s *Session) SendFrame(f protocol.Frame) error {
+	return s.writeFrame(f)
+}
+
+// Context returns a context that is canceled when the session ends: either
+// side closed the connection, or the peer violated the protocol. Goroutines
+// serving the session can derive from it to shut down with it.
+func (s *Session) Context() context.Context { return s.ctx }
+
+// Done returns a channel that is closed when the session ends.
+func (s *Session) Done() <-chan struct{} { return s.ctx.Done() }
+
+// CloseReason returns why the session ended, or nil while it is open. A local
+// CloseWithError reports an error wrapping ErrClosed, a protocol violation the
+// violation, and anything else the transport's close error. When either side
+// closed with a code, the reason is an *i6perrors.Error carrying it.
+func (s *Session) CloseReason() error {
+	if s.ctx.Err() == nil {
+		return nil
+	}
+	return i6perrors.FromClose(context.Cause(s.ctx))
+}
+
+// controlLoop reads frames from the control stream after the handshake.
+// PINGs are answered here so liveness works whether or not the application
+// runs a heartbeat itself, and TIME_REQUESTs for the same reason. Frames without a handler are ignored. A peer that
+// violates the protocol gets its connection closed.
+func (s *Session) controlLoop() {
+	defer close(s.controlDone)
+	for {
+		f, err := protocol.ReadFrame(s.control)
+		if err != nil {
+			return
+		}
+		at := time.Now()
+		if f.Version != s.frameVersion() {
+			s.fail(fmt.Errorf("%w: %d on a version %d session", protocol.ErrInvalidVersion, f.Version, s.version))
+			return
+		}
+		if err := s.dispatch(f, at); err != nil {
+			s.cancel(err)
+			_ = s.conn.CloseWithError(uint64(i6perrors.CodeInternal), err.Error())
+			return
+		}
+	}
+}
+
+// dispatch handles one control frame read at at. A panic in a frame
+// interceptor or handler is returned as an error instead of crashing the
+// process.
+func (s *Session) dispatch(f protocol.Frame, at time.Time) (err error) {
+	defer i6perrors.Recover(&err, "session: control frame")
+	if f, err = s.ic.frame(FrameRead, f); err != nil {
+		return nil
+	}
+	switch f.Type {
+	case protocol.MessageTypePing:
+		if seq, err := protocol.DecodePing(f.Payload); err == nil {
+			_ = s.writeFrame(protocol.NewPongFrame(seq))
+		}
+	case protocol.MessageTypePong:
+		if seq, err := protocol.DecodePing(f.Payload); err == nil {
+			select {
+			case s.pongs <- seq:
+			default:
+			}
+		}
+	case protocol.MessageTypeTimeRequest:
+		if seq, err := protocol.DecodePing(f.Payload); err == nil {
+			_ = s.writeFrame(protocol.NewTimeResponseFrame(protocol.TimeResponse{Seq: seq, Received: at, Sent: time.Now()}))
+		}
+	case protocol.MessageTypeTimeResponse:
+		if r, err := protocol.DecodeTimeResponse(f.Payload); err == nil {
+			select {
+			case s.times <- timeReply{r, at}:
+			default:
+			}
+		}
+	case protocol.MessageTypeGoAway:
+		s.mu.Lock()
+		select {
+		case <-s.goAwayRecv:
+		default:
+			s.goAwayReason = string(f.Payload)
+			close(s.goAwayRecv)
+		}
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	h := s.handlers[f.Type]
+	s.mu.Unlock()
+	if h != nil {
+		h(f)
+	}
+	return nil
+}
+
+// fail records a protocol violation that ended the control loop and closes
+// the connection.
+func (s *Session) fail(err error) {
+	s.cancel(i6perrors.Wrap(i6perrors.CodeProtocolViolation, "session", err))
+	_ = s.conn.CloseWithError(uint64(i6perrors.CodeProtocolViolation), "protocol violation: "+err.Error())
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+func TestHandleFrame(t *testing.T) {
+	client, server := sessionPair(t)
+	got := make(chan []byte, 1)
+	server.HandleFrame(protocol.MessageTypePeerInfo, func(f protocol.Frame) { got <- f.Payload })
+	if err := client.writeFrame(protocol.Frame{Type: protocol.MessageTypePeerInfo, Payload: []byte("caps")}); err != nil {
+		t.Fatalf("writeFrame: %v", err)
+	}
+	select {
+	case p := <-got:
+		if string(p) != "caps" {
+			t.Fatalf("payload %q", p)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler not called")
+	}
+	if server.CloseReason() != nil {
+		t.Fatalf("CloseReason while running: %v", server.CloseReason())
+	}
+}
+
+func TestControlLoopEndsOnProtocolViolation(t *testing.T) {
+	client, server := sessionPair(t)
+	// A Version1 header on a version 2 session.
+	if err := protocol.WriteFrame(client.control, protocol.NewPingFrame(1)); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	select {
+	case <-server.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("session still open")
+	}
+	if !errors.Is(server.CloseReason(), protocol.ErrInvalidVersion) || !errors.Is(server.CloseReason(), i6perrors.CodeProtocolViolation) {
+		t.Fatalf("CloseReason = %v", server.CloseReason())
+	}
+	select {
+	case <-client.Connection().Context().Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection left open after a protocol violation")
+	}
+}
+
+func TestSessionDoneOnClose(t *testing.T) {
+	client, server := sessionPair(t)
+	select {
+	case <-server.Done():
+		t.Fatal("Done closed on an open session")
+	default:
+	}
+
+	if err := client.CloseWithError(7, "bye"); err != nil {
+		t.Fatalf("CloseWithError: %v", err)
+	}
+	for name, s := range map[string]*Session{"client": client, "server": server} {
+		select {
+		case <-s.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: Done not closed", name)
+		}
+		if s.Context().Err() == nil {
+			t.Fatalf("%s: Context not canceled", name)
+		}
+	}
+	if !errors.Is(client.CloseReason(), ErrClosed) {
+		t.Fatalf("client CloseReason = %v", client.CloseReason())
+	}
+	if !errors.Is(server.CloseReason(), memory.ErrClosed) || !strings.Contains(server.CloseReason().Error(), "bye") {
+		t.Fatalf("server CloseReason = %v", server.CloseReason())
+	}
+}
+
+func TestCloseReasonCode(t *testing.T) {
+	client, server := quicPair(t)
+	if err := server.CloseWithError(uint64(i6perrors.CodeDraining), "shutting down"); err != nil {
+		t.Fatalf("CloseWithError: %v", err)
+	}
+	select {
+	case <-client.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("client session still open")
+	}
+	var e *i6perrors.Error
+	if reason := client.CloseReason(); !errors.As(reason, &e) || e.Code != i6perrors.CodeDraining || !e.Remote || !i6perrors.IsRetryable(reason) {
+		t.Fatalf("client CloseReason = %v", reason)
+	}
+	if reason := server.CloseReason(); !errors.Is(reason, i6perrors.CodeDraining) || !errors.Is(reason, ErrClosed) {
+		t.Fatalf("server CloseReason = %v", reason)
+	}
+}
+
+func TestHandlerPanicEndsSession(t *testing.T) {
+	client, server := sessionPair(t)
+	server.HandleFrame(protocol.MessageTypePeerInfo, func(f protocol.Frame) { _ = f.Payload[100] })
+	if err := client.writeFrame(protocol.Frame{Type: protocol.MessageTypePeerInfo, Payload: []byte("x")}); err != nil {
+		t.Fatalf("writeFrame: %v", err)
+	}
+	select {
+	case <-server.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("session still open after a handler panic")
+	}
+	var p *i6perrors.PanicError
+	if reason := server.CloseReason(); !errors.Is(reason, i6perrors.CodeInternal) || !errors.As(reason, &p) {
+		t.Fatalf("CloseReason = %v", reason)
+	}
+}
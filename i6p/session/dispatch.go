@@ -0,0 +1,155 @@
+package session
+
+import (
+	"context"
+	"sync"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// ProtocolHandler serves one accepted stream of the protocol it was
+// registered for. The session closes the stream when the handler returns.
+type ProtocolHandler func(st transport.Stream) error
+
+// StreamErrorHook receives the errors protocol handlers return, with the
+// protocol of the stream. A handler that panics is reported with an
+// i6perrors.CodeInternal error wrapping an *i6perrors.PanicError.
+type StreamErrorHook func(proto string, err error)
+
+// acceptBacklog bounds the accepted streams without a handler that wait for
+// AcceptStream.
+const acceptBacklog = 32
+
+// acceptor runs the session's single accept loop, which every stream
+// accepted after the handshake goes through.
+type acceptor struct {
+	once    sync.Once
+	streams chan transport.Stream // streams without a handler, for AcceptStream
+	done    chan struct{}         // closed when the accept loop exits
+	err     error                 // why it exited; set before done is closed
+
+	mu       sync.Mutex
+	handlers map[string]ProtocolHandler
+	hook     StreamErrorHook
+}
+
+// HandleProtocol routes accepted streams of protocol proto to h, each on its
+// own goroutine, instead of returning them from AcceptStream, so several
+// services can share one session. It replaces any previous handler; a nil h
+// removes it. Streams of sessions without stream protocols all have protocol
+// "" (see StreamProtocols).
+func (s *Session) HandleProtocol(proto string, h ProtocolHandler) {
+	s.accept.mu.Lock()
+	if h == nil {
+		delete(s.accept.handlers, proto)
+	} else {
+		if s.accept.handlers == nil {
+			s.accept.handlers = map[string]ProtocolHandler{}
+		}
+		s.accept.handlers[proto] = h
+	}
+	s.accept.mu.Unlock()
+	s.startAccepting()
+}
+
+// SetStreamErrorHook sets the function that receives the errors returned by
+// protocol handlers. Without one they are dropped.
+func (s *Session) SetStreamErrorHook(hook StreamErrorHook) {
+	s.accept.mu.Lock()
+	defer s.accept.mu.Unlock()
+	s.accept.hook = hook
+}
+
+// ServeProtocol handles streams of protocol proto with h until ctx is done
+// or the session ends, then removes the handler and returns ctx.Err() or
+// the session's CloseReason. On sessions without stream protocols streams
+// carry no name, so h is registered for "" and receives every stream.
+func (s *Session) ServeProtocol(ctx context.Context, proto string, h ProtocolHandler) error {
+	if !s.streamProtocols {
+		proto = ""
+	}
+	s.HandleProtocol(proto, h)
+	defer s.HandleProtocol(proto, nil)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.accept.done:
+		if err := s.CloseReason(); err != nil {
+			return err
+		}
+		return s.accept.err
+	}
+}
+
+func (s *Session) startAccepting() {
+	s.accept.once.Do(func() { go s.acceptLoop() })
+}
+
+// acceptLoop accepts streams until the connection fails, skipping the
+// control stream, streams rejected by a stream interceptor and, when the
+// session negotiated stream protocols, streams without a valid header.
+func (s *Session) acceptLoop() {
+	defer close(s.accept.done)
+	for {
+		st, err := s.conn.AcceptStream(s.ctx)
+		if err != nil {
+			s.accept.err = err
+			return
+		}
+		if st == s.control {
+			_ = st.Close()
+			continue
+		}
+		wrapped, err := s.ic.stream(s.ctx, StreamAccepted, st)
+		if err != nil {
+			_ = st.Close()
+			continue
+		}
+		if !s.streamProtocols {
+			s.route(s.track(wrapped, "", true), "")
+			continue
+		}
+		// Headers are read off the loop so a peer that is slow to send one
+		// does not hold up the streams behind it.
+		go func() {
+			proto, err := s.readStreamHeader(wrapped)
+			if err != nil {
+				_ = wrapped.Close()
+				return
+			}
+			s.route(s.track(wrapped, proto, true), proto)
+		}()
+	}
+}
+
+// route hands st to the handler of proto, or queues it for AcceptStream.
+// Streams that find the queue full are refused, so a peer cannot pile up
+// streams nobody accepts.
+func (s *Session) route(st transport.Stream, proto string) {
+	s.accept.mu.Lock()
+	h, hook := s.accept.handlers[proto], s.accept.hook
+	s.accept.mu.Unlock()
+	if h == nil {
+		select {
+		case s.accept.streams <- st:
+		default:
+			transport.Reset(st, transport.StreamRefused)
+			_ = st.Close()
+		}
+		return
+	}
+	go func() {
+		defer st.Close()
+		if err := serveStream(h, st); err != nil && hook != nil {
+			hook(proto, err)
+		}
+	}()
+}
+
+// serveStream runs h on st, returning a panic in h as an
+// i6perrors.CodeInternal error instead of crashing the process.
+func serveStream(h ProtocolHandler, st transport.Stream) (err error) {
+	defer i6perrors.Recover(&err, "session: protocol handler")
+	return h(st)
+}
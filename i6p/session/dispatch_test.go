@@ -0,0 +1,115 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+func TestHandleProtocolSharesSession(t *testing.T) {
+	client, server := trafficPair(t, true, true)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	got := make(chan string, 2)
+	echo := func(st transport.Stream) error {
+		b, err := io.ReadAll(st)
+		got <- StreamProtocol(st) + ":" + string(b)
+		return err
+	}
+	server.HandleProtocol("a", echo)
+	server.HandleProtocol("b", echo)
+
+	for _, p := range []string{"a", "b"} {
+		st, err := client.OpenProtocolStream(ctx, p)
+		if err != nil {
+			t.Fatalf("OpenProtocolStream: %v", err)
+		}
+		_, _ = st.Write([]byte("hi"))
+		_ = st.Close()
+	}
+	seen := map[string]bool{}
+	for range 2 {
+		select {
+		case s := <-got:
+			seen[s] = true
+		case <-ctx.Done():
+			t.Fatal("handler not called")
+		}
+	}
+	if !seen["a:hi"] || !seen["b:hi"] {
+		t.Fatalf("handled %v", seen)
+	}
+
+	// Streams without a handler still reach AcceptStream.
+	if p := sendOn(t, client, server, "c", "x"); p != "c" {
+		t.Fatalf("accepted protocol %q", p)
+	}
+}
+
+func TestHandlerPanicIsReported(t *testing.T) {
+	client, server := trafficPair(t, true, true)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	reported := make(chan error, 1)
+	server.SetStreamErrorHook(func(proto string, err error) { reported <- err })
+	server.HandleProtocol("boom", func(transport.Stream) error { panic("handler bug") })
+	st, err := client.OpenProtocolStream(ctx, "boom")
+	if err != nil {
+		t.Fatalf("OpenProtocolStream: %v", err)
+	}
+	_ = st.Close()
+	select {
+	case err := <-reported:
+		var pe *i6perrors.PanicError
+		if !errors.As(err, &pe) || pe.Value != "handler bug" || i6perrors.CodeOf(err) != i6perrors.CodeInternal {
+			t.Fatalf("reported %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("panic not reported")
+	}
+	// The session survives its handler.
+	if p := sendOn(t, client, server, "c", "x"); p != "c" {
+		t.Fatalf("accepted protocol %q", p)
+	}
+}
+
+func TestUnacceptedStreamsAreRefused(t *testing.T) {
+	client, server := trafficPair(t, true, true)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// The server only handles another protocol and never calls
+	// AcceptStream: past the backlog, streams are refused, not parked.
+	server.HandleProtocol("other", func(transport.Stream) error { return nil })
+	const extra = 4
+	errs := make(chan error, acceptBacklog+extra)
+	for range acceptBacklog + extra {
+		st, err := client.OpenProtocolStream(ctx, "unhandled")
+		if err != nil {
+			t.Fatalf("OpenProtocolStream: %v", err)
+		}
+		defer st.Close()
+		_ = st.SetReadDeadline(time.Now().Add(time.Second))
+		go func() {
+			_, err := st.Read(make([]byte, 1))
+			errs <- err
+		}()
+	}
+	var refused int
+	for range acceptBacklog + extra {
+		var se *transport.StreamError
+		if err := <-errs; errors.As(err, &se) && se.Code == transport.StreamRefused {
+			refused++
+		}
+	}
+	if refused != extra {
+		t.Fatalf("%d streams refused, want %d", refused, extra)
+	}
+}
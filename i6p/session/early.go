@@ -0,0 +1,65 @@
+package session
+
+import (
+	"context"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// closedChan is returned by HandshakeConfirmed on connections without early
+// data.
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// HandshakeConfirmed is closed once data sent on the session can no longer
+// be replayed. On connections that carry early data (transport.EarlyConn,
+// e.g. QUIC with 0-RTT) that is when the transport handshake completes; on
+// others it is closed from the start.
+func (s *Session) HandshakeConfirmed() <-chan struct{} {
+	if ec, ok := s.conn.(transport.EarlyConn); ok {
+		return ec.HandshakeComplete()
+	}
+	return closedChan
+}
+
+// MarkReplaySafe declares that requests of the given stream protocols are
+// idempotent, so their streams may be opened as early data. Streams of any
+// other protocol, including unnamed ones, are held back by OpenStream until
+// HandshakeConfirmed is closed. Built-in control frames are replay-safe.
+func (s *Session) MarkReplaySafe(protos ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replaySafe == nil {
+		s.replaySafe = make(map[string]bool, len(protos))
+	}
+	for _, p := range protos {
+		s.replaySafe[p] = true
+	}
+}
+
+// awaitReplaySafe waits until a stream of proto may be opened.
+func (s *Session) awaitReplaySafe(ctx context.Context, proto string) error {
+	confirmed := s.HandshakeConfirmed()
+	select {
+	case <-confirmed:
+		return nil
+	default:
+	}
+	s.mu.Lock()
+	safe := s.replaySafe[proto]
+	s.mu.Unlock()
+	if safe {
+		return nil
+	}
+	select {
+	case <-confirmed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.ctx.Done():
+		return context.Cause(s.ctx)
+	}
+}
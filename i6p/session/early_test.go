@@ -0,0 +1,78 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/transport"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+// earlyConn is a connection whose handshake completes when confirm is closed.
+type earlyConn struct {
+	transport.Conn
+	confirm chan struct{}
+}
+
+func (c *earlyConn) HandshakeComplete() <-chan struct{} { return c.confirm }
+
+func TestReplaySafeStreams(t *testing.T) {
+	plain, _ := sessionPair(t)
+	select {
+	case <-plain.HandshakeConfirmed():
+	default:
+		t.Fatal("memory session not confirmed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	network := memory.NewNetwork()
+	ln, _ := network.Listen("")
+	defer func() { _ = ln.Close() }()
+	go func() {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			return
+		}
+		kp, _ := identity.GenerateKeyPair()
+		_, _ = HandshakeServer(ctx, conn, kp, HandshakeOptions{})
+	}()
+	conn, err := network.Dial(ctx, ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	early := &earlyConn{Conn: conn, confirm: make(chan struct{})}
+	kp, _ := identity.GenerateKeyPair()
+	client, err := HandshakeClient(ctx, early, kp, HandshakeOptions{})
+	if err != nil {
+		t.Fatalf("HandshakeClient: %v", err)
+	}
+	client.MarkReplaySafe("lookup")
+
+	if _, err := client.OpenProtocolStream(ctx, "lookup"); err != nil {
+		t.Fatalf("replay-safe stream before confirmation: %v", err)
+	}
+	short, cancelShort := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelShort()
+	if _, err := client.OpenProtocolStream(short, "transfer"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unmarked stream before confirmation: %v", err)
+	}
+
+	opened := make(chan error, 1)
+	go func() {
+		_, err := client.OpenStream(ctx)
+		opened <- err
+	}()
+	select {
+	case err := <-opened:
+		t.Fatalf("OpenStream returned before confirmation: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(early.confirm)
+	if err := <-opened; err != nil {
+		t.Fatalf("OpenStream after confirmation: %v", err)
+	}
+}
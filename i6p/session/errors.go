@@ -0,0 +1,32 @@
+package session
+
+import (
+	"errors"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// classifyHandshake gives a handshake failure its i6perrors code, keeping
+// err in the chain.
+func classifyHandshake(err error) error {
+	var e *i6perrors.Error
+	var ce *transport.CloseError
+	switch {
+	case err == nil || errors.As(err, &e):
+		return err
+	case errors.As(err, &ce):
+		return i6perrors.FromClose(err)
+	case errors.Is(err, ErrHandshakeTimeout):
+		return i6perrors.Wrap(i6perrors.CodeHandshakeTimeout, "handshake", err)
+	case errors.Is(err, protocol.ErrNoCommonVersion), errors.Is(err, protocol.ErrVersionDowngrade):
+		return i6perrors.Wrap(i6perrors.CodeVersion, "handshake", err)
+	case errors.Is(err, protocol.ErrHelloBadSignature), errors.Is(err, protocol.ErrHelloPeerIDMismatch):
+		return i6perrors.Wrap(i6perrors.CodeAuthentication, "handshake", err)
+	case errors.Is(err, ErrHandshakeExpectedHello), errors.Is(err, protocol.ErrInvalidVersion),
+		errors.Is(err, protocol.ErrInvalidType), errors.Is(err, protocol.ErrFrameTooLarge):
+		return i6perrors.Wrap(i6perrors.CodeProtocolViolation, "handshake", err)
+	}
+	return i6perrors.Wrap(i6perrors.CodeHandshake, "handshake", err)
+}
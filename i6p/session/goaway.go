@@ -0,0 +1,116 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+var (
+	ErrGoingAway = errors.New("session: peer is going away")
+)
+
+// GoAway tells the peer that this side is draining: the peer must not open new
+// streams, while streams already open keep working. It is sent at most once.
+func (s *Session) GoAway(reason string) error {
+	s.mu.Lock()
+	if s.goAwaySent {
+		s.mu.Unlock()
+		return nil
+	}
+	s.goAwaySent = true
+	s.mu.Unlock()
+	return s.writeFrame(protocol.NewGoAwayFrame(reason))
+}
+
+// GoingAway is closed when the peer sends GOAWAY.
+func (s *Session) GoingAway() <-chan struct{} { return s.goAwayRecv }
+
+// GoAwayReason returns the reason the peer gave in its GOAWAY, if any.
+func (s *Session) GoAwayReason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.goAwayReason
+}
+
+// ActiveStreams returns the number of application streams opened or accepted
+// on this session that have not been closed locally.
+func (s *Session) ActiveStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+// WaitIdle blocks until every application stream has been closed locally, the
+// connection ends, or ctx is done.
+func (s *Session) WaitIdle(ctx context.Context) error {
+	s.mu.Lock()
+	idle := s.idle
+	s.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-s.conn.Context().Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// track wraps a stream the session opened or, if accepted, the peer opened.
+func (s *Session) track(st transport.Stream, proto string, accepted bool) *trackedStream {
+	s.mu.Lock()
+	if s.active == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.active++
+	s.mu.Unlock()
+	return &trackedStream{Stream: st, s: s, proto: proto, c: s.counters(proto, accepted)}
+}
+
+func (s *Session) untrack() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	if s.active == 0 {
+		close(s.idle)
+	}
+}
+
+// trackedStream counts toward Session.ActiveStreams until it is closed, and
+// its traffic toward Session.Stats. Opened streams also hold a slot of the
+// open budget until then.
+type trackedStream struct {
+	transport.Stream
+	s        *Session
+	proto    string
+	c        *protocolCounters
+	budgeted bool
+	once     sync.Once
+}
+
+func (t *trackedStream) Read(p []byte) (int, error) {
+	n, err := t.Stream.Read(p)
+	t.c.received.Add(uint64(n))
+	return n, err
+}
+
+func (t *trackedStream) Write(p []byte) (int, error) {
+	n, err := t.Stream.Write(p)
+	t.c.sent.Add(uint64(n))
+	return n, err
+}
+
+func (t *trackedStream) Close() error {
+	err := t.Stream.Close()
+	t.once.Do(func() {
+		t.s.untrack()
+		if t.budgeted {
+			t.s.releaseStream()
+		}
+	})
+	return err
+}
@@ -3,32 +3,119 @@ package session
 import (
 	"context"
 	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
 
+	"github.com/TheusHen/I6P/i6p/clock"
+	"github.com/TheusHen/I6P/i6p/crypto"
 	"github.com/TheusHen/I6P/i6p/identity"
 	"github.com/TheusHen/I6P/i6p/protocol"
-	q "github.com/quic-go/quic-go"
+	"github.com/TheusHen/I6P/i6p/transport"
 )
 
 var (
 	ErrHandshakeExpectedHello = errors.New("handshake expected HELLO")
+	ErrHandshakeTimeout       = errors.New("handshake timed out")
+	ErrClockSkew              = errors.New("handshake: HELLO timestamp outside the allowed clock skew")
 )
 
 type HandshakeOptions struct {
 	Capabilities map[string]string
+	Interceptors Interceptors // applied to the handshake and kept by the session
+	// Versions are the protocol versions offered, highest first. Defaults to
+	// protocol.SupportedVersions; leaving out Version1 refuses legacy peers.
+	Versions []uint8
+	// HandshakeTimeout bounds the whole handshake, including reads and writes
+	// on the control stream, independently of the caller's context. Zero
+	// relies on the context alone.
+	HandshakeTimeout time.Duration
+	// HelloCache, if set and matching, supplies the server HELLO instead of
+	// signing and encoding one per connection.
+	HelloCache *HelloCache
+	// Clock timestamps the local HELLO and checks the remote one (clock.System
+	// if nil). The handshake timeout always follows the system clock.
+	Clock clock.Clock
+	// AllowLegacyServer lets a client that offers versions above Version1
+	// accept a server HELLO without versions, from a server that predates
+	// version negotiation. Such a HELLO is not bound to the client's offer,
+	// so one replayed by an attacker would force Version1; it is refused by
+	// default, and always when it carries protocol.MaxVersionCapability.
+	AllowLegacyServer bool
+	// MaxClockSkew, if positive, refuses a remote HELLO whose timestamp is
+	// further than this from the local clock. HELLOs served from a HelloCache
+	// can be up to its refresh period old, so allow for it.
+	MaxClockSkew time.Duration
+}
+
+func (o HandshakeOptions) versions() []uint8 {
+	if len(o.Versions) > 0 {
+		return o.Versions
+	}
+	return protocol.SupportedVersions
+}
+
+// checkTimestamp applies MaxClockSkew to the remote HELLO h.
+func (o HandshakeOptions) checkTimestamp(h protocol.Hello) error {
+	if o.MaxClockSkew <= 0 {
+		return nil
+	}
+	skew := clock.Or(o.Clock).Now().Sub(time.Unix(h.TimestampSec, 0))
+	if skew > o.MaxClockSkew || -skew > o.MaxClockSkew {
+		return fmt.Errorf("%w: %s", ErrClockSkew, skew.Round(time.Second))
+	}
+	return nil
+}
+
+// withHandshakeTimeout runs fn under timeout d, passing the deadline for the
+// control stream, and reports expiry as ErrHandshakeTimeout. Failures are
+// classified as i6perrors.Error values.
+func withHandshakeTimeout(ctx context.Context, d time.Duration, fn func(context.Context, time.Time) (*Session, error)) (*Session, error) {
+	if d <= 0 {
+		s, err := fn(ctx, time.Time{})
+		return s, classifyHandshake(err)
+	}
+	deadline := time.Now().Add(d)
+	ctx, cancel := context.WithDeadlineCause(ctx, deadline, ErrHandshakeTimeout)
+	defer cancel()
+	s, err := fn(ctx, deadline)
+	if err != nil && (errors.Is(err, os.ErrDeadlineExceeded) || context.Cause(ctx) == ErrHandshakeTimeout) {
+		err = ErrHandshakeTimeout
+	}
+	return s, classifyHandshake(err)
 }
 
 // HandshakeClient performs the I6P session handshake as a client.
-// The client opens a dedicated control stream.
-func HandshakeClient(ctx context.Context, conn *q.Conn, kp identity.KeyPair, opts HandshakeOptions) (*Session, error) {
+// The client opens a dedicated control stream and offers its versions; the
+// server's signed HELLO must select the highest version both offered. The
+// offer is also signed in the Version1 form, with its highest version as
+// protocol.MaxVersionCapability, so servers that predate versions accept
+// it. A server HELLO without versions is refused unless
+// opts.AllowLegacyServer is set or the client offered only Version1.
+func HandshakeClient(ctx context.Context, conn transport.Conn, kp identity.KeyPair, opts HandshakeOptions) (*Session, error) {
+	return withHandshakeTimeout(ctx, opts.HandshakeTimeout, func(ctx context.Context, deadline time.Time) (*Session, error) {
+		return handshakeClient(ctx, conn, kp, opts, deadline)
+	})
+}
+
+func handshakeClient(ctx context.Context, conn transport.Conn, kp identity.KeyPair, opts HandshakeOptions, deadline time.Time) (*Session, error) {
 	control, err := conn.OpenStreamSync(ctx)
 	if err != nil {
 		return nil, err
 	}
+	_ = control.SetDeadline(deadline)
 
 	localHello, err := protocol.NewHello(kp, opts.Capabilities)
 	if err != nil {
 		return nil, err
 	}
+	localHello.TimestampSec = clock.Or(opts.Clock).Now().Unix()
+	localHello.Versions = append([]uint8(nil), opts.versions()...)
+	if top := slices.Max(localHello.Versions); top > protocol.Version1 {
+		localHello.Capabilities[protocol.MaxVersionCapability] = strconv.Itoa(int(top))
+	}
 	if err := localHello.Sign(kp); err != nil {
 		return nil, err
 	}
@@ -36,11 +123,11 @@ func HandshakeClient(ctx context.Context, conn *q.Conn, kp identity.KeyPair, opt
 	if err != nil {
 		return nil, err
 	}
-	if err := protocol.WriteFrame(control, protocol.Frame{Type: protocol.MessageTypeHello, Payload: payload}); err != nil {
+	if err := opts.Interceptors.writeFrame(control, protocol.Frame{Type: protocol.MessageTypeHello, Payload: payload}); err != nil {
 		return nil, err
 	}
 
-	frame, err := protocol.ReadFrame(control)
+	frame, err := opts.Interceptors.readFrame(control)
 	if err != nil {
 		return nil, err
 	}
@@ -54,30 +141,62 @@ func HandshakeClient(ctx context.Context, conn *q.Conn, kp identity.KeyPair, opt
 	if err := remoteHello.Verify(); err != nil {
 		return nil, err
 	}
+	if err := opts.checkTimestamp(remoteHello); err != nil {
+		return nil, err
+	}
 	remoteID, err := identity.ParsePeerIDHex(remoteHello.PeerID)
 	if err != nil {
 		return nil, err
 	}
+	version, err := protocol.NegotiateVersion(localHello.Versions, remoteHello.Versions)
+	if err != nil {
+		return nil, err
+	}
+	if len(remoteHello.Versions) > 0 && remoteHello.Version != version {
+		return nil, fmt.Errorf("%w: server selected %d, expected %d", protocol.ErrVersionDowngrade, remoteHello.Version, version)
+	}
+	if len(remoteHello.Versions) == 0 && slices.Max(localHello.Versions) > protocol.Version1 {
+		if top, ok := remoteHello.Capabilities[protocol.MaxVersionCapability]; ok {
+			return nil, fmt.Errorf("%w: server speaks version %s but answered as Version1", protocol.ErrVersionDowngrade, top)
+		}
+		if !opts.AllowLegacyServer {
+			return nil, fmt.Errorf("%w: server HELLO offers no versions", protocol.ErrVersionDowngrade)
+		}
+	}
 
-	return &Session{
-		conn:         conn,
-		control:      control,
-		controlID:    control.StreamID(),
-		localPeerID:  kp.PeerID(),
-		remotePeerID: remoteID,
-		caps:         remoteHello.Capabilities,
-	}, nil
+	binding, err := channelBinding(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = control.SetDeadline(time.Time{})
+	s := newSession(conn, control, kp.PeerID(), remoteID, remoteHello.Capabilities, version, opts.Interceptors)
+	s.transcript = transcriptHash(payload, frame.Payload, version, binding)
+	s.streamProtocols = negotiateStreamProtocols(opts.Capabilities, remoteHello.Capabilities)
+	s.padding = crypto.NegotiatePaddingCapabilities(opts.Capabilities, remoteHello.Capabilities)
+	s.initiator = true
+	return s, nil
 }
 
 // HandshakeServer performs the I6P session handshake as a server.
-// The server accepts a dedicated control stream (opened by the client).
-func HandshakeServer(ctx context.Context, conn *q.Conn, kp identity.KeyPair, opts HandshakeOptions) (*Session, error) {
+// The server accepts a dedicated control stream (opened by the client) and
+// selects the highest version both sides offer. A client that offers no
+// versions gets a Version1 HELLO, unless its HELLO carries
+// protocol.MaxVersionCapability: then its offer was stripped in transit.
+func HandshakeServer(ctx context.Context, conn transport.Conn, kp identity.KeyPair, opts HandshakeOptions) (*Session, error) {
+	return withHandshakeTimeout(ctx, opts.HandshakeTimeout, func(ctx context.Context, deadline time.Time) (*Session, error) {
+		return handshakeServer(ctx, conn, kp, opts, deadline)
+	})
+}
+
+func handshakeServer(ctx context.Context, conn transport.Conn, kp identity.KeyPair, opts HandshakeOptions, deadline time.Time) (*Session, error) {
 	control, err := conn.AcceptStream(ctx)
 	if err != nil {
 		return nil, err
 	}
+	_ = control.SetDeadline(deadline)
 
-	frame, err := protocol.ReadFrame(control)
+	frame, err := opts.Interceptors.readFrame(control)
 	if err != nil {
 		return nil, err
 	}
@@ -91,32 +210,45 @@ func HandshakeServer(ctx context.Context, conn *q.Conn, kp identity.KeyPair, opt
 	if err := remoteHello.Verify(); err != nil {
 		return nil, err
 	}
+	if err := opts.checkTimestamp(remoteHello); err != nil {
+		return nil, err
+	}
 	remoteID, err := identity.ParsePeerIDHex(remoteHello.PeerID)
 	if err != nil {
 		return nil, err
 	}
 
-	localHello, err := protocol.NewHello(kp, opts.Capabilities)
+	version, err := protocol.NegotiateVersion(opts.versions(), remoteHello.Versions)
 	if err != nil {
 		return nil, err
 	}
-	if err := localHello.Sign(kp); err != nil {
-		return nil, err
+
+	legacy := len(remoteHello.Versions) == 0
+	if top, ok := remoteHello.Capabilities[protocol.MaxVersionCapability]; ok && legacy && slices.Max(opts.versions()) > protocol.Version1 {
+		return nil, fmt.Errorf("%w: client speaks version %s but sent a Version1 HELLO", protocol.ErrVersionDowngrade, top)
+	}
+	var payload []byte
+	if c := opts.HelloCache; c != nil && c.Matches(kp, opts) {
+		payload, err = c.serverHello(version, legacy)
+	} else {
+		payload, err = signServerHello(kp, opts.Capabilities, opts.versions(), version, legacy, clock.Or(opts.Clock).Now())
 	}
-	payload, err := protocol.EncodeHello(localHello)
 	if err != nil {
 		return nil, err
 	}
-	if err := protocol.WriteFrame(control, protocol.Frame{Type: protocol.MessageTypeHello, Payload: payload}); err != nil {
+	if err := opts.Interceptors.writeFrame(control, protocol.Frame{Type: protocol.MessageTypeHello, Payload: payload}); err != nil {
+		return nil, err
+	}
+
+	binding, err := channelBinding(conn)
+	if err != nil {
 		return nil, err
 	}
 
-	return &Session{
-		conn:         conn,
-		control:      control,
-		controlID:    control.StreamID(),
-		localPeerID:  kp.PeerID(),
-		remotePeerID: remoteID,
-		caps:         remoteHello.Capabilities,
-	}, nil
+	_ = control.SetDeadline(time.Time{})
+	s := newSession(conn, control, kp.PeerID(), remoteID, remoteHello.Capabilities, version, opts.Interceptors)
+	s.transcript = transcriptHash(frame.Payload, payload, version, binding)
+	s.streamProtocols = negotiateStreamProtocols(opts.Capabilities, remoteHello.Capabilities)
+	s.padding = crypto.NegotiatePaddingCapabilities(opts.Capabilities, remoteHello.Capabilities)
+	return s, nil
 }
@@ -2,10 +2,15 @@ package session
 
 import (
 	"context"
+	"errors"
+	"io"
 	"testing"
 	"time"
 
+	"github.com/TheusHen/I6P/i6p/clock"
 	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
 	"github.com/TheusHen/I6P/i6p/transport/quic"
 )
 
@@ -73,3 +78,164 @@ func TestHandshakeClientServer(t *testing.T) {
 		t.Fatalf("server expected client peerid")
 	}
 }
+
+func TestHandshakeOverMemoryTransport(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+
+	network := memory.NewNetwork()
+	ln, err := network.Listen("server")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	type result struct {
+		sess *Session
+		err  error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			resCh <- result{err: err}
+			return
+		}
+		sess, err := HandshakeServer(ctx, conn, serverKP, HandshakeOptions{})
+		resCh <- result{sess, err}
+	}()
+
+	conn, err := network.Dial(ctx, "server")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	clientSess, err := HandshakeClient(ctx, conn, clientKP, HandshakeOptions{})
+	if err != nil {
+		t.Fatalf("HandshakeClient: %v", err)
+	}
+	res := <-resCh
+	if res.err != nil {
+		t.Fatalf("server handshake: %v", res.err)
+	}
+	if clientSess.RemotePeerID() != serverKP.PeerID() || res.sess.RemotePeerID() != clientKP.PeerID() {
+		t.Fatalf("peer IDs not exchanged")
+	}
+
+	st, err := clientSess.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	_, _ = st.Write([]byte("data"))
+	_ = st.Close()
+	sst, err := res.sess.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := sst.Read(buf); err != nil || string(buf) != "data" {
+		t.Fatalf("Read = %q, %v", buf, err)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	kp, _ := identity.GenerateKeyPair()
+	network := memory.NewNetwork()
+	ln, err := network.Listen("silent")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	// The server accepts the connection but never answers the HELLO.
+	go func() {
+		conn, err := ln.Accept(ctx)
+		if err == nil {
+			st, err := conn.AcceptStream(ctx)
+			if err == nil {
+				_, _ = io.Copy(io.Discard, st)
+			}
+		}
+	}()
+	conn, err := network.Dial(ctx, "silent")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	start := time.Now()
+	_, err = HandshakeClient(ctx, conn, kp, HandshakeOptions{HandshakeTimeout: 50 * time.Millisecond})
+	if !errors.Is(err, ErrHandshakeTimeout) {
+		t.Fatalf("client err = %v, want ErrHandshakeTimeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("client gave up after %v", d)
+	}
+
+	// A client that opens the control stream and stays silent.
+	srvErr := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			srvErr <- err
+			return
+		}
+		_, err = HandshakeServer(ctx, conn, kp, HandshakeOptions{HandshakeTimeout: 50 * time.Millisecond})
+		srvErr <- err
+	}()
+	conn, err = network.Dial(ctx, "silent")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	st, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+	_, _ = st.Write([]byte{byte(protocol.MessageTypeHello)}) // a partial frame
+	if err := <-srvErr; !errors.Is(err, ErrHandshakeTimeout) {
+		t.Fatalf("server err = %v, want ErrHandshakeTimeout", err)
+	}
+}
+
+func TestHandshakeClockSkew(t *testing.T) {
+	for _, tc := range []struct {
+		skew time.Duration
+		ok   bool
+	}{{time.Minute, true}, {-time.Hour, false}} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		serverKP, _ := identity.GenerateKeyPair()
+		clientKP, _ := identity.GenerateKeyPair()
+		network := memory.NewNetwork()
+		ln, err := network.Listen("server")
+		if err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		go func() {
+			conn, err := network.Dial(ctx, "server")
+			if err != nil {
+				return
+			}
+			opts := HandshakeOptions{Clock: clock.NewFake(time.Now().Add(tc.skew))}
+			_, _ = HandshakeClient(ctx, conn, clientKP, opts)
+		}()
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+		_, err = HandshakeServer(ctx, conn, serverKP, HandshakeOptions{MaxClockSkew: 5 * time.Minute})
+		if tc.ok && err != nil {
+			t.Fatalf("skew %v: %v", tc.skew, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrClockSkew) {
+			t.Fatalf("skew %v: err = %v, want ErrClockSkew", tc.skew, err)
+		}
+		cancel()
+		_ = ln.Close()
+	}
+}
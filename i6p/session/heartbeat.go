@@ -0,0 +1,161 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/clock"
+	"github.com/TheusHen/I6P/i6p/protocol"
+)
+
+// Liveness is the health of a session as seen by its heartbeat.
+type Liveness int
+
+const (
+	LivenessAlive    Liveness = iota // the last probe was answered
+	LivenessDegraded                 // probes are being missed
+	LivenessDead                     // MissThreshold consecutive probes were missed
+)
+
+func (l Liveness) String() string {
+	switch l {
+	case LivenessAlive:
+		return "alive"
+	case LivenessDegraded:
+		return "degraded"
+	case LivenessDead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
+// HeartbeatConfig configures liveness probing. Probes are PING frames on the
+// control stream, so failures are detected well before QUIC's idle timeout.
+type HeartbeatConfig struct {
+	Interval      time.Duration // time between probes (default 5s)
+	Timeout       time.Duration // how long to wait for each PONG (default Interval)
+	MissThreshold int           // consecutive misses before the session is dead (default 3)
+	Clock         clock.Clock   // times probes and PONG waits (default clock.System)
+}
+
+func (c HeartbeatConfig) withDefaults() HeartbeatConfig {
+	if c.Interval <= 0 {
+		c.Interval = 5 * time.Second
+	}
+	if c.Timeout <= 0 || c.Timeout > c.Interval {
+		c.Timeout = c.Interval
+	}
+	if c.MissThreshold <= 0 {
+		c.MissThreshold = 3
+	}
+	c.Clock = clock.Or(c.Clock)
+	return c
+}
+
+// HeartbeatEvent reports a liveness change.
+type HeartbeatEvent struct {
+	Session *Session
+	State   Liveness
+	Misses  int           // consecutive missed probes
+	RTT     time.Duration // round-trip time of the last answered probe
+}
+
+// Heartbeat probes a session's liveness until it is stopped, the session dies,
+// or the session's connection closes.
+type Heartbeat struct {
+	s      *Session
+	cfg    HeartbeatConfig
+	events func(HeartbeatEvent)
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartHeartbeat begins probing the session. events, if non-nil, is called from
+// the heartbeat goroutine whenever the liveness state changes.
+func (s *Session) StartHeartbeat(cfg HeartbeatConfig, events func(HeartbeatEvent)) *Heartbeat {
+	ctx, cancel := context.WithCancel(s.conn.Context())
+	h := &Heartbeat{
+		s:      s,
+		cfg:    cfg.withDefaults(),
+		events: events,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go h.run(ctx)
+	return h
+}
+
+// Stop ends probing and waits for the heartbeat goroutine to exit.
+func (h *Heartbeat) Stop() {
+	h.cancel()
+	<-h.done
+}
+
+// Done is closed when the heartbeat stops, including when the session dies.
+func (h *Heartbeat) Done() <-chan struct{} { return h.done }
+
+func (h *Heartbeat) run(ctx context.Context) {
+	defer close(h.done)
+	ticker := h.cfg.Clock.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+
+	var seq uint64
+	state := LivenessAlive
+	misses := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		seq++
+		rtt, ok := h.probe(ctx, seq)
+		if ctx.Err() != nil {
+			return
+		}
+		next := LivenessAlive
+		if ok {
+			misses = 0
+		} else {
+			misses++
+			next = LivenessDegraded
+			if misses >= h.cfg.MissThreshold {
+				next = LivenessDead
+			}
+		}
+		if next != state {
+			state = next
+			if h.events != nil {
+				h.events(HeartbeatEvent{Session: h.s, State: state, Misses: misses, RTT: rtt})
+			}
+		}
+		if state == LivenessDead {
+			return
+		}
+	}
+}
+
+// probe sends one PING and waits for the matching PONG.
+func (h *Heartbeat) probe(ctx context.Context, seq uint64) (time.Duration, bool) {
+	start := h.cfg.Clock.Now()
+	if err := h.s.writeFrameTimeout(protocol.NewPingFrame(seq), h.cfg.Timeout); err != nil {
+		return 0, false
+	}
+	timer := h.cfg.Clock.NewTimer(h.cfg.Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case got := <-h.s.pongs:
+			if got == seq {
+				return h.cfg.Clock.Now().Sub(start), true
+			}
+			// A late PONG for an earlier probe; keep waiting.
+		case <-timer.C():
+			return 0, false
+		case <-ctx.Done():
+			return 0, false
+		}
+	}
+}
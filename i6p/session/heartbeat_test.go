@@ -0,0 +1,206 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/clock"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+// sessionPair runs a handshake over the in-memory transport.
+func sessionPair(t *testing.T) (client, server *Session) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+	network := memory.NewNetwork()
+	ln, err := network.Listen("")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	srvCh := make(chan *Session, 1)
+	go func() {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			srvCh <- nil
+			return
+		}
+		s, _ := HandshakeServer(ctx, conn, serverKP, HandshakeOptions{})
+		srvCh <- s
+	}()
+	conn, err := network.Dial(ctx, ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client, err = HandshakeClient(ctx, conn, clientKP, HandshakeOptions{})
+	if err != nil {
+		t.Fatalf("HandshakeClient: %v", err)
+	}
+	server = <-srvCh
+	if server == nil {
+		t.Fatalf("server handshake failed")
+	}
+	return client, server
+}
+
+// silence stops s from answering PINGs, as if the peer hung.
+func silence(s *Session) {
+	_ = s.control.SetReadDeadline(time.Now())
+	<-s.controlDone
+}
+
+func TestHeartbeatAlive(t *testing.T) {
+	client, _ := sessionPair(t)
+	events := make(chan HeartbeatEvent, 8)
+	hb := client.StartHeartbeat(HeartbeatConfig{Interval: 10 * time.Millisecond}, func(ev HeartbeatEvent) { events <- ev })
+	time.Sleep(80 * time.Millisecond)
+	hb.Stop()
+
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected liveness change: %v", ev.State)
+	default:
+	}
+}
+
+func TestHeartbeatDetectsDeadPeer(t *testing.T) {
+	client, server := sessionPair(t)
+	silence(server)
+
+	events := make(chan HeartbeatEvent, 8)
+	hb := client.StartHeartbeat(HeartbeatConfig{Interval: 10 * time.Millisecond, MissThreshold: 2}, func(ev HeartbeatEvent) { events <- ev })
+
+	select {
+	case <-hb.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("heartbeat did not give up")
+	}
+	if ev := <-events; ev.State != LivenessDegraded {
+		t.Fatalf("expected degraded first, got %v", ev.State)
+	}
+	if ev := <-events; ev.State != LivenessDead || ev.Misses != 2 {
+		t.Fatalf("expected dead after 2 misses, got %v/%d", ev.State, ev.Misses)
+	}
+}
+
+func TestManagerReconnectsDeadSession(t *testing.T) {
+	client, server := sessionPair(t)
+	replacement, _ := sessionPair(t)
+
+	reconnected := make(chan identity.PeerID, 1)
+	m := NewManager(ManagerConfig{
+		Heartbeat: HeartbeatConfig{Interval: 10 * time.Millisecond, MissThreshold: 2},
+		Reconnect: func(ctx context.Context, peer identity.PeerID) (*Session, error) {
+			reconnected <- peer
+			return replacement, nil
+		},
+	})
+	defer m.Close()
+
+	if err := m.Add(client); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	silence(server)
+
+	select {
+	case peer := <-reconnected:
+		if peer != client.RemotePeerID() {
+			t.Fatalf("reconnect for wrong peer")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no reconnect")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if s, ok := m.Get(replacement.RemotePeerID()); ok && s == replacement {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replacement session not managed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-client.Connection().Context().Done():
+	default:
+		t.Fatalf("dead session was not closed")
+	}
+}
+
+func TestGoAwayBlocksNewStreams(t *testing.T) {
+	client, server := sessionPair(t)
+	ctx := context.Background()
+
+	st, err := client.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	if client.ActiveStreams() != 1 {
+		t.Fatalf("expected 1 active stream, got %d", client.ActiveStreams())
+	}
+
+	if err := server.GoAway("restart"); err != nil {
+		t.Fatalf("GoAway: %v", err)
+	}
+	select {
+	case <-client.GoingAway():
+	case <-time.After(time.Second):
+		t.Fatalf("GOAWAY not received")
+	}
+	if client.GoAwayReason() != "restart" {
+		t.Fatalf("unexpected reason %q", client.GoAwayReason())
+	}
+	if _, err := client.OpenStream(ctx); err != ErrGoingAway {
+		t.Fatalf("expected ErrGoingAway, got %v", err)
+	}
+
+	// The existing stream still works and WaitIdle returns once it is closed.
+	if _, err := st.Write([]byte("x")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	idleErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		idleErr <- client.WaitIdle(ctx)
+	}()
+	_ = st.Close()
+	if err := <-idleErr; err != nil {
+		t.Fatalf("WaitIdle: %v", err)
+	}
+}
+
+func TestHeartbeatWithClock(t *testing.T) {
+	client, server := sessionPair(t)
+	silence(server)
+
+	fake := clock.NewFake(time.Now())
+	events := make(chan HeartbeatEvent, 8)
+	hb := client.StartHeartbeat(HeartbeatConfig{Interval: time.Hour, MissThreshold: 2, Clock: fake}, func(ev HeartbeatEvent) { events <- ev })
+	defer hb.Stop()
+
+	fake.BlockUntil(1) // the ticker
+	fake.Advance(time.Hour)
+	for _, want := range []Liveness{LivenessDegraded, LivenessDead} {
+		fake.BlockUntil(2) // the ticker and the PONG wait
+		fake.Advance(time.Hour)
+		select {
+		case ev := <-events:
+			if ev.State != want {
+				t.Fatalf("state = %v, want %v", ev.State, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %v event", want)
+		}
+	}
+	<-hb.Done()
+}
@@ -0,0 +1,107 @@
+package session
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/clock"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/protocol"
+)
+
+// DefaultHelloRefresh is how long a cached HELLO is reused before it is
+// signed again with a fresh timestamp and nonce.
+const DefaultHelloRefresh = time.Minute
+
+// HelloCache keeps signed, encoded server HELLOs so that HandshakeServer does
+// not sign and marshal one per connection. The server HELLO only depends on
+// the identity, capabilities, offered versions and the version selected for
+// the client, so one entry per selected version serves every connection.
+// The client HELLO carries a fresh nonce, so transcripts remain unique.
+//
+// A HelloCache is safe for concurrent use.
+type HelloCache struct {
+	kp       identity.KeyPair
+	caps     map[string]string
+	versions []uint8
+	refresh  time.Duration
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	entries map[uint8]cachedHello // by selected version; 0 is the Version1 HELLO
+}
+
+type cachedHello struct {
+	payload []byte
+	expires time.Time
+}
+
+// NewHelloCache returns a cache of HELLOs for kp and opts, re-signed every
+// refresh (DefaultHelloRefresh if zero) as measured by opts.Clock.
+func NewHelloCache(kp identity.KeyPair, opts HandshakeOptions, refresh time.Duration) *HelloCache {
+	if refresh <= 0 {
+		refresh = DefaultHelloRefresh
+	}
+	return &HelloCache{
+		kp:       kp,
+		caps:     maps.Clone(opts.Capabilities),
+		versions: slices.Clone(opts.versions()),
+		refresh:  refresh,
+		clock:    clock.Or(opts.Clock),
+		entries:  map[uint8]cachedHello{},
+	}
+}
+
+// Matches reports whether the cache holds HELLOs for kp and opts. Handshakes
+// ignore a cache that does not match.
+func (c *HelloCache) Matches(kp identity.KeyPair, opts HandshakeOptions) bool {
+	return c.kp.PeerID() == kp.PeerID() &&
+		maps.Equal(c.caps, opts.Capabilities) &&
+		slices.Equal(c.versions, opts.versions())
+}
+
+// serverHello returns the encoded server HELLO selecting version, or the
+// Version1 HELLO for a client that offered no versions.
+func (c *HelloCache) serverHello(version uint8, legacy bool) ([]byte, error) {
+	key := version
+	if legacy {
+		key = 0
+	}
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && now.Before(e.expires) {
+		return e.payload, nil
+	}
+	payload, err := signServerHello(c.kp, c.caps, c.versions, version, legacy, now)
+	if err != nil {
+		return nil, err
+	}
+	c.entries[key] = cachedHello{payload: payload, expires: now.Add(c.refresh)}
+	return payload, nil
+}
+
+// signServerHello builds, signs and encodes a server HELLO timestamped now.
+// A Version1 HELLO from a server that speaks later versions carries the
+// protocol.MaxVersionCapability sentinel.
+func signServerHello(kp identity.KeyPair, caps map[string]string, versions []uint8, version uint8, legacy bool, now time.Time) ([]byte, error) {
+	h, err := protocol.NewHello(kp, caps)
+	if err != nil {
+		return nil, err
+	}
+	h.TimestampSec = now.Unix()
+	h.Versions = nil
+	if !legacy {
+		h.Versions = slices.Clone(versions)
+		h.Version = version
+	} else if top := slices.Max(versions); top > protocol.Version1 {
+		h.Capabilities[protocol.MaxVersionCapability] = strconv.Itoa(int(top))
+	}
+	if err := h.Sign(kp); err != nil {
+		return nil, err
+	}
+	return protocol.EncodeHello(h)
+}
@@ -0,0 +1,51 @@
+package session
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/protocol"
+)
+
+func TestHelloCache(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	opts := HandshakeOptions{Capabilities: map[string]string{"role": "server"}}
+	c := NewHelloCache(kp, opts, time.Hour)
+
+	a, err := c.serverHello(protocol.Version2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := c.serverHello(protocol.Version2, false)
+	if !bytes.Equal(a, b) {
+		t.Fatal("cached HELLO was re-signed")
+	}
+	h, err := protocol.DecodeHello(a)
+	if err != nil || h.Verify() != nil || h.Version != protocol.Version2 || h.Capabilities["role"] != "server" {
+		t.Fatalf("cached HELLO = %+v, %v", h, err)
+	}
+	legacy, _ := c.serverHello(protocol.Version1, true)
+	if h, _ := protocol.DecodeHello(legacy); len(h.Versions) != 0 || h.Verify() != nil {
+		t.Fatalf("legacy HELLO = %+v", h)
+	}
+
+	if !c.Matches(kp, opts) {
+		t.Fatal("cache does not match its own options")
+	}
+	if c.Matches(kp, HandshakeOptions{Capabilities: map[string]string{"role": "other"}}) {
+		t.Fatal("cache matches other capabilities")
+	}
+	other, _ := identity.GenerateKeyPair()
+	if c.Matches(other, opts) {
+		t.Fatal("cache matches another identity")
+	}
+
+	short := NewHelloCache(kp, opts, time.Nanosecond)
+	a, _ = short.serverHello(protocol.Version2, false)
+	time.Sleep(time.Millisecond)
+	if b, _ = short.serverHello(protocol.Version2, false); bytes.Equal(a, b) {
+		t.Fatal("expired HELLO was reused")
+	}
+}
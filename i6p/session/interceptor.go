@@ -0,0 +1,79 @@
+package session
+
+import (
+	"context"
+
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// StreamDirection tells a StreamInterceptor how a stream came to be.
+type StreamDirection int
+
+const (
+	StreamOpened StreamDirection = iota
+	StreamAccepted
+)
+
+// StreamInterceptor runs for every application stream opened or accepted on a
+// session. It may return st, a wrapper around it (for metrics or fault
+// injection), or an error: an error fails OpenStream, while an accepted stream
+// is closed and AcceptStream keeps waiting.
+type StreamInterceptor func(ctx context.Context, dir StreamDirection, st transport.Stream) (transport.Stream, error)
+
+// FrameDirection tells a FrameInterceptor whether a frame is being read or written.
+type FrameDirection int
+
+const (
+	FrameRead FrameDirection = iota
+	FrameWrite
+)
+
+// FrameInterceptor runs for every frame on the control stream, including the
+// HELLO exchange. It may return f unchanged, a modified frame, or an error:
+// an error fails a write or the handshake, and drops a frame read after the
+// handshake.
+type FrameInterceptor func(dir FrameDirection, f protocol.Frame) (protocol.Frame, error)
+
+// Interceptors are applied in order: the first stream interceptor sees the
+// raw stream and the first frame interceptor sees the frame first.
+type Interceptors struct {
+	Stream []StreamInterceptor
+	Frame  []FrameInterceptor
+}
+
+func (ic Interceptors) stream(ctx context.Context, dir StreamDirection, st transport.Stream) (transport.Stream, error) {
+	for _, fn := range ic.Stream {
+		var err error
+		if st, err = fn(ctx, dir, st); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+func (ic Interceptors) frame(dir FrameDirection, f protocol.Frame) (protocol.Frame, error) {
+	for _, fn := range ic.Frame {
+		var err error
+		if f, err = fn(dir, f); err != nil {
+			return protocol.Frame{}, err
+		}
+	}
+	return f, nil
+}
+
+func (ic Interceptors) writeFrame(st transport.Stream, f protocol.Frame) error {
+	f, err := ic.frame(FrameWrite, f)
+	if err != nil {
+		return err
+	}
+	return protocol.WriteFrame(st, f)
+}
+
+func (ic Interceptors) readFrame(st transport.Stream) (protocol.Frame, error) {
+	f, err := protocol.ReadFrame(st)
+	if err != nil {
+		return protocol.Frame{}, err
+	}
+	return ic.frame(FrameRead, f)
+}
@@ -0,0 +1,148 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+var (
+	ErrManagerClosed = errors.New("session: manager closed")
+)
+
+// ManagerConfig configures a Manager.
+type ManagerConfig struct {
+	Heartbeat HeartbeatConfig
+
+	// OnEvent is called on every liveness change of a managed session.
+	OnEvent func(HeartbeatEvent)
+
+	// Reconnect, if set, is called when a session is declared dead. The
+	// returned session replaces the dead one. It runs on its own goroutine and
+	// should give up when ctx is cancelled (the manager was closed).
+	Reconnect func(ctx context.Context, peer identity.PeerID) (*Session, error)
+}
+
+// Manager tracks one session per remote peer, probes each with a heartbeat,
+// closes sessions that stop answering, and optionally re-establishes them.
+type Manager struct {
+	cfg    ManagerConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	sessions map[identity.PeerID]*managedSession
+	wg       sync.WaitGroup
+}
+
+type managedSession struct {
+	s  *Session
+	hb *Heartbeat
+}
+
+// NewManager creates a session manager.
+func NewManager(cfg ManagerConfig) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		cfg:      cfg,
+		ctx:      ctx,
+		cancel:   cancel,
+		sessions: make(map[identity.PeerID]*managedSession),
+	}
+}
+
+// Add starts managing s, replacing (and closing) any session to the same peer.
+func (m *Manager) Add(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx.Err() != nil {
+		return ErrManagerClosed
+	}
+	if old, ok := m.sessions[s.RemotePeerID()]; ok && old.s != s {
+		old.hb.cancel()
+		_ = old.s.CloseWithError(uint64(i6perrors.CodeReplaced), "replaced")
+	}
+	ms := &managedSession{s: s}
+	ms.hb = s.StartHeartbeat(m.cfg.Heartbeat, func(ev HeartbeatEvent) { m.onEvent(ms, ev) })
+	m.sessions[s.RemotePeerID()] = ms
+	return nil
+}
+
+// Get returns the current session to peer.
+func (m *Manager) Get(peer identity.PeerID) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ms, ok := m.sessions[peer]
+	if !ok {
+		return nil, false
+	}
+	return ms.s, true
+}
+
+// Remove stops managing the session to peer without closing it.
+func (m *Manager) Remove(peer identity.PeerID) {
+	m.mu.Lock()
+	ms, ok := m.sessions[peer]
+	delete(m.sessions, peer)
+	m.mu.Unlock()
+	if ok {
+		ms.hb.Stop()
+	}
+}
+
+// Len returns the number of managed sessions.
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+// Close stops all heartbeats and pending reconnects. Sessions are left open.
+func (m *Manager) Close() {
+	m.cancel()
+	m.mu.Lock()
+	sessions := m.sessions
+	m.sessions = make(map[identity.PeerID]*managedSession)
+	m.mu.Unlock()
+	for _, ms := range sessions {
+		ms.hb.Stop()
+	}
+	m.wg.Wait()
+}
+
+func (m *Manager) onEvent(ms *managedSession, ev HeartbeatEvent) {
+	if m.cfg.OnEvent != nil {
+		m.cfg.OnEvent(ev)
+	}
+	if ev.State != LivenessDead {
+		return
+	}
+
+	peer := ms.s.RemotePeerID()
+	_ = ms.s.CloseWithError(uint64(i6perrors.CodeTimeout), "heartbeat timeout")
+	m.mu.Lock()
+	if cur, ok := m.sessions[peer]; ok && cur == ms {
+		delete(m.sessions, peer)
+	}
+	reconnect := m.cfg.Reconnect != nil && m.ctx.Err() == nil
+	if reconnect {
+		m.wg.Add(1)
+	}
+	m.mu.Unlock()
+
+	if reconnect {
+		go func() {
+			defer m.wg.Done()
+			s, err := m.cfg.Reconnect(m.ctx, peer)
+			if err != nil {
+				return
+			}
+			if err := m.Add(s); err != nil {
+				_ = s.CloseWithError(0, "manager closed")
+			}
+		}()
+	}
+}
@@ -0,0 +1,83 @@
+package session
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// Addr is the address of one end of an I6P stream: the peer's identity and
+// its transport address.
+type Addr struct {
+	PeerID identity.PeerID
+	Addr   net.Addr // transport address; may be nil
+}
+
+func (a Addr) Network() string { return "i6p" }
+
+// String returns "<peer id hex>@<transport address>".
+func (a Addr) String() string {
+	if a.Addr == nil {
+		return a.PeerID.String()
+	}
+	return a.PeerID.String() + "@" + a.Addr.String()
+}
+
+// StreamConn adapts a stream of s to net.Conn, so code written for net.Conn
+// (HTTP, gRPC, SSH servers and clients) can run over I6P. Local and remote
+// addresses are Addr values carrying the peers' identities.
+//
+// Close ends the stream in both directions as far as the caller is concerned:
+// it sends FIN and makes further reads fail with net.ErrClosed.
+func (s *Session) StreamConn(st transport.Stream) net.Conn {
+	return &streamConn{
+		Stream: st,
+		local:  Addr{PeerID: s.localPeerID, Addr: s.conn.LocalAddr()},
+		remote: Addr{PeerID: s.remotePeerID, Addr: s.conn.RemoteAddr()},
+	}
+}
+
+// aLongTimeAgo is a read deadline that unblocks pending reads immediately.
+var aLongTimeAgo = time.Unix(1, 0)
+
+type streamConn struct {
+	transport.Stream
+	local, remote Addr
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	return c.Stream.Read(b)
+}
+
+func (c *streamConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+	err := c.Stream.Close()
+	// Unblock a Read in progress.
+	_ = c.Stream.SetReadDeadline(aLongTimeAgo)
+	return err
+}
+
+// CloseWrite sends FIN and leaves the conn readable, like
+// (*net.TCPConn).CloseWrite.
+func (c *streamConn) CloseWrite() error { return c.Stream.Close() }
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.local }
+func (c *streamConn) RemoteAddr() net.Addr { return c.remote }
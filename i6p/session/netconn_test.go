@@ -0,0 +1,70 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStreamConn(t *testing.T) {
+	client, server := sessionPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cst, err := client.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	cc := client.StreamConn(cst)
+	if _, err := cc.Write([]byte("hi")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	sst, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	sc := server.StreamConn(sst)
+
+	remote, ok := sc.RemoteAddr().(Addr)
+	if !ok || remote.PeerID != client.LocalPeerID() || remote.Network() != "i6p" {
+		t.Fatalf("unexpected remote address %v", sc.RemoteAddr())
+	}
+	if cc.LocalAddr().String() != sc.RemoteAddr().String() {
+		t.Fatalf("address mismatch: %v vs %v", cc.LocalAddr(), sc.RemoteAddr())
+	}
+
+	_ = sc.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(sc, buf); err != nil || string(buf) != "hi" {
+		t.Fatalf("Read: %q %v", buf, err)
+	}
+
+	// A pending Read is released by Close.
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := cc.Read(buf)
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_ = cc.Close()
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Fatalf("Read succeeded after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Close did not unblock Read")
+	}
+	if _, err := cc.Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Read after Close: %v", err)
+	}
+	if _, err := io.ReadAll(sc); err != nil {
+		t.Fatalf("server did not see FIN: %v", err)
+	}
+	if client.ActiveStreams() != 0 {
+		t.Fatalf("closed conn still counted as active")
+	}
+}
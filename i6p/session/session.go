@@ -2,28 +2,66 @@ package session
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 
+	"github.com/TheusHen/I6P/i6p/crypto"
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
 	"github.com/TheusHen/I6P/i6p/identity"
-	q "github.com/quic-go/quic-go"
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/transport"
 )
 
-// Session is an authenticated I6P session over a QUIC connection.
-// The QUIC connection provides encryption; identity is bound via the signed HELLO exchange.
+// ErrClosed is the CloseReason of a session closed with CloseWithError.
+var ErrClosed = errors.New("session: closed")
+
+// Session is an authenticated I6P session over a transport connection (QUIC in production).
+// The transport provides encryption; identity is bound via the signed HELLO exchange.
 type Session struct {
-	conn         *q.Conn
-	control      *q.Stream
-	controlID    q.StreamID
+	conn         transport.Conn
+	control      transport.Stream
 	localPeerID  identity.PeerID
 	remotePeerID identity.PeerID
 	caps         map[string]string
+	version      uint8    // negotiated protocol version
+	transcript   [32]byte // hash of the handshake, see TranscriptHash
+	ic           Interceptors
+	ctx          context.Context // canceled when the session ends, see Context
+	cancel       context.CancelCauseFunc
+
+	streamProtocols bool                 // both peers send stream protocol headers
+	padding         crypto.PaddingPolicy // negotiated from both HELLOs, see Padding
+	initiator       bool                 // the local end ran HandshakeClient
+	accept          acceptor
+
+	frames      *frameQueue // frames waiting for the control stream writer
+	pongs       chan uint64 // PONG sequences for the heartbeat
+	times       chan timeReply
+	clock       clockState
+	controlDone chan struct{} // closed when the control loop exits
+
+	mu           sync.Mutex
+	active       int           // application streams not yet closed locally
+	idle         chan struct{} // closed while active == 0
+	goAwaySent   bool
+	goAwayRecv   chan struct{} // closed when the peer sends GOAWAY
+	goAwayReason string
+	handlers     map[protocol.MessageType]FrameHandler
+	traffic      map[string]*protocolCounters
+	budget       streamBudget
+	replaySafe   map[string]bool // protocols that may be sent as early data
 }
 
-func (s *Session) Connection() *q.Conn { return s.conn }
+func (s *Session) Connection() transport.Conn { return s.conn }
 
 func (s *Session) LocalPeerID() identity.PeerID { return s.localPeerID }
 
 func (s *Session) RemotePeerID() identity.PeerID { return s.remotePeerID }
 
+// Version returns the protocol version negotiated in the handshake.
+func (s *Session) Version() uint8 { return s.version }
+
 func (s *Session) RemoteCapabilities() map[string]string {
 	out := map[string]string{}
 	for k, v := range s.caps {
@@ -32,26 +70,36 @@ func (s *Session) RemoteCapabilities() map[string]string {
 	return out
 }
 
-// OpenStream opens an application data stream.
-func (s *Session) OpenStream(ctx context.Context) (*q.Stream, error) {
-	return s.conn.OpenStreamSync(ctx)
+// OpenStream opens an application data stream without a protocol name.
+// It waits while the budget set by SetMaxOpenStreams or the peer's stream
+// limit is used up, and fails with ErrGoingAway once the peer has sent GOAWAY.
+// Before the handshake is confirmed it waits unless "" was marked replay-safe
+// with MarkReplaySafe.
+func (s *Session) OpenStream(ctx context.Context) (transport.Stream, error) {
+	return s.OpenProtocolStream(ctx, "")
 }
 
-// AcceptStream accepts an application data stream, skipping the control stream.
-func (s *Session) AcceptStream(ctx context.Context) (*q.Stream, error) {
-	for {
-		st, err := s.conn.AcceptStream(ctx)
-		if err != nil {
-			return nil, err
-		}
-		if st.StreamID() == s.controlID {
-			_ = st.Close()
-			continue
-		}
+// AcceptStream accepts an application data stream that no ProtocolHandler
+// took, skipping the control stream, streams rejected by a stream
+// interceptor and, when the session negotiated stream protocols, streams
+// without a valid header. StreamProtocol reports the protocol of the
+// returned stream.
+func (s *Session) AcceptStream(ctx context.Context) (transport.Stream, error) {
+	s.startAccepting()
+	select {
+	case st := <-s.accept.streams:
 		return st, nil
+	case <-s.accept.done:
+		return nil, s.accept.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
-func (s *Session) CloseWithError(code q.ApplicationErrorCode, msg string) error {
+// CloseWithError closes the connection. CloseReason then reports an error
+// wrapping ErrClosed with code and msg. Codes should come from package
+// i6perrors, so the peer can tell why.
+func (s *Session) CloseWithError(code uint64, msg string) error {
+	s.cancel(&i6perrors.Error{Code: i6perrors.Code(code), Err: fmt.Errorf("%w locally (code %d: %s)", ErrClosed, code, msg)})
 	return s.conn.CloseWithError(code, msg)
 }
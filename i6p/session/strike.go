@@ -0,0 +1,50 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/clock"
+)
+
+// StrikeRegister remembers which single-use tickets were redeemed. Stores
+// that share a ticket key across a cluster must share one register, or a
+// ticket could be redeemed once per node.
+type StrikeRegister interface {
+	// Strike records ticket id as redeemed until expiresAt, when the ticket
+	// expires anyway, and reports whether it had not been redeemed before.
+	Strike(id [16]byte, expiresAt time.Time) bool
+}
+
+// NewStrikeRegister returns an in-memory register that forgets entries once
+// their ticket expires by c (clock.System if nil).
+func NewStrikeRegister(c clock.Clock) StrikeRegister {
+	return &memoryStrikes{clock: clock.Or(c), strikes: map[[16]byte]time.Time{}}
+}
+
+type memoryStrikes struct {
+	clock clock.Clock
+
+	mu      sync.Mutex
+	strikes map[[16]byte]time.Time
+	limit   int // size at which expired entries are swept next
+}
+
+func (m *memoryStrikes) Strike(id [16]byte, expiresAt time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clock.Now()
+	if at, ok := m.strikes[id]; ok && !now.After(at) {
+		return false
+	}
+	m.strikes[id] = expiresAt
+	if len(m.strikes) > m.limit {
+		for k, at := range m.strikes {
+			if now.After(at) {
+				delete(m.strikes, k)
+			}
+		}
+		m.limit = max(64, 2*len(m.strikes))
+	}
+	return true
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/TheusHen/I6P/i6p/clock"
 	"github.com/TheusHen/I6P/i6p/crypto"
 	"github.com/TheusHen/I6P/i6p/identity"
 )
@@ -15,12 +16,31 @@ var (
 	ErrTicketExpired  = errors.New("session: ticket expired")
 	ErrTicketInvalid  = errors.New("session: ticket invalid")
 	ErrTicketNotFound = errors.New("session: ticket not found")
+	ErrTicketRedeemed = errors.New("session: single-use ticket already redeemed")
+	ErrTicketAudience = errors.New("session: ticket presented to the wrong audience")
+	ErrTicketRevoked  = errors.New("session: ticket revoked")
 )
 
 const (
 	TicketKeySize   = 32
 	TicketNonceSize = 16
-	TicketLifetime  = 24 * time.Hour
+	// TicketLifetime is the lifetime of tickets from a store without
+	// SetLifetime.
+	TicketLifetime = 24 * time.Hour
+)
+
+// Encoded ticket layout: ID (16) || issuedAt (8) || expiresAt (8) || AEAD
+// nonce, ciphertext and tag, with the first 32 bytes as associated data.
+// Tickets with options append flags (1) || audience length (1) || audience
+// to the plaintext.
+// Clients read the times in the clear to schedule renewal; the AEAD keeps
+// them authentic. Tickets encoded before the header existed, without the
+// times, are exactly legacyTicketSize bytes and still decode.
+const (
+	ticketHeaderSize = 32
+	ticketPlainSize  = 80 // peerID (32) + issuedAt (8) + expiresAt (8) + sessionKey (32)
+	ticketSealedSize = 12 + ticketPlainSize + 16
+	legacyTicketSize = 16 + ticketSealedSize
 )
 
 // Ticket enables fast session resumption without full handshake.
@@ -31,19 +51,46 @@ type Ticket struct {
 	ExpiresAt  int64
 	PeerID     identity.PeerID
 	SessionKey [32]byte // pre-shared key for resumed session
+	TicketOptions
+}
+
+// TicketOptions restrict how a ticket can be redeemed. They are sealed in the
+// ticket, so clients cannot change them.
+type TicketOptions struct {
+	// SingleUse tickets can be redeemed once; the store's StrikeRegister
+	// refuses them afterwards.
+	SingleUse bool
+	// Audience, if set, is the listener address or cluster ID the ticket is
+	// valid for, at most 255 bytes. Redeem refuses it elsewhere.
+	Audience string
+}
+
+const ticketFlagSingleUse = 1
+
+// RenewAt returns when half of t's lifetime has passed, after which a
+// resumption with t gets a fresh ticket.
+func (t *Ticket) RenewAt() time.Time {
+	return time.Unix(t.IssuedAt+(t.ExpiresAt-t.IssuedAt)/2, 0)
 }
 
 // TicketStore manages session tickets for resumption.
 type TicketStore struct {
-	mu      sync.RWMutex
-	tickets map[[16]byte]*Ticket
-	key     [TicketKeySize]byte // encryption key for ticket data
+	mu       sync.RWMutex
+	tickets  map[[16]byte]*Ticket
+	revoked  map[[16]byte]int64  // revoked ticket IDs, by expiry
+	limit    int                 // entries at which expired ones are swept next
+	key      [TicketKeySize]byte // encryption key for ticket data
+	prevKey  *[TicketKeySize]byte
+	clock    clock.Clock
+	lifetime time.Duration
+	strikes  StrikeRegister
 }
 
 // NewTicketStore creates a new ticket store.
 func NewTicketStore() (*TicketStore, error) {
 	ts := &TicketStore{
 		tickets: make(map[[16]byte]*Ticket),
+		revoked: make(map[[16]byte]int64),
 	}
 	if _, err := rand.Read(ts.key[:]); err != nil {
 		return nil, err
@@ -55,27 +102,87 @@ func NewTicketStore() (*TicketStore, error) {
 func NewTicketStoreWithKey(key [TicketKeySize]byte) *TicketStore {
 	return &TicketStore{
 		tickets: make(map[[16]byte]*Ticket),
+		revoked: make(map[[16]byte]int64),
 		key:     key,
 	}
 }
 
+// SetClock sets the clock tickets are issued and expired by (clock.System
+// if nil). Must be called before the store is used.
+func (ts *TicketStore) SetClock(c clock.Clock) {
+	ts.clock = c
+}
+
+func (ts *TicketStore) now() time.Time {
+	return clock.Or(ts.clock).Now()
+}
+
+// SetLifetime sets how long new tickets are valid (TicketLifetime if zero).
+// Tickets already issued keep their expiry.
+func (ts *TicketStore) SetLifetime(d time.Duration) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.lifetime = d
+}
+
+// Lifetime returns how long new tickets are valid.
+func (ts *TicketStore) Lifetime() time.Duration {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	if ts.lifetime <= 0 {
+		return TicketLifetime
+	}
+	return ts.lifetime
+}
+
+// RotateKey replaces the ticket encryption key at runtime. New tickets are
+// encrypted with key; tickets encrypted with the previous key still decode
+// until the next rotation, so clients holding them can resume.
+func (ts *TicketStore) RotateKey(key [TicketKeySize]byte) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	prev := ts.key
+	ts.prevKey = &prev
+	ts.key = key
+}
+
+// SetStrikeRegister sets the register single-use tickets are struck in. By
+// default each store has an in-memory one. Must be called before the store
+// is used.
+func (ts *TicketStore) SetStrikeRegister(r StrikeRegister) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.strikes = r
+}
+
 // Issue creates a new ticket for the given peer and session key.
 func (ts *TicketStore) Issue(peerID identity.PeerID, sessionKey [32]byte) (*Ticket, error) {
+	return ts.IssueWithOptions(peerID, sessionKey, TicketOptions{})
+}
+
+// IssueWithOptions creates a new ticket restricted by opts.
+func (ts *TicketStore) IssueWithOptions(peerID identity.PeerID, sessionKey [32]byte, opts TicketOptions) (*Ticket, error) {
+	if len(opts.Audience) > 255 {
+		return nil, ErrTicketInvalid
+	}
+	lifetime := ts.Lifetime()
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
-	now := time.Now()
+	now := ts.now()
 	ticket := &Ticket{
-		IssuedAt:   now.Unix(),
-		ExpiresAt:  now.Add(TicketLifetime).Unix(),
-		PeerID:     peerID,
-		SessionKey: sessionKey,
+		IssuedAt:      now.Unix(),
+		ExpiresAt:     now.Add(lifetime).Unix(),
+		PeerID:        peerID,
+		SessionKey:    sessionKey,
+		TicketOptions: opts,
 	}
 	if _, err := rand.Read(ticket.ID[:]); err != nil {
 		return nil, err
 	}
 
 	ts.tickets[ticket.ID] = ticket
+	ts.sweepLocked()
 	return ticket, nil
 }
 
@@ -89,26 +196,43 @@ func (ts *TicketStore) Lookup(ticketID [16]byte) (*Ticket, error) {
 		return nil, ErrTicketNotFound
 	}
 
-	if time.Now().Unix() > ticket.ExpiresAt {
+	if ts.now().Unix() > ticket.ExpiresAt {
 		return nil, ErrTicketExpired
 	}
 
 	return ticket, nil
 }
 
-// Revoke invalidates a ticket.
+// Revoke invalidates a ticket: Redeem refuses it until it expires, or for a
+// lifetime from now if this store did not issue it. Unlike the strikes of
+// single-use tickets, revocations are kept by this store only.
 func (ts *TicketStore) Revoke(ticketID [16]byte) {
+	lifetime := ts.Lifetime()
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
-	delete(ts.tickets, ticketID)
+	expiresAt := ts.now().Add(lifetime).Unix()
+	if t, ok := ts.tickets[ticketID]; ok {
+		expiresAt = t.ExpiresAt
+	}
+	ts.revokeLocked(ticketID, expiresAt)
 }
 
-// Cleanup removes expired tickets.
+func (ts *TicketStore) revokeLocked(id [16]byte, expiresAt int64) {
+	delete(ts.tickets, id)
+	ts.revoked[id] = expiresAt
+	ts.sweepLocked()
+}
+
+// Cleanup removes expired tickets and revocations, returning the number of
+// tickets removed. Issue and Revoke also sweep as the store grows.
 func (ts *TicketStore) Cleanup() int {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
+	return ts.cleanupLocked()
+}
 
-	now := time.Now().Unix()
+func (ts *TicketStore) cleanupLocked() int {
+	now := ts.now().Unix()
 	removed := 0
 	for id, ticket := range ts.tickets {
 		if now > ticket.ExpiresAt {
@@ -116,71 +240,197 @@ func (ts *TicketStore) Cleanup() int {
 			removed++
 		}
 	}
+	for id, expiresAt := range ts.revoked {
+		if now > expiresAt {
+			delete(ts.revoked, id)
+		}
+	}
 	return removed
 }
 
+// sweepLocked runs cleanupLocked whenever the store has doubled since the
+// last sweep, so stores nobody calls Cleanup on stay bounded by the tickets
+// that have not expired.
+func (ts *TicketStore) sweepLocked() {
+	if len(ts.tickets)+len(ts.revoked) <= ts.limit {
+		return
+	}
+	ts.cleanupLocked()
+	ts.limit = max(64, 2*(len(ts.tickets)+len(ts.revoked)))
+}
+
 // EncodeTicket encrypts a ticket for wire transmission.
-// Format: ticketID (16) || nonce (16) || encrypted data
+// Format: ticketID (16) || issuedAt (8) || expiresAt (8) || nonce (12) || encrypted data
 func (ts *TicketStore) EncodeTicket(ticket *Ticket) ([]byte, error) {
-	// Serialize ticket data
-	// peerID (32) + issuedAt (8) + expiresAt (8) + sessionKey (32) = 80 bytes
-	plain := make([]byte, 80)
+	if len(ticket.Audience) > 255 {
+		return nil, ErrTicketInvalid
+	}
+	plain := make([]byte, ticketPlainSize, ticketPlainSize+2+len(ticket.Audience))
 	copy(plain[0:32], ticket.PeerID[:])
 	binary.BigEndian.PutUint64(plain[32:40], uint64(ticket.IssuedAt))
 	binary.BigEndian.PutUint64(plain[40:48], uint64(ticket.ExpiresAt))
 	copy(plain[48:80], ticket.SessionKey[:])
+	if ticket.TicketOptions != (TicketOptions{}) {
+		var flags byte
+		if ticket.SingleUse {
+			flags |= ticketFlagSingleUse
+		}
+		plain = append(plain, flags, byte(len(ticket.Audience)))
+		plain = append(plain, ticket.Audience...)
+	}
 
-	aead, err := crypto.NewAEAD(ts.key[:])
+	ts.mu.RLock()
+	key := ts.key
+	ts.mu.RUnlock()
+	aead, err := crypto.NewAEAD(key[:])
 	if err != nil {
 		return nil, err
 	}
 
-	ciphertext := aead.Seal(plain, ticket.ID[:])
-
-	out := make([]byte, 16+len(ciphertext))
-	copy(out[:16], ticket.ID[:])
-	copy(out[16:], ciphertext)
+	header := make([]byte, ticketHeaderSize, ticketHeaderSize+ticketSealedSize+len(plain)-ticketPlainSize)
+	copy(header[:16], ticket.ID[:])
+	binary.BigEndian.PutUint64(header[16:24], uint64(ticket.IssuedAt))
+	binary.BigEndian.PutUint64(header[24:32], uint64(ticket.ExpiresAt))
+	return append(header, aead.Seal(plain, header)...), nil
+}
 
-	return out, nil
+// PeekTicket reads the ID, issue and expiry times of an encoded ticket
+// without the store key, so a client can schedule renewal (see
+// Ticket.RenewAt). The times are only authenticated when the issuer decodes
+// the ticket.
+func PeekTicket(data []byte) (*Ticket, error) {
+	if len(data) < ticketHeaderSize+ticketSealedSize || len(data) == legacyTicketSize {
+		return nil, ErrTicketInvalid
+	}
+	ticket := &Ticket{
+		IssuedAt:  int64(binary.BigEndian.Uint64(data[16:24])),
+		ExpiresAt: int64(binary.BigEndian.Uint64(data[24:32])),
+	}
+	copy(ticket.ID[:], data[:16])
+	return ticket, nil
 }
 
 // DecodeTicket decrypts and validates a ticket from wire format.
 func (ts *TicketStore) DecodeTicket(data []byte) (*Ticket, error) {
-	if len(data) < 16+12+16+80 { // id + nonce + tag + data
+	var ad, sealed []byte
+	switch {
+	case len(data) == legacyTicketSize:
+		ad, sealed = data[:16], data[16:]
+	case len(data) >= ticketHeaderSize+ticketSealedSize:
+		ad, sealed = data[:ticketHeaderSize], data[ticketHeaderSize:]
+	default:
 		return nil, ErrTicketInvalid
 	}
 
-	var ticketID [16]byte
-	copy(ticketID[:], data[:16])
-	ciphertext := data[16:]
-
-	aead, err := crypto.NewAEAD(ts.key[:])
-	if err != nil {
-		return nil, err
+	ts.mu.RLock()
+	keys := [][TicketKeySize]byte{ts.key}
+	if ts.prevKey != nil {
+		keys = append(keys, *ts.prevKey)
 	}
+	ts.mu.RUnlock()
 
-	plain, err := aead.Open(ciphertext, ticketID[:])
-	if err != nil {
-		return nil, ErrTicketInvalid
+	var plain []byte
+	for _, key := range keys {
+		aead, err := crypto.NewAEAD(key[:])
+		if err != nil {
+			return nil, err
+		}
+		if plain, err = aead.Open(sealed, ad); err == nil {
+			break
+		}
 	}
-
-	if len(plain) != 80 {
+	if plain == nil || len(plain) < ticketPlainSize {
 		return nil, ErrTicketInvalid
 	}
 
-	ticket := &Ticket{ID: ticketID}
+	ticket := &Ticket{}
+	copy(ticket.ID[:], data[:16])
 	copy(ticket.PeerID[:], plain[0:32])
 	ticket.IssuedAt = int64(binary.BigEndian.Uint64(plain[32:40]))
 	ticket.ExpiresAt = int64(binary.BigEndian.Uint64(plain[40:48]))
 	copy(ticket.SessionKey[:], plain[48:80])
+	if opts := plain[ticketPlainSize:]; len(opts) > 0 {
+		if len(opts) < 2 || len(opts) != 2+int(opts[1]) || opts[0]&^ticketFlagSingleUse != 0 {
+			return nil, ErrTicketInvalid
+		}
+		ticket.SingleUse = opts[0]&ticketFlagSingleUse != 0
+		ticket.Audience = string(opts[2:])
+	}
 
-	if time.Now().Unix() > ticket.ExpiresAt {
+	if ts.now().Unix() > ticket.ExpiresAt {
 		return nil, ErrTicketExpired
 	}
 
 	return ticket, nil
 }
 
+// Redeem decodes a ticket presented to audience, the listener address or
+// cluster ID of this server, and enforces its options: a ticket for another
+// audience fails with ErrTicketAudience, a single-use ticket redeemed
+// before with ErrTicketRedeemed, and a revoked one with ErrTicketRevoked.
+// DecodeTicket only decrypts.
+func (ts *TicketStore) Redeem(data []byte, audience string) (*Ticket, error) {
+	ticket, err := ts.DecodeTicket(data)
+	if err != nil {
+		return nil, err
+	}
+	ts.mu.RLock()
+	_, revoked := ts.revoked[ticket.ID]
+	ts.mu.RUnlock()
+	if revoked {
+		return nil, ErrTicketRevoked
+	}
+	if ticket.Audience != "" && ticket.Audience != audience {
+		return nil, ErrTicketAudience
+	}
+	if ticket.SingleUse && !ts.strikeRegister().Strike(ticket.ID, time.Unix(ticket.ExpiresAt, 0)) {
+		return nil, ErrTicketRedeemed
+	}
+	return ticket, nil
+}
+
+func (ts *TicketStore) strikeRegister() StrikeRegister {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.strikes == nil {
+		ts.strikes = NewStrikeRegister(ts.clock)
+	}
+	return ts.strikes
+}
+
+// Resume redeems the ticket a client resumes with at audience. Once more
+// than half of its lifetime has passed, or always for a single-use ticket,
+// it also issues a fresh ticket with the same options for the same peer
+// under sessionKey, the key of the resumed session, and revokes the old
+// one; renewed is the fresh ticket encoded, or nil if none was due.
+func (ts *TicketStore) Resume(data []byte, audience string, sessionKey [32]byte) (ticket *Ticket, renewed []byte, err error) {
+	ticket, err = ts.Redeem(data, audience)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ticket.SingleUse && ts.now().Before(ticket.RenewAt()) {
+		return ticket, nil, nil
+	}
+	// Revoke before issuing, so that of two resumptions racing with one
+	// ticket only the first is renewed.
+	ts.mu.Lock()
+	if _, ok := ts.revoked[ticket.ID]; ok {
+		ts.mu.Unlock()
+		return nil, nil, ErrTicketRevoked
+	}
+	ts.revokeLocked(ticket.ID, ticket.ExpiresAt)
+	ts.mu.Unlock()
+
+	fresh, err := ts.IssueWithOptions(ticket.PeerID, sessionKey, ticket.TicketOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	if renewed, err = ts.EncodeTicket(fresh); err != nil {
+		return nil, nil, err
+	}
+	return ticket, renewed, nil
+}
+
 // Count returns the number of active tickets.
 func (ts *TicketStore) Count() int {
 	ts.mu.RLock()
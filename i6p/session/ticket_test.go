@@ -1,9 +1,12 @@
 package session
 
 import (
+	"encoding/binary"
 	"testing"
 	"time"
 
+	"github.com/TheusHen/I6P/i6p/clock"
+	"github.com/TheusHen/I6P/i6p/crypto"
 	"github.com/TheusHen/I6P/i6p/identity"
 )
 
@@ -65,6 +68,30 @@ func TestTicketEncodeDeccode(t *testing.T) {
 	}
 }
 
+func TestTicketKeyRotation(t *testing.T) {
+	store, _ := NewTicketStore()
+	kp, _ := identity.GenerateKeyPair()
+	var sessionKey [32]byte
+
+	old, _ := store.Issue(kp.PeerID(), sessionKey)
+	oldEncoded, _ := store.EncodeTicket(old)
+
+	store.RotateKey([TicketKeySize]byte{1})
+	if _, err := store.DecodeTicket(oldEncoded); err != nil {
+		t.Fatalf("ticket from previous key rejected: %v", err)
+	}
+	fresh, _ := store.Issue(kp.PeerID(), sessionKey)
+	freshEncoded, _ := store.EncodeTicket(fresh)
+	if _, err := store.DecodeTicket(freshEncoded); err != nil {
+		t.Fatalf("DecodeTicket: %v", err)
+	}
+
+	store.RotateKey([TicketKeySize]byte{2})
+	if _, err := store.DecodeTicket(oldEncoded); err != ErrTicketInvalid {
+		t.Fatalf("ticket from retired key accepted: %v", err)
+	}
+}
+
 func TestTicketExpiration(t *testing.T) {
 	store, _ := NewTicketStore()
 	kp, _ := identity.GenerateKeyPair()
@@ -81,6 +108,173 @@ func TestTicketExpiration(t *testing.T) {
 	}
 }
 
+func TestTicketExpirationWithClock(t *testing.T) {
+	store, _ := NewTicketStore()
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	store.SetClock(fake)
+	kp, _ := identity.GenerateKeyPair()
+	var sessionKey [32]byte
+
+	ticket, _ := store.Issue(kp.PeerID(), sessionKey)
+	encoded, _ := store.EncodeTicket(ticket)
+	fake.Advance(TicketLifetime)
+	if _, err := store.Lookup(ticket.ID); err != nil {
+		t.Fatalf("Lookup at expiry: %v", err)
+	}
+	fake.Advance(time.Second)
+	if _, err := store.Lookup(ticket.ID); err != ErrTicketExpired {
+		t.Fatalf("Lookup after expiry: %v", err)
+	}
+	if _, err := store.DecodeTicket(encoded); err != ErrTicketExpired {
+		t.Fatalf("DecodeTicket after expiry: %v", err)
+	}
+	if n := store.Cleanup(); n != 1 {
+		t.Fatalf("Cleanup removed %d, want 1", n)
+	}
+}
+
+func TestTicketLifetimeAndRenewal(t *testing.T) {
+	store, _ := NewTicketStore()
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	store.SetClock(fake)
+	store.SetLifetime(time.Hour)
+	kp, _ := identity.GenerateKeyPair()
+
+	ticket, _ := store.Issue(kp.PeerID(), [32]byte{1})
+	encoded, _ := store.EncodeTicket(ticket)
+	peeked, err := PeekTicket(encoded)
+	if err != nil || peeked.ID != ticket.ID || peeked.ExpiresAt-peeked.IssuedAt != 3600 {
+		t.Fatalf("PeekTicket = %+v, %v", peeked, err)
+	}
+	if !peeked.RenewAt().Equal(fake.Now().Add(30 * time.Minute)) {
+		t.Fatalf("RenewAt = %v", peeked.RenewAt())
+	}
+
+	fake.Advance(29 * time.Minute)
+	if _, renewed, err := store.Resume(encoded, "", [32]byte{2}); err != nil || renewed != nil {
+		t.Fatalf("early Resume renewed = %v, %v", renewed != nil, err)
+	}
+	fake.Advance(time.Minute)
+	got, renewed, err := store.Resume(encoded, "", [32]byte{2})
+	if err != nil || got.SessionKey != [32]byte{1} || renewed == nil {
+		t.Fatalf("Resume = %+v, renewed %v, %v", got, renewed != nil, err)
+	}
+	if _, err := store.Lookup(ticket.ID); err != ErrTicketNotFound {
+		t.Fatalf("renewed ticket still stored: %v", err)
+	}
+	fresh, err := store.DecodeTicket(renewed)
+	if err != nil || fresh.SessionKey != [32]byte{2} || fresh.PeerID != kp.PeerID() {
+		t.Fatalf("renewed ticket = %+v, %v", fresh, err)
+	}
+	if fresh.ExpiresAt != fake.Now().Add(time.Hour).Unix() {
+		t.Fatalf("renewed ticket expires at %d", fresh.ExpiresAt)
+	}
+
+	// The ticket renewed away no longer resumes; its successor does.
+	if _, _, err := store.Resume(encoded, "", [32]byte{3}); err != ErrTicketRevoked {
+		t.Fatalf("Resume with the old ticket: %v", err)
+	}
+	if _, renewedAgain, err := store.Resume(renewed, "", [32]byte{3}); err != nil || renewedAgain != nil {
+		t.Fatalf("Resume with the renewed ticket: renewed %v, %v", renewedAgain != nil, err)
+	}
+
+	// Expired tickets and revocations are swept without Cleanup.
+	fake.Advance(2 * time.Hour)
+	for range 100 {
+		_, _ = store.Issue(kp.PeerID(), [32]byte{})
+	}
+	if n := store.Count(); n != 100 {
+		t.Fatalf("%d tickets stored", n)
+	}
+	store.mu.RLock()
+	revocations := len(store.revoked)
+	store.mu.RUnlock()
+	if revocations != 0 {
+		t.Fatalf("%d expired revocations kept", revocations)
+	}
+
+	// The clear times are authenticated.
+	encoded[31]++
+	if _, err := store.DecodeTicket(encoded); err != ErrTicketInvalid {
+		t.Fatalf("tampered expiry: %v", err)
+	}
+}
+
+func TestTicketLegacyEncoding(t *testing.T) {
+	store, _ := NewTicketStore()
+	kp, _ := identity.GenerateKeyPair()
+	ticket, _ := store.Issue(kp.PeerID(), [32]byte{3})
+
+	// Tickets encoded before the clear header: ID || AEAD(plain, ID).
+	plain := make([]byte, ticketPlainSize)
+	copy(plain, ticket.PeerID[:])
+	binary.BigEndian.PutUint64(plain[32:], uint64(ticket.IssuedAt))
+	binary.BigEndian.PutUint64(plain[40:], uint64(ticket.ExpiresAt))
+	copy(plain[48:], ticket.SessionKey[:])
+	aead, _ := crypto.NewAEAD(store.key[:])
+	legacy := append(ticket.ID[:], aead.Seal(plain, ticket.ID[:])...)
+
+	got, err := store.DecodeTicket(legacy)
+	if err != nil || *got != *ticket {
+		t.Fatalf("DecodeTicket(legacy) = %+v, %v", got, err)
+	}
+	if _, err := PeekTicket(legacy); err != ErrTicketInvalid {
+		t.Fatalf("PeekTicket(legacy): %v", err)
+	}
+}
+
+func TestTicketSingleUseAndAudience(t *testing.T) {
+	key := [TicketKeySize]byte{9}
+	nodeA, nodeB := NewTicketStoreWithKey(key), NewTicketStoreWithKey(key)
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	strikes := NewStrikeRegister(fake)
+	for _, s := range []*TicketStore{nodeA, nodeB} {
+		s.SetClock(fake)
+		s.SetStrikeRegister(strikes)
+	}
+	kp, _ := identity.GenerateKeyPair()
+
+	ticket, _ := nodeA.IssueWithOptions(kp.PeerID(), [32]byte{1}, TicketOptions{SingleUse: true, Audience: "cluster-eu"})
+	encoded, _ := nodeA.EncodeTicket(ticket)
+	if _, err := nodeA.Redeem(encoded, "cluster-us"); err != ErrTicketAudience {
+		t.Fatalf("Redeem at another audience: %v", err)
+	}
+	got, renewed, err := nodeA.Resume(encoded, "cluster-eu", [32]byte{2})
+	if err != nil || !got.SingleUse || got.Audience != "cluster-eu" || renewed == nil {
+		t.Fatalf("Resume = %+v, renewed %v, %v", got, renewed != nil, err)
+	}
+	// Another node of the cluster shares the strike register.
+	if _, err := nodeB.Redeem(encoded, "cluster-eu"); err != ErrTicketRedeemed {
+		t.Fatalf("second redemption: %v", err)
+	}
+	fresh, err := nodeB.Redeem(renewed, "cluster-eu")
+	if err != nil || !fresh.SingleUse || fresh.Audience != "cluster-eu" {
+		t.Fatalf("renewed ticket = %+v, %v", fresh, err)
+	}
+
+	// Tickets without options keep the plain layout and redeem anywhere.
+	plain, _ := nodeA.Issue(kp.PeerID(), [32]byte{3})
+	encoded, _ = nodeA.EncodeTicket(plain)
+	for range 2 {
+		if _, err := nodeB.Redeem(encoded, "anywhere"); err != nil {
+			t.Fatalf("Redeem of an unrestricted ticket: %v", err)
+		}
+	}
+}
+
+func TestStrikeRegisterForgetsExpired(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	r := NewStrikeRegister(fake)
+	id := [16]byte{1}
+	if !r.Strike(id, fake.Now().Add(time.Minute)) || r.Strike(id, fake.Now().Add(time.Minute)) {
+		t.Fatal("strike not recorded")
+	}
+	fake.Advance(2 * time.Minute)
+	if !r.Strike(id, fake.Now().Add(time.Minute)) {
+		t.Fatal("expired strike still held")
+	}
+}
+
 func TestTicketRevoke(t *testing.T) {
 	store, _ := NewTicketStore()
 	kp, _ := identity.GenerateKeyPair()
@@ -0,0 +1,182 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// StreamProtocolCapability is the HELLO capability that announces stream
+// protocol headers, with the value "1". When both peers advertise it, every
+// application stream starts with a STREAM_PROTOCOL frame naming its protocol,
+// so both ends can account traffic per protocol.
+const StreamProtocolCapability = "i6p.stream-protocols"
+
+// MaxProtocolName limits the protocol name carried by a stream header.
+const MaxProtocolName = 255
+
+// MaxProtocolStats bounds the protocols Stats counts separately once the
+// peer names them: streams it opens for further protocols without a handler
+// count under OtherProtocols, so a peer cannot grow the stats without bound.
+const MaxProtocolStats = 64
+
+// OtherProtocols is the Stats key of the accepted streams beyond
+// MaxProtocolStats protocols.
+const OtherProtocols = "*"
+
+var (
+	ErrProtocolNameTooLong = errors.New("session: protocol name too long")
+	ErrBadStreamHeader     = errors.New("session: bad stream protocol header")
+)
+
+// streamHeaderTimeout bounds how long AcceptStream waits for the header of a
+// new stream, so a peer cannot stall it by opening streams and staying silent.
+const streamHeaderTimeout = 10 * time.Second
+
+func negotiateStreamProtocols(local, remote map[string]string) bool {
+	return local[StreamProtocolCapability] == "1" && remote[StreamProtocolCapability] == "1"
+}
+
+// ProtocolStats is the traffic of one protocol on a session.
+type ProtocolStats struct {
+	Streams       uint64 // streams opened or accepted
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+// Stats is a snapshot of the application traffic of a session.
+type Stats struct {
+	// Protocols maps protocol names to their traffic. Streams opened without a
+	// name count under "", and those of protocols past MaxProtocolStats
+	// under OtherProtocols.
+	Protocols map[string]ProtocolStats
+}
+
+type protocolCounters struct {
+	streams, sent, received atomic.Uint64
+}
+
+// counters returns the counters of a new stream of proto, counting it. The
+// protocols of accepted streams, named by the peer, get their own counters
+// while there are fewer than MaxProtocolStats, or if they have a handler.
+func (s *Session) counters(proto string, accepted bool) *protocolCounters {
+	handled := false
+	if accepted {
+		s.accept.mu.Lock()
+		_, handled = s.accept.handlers[proto]
+		s.accept.mu.Unlock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.traffic == nil {
+		s.traffic = make(map[string]*protocolCounters)
+	}
+	c := s.traffic[proto]
+	if c == nil {
+		if accepted && !handled && len(s.traffic) >= MaxProtocolStats {
+			proto = OtherProtocols
+			c = s.traffic[proto]
+		}
+		if c == nil {
+			c = &protocolCounters{}
+			s.traffic[proto] = c
+		}
+	}
+	c.streams.Add(1)
+	return c
+}
+
+// Stats returns the traffic of application streams by protocol. Bytes are
+// counted as the application reads and writes them; stream headers and the
+// control stream are not included.
+func (s *Session) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := Stats{Protocols: make(map[string]ProtocolStats, len(s.traffic))}
+	for name, c := range s.traffic {
+		out.Protocols[name] = ProtocolStats{
+			Streams:       c.streams.Load(),
+			BytesSent:     c.sent.Load(),
+			BytesReceived: c.received.Load(),
+		}
+	}
+	return out
+}
+
+// StreamProtocols reports whether streams on this session carry protocol
+// headers, i.e. both peers advertised StreamProtocolCapability. Without them,
+// the peer accounts every stream under "".
+func (s *Session) StreamProtocols() bool { return s.streamProtocols }
+
+// OpenProtocolStream opens an application stream for protocol proto. The
+// peer learns proto from the stream header if the session negotiated stream
+// protocols; otherwise proto only labels the local accounting. Streams of
+// protocols not marked with MarkReplaySafe wait for HandshakeConfirmed.
+func (s *Session) OpenProtocolStream(ctx context.Context, proto string) (transport.Stream, error) {
+	if len(proto) > MaxProtocolName {
+		return nil, ErrProtocolNameTooLong
+	}
+	select {
+	case <-s.goAwayRecv:
+		return nil, ErrGoingAway
+	default:
+	}
+	start := time.Now()
+	if err := s.awaitReplaySafe(ctx, proto); err != nil {
+		return nil, err
+	}
+	if err := s.acquireStream(ctx); err != nil {
+		return nil, err
+	}
+	st, err := s.conn.OpenStreamSync(ctx)
+	if err != nil {
+		s.releaseStream()
+		return nil, err
+	}
+	wrapped, err := s.ic.stream(ctx, StreamOpened, st)
+	if err != nil {
+		_ = st.Close()
+		s.releaseStream()
+		return nil, err
+	}
+	if s.streamProtocols {
+		f := protocol.Frame{Type: protocol.MessageTypeStreamProtocol, Version: s.frameVersion(), Payload: []byte(proto)}
+		if err := protocol.WriteFrame(wrapped, f); err != nil {
+			_ = wrapped.Close()
+			s.releaseStream()
+			return nil, err
+		}
+	}
+	s.opened(time.Since(start))
+	t := s.track(wrapped, proto, false)
+	t.budgeted = true
+	return t, nil
+}
+
+// readStreamHeader reads the protocol name that starts an accepted stream.
+func (s *Session) readStreamHeader(st transport.Stream) (string, error) {
+	_ = st.SetReadDeadline(time.Now().Add(streamHeaderTimeout))
+	defer st.SetReadDeadline(time.Time{})
+	f, err := protocol.ReadFrame(st)
+	if err != nil {
+		return "", err
+	}
+	if f.Type != protocol.MessageTypeStreamProtocol || f.Version != s.frameVersion() || len(f.Payload) > MaxProtocolName {
+		return "", fmt.Errorf("%w: %v frame", ErrBadStreamHeader, f.Type)
+	}
+	return string(f.Payload), nil
+}
+
+// StreamProtocol returns the protocol of a stream returned by OpenStream,
+// OpenProtocolStream or AcceptStream, or "" for any other stream.
+func StreamProtocol(st transport.Stream) string {
+	if t, ok := st.(*trackedStream); ok {
+		return t.proto
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+func trafficPair(t *testing.T, clientTags, serverTags bool) (client, server *Session) {
+	t.Helper()
+	opts := func(on bool) HandshakeOptions {
+		if !on {
+			return HandshakeOptions{}
+		}
+		return HandshakeOptions{Capabilities: map[string]string{StreamProtocolCapability: "1"}}
+	}
+	kp, _ := identity.GenerateKeyPair()
+	client, server, cerr, serr := versionPair(t, kp, opts(clientTags), opts(serverTags))
+	if cerr != nil || serr != nil {
+		t.Fatalf("handshake: client %v, server %v", cerr, serr)
+	}
+	return client, server
+}
+
+func sendOn(t *testing.T, client, server *Session, proto, msg string) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	st, err := client.OpenProtocolStream(ctx, proto)
+	if err != nil {
+		t.Fatalf("OpenProtocolStream: %v", err)
+	}
+	if _, err := st.Write([]byte(msg)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_ = st.Close()
+	in, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	defer in.Close()
+	got, err := io.ReadAll(in)
+	if err != nil || string(got) != msg {
+		t.Fatalf("read %q, %v", got, err)
+	}
+	return StreamProtocol(in)
+}
+
+func TestStreamProtocolStats(t *testing.T) {
+	client, server := trafficPair(t, true, true)
+	if !client.StreamProtocols() || !server.StreamProtocols() {
+		t.Fatal("stream protocols not negotiated")
+	}
+	if p := sendOn(t, client, server, "rpc", "ping"); p != "rpc" {
+		t.Fatalf("accepted protocol %q", p)
+	}
+	if p := sendOn(t, client, server, "transfer", "0123456789"); p != "transfer" {
+		t.Fatalf("accepted protocol %q", p)
+	}
+	if p := sendOn(t, client, server, "transfer", "abc"); p != "transfer" {
+		t.Fatalf("accepted protocol %q", p)
+	}
+
+	sent := client.Stats().Protocols
+	if got := sent["rpc"]; got != (ProtocolStats{Streams: 1, BytesSent: 4}) {
+		t.Fatalf("client rpc %+v", got)
+	}
+	if got := sent["transfer"]; got != (ProtocolStats{Streams: 2, BytesSent: 13}) {
+		t.Fatalf("client transfer %+v", got)
+	}
+	recv := server.Stats().Protocols
+	if got := recv["rpc"]; got != (ProtocolStats{Streams: 1, BytesReceived: 4}) {
+		t.Fatalf("server rpc %+v", got)
+	}
+	if got := recv["transfer"]; got != (ProtocolStats{Streams: 2, BytesReceived: 13}) {
+		t.Fatalf("server transfer %+v", got)
+	}
+}
+
+func TestStreamProtocolsRequireBothPeers(t *testing.T) {
+	client, server := trafficPair(t, true, false)
+	if client.StreamProtocols() || server.StreamProtocols() {
+		t.Fatal("stream protocols negotiated with one side only")
+	}
+	if p := sendOn(t, client, server, "rpc", "ping"); p != "" {
+		t.Fatalf("accepted protocol %q without headers", p)
+	}
+	if got := client.Stats().Protocols["rpc"]; got.BytesSent != 4 {
+		t.Fatalf("client rpc %+v", got)
+	}
+	if got := server.Stats().Protocols[""]; got.BytesReceived != 4 {
+		t.Fatalf("server untagged %+v", got)
+	}
+}
+
+func TestProtocolStatsBounded(t *testing.T) {
+	client, server := trafficPair(t, true, true)
+	const extra = 3
+	for i := range MaxProtocolStats + extra {
+		p := fmt.Sprintf("p%d", i)
+		if got := sendOn(t, client, server, p, "x"); got != p {
+			t.Fatalf("accepted protocol %q, want %q", got, p)
+		}
+	}
+	recv := server.Stats().Protocols
+	if len(recv) != MaxProtocolStats+1 || recv[OtherProtocols].Streams != extra {
+		t.Fatalf("%d protocols, %+v under %q", len(recv), recv[OtherProtocols], OtherProtocols)
+	}
+
+	// Protocols with a handler are still counted on their own.
+	handled := make(chan struct{})
+	server.HandleProtocol("late", func(st transport.Stream) error {
+		_, err := io.ReadAll(st)
+		close(handled)
+		return err
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	st, err := client.OpenProtocolStream(ctx, "late")
+	if err != nil {
+		t.Fatalf("OpenProtocolStream: %v", err)
+	}
+	_, _ = st.Write([]byte("y"))
+	_ = st.Close()
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		t.Fatal("handler not called")
+	}
+	if got := server.Stats().Protocols["late"]; got.Streams != 1 || got.BytesReceived != 1 {
+		t.Fatalf("server late %+v", got)
+	}
+}
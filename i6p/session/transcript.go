@@ -0,0 +1,90 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// exporterLabel is the TLS exporter label of session keying material.
+const exporterLabel = "EXPORTER-i6p-session"
+
+// MaxExportLength bounds ExportKeyingMaterial, the most HKDF-SHA256 yields.
+const MaxExportLength = 255 * sha256.Size
+
+var (
+	ErrNoExporter   = errors.New("session: transport cannot export keying material")
+	ErrExportLength = errors.New("session: export length must be 1 to MaxExportLength")
+)
+
+// transcriptLabel separates the transcript hash from other uses of SHA-256.
+const transcriptLabel = "i6p transcript v1"
+
+// bindingLabel is the TLS exporter label of the channel binding mixed into
+// the transcript hash.
+const bindingLabel = "EXPORTER-i6p-transcript"
+
+// channelBinding returns a value unique to the TLS connection under conn, or
+// nil for transports without TLS. A relay that terminates TLS towards each
+// peer holds two TLS connections, so the peers get different values.
+func channelBinding(conn transport.Conn) ([]byte, error) {
+	e, ok := conn.(transport.Exporter)
+	if !ok {
+		return nil, nil
+	}
+	return e.ExportKeyingMaterial(bindingLabel, nil, sha256.Size)
+}
+
+// transcriptHash hashes the handshake as both sides saw it: the client and
+// server HELLO payloads in that order, each length-prefixed, the negotiated
+// version and, if the transport has one, the channel binding.
+func transcriptHash(clientHello, serverHello []byte, version uint8, binding []byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte(transcriptLabel))
+	var n [4]byte
+	for _, b := range [][]byte{clientHello, serverHello} {
+		binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+		h.Write(n[:])
+		h.Write(b)
+	}
+	h.Write([]byte{version})
+	h.Write(binding)
+	var out [32]byte
+	h.Sum(out[:0])
+	return out
+}
+
+// TranscriptHash returns a hash over both HELLOs, the negotiated version and
+// the TLS connection the session runs on. Both ends of a session compute the
+// same value. A man in the middle that relays the HELLOs between two TLS
+// connections of its own leaves the ends with different values, so
+// applications can bind their own authentication (passwords, tokens, short
+// authentication strings) to this session. Transports without TLS, such as
+// the memory transport, give no such binding: there the hash only shows
+// that neither HELLO was altered.
+func (s *Session) TranscriptHash() [32]byte { return s.transcript }
+
+// ExportKeyingMaterial derives length bytes bound to this session for the
+// application purpose label, e.g. a key to encrypt a database exchanged
+// over it. Both ends get the same bytes; distinct labels and sessions give
+// independent ones. The material comes from the transport's TLS exporter
+// with the transcript hash as its context, so it is secret even from a
+// party that saw both HELLOs. Transports without TLS, such as the memory
+// transport, return ErrNoExporter.
+func (s *Session) ExportKeyingMaterial(label string, length int) ([]byte, error) {
+	if length <= 0 || length > MaxExportLength {
+		return nil, ErrExportLength
+	}
+	e, ok := s.conn.(transport.Exporter)
+	if !ok {
+		return nil, ErrNoExporter
+	}
+	secret, err := e.ExportKeyingMaterial(exporterLabel, s.transcript[:], sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.DeriveKey(secret, nil, []byte(label), length)
+}
@@ -0,0 +1,167 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/transport"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+	"github.com/TheusHen/I6P/i6p/transport/quic"
+)
+
+func TestTranscriptHash(t *testing.T) {
+	client, server := sessionPair(t)
+	if client.TranscriptHash() != server.TranscriptHash() {
+		t.Fatal("ends of a session disagree on the transcript")
+	}
+	if client.TranscriptHash() == ([32]byte{}) {
+		t.Fatal("empty transcript")
+	}
+	other, _ := sessionPair(t)
+	if other.TranscriptHash() == client.TranscriptHash() {
+		t.Fatal("different sessions share a transcript")
+	}
+}
+
+func TestTranscriptHashDetectsRewrittenHello(t *testing.T) {
+	// A HELLO altered in transit, even if validly re-signed, leaves the two
+	// ends with different transcripts.
+	kp, _ := identity.GenerateKeyPair()
+	rewrite := HandshakeOptions{Interceptors: Interceptors{Frame: []FrameInterceptor{
+		rewriteHello(&kp, func(h *protocol.Hello) { h.Capabilities = map[string]string{"injected": "1"} }),
+	}}}
+	client, server, cerr, serr := versionPair(t, kp, rewrite, HandshakeOptions{})
+	if cerr != nil || serr != nil {
+		t.Fatalf("handshake: %v, %v", cerr, serr)
+	}
+	if client.TranscriptHash() == server.TranscriptHash() {
+		t.Fatal("transcripts match despite the rewritten HELLO")
+	}
+}
+
+// quicPair returns the two ends of a session over QUIC on the loopback.
+func quicPair(t *testing.T) (client, server *Session) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+	ln, err := quic.Listen("[::1]:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	srvCh := make(chan *Session, 1)
+	go func() {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			srvCh <- nil
+			return
+		}
+		s, _ := HandshakeServer(ctx, conn, serverKP, HandshakeOptions{})
+		srvCh <- s
+	}()
+	conn, err := quic.Dial(ctx, ln.AddrString())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client, err = HandshakeClient(ctx, conn, clientKP, HandshakeOptions{})
+	if err != nil {
+		t.Fatalf("HandshakeClient: %v", err)
+	}
+	server = <-srvCh
+	if server == nil {
+		t.Fatal("server handshake failed")
+	}
+	return client, server
+}
+
+// tlsLeg stands in for one TLS connection: it exports material derived from
+// its own secret.
+type tlsLeg struct {
+	transport.Conn
+	secret string
+}
+
+func (c tlsLeg) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	return crypto.DeriveKey([]byte(c.secret), context, []byte(label), length)
+}
+
+// relayPair runs a handshake whose HELLOs pass unchanged between two TLS
+// legs, the client's with clientTLS and the server's with serverTLS.
+func relayPair(t *testing.T, clientTLS, serverTLS string) (client, server *Session) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	network := memory.NewNetwork()
+	ln, err := network.Listen("")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+	srvCh := make(chan *Session, 1)
+	go func() {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			srvCh <- nil
+			return
+		}
+		s, _ := HandshakeServer(ctx, tlsLeg{conn, serverTLS}, serverKP, HandshakeOptions{})
+		srvCh <- s
+	}()
+	conn, err := network.Dial(ctx, ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if client, err = HandshakeClient(ctx, tlsLeg{conn, clientTLS}, clientKP, HandshakeOptions{}); err != nil {
+		t.Fatalf("HandshakeClient: %v", err)
+	}
+	if server = <-srvCh; server == nil {
+		t.Fatal("server handshake failed")
+	}
+	return client, server
+}
+
+func TestTranscriptHashBindsTLS(t *testing.T) {
+	client, server := relayPair(t, "tls", "tls")
+	if client.TranscriptHash() != server.TranscriptHash() {
+		t.Fatal("ends of one TLS connection disagree on the transcript")
+	}
+	// A relay holds a TLS connection towards each peer.
+	client, server = relayPair(t, "client leg", "server leg")
+	if client.TranscriptHash() == server.TranscriptHash() {
+		t.Fatal("transcripts match across a relay")
+	}
+}
+
+func TestExportKeyingMaterial(t *testing.T) {
+	client, server := quicPair(t)
+
+	a, err := client.ExportKeyingMaterial("app db", 48)
+	if err != nil {
+		t.Fatalf("ExportKeyingMaterial: %v", err)
+	}
+	b, _ := server.ExportKeyingMaterial("app db", 48)
+	if len(a) != 48 || !bytes.Equal(a, b) {
+		t.Fatal("ends of a session export different material")
+	}
+	if c, _ := client.ExportKeyingMaterial("other", 48); bytes.Equal(a, c) {
+		t.Fatal("labels export the same material")
+	}
+	if _, err := client.ExportKeyingMaterial("app db", 0); !errors.Is(err, ErrExportLength) {
+		t.Fatalf("zero length: %v", err)
+	}
+
+	memClient, _ := sessionPair(t)
+	if _, err := memClient.ExportKeyingMaterial("app db", 32); !errors.Is(err, ErrNoExporter) {
+		t.Fatalf("memory transport: %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+// versionPair runs a handshake over an in-memory network and returns both
+// sides' results.
+func versionPair(t *testing.T, clientKP identity.KeyPair, clientOpts, serverOpts HandshakeOptions) (client, server *Session, clientErr, serverErr error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverKP, _ := identity.GenerateKeyPair()
+	network := memory.NewNetwork()
+	ln, err := network.Listen("")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	type result struct {
+		sess *Session
+		err  error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			resCh <- result{err: err}
+			return
+		}
+		sess, err := HandshakeServer(ctx, conn, serverKP, serverOpts)
+		if err != nil {
+			_ = conn.CloseWithError(1, err.Error())
+		}
+		resCh <- result{sess, err}
+	}()
+	conn, err := network.Dial(ctx, ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client, clientErr = HandshakeClient(ctx, conn, clientKP, clientOpts)
+	res := <-resCh
+	return client, res.sess, clientErr, res.err
+}
+
+// rewriteHello returns an interceptor that edits outgoing HELLOs, re-signing
+// them with kp when it is non-nil.
+func rewriteHello(kp *identity.KeyPair, edit func(*protocol.Hello)) FrameInterceptor {
+	return func(dir FrameDirection, f protocol.Frame) (protocol.Frame, error) {
+		if dir != FrameWrite || f.Type != protocol.MessageTypeHello {
+			return f, nil
+		}
+		h, err := protocol.DecodeHello(f.Payload)
+		if err != nil {
+			return f, err
+		}
+		edit(&h)
+		if kp != nil {
+			if err := h.Sign(*kp); err != nil {
+				return f, err
+			}
+		}
+		f.Payload, err = protocol.EncodeHello(h)
+		return f, err
+	}
+}
+
+// pingPong checks that frames flow both ways on the negotiated version.
+func pingPong(t *testing.T, s *Session) {
+	t.Helper()
+	if err := s.writeFrame(protocol.NewPingFrame(7)); err != nil {
+		t.Fatalf("write PING: %v", err)
+	}
+	select {
+	case seq := <-s.pongs:
+		if seq != 7 {
+			t.Fatalf("PONG %d", seq)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no PONG on a version %d session", s.Version())
+	}
+}
+
+func TestHandshakeNegotiatesHighestVersion(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	client, server, cerr, serr := versionPair(t, kp, HandshakeOptions{}, HandshakeOptions{})
+	if cerr != nil || serr != nil {
+		t.Fatalf("handshake: %v, %v", cerr, serr)
+	}
+	if client.Version() != protocol.CurrentVersion || server.Version() != protocol.CurrentVersion {
+		t.Fatalf("versions = %d, %d", client.Version(), server.Version())
+	}
+	pingPong(t, client)
+
+	client, server, cerr, serr = versionPair(t, kp, HandshakeOptions{Versions: []uint8{protocol.Version1}}, HandshakeOptions{})
+	if cerr != nil || serr != nil {
+		t.Fatalf("handshake: %v, %v", cerr, serr)
+	}
+	if client.Version() != protocol.Version1 || server.Version() != protocol.Version1 {
+		t.Fatalf("versions = %d, %d", client.Version(), server.Version())
+	}
+	pingPong(t, client)
+}
+
+func TestHandshakeLegacyClient(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	legacy := HandshakeOptions{Versions: []uint8{protocol.Version1}, Interceptors: Interceptors{Frame: []FrameInterceptor{
+		rewriteHello(&kp, func(h *protocol.Hello) { h.Versions = nil }),
+	}}}
+	client, server, cerr, serr := versionPair(t, kp, legacy, HandshakeOptions{})
+	if cerr != nil || serr != nil {
+		t.Fatalf("handshake: %v, %v", cerr, serr)
+	}
+	if client.Version() != protocol.Version1 || server.Version() != protocol.Version1 {
+		t.Fatalf("versions = %d, %d", client.Version(), server.Version())
+	}
+
+	_, _, _, serr = versionPair(t, kp, legacy, HandshakeOptions{Versions: []uint8{protocol.Version2}})
+	if !errors.Is(serr, protocol.ErrNoCommonVersion) {
+		t.Fatalf("server err = %v, want ErrNoCommonVersion", serr)
+	}
+}
+
+func TestHandshakeRejectsStrippedVersions(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	// An attacker without the client's key cannot remove its offer.
+	mitm := HandshakeOptions{Interceptors: Interceptors{Frame: []FrameInterceptor{
+		rewriteHello(nil, func(h *protocol.Hello) { h.Versions = []uint8{protocol.Version1} }),
+	}}}
+	_, _, _, serr := versionPair(t, kp, mitm, HandshakeOptions{})
+	if !errors.Is(serr, protocol.ErrHelloBadSignature) {
+		t.Fatalf("server err = %v, want ErrHelloBadSignature", serr)
+	}
+
+	// Removing the offer with its signature leaves a valid Version1 HELLO,
+	// but one that still carries the client's highest version.
+	strip := HandshakeOptions{Interceptors: Interceptors{Frame: []FrameInterceptor{
+		rewriteHello(nil, func(h *protocol.Hello) { h.Versions, h.VersionSignature = nil, nil }),
+	}}}
+	_, _, _, serr = versionPair(t, kp, strip, HandshakeOptions{})
+	if !errors.Is(serr, protocol.ErrVersionDowngrade) {
+		t.Fatalf("server err = %v, want ErrVersionDowngrade", serr)
+	}
+}
+
+func TestHandshakeDetectsDowngradedSelection(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	serverKP, _ := identity.GenerateKeyPair()
+	// A server that signs a lower selection than both offers is rejected.
+	downgrade := HandshakeOptions{Interceptors: Interceptors{Frame: []FrameInterceptor{
+		func(dir FrameDirection, f protocol.Frame) (protocol.Frame, error) {
+			if dir != FrameRead || f.Type != protocol.MessageTypeHello {
+				return f, nil
+			}
+			h, err := protocol.DecodeHello(f.Payload)
+			if err != nil {
+				return f, err
+			}
+			h.PeerID, h.PublicKey = serverKP.PeerID().String(), serverKP.PublicKey
+			h.Version = protocol.Version1
+			if err := h.Sign(serverKP); err != nil {
+				return f, err
+			}
+			f.Payload, err = protocol.EncodeHello(h)
+			return f, err
+		},
+	}}}
+	_, _, cerr, _ := versionPair(t, kp, downgrade, HandshakeOptions{})
+	if !errors.Is(cerr, protocol.ErrVersionDowngrade) {
+		t.Fatalf("client err = %v, want ErrVersionDowngrade", cerr)
+	}
+}
+
+// replaceServerHello substitutes payload for the server HELLO the client reads.
+func replaceServerHello(payload *[]byte) FrameInterceptor {
+	return func(dir FrameDirection, f protocol.Frame) (protocol.Frame, error) {
+		if dir == FrameRead && f.Type == protocol.MessageTypeHello {
+			f.Payload = *payload
+		}
+		return f, nil
+	}
+}
+
+func TestHandshakeRejectsReplayedLegacyHello(t *testing.T) {
+	kp, _ := identity.GenerateKeyPair()
+	// Harvest the Version1 HELLO a current server signs for a legacy client.
+	var harvested []byte
+	legacy := HandshakeOptions{Versions: []uint8{protocol.Version1}, Interceptors: Interceptors{Frame: []FrameInterceptor{
+		rewriteHello(&kp, func(h *protocol.Hello) { h.Versions = nil }),
+		func(dir FrameDirection, f protocol.Frame) (protocol.Frame, error) {
+			if dir == FrameRead && f.Type == protocol.MessageTypeHello {
+				harvested = f.Payload
+			}
+			return f, nil
+		},
+	}}}
+	if _, _, cerr, serr := versionPair(t, kp, legacy, HandshakeOptions{}); cerr != nil || serr != nil {
+		t.Fatalf("handshake: %v, %v", cerr, serr)
+	}
+	h, err := protocol.DecodeHello(harvested)
+	if err != nil || h.Capabilities[protocol.MaxVersionCapability] != "2" {
+		t.Fatalf("legacy HELLO capabilities %v, %v", h.Capabilities, err)
+	}
+
+	// Replayed to a client that offered Version2, it is refused even when
+	// legacy servers are allowed.
+	for _, allow := range []bool{false, true} {
+		replay := HandshakeOptions{AllowLegacyServer: allow, Interceptors: Interceptors{Frame: []FrameInterceptor{replaceServerHello(&harvested)}}}
+		_, _, cerr, _ := versionPair(t, kp, replay, HandshakeOptions{})
+		if !errors.Is(cerr, protocol.ErrVersionDowngrade) {
+			t.Fatalf("allow %v: client err = %v, want ErrVersionDowngrade", allow, cerr)
+		}
+	}
+}
+
+// baselineHello is the HELLO of a server that predates version negotiation,
+// with its own signing bytes rather than protocol.Hello's.
+type baselineHello struct {
+	PeerID       string            `json:"peer_id"`
+	PublicKey    []byte            `json:"public_key"`
+	TimestampSec int64             `json:"timestamp_sec"`
+	Nonce        []byte            `json:"nonce"`
+	Capabilities map[string]string `json:"capabilities,omitempty"`
+	Signature    []byte            `json:"signature"`
+}
+
+func (h baselineHello) signingBytes() []byte {
+	id, _ := identity.ParsePeerIDHex(h.PeerID)
+	var b bytes.Buffer
+	b.Write(id[:])
+	b.Write(h.PublicKey)
+	_ = binary.Write(&b, binary.BigEndian, uint64(h.TimestampSec))
+	b.Write(h.Nonce)
+	for _, k := range slices.Sorted(maps.Keys(h.Capabilities)) {
+		_ = binary.Write(&b, binary.BigEndian, uint16(len(k)))
+		b.WriteString(k)
+		_ = binary.Write(&b, binary.BigEndian, uint16(len(h.Capabilities[k])))
+		b.WriteString(h.Capabilities[k])
+	}
+	return b.Bytes()
+}
+
+// baselinePair handshakes a client using opts with a server that predates
+// version negotiation: it verifies the client HELLO with a plain Ed25519
+// signature and answers with one of its own. serverErr is why it refused the
+// client.
+func baselinePair(t *testing.T, opts HandshakeOptions) (client *Session, clientErr, serverErr error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	kp, _ := identity.GenerateKeyPair()
+	serverKP, _ := identity.GenerateKeyPair()
+	network := memory.NewNetwork()
+	ln, err := network.Listen("")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- func() error {
+			conn, err := ln.Accept(ctx)
+			if err != nil {
+				return err
+			}
+			control, err := conn.AcceptStream(ctx)
+			if err != nil {
+				return err
+			}
+			f, err := protocol.ReadFrame(control)
+			if err != nil {
+				return err
+			}
+			var remote baselineHello
+			if err := json.Unmarshal(f.Payload, &remote); err != nil {
+				return err
+			}
+			if len(remote.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(remote.PublicKey, remote.signingBytes(), remote.Signature) {
+				_ = conn.CloseWithError(1, "bad HELLO")
+				return protocol.ErrHelloBadSignature
+			}
+			local := baselineHello{
+				PeerID:       serverKP.PeerID().String(),
+				PublicKey:    serverKP.PublicKey,
+				TimestampSec: time.Now().Unix(),
+				Nonce:        bytes.Repeat([]byte{7}, 32),
+			}
+			local.Signature = serverKP.Sign(local.signingBytes())
+			payload, _ := json.Marshal(local)
+			return protocol.WriteFrame(control, protocol.Frame{Type: protocol.MessageTypeHello, Payload: payload})
+		}()
+	}()
+	conn, err := network.Dial(ctx, ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client, clientErr = HandshakeClient(ctx, conn, kp, opts)
+	return client, clientErr, <-errCh
+}
+
+func TestHandshakeLegacyServer(t *testing.T) {
+	// The client's offer verifies on a server that predates versions, which
+	// answers without versions or the downgrade sentinel.
+	_, cerr, serr := baselinePair(t, HandshakeOptions{})
+	if serr != nil {
+		t.Fatalf("legacy server refused the client HELLO: %v", serr)
+	}
+	if !errors.Is(cerr, protocol.ErrVersionDowngrade) {
+		t.Fatalf("client err = %v, want ErrVersionDowngrade", cerr)
+	}
+	for _, opts := range []HandshakeOptions{{AllowLegacyServer: true}, {Versions: []uint8{protocol.Version1}}} {
+		client, cerr, serr := baselinePair(t, opts)
+		if cerr != nil || serr != nil {
+			t.Fatalf("handshake: %v, %v", cerr, serr)
+		}
+		if client.Version() != protocol.Version1 {
+			t.Fatalf("version = %d", client.Version())
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/protocol"
+)
+
+// frameReq is a frame waiting in a lane of the control stream writer.
+type frameReq struct {
+	f        protocol.Frame
+	deadline time.Time   // zero: no deadline
+	done     chan error  // receives the write result
+	canceled atomic.Bool // the caller gave up; skip the frame
+}
+
+// frameQueue holds frames waiting to be written, one FIFO per lane.
+type frameQueue struct {
+	mu     sync.Mutex
+	lanes  [protocol.NumLanes][]*frameReq
+	signal chan struct{} // wakes the writer; capacity 1
+}
+
+func newFrameQueue() *frameQueue {
+	return &frameQueue{signal: make(chan struct{}, 1)}
+}
+
+func (q *frameQueue) push(r *frameReq) {
+	lane := r.f.Type.Lane()
+	if lane >= protocol.NumLanes {
+		lane = protocol.LaneNormal
+	}
+	q.mu.Lock()
+	q.lanes[lane] = append(q.lanes[lane], r)
+	q.mu.Unlock()
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the oldest frame of the most urgent non-empty lane, or nil.
+func (q *frameQueue) pop() *frameReq {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.lanes {
+		if len(q.lanes[i]) > 0 {
+			r := q.lanes[i][0]
+			q.lanes[i][0] = nil
+			q.lanes[i] = q.lanes[i][1:]
+			return r
+		}
+	}
+	return nil
+}
+
+// len returns the number of queued frames.
+func (q *frameQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, l := range q.lanes {
+		n += len(l)
+	}
+	return n
+}
+
+// writeLoop writes queued frames to the control stream, most urgent lane
+// first, until the connection ends. A frame is written whole, so an urgent
+// frame waits for at most the frame being written.
+func (s *Session) writeLoop() {
+	done := s.conn.Context().Done()
+	for {
+		r := s.frames.pop()
+		if r == nil {
+			select {
+			case <-s.frames.signal:
+				continue
+			case <-done:
+				return
+			}
+		}
+		if r.canceled.Load() {
+			continue
+		}
+		if r.deadline.IsZero() {
+			r.done <- s.ic.writeFrame(s.control, r.f)
+			continue
+		}
+		if !time.Now().Before(r.deadline) {
+			r.done <- os.ErrDeadlineExceeded
+			continue
+		}
+		_ = s.control.SetWriteDeadline(r.deadline)
+		err := s.ic.writeFrame(s.control, r.f)
+		_ = s.control.SetWriteDeadline(time.Time{})
+		r.done <- err
+	}
+}
+
+// sendFrame queues f in its lane and waits until it is written, the deadline
+// (if any) passes or the connection ends.
+func (s *Session) sendFrame(f protocol.Frame, deadline time.Time) error {
+	f.Version = s.frameVersion()
+	r := &frameReq{f: f, deadline: deadline, done: make(chan error, 1)}
+	s.frames.push(r)
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case err := <-r.done:
+		return err
+	case <-timeout:
+		r.canceled.Store(true)
+		return os.ErrDeadlineExceeded
+	case <-s.conn.Context().Done():
+		r.canceled.Store(true)
+		return s.conn.Context().Err()
+	}
+}
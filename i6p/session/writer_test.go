@@ -0,0 +1,101 @@
+package session
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/protocol"
+)
+
+func TestWriterUrgentLaneFirst(t *testing.T) {
+	client, _ := sessionPair(t)
+
+	var (
+		mu      sync.Mutex
+		written []protocol.MessageType
+	)
+	release := make(chan struct{})
+	first := true
+	client.ic.Frame = []FrameInterceptor{func(dir FrameDirection, f protocol.Frame) (protocol.Frame, error) {
+		if dir != FrameWrite {
+			return f, nil
+		}
+		mu.Lock()
+		block := first
+		first = false
+		written = append(written, f.Type)
+		mu.Unlock()
+		if block {
+			<-release // hold the writer inside the first frame
+		}
+		return f, nil
+	}}
+
+	var wg sync.WaitGroup
+	send := func(f protocol.Frame) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.writeFrame(f); err != nil {
+				t.Errorf("writeFrame(%v): %v", f.Type, err)
+			}
+		}()
+	}
+	big := protocol.Frame{Type: protocol.MessageTypePeerInfo, Payload: make([]byte, 512<<10)}
+	send(big)
+	for {
+		mu.Lock()
+		started := !first
+		mu.Unlock()
+		if started {
+			break // the writer is inside the first frame
+		}
+		time.Sleep(time.Millisecond)
+	}
+	send(big)
+	send(big)
+	for client.frames.len() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	send(protocol.NewPingFrame(1))
+	for client.frames.len() != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	want := []protocol.MessageType{protocol.MessageTypePeerInfo, protocol.MessageTypePing, protocol.MessageTypePeerInfo, protocol.MessageTypePeerInfo}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(written) != len(want) {
+		t.Fatalf("wrote %v", written)
+	}
+	for i := range want {
+		if written[i] != want[i] {
+			t.Fatalf("wrote %v, want %v", written, want)
+		}
+	}
+}
+
+func TestWriterTimeoutWhileQueued(t *testing.T) {
+	client, _ := sessionPair(t)
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	client.ic.Frame = []FrameInterceptor{func(dir FrameDirection, f protocol.Frame) (protocol.Frame, error) {
+		if dir == FrameWrite && f.Type == protocol.MessageTypePeerInfo {
+			close(entered)
+			<-release
+		}
+		return f, nil
+	}}
+	go func() { _ = client.writeFrame(protocol.Frame{Type: protocol.MessageTypePeerInfo}) }()
+	<-entered
+	start := time.Now()
+	if err := client.writeFrameTimeout(protocol.NewPingFrame(1), 50*time.Millisecond); err == nil {
+		t.Fatal("expected a timeout while the writer is blocked")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("timeout took %v", d)
+	}
+}
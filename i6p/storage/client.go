@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// Client stores fragments on the provider at the other end of a session.
+type Client struct {
+	s *session.Session
+}
+
+// NewClient creates a client for the provider on s.
+func NewClient(s *session.Session) *Client {
+	return &Client{s: s}
+}
+
+// exchange runs one request on a new stream, bounded by ctx.
+func (c *Client) exchange(ctx context.Context, fn func(st transport.Stream) error) error {
+	st, err := c.s.OpenProtocolStream(ctx, ProtocolName)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	stop := context.AfterFunc(ctx, func() { _ = st.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	err = fn(st)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func remoteErr(resp response) error {
+	if resp.Error != "" {
+		return fmt.Errorf("%w: %s", ErrRemoteFail, resp.Error)
+	}
+	return nil
+}
+
+// Store sends the size bytes read from r to the provider and returns the
+// receipt for them. It fails with ErrIntegrity if the provider stored
+// something else.
+func (c *Client) Store(ctx context.Context, r io.Reader, size int64) (Receipt, error) {
+	var rc Receipt
+	err := c.exchange(ctx, func(st transport.Stream) error {
+		if err := writeMsg(st, request{Op: opStore, Size: size}); err != nil {
+			return err
+		}
+		var h blockHasher
+		if _, err := io.CopyN(st, io.TeeReader(r, &h), size); err != nil {
+			return err
+		}
+		var resp response
+		if err := readMsg(st, &resp); err != nil {
+			return err
+		}
+		if err := remoteErr(resp); err != nil {
+			return err
+		}
+		tree, err := h.tree()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tree.Root(), resp.Root) {
+			return ErrIntegrity
+		}
+		rc = Receipt{Root: tree.Root(), Size: size}
+		return nil
+	})
+	return rc, err
+}
+
+// Retrieve writes the fragment of rc to w. The fragment is checked against
+// its root as it streams, so on ErrIntegrity w has already received the bad
+// data.
+func (c *Client) Retrieve(ctx context.Context, rc Receipt, w io.Writer) error {
+	return c.exchange(ctx, func(st transport.Stream) error {
+		if err := writeMsg(st, request{Op: opRetrieve, Root: rc.Root}); err != nil {
+			return err
+		}
+		var resp response
+		if err := readMsg(st, &resp); err != nil {
+			return err
+		}
+		if err := remoteErr(resp); err != nil {
+			return err
+		}
+		if resp.Size != rc.Size {
+			return ErrIntegrity
+		}
+		var h blockHasher
+		if _, err := io.CopyN(io.MultiWriter(w, &h), st, rc.Size); err != nil {
+			return err
+		}
+		tree, err := h.tree()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tree.Root(), rc.Root) {
+			return ErrIntegrity
+		}
+		return nil
+	})
+}
+
+// Challenge asks the provider for a random block of the fragment of rc with
+// its Merkle proof. It returns nil only if the provider proved it holds that
+// block, and an error wrapping ErrChallengeFailed if the answer was wrong.
+func (c *Client) Challenge(ctx context.Context, rc Receipt) error {
+	if rc.Blocks() == 0 {
+		return fmt.Errorf("%w: empty receipt", ErrChallengeFailed)
+	}
+	index := rand.IntN(rc.Blocks())
+	return c.exchange(ctx, func(st transport.Stream) error {
+		if err := writeMsg(st, request{Op: opChallenge, Root: rc.Root, Index: index}); err != nil {
+			return err
+		}
+		var resp response
+		if err := readMsg(st, &resp); err != nil {
+			return err
+		}
+		if err := remoteErr(resp); err != nil {
+			return fmt.Errorf("%w: %v", ErrChallengeFailed, err)
+		}
+		want := BlockSize
+		if index == rc.Blocks()-1 {
+			want = int(rc.Size - int64(index)*BlockSize)
+		}
+		if len(resp.Block) != want {
+			return fmt.Errorf("%w: block %d has %d bytes", ErrChallengeFailed, index, len(resp.Block))
+		}
+		proof := transfer.Proof{
+			ChunkIndex: index,
+			ChunkHash:  transfer.HashChunk(resp.Block),
+			Siblings:   resp.Siblings,
+			IsLeft:     resp.IsLeft,
+		}
+		if err := transfer.VerifyProofAt(proof, rc.Root, rc.Blocks()); err != nil {
+			return fmt.Errorf("%w: block %d: %v", ErrChallengeFailed, index, err)
+		}
+		return nil
+	})
+}
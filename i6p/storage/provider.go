@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// DefaultMaxFragment is the largest fragment a Provider accepts by default.
+const DefaultMaxFragment = 1 << 30
+
+var (
+	ErrNotFound         = errors.New("storage: fragment not found")
+	ErrFragmentTooLarge = errors.New("storage: fragment too large")
+)
+
+// Provider stores fragments for remote clients in a directory. Each fragment
+// is kept as <root>.frag with its block hashes in <root>.leaves, so
+// challenges are answered without rehashing the fragment.
+type Provider struct {
+	dir string
+	// MaxFragment caps the size of a stored fragment; 0 selects
+	// DefaultMaxFragment.
+	MaxFragment int64
+}
+
+// NewProvider creates a provider storing fragments in dir.
+func NewProvider(dir string) *Provider {
+	return &Provider{dir: dir}
+}
+
+// Serve answers storage requests on s until the session ends or ctx is done.
+// Streams of other protocols are left to the session's other handlers, and
+// errors go to its stream error hook.
+func (p *Provider) Serve(ctx context.Context, s *session.Session) error {
+	return s.ServeProtocol(ctx, ProtocolName, func(st transport.Stream) error {
+		return p.Handle(st)
+	})
+}
+
+// Handle answers one request read from rw.
+func (p *Provider) Handle(rw io.ReadWriter) (err error) {
+	defer i6perrors.Recover(&err, "storage")
+	var req request
+	if err := readMsg(rw, &req); err != nil {
+		return err
+	}
+	switch req.Op {
+	case opStore:
+		err = p.store(rw, req)
+	case opRetrieve:
+		err = p.retrieve(rw, req)
+	case opChallenge:
+		err = p.challenge(rw, req)
+	default:
+		err = fmt.Errorf("%w: unknown op %q", ErrMessage, req.Op)
+		_ = writeMsg(rw, response{Error: err.Error()})
+	}
+	return err
+}
+
+func (p *Provider) path(root []byte, ext string) (string, error) {
+	if len(root) != 32 {
+		return "", ErrMessage
+	}
+	return filepath.Join(p.dir, hex.EncodeToString(root)+ext), nil
+}
+
+// fail reports err to the client and returns it.
+func fail(w io.Writer, err error) error {
+	_ = writeMsg(w, response{Error: err.Error()})
+	return err
+}
+
+func (p *Provider) store(rw io.ReadWriter, req request) error {
+	limit := p.MaxFragment
+	if limit <= 0 {
+		limit = DefaultMaxFragment
+	}
+	if req.Size <= 0 || req.Size > limit {
+		// The client reads the response only after sending the fragment.
+		_, _ = io.CopyN(io.Discard, rw, max(req.Size, 0))
+		return fail(rw, ErrFragmentTooLarge)
+	}
+
+	tmp, err := os.CreateTemp(p.dir, ".incoming-*")
+	if err != nil {
+		return fail(rw, err)
+	}
+	defer os.Remove(tmp.Name())
+	var h blockHasher
+	_, err = io.CopyN(io.MultiWriter(tmp, &h), rw, req.Size)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fail(rw, err)
+	}
+	tree, err := h.tree()
+	if err != nil {
+		return fail(rw, err)
+	}
+	root := tree.Root()
+
+	frag, _ := p.path(root, ".frag")
+	leaves, _ := p.path(root, ".leaves")
+	var buf []byte
+	for _, l := range h.leaves {
+		buf = append(buf, l...)
+	}
+	if err := os.WriteFile(leaves, buf, 0o600); err != nil {
+		return fail(rw, err)
+	}
+	if err := os.Rename(tmp.Name(), frag); err != nil {
+		return fail(rw, err)
+	}
+	return writeMsg(rw, response{Root: root})
+}
+
+func (p *Provider) retrieve(rw io.ReadWriter, req request) error {
+	frag, err := p.path(req.Root, ".frag")
+	if err != nil {
+		return fail(rw, err)
+	}
+	f, err := os.Open(frag)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fail(rw, ErrNotFound)
+	}
+	if err != nil {
+		return fail(rw, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fail(rw, err)
+	}
+	if err := writeMsg(rw, response{Size: fi.Size()}); err != nil {
+		return err
+	}
+	_, err = io.CopyN(rw, f, fi.Size())
+	return err
+}
+
+func (p *Provider) challenge(rw io.ReadWriter, req request) error {
+	frag, err := p.path(req.Root, ".frag")
+	if err != nil {
+		return fail(rw, err)
+	}
+	leavesPath, _ := p.path(req.Root, ".leaves")
+	raw, err := os.ReadFile(leavesPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fail(rw, ErrNotFound)
+	}
+	if err != nil {
+		return fail(rw, err)
+	}
+	if len(raw) == 0 || len(raw)%32 != 0 {
+		return fail(rw, ErrIntegrity)
+	}
+	leaves := make([][]byte, len(raw)/32)
+	for i := range leaves {
+		leaves[i] = raw[i*32 : (i+1)*32 : (i+1)*32]
+	}
+	tree, err := transfer.BuildMerkleTree(leaves)
+	if err != nil {
+		return fail(rw, err)
+	}
+	proof, err := tree.GenerateProof(req.Index)
+	if err != nil || req.Index >= len(leaves) {
+		return fail(rw, transfer.ErrMerkleIndexRange)
+	}
+
+	f, err := os.Open(frag)
+	if err != nil {
+		return fail(rw, ErrNotFound)
+	}
+	defer f.Close()
+	block := make([]byte, BlockSize)
+	n, err := f.ReadAt(block, int64(req.Index)*BlockSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fail(rw, err)
+	}
+	return writeMsg(rw, response{Block: block[:n], Siblings: proof.Siblings, IsLeft: proof.IsLeft})
+}
@@ -0,0 +1,153 @@
+// Package storage lets a peer keep data, typically erasure.Archive
+// fragments, on another peer and check that the provider still holds it.
+//
+// A fragment is named by the Merkle root of its BlockSize blocks. The client
+// keeps only a Receipt (root and size) and can later retrieve the fragment or
+// challenge the provider for a random block: the provider must answer with
+// the block and its Merkle proof, which it cannot produce without the data.
+//
+// One request travels per stream, tagged ProtocolName on sessions that
+// negotiated stream protocols:
+//
+//	store:     client -> request, fragment bytes;  provider -> response with the root
+//	retrieve:  client -> request with the root;    provider -> response with the size, fragment bytes
+//	challenge: client -> request with root, index; provider -> response with the block and proof
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/TheusHen/I6P/i6p/transfer"
+)
+
+// ProtocolName tags storage streams (see session.OpenProtocolStream).
+const ProtocolName = "i6p/storage/1"
+
+// BlockSize is the leaf size of a fragment's Merkle tree, and the amount of
+// data a challenge asks for.
+const BlockSize = 16 << 10
+
+// maxMessage bounds one control message.
+const maxMessage = 1 << 20
+
+var (
+	ErrMessage         = errors.New("storage: malformed message")
+	ErrTooLarge        = errors.New("storage: message too large")
+	ErrRemoteFail      = errors.New("storage: remote failed")
+	ErrIntegrity       = errors.New("storage: fragment does not match its root")
+	ErrChallengeFailed = errors.New("storage: challenge failed")
+)
+
+const (
+	opStore     = "store"
+	opRetrieve  = "retrieve"
+	opChallenge = "challenge"
+)
+
+type request struct {
+	Op    string `json:"op"`
+	Size  int64  `json:"size,omitempty"`  // store: bytes that follow
+	Root  []byte `json:"root,omitempty"`  // retrieve, challenge
+	Index int    `json:"index,omitempty"` // challenge: block index
+}
+
+type response struct {
+	Error    string   `json:"error,omitempty"`
+	Root     []byte   `json:"root,omitempty"` // store
+	Size     int64    `json:"size,omitempty"` // retrieve: bytes that follow
+	Block    []byte   `json:"block,omitempty"`
+	Siblings [][]byte `json:"siblings,omitempty"`
+	IsLeft   []bool   `json:"is_left,omitempty"`
+}
+
+// Receipt is what a client keeps to retrieve and challenge a fragment.
+type Receipt struct {
+	Root []byte `json:"root"`
+	Size int64  `json:"size"`
+}
+
+// Blocks returns the number of Merkle leaves of the fragment.
+func (r Receipt) Blocks() int {
+	return int((r.Size + BlockSize - 1) / BlockSize)
+}
+
+// blockHasher hashes what is written to it in BlockSize blocks.
+type blockHasher struct {
+	leaves  [][]byte
+	partial []byte
+}
+
+func (h *blockHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := min(BlockSize-len(h.partial), len(p))
+		h.partial = append(h.partial, p[:take]...)
+		p = p[take:]
+		if len(h.partial) == BlockSize {
+			h.leaves = append(h.leaves, transfer.HashChunk(h.partial))
+			h.partial = h.partial[:0]
+		}
+	}
+	return n, nil
+}
+
+// tree finishes the last block and builds the Merkle tree.
+func (h *blockHasher) tree() (*transfer.MerkleTree, error) {
+	if len(h.partial) > 0 {
+		h.leaves = append(h.leaves, transfer.HashChunk(h.partial))
+		h.partial = nil
+	}
+	return transfer.BuildMerkleTree(h.leaves)
+}
+
+// FragmentRoot returns the root a fragment is stored under.
+func FragmentRoot(data []byte) ([]byte, error) {
+	var h blockHasher
+	_, _ = h.Write(data)
+	t, err := h.tree()
+	if err != nil {
+		return nil, err
+	}
+	return t.Root(), nil
+}
+
+// writeMsg sends v as length-prefixed JSON.
+func writeMsg(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMessage {
+		return ErrTooLarge
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readMsg(r io.Reader, v any) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxMessage {
+		return ErrTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMessage, err)
+	}
+	return nil
+}
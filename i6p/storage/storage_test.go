@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+func TestStoreRetrieveChallenge(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	caps := map[string]string{session.StreamProtocolCapability: "1"}
+	network := memory.NewNetwork()
+	aliceKP, _ := identity.GenerateKeyPair()
+	bobKP, _ := identity.GenerateKeyPair()
+	bob := i6p.NewPeer(bobKP, caps)
+	ln, _ := network.Listen("bob")
+	bob.Serve(ln)
+	go func() {
+		s, err := bob.Accept(ctx)
+		if err == nil {
+			_ = NewProvider(dir).Serve(ctx, s)
+		}
+	}()
+	conn, _ := network.Dial(ctx, "bob")
+	sess, err := i6p.NewPeer(aliceKP, caps).Connect(ctx, conn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	c := NewClient(sess)
+
+	frag := make([]byte, 6*BlockSize+123)
+	rand.New(rand.NewSource(1)).Read(frag)
+	rc, err := c.Store(ctx, bytes.NewReader(frag), int64(len(frag)))
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if root, _ := FragmentRoot(frag); !bytes.Equal(root, rc.Root) || rc.Blocks() != 7 {
+		t.Fatalf("receipt %+v", rc)
+	}
+
+	var got bytes.Buffer
+	if err := c.Retrieve(ctx, rc, &got); err != nil || !bytes.Equal(got.Bytes(), frag) {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := c.Challenge(ctx, rc); err != nil {
+			t.Fatalf("Challenge: %v", err)
+		}
+	}
+
+	// Silent corruption of every block on the provider's disk.
+	path := filepath.Join(dir, hex.EncodeToString(rc.Root)+".frag")
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for off := int64(0); off < int64(len(frag)); off += BlockSize {
+		_, _ = f.WriteAt([]byte{^frag[off]}, off)
+	}
+	_ = f.Close()
+	if err := c.Challenge(ctx, rc); !errors.Is(err, ErrChallengeFailed) {
+		t.Fatalf("Challenge of corrupt fragment: %v", err)
+	}
+	if err := c.Retrieve(ctx, rc, &bytes.Buffer{}); !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("Retrieve of corrupt fragment: %v", err)
+	}
+
+	_ = os.Remove(path)
+	if err := c.Challenge(ctx, rc); !errors.Is(err, ErrChallengeFailed) {
+		t.Fatalf("Challenge of lost fragment: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package sync
+
+import (
+	gosync "sync"
+)
+
+// MemoryStore is an in-memory Store. Set and Delete bump the version of the
+// key so local changes win over older remote ones.
+type MemoryStore struct {
+	mu      gosync.Mutex
+	entries map[string]Entry
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]Entry)}
+}
+
+// Get returns the value of key, or false if it is absent or deleted.
+func (m *MemoryStore) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || e.Deleted {
+		return nil, false
+	}
+	return e.Value, true
+}
+
+// Set stores value under key.
+func (m *MemoryStore) Set(key string, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entries[key]
+	m.entries[key] = Entry{Key: key, Value: append([]byte(nil), value...), Version: e.Version + 1}
+}
+
+// Delete leaves a tombstone for key.
+func (m *MemoryStore) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entries[key]
+	m.entries[key] = Entry{Key: key, Version: e.Version + 1, Deleted: true}
+}
+
+// Entries implements Store.
+func (m *MemoryStore) Entries() ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// Put implements Store.
+func (m *MemoryStore) Put(e Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[e.Key] = e
+	return nil
+}
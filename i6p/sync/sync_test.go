@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+)
+
+// run syncs a (initiator) with b (responder) over an in-memory pipe.
+func run(t *testing.T, a, b *Syncer) (Stats, Stats) {
+	t.Helper()
+	ca, cb := net.Pipe()
+	defer ca.Close()
+	defer cb.Close()
+	type res struct {
+		st  Stats
+		err error
+	}
+	done := make(chan res, 1)
+	go func() {
+		st, err := b.Serve(cb)
+		done <- res{st, err}
+	}()
+	sa, err := a.Sync(ca)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("Serve: %v", r.err)
+	}
+	return sa, r.st
+}
+
+func contents(t *testing.T, s Store) []Entry {
+	t.Helper()
+	es, _ := s.Entries()
+	sort.Slice(es, func(i, j int) bool { return es[i].Key < es[j].Key })
+	return es
+}
+
+func TestSyncConverges(t *testing.T) {
+	a, b := NewMemoryStore(), NewMemoryStore()
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		a.Set(k, []byte("v"))
+		b.Set(k, []byte("v"))
+	}
+	a.Set("only-a", []byte("a"))
+	b.Set("only-b", []byte("b"))
+	a.Set("key-1", []byte("newer")) // version 2 beats b's version 1
+	b.Delete("key-2")               // tombstone version 2 beats a's value
+	b.Set("key-3", []byte("x"))
+	a.Set("key-3", []byte("y")) // same version: larger value wins
+
+	sa, sb := run(t, &Syncer{Store: a}, &Syncer{Store: b})
+	if sa.BucketsDiffered == 0 || sa.BucketsDiffered > 5 || sa.Received != 2 || sa.Sent != 3 || sb.Received != 3 {
+		t.Fatalf("unexpected stats %+v %+v", sa, sb)
+	}
+	ea, eb := contents(t, a), contents(t, b)
+	if len(ea) != len(eb) {
+		t.Fatalf("stores differ in size: %d vs %d", len(ea), len(eb))
+	}
+	for i := range ea {
+		if !ea[i].equal(eb[i]) {
+			t.Fatalf("stores differ at %q: %+v vs %+v", ea[i].Key, ea[i], eb[i])
+		}
+	}
+	if v, _ := b.Get("key-1"); string(v) != "newer" {
+		t.Fatalf("key-1 = %q", v)
+	}
+	if _, ok := a.Get("key-2"); ok {
+		t.Fatal("deletion did not propagate")
+	}
+	if v, _ := b.Get("key-3"); string(v) != "y" {
+		t.Fatalf("key-3 = %q", v)
+	}
+
+	sa, sb = run(t, &Syncer{Store: a}, &Syncer{Store: b})
+	if sa != (Stats{}) || sb != (Stats{}) {
+		t.Fatalf("second sync should be a no-op, got %+v %+v", sa, sb)
+	}
+}
+
+func TestSyncCustomMerge(t *testing.T) {
+	a, b := NewMemoryStore(), NewMemoryStore()
+	a.Set("k", []byte("long value"))
+	b.Set("k", []byte("short"))
+	calls := 0
+	shortest := func(l, r Entry) Entry {
+		calls++
+		if len(r.Value) < len(l.Value) {
+			return r
+		}
+		return l
+	}
+	run(t, &Syncer{Store: a, Merge: shortest}, &Syncer{Store: b})
+	if calls != 1 {
+		t.Fatalf("merge called %d times", calls)
+	}
+	if v, _ := a.Get("k"); string(v) != "short" {
+		t.Fatalf("k = %q", v)
+	}
+}
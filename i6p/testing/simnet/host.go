@@ -0,0 +1,112 @@
+package simnet
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+// Host is a simulated machine. Its name is its address on the network.
+type Host struct {
+	net  *Network
+	name string
+
+	mu       sync.Mutex
+	listener *Listener
+	packets  *PacketConn
+}
+
+// Name returns the host's name.
+func (h *Host) Name() string { return h.name }
+
+// Addr returns the host's address.
+func (h *Host) Addr() net.Addr { return memory.Addr(h.name) }
+
+// Listen makes the host accept connections. A host has at most one listener.
+func (h *Host) Listen() (*Listener, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.listener != nil {
+		return h.listener, nil
+	}
+	h.listener = &Listener{
+		host:    h,
+		backlog: make(chan transport.Conn, 16),
+		done:    make(chan struct{}),
+	}
+	return h.listener, nil
+}
+
+// Dial connects to host to. Connection setup takes one round trip.
+func (h *Host) Dial(ctx context.Context, to string) (transport.Conn, error) {
+	remote, ok := h.net.Host(to)
+	if !ok {
+		return nil, ErrNoHost
+	}
+	remote.mu.Lock()
+	l := remote.listener
+	remote.mu.Unlock()
+	if l == nil {
+		return nil, ErrNotListen
+	}
+
+	t := time.NewTimer(h.net.rtt(h.name, to))
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		t.Stop()
+		return nil, ctx.Err()
+	}
+
+	c, s := memory.Pipe(memory.Addr(h.name), memory.Addr(to))
+	client := &conn{Conn: c, net: h.net, local: h.name, remote: to}
+	server := &conn{Conn: s, net: h.net, local: to, remote: h.name}
+	select {
+	case l.backlog <- server:
+		return client, nil
+	case <-l.done:
+		return nil, ErrNotListen
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Listener accepts connections to a host.
+type Listener struct {
+	host      *Host
+	backlog   chan transport.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ transport.Listener = (*Listener)(nil)
+
+func (l *Listener) Accept(ctx context.Context) (transport.Conn, error) {
+	select {
+	case c := <-l.backlog:
+		return c, nil
+	case <-l.done:
+		return nil, ErrNotListen
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *Listener) Addr() net.Addr { return l.host.Addr() }
+
+// Close stops accepting connections. Established connections are not affected.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		l.host.mu.Lock()
+		if l.host.listener == l {
+			l.host.listener = nil
+		}
+		l.host.mu.Unlock()
+		close(l.done)
+	})
+	return nil
+}
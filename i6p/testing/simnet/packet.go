@@ -0,0 +1,145 @@
+package simnet
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+// packetQueue is how many datagrams a host buffers before dropping new ones.
+const packetQueue = 1024
+
+type datagram struct {
+	data []byte
+	from net.Addr
+}
+
+// PacketConn is a host's unreliable datagram endpoint. Datagrams are delayed,
+// reordered by jitter and dropped according to the link, and dropped when the
+// receiver's queue is full or nobody listens at the destination.
+type PacketConn struct {
+	host  *Host
+	inbox chan datagram
+	done  chan struct{}
+	once  sync.Once
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	wake         chan struct{} // closed and replaced when the read deadline changes
+}
+
+var _ net.PacketConn = (*PacketConn)(nil)
+
+// PacketConn returns the host's datagram endpoint, creating it on first use.
+func (h *Host) PacketConn() *PacketConn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.packets == nil {
+		h.packets = &PacketConn{
+			host:  h,
+			inbox: make(chan datagram, packetQueue),
+			done:  make(chan struct{}),
+			wake:  make(chan struct{}),
+		}
+	}
+	return h.packets
+}
+
+func (p *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		p.mu.Lock()
+		deadline, wake := p.readDeadline, p.wake
+		p.mu.Unlock()
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+		var t *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			t = time.NewTimer(time.Until(deadline))
+			timeout = t.C
+		}
+		select {
+		case d := <-p.inbox:
+			stopTimer(t)
+			return copy(b, d.data), d.from, nil
+		case <-p.done:
+			stopTimer(t)
+			return 0, nil, net.ErrClosed
+		case <-timeout:
+		case <-wake:
+			stopTimer(t)
+		}
+	}
+}
+
+// WriteTo sends b to the host named by addr. Like UDP it reports success even
+// if the datagram is lost on the way.
+func (p *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-p.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	to := addr.String()
+	at, lost := p.host.net.schedule(p.host.name, to, len(b))
+	if lost {
+		return len(b), nil
+	}
+	remote, ok := p.host.net.Host(to)
+	if !ok {
+		return len(b), nil
+	}
+	remote.mu.Lock()
+	dst := remote.packets
+	remote.mu.Unlock()
+	if dst == nil {
+		return len(b), nil
+	}
+	d := datagram{data: append([]byte(nil), b...), from: p.LocalAddr()}
+	time.AfterFunc(time.Until(at), func() {
+		select {
+		case dst.inbox <- d:
+		default:
+		}
+	})
+	return len(b), nil
+}
+
+// Close stops the endpoint; the host gets a new one from its next PacketConn call.
+func (p *PacketConn) Close() error {
+	p.once.Do(func() {
+		p.host.mu.Lock()
+		if p.host.packets == p {
+			p.host.packets = nil
+		}
+		p.host.mu.Unlock()
+		close(p.done)
+	})
+	return nil
+}
+
+func (p *PacketConn) LocalAddr() net.Addr { return memory.Addr(p.host.name) }
+
+func (p *PacketConn) SetDeadline(t time.Time) error { return p.SetReadDeadline(t) }
+
+func (p *PacketConn) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readDeadline = t
+	close(p.wake)
+	p.wake = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline is a no-op: writes never block.
+func (p *PacketConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
@@ -0,0 +1,39 @@
+package simnet
+
+import (
+	"context"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+)
+
+// Peer is an i6p.Peer running on a simulated host.
+type Peer struct {
+	*i6p.Peer
+	Host *Host
+}
+
+// AddPeer creates a host named name with a peer that accepts sessions on it.
+func (n *Network) AddPeer(name string, kp identity.KeyPair, capabilities map[string]string, opts ...i6p.PeerOption) (*Peer, error) {
+	h, err := n.AddHost(name)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := h.Listen()
+	if err != nil {
+		return nil, err
+	}
+	p := i6p.NewPeer(kp, capabilities, opts...)
+	p.Serve(ln)
+	return &Peer{Peer: p, Host: h}, nil
+}
+
+// ConnectTo dials the host named to and runs the client handshake.
+func (p *Peer) ConnectTo(ctx context.Context, to string) (*session.Session, error) {
+	conn, err := p.Host.Dial(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	return p.Connect(ctx, conn)
+}
@@ -0,0 +1,189 @@
+// Package simnet simulates a network of in-process hosts for integration tests.
+//
+// Hosts exchange sessions over the memory transport, with every directed link
+// shaped by a LinkConfig: one-way latency, jitter, loss and bandwidth. Streams
+// stay reliable and ordered, so loss shows up as retransmission delay, while
+// datagrams sent with Host.PacketConn are really dropped, which makes it
+// possible to exercise erasure coding and ARQ logic.
+//
+// Random decisions (loss, jitter) come from a source seeded by New, so a test
+// sees the same sequence of drops for the same seed and traffic order.
+// Delivery itself follows the wall clock.
+package simnet
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	ErrHostExists  = errors.New("simnet: host already exists")
+	ErrNoHost      = errors.New("simnet: no such host")
+	ErrNotListen   = errors.New("simnet: host is not listening")
+	ErrInvalidLink = errors.New("simnet: invalid link config")
+)
+
+// segmentSize is the unit in which stream writes are shaped, roughly one
+// QUIC packet.
+const segmentSize = 1200
+
+// maxRetransmits bounds how often a lost stream segment is resent, so a lossy
+// link slows streams down instead of stalling them forever.
+const maxRetransmits = 8
+
+// LinkConfig describes one direction of a link between two hosts.
+type LinkConfig struct {
+	Latency   time.Duration // one-way delay
+	Jitter    time.Duration // extra delay drawn uniformly from [0, Jitter)
+	Loss      float64       // probability in [0, 1] that a packet is dropped
+	Bandwidth int64         // bytes per second; 0 means unlimited
+}
+
+func (c LinkConfig) validate() error {
+	if c.Latency < 0 || c.Jitter < 0 || c.Loss < 0 || c.Loss > 1 || c.Bandwidth < 0 {
+		return ErrInvalidLink
+	}
+	return nil
+}
+
+// link is the shaping state of one direction between two hosts.
+type link struct {
+	cfg       LinkConfig
+	busyUntil time.Time // when the link finishes sending queued bytes
+}
+
+type linkKey struct{ from, to string }
+
+// Network is a set of simulated hosts and the links between them.
+type Network struct {
+	mu          sync.Mutex
+	rng         *rand.Rand
+	defaultLink LinkConfig
+	links       map[linkKey]*link
+	hosts       map[string]*Host
+}
+
+// New creates an empty network whose random decisions are drawn from seed.
+func New(seed int64) *Network {
+	return &Network{
+		rng:   rand.New(rand.NewSource(seed)),
+		links: make(map[linkKey]*link),
+		hosts: make(map[string]*Host),
+	}
+}
+
+// SetDefaultLink sets the config of links that were not set explicitly.
+func (n *Network) SetDefaultLink(cfg LinkConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.defaultLink = cfg
+	return nil
+}
+
+// SetLink sets the config of both directions between hosts a and b.
+func (n *Network) SetLink(a, b string, cfg LinkConfig) error {
+	if err := n.SetDirectedLink(a, b, cfg); err != nil {
+		return err
+	}
+	return n.SetDirectedLink(b, a, cfg)
+}
+
+// SetDirectedLink sets the config of traffic sent from host from to host to.
+func (n *Network) SetDirectedLink(from, to string, cfg LinkConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.linkLocked(from, to).cfg = cfg
+	return nil
+}
+
+func (n *Network) linkLocked(from, to string) *link {
+	k := linkKey{from, to}
+	l, ok := n.links[k]
+	if !ok {
+		l = &link{cfg: n.defaultLink}
+		n.links[k] = l
+	}
+	return l
+}
+
+// schedule accounts for size bytes sent from one host to another and returns
+// when they arrive and whether they were lost. Bandwidth is shared by all
+// traffic on the directed link.
+func (n *Network) schedule(from, to string, size int) (time.Time, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	l := n.linkLocked(from, to)
+	now := time.Now()
+	start := now
+	if l.busyUntil.After(start) {
+		start = l.busyUntil
+	}
+	if l.cfg.Bandwidth > 0 {
+		l.busyUntil = start.Add(time.Duration(int64(size) * int64(time.Second) / l.cfg.Bandwidth))
+	} else {
+		l.busyUntil = start
+	}
+	at := l.busyUntil.Add(l.cfg.Latency)
+	if l.cfg.Jitter > 0 {
+		at = at.Add(time.Duration(n.rng.Int63n(int64(l.cfg.Jitter))))
+	}
+	lost := l.cfg.Loss > 0 && n.rng.Float64() < l.cfg.Loss
+	return at, lost
+}
+
+// scheduleReliable is schedule for stream data: each loss costs a
+// retransmission timeout of one round trip before the segment is resent.
+func (n *Network) scheduleReliable(from, to string, size int) time.Time {
+	at, lost := n.schedule(from, to, size)
+	for i := 0; lost && i < maxRetransmits; i++ {
+		n.mu.Lock()
+		rto := 2*n.linkLocked(from, to).cfg.Latency + time.Millisecond
+		n.mu.Unlock()
+		var next time.Time
+		next, lost = n.schedule(from, to, size)
+		at = maxTime(at, next).Add(rto)
+	}
+	return at
+}
+
+// rtt returns the round-trip time between two hosts, without jitter.
+func (n *Network) rtt(a, b string) time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.linkLocked(a, b).cfg.Latency + n.linkLocked(b, a).cfg.Latency
+}
+
+// AddHost creates a host with the given name, which is also its address.
+func (n *Network) AddHost(name string) (*Host, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.hosts[name]; ok {
+		return nil, ErrHostExists
+	}
+	h := &Host{net: n, name: name}
+	n.hosts[name] = h
+	return h, nil
+}
+
+// Host returns the host with the given name.
+func (n *Network) Host(name string) (*Host, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	h, ok := n.hosts[name]
+	return h, ok
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
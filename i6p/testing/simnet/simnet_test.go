@@ -0,0 +1,218 @@
+package simnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer/erasure"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+func TestPeersOverSimulatedLink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n := New(1)
+	aKP, _ := identity.GenerateKeyPair()
+	bKP, _ := identity.GenerateKeyPair()
+	a, err := n.AddPeer("a", aKP, nil)
+	if err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	b, _ := n.AddPeer("b", bKP, nil)
+	if err := n.SetLink("a", "b", LinkConfig{Latency: 20 * time.Millisecond}); err != nil {
+		t.Fatalf("SetLink: %v", err)
+	}
+
+	accepted := make(chan *session.Session, 1)
+	go func() {
+		s, _ := b.Accept(ctx)
+		accepted <- s
+	}()
+	start := time.Now()
+	sa, err := a.ConnectTo(ctx, "b")
+	if err != nil {
+		t.Fatalf("ConnectTo: %v", err)
+	}
+	// Connection setup plus the HELLO exchange: two round trips.
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Fatalf("handshake took %v, expected at least 80ms", d)
+	}
+	if sa.RemotePeerID() != bKP.PeerID() {
+		t.Fatalf("unexpected remote peer")
+	}
+	sb := <-accepted
+
+	st, _ := sa.OpenStream(ctx)
+	start = time.Now()
+	_, _ = st.Write([]byte("ping"))
+	_ = st.Close()
+	rst, err := sb.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	got, _ := io.ReadAll(rst)
+	if string(got) != "ping" {
+		t.Fatalf("got %q", got)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("one-way delivery took %v, expected at least 20ms", d)
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n := New(1)
+	a, _ := n.AddHost("a")
+	b, _ := n.AddHost("b")
+	_ = n.SetLink("a", "b", LinkConfig{Bandwidth: 1 << 20}) // 1 MiB/s
+	ln, _ := b.Listen()
+
+	go func() {
+		c, err := ln.Accept(ctx)
+		if err != nil {
+			return
+		}
+		st, err := c.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(io.Discard, st)
+		_ = st.Close()
+	}()
+	c, err := a.Dial(ctx, "b")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	st, _ := c.OpenStreamSync(ctx)
+	start := time.Now()
+	_, _ = st.Write(make([]byte, 100<<10))
+	_ = st.Close()
+	_, _ = io.ReadAll(st)
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("100 KiB at 1 MiB/s took %v", d)
+	}
+}
+
+// received sends count numbered datagrams from a to b and returns which arrived.
+func received(t *testing.T, seed int64, loss float64, count int) []bool {
+	t.Helper()
+	n := New(seed)
+	a, _ := n.AddHost("a")
+	b, _ := n.AddHost("b")
+	_ = n.SetLink("a", "b", LinkConfig{Loss: loss, Latency: time.Millisecond})
+	pa, pb := a.PacketConn(), b.PacketConn()
+	defer pa.Close()
+	defer pb.Close()
+
+	for i := 0; i < count; i++ {
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(i))
+		_, _ = pa.WriteTo(buf[:], b.Addr())
+	}
+	got := make([]bool, count)
+	buf := make([]byte, 16)
+	for {
+		_ = pb.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		n, from, err := pb.ReadFrom(buf)
+		if err != nil {
+			return got
+		}
+		if from.String() != "a" || n != 4 {
+			t.Fatalf("unexpected datagram from %v", from)
+		}
+		got[binary.BigEndian.Uint32(buf)] = true
+	}
+}
+
+func TestPacketLossIsSeeded(t *testing.T) {
+	first := received(t, 42, 0.3, 500)
+	second := received(t, 42, 0.3, 500)
+	lost := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("datagram %d: same seed gave different outcomes", i)
+		}
+		if !first[i] {
+			lost++
+		}
+	}
+	if lost < 100 || lost > 200 {
+		t.Fatalf("lost %d of 500 datagrams at 30%% loss", lost)
+	}
+}
+
+func TestErasureRecoversLostShards(t *testing.T) {
+	codec, err := erasure.NewCodec(10, 4)
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	data := bytes.Repeat([]byte("simnet"), 1000)
+	shards, err := codec.EncodeData(data)
+	if err != nil {
+		t.Fatalf("EncodeData: %v", err)
+	}
+
+	n := New(7)
+	a, _ := n.AddHost("a")
+	b, _ := n.AddHost("b")
+	_ = n.SetLink("a", "b", LinkConfig{Loss: 0.15, Latency: time.Millisecond, Jitter: time.Millisecond})
+	pa, pb := a.PacketConn(), b.PacketConn()
+	defer pa.Close()
+	defer pb.Close()
+
+	for i, shard := range shards {
+		_, _ = pa.WriteTo(append([]byte{byte(i)}, shard...), b.Addr())
+	}
+	got := make([][]byte, len(shards))
+	buf := make([]byte, 64<<10)
+	arrived := 0
+	for {
+		_ = pb.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		n, _, err := pb.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		got[buf[0]] = append([]byte(nil), buf[1:n]...)
+		arrived++
+	}
+	if arrived == len(shards) {
+		t.Fatalf("no shard was lost; pick a seed that drops some")
+	}
+	if err := codec.Reconstruct(got); err != nil {
+		t.Fatalf("Reconstruct with %d/%d shards: %v", arrived, len(shards), err)
+	}
+	out, err := codec.Join(got, len(data))
+	if err != nil || !bytes.Equal(out, data) {
+		t.Fatalf("Join: %v", err)
+	}
+}
+
+func TestDialErrors(t *testing.T) {
+	n := New(1)
+	a, _ := n.AddHost("a")
+	_, _ = n.AddHost("b")
+	if _, err := n.AddHost("a"); err != ErrHostExists {
+		t.Fatalf("expected ErrHostExists, got %v", err)
+	}
+	if _, err := a.Dial(context.Background(), "c"); err != ErrNoHost {
+		t.Fatalf("expected ErrNoHost, got %v", err)
+	}
+	if _, err := a.Dial(context.Background(), "b"); err != ErrNotListen {
+		t.Fatalf("expected ErrNotListen, got %v", err)
+	}
+	if err := n.SetLink("a", "b", LinkConfig{Loss: 2}); err != ErrInvalidLink {
+		t.Fatalf("expected ErrInvalidLink, got %v", err)
+	}
+	if a.Addr() != memory.Addr("a") {
+		t.Fatalf("unexpected address %v", a.Addr())
+	}
+}
@@ -0,0 +1,208 @@
+package simnet
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+// maxQueued bounds the bytes a stream holds in flight before Write blocks.
+const maxQueued = 1 << 20
+
+// conn shapes the streams of a memory connection by the link between its hosts.
+type conn struct {
+	*memory.Conn
+	net           *Network
+	local, remote string
+}
+
+var _ transport.Conn = (*conn)(nil)
+
+func (c *conn) OpenStreamSync(ctx context.Context) (transport.Stream, error) {
+	st, err := c.Conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.shape(st), nil
+}
+
+func (c *conn) AcceptStream(ctx context.Context) (transport.Stream, error) {
+	st, err := c.Conn.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.shape(st), nil
+}
+
+func (c *conn) shape(st transport.Stream) *stream {
+	return &stream{Stream: st, conn: c, wake: make(chan struct{})}
+}
+
+// segment is a piece of stream data, or the FIN when data is nil.
+type segment struct {
+	data []byte
+	at   time.Time
+}
+
+// stream delays its writes as if they crossed the link: Write queues segments
+// with their arrival time and a sender goroutine hands them to the underlying
+// stream in order. Reads are not shaped; the peer's writes already are.
+type stream struct {
+	transport.Stream
+	conn *conn
+
+	mu            sync.Mutex
+	queue         []segment
+	queued        int
+	last          time.Time // arrival of the last queued segment
+	closed        bool
+	err           error
+	writeDeadline time.Time
+	sending       bool
+	wake          chan struct{} // closed and replaced when the queue changes
+}
+
+func (s *stream) signalLocked() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+func (s *stream) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	written := 0
+	for written < len(b) {
+		if s.err != nil {
+			return written, s.err
+		}
+		if s.closed {
+			return written, io.ErrClosedPipe
+		}
+		if !s.writeDeadline.IsZero() && !time.Now().Before(s.writeDeadline) {
+			return written, os.ErrDeadlineExceeded
+		}
+		if s.queued >= maxQueued {
+			s.waitLocked(s.writeDeadline)
+			continue
+		}
+		n := min(segmentSize, len(b)-written)
+		at := s.conn.net.scheduleReliable(s.conn.local, s.conn.remote, n)
+		s.enqueueLocked(segment{data: append([]byte(nil), b[written:written+n]...), at: at})
+		written += n
+	}
+	return written, nil
+}
+
+// Close sends FIN after the data already queued.
+func (s *stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.enqueueLocked(segment{at: s.last})
+	return nil
+}
+
+// CancelWrite drops the queued segments and resets the underlying stream.
+func (s *stream) CancelWrite(code transport.StreamErrorCode) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = &transport.StreamError{Code: code}
+	}
+	s.queue, s.queued = nil, 0
+	s.signalLocked()
+	s.mu.Unlock()
+	s.Stream.CancelWrite(code)
+}
+
+func (s *stream) SetDeadline(t time.Time) error {
+	_ = s.SetWriteDeadline(t)
+	return s.Stream.SetReadDeadline(t)
+}
+
+func (s *stream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeDeadline = t
+	s.signalLocked()
+	return nil
+}
+
+func (s *stream) enqueueLocked(seg segment) {
+	seg.at = maxTime(seg.at, s.last)
+	s.last = seg.at
+	s.queue = append(s.queue, seg)
+	s.queued += len(seg.data)
+	s.signalLocked()
+	if !s.sending {
+		s.sending = true
+		go s.send()
+	}
+}
+
+// waitLocked blocks until the queue changes or deadline passes. Called with
+// s.mu held; returns with it held.
+func (s *stream) waitLocked(deadline time.Time) {
+	wake := s.wake
+	s.mu.Unlock()
+	if deadline.IsZero() {
+		<-wake
+	} else {
+		t := time.NewTimer(time.Until(deadline))
+		select {
+		case <-wake:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+	s.mu.Lock()
+}
+
+// send delivers queued segments at their arrival time. It exits when the
+// queue is empty and is restarted by the next enqueue.
+func (s *stream) send() {
+	done := s.conn.Context().Done()
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 || s.err != nil {
+			s.sending = false
+			s.mu.Unlock()
+			return
+		}
+		seg := s.queue[0]
+		s.mu.Unlock()
+
+		if d := time.Until(seg.at); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-done:
+				t.Stop()
+			}
+		}
+		var err error
+		if seg.data == nil {
+			err = s.Stream.Close()
+		} else {
+			_, err = s.Stream.Write(seg.data)
+		}
+
+		s.mu.Lock()
+		if len(s.queue) > 0 { // CancelWrite may have dropped the queue
+			s.queue = s.queue[1:]
+			s.queued -= len(seg.data)
+		}
+		if err != nil {
+			s.err = err
+		}
+		s.signalLocked()
+		s.mu.Unlock()
+	}
+}
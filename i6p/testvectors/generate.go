@@ -0,0 +1,234 @@
+package testvectors
+
+import (
+	"bytes"
+	"crypto/ed25519"
+
+	"github.com/TheusHen/I6P/i6p/crypto/ratchet"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+)
+
+// fill returns n copies of b.
+func fill(b byte, n int) []byte { return bytes.Repeat([]byte{b}, n) }
+
+// Generate builds a vector set with this implementation from fixed inputs.
+// The deterministic vectors equal the golden ones; tickets and ratchet
+// messages get fresh nonces.
+func Generate() (*Set, error) {
+	s := &Set{FormatVersion: FormatVersion}
+	if err := s.genFrames(); err != nil {
+		return nil, err
+	}
+	if err := s.genHellos(); err != nil {
+		return nil, err
+	}
+	if err := s.genBatches(); err != nil {
+		return nil, err
+	}
+	if err := s.genTickets(); err != nil {
+		return nil, err
+	}
+	if err := s.genRatchets(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func encodeFrame(f protocol.Frame) ([]byte, error) {
+	var b bytes.Buffer
+	err := protocol.WriteFrame(&b, f)
+	return b.Bytes(), err
+}
+
+func (s *Set) genFrames() error {
+	frames := []struct {
+		name string
+		f    protocol.Frame
+	}{
+		{"ping", protocol.NewPingFrame(1)},
+		{"pong", protocol.NewPongFrame(0x0102030405060708)},
+		{"goaway", protocol.NewGoAwayFrame("draining")},
+		{"window_update", protocol.NewWindowUpdateFrame(1, 64)},
+		{"ack", protocol.NewAckFrame(1, 16)},
+		{"data_empty", protocol.Frame{Type: protocol.MessageTypeData}},
+		{"ping_v2", withVersion(protocol.NewPingFrame(2), protocol.Version2)},
+		{"get_chunks", mustFrame(protocol.NewGetChunksFrame(protocol.ChunkRequest{Root: vectorRoot, Indexes: []uint32{0, 7}}))},
+		{"chunk_data", mustFrame(protocol.NewChunkDataFrame(protocol.ChunkData{Root: vectorRoot, Index: 7, Hash: fill(0x48, 32), Data: []byte("chunk")}))},
+		{"chunks_unavailable", mustFrame(protocol.NewChunksUnavailableFrame(protocol.ChunkRequest{Root: vectorRoot, Indexes: []uint32{0}}))},
+	}
+	for _, fr := range frames {
+		enc, err := encodeFrame(fr.f)
+		if err != nil {
+			return err
+		}
+		s.Frames = append(s.Frames, FrameVector{
+			Name:    fr.name,
+			Type:    uint8(fr.f.Type),
+			Version: fr.f.Version,
+			Payload: fr.f.Payload,
+			Encoded: enc,
+		})
+	}
+	return nil
+}
+
+// vectorRoot is the transfer root used by the chunk frame vectors.
+var vectorRoot = fill(0x52, 32)
+
+// mustFrame unwraps frame constructors, which cannot fail on fixed inputs.
+func mustFrame(f protocol.Frame, err error) protocol.Frame {
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func withVersion(f protocol.Frame, v uint8) protocol.Frame {
+	f.Version = v
+	return f
+}
+
+func keyPairFromSeed(seed []byte) (identity.KeyPair, error) {
+	priv := ed25519.NewKeyFromSeed(seed)
+	return identity.NewKeyPair(priv.Public().(ed25519.PublicKey), priv)
+}
+
+// buildHello builds the HELLO described by v, without its outputs.
+func buildHello(v HelloVector) (protocol.Hello, identity.KeyPair, error) {
+	kp, err := keyPairFromSeed(v.Seed)
+	if err != nil {
+		return protocol.Hello{}, identity.KeyPair{}, err
+	}
+	h := protocol.Hello{
+		PeerID:       kp.PeerID().String(),
+		PublicKey:    append([]byte(nil), kp.PublicKey...),
+		TimestampSec: v.TimestampSec,
+		Nonce:        v.Nonce,
+		Capabilities: v.Capabilities,
+		Versions:     v.Versions,
+		Version:      v.Version,
+	}
+	return h, kp, nil
+}
+
+func (s *Set) genHellos() error {
+	inputs := []HelloVector{
+		{Name: "no_capabilities", Seed: fill(0x01, 32), TimestampSec: 1700000000, Nonce: fill(0x02, 32)},
+		{Name: "capabilities", Seed: fill(0x11, 32), TimestampSec: 1700000001, Nonce: fill(0x12, 32),
+			Capabilities: map[string]string{"agent": "i6p", "transfer": "v1"}},
+		{Name: "versions_offer", Seed: fill(0x21, 32), TimestampSec: 1700000002, Nonce: fill(0x22, 32),
+			Versions: []byte{protocol.Version2, protocol.Version1}},
+		{Name: "versions_selected", Seed: fill(0x31, 32), TimestampSec: 1700000003, Nonce: fill(0x32, 32),
+			Versions: []byte{protocol.Version2, protocol.Version1}, Version: protocol.Version2},
+	}
+	for _, v := range inputs {
+		h, kp, err := buildHello(v)
+		if err != nil {
+			return err
+		}
+		if v.SigningBytes, err = h.SigningBytes(); err != nil {
+			return err
+		}
+		if err := h.Sign(kp); err != nil {
+			return err
+		}
+		v.Signature, v.VersionSig = h.Signature, h.VersionSignature
+		if v.Encoded, err = protocol.EncodeHello(h); err != nil {
+			return err
+		}
+		s.Hellos = append(s.Hellos, v)
+	}
+	return nil
+}
+
+func (s *Set) genBatches() error {
+	plain := []byte("hello, i6p")
+	text := bytes.Repeat([]byte("I6P "), 1024)
+	batches := []struct {
+		name   string
+		chunks []transfer.CompressedChunk
+	}{
+		{"empty", nil},
+		{"mixed", []transfer.CompressedChunk{
+			{Index: 0, Data: plain, OrigHash: transfer.HashChunk(plain)},
+			transfer.CompressChunk(transfer.Chunk{Index: 7, Data: text, Hash: transfer.HashChunk(text)}, transfer.CompressionFast),
+		}},
+	}
+	for _, bt := range batches {
+		b := transfer.NewBatch()
+		v := BatchVector{Name: bt.name, Chunks: []BatchChunk{}}
+		for _, cc := range bt.chunks {
+			b.Add(cc)
+			v.Chunks = append(v.Chunks, BatchChunk{Index: cc.Index, Compressed: cc.Compressed, Data: cc.Data, OrigHash: cc.OrigHash})
+		}
+		enc, err := b.Encode()
+		if err != nil {
+			return err
+		}
+		v.Encoded = enc
+		s.Batches = append(s.Batches, v)
+	}
+	return nil
+}
+
+func (s *Set) genTickets() error {
+	kp, err := keyPairFromSeed(fill(0x01, 32))
+	if err != nil {
+		return err
+	}
+	id := kp.PeerID()
+	v := TicketVector{
+		Name:       "basic",
+		Key:        fill(0x03, session.TicketKeySize),
+		ID:         fill(0x04, 16),
+		PeerID:     id[:],
+		IssuedAt:   1700000000,
+		ExpiresAt:  4102444800, // 2100-01-01
+		SessionKey: fill(0x05, 32),
+	}
+	var key [session.TicketKeySize]byte
+	copy(key[:], v.Key)
+	t := &session.Ticket{IssuedAt: v.IssuedAt, ExpiresAt: v.ExpiresAt, PeerID: id}
+	copy(t.ID[:], v.ID)
+	copy(t.SessionKey[:], v.SessionKey)
+	if v.Encoded, err = session.NewTicketStoreWithKey(key).EncodeTicket(t); err != nil {
+		return err
+	}
+	s.Tickets = append(s.Tickets, v)
+	return nil
+}
+
+func (s *Set) genRatchets() error {
+	inputs := []RatchetVector{
+		{Name: "hkdf", InitialKey: fill(0x06, 32), Version: ratchet.CurrentVersion},
+		{Name: "hkdf_salted", InitialKey: fill(0x07, 32), Version: ratchet.CurrentVersion, Salt: []byte("i6p session salt")},
+		{Name: "legacy", InitialKey: fill(0x08, 32), Version: 0},
+	}
+	plaintexts := [][]byte{[]byte("first"), []byte("second"), {}}
+	for _, v := range inputs {
+		c, err := ratchet.NewChainWithOptions(v.InitialKey, ratchet.ChainOptions{Version: v.Version, Salt: v.Salt})
+		if err != nil {
+			return err
+		}
+		for _, p := range plaintexts {
+			ad := []byte("i6p")
+			m, err := c.Seal(p, ad)
+			if err != nil {
+				return err
+			}
+			v.Messages = append(v.Messages, RatchetMessage{
+				Generation: m.Generation,
+				Counter:    m.Counter,
+				Header:     m.AppendHeader(nil),
+				AD:         ad,
+				Plaintext:  p,
+				Encoded:    m.Encode(),
+			})
+		}
+		s.Ratchets = append(s.Ratchets, v)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package testvectors holds golden byte-level encodings of the I6P wire
+// formats, so that implementations in other languages can check that they
+// interoperate with this one.
+//
+// The vectors live in vectors.json, which is the frozen reference: Verify
+// checks an implementation against a set of vectors, and the package tests
+// fail if the Go implementation stops producing or accepting them. Changing
+// a wire format therefore means bumping FormatVersion and regenerating the
+// file deliberately (go test ./i6p/testvectors -update).
+//
+// Deterministic formats (frames, HELLO, batches, ratchet headers) must match
+// byte for byte in both directions. Encrypted formats (tickets, ratchet
+// messages) use random nonces, so only decryption of the recorded bytes is
+// checked.
+package testvectors
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// FormatVersion identifies the revision of the wire formats the vectors
+// describe. It changes whenever any encoding changes.
+const FormatVersion = 3
+
+//go:embed vectors.json
+var golden []byte
+
+// Hex is a byte string encoded as lowercase hex in JSON.
+type Hex []byte
+
+func (h Hex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *Hex) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	d, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*h = d
+	return nil
+}
+
+// Set is a complete collection of vectors.
+type Set struct {
+	FormatVersion int             `json:"format_version"`
+	Frames        []FrameVector   `json:"frames"`
+	Hellos        []HelloVector   `json:"hellos"`
+	Batches       []BatchVector   `json:"batches"`
+	Tickets       []TicketVector  `json:"tickets"`
+	Ratchets      []RatchetVector `json:"ratchets"`
+}
+
+// FrameVector is a control stream frame.
+type FrameVector struct {
+	Name    string `json:"name"`
+	Type    uint8  `json:"type"`
+	Version uint8  `json:"version,omitempty"` // zero: Version1 header
+	Payload Hex    `json:"payload"`
+	Encoded Hex    `json:"encoded"`
+}
+
+// HelloVector is a signed HELLO built from an Ed25519 seed.
+type HelloVector struct {
+	Name         string            `json:"name"`
+	Seed         Hex               `json:"seed"`
+	TimestampSec int64             `json:"timestamp_sec"`
+	Nonce        Hex               `json:"nonce"`
+	Capabilities map[string]string `json:"capabilities,omitempty"`
+	Versions     Hex               `json:"versions,omitempty"` // offered versions
+	Version      uint8             `json:"version,omitempty"`  // selected version
+	SigningBytes Hex               `json:"signing_bytes"`
+	Signature    Hex               `json:"signature,omitempty"`         // over the Version1 signing bytes
+	VersionSig   Hex               `json:"version_signature,omitempty"` // over SigningBytes, under "i6p-hello"
+	Encoded      Hex               `json:"encoded"`                     // HELLO frame payload
+}
+
+// BatchChunk is one chunk of a BatchVector, as carried on the wire.
+type BatchChunk struct {
+	Index      int  `json:"index"`
+	Compressed bool `json:"compressed"`
+	Data       Hex  `json:"data"`
+	OrigHash   Hex  `json:"orig_hash"`
+}
+
+// BatchVector is an encoded chunk batch (without the stream length prefix).
+type BatchVector struct {
+	Name    string       `json:"name"`
+	Chunks  []BatchChunk `json:"chunks"`
+	Encoded Hex          `json:"encoded"`
+}
+
+// TicketVector is a resumption ticket sealed under Key.
+type TicketVector struct {
+	Name       string `json:"name"`
+	Key        Hex    `json:"key"`
+	ID         Hex    `json:"id"`
+	PeerID     Hex    `json:"peer_id"`
+	IssuedAt   int64  `json:"issued_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+	SessionKey Hex    `json:"session_key"`
+	Encoded    Hex    `json:"encoded"`
+}
+
+// RatchetMessage is one message of a RatchetVector.
+type RatchetMessage struct {
+	Generation uint64 `json:"generation"`
+	Counter    uint32 `json:"counter"`
+	Header     Hex    `json:"header"`
+	AD         Hex    `json:"ad"`
+	Plaintext  Hex    `json:"plaintext"`
+	Encoded    Hex    `json:"encoded"`
+}
+
+// RatchetVector is a sequence of messages sealed by one ratchet chain.
+type RatchetVector struct {
+	Name       string           `json:"name"`
+	InitialKey Hex              `json:"initial_key"`
+	Version    uint8            `json:"version"`
+	Salt       Hex              `json:"salt,omitempty"`
+	Messages   []RatchetMessage `json:"messages"`
+}
+
+// Load returns the golden vectors.
+func Load() (*Set, error) {
+	var s Set
+	if err := json.Unmarshal(golden, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Golden returns the raw contents of vectors.json, for publishing to other
+// implementations.
+func Golden() []byte {
+	return append([]byte(nil), golden...)
+}
@@ -0,0 +1,71 @@
+package testvectors
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "regenerate vectors.json")
+
+func TestGoldenVectors(t *testing.T) {
+	if *update {
+		s, err := Generate()
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		b, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile("vectors.json", append(b, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		golden = b
+	}
+	s, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(s.Frames) == 0 || len(s.Hellos) == 0 || len(s.Batches) == 0 || len(s.Tickets) == 0 || len(s.Ratchets) == 0 {
+		t.Fatal("golden set is missing vector kinds")
+	}
+	for _, f := range Verify(s) {
+		t.Error(f)
+	}
+}
+
+// The deterministic encodings must not drift from the golden file; tickets
+// and ratchet messages differ only in their random nonces.
+func TestGenerateMatchesGolden(t *testing.T) {
+	s, _ := Load()
+	g, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	enc := func(v any) []byte { b, _ := json.Marshal(v); return b }
+	if !bytes.Equal(enc(g.Frames), enc(s.Frames)) {
+		t.Error("frame encodings changed")
+	}
+	if !bytes.Equal(enc(g.Hellos), enc(s.Hellos)) {
+		t.Error("HELLO encodings changed")
+	}
+	if !bytes.Equal(enc(g.Batches), enc(s.Batches)) {
+		t.Error("batch encodings changed")
+	}
+	for _, f := range Verify(g) {
+		t.Error(f)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	s, _ := Load()
+	s.Frames[0].Encoded[len(s.Frames[0].Encoded)-1] ^= 1
+	s.Tickets[0].Encoded[20] ^= 1
+	s.Ratchets[0].Messages[0].Plaintext = []byte("other")
+	if n := len(Verify(s)); n != 3 {
+		t.Fatalf("expected 3 failures, got %d", n)
+	}
+}
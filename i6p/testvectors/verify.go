@@ -0,0 +1,201 @@
+package testvectors
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/TheusHen/I6P/i6p/crypto/ratchet"
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transfer"
+)
+
+var errMismatch = errors.New("mismatch")
+
+// Failure is a vector this implementation did not reproduce or accept.
+type Failure struct {
+	Kind string // "frame", "hello", "batch", "ticket" or "ratchet"
+	Name string
+	Err  error
+}
+
+func (f Failure) Error() string {
+	return fmt.Sprintf("%s %q: %v", f.Kind, f.Name, f.Err)
+}
+
+func mismatch(what string) error {
+	return fmt.Errorf("%w: %s", errMismatch, what)
+}
+
+// Verify checks this implementation against every vector of s, encoding the
+// inputs and decoding the recorded bytes, and returns the failures.
+func Verify(s *Set) []Failure {
+	if s.FormatVersion != FormatVersion {
+		return []Failure{{Kind: "set", Err: fmt.Errorf("format version %d, implementation has %d", s.FormatVersion, FormatVersion)}}
+	}
+	var out []Failure
+	check := func(kind, name string, err error) {
+		if err != nil {
+			out = append(out, Failure{Kind: kind, Name: name, Err: err})
+		}
+	}
+	for _, v := range s.Frames {
+		check("frame", v.Name, verifyFrame(v))
+	}
+	for _, v := range s.Hellos {
+		check("hello", v.Name, verifyHello(v))
+	}
+	for _, v := range s.Batches {
+		check("batch", v.Name, verifyBatch(v))
+	}
+	for _, v := range s.Tickets {
+		check("ticket", v.Name, verifyTicket(v))
+	}
+	for _, v := range s.Ratchets {
+		check("ratchet", v.Name, verifyRatchet(v))
+	}
+	return out
+}
+
+func verifyFrame(v FrameVector) error {
+	f := protocol.Frame{Type: protocol.MessageType(v.Type), Payload: v.Payload, Version: v.Version}
+	enc, err := encodeFrame(f)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(enc, v.Encoded) {
+		return mismatch("encoding")
+	}
+	got, err := protocol.ReadFrame(bytes.NewReader(v.Encoded))
+	if err != nil {
+		return err
+	}
+	if got.Type != f.Type || got.Version != v.Version || !bytes.Equal(got.Payload, v.Payload) {
+		return mismatch("decoded frame")
+	}
+	return nil
+}
+
+func verifyHello(v HelloVector) error {
+	h, kp, err := buildHello(v)
+	if err != nil {
+		return err
+	}
+	sb, err := h.SigningBytes()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sb, v.SigningBytes) {
+		return mismatch("signing bytes")
+	}
+	if err := h.Sign(kp); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Signature, v.Signature) {
+		return mismatch("signature")
+	}
+	if !bytes.Equal(h.VersionSignature, v.VersionSig) {
+		return mismatch("version signature")
+	}
+	enc, err := protocol.EncodeHello(h)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(enc, v.Encoded) {
+		return mismatch("encoding")
+	}
+	got, err := protocol.DecodeHello(v.Encoded)
+	if err != nil {
+		return err
+	}
+	if err := got.Verify(); err != nil {
+		return err
+	}
+	if got.PeerID != h.PeerID || got.TimestampSec != v.TimestampSec || !bytes.Equal(got.Nonce, v.Nonce) ||
+		!bytes.Equal(got.Versions, v.Versions) || got.Version != v.Version {
+		return mismatch("decoded HELLO")
+	}
+	return nil
+}
+
+func verifyBatch(v BatchVector) error {
+	b := transfer.NewBatch()
+	for _, c := range v.Chunks {
+		b.Add(transfer.CompressedChunk{Index: c.Index, Compressed: c.Compressed, Data: c.Data, OrigHash: c.OrigHash})
+	}
+	enc, err := b.Encode()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(enc, v.Encoded) {
+		return mismatch("encoding")
+	}
+	got, err := transfer.DecodeBatch(v.Encoded)
+	if err != nil {
+		return err
+	}
+	if len(got.Chunks) != len(v.Chunks) {
+		return mismatch("chunk count")
+	}
+	for i, cc := range got.Chunks {
+		want := v.Chunks[i]
+		if cc.Index != want.Index || cc.Compressed != want.Compressed ||
+			!bytes.Equal(cc.Data, want.Data) || !bytes.Equal(cc.OrigHash, want.OrigHash) {
+			return mismatch(fmt.Sprintf("chunk %d", i))
+		}
+		if _, err := transfer.DecompressChunk(cc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyTicket(v TicketVector) error {
+	var key [session.TicketKeySize]byte
+	if len(v.Key) != len(key) {
+		return mismatch("key size")
+	}
+	copy(key[:], v.Key)
+	t, err := session.NewTicketStoreWithKey(key).DecodeTicket(v.Encoded)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(t.ID[:], v.ID) || !bytes.Equal(t.PeerID[:], v.PeerID) || t.IssuedAt != v.IssuedAt ||
+		t.ExpiresAt != v.ExpiresAt || !bytes.Equal(t.SessionKey[:], v.SessionKey) {
+		return mismatch("decoded ticket")
+	}
+	// Tickets with the clear header must carry the same times in it.
+	if p, err := session.PeekTicket(v.Encoded); err == nil && (p.IssuedAt != v.IssuedAt || p.ExpiresAt != v.ExpiresAt) {
+		return mismatch("ticket header")
+	}
+	return nil
+}
+
+func verifyRatchet(v RatchetVector) error {
+	r, err := ratchet.NewReceiverWithOptions(v.InitialKey, 16, ratchet.ChainOptions{Version: v.Version, Salt: v.Salt})
+	if err != nil {
+		return err
+	}
+	for i, m := range v.Messages {
+		want := ratchet.EncryptedMessage{Version: v.Version, Generation: m.Generation, Counter: m.Counter}
+		if !bytes.Equal(want.AppendHeader(nil), m.Header) || !bytes.HasPrefix(m.Encoded, m.Header) {
+			return mismatch(fmt.Sprintf("message %d header", i))
+		}
+		msg, err := ratchet.DecodeEncryptedMessage(m.Encoded)
+		if err != nil {
+			return err
+		}
+		if msg.Version != v.Version || msg.Generation != m.Generation || msg.Counter != m.Counter {
+			return mismatch(fmt.Sprintf("message %d decoded header", i))
+		}
+		p, err := r.Open(msg, m.AD)
+		if err != nil {
+			return fmt.Errorf("message %d: %w", i, err)
+		}
+		if !bytes.Equal(p, m.Plaintext) {
+			return mismatch(fmt.Sprintf("message %d plaintext", i))
+		}
+	}
+	return nil
+}
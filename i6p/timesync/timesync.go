@@ -0,0 +1,75 @@
+// Package timesync estimates the offset between a local clock and a peer's
+// from request/response round trips, as NTP does, without a time server.
+//
+// Each round trip yields a Sample of four timestamps: T1 when the request
+// left, T2 when the peer received it and T3 when the peer answered, both on
+// the peer's clock, and T4 when the answer arrived. Network delay inflates
+// the error of a sample, so Estimate keeps the sample with the smallest
+// round-trip delay. session.Session.ClockOffset collects the samples over
+// the control stream.
+package timesync
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNoSamples = errors.New("timesync: no samples")
+
+// Sample is one timed round trip.
+type Sample struct {
+	T1 time.Time // request sent, local clock
+	T2 time.Time // request received, remote clock
+	T3 time.Time // response sent, remote clock
+	T4 time.Time // response received, local clock
+}
+
+// Offset returns how far the remote clock is ahead of the local one, assuming
+// the request and the response took equally long.
+func (s Sample) Offset() time.Duration {
+	return (s.T2.Sub(s.T1) + s.T3.Sub(s.T4)) / 2
+}
+
+// Delay returns the round-trip time, minus the time the peer held the
+// request.
+func (s Sample) Delay() time.Duration {
+	return s.T4.Sub(s.T1) - s.T3.Sub(s.T2)
+}
+
+// Estimate is the offset to a peer's clock.
+type Estimate struct {
+	Offset  time.Duration // remote clock minus local clock
+	Delay   time.Duration // round-trip delay of the sample used
+	Samples int           // samples considered
+	At      time.Time     // local time the sample used completed
+}
+
+// Error bounds how far Offset can be from the true offset: half the delay,
+// the case where one direction took the whole round trip.
+func (e Estimate) Error() time.Duration { return e.Delay / 2 }
+
+// ToLocal converts a time read from the remote clock to the local clock.
+func (e Estimate) ToLocal(remote time.Time) time.Time { return remote.Add(-e.Offset) }
+
+// ToRemote converts a local time to the remote clock.
+func (e Estimate) ToRemote(local time.Time) time.Time { return local.Add(e.Offset) }
+
+// Compute returns the estimate from the sample with the smallest delay.
+// Samples with a negative delay, which only clock steps during the round
+// trip can produce, are ignored.
+func Compute(samples []Sample) (Estimate, error) {
+	var best *Sample
+	for i := range samples {
+		s := &samples[i]
+		if s.Delay() < 0 {
+			continue
+		}
+		if best == nil || s.Delay() < best.Delay() {
+			best = s
+		}
+	}
+	if best == nil {
+		return Estimate{}, ErrNoSamples
+	}
+	return Estimate{Offset: best.Offset(), Delay: best.Delay(), Samples: len(samples), At: best.T4}, nil
+}
@@ -0,0 +1,41 @@
+package timesync
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCompute(t *testing.T) {
+	base := time.Unix(1_700_000_000, 0)
+	// sample builds a round trip to a clock ahead by offset, with the given
+	// one-way delays and no processing time at the peer.
+	sample := func(at time.Time, offset, up, down time.Duration) Sample {
+		t2 := at.Add(up).Add(offset)
+		return Sample{T1: at, T2: t2, T3: t2, T4: at.Add(up + down)}
+	}
+	const offset = 3 * time.Second
+	samples := []Sample{
+		sample(base, offset, 40*time.Millisecond, 10*time.Millisecond), // asymmetric: biased
+		sample(base.Add(time.Second), offset, 2*time.Millisecond, 2*time.Millisecond),
+		sample(base.Add(2*time.Second), offset, 30*time.Millisecond, 30*time.Millisecond),
+	}
+	if got := samples[0].Offset(); got != offset+15*time.Millisecond {
+		t.Fatalf("biased sample offset %v", got)
+	}
+	est, err := Compute(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.Offset != offset || est.Delay != 4*time.Millisecond || est.Error() != 2*time.Millisecond || est.Samples != 3 {
+		t.Fatalf("estimate %+v", est)
+	}
+	if !est.ToLocal(est.ToRemote(base)).Equal(base) || !est.ToRemote(base).Equal(base.Add(offset)) {
+		t.Fatal("conversion")
+	}
+
+	stepped := Sample{T1: base, T2: base, T3: base.Add(time.Second), T4: base.Add(time.Millisecond)}
+	if _, err := Compute([]Sample{stepped}); !errors.Is(err, ErrNoSamples) {
+		t.Fatalf("negative delay: %v", err)
+	}
+}
@@ -1,13 +1,21 @@
 package transfer
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"io"
+	"net"
+	"os"
+	"time"
 )
 
 var (
 	ErrBatchTooLarge = errors.New("transfer: batch exceeds maximum size")
+	ErrBatchTimeout  = errors.New("transfer: batch read/write timed out")
+
+	errBatchTruncated = errors.New("transfer: batch truncated")
 )
 
 const (
@@ -15,12 +23,17 @@ const (
 	MaxBatchSize = 4 * 1024 * 1024
 	// BatchMagic identifies a batch frame.
 	BatchMagic = uint32(0x49365042) // "I6PB"
+	// BatchMagicMeta identifies a batch frame with metadata sections.
+	BatchMagicMeta = uint32(0x4936504D) // "I6PM"
 )
 
 // Batch groups multiple chunks for efficient transmission.
 // This reduces per-chunk overhead and syscall frequency.
 type Batch struct {
 	Chunks []CompressedChunk
+	// Meta tags the whole batch, e.g. with MetaFileID. Chunks carry
+	// their own in CompressedChunk.Meta.
+	Meta Metadata
 }
 
 // NewBatch creates an empty batch.
@@ -33,12 +46,33 @@ func (b *Batch) Add(cc CompressedChunk) {
 	b.Chunks = append(b.Chunks, cc)
 }
 
+// hasMeta reports whether b needs the metadata format. Batches without
+// metadata keep the original format, which older peers decode.
+func (b *Batch) hasMeta() bool {
+	if len(b.Meta) > 0 {
+		return true
+	}
+	for _, cc := range b.Chunks {
+		if len(cc.Meta) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // Size returns the total serialized size of the batch.
 func (b *Batch) Size() int {
+	meta := b.hasMeta()
 	size := 4 + 4 // magic + count
+	if meta {
+		size += 2 + b.Meta.size()
+	}
 	for _, cc := range b.Chunks {
-		// index(4) + compressed(1) + hashLen(2) + hash + dataLen(4) + data
+		// index(4) + codec(1) + hashLen(2) + hash + dataLen(4) + data
 		size += 4 + 1 + 2 + len(cc.OrigHash) + 4 + len(cc.Data)
+		if meta {
+			size += 2 + cc.Meta.size()
+		}
 	}
 	return size
 }
@@ -47,108 +81,140 @@ func (b *Batch) Size() int {
 // Format:
 //
 //	4 bytes: magic
+//	[metadata] batch metadata, with BatchMagicMeta only
 //	4 bytes: chunk count
 //	For each chunk:
 //		4 bytes: index
-//		1 byte: compressed flag
+//		1 byte: codec ID (0: uncompressed)
 //		2 bytes: hash length
 //		N bytes: hash
+//		[metadata] chunk metadata, with BatchMagicMeta only
 //		4 bytes: data length
 //		N bytes: data
+//
+// A metadata section is a 2-byte length followed by entries of 2 bytes
+// type, 2 bytes value length and the value. Batches without metadata are
+// encoded with BatchMagic and no sections.
 func (b *Batch) Encode() ([]byte, error) {
 	size := b.Size()
 	if size > MaxBatchSize {
 		return nil, ErrBatchTooLarge
 	}
-
-	buf := make([]byte, size)
-	offset := 0
-
-	binary.BigEndian.PutUint32(buf[offset:], BatchMagic)
-	offset += 4
-	binary.BigEndian.PutUint32(buf[offset:], uint32(len(b.Chunks)))
-	offset += 4
+	meta := b.hasMeta()
+
+	buf := make([]byte, 0, size)
+	var err error
+	if meta {
+		buf = binary.BigEndian.AppendUint32(buf, BatchMagicMeta)
+		if buf, err = appendMeta(buf, b.Meta); err != nil {
+			return nil, err
+		}
+	} else {
+		buf = binary.BigEndian.AppendUint32(buf, BatchMagic)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(b.Chunks)))
 
 	for _, cc := range b.Chunks {
-		binary.BigEndian.PutUint32(buf[offset:], uint32(cc.Index))
-		offset += 4
-
-		if cc.Compressed {
-			buf[offset] = 1
-		} else {
-			buf[offset] = 0
+		buf = binary.BigEndian.AppendUint32(buf, uint32(cc.Index))
+		buf = append(buf, byte(cc.CodecID()))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(cc.OrigHash)))
+		buf = append(buf, cc.OrigHash...)
+		if meta {
+			if buf, err = appendMeta(buf, cc.Meta); err != nil {
+				return nil, err
+			}
 		}
-		offset++
-
-		binary.BigEndian.PutUint16(buf[offset:], uint16(len(cc.OrigHash)))
-		offset += 2
-		copy(buf[offset:], cc.OrigHash)
-		offset += len(cc.OrigHash)
-
-		binary.BigEndian.PutUint32(buf[offset:], uint32(len(cc.Data)))
-		offset += 4
-		copy(buf[offset:], cc.Data)
-		offset += len(cc.Data)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(cc.Data)))
+		buf = append(buf, cc.Data...)
 	}
 
 	return buf, nil
 }
 
-// DecodeBatch deserializes a batch from wire format.
+// DecodeBatch deserializes a batch from wire format. Metadata entries of
+// unknown types are kept, not rejected.
 func DecodeBatch(data []byte) (*Batch, error) {
 	if len(data) < 8 {
 		return nil, errors.New("transfer: batch too short")
 	}
 
-	magic := binary.BigEndian.Uint32(data[:4])
-	if magic != BatchMagic {
+	var meta bool
+	switch binary.BigEndian.Uint32(data[:4]) {
+	case BatchMagic:
+	case BatchMagicMeta:
+		meta = true
+	default:
 		return nil, errors.New("transfer: invalid batch magic")
 	}
+	offset := 4
 
-	count := binary.BigEndian.Uint32(data[4:8])
-	offset := 8
+	b := &Batch{}
+	if meta {
+		m, n, err := decodeMeta(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		b.Meta = m
+		offset += n
+		if offset+4 > len(data) {
+			return nil, errBatchTruncated
+		}
+	}
 
-	b := &Batch{Chunks: make([]CompressedChunk, 0, count)}
+	count := binary.BigEndian.Uint32(data[offset:])
+	offset += 4
+	// Every chunk takes at least 11 bytes, which bounds the allocation.
+	b.Chunks = make([]CompressedChunk, 0, min(int(count), (len(data)-offset)/11))
 
 	for i := uint32(0); i < count; i++ {
 		if offset+4+1+2 > len(data) {
-			return nil, errors.New("transfer: batch truncated")
+			return nil, errBatchTruncated
 		}
 
 		index := int(binary.BigEndian.Uint32(data[offset:]))
 		offset += 4
 
-		compressed := data[offset] == 1
+		codec := CodecID(data[offset])
 		offset++
 
 		hashLen := int(binary.BigEndian.Uint16(data[offset:]))
 		offset += 2
 
-		if offset+hashLen+4 > len(data) {
-			return nil, errors.New("transfer: batch truncated")
+		if offset+hashLen > len(data) {
+			return nil, errBatchTruncated
 		}
 
 		hash := make([]byte, hashLen)
 		copy(hash, data[offset:offset+hashLen])
 		offset += hashLen
 
+		var m Metadata
+		if meta {
+			var n int
+			var err error
+			if m, n, err = decodeMeta(data[offset:]); err != nil {
+				return nil, err
+			}
+			offset += n
+		}
+
+		if offset+4 > len(data) {
+			return nil, errBatchTruncated
+		}
 		dataLen := int(binary.BigEndian.Uint32(data[offset:]))
 		offset += 4
 
 		if offset+dataLen > len(data) {
-			return nil, errors.New("transfer: batch truncated")
+			return nil, errBatchTruncated
 		}
 
 		chunkData := make([]byte, dataLen)
 		copy(chunkData, data[offset:offset+dataLen])
 		offset += dataLen
 
-		b.Chunks = append(b.Chunks, CompressedChunk{
-			Index:      index,
-			Compressed: compressed,
-			Data:       chunkData,
-			OrigHash:   hash,
-		})
+		cc := chunkWithCodec(index, codec, chunkData, hash)
+		cc.Meta = m
+		b.Chunks = append(b.Chunks, cc)
 	}
 
 	return b, nil
@@ -170,6 +236,10 @@ func WriteBatch(w io.Writer, b *Batch) error {
 	return err
 }
 
+// batchReadChunk bounds the up-front allocation for a batch body, so a peer that
+// announces a large batch and then stalls does not pin MaxBatchSize bytes.
+const batchReadChunk = 64 * 1024
+
 // ReadBatch reads a batch from a reader.
 func ReadBatch(r io.Reader) (*Batch, error) {
 	var lenBuf [4]byte
@@ -180,9 +250,121 @@ func ReadBatch(r io.Reader) (*Batch, error) {
 	if dataLen > MaxBatchSize {
 		return nil, ErrBatchTooLarge
 	}
-	data := make([]byte, dataLen)
-	if _, err := io.ReadFull(r, data); err != nil {
+	buf := bytes.NewBuffer(make([]byte, 0, min(int(dataLen), batchReadChunk)))
+	if _, err := io.CopyN(buf, r, int64(dataLen)); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
-	return DecodeBatch(data)
+	return DecodeBatch(buf.Bytes())
+}
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// ReadBatchContext is ReadBatch bounded by ctx. Readers with SetReadDeadline
+// (QUIC streams, net.Conn) get the context deadline passed through and are
+// unblocked on cancellation. Other readers are read on a helper goroutine that
+// is abandoned, not stopped, when ctx ends.
+// Returns ErrBatchTimeout when the deadline passes mid-batch.
+func ReadBatchContext(ctx context.Context, r io.Reader) (*Batch, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, batchContextErr(err)
+	}
+	rd, ok := r.(readDeadliner)
+	if !ok {
+		type result struct {
+			b   *Batch
+			err error
+		}
+		ch := make(chan result, 1)
+		go func() {
+			b, err := ReadBatch(r)
+			ch <- result{b, err}
+		}()
+		select {
+		case res := <-ch:
+			return res.b, res.err
+		case <-ctx.Done():
+			return nil, batchContextErr(ctx.Err())
+		}
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = rd.SetReadDeadline(dl)
+	}
+	defer func() {
+		_ = rd.SetReadDeadline(time.Time{})
+	}()
+	stop := context.AfterFunc(ctx, func() {
+		_ = rd.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	b, err := ReadBatch(r)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, batchContextErr(ctx.Err())
+		}
+		if isTimeout(err) {
+			return nil, ErrBatchTimeout
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// WriteBatchContext is WriteBatch bounded by ctx, using SetWriteDeadline when
+// the writer supports it.
+func WriteBatchContext(ctx context.Context, w io.Writer, b *Batch) error {
+	if err := ctx.Err(); err != nil {
+		return batchContextErr(err)
+	}
+	wd, ok := w.(writeDeadliner)
+	if !ok {
+		return WriteBatch(w, b)
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = wd.SetWriteDeadline(dl)
+	}
+	defer func() {
+		_ = wd.SetWriteDeadline(time.Time{})
+	}()
+	stop := context.AfterFunc(ctx, func() {
+		_ = wd.SetWriteDeadline(time.Now())
+	})
+	defer stop()
+
+	if err := WriteBatch(w, b); err != nil {
+		if ctx.Err() != nil {
+			return batchContextErr(ctx.Err())
+		}
+		if isTimeout(err) {
+			return ErrBatchTimeout
+		}
+		return err
+	}
+	return nil
+}
+
+func batchContextErr(err error) error {
+	if err == context.DeadlineExceeded {
+		return ErrBatchTimeout
+	}
+	return err
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
 }
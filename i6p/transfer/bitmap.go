@@ -0,0 +1,20 @@
+package transfer
+
+// bitmap tracks which chunk indexes have been received.
+type bitmap []byte
+
+func newBitmap(n int) bitmap { return make(bitmap, (n+7)/8) }
+
+func (b bitmap) set(i int)      { b[i/8] |= 1 << (i % 8) }
+func (b bitmap) clear(i int)    { b[i/8] &^= 1 << (i % 8) }
+func (b bitmap) has(i int) bool { return b[i/8]&(1<<(i%8)) != 0 }
+
+func (b bitmap) count(n int) int {
+	c := 0
+	for i := 0; i < n; i++ {
+		if b.has(i) {
+			c++
+		}
+	}
+	return c
+}
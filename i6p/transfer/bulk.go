@@ -5,23 +5,30 @@ import (
 	"crypto/sha256"
 	"errors"
 	"io"
+	"sort"
 	"sync"
-	"sync/atomic"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
 )
 
 var (
 	ErrTransferFailed       = errors.New("transfer: transfer failed")
 	ErrIntegrityCheckFailed = errors.New("transfer: integrity check failed")
+	ErrChunkConflict        = errors.New("transfer: conflicting duplicate chunk")
 )
 
 // TransferConfig configures a bulk transfer operation.
 type TransferConfig struct {
-	ChunkSize       int              // bytes per chunk (default: 256KB)
-	Compression     CompressionLevel // compression level
-	ErasureData     int              // data shards for erasure coding (0 = disabled)
-	ErasureParity   int              // parity shards for erasure coding
-	ParallelStreams int              // number of parallel streams to use
-	ParallelWorkers int              // number of worker goroutines
+	ChunkSize       int                 // bytes per chunk (default: 256KB)
+	Compression     CompressionLevel    // compression level
+	Codec           Codec               // compression codec (nil: LZ4)
+	Feedback        CompressionFeedback // turns compression off while chunks do not compress
+	ErasureData     int                 // data shards for erasure coding (0 = disabled)
+	ErasureParity   int                 // parity shards for erasure coding
+	ParallelStreams int                 // number of parallel streams to use
+	ParallelWorkers int                 // number of worker goroutines
+	FlowWindow      int                 // max unacknowledged chunks (0 = unlimited until a WINDOW_UPDATE)
 }
 
 // DefaultTransferConfig returns sensible defaults for high-throughput transfers.
@@ -36,80 +43,113 @@ func DefaultTransferConfig() TransferConfig {
 	}
 }
 
-// TransferStats tracks transfer progress and metrics.
-type TransferStats struct {
-	TotalBytes      atomic.Int64
-	CompressedBytes atomic.Int64
-	ChunksSent      atomic.Int64
-	ChunksReceived  atomic.Int64
-	Errors          atomic.Int64
-}
-
-// CompressionRatio returns the compression ratio (original / compressed).
-func (s *TransferStats) CompressionRatio() float64 {
-	comp := s.CompressedBytes.Load()
-	if comp == 0 {
-		return 1.0
-	}
-	return float64(s.TotalBytes.Load()) / float64(comp)
-}
-
 // BulkSender handles sending large data efficiently.
 type BulkSender struct {
 	config  TransferConfig
 	pool    *StreamPool
 	stats   TransferStats
 	chunker *Chunker
+	flow    *FlowControl
+	sched   *Scheduler
+	weight  int
+
+	meta Metadata     // tags every batch, see MuxTransfer.Sender
+	mux  *TransferMux // owns the pool of a sender from MuxTransfer.Sender
 }
 
 // NewBulkSender creates a new bulk sender.
 func NewBulkSender(opener StreamOpener, config TransferConfig) *BulkSender {
+	return newBulkSender(NewStreamPool(opener, config.ParallelStreams), config)
+}
+
+func newBulkSender(pool *StreamPool, config TransferConfig) *BulkSender {
 	if config.ChunkSize <= 0 {
 		config.ChunkSize = DefaultChunkSize
 	}
 	return &BulkSender{
 		config:  config,
-		pool:    NewStreamPool(opener, config.ParallelStreams),
+		pool:    pool,
 		chunker: NewChunker(config.ChunkSize),
+		flow:    NewFlowControl(config.FlowWindow),
 	}
 }
 
+// FlowControl returns the sender's flow controller. Feed it the WINDOW_UPDATE
+// and ACK frames received from the peer, or call Pause/Resume directly. The
+// frames of a sender from MuxTransfer.Sender go to TransferMux.HandleFrame
+// instead.
+func (bs *BulkSender) FlowControl() *FlowControl { return bs.flow }
+
+// SetScheduler shares s between this sender and the other transfers to the same
+// peer. Each Send or SendReader call registers as one transfer with the given
+// weight for its duration.
+func (bs *BulkSender) SetScheduler(s *Scheduler, weight int) {
+	bs.sched = s
+	bs.weight = weight
+}
+
+func (bs *BulkSender) newWriter() (pw *ParallelWriter, done func()) {
+	pw = NewParallelWriter(bs.pool, bs.config.ParallelWorkers)
+	pw.SetFlowControl(bs.flow)
+	pw.SetMeta(bs.meta)
+	if bs.sched == nil {
+		return pw, func() {}
+	}
+	t := bs.sched.Register(bs.weight)
+	pw.SetScheduler(t)
+	return pw, t.Close
+}
+
+// newCompressor starts the compression feedback loop of one transfer.
+func (bs *BulkSender) newCompressor() *compressor {
+	return newCompressor(bs.config.Codec, bs.config.Compression, bs.config.Feedback, &bs.stats)
+}
+
 // Send transmits data efficiently using all configured optimizations.
 // Returns the Merkle root hash for integrity verification.
 func (bs *BulkSender) Send(ctx context.Context, data []byte) (merkleRoot []byte, err error) {
-	chunks := bs.chunker.Split(data)
+	start := time.Now()
+	chunks, err := bs.chunker.SplitContext(ctx, data)
+	if err != nil {
+		return nil, err
+	}
 
 	// Build Merkle tree
 	var hashes [][]byte
 	for _, c := range chunks {
 		hashes = append(hashes, c.Hash)
 	}
-	tree, err := BuildMerkleTree(hashes)
+	tree, err := BuildMerkleTreeContext(ctx, hashes)
 	if err != nil {
 		return nil, err
 	}
-
-	bs.stats.TotalBytes.Store(int64(len(data)))
+	phase(&bs.stats.HashNanos, start)
 
 	// Compress chunks
-	var compressedChunks []CompressedChunk
-	var compressedSize int64
-	for _, c := range chunks {
-		cc := CompressChunk(c, bs.config.Compression)
-		compressedChunks = append(compressedChunks, cc)
-		compressedSize += int64(len(cc.Data))
+	start = time.Now()
+	comp := bs.newCompressor()
+	compressedChunks := make([]CompressedChunk, len(chunks))
+	for i, c := range chunks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		compressedChunks[i] = comp.compress(c)
 	}
-	bs.stats.CompressedBytes.Store(compressedSize)
+	phase(&bs.stats.CompressNanos, start)
 
 	// Send using parallel writer
-	pw := NewParallelWriter(bs.pool, bs.config.ParallelWorkers)
+	start = time.Now()
+	defer phase(&bs.stats.SendNanos, start)
+	pw, done := bs.newWriter()
+	defer done()
 	pw.Start(ctx)
 
-	for _, cc := range compressedChunks {
+	for i, cc := range compressedChunks {
 		if err := pw.Send(cc); err != nil {
 			return nil, err
 		}
 		bs.stats.ChunksSent.Add(1)
+		bs.stats.count(len(chunks[i].Data), len(cc.Data), time.Now())
 	}
 
 	if err := pw.Wait(); err != nil {
@@ -121,39 +161,45 @@ func (bs *BulkSender) Send(ctx context.Context, data []byte) (merkleRoot []byte,
 
 // SendReader transmits data from a reader.
 func (bs *BulkSender) SendReader(ctx context.Context, r io.Reader) (merkleRoot []byte, err error) {
+	start := time.Now()
 	chunks, err := bs.chunker.SplitReader(r)
 	if err != nil {
 		return nil, err
 	}
 
-	var totalSize int64
 	var hashes [][]byte
 	for _, c := range chunks {
 		hashes = append(hashes, c.Hash)
-		totalSize += int64(len(c.Data))
 	}
-	bs.stats.TotalBytes.Store(totalSize)
 
 	tree, err := BuildMerkleTree(hashes)
 	if err != nil {
 		return nil, err
 	}
+	phase(&bs.stats.HashNanos, start)
 
 	// Compress and send
-	pw := NewParallelWriter(bs.pool, bs.config.ParallelWorkers)
+	pw, done := bs.newWriter()
+	defer done()
 	pw.Start(ctx)
 
-	var compressedSize int64
+	comp := bs.newCompressor()
 	for _, c := range chunks {
-		cc := CompressChunk(c, bs.config.Compression)
-		compressedSize += int64(len(cc.Data))
-		if err := pw.Send(cc); err != nil {
+		start = time.Now()
+		cc := comp.compress(c)
+		phase(&bs.stats.CompressNanos, start)
+		start = time.Now()
+		err := pw.Send(cc)
+		phase(&bs.stats.SendNanos, start)
+		if err != nil {
 			return nil, err
 		}
 		bs.stats.ChunksSent.Add(1)
+		bs.stats.count(len(c.Data), len(cc.Data), time.Now())
 	}
-	bs.stats.CompressedBytes.Store(compressedSize)
 
+	start = time.Now()
+	defer phase(&bs.stats.SendNanos, start)
 	if err := pw.Wait(); err != nil {
 		return nil, err
 	}
@@ -164,8 +210,13 @@ func (bs *BulkSender) SendReader(ctx context.Context, r io.Reader) (merkleRoot [
 // Stats returns transfer statistics.
 func (bs *BulkSender) Stats() *TransferStats { return &bs.stats }
 
-// Close closes the sender and releases resources.
+// Close closes the sender and releases resources. The streams of a sender
+// from MuxTransfer.Sender stay open.
 func (bs *BulkSender) Close() error {
+	if bs.mux != nil {
+		bs.mux.removeFlow(bs.flow)
+		return nil
+	}
 	return bs.pool.Close()
 }
 
@@ -176,6 +227,8 @@ type BulkReceiver struct {
 	mu          sync.Mutex
 	chunks      map[int]Chunk
 	totalChunks int
+	manifest    *Manifest
+	acks        *FlowAcks
 }
 
 // NewBulkReceiver creates a new bulk receiver.
@@ -187,7 +240,18 @@ func NewBulkReceiver(config TransferConfig) *BulkReceiver {
 }
 
 // ReceiveChunk processes an incoming compressed chunk.
+// A chunk whose index was already received is counted as a duplicate and
+// dropped; if its payload differs from the stored one, the original is kept
+// and ErrChunkConflict is returned.
 func (br *BulkReceiver) ReceiveChunk(cc CompressedChunk) error {
+	err := br.receiveChunk(cc)
+	if aerr := br.ack(1); err == nil {
+		err = aerr
+	}
+	return err
+}
+
+func (br *BulkReceiver) receiveChunk(cc CompressedChunk) error {
 	chunk, err := DecompressChunk(cc)
 	if err != nil {
 		br.stats.Errors.Add(1)
@@ -195,20 +259,68 @@ func (br *BulkReceiver) ReceiveChunk(cc CompressedChunk) error {
 	}
 
 	br.mu.Lock()
+	if prev, ok := br.chunks[chunk.Index]; ok {
+		br.mu.Unlock()
+		if !crypto.Equal(prev.Hash, chunk.Hash) {
+			br.stats.Conflicts.Add(1)
+			return ErrChunkConflict
+		}
+		br.stats.Duplicates.Add(1)
+		return nil
+	}
 	br.chunks[chunk.Index] = chunk
 	br.mu.Unlock()
 
 	br.stats.ChunksReceived.Add(1)
+	br.stats.count(len(chunk.Data), len(cc.Data), time.Now())
 	return nil
 }
 
-// ReceiveBatch processes an incoming batch of chunks.
+// ReceiveBatch processes an incoming batch of chunks. Every chunk of the
+// batch is acknowledged, including those after one that fails.
 func (br *BulkReceiver) ReceiveBatch(batch *Batch) error {
+	var err error
 	for _, cc := range batch.Chunks {
-		if err := br.ReceiveChunk(cc); err != nil {
-			return err
+		if err = br.receiveChunk(cc); err != nil {
+			break
 		}
 	}
+	if aerr := br.ack(len(batch.Chunks)); err == nil {
+		err = aerr
+	}
+	return err
+}
+
+// SetFlowAcks makes the receiver acknowledge each chunk it receives with a,
+// returning its slot to a sender limited by a flow window. Receivers behind a
+// TransferMux are acknowledged by the mux instead (see
+// TransferMux.SetFlowAcks). Must be called before the first chunk arrives.
+func (br *BulkReceiver) SetFlowAcks(a *FlowAcks) {
+	br.acks = a
+}
+
+func (br *BulkReceiver) ack(n int) error {
+	if br.acks == nil {
+		return nil
+	}
+	return br.acks.Consumed(n)
+}
+
+// Discard drops previously received chunks, typically the Corrupt indexes of an
+// IntegrityReport, so that retransmitted copies are accepted.
+func (br *BulkReceiver) Discard(indexes ...int) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	for _, i := range indexes {
+		delete(br.chunks, i)
+	}
+}
+
+// Abort drops every received chunk, releasing their memory.
+func (br *BulkReceiver) Abort() error {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.chunks = make(map[int]Chunk)
 	return nil
 }
 
@@ -217,6 +329,80 @@ func (br *BulkReceiver) SetExpectedChunks(n int) {
 	br.totalChunks = n
 }
 
+// SetManifest supplies the expected per-chunk hashes. It also sets the expected
+// chunk count, and lets Verify pinpoint corrupt chunks rather than only
+// detecting a root mismatch.
+func (br *BulkReceiver) SetManifest(m *Manifest) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.manifest = m
+	br.totalChunks = m.NumChunks()
+}
+
+// IntegrityReport is the result of BulkReceiver.Verify.
+type IntegrityReport struct {
+	Expected  int   // expected chunk count (0 if unknown)
+	Received  int   // chunks currently held
+	Missing   []int // indexes never received
+	Corrupt   []int // indexes whose hash differs from the manifest
+	RootMatch bool  // Merkle root of the received chunks equals the expected root
+}
+
+// OK reports whether the transfer is complete and intact.
+func (r IntegrityReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Corrupt) == 0 && r.RootMatch
+}
+
+// Retransmit returns the sorted indexes that should be requested again.
+func (r IntegrityReport) Retransmit() []int {
+	out := make([]int, 0, len(r.Missing)+len(r.Corrupt))
+	out = append(out, r.Missing...)
+	out = append(out, r.Corrupt...)
+	sort.Ints(out)
+	return out
+}
+
+// Verify checks the received chunks and reports exactly which are missing or
+// corrupt, so callers can re-request only those. Corrupt chunks can only be
+// identified when a manifest was supplied with SetManifest; without one, a bad
+// chunk shows up as RootMatch == false.
+// If expectedRoot is empty, the manifest root is used when available.
+func (br *BulkReceiver) Verify(expectedRoot []byte) IntegrityReport {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	if len(expectedRoot) == 0 && br.manifest != nil {
+		expectedRoot = br.manifest.Root
+	}
+	report := IntegrityReport{Expected: br.totalChunks, Received: len(br.chunks)}
+	for i := 0; i < br.totalChunks; i++ {
+		c, ok := br.chunks[i]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, i)
+		case br.manifest != nil && !crypto.Equal(c.Hash, br.manifest.ChunkHashes[i]):
+			report.Corrupt = append(report.Corrupt, i)
+		}
+	}
+
+	if len(report.Missing) > 0 || len(br.chunks) == 0 || len(expectedRoot) == 0 {
+		return report
+	}
+	hashes := make([][]byte, 0, len(br.chunks))
+	for i := 0; i < len(br.chunks); i++ {
+		c, ok := br.chunks[i]
+		if !ok {
+			return report
+		}
+		hashes = append(hashes, c.Hash)
+	}
+	tree, err := BuildMerkleTree(hashes)
+	if err == nil && crypto.Equal(tree.Root(), expectedRoot) {
+		report.RootMatch = true
+	}
+	return report
+}
+
 // Progress returns the reception progress (0.0 to 1.0).
 func (br *BulkReceiver) Progress() float64 {
 	if br.totalChunks == 0 {
@@ -266,7 +452,7 @@ func (br *BulkReceiver) Assemble(expectedRoot []byte) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
-		if !bytesEqual(tree.Root(), expectedRoot) {
+		if !crypto.Equal(tree.Root(), expectedRoot) {
 			return nil, ErrIntegrityCheckFailed
 		}
 	}
@@ -0,0 +1,83 @@
+package transfer
+
+import "math/bits"
+
+// Default content-defined chunk sizes.
+const (
+	DefaultCDCMin = 16 * 1024
+	DefaultCDCAvg = 64 * 1024
+	DefaultCDCMax = 256 * 1024
+)
+
+// gear is the table of the rolling gear hash. It is fixed so both ends of a
+// transfer cut the same content at the same places.
+var gear = func() (t [256]uint64) {
+	x := uint64(0x49365043) // "I6PC"
+	for i := range t {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// CDCChunker splits data at content-defined boundaries using a gear rolling
+// hash. Unlike Chunker, inserting or removing bytes only changes the chunks
+// around the edit, so a modified file can be updated by sending those alone.
+type CDCChunker struct {
+	min, avg, max int
+	mask          uint64
+}
+
+// NewCDCChunker creates a chunker producing chunks between min and max bytes,
+// avg on average. avg is rounded down to a power of two; zero or inconsistent
+// sizes select the defaults.
+func NewCDCChunker(min, avg, max int) *CDCChunker {
+	if min <= 0 || avg < min || max < avg {
+		min, avg, max = DefaultCDCMin, DefaultCDCAvg, DefaultCDCMax
+	}
+	b := bits.Len(uint(avg)) - 1
+	return &CDCChunker{
+		min:  min,
+		avg:  1 << b,
+		max:  max,
+		mask: ^uint64(0) << (64 - b), // the top b bits
+	}
+}
+
+// Sizes returns the chunker's minimum, average and maximum chunk sizes.
+func (c *CDCChunker) Sizes() (min, avg, max int) { return c.min, c.avg, c.max }
+
+// Split splits data into content-defined chunks and computes hashes.
+func (c *CDCChunker) Split(data []byte) []Chunk {
+	var chunks []Chunk
+	for len(data) > 0 {
+		n := c.cut(data)
+		chunks = append(chunks, Chunk{
+			Index: len(chunks),
+			Data:  data[:n],
+			Hash:  HashChunk(data[:n]),
+		})
+		data = data[n:]
+	}
+	return chunks
+}
+
+// cut returns the length of the next chunk of data.
+func (c *CDCChunker) cut(data []byte) int {
+	if len(data) <= c.min {
+		return len(data)
+	}
+	end := min(len(data), c.max)
+	var h uint64
+	for i := c.min; i < end; i++ {
+		h = h<<1 + gear[data[i]]
+		if h&c.mask == 0 {
+			return i + 1
+		}
+	}
+	return end
+}
@@ -0,0 +1,66 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrCheckpointInvalid = errors.New("transfer: invalid checkpoint")
+)
+
+// Checkpoint is the persisted state of a partially received object.
+type Checkpoint struct {
+	Manifest Manifest `json:"manifest"`
+	Received []byte   `json:"received"`  // bitmap: bit i set once chunk i is on disk
+	TempPath string   `json:"temp_path"` // file holding the partial data
+	// KeyCheck identifies the spool key of an encrypted temp file; nil for a
+	// plaintext one. It does not reveal the key.
+	KeyCheck []byte `json:"key_check,omitempty"`
+}
+
+// SaveCheckpoint writes cp to path atomically (write to a sibling file, then rename),
+// so a crash mid-save never leaves a truncated checkpoint behind.
+func SaveCheckpoint(path string, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadCheckpoint reads a checkpoint written by SaveCheckpoint.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, ErrCheckpointInvalid
+	}
+	if cp.TempPath == "" {
+		return nil, ErrCheckpointInvalid
+	}
+	return &cp, nil
+}
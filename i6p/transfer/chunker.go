@@ -2,6 +2,7 @@ package transfer
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"sync"
 )
@@ -34,20 +35,26 @@ type Chunk struct {
 
 // Split splits data into chunks and computes hashes.
 func (c *Chunker) Split(data []byte) []Chunk {
-	var chunks []Chunk
+	chunks, _ := c.SplitContext(context.Background(), data)
+	return chunks
+}
+
+// SplitContext is Split that stops with ctx.Err() once ctx is done, checking
+// between chunks. The chunks are hashed with HashChunks.
+func (c *Chunker) SplitContext(ctx context.Context, data []byte) ([]Chunk, error) {
+	var parts [][]byte
 	for i := 0; i < len(data); i += c.chunkSize {
-		end := i + c.chunkSize
-		if end > len(data) {
-			end = len(data)
-		}
-		chunk := data[i:end]
-		chunks = append(chunks, Chunk{
-			Index: len(chunks),
-			Data:  chunk,
-			Hash:  HashChunk(chunk),
-		})
+		parts = append(parts, data[i:min(i+c.chunkSize, len(data))])
 	}
-	return chunks
+	hashes, err := HashChunksContext(ctx, parts)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []Chunk
+	for i, p := range parts {
+		chunks = append(chunks, Chunk{Index: i, Data: p, Hash: hashes[i]})
+	}
+	return chunks, nil
 }
 
 // SplitReader splits data from a reader into chunks.
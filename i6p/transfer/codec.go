@@ -0,0 +1,219 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CodecID identifies a compression codec on the wire. It is the byte that
+// batches and CHUNK_DATA frames carry for each chunk.
+type CodecID uint8
+
+const (
+	CodecNone CodecID = 0 // chunk data is not compressed
+	CodecLZ4  CodecID = 1
+)
+
+// CompressionCapability is the HELLO capability listing the codec IDs a peer
+// can decompress, as comma-separated decimals ("1,7"). Peers that do not
+// advertise it are assumed to support LZ4 only.
+const CompressionCapability = "i6p.compression"
+
+var (
+	ErrCodecID         = errors.New("transfer: invalid codec ID")
+	ErrCodecRegistered = errors.New("transfer: codec ID already registered")
+	ErrCodecUnknown    = errors.New("transfer: unknown compression codec")
+)
+
+// Codec compresses chunk data. Implementations must be safe for concurrent
+// use; Decompress must accept anything the same codec's Compress produced at
+// any level.
+type Codec interface {
+	ID() CodecID
+	Name() string
+	Compress(data []byte, level CompressionLevel) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// LZ4 is the built-in codec and the default of TransferConfig and
+// StreamConfig.
+var LZ4 Codec = lz4Codec{}
+
+// NoCompression is a Codec that leaves chunks uncompressed.
+var NoCompression Codec = noCodec{}
+
+type lz4Codec struct{}
+
+func (lz4Codec) ID() CodecID  { return CodecLZ4 }
+func (lz4Codec) Name() string { return "lz4" }
+func (lz4Codec) Compress(data []byte, level CompressionLevel) ([]byte, error) {
+	return Compress(data, level)
+}
+func (lz4Codec) Decompress(data []byte) ([]byte, error) { return Decompress(data) }
+
+// LZ4Options configures a codec from NewLZ4.
+type LZ4Options struct {
+	// Level is the LZ4 level, 0 (fastest) to MaxLZ4Level (best ratio). It
+	// replaces the CompressionLevel of the transfer.
+	Level int
+}
+
+// NewLZ4 returns an LZ4 codec with the given options, for TransferConfig.Codec
+// or StreamConfig.Codec. It shares CodecLZ4 with the built-in codec, so any
+// peer can decompress its output.
+func NewLZ4(opts LZ4Options) (Codec, error) {
+	if opts.Level < 0 || opts.Level > MaxLZ4Level {
+		return nil, fmt.Errorf("%w: %d", ErrLZ4Level, opts.Level)
+	}
+	return lz4LevelCodec{lz4Codec{}, opts.Level}, nil
+}
+
+type lz4LevelCodec struct {
+	lz4Codec
+	level int
+}
+
+func (c lz4LevelCodec) Compress(data []byte, _ CompressionLevel) ([]byte, error) {
+	return compressLZ4(data, c.level)
+}
+
+type noCodec struct{}
+
+func (noCodec) ID() CodecID  { return CodecNone }
+func (noCodec) Name() string { return "none" }
+func (noCodec) Compress(data []byte, _ CompressionLevel) ([]byte, error) {
+	return data, nil
+}
+func (noCodec) Decompress(data []byte) ([]byte, error) { return data, nil }
+
+var codecs = struct {
+	sync.RWMutex
+	byID    map[CodecID]Codec
+	content map[string]Codec
+}{
+	byID:    map[CodecID]Codec{CodecLZ4: LZ4},
+	content: map[string]Codec{},
+}
+
+// RegisterCodec makes c available to every transfer in the process, both
+// for sending and for decompressing received chunks. Register codecs such as
+// zstd or brotli at init time; IDs 0 and 1 are taken by CodecNone and
+// CodecLZ4.
+func RegisterCodec(c Codec) error {
+	if c.ID() == CodecNone {
+		return ErrCodecID
+	}
+	codecs.Lock()
+	defer codecs.Unlock()
+	if _, ok := codecs.byID[c.ID()]; ok {
+		return fmt.Errorf("%w: %d", ErrCodecRegistered, c.ID())
+	}
+	codecs.byID[c.ID()] = c
+	return nil
+}
+
+// LookupCodec returns the codec registered under id. CodecNone returns
+// NoCompression.
+func LookupCodec(id CodecID) (Codec, bool) {
+	if id == CodecNone {
+		return NoCompression, true
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.byID[id]
+	return c, ok
+}
+
+// CodecIDs returns the IDs of the registered codecs in increasing order.
+func CodecIDs() []CodecID {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	ids := make([]CodecID, 0, len(codecs.byID))
+	for id := range codecs.byID {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// CodecCapability returns the CompressionCapability value advertising the
+// registered codecs.
+func CodecCapability() string {
+	ids := CodecIDs()
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(int(id))
+	}
+	return strings.Join(parts, ",")
+}
+
+// NegotiateCodec returns the first codec of prefer that is registered here
+// and listed in the remote HELLO capabilities, or NoCompression if there is
+// none. With no preference, LZ4 is tried.
+func NegotiateCodec(remote map[string]string, prefer ...Codec) Codec {
+	if len(prefer) == 0 {
+		prefer = []Codec{LZ4}
+	}
+	supported := map[CodecID]bool{CodecLZ4: true}
+	if v, ok := remote[CompressionCapability]; ok {
+		supported = map[CodecID]bool{}
+		for _, f := range strings.Split(v, ",") {
+			if n, err := strconv.ParseUint(strings.TrimSpace(f), 10, 8); err == nil {
+				supported[CodecID(n)] = true
+			}
+		}
+	}
+	for _, c := range prefer {
+		if _, ok := LookupCodec(c.ID()); ok && supported[c.ID()] {
+			return c
+		}
+	}
+	return NoCompression
+}
+
+// SetContentTypeCodec selects the codec ForContentType uses for a media
+// type, such as NoCompression for "video/*" or "application/zip". A nil
+// codec removes the entry.
+func SetContentTypeCodec(contentType string, c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if c == nil {
+		delete(codecs.content, contentType)
+		return
+	}
+	codecs.content[contentType] = c
+}
+
+// ForContentType returns c with the codec set for contentType by
+// SetContentTypeCodec, matching the exact media type first and then its
+// "type/*" wildcard. Parameters such as charset are ignored. Content types
+// without an entry keep c unchanged.
+func (c TransferConfig) ForContentType(contentType string) TransferConfig {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return c
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	if codec, ok := codecs.content[mt]; ok {
+		c.Codec = codec
+	} else if major, _, ok := strings.Cut(mt, "/"); ok {
+		if codec, ok := codecs.content[major+"/*"]; ok {
+			c.Codec = codec
+		}
+	}
+	return c
+}
+
+// codecOrDefault returns c, or LZ4 if c is nil.
+func codecOrDefault(c Codec) Codec {
+	if c == nil {
+		return LZ4
+	}
+	return c
+}
@@ -3,9 +3,11 @@ package transfer
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
 
+	"github.com/TheusHen/I6P/i6p/crypto"
 	"github.com/pierrec/lz4/v4"
 )
 
@@ -14,15 +16,42 @@ var (
 	ErrDecompressionFailed = errors.New("transfer: decompression failed")
 )
 
-// CompressionLevel controls the speed/ratio tradeoff.
+// CompressionLevel controls the speed/ratio tradeoff. For finer control of
+// LZ4, use a codec from NewLZ4.
 type CompressionLevel int
 
 const (
-	CompressionFast    CompressionLevel = iota // Fastest, lower ratio
-	CompressionDefault                         // Balanced
-	CompressionBest                            // Best ratio, slower
+	CompressionFast    CompressionLevel = iota // Fastest, lower ratio: LZ4 level 0
+	CompressionDefault                         // Balanced: LZ4 level 1
+	CompressionBest                            // Best ratio, slower: LZ4 level 9
 )
 
+// MaxLZ4Level is the highest LZ4 level. Level 0 is the fast compressor;
+// levels 1 to MaxLZ4Level select the high-compression one with a search
+// depth doubling at each level. Beyond level 3 the ratio rarely improves
+// (see BenchmarkLZ4Levels).
+const MaxLZ4Level = 9
+
+var ErrLZ4Level = errors.New("transfer: LZ4 level out of range")
+
+// lz4Levels maps numeric levels to the library's.
+var lz4Levels = [MaxLZ4Level + 1]lz4.CompressionLevel{
+	lz4.Fast, lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4,
+	lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9,
+}
+
+// LZ4Level returns the numeric LZ4 level a CompressionLevel selects.
+func (l CompressionLevel) LZ4Level() int {
+	switch l {
+	case CompressionFast:
+		return 0
+	case CompressionBest:
+		return MaxLZ4Level
+	default:
+		return 1
+	}
+}
+
 // compressorPool reuses LZ4 writers to reduce allocations.
 var compressorPool = sync.Pool{
 	New: func() interface{} {
@@ -40,19 +69,17 @@ var decompressorPool = sync.Pool{
 // Compress compresses data using LZ4.
 // LZ4 is chosen for its exceptional speed on commodity hardware.
 func Compress(data []byte, level CompressionLevel) ([]byte, error) {
+	return compressLZ4(data, level.LZ4Level())
+}
+
+func compressLZ4(data []byte, level int) ([]byte, error) {
 	var buf bytes.Buffer
 	w := compressorPool.Get().(*lz4.Writer)
 	defer compressorPool.Put(w)
 
 	w.Reset(&buf)
-
-	switch level {
-	case CompressionFast:
-		_ = w.Apply(lz4.CompressionLevelOption(lz4.Fast))
-	case CompressionBest:
-		_ = w.Apply(lz4.CompressionLevelOption(lz4.Level9))
-	default:
-		_ = w.Apply(lz4.CompressionLevelOption(lz4.Level4))
+	if err := w.Apply(lz4.CompressionLevelOption(lz4Levels[level])); err != nil {
+		return nil, ErrCompressionFailed
 	}
 
 	if _, err := w.Write(data); err != nil {
@@ -83,14 +110,45 @@ func Decompress(data []byte) ([]byte, error) {
 type CompressedChunk struct {
 	Index      int
 	Compressed bool
+	Codec      CodecID // codec of Data when Compressed; 0 means CodecLZ4
 	Data       []byte
-	OrigHash   []byte // hash of original uncompressed data
+	OrigHash   []byte   // hash of original uncompressed data
+	Meta       Metadata // tags sent with the chunk in a batch
+}
+
+// CodecID returns the codec Data is encoded with, CodecNone if it is not
+// compressed.
+func (cc CompressedChunk) CodecID() CodecID {
+	switch {
+	case !cc.Compressed:
+		return CodecNone
+	case cc.Codec == CodecNone:
+		return CodecLZ4
+	default:
+		return cc.Codec
+	}
+}
+
+// chunkWithCodec builds the CompressedChunk for data encoded with id.
+func chunkWithCodec(index int, id CodecID, data, hash []byte) CompressedChunk {
+	return CompressedChunk{Index: index, Compressed: id != CodecNone, Codec: id, Data: data, OrigHash: hash}
 }
 
-// CompressChunk compresses a chunk if beneficial.
-// Returns the original chunk if compression doesn't help.
+// CompressChunk compresses a chunk with LZ4 if beneficial.
+// Returns the original chunk if compression doesn't help, or without trying
+// if the chunk looks incompressible (see HighEntropy).
 func CompressChunk(chunk Chunk, level CompressionLevel) CompressedChunk {
-	compressed, err := Compress(chunk.Data, level)
+	return CompressChunkWith(chunk, LZ4, level)
+}
+
+// CompressChunkWith is CompressChunk with the given codec; a nil codec
+// selects LZ4.
+func CompressChunkWith(chunk Chunk, codec Codec, level CompressionLevel) CompressedChunk {
+	codec = codecOrDefault(codec)
+	if codec.ID() == CodecNone || HighEntropy(chunk.Data) {
+		return chunkWithCodec(chunk.Index, CodecNone, chunk.Data, chunk.Hash)
+	}
+	compressed, err := codec.Compress(chunk.Data, level)
 	if err != nil || len(compressed) >= len(chunk.Data) {
 		// Compression not beneficial
 		return CompressedChunk{
@@ -100,30 +158,23 @@ func CompressChunk(chunk Chunk, level CompressionLevel) CompressedChunk {
 			OrigHash:   chunk.Hash,
 		}
 	}
-	return CompressedChunk{
-		Index:      chunk.Index,
-		Compressed: true,
-		Data:       compressed,
-		OrigHash:   chunk.Hash,
-	}
+	return chunkWithCodec(chunk.Index, codec.ID(), compressed, chunk.Hash)
 }
 
 // DecompressChunk decompresses a chunk and verifies integrity.
 func DecompressChunk(cc CompressedChunk) (Chunk, error) {
-	var data []byte
-	if cc.Compressed {
-		var err error
-		data, err = Decompress(cc.Data)
-		if err != nil {
-			return Chunk{}, err
-		}
-	} else {
-		data = cc.Data
+	codec, ok := LookupCodec(cc.CodecID())
+	if !ok {
+		return Chunk{}, fmt.Errorf("%w: %d", ErrCodecUnknown, cc.CodecID())
+	}
+	data, err := codec.Decompress(cc.Data)
+	if err != nil {
+		return Chunk{}, err
 	}
 
 	// Verify hash
 	hash := HashChunk(data)
-	if !bytesEqual(hash, cc.OrigHash) {
+	if !crypto.Equal(hash, cc.OrigHash) {
 		return Chunk{}, errors.New("transfer: chunk hash mismatch after decompression")
 	}
 
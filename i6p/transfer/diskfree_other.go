@@ -0,0 +1,6 @@
+//go:build !(linux || darwin || freebsd)
+
+package transfer
+
+// diskFree is not implemented on this platform; the disk check is skipped.
+func diskFree(dir string) (int64, bool) { return 0, false }
@@ -6,6 +6,7 @@
 //   - LZ4 compression (extremely fast, good for network-bound transfers)
 //   - Batching for reduced syscall overhead
 //   - Parallel stream support via the Stream Pool
+//   - Many concurrent transfers over one set of streams via TransferMux
 //
 // This package is designed to saturate high-bandwidth IPv6 links efficiently.
 package transfer
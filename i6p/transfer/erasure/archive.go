@@ -0,0 +1,225 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var ErrArchiveInvalid = errors.New("erasure: invalid archive descriptor")
+
+// ArchiveVersion is the descriptor format written by CreateArchive.
+const ArchiveVersion = 1
+
+// ArchiveBlockSize is the shard bytes encoded per stripe: a stripe covers
+// DataShards*ArchiveBlockSize bytes of the original file, so memory use stays
+// flat whatever the file size.
+const ArchiveBlockSize = 1 << 20
+
+// Archive describes a file stored as erasure-coded fragment files, one per
+// shard. The original can be restored from any DataShards intact fragments.
+// Fragments may be spread over several nodes; the descriptor travels with
+// each of them.
+type Archive struct {
+	Version      int        `json:"version"`
+	DataShards   int        `json:"data_shards"`
+	ParityShards int        `json:"parity_shards"`
+	Size         int64      `json:"size"`        // original file size
+	StripeSize   int        `json:"stripe_size"` // original bytes per stripe
+	Fragments    []Fragment `json:"fragments"`
+}
+
+// Fragment is one shard file of an Archive.
+type Fragment struct {
+	Name string `json:"name"` // file name, relative to the archive directory
+	Size int64  `json:"size"`
+	Hash []byte `json:"hash"` // SHA-256 of the whole file
+}
+
+// CreateArchive reads r to EOF and writes it to dir as dataShards +
+// parityShards fragment files named name.NNN.frag, plus the descriptor
+// name.archive.json, which it also returns.
+func CreateArchive(dir, name string, r io.Reader, dataShards, parityShards int) (*Archive, error) {
+	return CreateArchiveContext(context.Background(), dir, name, r, dataShards, parityShards)
+}
+
+// CreateArchiveContext is CreateArchive that stops with ctx.Err() once ctx
+// is done, leaving the fragments written so far without a descriptor.
+func CreateArchiveContext(ctx context.Context, dir, name string, r io.Reader, dataShards, parityShards int) (*Archive, error) {
+	codec, err := NewCodec(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{
+		Version:      ArchiveVersion,
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+		StripeSize:   dataShards * ArchiveBlockSize,
+		Fragments:    make([]Fragment, codec.TotalShards()),
+	}
+	files := make([]*os.File, codec.TotalShards())
+	hashes := make([]hash.Hash, codec.TotalShards())
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				_ = f.Close()
+			}
+		}
+	}()
+	for i := range files {
+		a.Fragments[i].Name = fmt.Sprintf("%s.%03d.frag", name, i)
+		if files[i], err = os.Create(filepath.Join(dir, a.Fragments[i].Name)); err != nil {
+			return nil, err
+		}
+		hashes[i] = sha256.New()
+	}
+
+	buf := make([]byte, a.StripeSize)
+	for {
+		n, rerr := io.ReadFull(r, buf)
+		if n > 0 {
+			shards, err := codec.EncodeDataContext(ctx, buf[:n])
+			if err != nil {
+				return nil, err
+			}
+			for i, s := range shards {
+				if _, err := io.MultiWriter(files[i], hashes[i]).Write(s); err != nil {
+					return nil, err
+				}
+				a.Fragments[i].Size += int64(len(s))
+			}
+			a.Size += int64(n)
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			return nil, rerr
+		}
+	}
+	for i, f := range files {
+		if err := f.Sync(); err != nil {
+			return nil, err
+		}
+		a.Fragments[i].Hash = hashes[i].Sum(nil)
+	}
+
+	desc, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".archive.json"), desc, 0o644); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// LoadArchive reads a descriptor written by CreateArchive.
+func LoadArchive(path string) (*Archive, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a Archive
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, ErrArchiveInvalid
+	}
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (a *Archive) validate() error {
+	if a.Version != ArchiveVersion || a.DataShards <= 0 || a.ParityShards <= 0 ||
+		len(a.Fragments) != a.DataShards+a.ParityShards || a.Size < 0 || a.StripeSize <= 0 ||
+		a.StripeSize%a.DataShards != 0 {
+		return ErrArchiveInvalid
+	}
+	for _, f := range a.Fragments {
+		if f.Name == "" || f.Name != filepath.Base(f.Name) || len(f.Hash) != sha256.Size {
+			return ErrArchiveInvalid
+		}
+	}
+	return nil
+}
+
+// Check hashes the fragments found in dir and returns the indexes of the
+// intact ones. Missing, truncated and corrupt fragments are left out.
+func (a *Archive) Check(dir string) []int {
+	var intact []int
+	for i, frag := range a.Fragments {
+		f, err := os.Open(filepath.Join(dir, frag.Name))
+		if err != nil {
+			continue
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, f)
+		_ = f.Close()
+		if err == nil && n == frag.Size && bytes.Equal(h.Sum(nil), frag.Hash) {
+			intact = append(intact, i)
+		}
+	}
+	return intact
+}
+
+// Restore writes the original file to w from the intact fragments in dir.
+// It needs at least DataShards of them and returns ErrTooManyLost otherwise.
+func (a *Archive) Restore(dir string, w io.Writer) error {
+	if err := a.validate(); err != nil {
+		return err
+	}
+	codec, err := NewCodec(a.DataShards, a.ParityShards)
+	if err != nil {
+		return err
+	}
+	intact := a.Check(dir)
+	if len(intact) < a.DataShards {
+		return ErrTooManyLost
+	}
+	intact = intact[:a.DataShards] // any DataShards fragments suffice
+	files := make([]*os.File, codec.TotalShards())
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				_ = f.Close()
+			}
+		}
+	}()
+	for _, i := range intact {
+		if files[i], err = os.Open(filepath.Join(dir, a.Fragments[i].Name)); err != nil {
+			return err
+		}
+	}
+
+	shards := make([][]byte, codec.TotalShards())
+	bufs := make([][]byte, codec.TotalShards())
+	for _, i := range intact {
+		bufs[i] = make([]byte, codec.ShardSize(a.StripeSize))
+	}
+	for off := int64(0); off < a.Size; off += int64(a.StripeSize) {
+		stripe := int(min(int64(a.StripeSize), a.Size-off))
+		piece := codec.ShardSize(stripe)
+		for i := range shards {
+			shards[i] = nil
+			if files[i] == nil {
+				continue
+			}
+			shards[i] = bufs[i][:piece]
+			if _, err := io.ReadFull(files[i], shards[i]); err != nil {
+				return err
+			}
+		}
+		if err := codec.JoinTo(w, shards, stripe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package erasure
 
 import (
+	"context"
 	"errors"
+	"io"
+	"sync"
 
 	"github.com/klauspost/reedsolomon"
 )
@@ -13,28 +16,75 @@ var (
 )
 
 // Codec provides Reed-Solomon encoding/decoding.
+// A Codec is safe for concurrent use.
 type Codec struct {
 	enc          reedsolomon.Encoder
 	dataShards   int
 	parityShards int
 }
 
-// NewCodec creates a new erasure codec.
+// Option tunes the encoder behind a Codec.
+type Option func(*options)
+
+type options struct {
+	autoGoroutines int // shard size hint, 0 = library default
+	cauchy         bool
+}
+
+// WithAutoGoroutines sizes the encoder's goroutine use for shards of about
+// shardSize bytes.
+func WithAutoGoroutines(shardSize int) Option {
+	return func(o *options) { o.autoGoroutines = shardSize }
+}
+
+// WithCauchyMatrix builds the encoder from a Cauchy matrix, which is faster
+// to set up for large shard counts.
+func WithCauchyMatrix() Option {
+	return func(o *options) { o.cauchy = true }
+}
+
+type codecKey struct {
+	data, parity int
+	opts         options
+}
+
+// codecs caches codecs by configuration: building the encoding matrices is
+// expensive for large shard counts, and codecs are immutable.
+var codecs sync.Map // codecKey -> *Codec
+
+// NewCodec returns an erasure codec, reusing a cached one for the same
+// configuration.
 // dataShards: number of data shards
 // parityShards: number of parity shards (can lose up to this many)
-func NewCodec(dataShards, parityShards int) (*Codec, error) {
+func NewCodec(dataShards, parityShards int, opts ...Option) (*Codec, error) {
 	if dataShards <= 0 || parityShards <= 0 {
 		return nil, ErrInvalidConfig
 	}
-	enc, err := reedsolomon.New(dataShards, parityShards)
+	key := codecKey{data: dataShards, parity: parityShards}
+	for _, o := range opts {
+		o(&key.opts)
+	}
+	if c, ok := codecs.Load(key); ok {
+		return c.(*Codec), nil
+	}
+
+	var rsOpts []reedsolomon.Option
+	if key.opts.autoGoroutines > 0 {
+		rsOpts = append(rsOpts, reedsolomon.WithAutoGoroutines(key.opts.autoGoroutines))
+	}
+	if key.opts.cauchy {
+		rsOpts = append(rsOpts, reedsolomon.WithCauchyMatrix())
+	}
+	enc, err := reedsolomon.New(dataShards, parityShards, rsOpts...)
 	if err != nil {
 		return nil, err
 	}
-	return &Codec{
+	c, _ := codecs.LoadOrStore(key, &Codec{
 		enc:          enc,
 		dataShards:   dataShards,
 		parityShards: parityShards,
-	}, nil
+	})
+	return c.(*Codec), nil
 }
 
 // DataShards returns the number of data shards.
@@ -59,14 +109,56 @@ func (c *Codec) Encode(shards [][]byte) error {
 	return c.enc.Encode(shards)
 }
 
+// encodeWindow is how many bytes of each shard EncodeContext encodes
+// between checks of its context.
+const encodeWindow = 256 << 10
+
+// EncodeContext is Encode that checks ctx between windows of the shards,
+// returning ctx.Err() once it is done; the parity is then incomplete.
+// Reed-Solomon works column by column, so encoding in windows yields the
+// same parity as one call.
+func (c *Codec) EncodeContext(ctx context.Context, shards [][]byte) error {
+	if len(shards) != c.TotalShards() || len(shards[0]) == 0 {
+		return c.Encode(shards)
+	}
+	size := len(shards[0])
+	for i, s := range shards {
+		if i >= c.dataShards && s == nil {
+			shards[i] = make([]byte, size)
+		} else if len(s) != size {
+			return ErrShardSizeMismatch
+		}
+	}
+	window := make([][]byte, len(shards))
+	for off := 0; off < size; off += encodeWindow {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := min(off+encodeWindow, size)
+		for i, s := range shards {
+			window[i] = s[off:end]
+		}
+		if err := c.Encode(window); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // EncodeData is a convenience function that splits data and computes parity.
 // Returns all shards (data + parity).
 func (c *Codec) EncodeData(data []byte) ([][]byte, error) {
+	return c.EncodeDataContext(context.Background(), data)
+}
+
+// EncodeDataContext is EncodeData that stops with ctx.Err() once ctx is
+// done, see EncodeContext.
+func (c *Codec) EncodeDataContext(ctx context.Context, data []byte) ([][]byte, error) {
 	shards, err := c.Split(data)
 	if err != nil {
 		return nil, err
 	}
-	if err := c.Encode(shards); err != nil {
+	if err := c.EncodeContext(ctx, shards); err != nil {
 		return nil, err
 	}
 	return shards, nil
@@ -83,8 +175,11 @@ func (c *Codec) Verify(shards [][]byte) (bool, error) {
 func (c *Codec) Reconstruct(shards [][]byte) error {
 	err := c.enc.Reconstruct(shards)
 	if err != nil {
-		if err == reedsolomon.ErrTooFewShards {
+		switch err {
+		case reedsolomon.ErrTooFewShards:
 			return ErrTooManyLost
+		case reedsolomon.ErrShardSize:
+			return ErrShardSizeMismatch
 		}
 		return err
 	}
@@ -95,8 +190,11 @@ func (c *Codec) Reconstruct(shards [][]byte) error {
 func (c *Codec) ReconstructData(shards [][]byte) error {
 	err := c.enc.ReconstructData(shards)
 	if err != nil {
-		if err == reedsolomon.ErrTooFewShards {
+		switch err {
+		case reedsolomon.ErrTooFewShards:
 			return ErrTooManyLost
+		case reedsolomon.ErrShardSize:
+			return ErrShardSizeMismatch
 		}
 		return err
 	}
@@ -104,21 +202,69 @@ func (c *Codec) ReconstructData(shards [][]byte) error {
 }
 
 // Join joins data shards back into the original data.
-// outSize is the original data size (before padding).
+// outSize is the original data size (before padding). Missing data shards
+// (nil or empty) are reconstructed in place when shards holds all
+// TotalShards() entries and enough of them survive; otherwise Join returns
+// ErrTooManyLost. Shards of different sizes, or too small to hold outSize
+// bytes, give ErrShardSizeMismatch.
 func (c *Codec) Join(shards [][]byte, outSize int) ([]byte, error) {
-	// Only use data shards
+	if err := c.prepareJoin(shards, outSize); err != nil {
+		return nil, err
+	}
 	data := make([]byte, 0, outSize)
 	for i := 0; i < c.dataShards && len(data) < outSize; i++ {
-		remaining := outSize - len(data)
-		if remaining >= len(shards[i]) {
-			data = append(data, shards[i]...)
-		} else {
-			data = append(data, shards[i][:remaining]...)
-		}
+		data = append(data, shards[i][:min(len(shards[i]), outSize-len(data))]...)
 	}
 	return data, nil
 }
 
+// JoinTo is Join writing the original data to w instead of returning it.
+func (c *Codec) JoinTo(w io.Writer, shards [][]byte, outSize int) error {
+	if err := c.prepareJoin(shards, outSize); err != nil {
+		return err
+	}
+	left := outSize
+	for i := 0; i < c.dataShards && left > 0; i++ {
+		n := min(len(shards[i]), left)
+		if _, err := w.Write(shards[i][:n]); err != nil {
+			return err
+		}
+		left -= n
+	}
+	return nil
+}
+
+// prepareJoin checks shards for Join and reconstructs missing data shards.
+func (c *Codec) prepareJoin(shards [][]byte, outSize int) error {
+	if len(shards) < c.dataShards {
+		return ErrTooManyLost
+	}
+	missing := false
+	for i := 0; i < c.dataShards; i++ {
+		if len(shards[i]) == 0 {
+			missing = true
+		}
+	}
+	if missing {
+		if len(shards) != c.TotalShards() {
+			return ErrTooManyLost
+		}
+		if err := c.ReconstructData(shards); err != nil {
+			return err
+		}
+	}
+	size := len(shards[0])
+	for i := 1; i < c.dataShards; i++ {
+		if len(shards[i]) != size {
+			return ErrShardSizeMismatch
+		}
+	}
+	if outSize < 0 || outSize > size*c.dataShards {
+		return ErrShardSizeMismatch
+	}
+	return nil
+}
+
 // ShardSize calculates the shard size for a given data size.
 func (c *Codec) ShardSize(dataSize int) int {
 	shardSize := dataSize / c.dataShards
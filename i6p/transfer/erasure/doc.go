@@ -5,5 +5,10 @@
 // 10 data shards and 4 parity shards, any 4 shards can be lost and the
 // data is still fully recoverable.
 //
+// Shards travel with their SHA-256 hash (see Shard), so Codec.Repair can
+// tell corrupt shards from good ones and rebuild them before Join. For
+// long-term storage, CreateArchive writes a file as one fragment file per
+// shard, restorable from any DataShards of them.
+//
 // This implementation uses the klauspost/reedsolomon library for high performance.
 package erasure
@@ -2,6 +2,11 @@ package erasure
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -54,6 +59,39 @@ func TestCodecRoundTrip(t *testing.T) {
 	}
 }
 
+func TestEncodeContext(t *testing.T) {
+	codec, err := NewCodec(4, 2)
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	// Several windows per shard, the last one partial.
+	data := make([]byte, 4*(2*encodeWindow+1000))
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	want, err := codec.EncodeData(data)
+	if err != nil {
+		t.Fatalf("EncodeData: %v", err)
+	}
+
+	shards, _ := codec.Split(data)
+	shards[4], shards[5] = nil, nil
+	if err := codec.EncodeContext(context.Background(), shards); err != nil {
+		t.Fatalf("EncodeContext: %v", err)
+	}
+	for i := range want {
+		if !bytes.Equal(shards[i], want[i]) {
+			t.Fatalf("shard %d differs from Encode", i)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := codec.EncodeDataContext(ctx, data); !errors.Is(err, context.Canceled) {
+		t.Fatalf("EncodeDataContext: %v", err)
+	}
+}
+
 func TestCodecTooManyLost(t *testing.T) {
 	codec, err := NewCodec(10, 4)
 	if err != nil {
@@ -120,3 +158,182 @@ func BenchmarkReconstruct(b *testing.B) {
 		_ = codec.Reconstruct(work)
 	}
 }
+
+func TestCodecCache(t *testing.T) {
+	a, err := NewCodec(6, 3)
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	b, _ := NewCodec(6, 3)
+	if a != b {
+		t.Fatal("same configuration built a new codec")
+	}
+	c, err := NewCodec(6, 3, WithCauchyMatrix(), WithAutoGoroutines(64<<10))
+	if err != nil {
+		t.Fatalf("NewCodec with options: %v", err)
+	}
+	if c == a {
+		t.Fatal("options ignored by the cache")
+	}
+
+	data := bytes.Repeat([]byte("cauchy"), 100)
+	shards, err := c.EncodeData(data)
+	if err != nil {
+		t.Fatalf("EncodeData: %v", err)
+	}
+	shards[0], shards[4], shards[7] = nil, nil, nil
+	if err := c.Reconstruct(shards); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+	got, _ := c.Join(shards, len(data))
+	if !bytes.Equal(got, data) {
+		t.Fatal("round trip mismatch")
+	}
+}
+
+func TestJoinValidatesShards(t *testing.T) {
+	codec, err := NewCodec(4, 2)
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	data := bytes.Repeat([]byte("join"), 25)
+	shards, err := codec.EncodeData(data)
+	if err != nil {
+		t.Fatalf("EncodeData: %v", err)
+	}
+
+	// A lost data shard is rebuilt from parity.
+	lost := append([][]byte(nil), shards...)
+	lost[1] = nil
+	var buf bytes.Buffer
+	if err := codec.JoinTo(&buf, lost, len(data)); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("JoinTo after loss: %v", err)
+	}
+
+	// Data shards alone cannot replace a lost one.
+	dataOnly := append([][]byte(nil), shards[:4]...)
+	dataOnly[2] = nil
+	if _, err := codec.Join(dataOnly, len(data)); err != ErrTooManyLost {
+		t.Fatalf("missing shard without parity: %v", err)
+	}
+	tooFew := append([][]byte(nil), shards...)
+	tooFew[0], tooFew[1], tooFew[4] = nil, nil, nil
+	if _, err := codec.Join(tooFew, len(data)); err != ErrTooManyLost {
+		t.Fatalf("too many lost: %v", err)
+	}
+
+	short := append([][]byte(nil), shards...)
+	short[3] = short[3][:len(short[3])-1]
+	if _, err := codec.Join(short, len(data)); err != ErrShardSizeMismatch {
+		t.Fatalf("short shard: %v", err)
+	}
+	if _, err := codec.Join(shards, len(data)*2); err != ErrShardSizeMismatch {
+		t.Fatalf("oversized output: %v", err)
+	}
+}
+
+func TestRepairFindsCorruptShards(t *testing.T) {
+	codec, err := NewCodec(4, 2)
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	data := bytes.Repeat([]byte("repair me "), 20)
+	shards, err := codec.EncodeData(data)
+	if err != nil {
+		t.Fatalf("EncodeData: %v", err)
+	}
+
+	// Ship the shards through the wire format.
+	var hashes [][]byte
+	received := make([][]byte, len(shards))
+	for _, s := range Shards(shards) {
+		b, err := s.Encode()
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		got, err := DecodeShard(b)
+		if err != nil || !got.Verify() {
+			t.Fatalf("DecodeShard: %v", err)
+		}
+		hashes = append(hashes, got.Hash)
+		if err := codec.Place(received, got); err != nil {
+			t.Fatalf("Place: %v", err)
+		}
+	}
+	if err := codec.Place(received, Shard{Index: 6}); err != ErrShardIndex {
+		t.Fatalf("Place with an index past the codec: %v", err)
+	}
+
+	received[1] = append([]byte(nil), received[1]...)
+	received[1][0] ^= 0xff // silent corruption
+	received[5] = nil      // lost parity
+	corrupt, err := codec.Repair(received, hashes)
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if len(corrupt) != 1 || corrupt[0] != 1 {
+		t.Fatalf("corrupt = %v", corrupt)
+	}
+	got, err := codec.Join(received, len(data))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Join after repair: %v", err)
+	}
+
+	received[0][0] ^= 1
+	received[2][0] ^= 1
+	received[3][0] ^= 1
+	if _, err := codec.Repair(received, hashes); err != ErrTooManyLost {
+		t.Fatalf("unrepairable: %v", err)
+	}
+	if _, err := DecodeShard([]byte{1, 2, 3}); err != ErrShardFormat {
+		t.Fatalf("short shard: %v", err)
+	}
+}
+
+func TestArchiveRestoreFromAnyFragments(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 2*3*ArchiveBlockSize+12345) // two full stripes and a short one
+	for i := range data {
+		data[i] = byte(i*31 + i>>11)
+	}
+	a, err := CreateArchive(dir, "blob", bytes.NewReader(data), 3, 2)
+	if err != nil {
+		t.Fatalf("CreateArchive: %v", err)
+	}
+	if a.Size != int64(len(data)) || len(a.Fragments) != 5 {
+		t.Fatalf("descriptor %+v", a)
+	}
+
+	loaded, err := LoadArchive(filepath.Join(dir, "blob.archive.json"))
+	if err != nil {
+		t.Fatalf("LoadArchive: %v", err)
+	}
+	// Lose one data fragment, corrupt another: three intact remain.
+	if err := os.Remove(filepath.Join(dir, a.Fragments[0].Name)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, a.Fragments[2].Name), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteAt([]byte{0xde, 0xad}, 100)
+	_ = f.Close()
+	if got := loaded.Check(dir); len(got) != 3 {
+		t.Fatalf("intact fragments %v", got)
+	}
+
+	var out bytes.Buffer
+	if err := loaded.Restore(dir, &out); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("restored data mismatch")
+	}
+
+	if err := os.Remove(filepath.Join(dir, a.Fragments[4].Name)); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Restore(dir, io.Discard); err != ErrTooManyLost {
+		t.Fatalf("Restore with two fragments: %v", err)
+	}
+}
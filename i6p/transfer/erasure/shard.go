@@ -0,0 +1,142 @@
+package erasure
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrShardCorrupt      = errors.New("erasure: shard does not match its hash")
+	ErrShardFormat       = errors.New("erasure: malformed shard")
+	ErrShardHashMismatch = errors.New("erasure: shard hash count does not match codec")
+	ErrShardIndex        = errors.New("erasure: shard index out of range")
+)
+
+// HashSize is the size of a shard hash (SHA-256).
+const HashSize = sha256.Size
+
+// shardHeaderSize is index(2) + hash(32) + data length(4).
+const shardHeaderSize = 2 + HashSize + 4
+
+// Shard is one erasure-coded shard with the hash it was produced with, so a
+// receiver can tell a corrupt shard from a good one.
+type Shard struct {
+	Index int
+	Hash  []byte
+	Data  []byte
+}
+
+// HashShard returns the hash of a shard's data.
+func HashShard(data []byte) []byte {
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+// HashShards returns the hash of every shard, or nil for a missing one.
+func HashShards(shards [][]byte) [][]byte {
+	out := make([][]byte, len(shards))
+	for i, s := range shards {
+		if s != nil {
+			out[i] = HashShard(s)
+		}
+	}
+	return out
+}
+
+// Shards wraps encoded shards with their index and hash for transmission.
+func Shards(shards [][]byte) []Shard {
+	out := make([]Shard, len(shards))
+	for i, s := range shards {
+		out[i] = Shard{Index: i, Hash: HashShard(s), Data: s}
+	}
+	return out
+}
+
+// Encode serializes the shard.
+// Format:
+//
+//	2 bytes: index
+//	32 bytes: SHA-256 of data
+//	4 bytes: data length
+//	N bytes: data
+func (s Shard) Encode() ([]byte, error) {
+	if s.Index < 0 || s.Index > 0xffff || len(s.Hash) != HashSize {
+		return nil, ErrShardFormat
+	}
+	buf := make([]byte, shardHeaderSize+len(s.Data))
+	binary.BigEndian.PutUint16(buf, uint16(s.Index))
+	copy(buf[2:], s.Hash)
+	binary.BigEndian.PutUint32(buf[2+HashSize:], uint32(len(s.Data)))
+	copy(buf[shardHeaderSize:], s.Data)
+	return buf, nil
+}
+
+// DecodeShard parses a shard produced by Encode. It does not check the hash;
+// Verify or Codec.Repair do.
+func DecodeShard(b []byte) (Shard, error) {
+	if len(b) < shardHeaderSize {
+		return Shard{}, ErrShardFormat
+	}
+	n := binary.BigEndian.Uint32(b[2+HashSize:])
+	if uint64(len(b)-shardHeaderSize) != uint64(n) {
+		return Shard{}, ErrShardFormat
+	}
+	return Shard{
+		Index: int(binary.BigEndian.Uint16(b)),
+		Hash:  append([]byte(nil), b[2:2+HashSize]...),
+		Data:  append([]byte(nil), b[shardHeaderSize:]...),
+	}, nil
+}
+
+// Verify reports whether the shard's data matches its hash.
+func (s Shard) Verify() bool {
+	return bytes.Equal(HashShard(s.Data), s.Hash)
+}
+
+// Place stores the data of a received shard at its index in shards, which
+// must hold TotalShards entries. The index comes from the sender, so it is
+// checked rather than trusted.
+func (c *Codec) Place(shards [][]byte, s Shard) error {
+	if len(shards) != c.TotalShards() || s.Index < 0 || s.Index >= len(shards) {
+		return ErrShardIndex
+	}
+	shards[s.Index] = s.Data
+	return nil
+}
+
+// Repair checks every shard against hashes, the per-shard hashes recorded at
+// encoding time. Shards that do not match are dropped and, together with
+// missing (nil) shards, reconstructed in place. It returns the indexes of the
+// corrupt shards it found. Reconstructed shards are checked against their
+// hashes too, so a repair never silently yields wrong data.
+func (c *Codec) Repair(shards, hashes [][]byte) (corrupt []int, err error) {
+	if len(shards) != c.TotalShards() || len(hashes) != c.TotalShards() {
+		return nil, ErrShardHashMismatch
+	}
+	var rebuild []int
+	for i, s := range shards {
+		if s == nil {
+			rebuild = append(rebuild, i)
+			continue
+		}
+		if !bytes.Equal(HashShard(s), hashes[i]) {
+			corrupt = append(corrupt, i)
+			rebuild = append(rebuild, i)
+			shards[i] = nil
+		}
+	}
+	if len(rebuild) == 0 {
+		return nil, nil
+	}
+	if err := c.Reconstruct(shards); err != nil {
+		return corrupt, err
+	}
+	for _, i := range rebuild {
+		if !bytes.Equal(HashShard(shards[i]), hashes[i]) {
+			return corrupt, ErrShardCorrupt
+		}
+	}
+	return corrupt, nil
+}
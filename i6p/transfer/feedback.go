@@ -0,0 +1,92 @@
+package transfer
+
+// Defaults of CompressionFeedback.
+const (
+	DefaultFeedbackMinRatio = 1.05
+	DefaultFeedbackWindow   = 16
+	DefaultFeedbackReprobe  = 64
+)
+
+// CompressionFeedback tunes the loop that turns compression off during a
+// transfer whose chunks do not compress, and back on when they start to.
+// The zero value selects the defaults.
+type CompressionFeedback struct {
+	Disabled bool    // always compress, as CompressChunkWith does
+	MinRatio float64 // ratio (original / compressed) a chunk must reach (default 1.05)
+	Window   int     // consecutive chunks below MinRatio before compression is turned off (default 16)
+	Reprobe  int     // chunks sent uncompressed between two probes while off (default 64)
+}
+
+func (f CompressionFeedback) withDefaults() CompressionFeedback {
+	if f.MinRatio <= 0 {
+		f.MinRatio = DefaultFeedbackMinRatio
+	}
+	if f.Window <= 0 {
+		f.Window = DefaultFeedbackWindow
+	}
+	if f.Reprobe <= 0 {
+		f.Reprobe = DefaultFeedbackReprobe
+	}
+	return f
+}
+
+// compressor compresses the chunks of one transfer, in order, applying the
+// feedback loop. Its decisions are recorded in stats when it is not nil.
+type compressor struct {
+	codec Codec
+	level CompressionLevel
+	fb    CompressionFeedback
+	stats *TransferStats
+
+	poor    int  // consecutive chunks below MinRatio while on
+	skipped int  // chunks sent uncompressed since the last probe while off
+	off     bool // compression turned off by the loop
+}
+
+func newCompressor(codec Codec, level CompressionLevel, fb CompressionFeedback, stats *TransferStats) *compressor {
+	return &compressor{codec: codec, level: level, fb: fb.withDefaults(), stats: stats}
+}
+
+func (c *compressor) compress(chunk Chunk) CompressedChunk {
+	if c.fb.Disabled || codecOrDefault(c.codec).ID() == CodecNone {
+		return CompressChunkWith(chunk, c.codec, c.level)
+	}
+	if c.off {
+		if c.skipped++; c.skipped <= c.fb.Reprobe {
+			if c.stats != nil {
+				c.stats.CompressionSkipped.Add(1)
+			}
+			return CompressChunkWith(chunk, NoCompression, c.level)
+		}
+		c.skipped = 0
+	}
+
+	cc := CompressChunkWith(chunk, c.codec, c.level)
+	ratio := 1.0
+	if cc.Compressed {
+		ratio = float64(len(chunk.Data)) / float64(len(cc.Data))
+	}
+	switch {
+	case c.off && ratio >= c.fb.MinRatio:
+		// The probe compressed well: turn compression back on.
+		c.off, c.poor = false, 0
+		c.setOff(false)
+	case c.off:
+	case ratio < c.fb.MinRatio:
+		if c.poor++; c.poor >= c.fb.Window {
+			c.off, c.skipped = true, 0
+			c.setOff(true)
+		}
+	default:
+		c.poor = 0
+	}
+	return cc
+}
+
+func (c *compressor) setOff(off bool) {
+	if c.stats == nil {
+		return
+	}
+	c.stats.CompressionOff.Store(off)
+	c.stats.CompressionSwitches.Add(1)
+}
@@ -0,0 +1,252 @@
+package transfer
+
+import (
+	"crypto/hmac"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+)
+
+var (
+	ErrChunkIndexRange  = errors.New("transfer: chunk index out of range")
+	ErrChunkHashInvalid = errors.New("transfer: chunk hash does not match manifest")
+	ErrTransferPartial  = errors.New("transfer: transfer is incomplete")
+)
+
+// FileReceiver receives a manifest-described object straight into a temp file,
+// so memory use stays flat regardless of object size. Its state can be
+// checkpointed and resumed after a process restart. An encrypted receiver
+// keeps the temp file encrypted until Finalize.
+type FileReceiver struct {
+	mu       sync.Mutex
+	manifest *Manifest
+	path     string
+	file     *os.File
+	cipher   *spoolCipher // nil for a plaintext temp file
+	received bitmap
+	stats    TransferStats
+}
+
+// NewFileReceiver starts a new download into tempPath.
+// The manifest must already be validated against the expected root.
+func NewFileReceiver(m *Manifest, tempPath string) (*FileReceiver, error) {
+	return newFileReceiver(m, tempPath, nil)
+}
+
+// NewEncryptedFileReceiver is NewFileReceiver with the temp file encrypted
+// under key (see GenerateSpoolKey), so partial data never sits on disk in
+// plaintext. Resuming needs the same key.
+func NewEncryptedFileReceiver(m *Manifest, tempPath string, key []byte) (*FileReceiver, error) {
+	c, err := newSpoolCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return newFileReceiver(m, tempPath, c)
+}
+
+func newFileReceiver(m *Manifest, tempPath string, c *spoolCipher) (*FileReceiver, error) {
+	if err := m.Validate(nil); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(m.Size); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &FileReceiver{
+		manifest: m,
+		path:     tempPath,
+		file:     f,
+		cipher:   c,
+		received: newBitmap(m.NumChunks()),
+	}, nil
+}
+
+// ResumeFileReceiver reopens a download from a checkpoint. Chunks marked as
+// received are re-hashed from disk; any that fail are marked missing again.
+func ResumeFileReceiver(cp *Checkpoint) (*FileReceiver, error) {
+	return resumeFileReceiver(cp, nil)
+}
+
+// ResumeEncryptedFileReceiver reopens a download started by
+// NewEncryptedFileReceiver with the same key.
+func ResumeEncryptedFileReceiver(cp *Checkpoint, key []byte) (*FileReceiver, error) {
+	c, err := newSpoolCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return resumeFileReceiver(cp, c)
+}
+
+func resumeFileReceiver(cp *Checkpoint, c *spoolCipher) (*FileReceiver, error) {
+	m := cp.Manifest
+	if err := m.Validate(nil); err != nil {
+		return nil, err
+	}
+	if len(cp.Received) != len(newBitmap(m.NumChunks())) {
+		return nil, ErrCheckpointInvalid
+	}
+	if (c == nil) != (cp.KeyCheck == nil) || (c != nil && !hmac.Equal(c.check(), cp.KeyCheck)) {
+		return nil, ErrSpoolKeyMismatch
+	}
+	f, err := os.OpenFile(cp.TempPath, os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	fr := &FileReceiver{
+		manifest: &m,
+		path:     cp.TempPath,
+		file:     f,
+		cipher:   c,
+		received: append(bitmap(nil), cp.Received...),
+	}
+	buf := make([]byte, m.ChunkSize)
+	for i := 0; i < m.NumChunks(); i++ {
+		if !fr.received.has(i) {
+			continue
+		}
+		chunk, err := fr.readChunk(i, buf)
+		if err != nil || !crypto.Equal(HashChunk(chunk), m.ChunkHashes[i]) {
+			fr.received.clear(i)
+		}
+	}
+	return fr, nil
+}
+
+// ReceiveChunk verifies a chunk against the manifest and writes it to disk.
+func (fr *FileReceiver) ReceiveChunk(cc CompressedChunk) error {
+	if cc.Index < 0 || cc.Index >= fr.manifest.NumChunks() {
+		fr.stats.Errors.Add(1)
+		return ErrChunkIndexRange
+	}
+	chunk, err := DecompressChunk(cc)
+	if err != nil {
+		fr.stats.Errors.Add(1)
+		return err
+	}
+	if !crypto.Equal(chunk.Hash, fr.manifest.ChunkHashes[chunk.Index]) || len(chunk.Data) != fr.manifest.ChunkLen(chunk.Index) {
+		fr.stats.Errors.Add(1)
+		return ErrChunkHashInvalid
+	}
+
+	data := chunk.Data
+	if fr.cipher != nil {
+		data = make([]byte, len(chunk.Data))
+		fr.cipher.xor(chunk.Index, data, chunk.Data)
+	}
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if _, err := fr.file.WriteAt(data, int64(chunk.Index)*int64(fr.manifest.ChunkSize)); err != nil {
+		fr.stats.Errors.Add(1)
+		return err
+	}
+	fr.received.set(chunk.Index)
+	fr.stats.ChunksReceived.Add(1)
+	fr.stats.count(len(chunk.Data), len(cc.Data), time.Now())
+	return nil
+}
+
+// ReceiveBatch processes an incoming batch of chunks.
+func (fr *FileReceiver) ReceiveBatch(batch *Batch) error {
+	for _, cc := range batch.Chunks {
+		if err := fr.ReceiveChunk(cc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Missing returns the indexes of chunks not yet received.
+func (fr *FileReceiver) Missing() []int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	var out []int
+	for i := 0; i < fr.manifest.NumChunks(); i++ {
+		if !fr.received.has(i) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// Progress returns the reception progress (0.0 to 1.0).
+func (fr *FileReceiver) Progress() float64 {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return float64(fr.received.count(fr.manifest.NumChunks())) / float64(fr.manifest.NumChunks())
+}
+
+// IsComplete returns true if every chunk has been received.
+func (fr *FileReceiver) IsComplete() bool {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.received.count(fr.manifest.NumChunks()) == fr.manifest.NumChunks()
+}
+
+// Checkpoint captures the receiver state for SaveCheckpoint.
+// Data is synced to disk first so the checkpoint never claims unwritten chunks.
+func (fr *FileReceiver) Checkpoint() (*Checkpoint, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if err := fr.file.Sync(); err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{
+		Manifest: *fr.manifest,
+		Received: append([]byte(nil), fr.received...),
+		TempPath: fr.path,
+	}
+	if fr.cipher != nil {
+		cp.KeyCheck = fr.cipher.check()
+	}
+	return cp, nil
+}
+
+// Finalize closes the temp file and renames it to dst once every chunk is present.
+// An encrypted temp file is decrypted into dst and removed instead.
+func (fr *FileReceiver) Finalize(dst string) error {
+	if !fr.IsComplete() {
+		return ErrTransferPartial
+	}
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if fr.cipher != nil {
+		return fr.decryptTo(dst)
+	}
+	if err := fr.file.Sync(); err != nil {
+		return err
+	}
+	if err := fr.file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fr.path, dst)
+}
+
+// Close closes the temp file, leaving it on disk for a later resume.
+func (fr *FileReceiver) Close() error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.file.Close()
+}
+
+// Abort closes and removes the temp file, giving up the download.
+func (fr *FileReceiver) Abort() error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	_ = fr.file.Close()
+	if err := os.Remove(fr.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Stats returns receiver statistics.
+func (fr *FileReceiver) Stats() *TransferStats { return &fr.stats }
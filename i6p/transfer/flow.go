@@ -0,0 +1,217 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/TheusHen/I6P/i6p/protocol"
+)
+
+var (
+	ErrUnexpectedFrame = errors.New("transfer: unexpected control frame")
+)
+
+// FlowControl bounds how many chunks a sender may have in flight: sent but
+// not yet consumed by the receiver. The receiver drives it with
+// WINDOW_UPDATE frames: shrinking the window slows the sender down, a window
+// of 0 pauses it, and growing it resumes. It returns slots with ACK frames
+// as it consumes chunks (see FlowAcks), so a slow receiver holds the sender
+// back however fast the chunks leave the local stream buffers.
+//
+// Frames carry the ID of the transfer they control (see
+// protocol.WindowUpdate), so one session can carry several transfers.
+//
+// An unlimited controller does not count chunks in flight: its receiver may
+// not acknowledge them at all. Counting starts from zero at the first
+// window, so until the chunks sent before it are acknowledged the sender may
+// exceed that window by their number.
+type FlowControl struct {
+	transfer uint64
+
+	mu       sync.Mutex
+	window   int
+	limited  bool
+	inflight int
+	wake     chan struct{} // closed and replaced whenever capacity may have changed
+}
+
+// NewFlowControl creates a flow controller for transfer 0, a transfer sent
+// outside a TransferMux. window <= 0 means unlimited until the first
+// WINDOW_UPDATE.
+func NewFlowControl(window int) *FlowControl {
+	f := &FlowControl{window: math.MaxInt32, wake: make(chan struct{})}
+	if window > 0 {
+		f.window, f.limited = window, true
+	}
+	return f
+}
+
+// Transfer returns the ID of the transfer whose frames the controller
+// accepts.
+func (f *FlowControl) Transfer() uint64 { return f.transfer }
+
+// Acquire blocks until a chunk may be sent or ctx is done.
+func (f *FlowControl) Acquire(ctx context.Context) error {
+	_, err := f.acquire(ctx)
+	return err
+}
+
+// acquire is Acquire, also reporting whether the chunk was counted in
+// flight, so that a chunk that does not go out only returns a slot it took.
+func (f *FlowControl) acquire(ctx context.Context) (counted bool, err error) {
+	for {
+		f.mu.Lock()
+		if !f.limited {
+			f.mu.Unlock()
+			return false, nil
+		}
+		if f.inflight < f.window {
+			f.inflight++
+			f.mu.Unlock()
+			return true, nil
+		}
+		wake := f.wake
+		f.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// Release returns the slot of one chunk, consumed by the receiver or never
+// sent.
+func (f *FlowControl) Release() { f.release(1) }
+
+func (f *FlowControl) release(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inflight = max(0, f.inflight-n)
+	f.signalLocked()
+}
+
+// SetWindow changes the number of chunks allowed in flight. Chunks already in
+// flight are not interrupted when the window shrinks.
+func (f *FlowControl) SetWindow(n int) {
+	if n < 0 {
+		n = 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.window = n
+	f.limited = true
+	f.signalLocked()
+}
+
+// Pause stops new chunks from being sent.
+func (f *FlowControl) Pause() { f.SetWindow(0) }
+
+// Resume allows up to n chunks in flight again.
+func (f *FlowControl) Resume(n int) { f.SetWindow(n) }
+
+// Window returns the current window.
+func (f *FlowControl) Window() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.window
+}
+
+// InFlight returns the number of chunks currently in flight.
+func (f *FlowControl) InFlight() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.inflight
+}
+
+// HandleFrame applies a WINDOW_UPDATE or ACK control frame. Frames for
+// another transfer return ErrUnknownTransfer.
+func (f *FlowControl) HandleFrame(frame protocol.Frame) error {
+	transfer, apply, err := decodeFlowFrame(frame)
+	if err != nil {
+		return err
+	}
+	if transfer != f.transfer {
+		return ErrUnknownTransfer
+	}
+	apply(f)
+	return nil
+}
+
+// decodeFlowFrame decodes a WINDOW_UPDATE or ACK frame into the transfer it
+// controls and its effect on that transfer's FlowControl.
+func decodeFlowFrame(frame protocol.Frame) (transfer uint64, apply func(*FlowControl), err error) {
+	switch frame.Type {
+	case protocol.MessageTypeWindowUpdate:
+		wu, err := protocol.DecodeWindowUpdate(frame.Payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		return wu.Transfer, func(f *FlowControl) { f.SetWindow(int(min(wu.Window, math.MaxInt32))) }, nil
+	case protocol.MessageTypeAck:
+		ack, err := protocol.DecodeAck(frame.Payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		return ack.Transfer, func(f *FlowControl) { f.release(int(min(ack.Chunks, math.MaxInt32))) }, nil
+	default:
+		return 0, nil, ErrUnexpectedFrame
+	}
+}
+
+// FlowAcks is the receiving side of FlowControl: it acknowledges the chunks
+// of one transfer as they are consumed, in ACK frames of at least every
+// chunks.
+type FlowAcks struct {
+	mu       sync.Mutex
+	send     func(protocol.Frame) error
+	transfer uint64
+	every    int
+	pending  int
+}
+
+// NewFlowAcks returns a FlowAcks for transfer that sends its frames with
+// send, e.g. Session.SendFrame. every <= 1 acknowledges each chunk; larger
+// values send fewer frames but should stay well below the sender's window.
+func NewFlowAcks(send func(protocol.Frame) error, transfer uint64, every int) *FlowAcks {
+	return &FlowAcks{send: send, transfer: transfer, every: max(1, every)}
+}
+
+// Consumed records that n more chunks were consumed and acknowledges them
+// once enough are pending.
+func (a *FlowAcks) Consumed(n int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending += n
+	if a.pending < a.every {
+		return nil
+	}
+	return a.flushLocked()
+}
+
+// Flush acknowledges the chunks still pending.
+func (a *FlowAcks) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.flushLocked()
+}
+
+func (a *FlowAcks) flushLocked() error {
+	if a.pending == 0 {
+		return nil
+	}
+	n := uint32(min(uint64(a.pending), math.MaxUint32))
+	if err := a.send(protocol.NewAckFrame(a.transfer, n)); err != nil {
+		return err
+	}
+	a.pending -= int(n)
+	return nil
+}
+
+func (f *FlowControl) signalLocked() {
+	close(f.wake)
+	f.wake = make(chan struct{})
+}
@@ -0,0 +1,75 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// laneMinBytes is the smallest batch HashChunks spreads over several lanes;
+// below it starting the lanes costs more than they save.
+const laneMinBytes = 1 << 20
+
+// HashChunks returns HashChunk of each element of data.
+//
+// Large batches are hashed in parallel lanes, one per CPU, each taking the
+// next unhashed chunk. crypto/sha256 already uses the SHA extensions of
+// amd64 and arm64 where the CPU has them, which outpace AVX2 multi-buffer
+// schemes per core, so every lane runs at the hardware's speed and CPUs
+// without them fall back to the portable code transparently.
+func HashChunks(data [][]byte) [][]byte {
+	sums, _ := HashChunksContext(context.Background(), data)
+	return sums
+}
+
+// HashChunksContext is HashChunks that stops with ctx.Err() once ctx is
+// done, checking between chunks.
+func HashChunksContext(ctx context.Context, data [][]byte) ([][]byte, error) {
+	// One backing array keeps the sums together and saves an allocation
+	// per chunk.
+	buf := make([]byte, len(data)*sha256.Size)
+	sums := make([][]byte, len(data))
+	for i := range sums {
+		sums[i] = buf[i*sha256.Size : (i+1)*sha256.Size : (i+1)*sha256.Size]
+	}
+
+	var total int
+	for _, d := range data {
+		total += len(d)
+	}
+	lanes := min(runtime.GOMAXPROCS(0), len(data))
+	if lanes <= 1 || total < laneMinBytes {
+		for i, d := range data {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			h := sha256.Sum256(d)
+			copy(sums[i], h[:])
+		}
+		return sums, nil
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range lanes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				i := int(next.Add(1) - 1)
+				if i >= len(data) {
+					return
+				}
+				h := sha256.Sum256(data[i])
+				copy(sums[i], h[:])
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
@@ -0,0 +1,81 @@
+package transfer
+
+import (
+	"errors"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+)
+
+var (
+	ErrManifestInvalid = errors.New("transfer: invalid manifest")
+)
+
+// Manifest describes a chunked object: its size, chunking and per-chunk hashes.
+// The Merkle root binds the hashes together, so a manifest obtained from an
+// untrusted source can be checked against a root learned out of band.
+type Manifest struct {
+	Root        []byte   `json:"root"`
+	Size        int64    `json:"size"`
+	ChunkSize   int      `json:"chunk_size"`
+	ChunkHashes [][]byte `json:"chunk_hashes"`
+}
+
+// NewManifest builds a manifest from chunks produced by a Chunker.
+func NewManifest(chunks []Chunk, chunkSize int) (*Manifest, error) {
+	hashes := make([][]byte, len(chunks))
+	var size int64
+	for i, c := range chunks {
+		hashes[i] = c.Hash
+		size += int64(len(c.Data))
+	}
+	tree, err := BuildMerkleTree(hashes)
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{
+		Root:        tree.Root(),
+		Size:        size,
+		ChunkSize:   chunkSize,
+		ChunkHashes: hashes,
+	}, nil
+}
+
+// BuildManifest chunks data and builds its manifest.
+func BuildManifest(data []byte, chunkSize int) (*Manifest, error) {
+	c := NewChunker(chunkSize)
+	return NewManifest(c.Split(data), c.ChunkSize())
+}
+
+// NumChunks returns the number of chunks in the object.
+func (m *Manifest) NumChunks() int { return len(m.ChunkHashes) }
+
+// ChunkLen returns the length of chunk i.
+func (m *Manifest) ChunkLen(i int) int {
+	if i == m.NumChunks()-1 {
+		return int(m.Size - int64(i)*int64(m.ChunkSize))
+	}
+	return m.ChunkSize
+}
+
+// Validate checks that the manifest is self-consistent and, if expectedRoot is
+// non-empty, that it matches.
+func (m *Manifest) Validate(expectedRoot []byte) error {
+	if m.ChunkSize <= 0 || len(m.ChunkHashes) == 0 || m.Size <= 0 {
+		return ErrManifestInvalid
+	}
+	n := int((m.Size + int64(m.ChunkSize) - 1) / int64(m.ChunkSize))
+	if n != len(m.ChunkHashes) {
+		return ErrManifestInvalid
+	}
+	tree, err := BuildMerkleTree(m.ChunkHashes)
+	if err != nil {
+		return err
+	}
+	if !crypto.Equal(tree.Root(), m.Root) {
+		return ErrManifestInvalid
+	}
+	if len(expectedRoot) > 0 && !crypto.Equal(m.Root, expectedRoot) {
+		return ErrIntegrityCheckFailed
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package transfer
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"math/bits"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
 )
 
 var (
@@ -14,62 +18,142 @@ var (
 
 // MerkleTree provides integrity verification for chunked data.
 // The root hash can be shared before transfer; recipients verify each chunk.
+//
+// The tree is padded to a power of two with hashes of empty chunks, but the
+// padding is never stored: a subtree of padding hashes to a constant per
+// height. Only the levels from sparseLevels up are kept, at 32 bytes per
+// node, and proofs recompute the lower interior nodes from the leaves, so a
+// tree costs about 4 bytes per chunk on top of the chunk hashes.
 type MerkleTree struct {
-	leaves [][]byte
-	nodes  [][]byte // full binary tree stored as array
+	leaves [][]byte // the chunk hashes, unpadded
+	height int      // of the root; the padded tree has 1<<height leaves
+	levels [][]byte // levels[h] holds the nodes at height h >= sparseLevels
 	root   []byte
 }
 
+// sparseLevels is how many levels above the leaves a MerkleTree does not
+// store. Recomputing them costs 2<<sparseLevels hashes per proof at most.
+const sparseLevels = 3
+
+// cancelCheckInterval is how many hashes the cancellable builders compute
+// between checks of their context.
+const cancelCheckInterval = 1024
+
+// padHashes[h] is the root of a subtree of height h holding only padding.
+var padHashes = func() [][]byte {
+	pads := make([][]byte, 64)
+	h := sha256.Sum256(nil)
+	pads[0] = h[:]
+	for i := 1; i < len(pads); i++ {
+		pads[i] = hashPair(nil, pads[i-1], pads[i-1])
+	}
+	return pads
+}()
+
+// hashPair appends the hash of left and right concatenated to dst.
+func hashPair(dst, left, right []byte) []byte {
+	var buf [2 * sha256.Size]byte
+	h := sha256.Sum256(append(append(buf[:0], left...), right...))
+	return append(dst, h[:]...)
+}
+
 // BuildMerkleTree constructs a Merkle tree from chunk hashes.
-// Each chunk should be hashed with SHA-256 before passing here.
+// Each chunk should be hashed with SHA-256 before passing here. The tree
+// keeps chunkHashes, which must not be modified afterwards.
 func BuildMerkleTree(chunkHashes [][]byte) (*MerkleTree, error) {
+	return BuildMerkleTreeContext(context.Background(), chunkHashes)
+}
+
+// BuildMerkleTreeContext is BuildMerkleTree that stops with ctx.Err() once
+// ctx is done, so a cancelled transfer of many chunks stops hashing.
+func BuildMerkleTreeContext(ctx context.Context, chunkHashes [][]byte) (*MerkleTree, error) {
 	if len(chunkHashes) == 0 {
 		return nil, ErrMerkleEmpty
 	}
-
-	// Pad to power of 2
-	n := 1
-	for n < len(chunkHashes) {
-		n *= 2
+	m := &MerkleTree{leaves: chunkHashes}
+	for 1<<m.height < len(chunkHashes) {
+		m.height++
 	}
-	leaves := make([][]byte, n)
-	for i := range leaves {
-		if i < len(chunkHashes) {
-			leaves[i] = chunkHashes[i]
-		} else {
-			// Pad with hash of empty
-			h := sha256.Sum256(nil)
-			leaves[i] = h[:]
+	m.levels = make([][]byte, m.height+1)
+	if m.height == 0 {
+		m.root = chunkHashes[0]
+		return m, nil
+	}
+
+	// Build bottom-up, one flat level at a time; a level missing its right
+	// child is completed with padding.
+	var below []byte
+	hashed := 0
+	for h := 1; h <= m.height; h++ {
+		count := m.width(h)
+		level := make([]byte, 0, count*sha256.Size)
+		for i := 0; i < count; i++ {
+			if hashed%cancelCheckInterval == 0 && ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			hashed++
+			var left, right []byte
+			if h == 1 {
+				left, right = m.leaf(2*i), m.leaf(2*i+1)
+			} else {
+				left, right = flatNode(below, h-1, 2*i), flatNode(below, h-1, 2*i+1)
+			}
+			level = hashPair(level, left, right)
 		}
+		if h >= sparseLevels {
+			m.levels[h] = level
+		}
+		below = level
 	}
+	m.root = below[:sha256.Size:sha256.Size]
+	return m, nil
+}
 
-	// Build tree bottom-up
-	nodes := make([][]byte, 2*n-1)
-	// Leaves are at positions [n-1, 2n-2]
-	for i, leaf := range leaves {
-		nodes[n-1+i] = leaf
+// width returns how many nodes at height h cover at least one chunk.
+func (m *MerkleTree) width(h int) int {
+	return (len(m.leaves) + 1<<h - 1) >> h
+}
+
+func (m *MerkleTree) leaf(i int) []byte {
+	if i < len(m.leaves) {
+		return m.leaves[i]
 	}
-	// Internal nodes
-	for i := n - 2; i >= 0; i-- {
-		left := nodes[2*i+1]
-		right := nodes[2*i+2]
-		combined := append(left, right...)
-		h := sha256.Sum256(combined)
-		nodes[i] = h[:]
+	return padHashes[0]
+}
+
+// flatNode returns node i of level, a flat level at height h, or padding
+// past its end.
+func flatNode(level []byte, h, i int) []byte {
+	if (i+1)*sha256.Size > len(level) {
+		return padHashes[h]
 	}
+	return level[i*sha256.Size : (i+1)*sha256.Size : (i+1)*sha256.Size]
+}
 
-	return &MerkleTree{
-		leaves: leaves,
-		nodes:  nodes,
-		root:   nodes[0],
-	}, nil
+// node returns node i at height h, recomputing it from the leaves if its
+// level is not stored.
+func (m *MerkleTree) node(h, i int) []byte {
+	switch {
+	case h == 0:
+		return m.leaf(i)
+	case i >= m.width(h):
+		return padHashes[h]
+	case m.levels[h] != nil:
+		return flatNode(m.levels[h], h, i)
+	}
+	return hashPair(nil, m.node(h-1, 2*i), m.node(h-1, 2*i+1))
 }
 
-// Root returns the Merkle root hash.
-func (m *MerkleTree) Root() []byte { return m.root }
+// Root returns the Merkle root hash, or nil for a nil tree.
+func (m *MerkleTree) Root() []byte {
+	if m == nil {
+		return nil
+	}
+	return m.root
+}
 
 // RootHex returns the Merkle root as a hex string.
-func (m *MerkleTree) RootHex() string { return hex.EncodeToString(m.root) }
+func (m *MerkleTree) RootHex() string { return hex.EncodeToString(m.Root()) }
 
 // Proof generates a Merkle proof for the chunk at the given index.
 // Returns the sibling hashes needed to verify the chunk.
@@ -81,66 +165,74 @@ type Proof struct {
 }
 
 func (m *MerkleTree) GenerateProof(chunkIndex int) (Proof, error) {
-	n := len(m.leaves)
-	if chunkIndex < 0 || chunkIndex >= n {
+	if m == nil || len(m.leaves) == 0 || len(m.levels) != m.height+1 {
+		return Proof{}, ErrMerkleEmpty
+	}
+	if chunkIndex < 0 || chunkIndex >= 1<<m.height {
 		return Proof{}, ErrMerkleIndexRange
 	}
 
-	var siblings [][]byte
-	var isLeft []bool
-	idx := n - 1 + chunkIndex // position in nodes array
-
-	for idx > 0 {
-		parentIdx := (idx - 1) / 2
-		var siblingIdx int
-		if idx%2 == 1 {
-			siblingIdx = idx + 1
-		} else {
-			siblingIdx = idx - 1
-		}
-		siblings = append(siblings, m.nodes[siblingIdx])
-		isLeft = append(isLeft, idx%2 == 0)
-		idx = parentIdx
+	siblings := make([][]byte, 0, m.height)
+	isLeft := make([]bool, 0, m.height)
+	idx := chunkIndex
+	for h := 0; h < m.height; h++ {
+		siblings = append(siblings, m.node(h, idx^1))
+		isLeft = append(isLeft, idx%2 == 1)
+		idx /= 2
 	}
 
 	return Proof{
 		ChunkIndex: chunkIndex,
-		ChunkHash:  m.leaves[chunkIndex],
+		ChunkHash:  m.leaf(chunkIndex),
 		Siblings:   siblings,
 		IsLeft:     isLeft,
 	}, nil
 }
 
-// VerifyProof verifies a Merkle proof against the expected root.
+// VerifyProof verifies a Merkle proof against the expected root. A
+// malformed proof, e.g. one with fewer IsLeft flags than siblings, fails
+// verification.
 func VerifyProof(proof Proof, expectedRoot []byte) error {
+	if len(proof.Siblings) != len(proof.IsLeft) {
+		return ErrMerkleProofFail
+	}
 	current := proof.ChunkHash
 	for i, sibling := range proof.Siblings {
-		var combined []byte
+		combined := make([]byte, 0, len(sibling)+len(current))
 		if proof.IsLeft[i] {
-			combined = append(sibling, current...)
+			combined = append(append(combined, sibling...), current...)
 		} else {
-			combined = append(current, sibling...)
+			combined = append(append(combined, current...), sibling...)
 		}
 		h := sha256.Sum256(combined)
 		current = h[:]
 	}
 
-	if !bytesEqual(current, expectedRoot) {
+	if !crypto.Equal(current, expectedRoot) {
 		return ErrMerkleProofFail
 	}
 	return nil
 }
 
-func bytesEqual(a, b []byte) bool {
-	if len(a) != len(b) {
-		return false
+// VerifyProofAt is VerifyProof for a tree of numChunks chunks that also checks
+// the proof's path leads to proof.ChunkIndex, so a proof for one chunk cannot
+// be passed off as a proof for another.
+func VerifyProofAt(proof Proof, expectedRoot []byte, numChunks int) error {
+	if proof.ChunkIndex < 0 || proof.ChunkIndex >= numChunks {
+		return ErrMerkleIndexRange
+	}
+	height := bits.Len64(uint64(numChunks - 1))
+	if height > 63 || len(proof.Siblings) != height || len(proof.IsLeft) != height {
+		return ErrMerkleProofFail
 	}
-	for i := range a {
-		if a[i] != b[i] {
-			return false
+	// At each level the sibling is on the left exactly when the node on the
+	// path is a right child.
+	for level := range height {
+		if proof.IsLeft[level] != (uint64(proof.ChunkIndex)>>level&1 == 1) {
+			return ErrMerkleProofFail
 		}
 	}
-	return true
+	return VerifyProof(proof, expectedRoot)
 }
 
 // HashChunk computes the SHA-256 hash of a data chunk.
@@ -0,0 +1,127 @@
+package transfer
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrMetaTooLarge = errors.New("transfer: metadata too large")
+
+// MetaType identifies a metadata value.
+type MetaType uint16
+
+// Well-known metadata types. Types below 0x8000 are reserved for this
+// package; higher-level protocols tag with types from MetaPrivate up.
+const (
+	MetaFileID       MetaType = 0x0001 // file the chunks belong to
+	MetaStreamID     MetaType = 0x0002 // logical stream the chunks belong to
+	MetaPriority     MetaType = 0x0003 // scheduling priority, as SetUint
+	MetaErasureGroup MetaType = 0x0004 // erasure-coding group, as SetUint
+	MetaTransferID   MetaType = 0x0005 // TransferMux transfer, as SetUint
+	MetaPrivate      MetaType = 0x8000
+)
+
+// Meta is one typed metadata value.
+type Meta struct {
+	Type  MetaType
+	Value []byte
+}
+
+// Metadata is an ordered list of typed values tagging a batch or a chunk.
+// Decoding keeps values of types it does not know, so receivers ignore
+// them and can still pass them on.
+type Metadata []Meta
+
+// maxMetaSection bounds the encoded metadata of a batch or chunk, whose
+// length is written in 16 bits.
+const maxMetaSection = 1<<16 - 1
+
+// Get returns the first value of type t.
+func (m Metadata) Get(t MetaType) ([]byte, bool) {
+	for _, e := range m {
+		if e.Type == t {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set replaces the values of type t with v.
+func (m *Metadata) Set(t MetaType, v []byte) {
+	out := (*m)[:0]
+	for _, e := range *m {
+		if e.Type != t {
+			out = append(out, e)
+		}
+	}
+	*m = append(out, Meta{Type: t, Value: v})
+}
+
+// Uint returns the value of type t decoded as an unsigned varint.
+func (m Metadata) Uint(t MetaType) (uint64, bool) {
+	v, ok := m.Get(t)
+	if !ok {
+		return 0, false
+	}
+	n, size := binary.Uvarint(v)
+	return n, size == len(v)
+}
+
+// SetUint sets the value of type t to n encoded as an unsigned varint.
+func (m *Metadata) SetUint(t MetaType, n uint64) {
+	m.Set(t, binary.AppendUvarint(nil, n))
+}
+
+// size returns the encoded size of m, without its length prefix.
+func (m Metadata) size() int {
+	size := 0
+	for _, e := range m {
+		size += 2 + 2 + len(e.Value)
+	}
+	return size
+}
+
+// appendMeta appends m to buf as a uint16 section length followed by
+// type (uint16), length (uint16) and value for each entry.
+func appendMeta(buf []byte, m Metadata) ([]byte, error) {
+	size := m.size()
+	if size > maxMetaSection {
+		return nil, ErrMetaTooLarge
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(size))
+	for _, e := range m {
+		if len(e.Value) > maxMetaSection {
+			return nil, ErrMetaTooLarge
+		}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(e.Type))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(e.Value)))
+		buf = append(buf, e.Value...)
+	}
+	return buf, nil
+}
+
+// decodeMeta reads a metadata section from the start of data and returns it
+// with the number of bytes consumed.
+func decodeMeta(data []byte) (Metadata, int, error) {
+	if len(data) < 2 {
+		return nil, 0, errBatchTruncated
+	}
+	size := int(binary.BigEndian.Uint16(data))
+	if 2+size > len(data) {
+		return nil, 0, errBatchTruncated
+	}
+	var m Metadata
+	for sec := data[2 : 2+size]; len(sec) > 0; {
+		if len(sec) < 4 {
+			return nil, 0, errBatchTruncated
+		}
+		t := MetaType(binary.BigEndian.Uint16(sec))
+		n := int(binary.BigEndian.Uint16(sec[2:]))
+		if 4+n > len(sec) {
+			return nil, 0, errBatchTruncated
+		}
+		m = append(m, Meta{Type: t, Value: append([]byte(nil), sec[4:4+n]...)})
+		sec = sec[4+n:]
+	}
+	return m, 2 + size, nil
+}
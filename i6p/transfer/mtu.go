@@ -0,0 +1,43 @@
+package transfer
+
+import "strconv"
+
+// PathMTUCapability is the HELLO capability carrying the MTU of the sender's
+// first hop towards the peer, in decimal bytes (see quic.ProbePathMTU).
+const PathMTUCapability = "i6p.path-mtu"
+
+const (
+	// MinPathMTU is assumed when a peer does not advertise its MTU: the
+	// smallest MTU IPv6 allows.
+	MinPathMTU = 1280
+	// JumboPathMTU is the smallest MTU treated as a jumbo-frame path.
+	JumboPathMTU = 9000
+	// jumboChunkScale multiplies the chunk size on jumbo paths.
+	jumboChunkScale = 4
+)
+
+// NegotiatePathMTU returns the smaller of the MTUs both peers advertised
+// under PathMTUCapability. A missing or invalid value counts as MinPathMTU.
+func NegotiatePathMTU(local, remote map[string]string) int {
+	return min(parsePathMTU(local[PathMTUCapability]), parsePathMTU(remote[PathMTUCapability]))
+}
+
+func parsePathMTU(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < MinPathMTU {
+		return MinPathMTU
+	}
+	return n
+}
+
+// ForPathMTU returns c tuned for a path with the given MTU. On jumbo paths
+// chunks, and with them batches, grow four-fold, up to what a batch can
+// carry, since every chunk costs a hash, a batch header and a flow-control
+// slot whatever its size. Other paths keep c unchanged.
+func (c TransferConfig) ForPathMTU(mtu int) TransferConfig {
+	if mtu < JumboPathMTU || c.ChunkSize <= 0 {
+		return c
+	}
+	c.ChunkSize = min(c.ChunkSize*jumboChunkScale, MaxBatchSize/2)
+	return c
+}
@@ -0,0 +1,235 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/TheusHen/I6P/i6p/protocol"
+)
+
+var ErrUnknownTransfer = errors.New("transfer: batch for unknown transfer")
+
+// BatchReceiver consumes the batches of one transfer. *BulkReceiver
+// implements it.
+type BatchReceiver interface {
+	ReceiveBatch(b *Batch) error
+}
+
+// TransferMux carries many transfers over one stream pool. Outgoing
+// transfers get an ID from Open and tag their batches with it as
+// MetaTransferID; incoming batches are routed by that tag to the receiver
+// registered for it, so concurrent transfers to one peer share streams
+// instead of each opening its own set.
+//
+// IDs are chosen by the sending side, so each direction has its own: a
+// peer's receivers are keyed by the IDs the other peer assigned, and the
+// flow control frames it sends back carry those IDs to HandleFrame.
+type TransferMux struct {
+	pool   *StreamPool
+	nextID atomic.Uint64
+
+	mu        sync.Mutex
+	receivers map[uint64]BatchReceiver
+	accept    func(id uint64, first *Batch) BatchReceiver
+	flows     map[uint64]*FlowControl // of the senders from MuxTransfer.Sender
+	sendAck   func(protocol.Frame) error
+
+	stats MuxStats
+}
+
+// MuxStats counts the batches a TransferMux has routed.
+type MuxStats struct {
+	Routed  atomic.Int64 // delivered to a receiver
+	Dropped atomic.Int64 // untagged or for no receiver
+	Errors  atomic.Int64 // rejected by their receiver
+}
+
+// NewTransferMux creates a mux sending over up to maxStreams streams from
+// opener.
+func NewTransferMux(opener StreamOpener, maxStreams int) *TransferMux {
+	return &TransferMux{
+		pool:      NewStreamPool(opener, maxStreams),
+		receivers: make(map[uint64]BatchReceiver),
+		flows:     make(map[uint64]*FlowControl),
+	}
+}
+
+// SetAcceptor sets the function asked for the receiver of a transfer whose
+// first batch arrives before Handle was called for its ID. Returning nil
+// drops the batch. It is called with no locks held.
+func (m *TransferMux) SetAcceptor(f func(id uint64, first *Batch) BatchReceiver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accept = f
+}
+
+// SetFlowAcks makes the mux acknowledge the chunks of every tagged batch it
+// reads, delivered or not, by sending ACK frames for the batch's transfer
+// with send, e.g. Session.SendFrame. The peer feeds them to its own
+// HandleFrame, returning the slots of senders limited by a flow window.
+func (m *TransferMux) SetFlowAcks(send func(protocol.Frame) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sendAck = send
+}
+
+// HandleFrame applies a WINDOW_UPDATE or ACK frame from the peer to the
+// sender of the outgoing transfer it names. Frames for transfers without an
+// open sender return ErrUnknownTransfer.
+func (m *TransferMux) HandleFrame(frame protocol.Frame) error {
+	transfer, apply, err := decodeFlowFrame(frame)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	f, ok := m.flows[transfer]
+	m.mu.Unlock()
+	if !ok {
+		return ErrUnknownTransfer
+	}
+	apply(f)
+	return nil
+}
+
+func (m *TransferMux) removeFlow(f *FlowControl) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.flows[f.transfer] == f {
+		delete(m.flows, f.transfer)
+	}
+}
+
+// Handle routes the incoming batches of transfer id to r, e.g. for an ID
+// agreed with the peer out of band.
+func (m *TransferMux) Handle(id uint64, r BatchReceiver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.receivers[id] = r
+}
+
+// Remove stops routing transfer id; later batches for it are dropped
+// unless the acceptor takes them.
+func (m *TransferMux) Remove(id uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.receivers, id)
+}
+
+// Serve reads batches from r, one of the peer's streams, and routes them
+// until r ends, which returns nil, or fails. Batches nobody receives are
+// counted as dropped rather than ending the stream, which other transfers
+// share.
+func (m *TransferMux) Serve(ctx context.Context, r io.Reader) error {
+	for {
+		b, err := ReadBatchContext(ctx, r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = m.route(b)
+		switch {
+		case err == nil:
+			m.stats.Routed.Add(1)
+		case errors.Is(err, ErrUnknownTransfer):
+			m.stats.Dropped.Add(1)
+		default:
+			m.stats.Errors.Add(1)
+		}
+		if err := m.ack(b); err != nil {
+			return err
+		}
+	}
+}
+
+// ack acknowledges the chunks of b, if the mux sends ACKs and b is tagged.
+func (m *TransferMux) ack(b *Batch) error {
+	m.mu.Lock()
+	send := m.sendAck
+	m.mu.Unlock()
+	id, ok := b.Meta.Uint(MetaTransferID)
+	if send == nil || !ok || len(b.Chunks) == 0 {
+		return nil
+	}
+	return send(protocol.NewAckFrame(id, uint32(min(len(b.Chunks), math.MaxInt32))))
+}
+
+func (m *TransferMux) route(b *Batch) error {
+	id, ok := b.Meta.Uint(MetaTransferID)
+	if !ok {
+		return ErrUnknownTransfer
+	}
+	m.mu.Lock()
+	r, ok := m.receivers[id]
+	accept := m.accept
+	m.mu.Unlock()
+	if !ok && accept != nil {
+		if r = accept(id, b); r != nil {
+			m.mu.Lock()
+			if prev, ok := m.receivers[id]; ok {
+				r = prev // registered meanwhile
+			} else {
+				m.receivers[id] = r
+			}
+			m.mu.Unlock()
+		}
+	}
+	if r == nil {
+		return ErrUnknownTransfer
+	}
+	return r.ReceiveBatch(b)
+}
+
+// Open starts an outgoing transfer with a fresh ID.
+func (m *TransferMux) Open() *MuxTransfer {
+	return &MuxTransfer{mux: m, id: m.nextID.Add(1)}
+}
+
+// Stats returns the routing counters.
+func (m *TransferMux) Stats() *MuxStats { return &m.stats }
+
+// Close closes the mux's streams.
+func (m *TransferMux) Close() error {
+	return m.pool.Close()
+}
+
+// MuxTransfer is one outgoing transfer of a TransferMux.
+type MuxTransfer struct {
+	mux *TransferMux
+	id  uint64
+}
+
+// ID returns the transfer ID its batches are tagged with.
+func (t *MuxTransfer) ID() uint64 { return t.id }
+
+// SendBatch tags b with the transfer ID and writes it on one of the mux's
+// streams.
+func (t *MuxTransfer) SendBatch(ctx context.Context, b *Batch) error {
+	b.Meta.SetUint(MetaTransferID, t.id)
+	s, err := t.mux.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.mux.pool.Release(s)
+	return WriteBatchContext(ctx, s, b)
+}
+
+// Sender returns a BulkSender for this transfer, with all its
+// optimizations, writing over the mux's streams. Its flow control frames
+// are delivered by the mux's HandleFrame until it is closed. Closing it
+// leaves the streams open for the other transfers.
+func (t *MuxTransfer) Sender(config TransferConfig) *BulkSender {
+	bs := newBulkSender(t.mux.pool, config)
+	bs.mux = t.mux
+	bs.meta.SetUint(MetaTransferID, t.id)
+	bs.flow.transfer = t.id
+	t.mux.mu.Lock()
+	t.mux.flows[t.id] = bs.flow
+	t.mux.mu.Unlock()
+	return bs
+}
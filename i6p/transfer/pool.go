@@ -6,6 +6,9 @@ import (
 	"io"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/fault"
 )
 
 var (
@@ -27,6 +30,7 @@ type StreamPool struct {
 	mu      sync.Mutex
 	closed  atomic.Bool
 	created atomic.Int32
+	stats   *poolCounters
 }
 
 // NewStreamPool creates a pool that can manage up to maxSize concurrent streams.
@@ -38,6 +42,7 @@ func NewStreamPool(opener StreamOpener, maxSize int) *StreamPool {
 		opener:  opener,
 		maxSize: maxSize,
 		streams: make(chan io.ReadWriteCloser, maxSize),
+		stats:   newPoolCounters(),
 	}
 }
 
@@ -46,10 +51,12 @@ func (p *StreamPool) Acquire(ctx context.Context) (io.ReadWriteCloser, error) {
 	if p.closed.Load() {
 		return nil, ErrPoolClosed
 	}
+	start := time.Now()
 
 	// Try to get an existing stream first
 	select {
 	case s := <-p.streams:
+		p.stats.observeAcquire(time.Since(start))
 		return s, nil
 	default:
 	}
@@ -63,16 +70,22 @@ func (p *StreamPool) Acquire(ctx context.Context) (io.ReadWriteCloser, error) {
 			s, err := p.opener.OpenStreamSync(ctx)
 			if err != nil {
 				p.created.Add(-1)
+				p.stats.openErrors.Add(1)
 				return nil, err
 			}
+			p.stats.opened.Add(1)
+			p.stats.observeAcquire(time.Since(start))
 			return s, nil
 		}
 		p.mu.Unlock()
 	}
 
 	// Wait for an available stream
+	p.stats.startWait()
+	defer p.stats.waiting.Add(-1)
 	select {
 	case s := <-p.streams:
+		p.stats.observeAcquire(time.Since(start))
 		return s, nil
 	case <-ctx.Done():
 		return nil, ctx.Err()
@@ -83,6 +96,7 @@ func (p *StreamPool) Acquire(ctx context.Context) (io.ReadWriteCloser, error) {
 func (p *StreamPool) Release(s io.ReadWriteCloser) {
 	if p.closed.Load() {
 		_ = s.Close()
+		p.stats.closed.Add(1)
 		return
 	}
 
@@ -91,6 +105,7 @@ func (p *StreamPool) Release(s io.ReadWriteCloser) {
 	default:
 		// Pool is full, close the stream
 		_ = s.Close()
+		p.stats.closed.Add(1)
 		p.created.Add(-1)
 	}
 }
@@ -104,6 +119,7 @@ func (p *StreamPool) Close() error {
 	close(p.streams)
 	for s := range p.streams {
 		_ = s.Close()
+		p.stats.closed.Add(1)
 	}
 	return nil
 }
@@ -118,9 +134,17 @@ func (p *StreamPool) Created() int {
 	return int(p.created.Load())
 }
 
+// Stats returns a snapshot of the pool's counters and acquire latency histogram.
+func (p *StreamPool) Stats() PoolStats {
+	return p.stats.snapshot()
+}
+
 // ParallelWriter provides parallel chunk transmission across multiple streams.
 type ParallelWriter struct {
 	pool      *StreamPool
+	flow      *FlowControl
+	sched     *ScheduledTransfer
+	meta      Metadata
 	workers   int
 	chunkChan chan CompressedChunk
 	errChan   chan error
@@ -140,6 +164,23 @@ func NewParallelWriter(pool *StreamPool, workers int) *ParallelWriter {
 	}
 }
 
+// SetFlowControl limits in-flight chunks with f. Must be called before Start.
+func (pw *ParallelWriter) SetFlowControl(f *FlowControl) {
+	pw.flow = f
+}
+
+// SetScheduler makes each chunk wait for a slot from t, sharing the peer fairly
+// with other transfers. Must be called before Start.
+func (pw *ParallelWriter) SetScheduler(t *ScheduledTransfer) {
+	pw.sched = t
+}
+
+// SetMeta tags every batch the writer sends with m. Must be called before
+// Start.
+func (pw *ParallelWriter) SetMeta(m Metadata) {
+	pw.meta = m
+}
+
 // Start begins the worker goroutines.
 func (pw *ParallelWriter) Start(ctx context.Context) {
 	for i := 0; i < pw.workers; i++ {
@@ -169,7 +210,27 @@ func (pw *ParallelWriter) worker(ctx context.Context) {
 	}
 }
 
-func (pw *ParallelWriter) sendChunk(ctx context.Context, chunk CompressedChunk) error {
+func (pw *ParallelWriter) sendChunk(ctx context.Context, chunk CompressedChunk) (err error) {
+	if pw.flow != nil {
+		// The slot is returned by the receiver's ACK, or here if the chunk
+		// does not go out.
+		var counted bool
+		if counted, err = pw.flow.acquire(ctx); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil && counted {
+				pw.flow.Release()
+			}
+		}()
+	}
+	if pw.sched != nil {
+		if err := pw.sched.Acquire(ctx); err != nil {
+			return err
+		}
+		defer pw.sched.Release()
+	}
+
 	stream, err := pw.pool.Acquire(ctx)
 	if err != nil {
 		return err
@@ -178,8 +239,13 @@ func (pw *ParallelWriter) sendChunk(ctx context.Context, chunk CompressedChunk)
 
 	// Create a single-chunk batch for transmission
 	batch := NewBatch()
+	batch.Meta = pw.meta
 	batch.Add(chunk)
-	return WriteBatch(stream, batch)
+	if err := WriteBatchContext(ctx, stream, batch); err != nil {
+		pw.pool.stats.writeErrors.Add(1)
+		return err
+	}
+	return nil
 }
 
 // Send queues a chunk for transmission.
@@ -209,11 +275,13 @@ func (pw *ParallelWriter) Wait() error {
 
 // ParallelReader provides parallel chunk reception across multiple streams.
 type ParallelReader struct {
-	pool       *StreamPool
-	workers    int
-	resultChan chan Chunk
-	errChan    chan error
-	wg         sync.WaitGroup
+	pool         *StreamPool
+	workers      int
+	batchTimeout time.Duration
+	faults       fault.Injector
+	resultChan   chan Chunk
+	errChan      chan error
+	wg           sync.WaitGroup
 }
 
 // NewParallelReader creates a reader that receives chunks in parallel.
@@ -227,11 +295,28 @@ func NewParallelReader(pool *StreamPool, workers int, bufferSize int) *ParallelR
 	return &ParallelReader{
 		pool:       pool,
 		workers:    workers,
+		faults:     fault.Nop,
 		resultChan: make(chan Chunk, bufferSize),
 		errChan:    make(chan error, workers),
 	}
 }
 
+// SetBatchTimeout bounds each batch read, including the wait for its length
+// prefix, so a stalled peer ends the stream with ErrBatchTimeout. The overall
+// transfer deadline still comes from the context passed to StartReader.
+// Must be called before StartReader.
+func (pr *ParallelReader) SetBatchTimeout(d time.Duration) {
+	pr.batchTimeout = d
+}
+
+// SetFaultInjector makes the reader consult inj for every batch received
+// (fault.BatchRead: drop or delay the whole batch) and every chunk in it
+// (fault.ChunkData: corrupt the payload, which then fails hash verification).
+// Must be called before StartReader.
+func (pr *ParallelReader) SetFaultInjector(inj fault.Injector) {
+	pr.faults = fault.Or(inj)
+}
+
 // StartReader begins reading from a single stream (for testing).
 func (pr *ParallelReader) StartReader(ctx context.Context, stream io.ReadWriteCloser) {
 	pr.wg.Add(1)
@@ -249,7 +334,7 @@ func (pr *ParallelReader) readFromStream(ctx context.Context, stream io.ReadWrit
 		default:
 		}
 
-		batch, err := ReadBatch(stream)
+		batch, err := pr.readBatch(ctx, stream)
 		if err != nil {
 			if err != io.EOF {
 				select {
@@ -259,8 +344,21 @@ func (pr *ParallelReader) readFromStream(ctx context.Context, stream io.ReadWrit
 			}
 			return
 		}
+		if d := pr.faults.Delay(fault.BatchRead); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+		}
+		if pr.faults.Drop(fault.BatchRead) {
+			continue
+		}
 
 		for _, cc := range batch.Chunks {
+			cc.Data = pr.faults.Corrupt(fault.ChunkData, cc.Data)
 			chunk, err := DecompressChunk(cc)
 			if err != nil {
 				select {
@@ -278,6 +376,15 @@ func (pr *ParallelReader) readFromStream(ctx context.Context, stream io.ReadWrit
 	}
 }
 
+func (pr *ParallelReader) readBatch(ctx context.Context, stream io.ReadWriteCloser) (*Batch, error) {
+	if pr.batchTimeout <= 0 {
+		return ReadBatchContext(ctx, stream)
+	}
+	ctx, cancel := context.WithTimeout(ctx, pr.batchTimeout)
+	defer cancel()
+	return ReadBatchContext(ctx, stream)
+}
+
 // Results returns the channel for received chunks.
 func (pr *ParallelReader) Results() <-chan Chunk {
 	return pr.resultChan
@@ -6,6 +6,13 @@ import (
 	"io"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/fault"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/protocol"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
 )
 
 // mockStream implements io.ReadWriteCloser for testing.
@@ -95,6 +102,203 @@ func TestStreamPoolAcquireRelease(t *testing.T) {
 	}
 }
 
+func TestStreamPoolStats(t *testing.T) {
+	pool := NewStreamPool(newMockOpener(1), 1)
+	ctx := context.Background()
+
+	s, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s2, err := pool.Acquire(ctx)
+		if err == nil {
+			pool.Release(s2)
+		}
+	}()
+	deadline := time.Now().Add(time.Second)
+	for pool.Stats().Waiting == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	pool.Release(s)
+	<-done
+	_ = pool.Close()
+
+	stats := pool.Stats()
+	if stats.Acquires != 2 || stats.Opened != 1 || stats.Closed != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if stats.MaxWaiting != 1 || stats.Waiting != 0 {
+		t.Fatalf("waiting=%d max=%d", stats.Waiting, stats.MaxWaiting)
+	}
+	var n int64
+	for _, b := range stats.AcquireLatency {
+		n += b.Count
+	}
+	if n != stats.Acquires {
+		t.Fatalf("histogram counts %d acquires, want %d", n, stats.Acquires)
+	}
+}
+
+func TestFlowControlPauseResume(t *testing.T) {
+	flow := NewFlowControl(2)
+	_ = flow.HandleFrame(protocol.NewWindowUpdateFrame(0, 0))
+	if flow.Window() != 0 {
+		t.Fatalf("expected paused window")
+	}
+
+	opener := newMockOpener(1)
+	pool := NewStreamPool(opener, 1)
+	defer func() {
+		_ = pool.Close()
+	}()
+	pw := NewParallelWriter(pool, 2)
+	pw.SetFlowControl(flow)
+	pw.Start(context.Background())
+
+	chunk := CompressChunk(Chunk{Index: 0, Data: []byte("x"), Hash: HashChunk([]byte("x"))}, CompressionFast)
+	_ = pw.Send(chunk)
+
+	time.Sleep(20 * time.Millisecond)
+	if opener.streams[0].buf.Len() != 0 {
+		t.Fatalf("expected no data while paused")
+	}
+
+	if err := flow.HandleFrame(protocol.NewWindowUpdateFrame(0, 1)); err != nil {
+		t.Fatalf("HandleFrame: %v", err)
+	}
+	if err := pw.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if opener.streams[0].buf.Len() == 0 {
+		t.Fatalf("expected data after resume")
+	}
+	// The chunk stays in flight until the receiver acknowledges it.
+	if flow.InFlight() != 1 {
+		t.Fatalf("expected 1 unacknowledged chunk, got %d", flow.InFlight())
+	}
+	if err := flow.HandleFrame(protocol.NewAckFrame(0, 1)); err != nil {
+		t.Fatalf("HandleFrame ACK: %v", err)
+	}
+	if flow.InFlight() != 0 {
+		t.Fatalf("expected no chunks in flight, got %d", flow.InFlight())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	flow.Pause()
+	if err := flow.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline while paused, got %v", err)
+	}
+
+	if err := flow.HandleFrame(protocol.Frame{Type: protocol.MessageTypePing}); err != ErrUnexpectedFrame {
+		t.Fatalf("expected ErrUnexpectedFrame, got %v", err)
+	}
+	if err := flow.HandleFrame(protocol.NewWindowUpdateFrame(7, 1)); err != ErrUnknownTransfer {
+		t.Fatalf("expected ErrUnknownTransfer for another transfer, got %v", err)
+	}
+	if flow.Window() != 0 {
+		t.Fatalf("another transfer's WINDOW_UPDATE changed the window to %d", flow.Window())
+	}
+}
+
+func TestFlowControlUnlimitedUntilWindow(t *testing.T) {
+	flow := NewFlowControl(0)
+	// A receiver that never acknowledges does not use up an unlimited window.
+	for range 5 {
+		if err := flow.Acquire(context.Background()); err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+	}
+	if flow.InFlight() != 0 {
+		t.Fatalf("unlimited window counted %d chunks in flight", flow.InFlight())
+	}
+
+	if err := flow.HandleFrame(protocol.NewWindowUpdateFrame(0, 2)); err != nil {
+		t.Fatalf("HandleFrame: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for range 2 {
+		if err := flow.Acquire(ctx); err != nil {
+			t.Fatalf("Acquire after first window: %v", err)
+		}
+	}
+	if flow.InFlight() != 2 {
+		t.Fatalf("expected 2 chunks in flight, got %d", flow.InFlight())
+	}
+}
+
+// sentBatches counts the complete batches written to m so far.
+func sentBatches(m *mockStream) int {
+	m.mu.Lock()
+	r := bytes.NewReader(bytes.Clone(m.buf.Bytes()))
+	m.mu.Unlock()
+	n := 0
+	for {
+		if _, err := ReadBatch(r); err != nil {
+			return n
+		}
+		n++
+	}
+}
+
+func TestFlowControlStalledReader(t *testing.T) {
+	const window, total = 2, 5
+	flow := NewFlowControl(window)
+	opener := newMockOpener(1)
+	pool := NewStreamPool(opener, 1)
+	defer pool.Close()
+	pw := NewParallelWriter(pool, 4)
+	pw.SetFlowControl(flow)
+	pw.Start(context.Background())
+	for i := range total {
+		data := []byte{byte(i)}
+		_ = pw.Send(CompressChunk(Chunk{Index: i, Data: data, Hash: HashChunk(data)}, CompressionFast))
+	}
+
+	waitSent := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for sentBatches(opener.streams[0]) < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d chunks sent, want %d", sentBatches(opener.streams[0]), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The stream accepts everything, but a receiver that consumes nothing
+	// holds the sender to its window.
+	waitSent(window)
+	time.Sleep(30 * time.Millisecond)
+	if n := sentBatches(opener.streams[0]); n != window {
+		t.Fatalf("%d chunks sent to a stalled receiver, want %d", n, window)
+	}
+
+	acks := NewFlowAcks(flow.HandleFrame, 0, 1)
+	for consumed := 0; consumed < total; {
+		waitSent(consumed + 1)
+		n := sentBatches(opener.streams[0])
+		if n-consumed > window {
+			t.Fatalf("%d chunks unacknowledged, window %d", n-consumed, window)
+		}
+		if err := acks.Consumed(n - consumed); err != nil {
+			t.Fatalf("Consumed: %v", err)
+		}
+		consumed = n
+	}
+	if err := pw.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if flow.InFlight() != 0 {
+		t.Fatalf("%d chunks in flight after all were acknowledged", flow.InFlight())
+	}
+}
+
 func TestBulkReceiverAssemble(t *testing.T) {
 	receiver := NewBulkReceiver(DefaultTransferConfig())
 
@@ -134,6 +338,79 @@ func TestBulkReceiverAssemble(t *testing.T) {
 	}
 }
 
+func TestBulkReceiverVerifyReport(t *testing.T) {
+	data := []byte("integrity report test data spanning several chunks")
+	chunker := NewChunker(8)
+	chunks := chunker.Split(data)
+	manifest, _ := NewManifest(chunks, chunker.ChunkSize())
+
+	receiver := NewBulkReceiver(DefaultTransferConfig())
+	receiver.SetManifest(manifest)
+	for i, c := range chunks {
+		if i == 1 {
+			continue // lost in transit
+		}
+		if i == 3 {
+			// Self-consistent but wrong payload.
+			c = Chunk{Index: 3, Data: []byte("tampered"), Hash: HashChunk([]byte("tampered"))}
+		}
+		_ = receiver.ReceiveChunk(CompressChunk(c, CompressionFast))
+	}
+
+	report := receiver.Verify(nil)
+	if report.OK() {
+		t.Fatalf("expected failing report")
+	}
+	if len(report.Missing) != 1 || report.Missing[0] != 1 {
+		t.Fatalf("unexpected missing: %v", report.Missing)
+	}
+	if len(report.Corrupt) != 1 || report.Corrupt[0] != 3 {
+		t.Fatalf("unexpected corrupt: %v", report.Corrupt)
+	}
+	if got := report.Retransmit(); len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Fatalf("unexpected retransmit list: %v", got)
+	}
+
+	if err := receiver.ReceiveChunk(CompressChunk(chunks[3], CompressionFast)); err != ErrChunkConflict {
+		t.Fatalf("expected ErrChunkConflict, got %v", err)
+	}
+	receiver.Discard(report.Corrupt...)
+	for _, i := range report.Retransmit() {
+		if err := receiver.ReceiveChunk(CompressChunk(chunks[i], CompressionFast)); err != nil {
+			t.Fatalf("retransmit %d: %v", i, err)
+		}
+	}
+	if report := receiver.Verify(manifest.Root); !report.OK() {
+		t.Fatalf("expected clean report, got %+v", report)
+	}
+}
+
+func TestBulkReceiverDuplicates(t *testing.T) {
+	chunks := NewChunker(8).Split([]byte("duplicate chunk detection"))
+	receiver := NewBulkReceiver(DefaultTransferConfig())
+
+	for i := 0; i < 3; i++ {
+		if err := receiver.ReceiveChunk(CompressChunk(chunks[0], CompressionFast)); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+	}
+	stats := receiver.Stats()
+	if stats.ChunksReceived.Load() != 1 || stats.Duplicates.Load() != 2 {
+		t.Fatalf("received=%d duplicates=%d", stats.ChunksReceived.Load(), stats.Duplicates.Load())
+	}
+	if rate := stats.DuplicateRate(); rate < 0.66 || rate > 0.67 {
+		t.Fatalf("unexpected duplicate rate %f", rate)
+	}
+
+	forged := Chunk{Index: 0, Data: []byte("forged!!"), Hash: HashChunk([]byte("forged!!"))}
+	if err := receiver.ReceiveChunk(CompressChunk(forged, CompressionFast)); err != ErrChunkConflict {
+		t.Fatalf("expected ErrChunkConflict, got %v", err)
+	}
+	if stats.Conflicts.Load() != 1 {
+		t.Fatalf("conflict not counted")
+	}
+}
+
 func BenchmarkBulkSendSimulated(b *testing.B) {
 	data := make([]byte, 10*1024*1024) // 10 MB
 	for i := range data {
@@ -161,3 +438,318 @@ func BenchmarkBulkSendSimulated(b *testing.B) {
 		_, _ = BuildMerkleTree(hashes)
 	}
 }
+
+// scriptedFaults drops the batch numbered dropBatch and corrupts the chunk
+// numbered corruptChunk, counting from zero in arrival order.
+type scriptedFaults struct {
+	mu                      sync.Mutex
+	batches, chunks         int
+	dropBatch, corruptChunk int
+}
+
+func (f *scriptedFaults) Drop(p fault.Point) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches++
+	return f.batches-1 == f.dropBatch
+}
+
+func (f *scriptedFaults) Delay(fault.Point) time.Duration { return 0 }
+
+func (f *scriptedFaults) Corrupt(p fault.Point, b []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.chunks++
+	if f.chunks-1 != f.corruptChunk {
+		return b
+	}
+	out := append([]byte(nil), b...)
+	out[0] ^= 0xff
+	return out
+}
+
+func TestParallelReaderFaultInjection(t *testing.T) {
+	stream := &mockStream{}
+	for i := 0; i < 3; i++ {
+		data := []byte{byte(i), 1, 2, 3}
+		batch := NewBatch()
+		batch.Add(CompressChunk(Chunk{Index: i, Data: data, Hash: HashChunk(data)}, CompressionFast))
+		if err := WriteBatch(stream, batch); err != nil {
+			t.Fatalf("WriteBatch: %v", err)
+		}
+	}
+
+	pr := NewParallelReader(nil, 1, 4)
+	pr.SetFaultInjector(&scriptedFaults{dropBatch: 1, corruptChunk: 1})
+	pr.StartReader(context.Background(), stream)
+	pr.Wait()
+
+	var got []int
+	for c := range pr.Results() {
+		got = append(got, c.Index)
+	}
+	// Batch 1 is dropped; the chunk of batch 2 (the second one decoded) is corrupted.
+	if len(got) != 1 || got[0] != 0 {
+		t.Fatalf("expected only chunk 0, got %v", got)
+	}
+	select {
+	case err := <-pr.Errors():
+		if err == nil {
+			t.Fatalf("expected a hash mismatch error")
+		}
+	default:
+		t.Fatalf("corrupted chunk was not reported")
+	}
+}
+
+func TestTransferMux(t *testing.T) {
+	opener := newMockOpener(2)
+	mux := NewTransferMux(opener, 2)
+	defer func() {
+		_ = mux.Close()
+	}()
+	ctx := context.Background()
+
+	cfg := DefaultTransferConfig()
+	cfg.ChunkSize = 1024
+	cfg.ParallelWorkers = 2
+	data := [][]byte{bytes.Repeat([]byte("first "), 2000), bytes.Repeat([]byte("second"), 3000)}
+	roots := make([][]byte, len(data))
+	ids := make([]uint64, len(data))
+	var wg sync.WaitGroup
+	for i := range data {
+		tr := mux.Open()
+		ids[i] = tr.ID()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bs := tr.Sender(cfg)
+			defer func() {
+				_ = bs.Close()
+			}()
+			root, err := bs.Send(ctx, data[i])
+			if err != nil {
+				t.Errorf("Send %d: %v", i, err)
+			}
+			roots[i] = root
+		}()
+	}
+	wg.Wait()
+	if ids[0] == ids[1] {
+		t.Fatal("transfers share an ID")
+	}
+	// A batch without a transfer ID is dropped.
+	stray := NewBatch()
+	stray.Add(CompressChunk(Chunk{Data: []byte("x"), Hash: HashChunk([]byte("x"))}, CompressionFast))
+	if err := WriteBatch(opener.streams[0], stray); err != nil {
+		t.Fatal(err)
+	}
+
+	in := NewTransferMux(newMockOpener(0), 1)
+	first := NewBulkReceiver(cfg)
+	in.Handle(ids[0], first)
+	accepted := make(map[uint64]*BulkReceiver)
+	in.SetAcceptor(func(id uint64, _ *Batch) BatchReceiver {
+		accepted[id] = NewBulkReceiver(cfg)
+		return accepted[id]
+	})
+	for _, s := range opener.streams {
+		if err := in.Serve(ctx, s); err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	}
+	if len(accepted) != 1 || accepted[ids[1]] == nil {
+		t.Fatalf("accepted transfers %v", accepted)
+	}
+	for i, br := range []*BulkReceiver{first, accepted[ids[1]]} {
+		got, err := br.Assemble(roots[i])
+		if err != nil || !bytes.Equal(got, data[i]) {
+			t.Fatalf("transfer %d: %v", i, err)
+		}
+	}
+	if in.Stats().Dropped.Load() != 1 || in.Stats().Routed.Load() == 0 {
+		t.Fatalf("routed %d, dropped %d", in.Stats().Routed.Load(), in.Stats().Dropped.Load())
+	}
+}
+
+func TestBulkReceiverAcksEveryChunk(t *testing.T) {
+	var acked uint32
+	acks := NewFlowAcks(func(f protocol.Frame) error {
+		ack, err := protocol.DecodeAck(f.Payload)
+		if err != nil || ack.Transfer != 3 {
+			t.Fatalf("ACK %+v: %v", ack, err)
+		}
+		acked += ack.Chunks
+		return nil
+	}, 3, 1)
+	br := NewBulkReceiver(DefaultTransferConfig())
+	br.SetFlowAcks(acks)
+
+	b := NewBatch()
+	for i, d := range []string{"a", "b", "c"} {
+		b.Add(CompressChunk(Chunk{Index: i, Data: []byte(d), Hash: HashChunk([]byte(d))}, CompressionFast))
+	}
+	b.Chunks[1].Data = []byte("corrupt")
+	if err := br.ReceiveBatch(b); err == nil {
+		t.Fatal("expected an error for the corrupt chunk")
+	}
+	// The chunks after the failure were sent too, so they are acknowledged.
+	if acked != 3 {
+		t.Fatalf("acknowledged %d of 3 chunks", acked)
+	}
+}
+
+// sessionOpener opens the streams of a StreamPool on a session.
+type sessionOpener struct{ s *session.Session }
+
+func (o sessionOpener) OpenStreamSync(ctx context.Context) (io.ReadWriteCloser, error) {
+	return o.s.OpenStream(ctx)
+}
+
+func TestFlowWindowOverSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	network := memory.NewNetwork()
+	ln, err := network.Listen("")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+	serverCh := make(chan *session.Session, 1)
+	go func() {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			serverCh <- nil
+			return
+		}
+		sess, err := session.HandshakeServer(ctx, conn, serverKP, session.HandshakeOptions{})
+		if err != nil {
+			t.Errorf("HandshakeServer: %v", err)
+		}
+		serverCh <- sess
+	}()
+	conn, err := network.Dial(ctx, ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client, err := session.HandshakeClient(ctx, conn, clientKP, session.HandshakeOptions{})
+	if err != nil {
+		t.Fatalf("HandshakeClient: %v", err)
+	}
+	server := <-serverCh
+	if server == nil {
+		t.FailNow()
+	}
+	defer client.CloseWithError(0, "")
+
+	// The receiving mux acknowledges every batch it reads on the session's
+	// control stream; the sending mux hands the ACKs to each transfer's
+	// flow controller by ID.
+	in := NewTransferMux(sessionOpener{server}, 1)
+	in.SetFlowAcks(server.SendFrame)
+	var mu sync.Mutex
+	receivers := make(map[uint64]*BulkReceiver)
+	cfg := DefaultTransferConfig()
+	cfg.ChunkSize = 512
+	cfg.ParallelStreams = 2
+	cfg.ParallelWorkers = 4
+	cfg.FlowWindow = 2
+	in.SetAcceptor(func(id uint64, _ *Batch) BatchReceiver {
+		mu.Lock()
+		defer mu.Unlock()
+		receivers[id] = NewBulkReceiver(cfg)
+		return receivers[id]
+	})
+	go func() {
+		for {
+			st, err := server.AcceptStream(ctx)
+			if err != nil {
+				return
+			}
+			go func() { _ = in.Serve(ctx, st) }()
+		}
+	}()
+
+	out := NewTransferMux(sessionOpener{client}, cfg.ParallelStreams)
+	defer out.Close()
+	updates := make(chan error, 1)
+	client.HandleFrame(protocol.MessageTypeAck, func(f protocol.Frame) { _ = out.HandleFrame(f) })
+	client.HandleFrame(protocol.MessageTypeWindowUpdate, func(f protocol.Frame) { updates <- out.HandleFrame(f) })
+
+	data := [][]byte{bytes.Repeat([]byte("first "), 3000), bytes.Repeat([]byte("second"), 4000)}
+	roots := make([][]byte, len(data))
+	ids := make([]uint64, len(data))
+	senders := make([]*BulkSender, len(data))
+	var wg sync.WaitGroup
+	for i := range data {
+		tr := out.Open()
+		ids[i], senders[i] = tr.ID(), tr.Sender(cfg)
+		defer senders[i].Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			root, err := senders[i].Send(ctx, data[i])
+			if err != nil {
+				t.Errorf("Send %d: %v", i, err)
+			}
+			roots[i] = root
+		}()
+	}
+	// Each transfer has many more chunks than its window, so both only
+	// finish if the receiver's ACKs reach the right sender.
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	for i := range data {
+		br := func() *BulkReceiver {
+			mu.Lock()
+			defer mu.Unlock()
+			return receivers[ids[i]]
+		}()
+		for br == nil || br.Stats().ChunksReceived.Load() < int64((len(data[i])+cfg.ChunkSize-1)/cfg.ChunkSize) {
+			if ctx.Err() != nil {
+				t.Fatalf("transfer %d incomplete", i)
+			}
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			br = receivers[ids[i]]
+			mu.Unlock()
+		}
+		got, err := br.Assemble(roots[i])
+		if err != nil || !bytes.Equal(got, data[i]) {
+			t.Fatalf("transfer %d: %v", i, err)
+		}
+	}
+
+	// A WINDOW_UPDATE for one transfer leaves the other alone.
+	if err := server.SendFrame(protocol.NewWindowUpdateFrame(ids[0], 0)); err != nil {
+		t.Fatalf("SendFrame: %v", err)
+	}
+	select {
+	case err := <-updates:
+		if err != nil {
+			t.Fatalf("HandleFrame: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("WINDOW_UPDATE not delivered")
+	}
+	if w0, w1 := senders[0].FlowControl().Window(), senders[1].FlowControl().Window(); w0 != 0 || w1 != cfg.FlowWindow {
+		t.Fatalf("windows after pausing transfer %d: %d, %d", ids[0], w0, w1)
+	}
+}
+
+func TestAcquireLatencyBucketsCopy(t *testing.T) {
+	b := AcquireLatencyBuckets()
+	b[0] = time.Hour
+	pool := NewStreamPool(newMockOpener(1), 1)
+	defer pool.Close()
+	stats := pool.Stats()
+	if len(stats.AcquireLatency) != len(AcquireLatencyBuckets())+1 || stats.AcquireLatency[0].Max == time.Hour {
+		t.Fatalf("histogram follows a caller's copy of the buckets: %+v", stats.AcquireLatency)
+	}
+}
@@ -0,0 +1,113 @@
+package transfer
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// acquireLatencyBuckets are the upper bounds of the StreamPool acquire
+// latency histogram. Latencies above the last bound fall into an overflow
+// bucket.
+var acquireLatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// AcquireLatencyBuckets returns the upper bounds of the StreamPool acquire
+// latency histogram (see PoolStats.AcquireLatency).
+func AcquireLatencyBuckets() []time.Duration {
+	return slices.Clone(acquireLatencyBuckets)
+}
+
+// LatencyBucket is one (non-cumulative) histogram bucket. Max is the bucket's
+// inclusive upper bound; the overflow bucket has Max == 0.
+type LatencyBucket struct {
+	Max   time.Duration
+	Count int64
+}
+
+// PoolStats is a point-in-time snapshot of a StreamPool's counters.
+//
+// A persistently non-zero Waiting (or a latency histogram skewed to the right)
+// with Opened == ParallelStreams means the pool is too small; high latency with
+// few waiters points at the peer's stream or flow-control limits instead.
+type PoolStats struct {
+	Acquires       int64           // successful Acquire calls
+	Waiting        int64           // callers currently blocked waiting for a stream
+	MaxWaiting     int64           // high-water mark of Waiting
+	Opened         int64           // streams opened
+	Closed         int64           // streams closed
+	OpenErrors     int64           // failed stream opens
+	WriteErrors    int64           // batch writes that failed on a pooled stream
+	TotalWait      time.Duration   // summed acquire latency
+	AcquireLatency []LatencyBucket // acquire latency histogram
+}
+
+// MeanAcquireLatency returns the average time spent in Acquire.
+func (s PoolStats) MeanAcquireLatency() time.Duration {
+	if s.Acquires == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.Acquires)
+}
+
+type poolCounters struct {
+	acquires    atomic.Int64
+	waiting     atomic.Int64
+	maxWaiting  atomic.Int64
+	opened      atomic.Int64
+	closed      atomic.Int64
+	openErrors  atomic.Int64
+	writeErrors atomic.Int64
+	totalWait   atomic.Int64
+	buckets     []atomic.Int64
+}
+
+func newPoolCounters() *poolCounters {
+	return &poolCounters{buckets: make([]atomic.Int64, len(acquireLatencyBuckets)+1)}
+}
+
+func (c *poolCounters) observeAcquire(d time.Duration) {
+	c.acquires.Add(1)
+	c.totalWait.Add(int64(d))
+	i := 0
+	for i < len(acquireLatencyBuckets) && d > acquireLatencyBuckets[i] {
+		i++
+	}
+	c.buckets[i].Add(1)
+}
+
+func (c *poolCounters) startWait() {
+	n := c.waiting.Add(1)
+	for {
+		cur := c.maxWaiting.Load()
+		if n <= cur || c.maxWaiting.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+func (c *poolCounters) snapshot() PoolStats {
+	s := PoolStats{
+		Acquires:       c.acquires.Load(),
+		Waiting:        c.waiting.Load(),
+		MaxWaiting:     c.maxWaiting.Load(),
+		Opened:         c.opened.Load(),
+		Closed:         c.closed.Load(),
+		OpenErrors:     c.openErrors.Load(),
+		WriteErrors:    c.writeErrors.Load(),
+		TotalWait:      time.Duration(c.totalWait.Load()),
+		AcquireLatency: make([]LatencyBucket, len(c.buckets)),
+	}
+	for i := range c.buckets {
+		if i < len(acquireLatencyBuckets) {
+			s.AcquireLatency[i].Max = acquireLatencyBuckets[i]
+		}
+		s.AcquireLatency[i].Count = c.buckets[i].Load()
+	}
+	return s
+}
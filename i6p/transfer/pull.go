@@ -0,0 +1,284 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+	"github.com/TheusHen/I6P/i6p/protocol"
+)
+
+var (
+	ErrRangeInvalid      = errors.New("transfer: byte range outside the object")
+	ErrChunksUnavailable = errors.New("transfer: peer does not have the requested chunks")
+)
+
+// ChunkLookup returns the chunk with the given index, or false if it is not
+// available.
+type ChunkLookup func(index int) (CompressedChunk, bool)
+
+// GetChunksFrames builds the GET_CHUNKS frames asking for the given chunks of
+// the transfer with Merkle root root, such as IntegrityReport.Retransmit. Long
+// lists are split over several frames.
+func GetChunksFrames(root []byte, indexes []int) ([]protocol.Frame, error) {
+	var frames []protocol.Frame
+	for len(indexes) > 0 {
+		n := min(len(indexes), protocol.MaxChunkIndexes)
+		req := protocol.ChunkRequest{Root: root, Indexes: make([]uint32, n)}
+		for i, idx := range indexes[:n] {
+			if idx < 0 {
+				return nil, ErrChunkIndexRange
+			}
+			req.Indexes[i] = uint32(idx)
+		}
+		f, err := protocol.NewGetChunksFrame(req)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, f)
+		indexes = indexes[n:]
+	}
+	return frames, nil
+}
+
+// AnswerGetChunks answers req, decoded from a GET_CHUNKS frame, on w: one
+// CHUNK_DATA frame per chunk lookup finds, then a single CHUNKS_UNAVAILABLE
+// frame listing the others, if any. Chunks too large for a frame are reported
+// unavailable.
+func AnswerGetChunks(w io.Writer, req protocol.ChunkRequest, lookup ChunkLookup) error {
+	var unavailable []uint32
+	for _, idx := range req.Indexes {
+		cc, ok := lookup(int(idx))
+		if !ok || len(cc.Data) > protocol.MaxChunkData {
+			unavailable = append(unavailable, idx)
+			continue
+		}
+		f, err := protocol.NewChunkDataFrame(protocol.ChunkData{
+			Root:  req.Root,
+			Index: idx,
+			Codec: uint8(cc.CodecID()),
+			Hash:  cc.OrigHash,
+			Data:  cc.Data,
+		})
+		if err != nil {
+			return err
+		}
+		if err := protocol.WriteFrame(w, f); err != nil {
+			return err
+		}
+	}
+	if len(unavailable) == 0 {
+		return nil
+	}
+	f, err := protocol.NewChunksUnavailableFrame(protocol.ChunkRequest{Root: req.Root, Indexes: unavailable})
+	if err != nil {
+		return err
+	}
+	return protocol.WriteFrame(w, f)
+}
+
+// ChunkFromFrame decodes a CHUNK_DATA frame into the root of its transfer and
+// a chunk for BulkReceiver.ReceiveChunk or FileReceiver.ReceiveChunk.
+func ChunkFromFrame(f protocol.Frame) (root []byte, cc CompressedChunk, err error) {
+	if f.Type != protocol.MessageTypeChunkData {
+		return nil, CompressedChunk{}, ErrUnexpectedFrame
+	}
+	cd, err := protocol.DecodeChunkData(f.Payload)
+	if err != nil {
+		return nil, CompressedChunk{}, err
+	}
+	return cd.Root, chunkWithCodec(int(cd.Index), CodecID(cd.Codec), cd.Data, cd.Hash), nil
+}
+
+// Lookup returns a ChunkLookup serving the chunks received so far,
+// compressed with LZ4 at level, so a receiver can pass them on to other
+// peers.
+func (br *BulkReceiver) Lookup(level CompressionLevel) ChunkLookup {
+	return func(index int) (CompressedChunk, bool) {
+		br.mu.Lock()
+		c, ok := br.chunks[index]
+		br.mu.Unlock()
+		if !ok {
+			return CompressedChunk{}, false
+		}
+		return CompressChunk(c, level), true
+	}
+}
+
+// ServeGetChunks answers GET_CHUNKS frames read from rw for the transfer with
+// Merkle root root until rw returns EOF. Requests for other transfers are
+// answered with CHUNKS_UNAVAILABLE.
+func ServeGetChunks(rw io.ReadWriter, root []byte, lookup ChunkLookup) error {
+	none := func(int) (CompressedChunk, bool) { return CompressedChunk{}, false }
+	for {
+		f, err := protocol.ReadFrame(rw)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if f.Type != protocol.MessageTypeGetChunks {
+			return ErrUnexpectedFrame
+		}
+		req, err := protocol.DecodeChunkRequest(f.Payload)
+		if err != nil {
+			return err
+		}
+		l := lookup
+		if !crypto.Equal(req.Root, root) {
+			l = none
+		}
+		if err := AnswerGetChunks(rw, req, l); err != nil {
+			return err
+		}
+	}
+}
+
+// ReceiveRange fetches length bytes at offset of the object described by m
+// from the peer on rw, which answers with ServeGetChunks or AnswerGetChunks.
+// Only the chunks covering the range are requested, and each is checked with
+// its Merkle proof against m.Root before its bytes are returned. Readers with
+// SetReadDeadline are unblocked when ctx is done. After an error rw is in an
+// unknown state and should be closed.
+func ReceiveRange(ctx context.Context, rw io.ReadWriter, m *Manifest, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 || offset+length > m.Size {
+		return nil, ErrRangeInvalid
+	}
+	if length == 0 {
+		return []byte{}, nil
+	}
+	rr, err := NewRangeReader(ctx, rw, m)
+	if err != nil {
+		return nil, err
+	}
+	return rr.ReadRange(ctx, offset, length)
+}
+
+// RangeReader is ReceiveRange for several ranges of one object in turn on
+// the same rw. The manifest is validated and its Merkle tree built once.
+type RangeReader struct {
+	rw   io.ReadWriter
+	m    *Manifest
+	tree *MerkleTree
+}
+
+// NewRangeReader validates m and prepares to read its ranges from rw.
+func NewRangeReader(ctx context.Context, rw io.ReadWriter, m *Manifest) (*RangeReader, error) {
+	if err := m.Validate(nil); err != nil {
+		return nil, err
+	}
+	tree, err := BuildMerkleTreeContext(ctx, m.ChunkHashes)
+	if err != nil {
+		return nil, err
+	}
+	return &RangeReader{rw: rw, m: m, tree: tree}, nil
+}
+
+// ReadRange fetches length bytes at offset, as ReceiveRange. After an error
+// the reader's rw is in an unknown state and should be closed.
+func (r *RangeReader) ReadRange(ctx context.Context, offset, length int64) ([]byte, error) {
+	rw, m, tree := r.rw, r.m, r.tree
+	if offset < 0 || length < 0 || offset+length > m.Size {
+		return nil, ErrRangeInvalid
+	}
+	if length == 0 {
+		return []byte{}, nil
+	}
+
+	first := int(offset / int64(m.ChunkSize))
+	last := int((offset + length - 1) / int64(m.ChunkSize))
+	indexes := make([]int, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		indexes = append(indexes, i)
+	}
+	frames, err := GetChunksFrames(m.Root, indexes)
+	if err != nil {
+		return nil, err
+	}
+
+	if rd, ok := rw.(readDeadliner); ok {
+		stop := context.AfterFunc(ctx, func() { _ = rd.SetReadDeadline(time.Now()) })
+		defer stop()
+		defer func() { _ = rd.SetReadDeadline(time.Time{}) }()
+	}
+	ctxErr := func(err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	// Requests are written while replies are read, so a peer answering the
+	// first request before reading the next cannot deadlock an unbuffered rw.
+	sent := make(chan error, 1)
+	go func() {
+		for _, f := range frames {
+			if err := protocol.WriteFrame(rw, f); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+
+	out := make([]byte, length)
+	pending := len(indexes)
+	got := make([]bool, len(indexes))
+	for pending > 0 {
+		f, err := protocol.ReadFrame(rw)
+		if err != nil {
+			return nil, ctxErr(err)
+		}
+		switch f.Type {
+		case protocol.MessageTypeChunksUnavailable:
+			un, err := protocol.DecodeChunkRequest(f.Payload)
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", ErrChunksUnavailable, un.Indexes)
+		case protocol.MessageTypeChunkData:
+		default:
+			return nil, ErrUnexpectedFrame
+		}
+		root, cc, err := ChunkFromFrame(f)
+		if err != nil {
+			return nil, err
+		}
+		if !crypto.Equal(root, m.Root) || cc.Index < first || cc.Index > last {
+			return nil, ErrUnexpectedFrame
+		}
+		if got[cc.Index-first] {
+			continue
+		}
+		c, err := DecompressChunk(cc)
+		if err != nil {
+			return nil, err
+		}
+		proof, err := tree.GenerateProof(c.Index)
+		if err != nil {
+			return nil, err
+		}
+		proof.ChunkHash = c.Hash
+		if err := VerifyProofAt(proof, m.Root, m.NumChunks()); err != nil {
+			return nil, fmt.Errorf("%w: chunk %d", ErrChunkHashInvalid, c.Index)
+		}
+		if len(c.Data) != m.ChunkLen(c.Index) {
+			return nil, fmt.Errorf("%w: chunk %d", ErrChunkHashInvalid, c.Index)
+		}
+
+		// Copy the part of the chunk inside [offset, offset+length).
+		start := int64(c.Index) * int64(m.ChunkSize)
+		lo := max(offset, start)
+		hi := min(offset+length, start+int64(len(c.Data)))
+		copy(out[lo-offset:], c.Data[lo-start:hi-start])
+		got[cc.Index-first] = true
+		pending--
+	}
+	if err := <-sent; err != nil {
+		return nil, ctxErr(err)
+	}
+	return out, nil
+}
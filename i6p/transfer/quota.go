@@ -0,0 +1,109 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+var (
+	ErrQuotaExceeded = errors.New("transfer: peer quota exceeded")
+	ErrDiskFull      = errors.New("transfer: not enough disk space")
+)
+
+// Rejection codes carried by a PreflightError, for the receiver to send back
+// to the peer, e.g. with Session.CloseWithError or as a stream error code.
+const (
+	RejectQuotaExceeded uint64 = 0x4951 // "IQ"
+	RejectDiskFull      uint64 = 0x4944 // "ID"
+)
+
+// PreflightError is the typed rejection of an incoming manifest. It wraps
+// ErrQuotaExceeded or ErrDiskFull.
+type PreflightError struct {
+	Err       error
+	Code      uint64 // RejectQuotaExceeded or RejectDiskFull
+	Requested int64  // bytes the manifest declares
+	Available int64  // bytes the receiver could still accept
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("%v: %d bytes requested, %d available", e.Err, e.Requested, e.Available)
+}
+
+func (e *PreflightError) Unwrap() error { return e.Err }
+
+// Quota admits incoming transfers before any data is received. Every admitted
+// manifest reserves its declared size against the sending peer's quota and
+// against the free space of Dir until the transfer is released.
+type Quota struct {
+	// PerPeer caps the bytes a single peer may have reserved at once;
+	// 0 means no per-peer limit.
+	PerPeer int64
+	// Dir is where incoming data is spooled. If set, a manifest is rejected
+	// when the filesystem's free space, less MinFree and bytes already
+	// reserved, cannot hold it. The check is skipped on platforms where free
+	// space cannot be read.
+	Dir     string
+	MinFree int64
+
+	mu       sync.Mutex
+	used     map[identity.PeerID]int64
+	reserved int64
+}
+
+// NewQuota returns a quota of perPeer bytes per peer, checking free space in
+// dir (if not empty).
+func NewQuota(perPeer int64, dir string) *Quota {
+	return &Quota{PerPeer: perPeer, Dir: dir}
+}
+
+// Preflight validates m and reserves its size for peer. On success the caller
+// must call release once the transfer completes or is abandoned; release is
+// idempotent. On rejection the error is a *PreflightError.
+func (q *Quota) Preflight(peer identity.PeerID, m *Manifest) (release func(), err error) {
+	if err := m.Validate(nil); err != nil {
+		return nil, err
+	}
+	size := m.Size
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.PerPeer > 0 && q.used[peer]+size > q.PerPeer {
+		return nil, &PreflightError{Err: ErrQuotaExceeded, Code: RejectQuotaExceeded, Requested: size, Available: max(q.PerPeer-q.used[peer], 0)}
+	}
+	if q.Dir != "" {
+		if free, ok := diskFree(q.Dir); ok {
+			avail := max(free-q.MinFree-q.reserved, 0)
+			if size > avail {
+				return nil, &PreflightError{Err: ErrDiskFull, Code: RejectDiskFull, Requested: size, Available: avail}
+			}
+		}
+	}
+	if q.used == nil {
+		q.used = make(map[identity.PeerID]int64)
+	}
+	q.used[peer] += size
+	q.reserved += size
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.reserved -= size
+			if q.used[peer] -= size; q.used[peer] <= 0 {
+				delete(q.used, peer)
+			}
+		})
+	}, nil
+}
+
+// Used returns the bytes currently reserved by peer.
+func (q *Quota) Used(peer identity.PeerID) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.used[peer]
+}
@@ -0,0 +1,145 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/clock"
+)
+
+// DefaultReapIdle is the idle period after which a Reaper expires a transfer.
+const DefaultReapIdle = 10 * time.Minute
+
+// Reapable is incomplete receiver state a Reaper can expire. BulkReceiver
+// and FileReceiver implement it.
+type Reapable interface {
+	Stats() *TransferStats
+	IsComplete() bool
+	// Abort releases the state: chunks held in memory, spool files on disk.
+	Abort() error
+}
+
+// ReapEvent reports a transfer expired by a Reaper.
+type ReapEvent struct {
+	ID   string
+	Idle time.Duration // time since the transfer last received a chunk
+	Err  error         // first error from Abort or from removing files
+}
+
+// Reaper expires receivers that stopped receiving chunks, so peers that
+// vanish mid-transfer do not leak memory and disk on long-running nodes.
+// A receiver is active while its chunk counters move; completed receivers
+// are dropped without being aborted.
+type Reaper struct {
+	idle   time.Duration
+	events func(ReapEvent)
+	clock  clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]*reapEntry
+}
+
+type reapEntry struct {
+	r        Reapable
+	files    []string
+	activity int64
+	since    time.Time
+}
+
+// NewReaper returns a reaper expiring transfers idle for longer than idle
+// (DefaultReapIdle if zero). events, if not nil, is called for every expired
+// transfer.
+func NewReaper(idle time.Duration, events func(ReapEvent)) *Reaper {
+	if idle <= 0 {
+		idle = DefaultReapIdle
+	}
+	return &Reaper{idle: idle, events: events, clock: clock.System, entries: make(map[string]*reapEntry)}
+}
+
+// SetClock sets the clock idle periods are measured by (clock.System if
+// nil). Must be called before Track or Run.
+func (rp *Reaper) SetClock(c clock.Clock) {
+	rp.clock = clock.Or(c)
+}
+
+// Track starts watching r under id, replacing any receiver tracked under the
+// same id. files, such as the transfer's checkpoint, are removed along with r
+// when it expires.
+func (rp *Reaper) Track(id string, r Reapable, files ...string) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.entries[id] = &reapEntry{r: r, files: files, activity: activity(r.Stats()), since: rp.clock.Now()}
+}
+
+// Forget stops watching id without touching its state.
+func (rp *Reaper) Forget(id string) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	delete(rp.entries, id)
+}
+
+// Len returns the number of tracked transfers.
+func (rp *Reaper) Len() int {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return len(rp.entries)
+}
+
+// Sweep expires transfers idle at now and returns their events.
+func (rp *Reaper) Sweep(now time.Time) []ReapEvent {
+	var expired []ReapEvent
+	var victims []*reapEntry
+	rp.mu.Lock()
+	for id, e := range rp.entries {
+		if e.r.IsComplete() {
+			delete(rp.entries, id)
+			continue
+		}
+		if a := activity(e.r.Stats()); a != e.activity {
+			e.activity, e.since = a, now
+			continue
+		}
+		if idle := now.Sub(e.since); idle >= rp.idle {
+			delete(rp.entries, id)
+			expired = append(expired, ReapEvent{ID: id, Idle: idle})
+			victims = append(victims, e)
+		}
+	}
+	rp.mu.Unlock()
+
+	for i, e := range victims {
+		err := e.r.Abort()
+		for _, f := range e.files {
+			if rerr := os.Remove(f); rerr != nil && !errors.Is(rerr, fs.ErrNotExist) && err == nil {
+				err = rerr
+			}
+		}
+		expired[i].Err = err
+		if rp.events != nil {
+			rp.events(expired[i])
+		}
+	}
+	return expired
+}
+
+// Run sweeps every quarter of the idle period until ctx is done.
+func (rp *Reaper) Run(ctx context.Context) {
+	t := rp.clock.NewTicker(rp.idle / 4)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C():
+			rp.Sweep(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func activity(s *TransferStats) int64 {
+	return s.ChunksReceived.Load() + s.Duplicates.Load() + s.Conflicts.Load() + s.Errors.Load()
+}
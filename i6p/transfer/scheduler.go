@@ -0,0 +1,183 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrSchedulerClosed = errors.New("transfer: transfer unregistered from scheduler")
+)
+
+// Scheduler shares a fixed number of send slots between the concurrent
+// transfers going to one peer, so a large transfer cannot starve the others.
+// Create one per session (or per Peer) and hand it to every BulkSender that
+// talks to that peer.
+//
+// Slots are granted in weighted fair order: among the transfers that are
+// waiting, the one with the fewest slots in use relative to its weight goes
+// next, with ties broken round-robin.
+type Scheduler struct {
+	mu        sync.Mutex
+	capacity  int
+	inUse     int
+	seq       uint64
+	nextID    uint64
+	transfers map[uint64]*ScheduledTransfer
+}
+
+// NewScheduler creates a scheduler with capacity concurrent slots.
+// capacity <= 0 defaults to the default ParallelStreams.
+func NewScheduler(capacity int) *Scheduler {
+	if capacity <= 0 {
+		capacity = DefaultTransferConfig().ParallelStreams
+	}
+	return &Scheduler{
+		capacity:  capacity,
+		transfers: make(map[uint64]*ScheduledTransfer),
+	}
+}
+
+// ScheduledTransfer is one transfer's handle on a Scheduler.
+type ScheduledTransfer struct {
+	s        *Scheduler
+	id       uint64
+	weight   int
+	inUse    int
+	lastSeq  uint64
+	waiters  []chan struct{}
+	released bool
+}
+
+// Register adds a transfer with the given weight (<= 0 means 1). A transfer
+// with weight 2 receives roughly twice the slots of a weight-1 transfer while
+// both are busy. Call Close on the handle when the transfer ends.
+func (s *Scheduler) Register(weight int) *ScheduledTransfer {
+	if weight <= 0 {
+		weight = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	t := &ScheduledTransfer{s: s, id: s.nextID, weight: weight}
+	s.transfers[t.id] = t
+	return t
+}
+
+// Transfers returns the number of registered transfers.
+func (s *Scheduler) Transfers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.transfers)
+}
+
+// InUse returns the number of slots currently granted.
+func (s *Scheduler) InUse() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inUse
+}
+
+// dispatchLocked hands free slots to waiting transfers.
+func (s *Scheduler) dispatchLocked() {
+	for s.inUse < s.capacity {
+		var best *ScheduledTransfer
+		for _, t := range s.transfers {
+			if len(t.waiters) == 0 {
+				continue
+			}
+			if best == nil {
+				best = t
+				continue
+			}
+			// Compare inUse/weight without division.
+			l, r := t.inUse*best.weight, best.inUse*t.weight
+			if l < r || (l == r && t.lastSeq < best.lastSeq) {
+				best = t
+			}
+		}
+		if best == nil {
+			return
+		}
+		w := best.waiters[0]
+		best.waiters = best.waiters[1:]
+		best.inUse++
+		s.inUse++
+		s.seq++
+		best.lastSeq = s.seq
+		w <- struct{}{}
+	}
+}
+
+// Acquire blocks until the transfer is granted a slot or ctx is done.
+func (t *ScheduledTransfer) Acquire(ctx context.Context) error {
+	s := t.s
+	w := make(chan struct{}, 1)
+
+	s.mu.Lock()
+	if t.released {
+		s.mu.Unlock()
+		return ErrSchedulerClosed
+	}
+	t.waiters = append(t.waiters, w)
+	s.dispatchLocked()
+	s.mu.Unlock()
+
+	select {
+	case _, ok := <-w:
+		if !ok {
+			return ErrSchedulerClosed
+		}
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, q := range t.waiters {
+		if q == w {
+			t.waiters = append(t.waiters[:i], t.waiters[i+1:]...)
+			return ctx.Err()
+		}
+	}
+	// Granted concurrently with cancellation; give the slot back.
+	t.releaseLocked()
+	return ctx.Err()
+}
+
+// Release returns a slot obtained from Acquire.
+func (t *ScheduledTransfer) Release() {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+	t.releaseLocked()
+}
+
+func (t *ScheduledTransfer) releaseLocked() {
+	if t.inUse == 0 {
+		return
+	}
+	t.inUse--
+	t.s.inUse--
+	t.s.dispatchLocked()
+}
+
+// Close unregisters the transfer. Pending and later Acquire calls fail with
+// ErrSchedulerClosed; slots still held are returned as they are released.
+func (t *ScheduledTransfer) Close() {
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.released {
+		return
+	}
+	t.released = true
+	for _, w := range t.waiters {
+		close(w)
+	}
+	t.waiters = nil
+	delete(s.transfers, t.id)
+}
+
+// Weight returns the transfer's scheduling weight.
+func (t *ScheduledTransfer) Weight() int { return t.weight }
@@ -0,0 +1,88 @@
+package transfer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerFairShare(t *testing.T) {
+	s := NewScheduler(1)
+	big := s.Register(1)
+	small := s.Register(1)
+	ctx := context.Background()
+
+	if err := big.Acquire(ctx); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	start := func(name string, tr *ScheduledTransfer) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := tr.Acquire(ctx); err != nil {
+				t.Errorf("Acquire: %v", err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			tr.Release()
+		}()
+	}
+	// The big transfer queues up several chunks before the small one arrives.
+	for i := 0; i < 3; i++ {
+		start("big", big)
+	}
+	waitFor(t, func() bool { s.mu.Lock(); defer s.mu.Unlock(); return len(big.waiters) == 3 })
+	start("small", small)
+	waitFor(t, func() bool { s.mu.Lock(); defer s.mu.Unlock(); return len(small.waiters) == 1 })
+
+	big.Release()
+	wg.Wait()
+
+	if len(order) != 4 || order[0] != "small" {
+		t.Fatalf("small transfer starved: %v", order)
+	}
+	if s.InUse() != 0 {
+		t.Fatalf("slots leaked: %d", s.InUse())
+	}
+}
+
+func TestSchedulerCloseFailsWaiters(t *testing.T) {
+	s := NewScheduler(1)
+	a := s.Register(1)
+	b := s.Register(1)
+	ctx := context.Background()
+	if err := a.Acquire(ctx); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- b.Acquire(ctx) }()
+	waitFor(t, func() bool { s.mu.Lock(); defer s.mu.Unlock(); return len(b.waiters) == 1 })
+	b.Close()
+
+	if err := <-errCh; err != ErrSchedulerClosed {
+		t.Fatalf("expected ErrSchedulerClosed, got %v", err)
+	}
+	if s.Transfers() != 1 {
+		t.Fatalf("expected 1 registered transfer, got %d", s.Transfers())
+	}
+	a.Release()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,118 @@
+package transfer
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// EntropyThreshold is the sampled entropy, in bits per byte, above which a
+// chunk is sent uncompressed without trying: compressed media, archives and
+// ciphertext all sit close to 8.
+const EntropyThreshold = 7.5
+
+const (
+	// minSniffLen is the smallest chunk whose entropy is sampled; shorter
+	// samples underestimate it.
+	minSniffLen = 1024
+	// sniffSamples slices of sniffSampleLen bytes, spread over the chunk,
+	// make up the sample.
+	sniffSamples   = 16
+	sniffSampleLen = 256
+)
+
+// incompressibleExts are file extensions of formats that are already
+// compressed or encrypted.
+var incompressibleExts = map[string]bool{
+	".7z": true, ".avif": true, ".br": true, ".bz2": true, ".gpg": true, ".gz": true,
+	".heic": true, ".jpeg": true, ".jpg": true, ".lz4": true, ".m4a": true, ".m4v": true,
+	".mkv": true, ".mov": true, ".mp3": true, ".mp4": true, ".ogg": true, ".opus": true,
+	".png": true, ".rar": true, ".tgz": true, ".webm": true, ".webp": true, ".xz": true,
+	".zip": true, ".zst": true, ".jar": true, ".apk": true, ".docx": true, ".xlsx": true,
+	".flac": true, ".age": true,
+}
+
+// incompressibleMagic are leading bytes of the same formats.
+var incompressibleMagic = [][]byte{
+	{0x1f, 0x8b},                     // gzip
+	[]byte("PK\x03\x04"),             // zip, jar, docx, apk
+	[]byte("7z\xbc\xaf\x27\x1c"),     // 7z
+	{0xfd, '7', 'z', 'X', 'Z', 0x00}, // xz
+	{0x28, 0xb5, 0x2f, 0xfd},         // zstd
+	[]byte("BZh"),                    // bzip2
+	[]byte("Rar!\x1a\x07"),           // rar
+	{0x04, 0x22, 0x4d, 0x18},         // lz4 frame
+	{0xff, 0xd8, 0xff},               // jpeg
+	[]byte("\x89PNG\r\n\x1a\n"),      // png
+	[]byte("OggS"),                   // ogg
+	[]byte("fLaC"),                   // flac
+	[]byte("ID3"),                    // mp3
+	{0x1a, 0x45, 0xdf, 0xa3},         // matroska, webm
+	[]byte("age-encryption.org/"),    // age
+}
+
+// IncompressibleName reports whether name has the extension of an already
+// compressed or encrypted format.
+func IncompressibleName(name string) bool {
+	return incompressibleExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// IncompressibleHead reports whether head, the first bytes of an object,
+// starts like an already compressed format.
+func IncompressibleHead(head []byte) bool {
+	for _, m := range incompressibleMagic {
+		if bytes.HasPrefix(head, m) {
+			return true
+		}
+	}
+	// ISO base media (mp4, mov, heic, avif): a box size, then "ftyp".
+	if len(head) >= 8 && string(head[4:8]) == "ftyp" {
+		return true
+	}
+	// RIFF containers holding WebP.
+	return len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP"
+}
+
+// HighEntropy reports whether a sample of data exceeds EntropyThreshold. It
+// reads at most 4 KiB whatever the size of data, so it costs far less than a
+// compression attempt. Data shorter than 1 KiB is never high entropy.
+func HighEntropy(data []byte) bool {
+	if len(data) < minSniffLen {
+		return false
+	}
+	var counts [256]int
+	n := 0
+	if len(data) <= sniffSamples*sniffSampleLen {
+		for _, b := range data {
+			counts[b]++
+		}
+		n = len(data)
+	} else {
+		step := (len(data) - sniffSampleLen) / (sniffSamples - 1)
+		for i := 0; i < sniffSamples; i++ {
+			for _, b := range data[i*step : i*step+sniffSampleLen] {
+				counts[b]++
+			}
+		}
+		n = sniffSamples * sniffSampleLen
+	}
+	var bits float64
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(n)
+			bits -= p * math.Log2(p)
+		}
+	}
+	return bits > EntropyThreshold
+}
+
+// ForFile returns c with compression disabled when the file name or its
+// first bytes show an already compressed format. Other files keep c
+// unchanged; their chunks are still checked with HighEntropy one by one.
+func (c TransferConfig) ForFile(name string, head []byte) TransferConfig {
+	if IncompressibleName(name) || IncompressibleHead(head) {
+		c.Codec = NoCompression
+	}
+	return c
+}
@@ -0,0 +1,105 @@
+package transfer
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"os"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+var (
+	ErrSpoolKeySize     = errors.New("transfer: spool key must be 32 bytes")
+	ErrSpoolKeyMismatch = errors.New("transfer: spool key does not match checkpoint")
+)
+
+// SpoolKeySize is the size of a spool encryption key.
+const SpoolKeySize = chacha20.KeySize
+
+// GenerateSpoolKey returns a random per-transfer key for
+// NewEncryptedFileReceiver. It must be kept apart from the checkpoint, which
+// only records a check value.
+func GenerateSpoolKey() ([]byte, error) {
+	key := make([]byte, SpoolKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// spoolCipher encrypts the chunks of a spool file with ChaCha20. The nonce of
+// a chunk is its index, so chunks can be written and read in any order; a
+// chunk is only ever rewritten with the same verified content, so reusing its
+// keystream reveals nothing. Integrity comes from the manifest hashes.
+type spoolCipher struct {
+	key []byte
+}
+
+func newSpoolCipher(key []byte) (*spoolCipher, error) {
+	if len(key) != SpoolKeySize {
+		return nil, ErrSpoolKeySize
+	}
+	return &spoolCipher{key: append([]byte(nil), key...)}, nil
+}
+
+// xor encrypts or decrypts src, the content of chunk index, into dst.
+func (c *spoolCipher) xor(index int, dst, src []byte) {
+	var nonce [chacha20.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], uint64(index))
+	s, _ := chacha20.NewUnauthenticatedCipher(c.key, nonce[:]) // key and nonce sizes are fixed
+	s.XORKeyStream(dst, src)
+}
+
+// check returns the value a checkpoint stores to recognise the key.
+func (c *spoolCipher) check() []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte("i6p spool key check"))
+	return mac.Sum(nil)[:16]
+}
+
+// readChunk reads chunk i of the spool into buf and returns its plaintext.
+func (fr *FileReceiver) readChunk(i int, buf []byte) ([]byte, error) {
+	chunk := buf[:fr.manifest.ChunkLen(i)]
+	if _, err := fr.file.ReadAt(chunk, int64(i)*int64(fr.manifest.ChunkSize)); err != nil {
+		return nil, err
+	}
+	if fr.cipher != nil {
+		fr.cipher.xor(i, chunk, chunk)
+	}
+	return chunk, nil
+}
+
+// decryptTo writes the plaintext of a complete encrypted spool to dst and
+// removes the spool.
+func (fr *FileReceiver) decryptTo(dst string) error {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, fr.manifest.ChunkSize)
+	for i := 0; i < fr.manifest.NumChunks(); i++ {
+		chunk, err := fr.readChunk(i, buf)
+		if err == nil {
+			_, err = out.Write(chunk)
+		}
+		if err != nil {
+			_ = out.Close()
+			_ = os.Remove(dst)
+			return err
+		}
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := fr.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(fr.path)
+}
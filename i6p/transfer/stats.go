@@ -0,0 +1,150 @@
+package transfer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateWindow is the period TransferStats averages throughput over.
+const RateWindow = 10 * time.Second
+
+// rateBuckets splits RateWindow into one-second buckets.
+const rateBuckets = int(RateWindow / time.Second)
+
+// TransferStats tracks transfer progress and metrics. Counters may be read
+// individually while the transfer runs; Snapshot reads them all at once
+// together with the derived rates.
+type TransferStats struct {
+	TotalBytes      atomic.Int64 // uncompressed bytes sent or received
+	CompressedBytes atomic.Int64 // the same bytes as carried on the wire
+	ChunksSent      atomic.Int64
+	ChunksReceived  atomic.Int64
+	Duplicates      atomic.Int64 // identical chunks received more than once
+	Conflicts       atomic.Int64 // same index received with a different payload
+	Errors          atomic.Int64
+
+	// Time spent per phase, in nanoseconds. Send covers queueing and writing
+	// the chunks, including waits on flow control.
+	HashNanos     atomic.Int64
+	CompressNanos atomic.Int64
+	SendNanos     atomic.Int64
+
+	// Decisions of the compression feedback loop (see CompressionFeedback).
+	CompressionOff      atomic.Bool  // compression is currently turned off
+	CompressionSwitches atomic.Int64 // times it was turned off or back on
+	CompressionSkipped  atomic.Int64 // chunks sent uncompressed while off
+
+	mu      sync.Mutex
+	buckets [rateBuckets]int64 // wire bytes per second
+	seconds [rateBuckets]int64 // unix second of each bucket
+}
+
+// StatsSnapshot is a point-in-time copy of TransferStats.
+type StatsSnapshot struct {
+	TotalBytes      int64
+	CompressedBytes int64
+	ChunksSent      int64
+	ChunksReceived  int64
+	Duplicates      int64
+	Conflicts       int64
+	Errors          int64
+
+	HashTime     time.Duration
+	CompressTime time.Duration
+	SendTime     time.Duration
+
+	CompressionOff      bool
+	CompressionSwitches int64
+	CompressionSkipped  int64
+
+	// Throughput is the wire bytes per second over the last RateWindow.
+	Throughput       float64
+	DuplicateRate    float64
+	CompressionRatio float64
+}
+
+// count records a chunk of raw uncompressed bytes carried as wire bytes.
+func (s *TransferStats) count(raw, wire int, now time.Time) {
+	s.TotalBytes.Add(int64(raw))
+	s.CompressedBytes.Add(int64(wire))
+	sec := now.Unix()
+	i := int(sec % int64(rateBuckets))
+	s.mu.Lock()
+	if s.seconds[i] != sec {
+		s.seconds[i], s.buckets[i] = sec, 0
+	}
+	s.buckets[i] += int64(wire)
+	s.mu.Unlock()
+}
+
+// phase adds the time since start to the phase counter p.
+func phase(p *atomic.Int64, start time.Time) {
+	p.Add(int64(time.Since(start)))
+}
+
+// throughput returns the wire bytes per second over the RateWindow ending
+// at now. The current second counts in full, so a fresh burst is not
+// understated.
+func (s *TransferStats) throughput(now time.Time) float64 {
+	sec := now.Unix()
+	var total int64
+	s.mu.Lock()
+	for i, at := range s.seconds {
+		if at > sec-int64(rateBuckets) && at <= sec {
+			total += s.buckets[i]
+		}
+	}
+	s.mu.Unlock()
+	return float64(total) / RateWindow.Seconds()
+}
+
+// Snapshot returns the current counters and rates.
+func (s *TransferStats) Snapshot() StatsSnapshot {
+	snap := StatsSnapshot{
+		TotalBytes:      s.TotalBytes.Load(),
+		CompressedBytes: s.CompressedBytes.Load(),
+		ChunksSent:      s.ChunksSent.Load(),
+		ChunksReceived:  s.ChunksReceived.Load(),
+		Duplicates:      s.Duplicates.Load(),
+		Conflicts:       s.Conflicts.Load(),
+		Errors:          s.Errors.Load(),
+		HashTime:        time.Duration(s.HashNanos.Load()),
+		CompressTime:    time.Duration(s.CompressNanos.Load()),
+		SendTime:        time.Duration(s.SendNanos.Load()),
+
+		CompressionOff:      s.CompressionOff.Load(),
+		CompressionSwitches: s.CompressionSwitches.Load(),
+		CompressionSkipped:  s.CompressionSkipped.Load(),
+
+		Throughput: s.throughput(time.Now()),
+	}
+	snap.DuplicateRate = duplicateRate(snap.ChunksReceived, snap.Duplicates)
+	snap.CompressionRatio = compressionRatio(snap.TotalBytes, snap.CompressedBytes)
+	return snap
+}
+
+// DuplicateRate returns the fraction of received chunks that were duplicates
+// (0.0 to 1.0). A high rate usually means retransmissions are too eager.
+func (s *TransferStats) DuplicateRate() float64 {
+	return duplicateRate(s.ChunksReceived.Load(), s.Duplicates.Load())
+}
+
+// CompressionRatio returns the compression ratio (original / compressed).
+func (s *TransferStats) CompressionRatio() float64 {
+	return compressionRatio(s.TotalBytes.Load(), s.CompressedBytes.Load())
+}
+
+func duplicateRate(received, duplicates int64) float64 {
+	if received+duplicates == 0 {
+		return 0
+	}
+	return float64(duplicates) / float64(received+duplicates)
+}
+
+func compressionRatio(total, compressed int64) float64 {
+	if compressed == 0 {
+		return 1.0
+	}
+	return float64(total) / float64(compressed)
+}
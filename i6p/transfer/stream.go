@@ -0,0 +1,284 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/TheusHen/I6P/i6p/crypto"
+)
+
+// DefaultWindowSize is the amount of data covered by one rolling manifest.
+const DefaultWindowSize = 64 << 20
+
+// maxWindowRecord bounds the encoded manifest of one window, and
+// maxWindowChunks keeps a window of many small reads under that bound.
+const (
+	maxWindowRecord = 16 << 20
+	maxWindowChunks = 64 * 1024
+)
+
+var (
+	ErrStreamTruncated = errors.New("transfer: stream ended before its trailer")
+	ErrStreamRecord    = errors.New("transfer: malformed stream record")
+)
+
+// Record tags of the stream format.
+const (
+	streamBatch  byte = 1 // a batch with the next chunk of the window
+	streamWindow byte = 2 // the manifest of the window just sent
+	streamEnd    byte = 3 // trailer
+)
+
+// StreamConfig configures SendStream. The receiver needs no configuration.
+type StreamConfig struct {
+	ChunkSize   int                 // maximum bytes per chunk (default: DefaultChunkSize)
+	WindowSize  int                 // bytes per rolling manifest (default: DefaultWindowSize)
+	Compression CompressionLevel    // compression level used by the sender
+	Codec       Codec               // compression codec (nil: LZ4)
+	Feedback    CompressionFeedback // turns compression off while chunks do not compress
+}
+
+func (c StreamConfig) withDefaults() StreamConfig {
+	if c.ChunkSize <= 0 {
+		c.ChunkSize = DefaultChunkSize
+	}
+	if c.WindowSize <= 0 {
+		c.WindowSize = DefaultWindowSize
+	}
+	return c
+}
+
+// StreamResult summarizes a streamed transfer.
+type StreamResult struct {
+	Bytes   int64
+	Windows int
+	// Root chains the Merkle roots of all windows:
+	// Root = SHA-256(... SHA-256(SHA-256(root0) || root1) ... || rootN).
+	// Both ends compute it; signing it commits to the whole stream.
+	Root []byte
+}
+
+// windowRecord is the rolling manifest of one window.
+type windowRecord struct {
+	Index       int      `json:"index"`
+	Offset      int64    `json:"offset"`
+	Size        int64    `json:"size"`
+	Root        []byte   `json:"root"`
+	Chain       []byte   `json:"chain"`
+	ChunkHashes [][]byte `json:"chunk_hashes"`
+}
+
+type endRecord struct {
+	Size    int64  `json:"size"`
+	Windows int    `json:"windows"`
+	Chain   []byte `json:"chain"`
+}
+
+func chainRoot(prev, root []byte) []byte {
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(root)
+	return h.Sum(nil)
+}
+
+// SendStream copies r to w until r returns EOF, for inputs of unknown length
+// such as pipes and live logs. Data is sent as soon as it is read, a chunk per
+// read; every WindowSize bytes (and at the end) the sender emits the window's
+// manifest and its chained Merkle root, which ReceiveStream verifies. Over an
+// I6P stream this makes an encrypted, verified netcat.
+//
+// A blocked read of r is not interrupted when ctx is done.
+func SendStream(ctx context.Context, w io.Writer, r io.Reader, cfg StreamConfig) (StreamResult, error) {
+	cfg = cfg.withDefaults()
+	var (
+		res    StreamResult
+		hashes [][]byte
+		winLen int64
+	)
+	flush := func() error {
+		if len(hashes) == 0 {
+			return nil
+		}
+		tree, err := BuildMerkleTreeContext(ctx, hashes)
+		if err != nil {
+			return err
+		}
+		res.Root = chainRoot(res.Root, tree.Root())
+		rec := windowRecord{
+			Index:       res.Windows,
+			Offset:      res.Bytes - winLen,
+			Size:        winLen,
+			Root:        tree.Root(),
+			Chain:       res.Root,
+			ChunkHashes: hashes,
+		}
+		if err := writeStreamRecord(w, streamWindow, rec); err != nil {
+			return err
+		}
+		res.Windows++
+		hashes, winLen = nil, 0
+		return nil
+	}
+
+	buf := make([]byte, cfg.ChunkSize)
+	tag := []byte{streamBatch}
+	comp := newCompressor(cfg.Codec, cfg.Compression, cfg.Feedback, nil)
+	for {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		n, rerr := r.Read(buf[:min(len(buf), cfg.WindowSize-int(winLen))])
+		if n > 0 {
+			data := buf[:n]
+			c := Chunk{Index: len(hashes), Data: data, Hash: HashChunk(data)}
+			batch := NewBatch()
+			batch.Add(comp.compress(c))
+			if _, err := w.Write(tag); err != nil {
+				return res, err
+			}
+			if err := WriteBatch(w, batch); err != nil {
+				return res, err
+			}
+			hashes = append(hashes, c.Hash)
+			winLen += int64(n)
+			res.Bytes += int64(n)
+			if winLen >= int64(cfg.WindowSize) || len(hashes) >= maxWindowChunks {
+				if err := flush(); err != nil {
+					return res, err
+				}
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return res, rerr
+		}
+	}
+	if err := flush(); err != nil {
+		return res, err
+	}
+	err := writeStreamRecord(w, streamEnd, endRecord{Size: res.Bytes, Windows: res.Windows, Chain: res.Root})
+	return res, err
+}
+
+// ReceiveStream reads a stream produced by SendStream from r and writes the
+// data to w. Every chunk is checked against its hash before it is written,
+// and every window against its manifest and chained root. Data of a window
+// is written before the window's manifest arrives, so on an integrity error
+// w may already hold part of the failing window.
+func ReceiveStream(ctx context.Context, w io.Writer, r io.Reader) (StreamResult, error) {
+	var (
+		res    StreamResult
+		hashes [][]byte
+		winLen int64
+		tag    [1]byte
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if _, err := io.ReadFull(r, tag[:]); err != nil {
+			if err == io.EOF {
+				return res, ErrStreamTruncated
+			}
+			return res, err
+		}
+		switch tag[0] {
+		case streamBatch:
+			batch, err := ReadBatchContext(ctx, r)
+			if err != nil {
+				return res, err
+			}
+			for _, cc := range batch.Chunks {
+				if cc.Index != len(hashes) {
+					return res, ErrStreamRecord
+				}
+				c, err := DecompressChunk(cc)
+				if err != nil {
+					return res, err
+				}
+				if _, err := w.Write(c.Data); err != nil {
+					return res, err
+				}
+				hashes = append(hashes, c.Hash)
+				winLen += int64(len(c.Data))
+				res.Bytes += int64(len(c.Data))
+			}
+
+		case streamWindow:
+			var rec windowRecord
+			if err := readStreamRecord(r, &rec); err != nil {
+				return res, err
+			}
+			if rec.Index != res.Windows || rec.Size != winLen || rec.Offset != res.Bytes-winLen ||
+				len(rec.ChunkHashes) != len(hashes) || len(hashes) == 0 {
+				return res, ErrIntegrityCheckFailed
+			}
+			for i, h := range hashes {
+				if !crypto.Equal(h, rec.ChunkHashes[i]) {
+					return res, ErrIntegrityCheckFailed
+				}
+			}
+			tree, err := BuildMerkleTreeContext(ctx, hashes)
+			if err != nil {
+				return res, err
+			}
+			chain := chainRoot(res.Root, tree.Root())
+			if !crypto.Equal(tree.Root(), rec.Root) || !crypto.Equal(chain, rec.Chain) {
+				return res, ErrIntegrityCheckFailed
+			}
+			res.Root = chain
+			res.Windows++
+			hashes, winLen = nil, 0
+
+		case streamEnd:
+			var end endRecord
+			if err := readStreamRecord(r, &end); err != nil {
+				return res, err
+			}
+			if len(hashes) != 0 || end.Size != res.Bytes || end.Windows != res.Windows || !crypto.Equal(end.Chain, res.Root) {
+				return res, ErrIntegrityCheckFailed
+			}
+			return res, nil
+
+		default:
+			return res, ErrStreamRecord
+		}
+	}
+}
+
+func writeStreamRecord(w io.Writer, tag byte, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	hdr := make([]byte, 5, 5+len(b))
+	hdr[0] = tag
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(b)))
+	_, err = w.Write(append(hdr, b...))
+	return err
+}
+
+func readStreamRecord(r io.Reader, v any) error {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size > maxWindowRecord {
+		return ErrStreamRecord
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return ErrStreamRecord
+	}
+	return nil
+}
@@ -2,7 +2,22 @@ package transfer
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/clock"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/protocol"
 )
 
 func TestMerkleTreeBuildAndVerify(t *testing.T) {
@@ -44,6 +59,102 @@ func TestMerkleTreeBuildAndVerify(t *testing.T) {
 	if err := VerifyProof(proof, root); err != ErrMerkleProofFail {
 		t.Fatalf("expected proof failure for tampered hash")
 	}
+
+	// Malformed proofs and trees fail instead of panicking.
+	proof, _ = tree.GenerateProof(1)
+	proof.IsLeft = proof.IsLeft[:1]
+	if err := VerifyProof(proof, root); err != ErrMerkleProofFail {
+		t.Fatalf("proof with missing directions: %v", err)
+	}
+	var empty *MerkleTree
+	if _, err := empty.GenerateProof(0); err != ErrMerkleEmpty || empty.Root() != nil {
+		t.Fatalf("GenerateProof on a nil tree: %v", err)
+	}
+}
+
+// fullMerkleRoot computes the root of hashes padded to width leaves
+// directly, as the tree is specified.
+func fullMerkleRoot(hashes [][]byte, width int) []byte {
+	if width == 1 {
+		if len(hashes) == 0 {
+			return HashChunk(nil)
+		}
+		return hashes[0]
+	}
+	half := width / 2
+	left, right := hashes, [][]byte(nil)
+	if len(hashes) > half {
+		left, right = hashes[:half], hashes[half:]
+	}
+	return HashChunk(append(append([]byte(nil), fullMerkleRoot(left, half)...), fullMerkleRoot(right, half)...))
+}
+
+func TestMerkleTreeSparseLevels(t *testing.T) {
+	// Sizes around powers of two and past the unstored levels.
+	for _, n := range []int{1, 2, 3, 7, 8, 9, 15, 16, 17, 33, 100, 1000} {
+		hashes := make([][]byte, n)
+		for i := range hashes {
+			hashes[i] = HashChunk([]byte(fmt.Sprint(i)))
+		}
+		tree, err := BuildMerkleTree(hashes)
+		if err != nil {
+			t.Fatalf("BuildMerkleTree(%d): %v", n, err)
+		}
+		width := 1
+		for width < n {
+			width *= 2
+		}
+		if !bytes.Equal(tree.Root(), fullMerkleRoot(hashes, width)) {
+			t.Fatalf("%d chunks: root differs from the padded tree", n)
+		}
+		for i := 0; i < width; i++ {
+			proof, err := tree.GenerateProof(i)
+			if err != nil {
+				t.Fatalf("GenerateProof(%d/%d): %v", i, n, err)
+			}
+			if err := VerifyProofAt(proof, tree.Root(), width); err != nil {
+				t.Fatalf("VerifyProofAt(%d/%d): %v", i, n, err)
+			}
+		}
+		if _, err := tree.GenerateProof(width); err != ErrMerkleIndexRange {
+			t.Fatalf("GenerateProof past the padding: %v", err)
+		}
+	}
+}
+
+func TestVerifyProofAtBindsIndex(t *testing.T) {
+	var hashes [][]byte
+	for i := 0; i < 5; i++ {
+		hashes = append(hashes, HashChunk([]byte{byte(i)}))
+	}
+	tree, err := BuildMerkleTree(hashes)
+	if err != nil {
+		t.Fatalf("BuildMerkleTree: %v", err)
+	}
+	for i := range hashes {
+		proof, _ := tree.GenerateProof(i)
+		if err := VerifyProofAt(proof, tree.Root(), len(hashes)); err != nil {
+			t.Fatalf("VerifyProofAt(%d): %v", i, err)
+		}
+	}
+	// A valid proof for chunk 1 presented as chunk 2.
+	proof, _ := tree.GenerateProof(1)
+	if err := VerifyProof(proof, tree.Root()); err != nil {
+		t.Fatalf("VerifyProof: %v", err)
+	}
+	proof.ChunkIndex = 2
+	if err := VerifyProofAt(proof, tree.Root(), len(hashes)); err != ErrMerkleProofFail {
+		t.Fatalf("relabelled proof: %v", err)
+	}
+	proof.ChunkIndex = 5
+	if err := VerifyProofAt(proof, tree.Root(), len(hashes)); err != ErrMerkleIndexRange {
+		t.Fatalf("out of range: %v", err)
+	}
+	// A claimed chunk count near the int limit must fail, not loop.
+	proof.ChunkIndex = 1
+	if err := VerifyProofAt(proof, tree.Root(), math.MaxInt); err != ErrMerkleProofFail {
+		t.Fatalf("huge chunk count: %v", err)
+	}
 }
 
 func TestChunkerSplitReassemble(t *testing.T) {
@@ -65,6 +176,133 @@ func TestChunkerSplitReassemble(t *testing.T) {
 	}
 }
 
+func TestComputeHonoursCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	data := make([]byte, 1<<20)
+	if _, err := NewChunker(64*1024).SplitContext(ctx, data); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SplitContext: %v", err)
+	}
+	hashes := make([][]byte, 4*cancelCheckInterval)
+	for i := range hashes {
+		hashes[i] = HashChunk([]byte{byte(i), byte(i >> 8)})
+	}
+	if _, err := BuildMerkleTreeContext(ctx, hashes); !errors.Is(err, context.Canceled) {
+		t.Fatalf("BuildMerkleTreeContext: %v", err)
+	}
+	tree, err := BuildMerkleTreeContext(context.Background(), hashes)
+	if err != nil {
+		t.Fatalf("BuildMerkleTreeContext: %v", err)
+	}
+	plain, _ := BuildMerkleTree(hashes)
+	if !bytes.Equal(tree.Root(), plain.Root()) {
+		t.Fatal("context variant built a different root")
+	}
+}
+
+func TestHashChunksLanes(t *testing.T) {
+	// Enough data for parallel lanes, in chunks of uneven sizes.
+	data := make([][]byte, 300)
+	for i := range data {
+		data[i] = make([]byte, 4096+i)
+		data[i][0] = byte(i)
+	}
+	sums := HashChunks(data)
+	for i := range data {
+		if !bytes.Equal(sums[i], HashChunk(data[i])) {
+			t.Fatalf("hash %d differs from HashChunk", i)
+		}
+	}
+	if small := HashChunks(data[:2]); !bytes.Equal(small[1], sums[1]) {
+		t.Fatal("serial path differs")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := HashChunksContext(ctx, data); !errors.Is(err, context.Canceled) {
+		t.Fatalf("HashChunksContext: %v", err)
+	}
+}
+
+func TestCDCChunkerLocalEdits(t *testing.T) {
+	data := make([]byte, 2<<20)
+	x := uint32(1)
+	for i := range data {
+		x = x*1664525 + 1013904223
+		data[i] = byte(x >> 24)
+	}
+	c := NewCDCChunker(4*1024, 16*1024, 64*1024)
+	before := c.Split(data)
+	if !bytes.Equal(Reassemble(before), data) {
+		t.Fatal("reassembled data mismatch")
+	}
+	for i, ch := range before {
+		if len(ch.Data) > 64*1024 || (len(ch.Data) < 4*1024 && i != len(before)-1) {
+			t.Fatalf("chunk %d has size %d", i, len(ch.Data))
+		}
+	}
+
+	// Insert bytes in the middle: only the chunks around the edit change.
+	edited := append(append(append([]byte{}, data[:1<<20]...), "inserted"...), data[1<<20:]...)
+	known := make(map[string]bool)
+	for _, ch := range before {
+		known[string(ch.Hash)] = true
+	}
+	changed := 0
+	for _, ch := range c.Split(edited) {
+		if !known[string(ch.Hash)] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 2 {
+		t.Fatalf("%d of %d chunks changed after a local insert", changed, len(before))
+	}
+}
+
+func TestSendReceiveStream(t *testing.T) {
+	ctx := context.Background()
+	data := make([]byte, 5<<20+17)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	// A reader that returns short reads, like a pipe.
+	src := io.MultiReader(bytes.NewReader(data[:100]), bytes.NewReader(data[100:]))
+	var wire bytes.Buffer
+	cfg := StreamConfig{ChunkSize: 64 * 1024, WindowSize: 1 << 20, Compression: CompressionFast}
+	sent, err := SendStream(ctx, &wire, src, cfg)
+	if err != nil {
+		t.Fatalf("SendStream: %v", err)
+	}
+	if sent.Bytes != int64(len(data)) || sent.Windows != 6 {
+		t.Fatalf("unexpected send result %+v", sent)
+	}
+	encoded := wire.Bytes()
+
+	var out bytes.Buffer
+	got, err := ReceiveStream(ctx, &out, bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("ReceiveStream: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) || got.Windows != sent.Windows || !bytes.Equal(got.Root, sent.Root) {
+		t.Fatalf("received %d bytes in %d windows, root match %v", out.Len(), got.Windows, bytes.Equal(got.Root, sent.Root))
+	}
+
+	if _, err := ReceiveStream(ctx, io.Discard, bytes.NewReader(encoded[:len(encoded)/2])); err == nil {
+		t.Fatal("expected error for a truncated stream")
+	}
+	// Dropping the trailer must be detected even at a record boundary.
+	var short bytes.Buffer
+	if _, err := SendStream(ctx, &short, bytes.NewReader(data[:1000]), cfg); err != nil {
+		t.Fatalf("SendStream: %v", err)
+	}
+	b := short.Bytes()
+	end := bytes.LastIndexByte(b[:len(b)-1], '{') - 5
+	if _, err := ReceiveStream(ctx, io.Discard, bytes.NewReader(b[:end])); err != ErrStreamTruncated {
+		t.Fatalf("expected ErrStreamTruncated, got %v", err)
+	}
+}
+
 func TestCompressDecompress(t *testing.T) {
 	data := bytes.Repeat([]byte("hello world "), 1000)
 
@@ -122,6 +360,370 @@ func TestBatchEncodeDecode(t *testing.T) {
 	}
 }
 
+func TestBatchMetadata(t *testing.T) {
+	plain := NewBatch()
+	plain.Add(CompressChunk(Chunk{Index: 0, Data: []byte("a"), Hash: HashChunk([]byte("a"))}, CompressionFast))
+	enc, _ := plain.Encode()
+	if binary.BigEndian.Uint32(enc) != BatchMagic || len(enc) != plain.Size() {
+		t.Fatal("batch without metadata changed format")
+	}
+
+	b := NewBatch()
+	b.Meta.SetUint(MetaFileID, 7)
+	b.Meta.Set(MetaPrivate+1, []byte("unknown to the receiver"))
+	for i := 0; i < 3; i++ {
+		data := []byte(fmt.Sprint("chunk", i))
+		cc := CompressChunk(Chunk{Index: i, Data: data, Hash: HashChunk(data)}, CompressionFast)
+		if i == 1 {
+			cc.Meta.SetUint(MetaPriority, 300)
+		}
+		b.Add(cc)
+	}
+	b.Meta.SetUint(MetaFileID, 9) // replaces 7
+	enc, err := b.Encode()
+	if err != nil || len(enc) != b.Size() {
+		t.Fatalf("Encode: %d bytes of %d, %v", len(enc), b.Size(), err)
+	}
+	got, err := DecodeBatch(enc)
+	if err != nil {
+		t.Fatalf("DecodeBatch: %v", err)
+	}
+	if id, ok := got.Meta.Uint(MetaFileID); !ok || id != 9 || len(got.Meta) != 2 {
+		t.Fatalf("batch metadata %v", got.Meta)
+	}
+	if v, ok := got.Meta.Get(MetaPrivate + 1); !ok || string(v) != "unknown to the receiver" {
+		t.Fatal("unknown metadata was not kept")
+	}
+	if p, ok := got.Chunks[1].Meta.Uint(MetaPriority); !ok || p != 300 || got.Chunks[0].Meta != nil {
+		t.Fatalf("chunk metadata %v, %v", got.Chunks[0].Meta, got.Chunks[1].Meta)
+	}
+	for i, cc := range got.Chunks {
+		if _, err := DecompressChunk(cc); err != nil {
+			t.Fatalf("DecompressChunk %d: %v", i, err)
+		}
+	}
+
+	for n := 5; n < len(enc); n += 7 {
+		if _, err := DecodeBatch(enc[:n]); err == nil {
+			t.Fatalf("truncated to %d bytes: decoded", n)
+		}
+	}
+	b.Meta.Set(MetaPrivate, make([]byte, 1<<16))
+	if _, err := b.Encode(); err != ErrMetaTooLarge {
+		t.Fatalf("oversized metadata: %v", err)
+	}
+}
+
+func TestFileReceiverCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 10*1000+37)
+	for i := range data {
+		data[i] = byte(i*7 + i/1024)
+	}
+	chunker := NewChunker(1000)
+	chunks := chunker.Split(data)
+	manifest, err := NewManifest(chunks, chunker.ChunkSize())
+	if err != nil {
+		t.Fatalf("NewManifest: %v", err)
+	}
+
+	partPath := filepath.Join(dir, "object.part")
+	fr, err := NewFileReceiver(manifest, partPath)
+	if err != nil {
+		t.Fatalf("NewFileReceiver: %v", err)
+	}
+	for _, c := range chunks[:6] {
+		if err := fr.ReceiveChunk(CompressChunk(c, CompressionFast)); err != nil {
+			t.Fatalf("ReceiveChunk: %v", err)
+		}
+	}
+	cp, err := fr.Checkpoint()
+	if err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	cpPath := filepath.Join(dir, "object.checkpoint")
+	if err := SaveCheckpoint(cpPath, cp); err != nil {
+		t.Fatalf("SaveCheckpoint: %v", err)
+	}
+	_ = fr.Close()
+
+	// Corrupt chunk 2 on disk while "offline".
+	f, _ := os.OpenFile(partPath, os.O_RDWR, 0)
+	_, _ = f.WriteAt([]byte{0xff, 0xff}, 2*1000)
+	_ = f.Close()
+
+	loaded, err := LoadCheckpoint(cpPath)
+	if err != nil {
+		t.Fatalf("LoadCheckpoint: %v", err)
+	}
+	resumed, err := ResumeFileReceiver(loaded)
+	if err != nil {
+		t.Fatalf("ResumeFileReceiver: %v", err)
+	}
+	missing := resumed.Missing()
+	if len(missing) != len(chunks)-5 || missing[0] != 2 {
+		t.Fatalf("unexpected missing chunks after resume: %v", missing)
+	}
+	for _, i := range missing {
+		if err := resumed.ReceiveChunk(CompressChunk(chunks[i], CompressionFast)); err != nil {
+			t.Fatalf("ReceiveChunk %d: %v", i, err)
+		}
+	}
+
+	bad := CompressChunk(Chunk{Index: 0, Data: []byte("nope"), Hash: HashChunk([]byte("nope"))}, CompressionFast)
+	if err := resumed.ReceiveChunk(bad); err != ErrChunkHashInvalid {
+		t.Fatalf("expected ErrChunkHashInvalid, got %v", err)
+	}
+
+	out := filepath.Join(dir, "object")
+	if err := resumed.Finalize(out); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("resumed download mismatch")
+	}
+}
+
+func TestEncryptedFileReceiver(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("sensitive payload "), 300)
+	chunker := NewChunker(1000)
+	chunks := chunker.Split(data)
+	manifest, err := NewManifest(chunks, chunker.ChunkSize())
+	if err != nil {
+		t.Fatalf("NewManifest: %v", err)
+	}
+	key, err := GenerateSpoolKey()
+	if err != nil {
+		t.Fatalf("GenerateSpoolKey: %v", err)
+	}
+
+	partPath := filepath.Join(dir, "object.part")
+	fr, err := NewEncryptedFileReceiver(manifest, partPath, key)
+	if err != nil {
+		t.Fatalf("NewEncryptedFileReceiver: %v", err)
+	}
+	for _, c := range chunks[:3] {
+		if err := fr.ReceiveChunk(CompressChunk(c, CompressionFast)); err != nil {
+			t.Fatalf("ReceiveChunk: %v", err)
+		}
+	}
+	cp, err := fr.Checkpoint()
+	if err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	_ = fr.Close()
+
+	spool, _ := os.ReadFile(partPath)
+	if bytes.Contains(spool, []byte("sensitive")) {
+		t.Fatal("plaintext found in spool file")
+	}
+
+	if _, err := ResumeFileReceiver(cp); err != ErrSpoolKeyMismatch {
+		t.Fatalf("resume without key: %v", err)
+	}
+	other, _ := GenerateSpoolKey()
+	if _, err := ResumeEncryptedFileReceiver(cp, other); err != ErrSpoolKeyMismatch {
+		t.Fatalf("resume with wrong key: %v", err)
+	}
+	resumed, err := ResumeEncryptedFileReceiver(cp, key)
+	if err != nil {
+		t.Fatalf("ResumeEncryptedFileReceiver: %v", err)
+	}
+	missing := resumed.Missing()
+	if len(missing) != len(chunks)-3 {
+		t.Fatalf("missing after resume: %v", missing)
+	}
+	for _, i := range missing {
+		if err := resumed.ReceiveChunk(CompressChunk(chunks[i], CompressionFast)); err != nil {
+			t.Fatalf("ReceiveChunk %d: %v", i, err)
+		}
+	}
+
+	out := filepath.Join(dir, "object")
+	if err := resumed.Finalize(out); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if !bytes.Equal(got, data) {
+		t.Fatal("decrypted download mismatch")
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Fatalf("spool file left behind: %v", err)
+	}
+}
+
+func TestQuotaPreflight(t *testing.T) {
+	m, err := BuildManifest(make([]byte, 3000), 1000)
+	if err != nil {
+		t.Fatalf("BuildManifest: %v", err)
+	}
+	alice, bob := identity.PeerID{1}, identity.PeerID{2}
+	q := NewQuota(5000, "")
+
+	release, err := q.Preflight(alice, m)
+	if err != nil {
+		t.Fatalf("first preflight: %v", err)
+	}
+	_, err = q.Preflight(alice, m)
+	var pe *PreflightError
+	if !errors.As(err, &pe) || !errors.Is(err, ErrQuotaExceeded) || pe.Code != RejectQuotaExceeded || pe.Available != 2000 {
+		t.Fatalf("over quota: %v", err)
+	}
+	if _, err := q.Preflight(bob, m); err != nil {
+		t.Fatalf("other peer: %v", err)
+	}
+	release()
+	release()
+	if q.Used(alice) != 0 {
+		t.Fatalf("used after release: %d", q.Used(alice))
+	}
+	if _, err := q.Preflight(alice, m); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+
+	disk := NewQuota(0, t.TempDir())
+	if _, ok := diskFree(disk.Dir); !ok {
+		t.Skip("free space not available on this platform")
+	}
+	disk.MinFree = 1 << 62
+	if _, err := disk.Preflight(alice, m); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("disk full: %v", err)
+	}
+}
+
+func TestReaperExpiresIdleTransfers(t *testing.T) {
+	dir := t.TempDir()
+	m, err := BuildManifest(make([]byte, 3000), 1000)
+	if err != nil {
+		t.Fatalf("BuildManifest: %v", err)
+	}
+	chunks := NewChunker(1000).Split(make([]byte, 3000))
+
+	partPath := filepath.Join(dir, "object.part")
+	cpPath := filepath.Join(dir, "object.checkpoint")
+	stale, err := NewFileReceiver(m, partPath)
+	if err != nil {
+		t.Fatalf("NewFileReceiver: %v", err)
+	}
+	cp, _ := stale.Checkpoint()
+	if err := SaveCheckpoint(cpPath, cp); err != nil {
+		t.Fatalf("SaveCheckpoint: %v", err)
+	}
+	active := NewBulkReceiver(DefaultTransferConfig())
+	active.SetManifest(m)
+	done := NewBulkReceiver(DefaultTransferConfig())
+	done.SetManifest(m)
+	for _, c := range chunks {
+		_ = done.ReceiveChunk(CompressChunk(c, CompressionFast))
+	}
+
+	var events []ReapEvent
+	rp := NewReaper(time.Minute, func(ev ReapEvent) { events = append(events, ev) })
+	rp.Track("stale", stale, cpPath)
+	rp.Track("active", active)
+	rp.Track("done", done)
+
+	start := time.Now()
+	rp.Sweep(start.Add(30 * time.Second))
+	if rp.Len() != 2 {
+		t.Fatalf("completed transfer still tracked: %d", rp.Len())
+	}
+	_ = active.ReceiveChunk(CompressChunk(chunks[0], CompressionFast))
+	rp.Sweep(start.Add(45 * time.Second))
+
+	expired := rp.Sweep(start.Add(90 * time.Second))
+	if len(expired) != 1 || expired[0].ID != "stale" || expired[0].Err != nil || len(events) != 1 {
+		t.Fatalf("expired %+v, events %+v", expired, events)
+	}
+	for _, p := range []string{partPath, cpPath} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("%s left behind: %v", p, err)
+		}
+	}
+
+	expired = rp.Sweep(start.Add(2 * time.Minute))
+	if len(expired) != 1 || expired[0].ID != "active" || active.Progress() != 0 {
+		t.Fatalf("idle active transfer: %+v, progress %v", expired, active.Progress())
+	}
+}
+
+func TestReaperRunWithClock(t *testing.T) {
+	m, _ := BuildManifest(make([]byte, 1000), 1000)
+	r := NewBulkReceiver(DefaultTransferConfig())
+	r.SetManifest(m)
+
+	fake := clock.NewFake(time.Now())
+	events := make(chan ReapEvent, 1)
+	rp := NewReaper(time.Minute, func(ev ReapEvent) { events <- ev })
+	rp.SetClock(fake)
+	rp.Track("idle", r)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rp.Run(ctx)
+
+	fake.BlockUntil(1)
+	start := fake.Now()
+	for range 100 {
+		fake.Advance(15 * time.Second)
+		select {
+		case ev := <-events:
+			if ev.ID != "idle" || ev.Idle < time.Minute || fake.Now().Sub(start) < time.Minute {
+				t.Fatalf("event %+v after %v", ev, fake.Now().Sub(start))
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Fatal("transfer not expired")
+}
+
+func TestReadBatchContextStall(t *testing.T) {
+	// Deadline-capable reader: peer sends a length prefix and stalls.
+	client, server := net.Pipe()
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+	go func() {
+		_, _ = server.Write([]byte{0, 0, 0, 100})
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := ReadBatchContext(ctx, client); err != ErrBatchTimeout {
+		t.Fatalf("expected ErrBatchTimeout, got %v", err)
+	}
+
+	// Plain reader without deadline support.
+	pr, pw := io.Pipe()
+	defer func() {
+		_ = pw.Close()
+	}()
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel2()
+	if _, err := ReadBatchContext(ctx2, pr); err != ErrBatchTimeout {
+		t.Fatalf("expected ErrBatchTimeout, got %v", err)
+	}
+
+	// Normal round trip still works.
+	var buf bytes.Buffer
+	batch := NewBatch()
+	batch.Add(CompressChunk(Chunk{Index: 3, Data: []byte("ok"), Hash: HashChunk([]byte("ok"))}, CompressionFast))
+	if err := WriteBatchContext(context.Background(), &buf, batch); err != nil {
+		t.Fatalf("WriteBatchContext: %v", err)
+	}
+	got, err := ReadBatchContext(context.Background(), &buf)
+	if err != nil || len(got.Chunks) != 1 || got.Chunks[0].Index != 3 {
+		t.Fatalf("ReadBatchContext: %v", err)
+	}
+
+	// A truncated body is reported rather than hanging.
+	if _, err := ReadBatch(bytes.NewReader([]byte{0, 0, 0, 10, 1, 2})); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+}
+
 func BenchmarkChunkAndCompress(b *testing.B) {
 	data := make([]byte, 4*1024*1024) // 4 MB
 	for i := range data {
@@ -138,3 +740,419 @@ func BenchmarkChunkAndCompress(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkHashChunks(b *testing.B) {
+	data := make([][]byte, 64)
+	for i := range data {
+		data[i] = make([]byte, 256*1024)
+	}
+	b.Run("serial", func(b *testing.B) {
+		b.SetBytes(int64(len(data) * 256 * 1024))
+		for i := 0; i < b.N; i++ {
+			for _, d := range data {
+				_ = HashChunk(d)
+			}
+		}
+	})
+	b.Run("lanes", func(b *testing.B) {
+		b.SetBytes(int64(len(data) * 256 * 1024))
+		for i := 0; i < b.N; i++ {
+			_ = HashChunks(data)
+		}
+	})
+}
+
+func TestNegotiatePathMTU(t *testing.T) {
+	jumbo := map[string]string{PathMTUCapability: "9000"}
+	if got := NegotiatePathMTU(jumbo, jumbo); got != 9000 {
+		t.Fatalf("jumbo both sides: %d", got)
+	}
+	if got := NegotiatePathMTU(jumbo, map[string]string{PathMTUCapability: "1500"}); got != 1500 {
+		t.Fatalf("mixed: %d", got)
+	}
+	if got := NegotiatePathMTU(jumbo, nil); got != MinPathMTU {
+		t.Fatalf("unadvertised: %d", got)
+	}
+	if got := NegotiatePathMTU(jumbo, map[string]string{PathMTUCapability: "lots"}); got != MinPathMTU {
+		t.Fatalf("invalid: %d", got)
+	}
+}
+
+func TestConfigForPathMTU(t *testing.T) {
+	cfg := DefaultTransferConfig()
+	if got := cfg.ForPathMTU(1500); got != cfg {
+		t.Fatalf("1500 changed config: %+v", got)
+	}
+	if got := cfg.ForPathMTU(JumboPathMTU).ChunkSize; got != 4*cfg.ChunkSize {
+		t.Fatalf("jumbo chunk size %d", got)
+	}
+	cfg.ChunkSize = MaxBatchSize
+	if got := cfg.ForPathMTU(JumboPathMTU).ChunkSize; got != MaxBatchSize/2 {
+		t.Fatalf("capped chunk size %d", got)
+	}
+}
+
+func TestGetChunksRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("pull me "), 4096)
+	chunks := NewChunker(1024).Split(data)
+	manifest, err := NewManifest(chunks, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := NewBulkReceiver(DefaultTransferConfig())
+	for _, c := range chunks[:len(chunks)-2] {
+		if err := have.ReceiveChunk(CompressChunk(c, CompressionFast)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frames, err := GetChunksFrames(manifest.Root, []int{0, len(chunks) - 2, 3, len(chunks) - 1})
+	if err != nil || len(frames) != 1 {
+		t.Fatalf("GetChunksFrames: %d frames, %v", len(frames), err)
+	}
+	var wire bytes.Buffer
+	_ = protocol.WriteFrame(&wire, frames[0])
+	f, _ := protocol.ReadFrame(&wire)
+	req, err := protocol.DecodeChunkRequest(f.Payload)
+	if err != nil || f.Type != protocol.MessageTypeGetChunks {
+		t.Fatalf("request %v: %v", f.Type, err)
+	}
+	if err := AnswerGetChunks(&wire, req, have.Lookup(CompressionFast)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := NewBulkReceiver(DefaultTransferConfig())
+	for _, idx := range []int{0, 3} {
+		f, err := protocol.ReadFrame(&wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, cc, err := ChunkFromFrame(f)
+		if err != nil || !bytes.Equal(root, manifest.Root) || cc.Index != idx {
+			t.Fatalf("chunk %d: index %d, %v", idx, cc.Index, err)
+		}
+		if err := want.ReceiveChunk(cc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, _ = protocol.ReadFrame(&wire)
+	un, err := protocol.DecodeChunkRequest(f.Payload)
+	if err != nil || f.Type != protocol.MessageTypeChunksUnavailable || len(un.Indexes) != 2 ||
+		int(un.Indexes[0]) != len(chunks)-2 || int(un.Indexes[1]) != len(chunks)-1 {
+		t.Fatalf("unavailable %v: %+v, %v", f.Type, un.Indexes, err)
+	}
+	if wire.Len() != 0 {
+		t.Fatalf("%d trailing bytes", wire.Len())
+	}
+
+	many := make([]int, protocol.MaxChunkIndexes+1)
+	if frames, _ := GetChunksFrames(manifest.Root, many); len(frames) != 2 {
+		t.Fatalf("split into %d frames", len(frames))
+	}
+}
+
+func TestReceiveRange(t *testing.T) {
+	data := make([]byte, 10*1024+77)
+	for i := range data {
+		data[i] = byte(i*7 + i/1024)
+	}
+	chunks := NewChunker(1024).Split(data)
+	m, _ := NewManifest(chunks, 1024)
+	served := 0
+	lookup := func(i int) (CompressedChunk, bool) {
+		served++
+		if i >= len(chunks) || i == 9 {
+			return CompressedChunk{}, false
+		}
+		return CompressChunk(chunks[i], CompressionFast), true
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		_ = ServeGetChunks(server, m.Root, lookup)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, r := range [][2]int64{{0, 1}, {1000, 100}, {2048, 1024}, {3000, 4000}, {10 * 1024, 77}, {5, 0}} {
+		served = 0
+		got, err := ReceiveRange(ctx, client, m, r[0], r[1])
+		if err != nil {
+			t.Fatalf("range %v: %v", r, err)
+		}
+		if !bytes.Equal(got, data[r[0]:r[0]+r[1]]) {
+			t.Fatalf("range %v: wrong bytes", r)
+		}
+		if want := int((r[0]+r[1]+1023)/1024 - r[0]/1024); r[1] > 0 && served != want {
+			t.Fatalf("range %v: served %d chunks, want %d", r, served, want)
+		}
+	}
+	if _, err := ReceiveRange(ctx, client, m, 9*1024, 10); !errors.Is(err, ErrChunksUnavailable) {
+		t.Fatalf("unavailable chunk: %v", err)
+	}
+	if _, err := ReceiveRange(ctx, client, m, m.Size-1, 2); !errors.Is(err, ErrRangeInvalid) {
+		t.Fatalf("range past the end: %v", err)
+	}
+
+	// A peer serving another chunk, consistently hashed, fails the proof.
+	liar, server2 := net.Pipe()
+	defer liar.Close()
+	go func() {
+		defer server2.Close()
+		_ = ServeGetChunks(server2, m.Root, func(i int) (CompressedChunk, bool) {
+			c := chunks[0]
+			c.Index = i
+			return CompressChunk(c, CompressionFast), true
+		})
+	}()
+	if _, err := ReceiveRange(ctx, liar, m, 1024, 10); !errors.Is(err, ErrChunkHashInvalid) {
+		t.Fatalf("substituted chunk: %v", err)
+	}
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	data := bytes.Repeat([]byte("snapshot "), 50000)
+	br := NewBulkReceiver(DefaultTransferConfig())
+	for _, c := range NewChunker(64 * 1024).Split(data) {
+		if err := br.ReceiveChunk(CompressChunk(c, CompressionFast)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snap := br.Stats().Snapshot()
+	if snap.TotalBytes != int64(len(data)) || snap.ChunksReceived != 7 {
+		t.Fatalf("snapshot %+v", snap)
+	}
+	if snap.CompressionRatio <= 1 || snap.Throughput != float64(snap.CompressedBytes)/RateWindow.Seconds() {
+		t.Fatalf("ratio %.2f, throughput %.0f", snap.CompressionRatio, snap.Throughput)
+	}
+
+	var s TransferStats
+	now := time.Unix(1000, 0)
+	s.count(100, 100, now.Add(-RateWindow)) // just outside the window
+	s.count(100, 50, now.Add(-time.Second))
+	s.count(100, 30, now)
+	if got := s.throughput(now); got != 80/RateWindow.Seconds() {
+		t.Fatalf("throughput %v", got)
+	}
+	if got := s.throughput(now.Add(RateWindow)); got != 0 {
+		t.Fatalf("throughput after the window %v", got)
+	}
+}
+
+// halveCodec "compresses" runs of identical byte pairs by dropping every
+// second byte, which is enough to exercise the codec plumbing.
+type halveCodec struct{}
+
+func (halveCodec) ID() CodecID  { return 200 }
+func (halveCodec) Name() string { return "halve" }
+func (halveCodec) Compress(data []byte, _ CompressionLevel) ([]byte, error) {
+	out := make([]byte, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i] != data[i+1] {
+			return nil, ErrCompressionFailed
+		}
+		out = append(out, data[i])
+	}
+	return out, nil
+}
+func (halveCodec) Decompress(data []byte) ([]byte, error) {
+	out := make([]byte, 0, 2*len(data))
+	for _, b := range data {
+		out = append(out, b, b)
+	}
+	return out, nil
+}
+
+func TestCodecRegistry(t *testing.T) {
+	if err := RegisterCodec(halveCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterCodec(halveCodec{}); !errors.Is(err, ErrCodecRegistered) {
+		t.Fatalf("duplicate registration: %v", err)
+	}
+	if err := RegisterCodec(NoCompression); !errors.Is(err, ErrCodecID) {
+		t.Fatalf("registering ID 0: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("aabbcc"), 100)
+	cc := CompressChunkWith(Chunk{Index: 3, Data: data, Hash: HashChunk(data)}, halveCodec{}, CompressionFast)
+	if cc.CodecID() != 200 || len(cc.Data) != len(data)/2 {
+		t.Fatalf("codec %d, %d bytes", cc.CodecID(), len(cc.Data))
+	}
+	b := NewBatch()
+	b.Add(cc)
+	b.Add(CompressChunkWith(Chunk{Index: 4, Data: data, Hash: HashChunk(data)}, NoCompression, CompressionFast))
+	enc, _ := b.Encode()
+	dec, err := DecodeBatch(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Chunks[1].Compressed || dec.Chunks[1].CodecID() != CodecNone {
+		t.Fatalf("NoCompression chunk decoded as codec %d", dec.Chunks[1].CodecID())
+	}
+	for _, cc := range dec.Chunks {
+		if c, err := DecompressChunk(cc); err != nil || !bytes.Equal(c.Data, data) {
+			t.Fatalf("chunk %d: %v", cc.Index, err)
+		}
+	}
+	unknown := cc
+	unknown.Codec = 201
+	if _, err := DecompressChunk(unknown); !errors.Is(err, ErrCodecUnknown) {
+		t.Fatalf("unknown codec: %v", err)
+	}
+
+	if got := CodecCapability(); got != "1,200" {
+		t.Fatalf("capability %q", got)
+	}
+	if c := NegotiateCodec(map[string]string{}, halveCodec{}, LZ4); c != LZ4 {
+		t.Fatalf("legacy peer negotiated %s", c.Name())
+	}
+	if c := NegotiateCodec(map[string]string{CompressionCapability: "1,200"}, halveCodec{}, LZ4); c.ID() != 200 {
+		t.Fatalf("negotiated %s", c.Name())
+	}
+	if c := NegotiateCodec(map[string]string{CompressionCapability: ""}); c != NoCompression {
+		t.Fatalf("peer without codecs negotiated %s", c.Name())
+	}
+
+	SetContentTypeCodec("video/*", NoCompression)
+	SetContentTypeCodec("video/x-raw", LZ4)
+	defer SetContentTypeCodec("video/*", nil)
+	defer SetContentTypeCodec("video/x-raw", nil)
+	cfg := DefaultTransferConfig()
+	if c := cfg.ForContentType("video/mp4").Codec; c != NoCompression {
+		t.Fatalf("video/mp4 uses %v", c)
+	}
+	if c := cfg.ForContentType("video/x-raw; rate=30").Codec; c != LZ4 {
+		t.Fatalf("video/x-raw uses %v", c)
+	}
+	if c := cfg.ForContentType("text/plain").Codec; c != nil {
+		t.Fatalf("text/plain uses %v", c)
+	}
+}
+
+func TestSkipIncompressible(t *testing.T) {
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 1500)
+	if !HighEntropy(random) || HighEntropy(text) || HighEntropy(random[:512]) {
+		t.Fatal("entropy misclassified")
+	}
+	if cc := CompressChunk(Chunk{Data: random, Hash: HashChunk(random)}, CompressionBest); cc.Compressed {
+		t.Fatal("random chunk compressed")
+	}
+	if cc := CompressChunk(Chunk{Data: text, Hash: HashChunk(text)}, CompressionFast); !cc.Compressed {
+		t.Fatal("text chunk not compressed")
+	}
+
+	cfg := DefaultTransferConfig()
+	if cfg.ForFile("movie.MP4", nil).Codec != NoCompression || cfg.ForFile("notes.txt", text).Codec != nil {
+		t.Fatal("ForFile by name")
+	}
+	for _, head := range [][]byte{
+		{0x1f, 0x8b, 8, 0},
+		[]byte("PK\x03\x04rest"),
+		[]byte("\x00\x00\x00\x20ftypisom"),
+		[]byte("RIFF\x00\x00\x00\x00WEBPVP8 "),
+	} {
+		if cfg.ForFile("blob", head).Codec != NoCompression {
+			t.Fatalf("head %q not detected", head)
+		}
+	}
+}
+
+func TestCompressionFeedback(t *testing.T) {
+	random := make([]byte, 4096)
+	text := bytes.Repeat([]byte("feedback "), 455)
+	rng := rand.New(rand.NewSource(2))
+	chunk := func(i int, data []byte) Chunk {
+		return Chunk{Index: i, Data: data, Hash: HashChunk(data)}
+	}
+
+	var stats TransferStats
+	c := newCompressor(nil, CompressionFast, CompressionFeedback{Window: 3, Reprobe: 4}, &stats)
+	// Small-alphabet noise: not high entropy, but LZ4 cannot shrink it.
+	for i := 0; i < 3; i++ {
+		rng.Read(random)
+		for j := range random {
+			random[j] = 'a' + random[j]%16
+		}
+		c.compress(chunk(i, random))
+	}
+	if !stats.CompressionOff.Load() {
+		t.Fatal("compression still on after 3 poor chunks")
+	}
+	for i := 0; i < 4; i++ {
+		if cc := c.compress(chunk(i, text)); cc.Compressed {
+			t.Fatalf("chunk %d compressed while off", i)
+		}
+	}
+	if cc := c.compress(chunk(4, text)); !cc.Compressed {
+		t.Fatal("probe not compressed")
+	}
+	snap := stats.Snapshot()
+	if snap.CompressionOff || snap.CompressionSwitches != 2 || snap.CompressionSkipped != 4 {
+		t.Fatalf("snapshot %+v", snap)
+	}
+
+	off := newCompressor(nil, CompressionFast, CompressionFeedback{Disabled: true, Window: 1}, &stats)
+	for i := 0; i < 3; i++ {
+		off.compress(chunk(i, random))
+	}
+	if stats.CompressionOff.Load() {
+		t.Fatal("disabled feedback turned compression off")
+	}
+}
+
+// lz4Corpus is text-like data for the LZ4 level tests and benchmarks.
+func lz4Corpus() []byte {
+	var b bytes.Buffer
+	rng := rand.New(rand.NewSource(3))
+	words := []string{"chunk", "merkle", "peer", "stream", "session", "window", "frame", "codec"}
+	for b.Len() < 256*1024 {
+		b.WriteString(words[rng.Intn(len(words))])
+		b.WriteByte(" \n"[rng.Intn(2)])
+	}
+	return b.Bytes()
+}
+
+func TestLZ4Levels(t *testing.T) {
+	data := lz4Corpus()
+	if _, err := NewLZ4(LZ4Options{Level: MaxLZ4Level + 1}); !errors.Is(err, ErrLZ4Level) {
+		t.Fatalf("level 10: %v", err)
+	}
+	prev := len(data) + 1
+	for _, level := range []int{0, 1, MaxLZ4Level} {
+		codec, err := NewLZ4(LZ4Options{Level: level})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc := CompressChunkWith(Chunk{Data: data, Hash: HashChunk(data)}, codec, CompressionFast)
+		if cc.CodecID() != CodecLZ4 || len(cc.Data) > prev {
+			t.Fatalf("level %d: codec %d, %d bytes after %d", level, cc.CodecID(), len(cc.Data), prev)
+		}
+		prev = len(cc.Data)
+		if _, err := DecompressChunk(cc); err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+	}
+	if CompressionBest.LZ4Level() != MaxLZ4Level || CompressionFast.LZ4Level() != 0 {
+		t.Fatal("coarse level mapping")
+	}
+}
+
+func BenchmarkLZ4Levels(b *testing.B) {
+	data := lz4Corpus()
+	for level := 0; level <= MaxLZ4Level; level++ {
+		codec, _ := NewLZ4(LZ4Options{Level: level})
+		b.Run(fmt.Sprintf("level%d", level), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			var out []byte
+			for i := 0; i < b.N; i++ {
+				out, _ = codec.Compress(data, CompressionFast)
+			}
+			b.ReportMetric(float64(len(data))/float64(len(out)), "ratio")
+		})
+	}
+}
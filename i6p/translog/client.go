@@ -0,0 +1,93 @@
+package translog
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// Client talks to the log hosted at the other end of a session.
+type Client struct {
+	s *session.Session
+}
+
+// NewClient creates a client for the log on s.
+func NewClient(s *session.Session) *Client {
+	return &Client{s: s}
+}
+
+// exchange sends req on a new stream and reads the response, bounded by ctx.
+func (c *Client) exchange(ctx context.Context, req request) (response, error) {
+	var resp response
+	st, err := c.s.OpenProtocolStream(ctx, ProtocolName)
+	if err != nil {
+		return resp, err
+	}
+	defer st.Close()
+	stop := context.AfterFunc(ctx, func() { _ = st.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	err = roundTrip(st, req, &resp)
+	if ctx.Err() != nil {
+		return resp, ctx.Err()
+	}
+	if err == nil && resp.Error != "" {
+		err = fmt.Errorf("%w: %s", ErrRemoteFail, resp.Error)
+	}
+	return resp, err
+}
+
+func roundTrip(st transport.Stream, req request, resp *response) error {
+	if err := writeMsg(st, req); err != nil {
+		return err
+	}
+	return readMsg(st, resp)
+}
+
+// Append appends e to the log and returns its index.
+func (c *Client) Append(ctx context.Context, e Entry) (uint64, error) {
+	resp, err := c.exchange(ctx, request{Op: opAppend, Entry: &e})
+	return resp.Index, err
+}
+
+// Head returns the log's current tree head. Callers verify it.
+func (c *Client) Head(ctx context.Context) (Head, error) {
+	resp, err := c.exchange(ctx, request{Op: opHead})
+	if err == nil && resp.Head == nil {
+		err = fmt.Errorf("%w: missing head", ErrMessage)
+	}
+	if err != nil {
+		return Head{}, err
+	}
+	return *resp.Head, nil
+}
+
+// Prove returns the bundle vouching for id's key, to present in a HELLO
+// under Capability. Callers verify it.
+func (c *Client) Prove(ctx context.Context, id identity.PeerID) (Bundle, error) {
+	resp, err := c.exchange(ctx, request{Op: opProve, PeerID: id})
+	if err == nil && resp.Bundle == nil {
+		err = fmt.Errorf("%w: missing bundle", ErrMessage)
+	}
+	if err != nil {
+		return Bundle{}, err
+	}
+	return *resp.Bundle, nil
+}
+
+// Consistency returns the proof that the tree of size first is a prefix of
+// the tree of size second, for VerifyConsistency.
+func (c *Client) Consistency(ctx context.Context, first, second uint64) ([][]byte, error) {
+	resp, err := c.exchange(ctx, request{Op: opConsistency, First: first, Second: second})
+	return resp.Path, err
+}
+
+// Entries returns the entries from index from up to to (exclusive); the log
+// may return fewer than asked.
+func (c *Client) Entries(ctx context.Context, from, to uint64) ([]Entry, error) {
+	resp, err := c.exchange(ctx, request{Op: opEntries, First: from, Second: to})
+	return resp.Entries, err
+}
@@ -0,0 +1,255 @@
+package translog
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	i6perrors "github.com/TheusHen/I6P/i6p/errors"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// MaxEntries bounds the entries one Entries call returns.
+const MaxEntries = 1024
+
+// keys tracks what the entries so far say about each key.
+type keys struct {
+	latest  map[identity.PeerID]uint64 // index of the entry vouching for a live key
+	retired map[identity.PeerID]bool
+	revoked map[identity.PeerID]bool
+}
+
+func newKeys() keys {
+	return keys{latest: map[identity.PeerID]uint64{}, retired: map[identity.PeerID]bool{}, revoked: map[identity.PeerID]bool{}}
+}
+
+// check reports whether e may follow the entries so far.
+func (k keys) check(e Entry) error {
+	id := identity.PeerIDFromPublicKey(e.PublicKey)
+	switch {
+	case k.revoked[id]:
+		return fmt.Errorf("%w: %s", ErrRevoked, id)
+	case e.Kind == KindRevoke:
+		return nil
+	case k.retired[id]:
+		return fmt.Errorf("%w: %s", ErrRetired, id)
+	case e.Kind == KindRotate:
+		if _, ok := k.latest[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		next := identity.PeerIDFromPublicKey(e.NewKey)
+		if _, ok := k.latest[next]; ok || k.retired[next] || k.revoked[next] {
+			return fmt.Errorf("%w: new key already in the log", ErrEntry)
+		}
+	}
+	return nil
+}
+
+// apply records e, the entry at index.
+func (k keys) apply(e Entry, index uint64) {
+	id := identity.PeerIDFromPublicKey(e.PublicKey)
+	switch e.Kind {
+	case KindPublish:
+		k.latest[id] = index
+	case KindRotate:
+		delete(k.latest, id)
+		k.retired[id] = true
+		k.latest[identity.PeerIDFromPublicKey(e.NewKey)] = index
+	case KindRevoke:
+		delete(k.latest, id)
+		k.revoked[id] = true
+	}
+}
+
+// status returns nil if id is live, or why it is not.
+func (k keys) status(id identity.PeerID) error {
+	switch {
+	case k.revoked[id]:
+		return fmt.Errorf("%w: %s", ErrRevoked, id)
+	case k.retired[id]:
+		return fmt.Errorf("%w: %s", ErrRetired, id)
+	}
+	if _, ok := k.latest[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// Log is an in-memory transparency log operated with one key. It is safe
+// for concurrent use. Proofs are computed from the leaves on demand, which
+// suits logs of up to a few hundred thousand entries.
+type Log struct {
+	kp identity.KeyPair
+
+	mu      sync.Mutex
+	entries []Entry
+	leaves  [][]byte
+	seen    map[string]bool
+	root    []byte
+	keys    keys
+}
+
+// NewLog creates an empty log whose tree heads kp signs.
+func NewLog(kp identity.KeyPair) *Log {
+	return &Log{kp: kp, seen: map[string]bool{}, root: rootOf(nil), keys: newKeys()}
+}
+
+// PublicKey returns the key verifiers trust the log by.
+func (l *Log) PublicKey() ed25519.PublicKey { return l.kp.PublicKey }
+
+// Append verifies e and adds it to the log, returning its index. Keys that
+// were revoked or rotated away cannot be published again, a rotation must
+// start from a live key, and an entry can only be appended once.
+func (l *Log) Append(e Entry) (uint64, error) {
+	if err := e.Verify(); err != nil {
+		return 0, err
+	}
+	leaf := e.LeafHash()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen[string(leaf)] {
+		return 0, fmt.Errorf("%w: already in the log", ErrEntry)
+	}
+	if err := l.keys.check(e); err != nil {
+		return 0, err
+	}
+	index := uint64(len(l.entries))
+	l.entries = append(l.entries, e)
+	l.leaves = append(l.leaves, leaf)
+	l.seen[string(leaf)] = true
+	l.root = rootOf(l.leaves)
+	l.keys.apply(e, index)
+	return index, nil
+}
+
+// Head returns the current tree head, signed now.
+func (l *Log) Head() Head {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.headLocked()
+}
+
+func (l *Log) headLocked() Head {
+	h := Head{Size: uint64(len(l.leaves)), Root: l.root, Time: time.Now()}
+	// SignContext fails only for an invalid context.
+	h.Signature, _ = l.kp.SignContext(identity.ContextLog, h.SigningBytes())
+	return h
+}
+
+// Prove returns the bundle vouching for id's key under the current head.
+func (l *Log) Prove(id identity.PeerID) (Bundle, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.keys.status(id); err != nil {
+		return Bundle{}, err
+	}
+	index := l.keys.latest[id]
+	return Bundle{
+		Entry: l.entries[index],
+		Index: index,
+		Path:  inclusionPath(index, l.leaves),
+		Head:  l.headLocked(),
+	}, nil
+}
+
+// Consistency returns the proof that the tree of size first is a prefix of
+// the tree of size second.
+func (l *Log) Consistency(first, second uint64) ([][]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if first > second || second > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("%w: sizes %d and %d of %d", ErrProof, first, second, len(l.leaves))
+	}
+	if first == 0 {
+		return nil, nil
+	}
+	return consistencyPath(first, l.leaves[:second], true), nil
+}
+
+// Entries returns the entries from index from up to to (exclusive), at most
+// MaxEntries of them.
+func (l *Log) Entries(from, to uint64) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if from > to || to > uint64(len(l.entries)) {
+		return nil, fmt.Errorf("%w: range %d-%d of %d", ErrEntry, from, to, len(l.entries))
+	}
+	to = min(to, from+MaxEntries)
+	return append([]Entry(nil), l.entries[from:to]...), nil
+}
+
+const (
+	opAppend      = "append"
+	opHead        = "head"
+	opProve       = "prove"
+	opConsistency = "consistency"
+	opEntries     = "entries"
+)
+
+type request struct {
+	Op     string          `json:"op"`
+	Entry  *Entry          `json:"entry,omitempty"`  // append
+	PeerID identity.PeerID `json:"peer_id"`          // prove
+	First  uint64          `json:"first,omitempty"`  // consistency, entries (from)
+	Second uint64          `json:"second,omitempty"` // consistency, entries (to)
+}
+
+type response struct {
+	Error   string   `json:"error,omitempty"`
+	Index   uint64   `json:"index,omitempty"`   // append
+	Head    *Head    `json:"head,omitempty"`    // head
+	Bundle  *Bundle  `json:"bundle,omitempty"`  // prove
+	Path    [][]byte `json:"path,omitempty"`    // consistency
+	Entries []Entry  `json:"entries,omitempty"` // entries
+}
+
+// Serve answers log requests on s until the session ends or ctx is done, so
+// a designated peer can host l. Streams of other protocols are left to the
+// session's other handlers, and errors go to its stream error hook.
+func (l *Log) Serve(ctx context.Context, s *session.Session) error {
+	return s.ServeProtocol(ctx, ProtocolName, func(st transport.Stream) error {
+		return l.Handle(st)
+	})
+}
+
+// Handle answers one request read from rw.
+func (l *Log) Handle(rw io.ReadWriter) (err error) {
+	defer i6perrors.Recover(&err, "translog")
+	var req request
+	if err := readMsg(rw, &req); err != nil {
+		return err
+	}
+	var resp response
+	switch req.Op {
+	case opAppend:
+		if req.Entry == nil {
+			err = fmt.Errorf("%w: missing entry", ErrMessage)
+			break
+		}
+		resp.Index, err = l.Append(*req.Entry)
+	case opHead:
+		h := l.Head()
+		resp.Head = &h
+	case opProve:
+		var b Bundle
+		if b, err = l.Prove(req.PeerID); err == nil {
+			resp.Bundle = &b
+		}
+	case opConsistency:
+		resp.Path, err = l.Consistency(req.First, req.Second)
+	case opEntries:
+		resp.Entries, err = l.Entries(req.First, req.Second)
+	default:
+		err = fmt.Errorf("%w: unknown op %q", ErrMessage, req.Op)
+	}
+	if err != nil {
+		_ = writeMsg(rw, response{Error: err.Error()})
+		return err
+	}
+	return writeMsg(rw, resp)
+}
@@ -0,0 +1,155 @@
+package translog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+)
+
+// The tree is the RFC 9162 Merkle tree: leaves and interior nodes are
+// hashed with distinct prefixes, and a tree of n leaves splits at the
+// largest power of two below n, so proofs exist for every size.
+
+func leafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// split returns the largest power of two smaller than n, for n > 1.
+func split(n uint64) uint64 {
+	return 1 << (bits.Len64(n-1) - 1)
+}
+
+// rootOf returns the root over the leaf hashes.
+func rootOf(leaves [][]byte) []byte {
+	switch n := uint64(len(leaves)); n {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	default:
+		k := split(n)
+		return nodeHash(rootOf(leaves[:k]), rootOf(leaves[k:]))
+	}
+}
+
+// inclusionPath returns the audit path of leaf m in the tree of leaves.
+func inclusionPath(m uint64, leaves [][]byte) [][]byte {
+	n := uint64(len(leaves))
+	if n <= 1 {
+		return nil
+	}
+	k := split(n)
+	if m < k {
+		return append(inclusionPath(m, leaves[:k]), rootOf(leaves[k:]))
+	}
+	return append(inclusionPath(m-k, leaves[k:]), rootOf(leaves[:k]))
+}
+
+// consistencyPath returns the proof that the tree of the first m leaves is
+// a prefix of the tree of leaves.
+func consistencyPath(m uint64, leaves [][]byte, complete bool) [][]byte {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{rootOf(leaves)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(consistencyPath(m, leaves[:k], complete), rootOf(leaves[k:]))
+	}
+	return append(consistencyPath(m-k, leaves[k:], false), rootOf(leaves[:k]))
+}
+
+// VerifyInclusion checks that the leaf with hash leaf is at index in the
+// tree of size leaves whose root is root (RFC 9162 section 2.1.3.2).
+func VerifyInclusion(leaf []byte, index, size uint64, path [][]byte, root []byte) error {
+	if index >= size {
+		return fmt.Errorf("%w: index %d of %d", ErrProof, index, size)
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range path {
+		if sn == 0 {
+			return fmt.Errorf("%w: path too long", ErrProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(r, root) {
+		return fmt.Errorf("%w: inclusion does not match the root", ErrProof)
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree of size first with root firstRoot
+// is a prefix of the tree of size second with root secondRoot, so the log
+// only appended between them (RFC 9162 section 2.1.4.2).
+func VerifyConsistency(first, second uint64, firstRoot, secondRoot []byte, path [][]byte) error {
+	switch {
+	case first > second:
+		return fmt.Errorf("%w: tree shrank from %d to %d", ErrProof, first, second)
+	case first == second:
+		if len(path) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+			return fmt.Errorf("%w: same size, different roots", ErrProof)
+		}
+		return nil
+	case first == 0:
+		return nil
+	case len(path) == 0:
+		return fmt.Errorf("%w: empty consistency proof", ErrProof)
+	}
+	if first&(first-1) == 0 {
+		path = append([][]byte{firstRoot}, path...)
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := path[0], path[0]
+	for _, c := range path[1:] {
+		if sn == 0 {
+			return fmt.Errorf("%w: path too long", ErrProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+		return fmt.Errorf("%w: consistency does not match the roots", ErrProof)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package translog
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// DefaultMonitorInterval is how often Monitor.Run polls when interval is
+// zero.
+const DefaultMonitorInterval = time.Minute
+
+// Source is a log a Monitor follows. Client implements it.
+type Source interface {
+	Head(ctx context.Context) (Head, error)
+	Entries(ctx context.Context, from, to uint64) ([]Entry, error)
+}
+
+// Monitor audits a log: it downloads every entry, checks that each tree
+// head signed by the log covers exactly the entries seen so far plus new
+// ones, and tracks which keys are live, rotated away or revoked. A log that
+// rewrites history or shows different trees to different peers is caught
+// by the first Update that sees the conflict. It is safe for concurrent use.
+type Monitor struct {
+	src    Source
+	logKey ed25519.PublicKey
+
+	mu     sync.RWMutex
+	head   Head
+	leaves [][]byte
+	keys   keys
+}
+
+// NewMonitor creates a monitor of the log src, whose tree heads logKey signs.
+func NewMonitor(src Source, logKey ed25519.PublicKey) *Monitor {
+	return &Monitor{src: src, logKey: logKey, keys: newKeys()}
+}
+
+// Update fetches the current tree head and the entries added since the
+// last update, and checks them. On error the monitor keeps its state.
+func (m *Monitor) Update(ctx context.Context) error {
+	h, err := m.src.Head(ctx)
+	if err != nil {
+		return err
+	}
+	if err := h.Verify(m.logKey); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	leaves := m.leaves[:len(m.leaves):len(m.leaves)]
+	old := m.head
+	m.mu.RUnlock()
+	if h.Size < uint64(len(leaves)) {
+		return fmt.Errorf("%w: log shrank from %d to %d entries", ErrProof, len(leaves), h.Size)
+	}
+
+	var added []Entry
+	for uint64(len(leaves)) < h.Size {
+		entries, err := m.src.Entries(ctx, uint64(len(leaves)), h.Size)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 || uint64(len(leaves)+len(entries)) > h.Size {
+			return fmt.Errorf("%w: log returned %d entries", ErrEntry, len(entries))
+		}
+		for _, e := range entries {
+			if err := e.Verify(); err != nil {
+				return err
+			}
+			leaves = append(leaves, e.LeafHash())
+		}
+		added = append(added, entries...)
+	}
+	if !bytes.Equal(rootOf(leaves), h.Root) {
+		return fmt.Errorf("%w: head of size %d does not match the entries", ErrProof, h.Size)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.head.Size != old.Size || !bytes.Equal(m.head.Root, old.Root) {
+		return fmt.Errorf("translog: concurrent monitor update")
+	}
+	for i, e := range added {
+		index := uint64(len(m.leaves) + i)
+		if err := m.keys.check(e); err != nil {
+			return fmt.Errorf("entry %d: %w", index, err)
+		}
+		m.keys.apply(e, index)
+	}
+	m.leaves, m.head = leaves, h
+	return nil
+}
+
+// Run calls Update every interval (DefaultMonitorInterval if zero) until
+// ctx is done or an update finds the log misbehaving. Failures to reach the
+// log are retried.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultMonitorInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := m.Update(ctx); err != nil && isMisbehavior(err) {
+			return err
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Head returns the last tree head the monitor checked.
+func (m *Monitor) Head() Head {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.head
+}
+
+// Status returns nil if the key of id is live in the log, or why it is not:
+// ErrRevoked, ErrRetired or ErrNotFound.
+func (m *Monitor) Status(id identity.PeerID) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.keys.status(id)
+}
+
+// isMisbehavior reports whether err shows the log at fault rather than
+// unreachable.
+func isMisbehavior(err error) bool {
+	for _, target := range []error{ErrProof, ErrSignature, ErrEntry, ErrRevoked, ErrRetired, ErrNotFound} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,273 @@
+// Package translog is an append-only transparency log of identity keys, so
+// that substituting a peer's key cannot go unnoticed.
+//
+// Peers publish their key, rotate to a new one and revoke compromised ones
+// by appending signed entries to a Log. The log is an RFC 9162 Merkle tree:
+// each tree head it signs commits to every entry so far, an inclusion proof
+// shows an entry is in the tree, and a consistency proof shows a later tree
+// only extends an earlier one. A Monitor follows a log, checks that it only
+// grows, and tracks revoked and retired keys.
+//
+// A peer presents a Bundle (its key's entry, the inclusion proof and a
+// recent tree head) in its HELLO under Capability; Verifier.AcceptPolicy
+// then refuses handshakes from keys the log does not vouch for. A log can
+// run in process or be hosted by designated peers that Serve ProtocolName
+// streams.
+package translog
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// ProtocolName tags transparency log streams (see session.OpenProtocolStream).
+const ProtocolName = "i6p/translog/1"
+
+// Capability is the HELLO capability carrying a peer's Bundle, as
+// Bundle.Capability encodes it.
+const Capability = "i6p.translog"
+
+// maxMessage bounds one control message, which may carry a range of entries.
+const maxMessage = 4 << 20
+
+var (
+	ErrProof      = errors.New("translog: invalid Merkle proof")
+	ErrEntry      = errors.New("translog: invalid entry")
+	ErrSignature  = errors.New("translog: invalid signature")
+	ErrRevoked    = errors.New("translog: key revoked")
+	ErrRetired    = errors.New("translog: key rotated away")
+	ErrNotFound   = errors.New("translog: key not in the log")
+	ErrStale      = errors.New("translog: tree head too old")
+	ErrNoBundle   = errors.New("translog: no inclusion proof presented")
+	ErrMessage    = errors.New("translog: malformed message")
+	ErrTooLarge   = errors.New("translog: message too large")
+	ErrRemoteFail = errors.New("translog: remote failed")
+)
+
+// Kind is what an Entry states about a key.
+type Kind uint8
+
+const (
+	KindPublish Kind = 1 // PublicKey is in use
+	KindRotate  Kind = 2 // PublicKey is replaced by NewKey
+	KindRevoke  Kind = 3 // PublicKey must no longer be trusted
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindPublish:
+		return "publish"
+	case KindRotate:
+		return "rotate"
+	case KindRevoke:
+		return "revoke"
+	default:
+		return "unknown"
+	}
+}
+
+// Entry is one statement in the log, signed by the key it is about. A
+// rotation is also signed by NewKey, so nobody can claim another peer's key
+// as their successor.
+type Entry struct {
+	Kind         Kind              `json:"kind"`
+	PublicKey    ed25519.PublicKey `json:"public_key"`
+	NewKey       ed25519.PublicKey `json:"new_key,omitempty"`
+	Time         time.Time         `json:"time"`
+	Signature    []byte            `json:"signature"`
+	NewSignature []byte            `json:"new_signature,omitempty"`
+}
+
+// Publish returns the entry stating that kp is in use.
+func Publish(kp identity.KeyPair, now time.Time) (Entry, error) {
+	e := Entry{Kind: KindPublish, PublicKey: kp.PublicKey, Time: now}
+	return e, e.sign(kp, nil)
+}
+
+// Rotate returns the entry replacing old with next.
+func Rotate(old, next identity.KeyPair, now time.Time) (Entry, error) {
+	e := Entry{Kind: KindRotate, PublicKey: old.PublicKey, NewKey: next.PublicKey, Time: now}
+	return e, e.sign(old, &next)
+}
+
+// Revoke returns the entry revoking kp.
+func Revoke(kp identity.KeyPair, now time.Time) (Entry, error) {
+	e := Entry{Kind: KindRevoke, PublicKey: kp.PublicKey, Time: now}
+	return e, e.sign(kp, nil)
+}
+
+func (e *Entry) sign(kp identity.KeyPair, next *identity.KeyPair) error {
+	var err error
+	if e.Signature, err = kp.SignContext(identity.ContextRotation, e.SigningBytes()); err != nil {
+		return err
+	}
+	if next != nil {
+		e.NewSignature, err = next.SignContext(identity.ContextRotation, e.SigningBytes())
+	}
+	return err
+}
+
+// SigningBytes returns the bytes the keys of e sign.
+func (e Entry) SigningBytes() []byte {
+	b := []byte{byte(e.Kind)}
+	b = append(b, e.PublicKey...)
+	b = append(b, byte(len(e.NewKey)))
+	b = append(b, e.NewKey...)
+	return binary.BigEndian.AppendUint64(b, uint64(e.Time.UnixNano()))
+}
+
+// Marshal returns the leaf data of e in the tree.
+func (e Entry) Marshal() []byte {
+	b := e.SigningBytes()
+	b = append(b, e.Signature...)
+	return append(b, e.NewSignature...)
+}
+
+// LeafHash returns the hash of e as a leaf of the tree.
+func (e Entry) LeafHash() []byte { return leafHash(e.Marshal()) }
+
+// Verify checks the shape and signatures of e.
+func (e Entry) Verify() error {
+	if len(e.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: public key", ErrEntry)
+	}
+	switch e.Kind {
+	case KindPublish, KindRevoke:
+		if e.NewKey != nil || e.NewSignature != nil {
+			return fmt.Errorf("%w: %s has a new key", ErrEntry, e.Kind)
+		}
+	case KindRotate:
+		if len(e.NewKey) != ed25519.PublicKeySize || e.NewKey.Equal(e.PublicKey) {
+			return fmt.Errorf("%w: new key", ErrEntry)
+		}
+		if !identity.VerifyContext(e.NewKey, identity.ContextRotation, e.SigningBytes(), e.NewSignature) {
+			return fmt.Errorf("%w: new key", ErrSignature)
+		}
+	default:
+		return fmt.Errorf("%w: kind %d", ErrEntry, e.Kind)
+	}
+	if !identity.VerifyContext(e.PublicKey, identity.ContextRotation, e.SigningBytes(), e.Signature) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Subject returns the peer whose key e vouches for: the published key or
+// the rotation's new key. Revocations vouch for nobody.
+func (e Entry) Subject() (identity.PeerID, bool) {
+	switch e.Kind {
+	case KindPublish:
+		return identity.PeerIDFromPublicKey(e.PublicKey), true
+	case KindRotate:
+		return identity.PeerIDFromPublicKey(e.NewKey), true
+	default:
+		return identity.PeerID{}, false
+	}
+}
+
+// Head is a tree head signed by the log key: the log had Size entries, with
+// root Root, at Time.
+type Head struct {
+	Size      uint64    `json:"size"`
+	Root      []byte    `json:"root"`
+	Time      time.Time `json:"time"`
+	Signature []byte    `json:"signature"`
+}
+
+// SigningBytes returns the bytes the log key signs for h.
+func (h Head) SigningBytes() []byte {
+	b := binary.BigEndian.AppendUint64(nil, h.Size)
+	b = append(b, h.Root...)
+	return binary.BigEndian.AppendUint64(b, uint64(h.Time.UnixNano()))
+}
+
+// Verify checks that h is signed by logKey.
+func (h Head) Verify(logKey ed25519.PublicKey) error {
+	if len(h.Root) != 32 || !identity.VerifyContext(logKey, identity.ContextLog, h.SigningBytes(), h.Signature) {
+		return fmt.Errorf("%w: tree head", ErrSignature)
+	}
+	return nil
+}
+
+// Bundle proves that Entry is leaf Index of the tree Head commits to.
+type Bundle struct {
+	Entry Entry    `json:"entry"`
+	Index uint64   `json:"index"`
+	Path  [][]byte `json:"path"`
+	Head  Head     `json:"head"`
+}
+
+// Verify checks the head signature, the entry and its inclusion.
+func (b Bundle) Verify(logKey ed25519.PublicKey) error {
+	if err := b.Head.Verify(logKey); err != nil {
+		return err
+	}
+	if err := b.Entry.Verify(); err != nil {
+		return err
+	}
+	return VerifyInclusion(b.Entry.LeafHash(), b.Index, b.Head.Size, b.Path, b.Head.Root)
+}
+
+// Capability encodes b as the value of the Capability HELLO capability.
+func (b Bundle) Capability() string {
+	j, _ := json.Marshal(b)
+	return base64.RawURLEncoding.EncodeToString(j)
+}
+
+// ParseBundle decodes a Capability value.
+func ParseBundle(s string) (Bundle, error) {
+	var b Bundle
+	j, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return b, fmt.Errorf("%w: %v", ErrMessage, err)
+	}
+	if err := json.Unmarshal(j, &b); err != nil {
+		return b, fmt.Errorf("%w: %v", ErrMessage, err)
+	}
+	return b, nil
+}
+
+// writeMsg sends v as length-prefixed JSON.
+func writeMsg(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMessage {
+		return ErrTooLarge
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readMsg(r io.Reader, v any) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxMessage {
+		return ErrTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMessage, err)
+	}
+	return nil
+}
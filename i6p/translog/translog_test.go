@@ -0,0 +1,222 @@
+package translog
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport/memory"
+)
+
+func TestMerkleProofs(t *testing.T) {
+	var leaves [][]byte
+	for n := 1; n <= 33; n++ {
+		leaves = append(leaves, leafHash([]byte(fmt.Sprint(n))))
+		root := rootOf(leaves)
+		for m := range leaves {
+			path := inclusionPath(uint64(m), leaves)
+			if err := VerifyInclusion(leaves[m], uint64(m), uint64(n), path, root); err != nil {
+				t.Fatalf("inclusion of %d in %d: %v", m, n, err)
+			}
+			if err := VerifyInclusion(leaves[(m+1)%n], uint64(m), uint64(n), path, root); n > 1 && !errors.Is(err, ErrProof) {
+				t.Fatalf("wrong leaf %d in %d verified", m, n)
+			}
+		}
+		for first := 1; first <= n; first++ {
+			path := consistencyPath(uint64(first), leaves, true)
+			if err := VerifyConsistency(uint64(first), uint64(n), rootOf(leaves[:first]), root, path); err != nil {
+				t.Fatalf("consistency %d -> %d: %v", first, n, err)
+			}
+			bad := sha256.Sum256([]byte("forked"))
+			if err := VerifyConsistency(uint64(first), uint64(n), bad[:], root, path); !errors.Is(err, ErrProof) {
+				t.Fatalf("forked consistency %d -> %d verified", first, n)
+			}
+		}
+	}
+}
+
+func TestLogRules(t *testing.T) {
+	logKP, _ := identity.GenerateKeyPair()
+	l := NewLog(logKP)
+	now := time.Now()
+	alice, _ := identity.GenerateKeyPair()
+	alice2, _ := identity.GenerateKeyPair()
+
+	pub, _ := Publish(alice, now)
+	if _, err := l.Append(pub); err != nil {
+		t.Fatalf("Append publish: %v", err)
+	}
+	if _, err := l.Append(pub); !errors.Is(err, ErrEntry) {
+		t.Fatalf("duplicate entry: %v", err)
+	}
+	b, err := l.Prove(alice.PeerID())
+	if err != nil {
+		t.Fatalf("Prove: %v", err)
+	}
+	if err := b.Verify(l.PublicKey()); err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+
+	rot, _ := Rotate(alice, alice2, now)
+	forged := rot
+	forged.NewSignature = nil
+	if _, err := l.Append(forged); !errors.Is(err, ErrSignature) {
+		t.Fatalf("rotation without the new key's signature: %v", err)
+	}
+	if _, err := l.Append(rot); err != nil {
+		t.Fatalf("Append rotate: %v", err)
+	}
+	if _, err := l.Prove(alice.PeerID()); !errors.Is(err, ErrRetired) {
+		t.Fatalf("Prove of a rotated key: %v", err)
+	}
+	again, _ := Publish(alice, now.Add(time.Second))
+	if _, err := l.Append(again); !errors.Is(err, ErrRetired) {
+		t.Fatalf("republishing a rotated key: %v", err)
+	}
+	b, err = l.Prove(alice2.PeerID())
+	if err != nil || b.Index != 1 {
+		t.Fatalf("Prove of the new key: %v, index %d", err, b.Index)
+	}
+
+	rev, _ := Revoke(alice2, now)
+	if _, err := l.Append(rev); err != nil {
+		t.Fatalf("Append revoke: %v", err)
+	}
+	if _, err := l.Prove(alice2.PeerID()); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("Prove of a revoked key: %v", err)
+	}
+	// The stale bundle still verifies on its own: a monitor or MaxAge is
+	// what catches the revocation.
+	v := &Verifier{LogKeys: []ed25519.PublicKey{l.PublicKey()}}
+	if err := v.CheckBundle(alice2.PeerID(), b, now); err != nil {
+		t.Fatalf("CheckBundle: %v", err)
+	}
+	if err := v.CheckBundle(alice2.PeerID(), b, now.Add(DefaultMaxAge+time.Minute)); !errors.Is(err, ErrStale) {
+		t.Fatalf("stale head: %v", err)
+	}
+	if err := v.CheckBundle(alice.PeerID(), b, now); !errors.Is(err, ErrEntry) {
+		t.Fatalf("bundle for another key: %v", err)
+	}
+
+	path, _ := l.Consistency(1, 3)
+	h := l.Head()
+	first := rootOf(l.leaves[:1])
+	if err := VerifyConsistency(1, h.Size, first, h.Root, path); err != nil {
+		t.Fatalf("VerifyConsistency: %v", err)
+	}
+}
+
+// startLog hosts l on a new peer and returns a client session to it.
+func startLog(ctx context.Context, t *testing.T, l *Log) *Client {
+	t.Helper()
+	caps := map[string]string{session.StreamProtocolCapability: "1"}
+	network := memory.NewNetwork()
+	kp, _ := identity.GenerateKeyPair()
+	p := i6p.NewPeer(kp, caps)
+	ln, err := network.Listen("log")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	p.Serve(ln)
+	go func() {
+		for {
+			s, err := p.Accept(ctx)
+			if err != nil {
+				return
+			}
+			go func() { _ = l.Serve(ctx, s) }()
+		}
+	}()
+	conn, err := network.Dial(ctx, "log")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ckp, _ := identity.GenerateKeyPair()
+	s, err := i6p.NewPeer(ckp, caps).Connect(ctx, conn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	return NewClient(s)
+}
+
+func TestHostedLog(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	logKP, _ := identity.GenerateKeyPair()
+	l := NewLog(logKP)
+	c := startLog(ctx, t, l)
+
+	bob, _ := identity.GenerateKeyPair()
+	e, _ := Publish(bob, time.Now())
+	if _, err := c.Append(ctx, e); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	b, err := c.Prove(ctx, bob.PeerID())
+	if err != nil {
+		t.Fatalf("Prove: %v", err)
+	}
+	caps := map[string]string{Capability: b.Capability()}
+
+	m := NewMonitor(c, l.PublicKey())
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	policy := (&Verifier{LogKeys: []ed25519.PublicKey{l.PublicKey()}, Monitor: m}).AcceptPolicy()
+	if err := policy(bob.PeerID(), caps); err != nil {
+		t.Fatalf("policy refused a logged key: %v", err)
+	}
+	mallory, _ := identity.GenerateKeyPair()
+	if err := policy(mallory.PeerID(), caps); !errors.Is(err, ErrEntry) {
+		t.Fatalf("policy accepted a substituted key: %v", err)
+	}
+	if err := policy(mallory.PeerID(), nil); !errors.Is(err, ErrNoBundle) {
+		t.Fatalf("policy accepted a peer without a bundle: %v", err)
+	}
+
+	// Once the monitor sees the revocation, the old bundle is refused.
+	rev, _ := Revoke(bob, time.Now())
+	if _, err := c.Append(ctx, rev); err != nil {
+		t.Fatalf("Append revoke: %v", err)
+	}
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := policy(bob.PeerID(), caps); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("policy accepted a revoked key: %v", err)
+	}
+	if m.Head().Size != 2 {
+		t.Fatalf("monitor head size = %d, want 2", m.Head().Size)
+	}
+}
+
+// forkedSource serves the entries of one log under the heads of another.
+type forkedSource struct {
+	heads   *Log
+	entries *Log
+}
+
+func (f forkedSource) Head(context.Context) (Head, error) { return f.heads.Head(), nil }
+func (f forkedSource) Entries(_ context.Context, from, to uint64) ([]Entry, error) {
+	return f.entries.Entries(from, to)
+}
+
+func TestMonitorDetectsFork(t *testing.T) {
+	logKP, _ := identity.GenerateKeyPair()
+	a, b := NewLog(logKP), NewLog(logKP)
+	for _, l := range []*Log{a, b} {
+		kp, _ := identity.GenerateKeyPair()
+		e, _ := Publish(kp, time.Now())
+		_, _ = l.Append(e)
+	}
+	m := NewMonitor(forkedSource{heads: a, entries: b}, logKP.PublicKey)
+	if err := m.Update(context.Background()); !errors.Is(err, ErrProof) {
+		t.Fatalf("Update of a forked log: %v", err)
+	}
+}
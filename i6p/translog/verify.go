@@ -0,0 +1,73 @@
+package translog
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p"
+	"github.com/TheusHen/I6P/i6p/identity"
+)
+
+// DefaultMaxAge is how old a presented tree head may be when
+// Verifier.MaxAge is zero.
+const DefaultMaxAge = 24 * time.Hour
+
+// Verifier checks that peers present their key's inclusion in a trusted
+// log. An inclusion proof alone cannot show that a key was not revoked
+// after the head it carries; MaxAge bounds that window, and a Monitor of
+// the log closes it for the keys it has seen revoked or rotated away.
+type Verifier struct {
+	// LogKeys are the keys of the trusted logs.
+	LogKeys []ed25519.PublicKey
+	// MaxAge bounds the age of a presented tree head (DefaultMaxAge if zero).
+	MaxAge time.Duration
+	// Monitor, if set, follows a trusted log; keys it does not report live
+	// are refused.
+	Monitor *Monitor
+}
+
+// Check verifies the Bundle in the HELLO capabilities of remote.
+func (v *Verifier) Check(remote identity.PeerID, capabilities map[string]string) error {
+	s, ok := capabilities[Capability]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoBundle, remote)
+	}
+	b, err := ParseBundle(s)
+	if err != nil {
+		return err
+	}
+	return v.CheckBundle(remote, b, time.Now())
+}
+
+// CheckBundle verifies that b vouches for the key of remote at now.
+func (v *Verifier) CheckBundle(remote identity.PeerID, b Bundle, now time.Time) error {
+	if id, ok := b.Entry.Subject(); !ok || id != remote {
+		return fmt.Errorf("%w: bundle is not about %s", ErrEntry, remote)
+	}
+	maxAge := v.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	if now.Sub(b.Head.Time) > maxAge {
+		return fmt.Errorf("%w: signed %s", ErrStale, b.Head.Time.Format(time.RFC3339))
+	}
+	i := slices.IndexFunc(v.LogKeys, func(k ed25519.PublicKey) bool { return b.Head.Verify(k) == nil })
+	if i < 0 {
+		return fmt.Errorf("%w: tree head of an untrusted log", ErrSignature)
+	}
+	if err := b.Verify(v.LogKeys[i]); err != nil {
+		return err
+	}
+	if v.Monitor != nil {
+		return v.Monitor.Status(remote)
+	}
+	return nil
+}
+
+// AcceptPolicy returns a policy that refuses handshakes from peers that do
+// not present a valid inclusion proof, for PeerConfig.AcceptPolicy.
+func (v *Verifier) AcceptPolicy() i6p.AcceptPolicy {
+	return v.Check
+}
@@ -0,0 +1,247 @@
+// Package memory is an in-process transport backend.
+//
+// It implements transport.Conn and transport.Listener with buffered in-memory
+// streams, so tests and peers in the same process can run the full session
+// handshake without opening UDP sockets. It provides no encryption of its own;
+// identities are still authenticated by the signed HELLO exchange.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/TheusHen/I6P/i6p/fault"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+var (
+	ErrAddrInUse     = errors.New("memory: address already in use")
+	ErrNoListener    = errors.New("memory: no listener at address")
+	ErrClosed        = errors.New("memory: connection closed")
+	ErrListenerClose = errors.New("memory: listener closed")
+)
+
+// maxPendingStreams bounds streams opened but not yet accepted, like a QUIC
+// stream limit.
+const maxPendingStreams = 100
+
+// Addr is an address on a Network.
+type Addr string
+
+func (a Addr) Network() string { return "memory" }
+func (a Addr) String() string  { return string(a) }
+
+// Network is a namespace of in-memory listeners. Tests should create their own
+// so they do not interfere with each other.
+type Network struct {
+	mu        sync.Mutex
+	listeners map[string]*Listener
+	nextAddr  int
+	faults    fault.Injector
+}
+
+// NewNetwork creates an empty network.
+func NewNetwork() *Network {
+	return &Network{listeners: make(map[string]*Listener), faults: fault.Nop}
+}
+
+// SetFaultInjector makes stream writes on connections dialed from now on
+// consult inj (at fault.StreamWrite), so they can be dropped, delayed or
+// corrupted. A nil inj disables injection.
+func (n *Network) SetFaultInjector(inj fault.Injector) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.faults = fault.Or(inj)
+}
+
+// Listen registers a listener at addr. An empty addr picks a unique one.
+func (n *Network) Listen(addr string) (*Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if addr == "" {
+		n.nextAddr++
+		addr = fmt.Sprintf("mem-%d", n.nextAddr)
+	}
+	if _, ok := n.listeners[addr]; ok {
+		return nil, ErrAddrInUse
+	}
+	l := &Listener{
+		net:     n,
+		addr:    Addr(addr),
+		backlog: make(chan *Conn, 16),
+		done:    make(chan struct{}),
+	}
+	n.listeners[addr] = l
+	return l, nil
+}
+
+// Dial connects to the listener at addr.
+func (n *Network) Dial(ctx context.Context, addr string) (transport.Conn, error) {
+	n.mu.Lock()
+	l, ok := n.listeners[addr]
+	n.nextAddr++
+	local := Addr(fmt.Sprintf("mem-%d", n.nextAddr))
+	faults := n.faults
+	n.mu.Unlock()
+	if !ok {
+		return nil, ErrNoListener
+	}
+
+	client, server := newConnPair(local, l.addr)
+	client.link.faults = faults
+	select {
+	case l.backlog <- server:
+		return client, nil
+	case <-l.done:
+		return nil, ErrNoListener
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Listener accepts in-memory connections.
+type Listener struct {
+	net       *Network
+	addr      Addr
+	backlog   chan *Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ transport.Listener = (*Listener)(nil)
+
+func (l *Listener) Accept(ctx context.Context) (transport.Conn, error) {
+	select {
+	case c := <-l.backlog:
+		return c, nil
+	case <-l.done:
+		return nil, ErrListenerClose
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *Listener) Addr() net.Addr { return l.addr }
+
+// Close unregisters the listener. Established connections are not affected.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		l.net.mu.Lock()
+		delete(l.net.listeners, string(l.addr))
+		l.net.mu.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+// link is the state shared by the two ends of a connection.
+type link struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	err    error
+	pipes  map[*pipe]struct{} // stream directions not yet closed by their writer
+	faults fault.Injector
+}
+
+func (k *link) close(err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.err != nil {
+		return
+	}
+	k.err = err
+	for p := range k.pipes {
+		p.abort(err)
+	}
+	k.pipes = nil
+	k.cancel(err)
+}
+
+// forget stops tracking a pipe whose writer has closed it; the reader drains
+// it to io.EOF without further help from the link.
+func (k *link) forget(p *pipe) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.pipes, p)
+}
+
+// Conn is one end of an in-memory connection.
+type Conn struct {
+	link          *link
+	local, remote Addr
+	incoming      chan *stream // streams opened by the peer
+	peer          *Conn
+}
+
+var _ transport.Conn = (*Conn)(nil)
+
+// Pipe returns the two ends of a connection between a and b without going
+// through a Network, like net.Pipe. Wrappers such as network simulators use it
+// to run their own address space.
+func Pipe(a, b Addr) (*Conn, *Conn) {
+	return newConnPair(a, b)
+}
+
+func newConnPair(clientAddr, serverAddr Addr) (*Conn, *Conn) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	k := &link{ctx: ctx, cancel: cancel, pipes: make(map[*pipe]struct{}), faults: fault.Nop}
+	c := &Conn{link: k, local: clientAddr, remote: serverAddr, incoming: make(chan *stream, maxPendingStreams)}
+	s := &Conn{link: k, local: serverAddr, remote: clientAddr, incoming: make(chan *stream, maxPendingStreams)}
+	c.peer, s.peer = s, c
+	return c, s
+}
+
+func (c *Conn) OpenStreamSync(ctx context.Context) (transport.Stream, error) {
+	c.link.mu.Lock()
+	if c.link.err != nil {
+		c.link.mu.Unlock()
+		return nil, c.link.err
+	}
+	local, remote := newStreamPair(c.link)
+	c.link.pipes[local.r] = struct{}{}
+	c.link.pipes[local.w] = struct{}{}
+	c.link.mu.Unlock()
+
+	select {
+	case c.peer.incoming <- remote:
+		return local, nil
+	case <-c.link.ctx.Done():
+		return nil, c.closeErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Conn) AcceptStream(ctx context.Context) (transport.Stream, error) {
+	select {
+	case s := <-c.incoming:
+		return s, nil
+	case <-c.link.ctx.Done():
+		return nil, c.closeErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Conn) closeErr() error {
+	c.link.mu.Lock()
+	defer c.link.mu.Unlock()
+	return c.link.err
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) Context() context.Context { return c.link.ctx }
+
+// CloseWithError closes both ends of the connection. Pending and future stream
+// operations fail with a *transport.CloseError wrapping ErrClosed; both ends
+// see Remote false.
+func (c *Conn) CloseWithError(code uint64, msg string) error {
+	c.link.close(&transport.CloseError{Code: code, Message: msg, Err: fmt.Errorf("%w (code %d: %s)", ErrClosed, code, msg)})
+	return nil
+}
@@ -0,0 +1,174 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/fault"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+func dialPair(t *testing.T) (client, server *Conn) {
+	t.Helper()
+	n := NewNetwork()
+	ln, err := n.Listen("")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := n.Dial(ctx, ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	s, err := ln.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	return c.(*Conn), s.(*Conn)
+}
+
+func TestStreamHalfClose(t *testing.T) {
+	client, server := dialPair(t)
+	ctx := context.Background()
+
+	cs, err := client.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+	if _, err := cs.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_ = cs.Close()
+
+	ss, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	got, err := io.ReadAll(ss)
+	if err != nil || string(got) != "ping" {
+		t.Fatalf("ReadAll = %q, %v", got, err)
+	}
+
+	// The client's read direction is still open after Close.
+	if _, err := ss.Write([]byte("pong")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_ = ss.Close()
+	got, err = io.ReadAll(cs)
+	if err != nil || string(got) != "pong" {
+		t.Fatalf("ReadAll = %q, %v", got, err)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	client, server := dialPair(t)
+	ctx := context.Background()
+	cs, _ := client.OpenStreamSync(ctx)
+	_, _ = cs.Write([]byte("discarded"))
+	ss, _ := server.AcceptStream(ctx)
+
+	// A reset discards unread data and reaches the peer's reads.
+	cs.CancelWrite(transport.StreamCancelled)
+	var se *transport.StreamError
+	if _, err := ss.Read(make([]byte, 16)); !errors.As(err, &se) || se.Code != transport.StreamCancelled || !se.Remote {
+		t.Fatalf("Read after peer reset: %v", err)
+	}
+	if _, err := cs.Write([]byte("x")); !errors.As(err, &se) || se.Remote {
+		t.Fatalf("Write after local reset: %v", err)
+	}
+
+	// The other direction is unaffected until it is cancelled too.
+	if _, err := ss.Write([]byte("pong")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	cs.CancelRead(transport.StreamRefused)
+	if _, err := ss.Write([]byte("x")); !errors.As(err, &se) || se.Code != transport.StreamRefused || !se.Remote {
+		t.Fatalf("Write after peer stopped reading: %v", err)
+	}
+	if _, err := cs.Read(make([]byte, 4)); !errors.As(err, &se) || se.Remote {
+		t.Fatalf("Read after local cancel: %v", err)
+	}
+}
+
+func TestStreamDeadline(t *testing.T) {
+	client, _ := dialPair(t)
+	st, err := client.OpenStreamSync(context.Background())
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+	_ = st.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := st.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+}
+
+func TestCloseAbortsStreams(t *testing.T) {
+	client, server := dialPair(t)
+	ctx := context.Background()
+	st, err := client.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := st.Read(make([]byte, 1))
+		errCh <- err
+	}()
+	_ = server.CloseWithError(7, "bye")
+
+	if err := <-errCh; !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if _, err := client.AcceptStream(ctx); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	select {
+	case <-client.Context().Done():
+	default:
+		t.Fatalf("client context not cancelled")
+	}
+}
+
+func TestDialUnknownAddr(t *testing.T) {
+	if _, err := NewNetwork().Dial(context.Background(), "nowhere"); err != ErrNoListener {
+		t.Fatalf("expected ErrNoListener, got %v", err)
+	}
+}
+
+func TestFaultInjectorCorruptsWrites(t *testing.T) {
+	n := NewNetwork()
+	n.SetFaultInjector(fault.NewRandom(1, fault.Config{CorruptRate: 1}))
+	ln, _ := n.Listen("")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := n.Dial(ctx, ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	s, _ := ln.Accept(ctx)
+
+	cs, _ := c.OpenStreamSync(ctx)
+	msg := []byte("hello")
+	if _, err := cs.Write(msg); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_ = cs.Close()
+	ss, _ := s.AcceptStream(ctx)
+	got, _ := io.ReadAll(ss)
+	if len(got) != len(msg) || string(got) == string(msg) {
+		t.Fatalf("expected a corrupted copy of %q, got %q", msg, got)
+	}
+	if string(msg) != "hello" {
+		t.Fatalf("caller's buffer was modified")
+	}
+}
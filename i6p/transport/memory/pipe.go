@@ -0,0 +1,219 @@
+package memory
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/fault"
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+// maxBuffered bounds the bytes queued in one stream direction before Write
+// blocks, standing in for QUIC flow control.
+const maxBuffered = 1 << 20
+
+// pipe is one direction of a stream: a bounded buffer with close and deadline
+// handling.
+type pipe struct {
+	mu            sync.Mutex
+	buf           bytes.Buffer
+	writeClosed   bool  // writer sent FIN; reader sees io.EOF once drained
+	err           error // connection-level abort, returned to both sides
+	cancelled     bool  // either side cancelled the pipe with code
+	code          transport.StreamErrorCode
+	byReader      bool // the reader cancelled it, rather than the writer
+	readDeadline  time.Time
+	writeDeadline time.Time
+	wake          chan struct{} // closed and replaced on any state change
+}
+
+func newPipe() *pipe {
+	return &pipe{wake: make(chan struct{})}
+}
+
+func (p *pipe) signalLocked() {
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
+
+// wait blocks until the pipe changes or deadline passes. Called with p.mu held;
+// returns with it held.
+func (p *pipe) wait(deadline time.Time) {
+	wake := p.wake
+	p.mu.Unlock()
+	if deadline.IsZero() {
+		<-wake
+	} else {
+		t := time.NewTimer(time.Until(deadline))
+		select {
+		case <-wake:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+	p.mu.Lock()
+}
+
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if p.err != nil {
+			return 0, p.err
+		}
+		if p.cancelled {
+			return 0, &transport.StreamError{Code: p.code, Remote: !p.byReader}
+		}
+		if p.buf.Len() > 0 {
+			n, _ := p.buf.Read(b)
+			p.signalLocked()
+			return n, nil
+		}
+		if p.writeClosed {
+			return 0, io.EOF
+		}
+		if expired(p.readDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		p.wait(p.readDeadline)
+	}
+}
+
+func (p *pipe) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	written := 0
+	for written < len(b) {
+		if p.err != nil {
+			return written, p.err
+		}
+		if p.cancelled {
+			return written, &transport.StreamError{Code: p.code, Remote: p.byReader}
+		}
+		if p.writeClosed {
+			return written, io.ErrClosedPipe
+		}
+		if expired(p.writeDeadline) {
+			return written, os.ErrDeadlineExceeded
+		}
+		if room := maxBuffered - p.buf.Len(); room > 0 {
+			n := min(room, len(b)-written)
+			p.buf.Write(b[written : written+n])
+			written += n
+			p.signalLocked()
+			continue
+		}
+		p.wait(p.writeDeadline)
+	}
+	return written, nil
+}
+
+func (p *pipe) closeWrite() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writeClosed = true
+	p.signalLocked()
+}
+
+// cancel discards the buffered data and fails later reads and writes with a
+// StreamError for code. Only the first cancellation counts.
+func (p *pipe) cancel(code transport.StreamErrorCode, byReader bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancelled || (!byReader && p.writeClosed && p.buf.Len() == 0) {
+		return
+	}
+	p.cancelled, p.code, p.byReader = true, code, byReader
+	p.buf.Reset()
+	p.signalLocked()
+}
+
+func (p *pipe) abort(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+		p.signalLocked()
+	}
+}
+
+func (p *pipe) setReadDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readDeadline = t
+	p.signalLocked()
+}
+
+func (p *pipe) setWriteDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writeDeadline = t
+	p.signalLocked()
+}
+
+// stream is one end of a bidirectional in-memory stream.
+type stream struct {
+	link *link
+	r    *pipe // peer -> us
+	w    *pipe // us -> peer
+}
+
+func newStreamPair(k *link) (*stream, *stream) {
+	ab, ba := newPipe(), newPipe()
+	return &stream{link: k, r: ba, w: ab}, &stream{link: k, r: ab, w: ba}
+}
+
+func (s *stream) Read(b []byte) (int, error) { return s.r.read(b) }
+
+// Write consults the link's fault injector before queueing b.
+func (s *stream) Write(b []byte) (int, error) {
+	inj := s.link.faults
+	if d := inj.Delay(fault.StreamWrite); d > 0 {
+		time.Sleep(d)
+	}
+	if inj.Drop(fault.StreamWrite) {
+		return len(b), nil
+	}
+	return s.w.write(inj.Corrupt(fault.StreamWrite, b))
+}
+
+// Close ends the write direction, like closing a QUIC stream.
+func (s *stream) Close() error {
+	s.w.closeWrite()
+	s.link.forget(s.w)
+	return nil
+}
+
+// CancelRead makes the peer's writes fail, like QUIC STOP_SENDING.
+func (s *stream) CancelRead(code transport.StreamErrorCode) {
+	s.r.cancel(code, true)
+}
+
+// CancelWrite makes the peer's reads fail, like a QUIC RESET_STREAM.
+func (s *stream) CancelWrite(code transport.StreamErrorCode) {
+	s.w.cancel(code, false)
+	s.link.forget(s.w)
+}
+
+func (s *stream) SetDeadline(t time.Time) error {
+	s.r.setReadDeadline(t)
+	s.w.setWriteDeadline(t)
+	return nil
+}
+
+func (s *stream) SetReadDeadline(t time.Time) error {
+	s.r.setReadDeadline(t)
+	return nil
+}
+
+func (s *stream) SetWriteDeadline(t time.Time) error {
+	s.w.setWriteDeadline(t)
+	return nil
+}
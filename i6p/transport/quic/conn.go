@@ -0,0 +1,88 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+	q "github.com/quic-go/quic-go"
+)
+
+// Conn adapts a quic-go connection to transport.Conn.
+type Conn struct {
+	conn *q.Conn
+
+	ctxOnce sync.Once
+	ctx     context.Context
+}
+
+var (
+	_ transport.Conn      = (*Conn)(nil)
+	_ transport.Exporter  = (*Conn)(nil)
+	_ transport.EarlyConn = (*Conn)(nil)
+)
+
+// NewConn wraps an established quic-go connection, e.g. one dialed with a
+// custom quic-go Transport.
+func NewConn(c *q.Conn) *Conn { return &Conn{conn: c} }
+
+// QUIC returns the underlying quic-go connection.
+func (c *Conn) QUIC() *q.Conn { return c.conn }
+
+func (c *Conn) OpenStreamSync(ctx context.Context) (transport.Stream, error) {
+	st, err := c.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{st}, nil
+}
+
+func (c *Conn) AcceptStream(ctx context.Context) (transport.Stream, error) {
+	st, err := c.conn.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{st}, nil
+}
+
+func (c *Conn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// Context is cancelled when the connection closes. After CloseWithError by
+// either side its cause is a *transport.CloseError wrapping the quic-go
+// *ApplicationError.
+func (c *Conn) Context() context.Context {
+	c.ctxOnce.Do(func() {
+		parent := c.conn.Context()
+		ctx, cancel := context.WithCancelCause(context.WithoutCancel(parent))
+		context.AfterFunc(parent, func() { cancel(closeError(context.Cause(parent))) })
+		c.ctx = ctx
+	})
+	return c.ctx
+}
+
+func closeError(err error) error {
+	var ae *q.ApplicationError
+	if errors.As(err, &ae) {
+		return &transport.CloseError{Code: uint64(ae.ErrorCode), Message: ae.ErrorMessage, Remote: ae.Remote, Err: err}
+	}
+	return err
+}
+
+func (c *Conn) CloseWithError(code uint64, msg string) error {
+	return c.conn.CloseWithError(q.ApplicationErrorCode(code), msg)
+}
+
+// HandshakeComplete is closed once the TLS handshake completes; until then a
+// connection dialed with 0-RTT sends early data.
+func (c *Conn) HandshakeComplete() <-chan struct{} { return c.conn.HandshakeComplete() }
+
+// ExportKeyingMaterial exports keying material from the connection's TLS
+// session.
+func (c *Conn) ExportKeyingMaterial(label string, exportContext []byte, length int) ([]byte, error) {
+	cs := c.conn.ConnectionState().TLS
+	return cs.ExportKeyingMaterial(label, exportContext, length)
+}
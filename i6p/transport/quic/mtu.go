@@ -0,0 +1,56 @@
+package quic
+
+import (
+	"errors"
+	"net"
+)
+
+var ErrNoRouteInterface = errors.New("quic: no interface for route")
+
+// JumboMTU is the smallest link MTU counted as a jumbo-frame path, as in
+// transfer.JumboPathMTU.
+const JumboMTU = 9000
+
+// PathMTUStatus describes the first hop towards a remote address.
+type PathMTUStatus struct {
+	Interface string // name of the outgoing interface
+	MTU       int    // link MTU of that interface
+	Jumbo     bool   // MTU >= JumboMTU
+}
+
+// ProbePathMTU reports the MTU of the interface the kernel routes addr
+// through. No packet is sent: the route is looked up by connecting a UDP
+// socket. Links further along the path may have a smaller MTU, so peers should
+// exchange the result (see transfer.PathMTUCapability) and use the minimum.
+//
+// quic-go keeps QUIC packets at or below 1452 bytes whatever the link allows;
+// jumbo paths still pay off through larger transfer chunks and GSO.
+func ProbePathMTU(addr string) (PathMTUStatus, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return PathMTUStatus{}, err
+	}
+	c, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return PathMTUStatus{}, err
+	}
+	local := c.LocalAddr().(*net.UDPAddr).IP
+	_ = c.Close()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return PathMTUStatus{}, err
+	}
+	for _, ifc := range ifaces {
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(local) {
+				return PathMTUStatus{Interface: ifc.Name, MTU: ifc.MTU, Jumbo: ifc.MTU >= JumboMTU}, nil
+			}
+		}
+	}
+	return PathMTUStatus{}, ErrNoRouteInterface
+}
@@ -0,0 +1,16 @@
+package quic
+
+import "testing"
+
+func TestProbePathMTULoopback(t *testing.T) {
+	st, err := ProbePathMTU("127.0.0.1:9")
+	if err != nil {
+		t.Skipf("no loopback route: %v", err)
+	}
+	if st.Interface == "" || st.MTU <= 0 {
+		t.Fatalf("status %+v", st)
+	}
+	if st.Jumbo != (st.MTU >= JumboMTU) {
+		t.Fatalf("Jumbo %v for MTU %d", st.Jumbo, st.MTU)
+	}
+}
@@ -0,0 +1,198 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+	q "github.com/quic-go/quic-go"
+)
+
+var ErrInvalidDSCP = errors.New("quic: DSCP value out of range (0-63)")
+
+// Common DSCP code points for Options.DSCP.
+const (
+	DSCPDefault     uint8 = 0  // best effort
+	DSCPLowerEffort uint8 = 1  // LE, scavenger class for bulk transfers (RFC 8622)
+	DSCPCS1         uint8 = 8  // legacy scavenger class
+	DSCPAF41        uint8 = 34 // assured forwarding, interactive traffic
+	DSCPEF          uint8 = 46 // expedited forwarding, latency-sensitive control traffic
+)
+
+// Options tunes the UDP socket underneath a QUIC listener or dialer.
+// The zero value keeps quic-go's defaults and dials directly.
+type Options struct {
+	// ReceiveBufferSize and SendBufferSize set SO_RCVBUF / SO_SNDBUF in bytes.
+	// 0 leaves quic-go's default, which tries to raise both to about 7 MiB.
+	// High-bandwidth links usually need 16 MiB or more; on Linux the
+	// net.core.rmem_max / wmem_max sysctls cap what an unprivileged process gets.
+	ReceiveBufferSize int
+	SendBufferSize    int
+
+	// BufferSizeHook, if set, is called for each buffer the kernel granted
+	// less of than requested, since a capped buffer silently limits
+	// throughput. send reports whether it is the send buffer.
+	BufferSizeHook func(send bool, requested, granted int)
+
+	// DisableGSO turns off UDP generic segmentation offload for this socket.
+	// GSO is used automatically where the kernel supports it (Linux 5.0+).
+	// quic-go decides GSO and ECN together from the socket it is handed, so
+	// disabling either one disables both.
+	DisableGSO bool
+
+	// DSCP sets the differentiated services code point (the upper six bits of
+	// the IPv6 traffic class / IPv4 TOS byte) on every outgoing packet, so
+	// managed networks can prioritise or deprioritise I6P traffic.
+	// quic-go writes the traffic class per packet when it marks ECN, which would
+	// clear the DSCP bits, so a non-zero DSCP also disables ECN.
+	DSCP uint8
+
+	// DisableECN turns off explicit congestion notification for this socket.
+	// ECN is otherwise negotiated automatically where the platform supports it
+	// and lets the congestion controller react to marks instead of losses. As
+	// with DisableGSO, it also disables GSO.
+	DisableECN bool
+
+	// Proxy selects a SOCKS5 proxy for each dialed address, or returns nil to
+	// dial directly; use FixedProxy for a single proxy. This allows per-peer
+	// overrides, like net/http's Transport.Proxy. Listeners ignore it.
+	Proxy func(addr string) (*Proxy, error)
+}
+
+func (o Options) isZero() bool {
+	return o.ReceiveBufferSize == 0 && o.SendBufferSize == 0 && !o.DisableGSO &&
+		o.DSCP == 0 && !o.DisableECN && o.Proxy == nil
+}
+
+// listenUDP opens a UDP socket and applies the buffer options. Sizes the kernel
+// refused are reported to BufferSizeHook.
+func (o Options) listenUDP(addr string) (*net.UDPConn, error) {
+	if o.DSCP > 63 {
+		return nil, ErrInvalidDSCP
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	if o.ReceiveBufferSize > 0 {
+		if err := conn.SetReadBuffer(o.ReceiveBufferSize); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		if got, ok := socketBufferSize(conn, false); ok && got < o.ReceiveBufferSize && o.BufferSizeHook != nil {
+			o.BufferSizeHook(false, o.ReceiveBufferSize, got)
+		}
+	}
+	if o.SendBufferSize > 0 {
+		if err := conn.SetWriteBuffer(o.SendBufferSize); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		if got, ok := socketBufferSize(conn, true); ok && got < o.SendBufferSize && o.BufferSizeHook != nil {
+			o.BufferSizeHook(true, o.SendBufferSize, got)
+		}
+	}
+	if o.DSCP != 0 {
+		if err := setTrafficClass(conn, int(o.DSCP)<<2); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// packetConn returns the socket to hand quic-go. quic-go only uses GSO and
+// ECN on sockets that can carry control messages, so when either is disabled
+// (or a DSCP is set, whose bits ECN marking would overwrite) the socket is
+// hidden behind plainUDPConn, which leaves path MTU discovery working.
+func (o Options) packetConn(udp *net.UDPConn) net.PacketConn {
+	if o.DisableGSO || o.DisableECN || o.DSCP != 0 {
+		return plainUDPConn{PacketConn: udp, udp: udp}
+	}
+	return udp
+}
+
+// plainUDPConn exposes a UDP socket without ReadMsgUDP and WriteMsgUDP, but
+// with the buffer and raw-socket methods quic-go uses to size its buffers and
+// set the don't-fragment bit.
+type plainUDPConn struct {
+	net.PacketConn
+	udp *net.UDPConn
+}
+
+func (c plainUDPConn) SyscallConn() (syscall.RawConn, error) { return c.udp.SyscallConn() }
+func (c plainUDPConn) SetReadBuffer(n int) error             { return c.udp.SetReadBuffer(n) }
+func (c plainUDPConn) SetWriteBuffer(n int) error            { return c.udp.SetWriteBuffer(n) }
+
+// ListenWithOptions is Listen with socket tuning.
+func ListenWithOptions(addr string, opts Options) (*Listener, error) {
+	if opts.isZero() {
+		return Listen(addr)
+	}
+	tlsConf, err := NewServerTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := opts.listenUDP(addr)
+	if err != nil {
+		return nil, err
+	}
+	tr := &q.Transport{Conn: opts.packetConn(conn)}
+	ln, err := tr.Listen(tlsConf, &q.Config{})
+	if err != nil {
+		_ = tr.Close()
+		_ = conn.Close()
+		return nil, err
+	}
+	return &Listener{inner: ln, tr: tr, conn: conn}, nil
+}
+
+// DialWithOptions is Dial with socket tuning. The local socket is closed when
+// the returned connection ends.
+func DialWithOptions(ctx context.Context, addr string, opts Options) (transport.Conn, error) {
+	if opts.isZero() {
+		return Dial(ctx, addr)
+	}
+	tlsConf, err := NewClientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := opts.proxyFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	udp, err := opts.listenUDP(":0")
+	if err != nil {
+		return nil, err
+	}
+	conn := opts.packetConn(udp)
+	if proxy != nil {
+		if conn, err = proxy.associate(ctx, udp); err != nil {
+			_ = udp.Close()
+			return nil, err
+		}
+	}
+	tr := &q.Transport{Conn: conn}
+	c, err := tr.Dial(ctx, raddr, tlsConf, &q.Config{})
+	if err != nil {
+		_ = tr.Close()
+		_ = conn.Close()
+		return nil, err
+	}
+	go func() {
+		<-c.Context().Done()
+		_ = tr.Close()
+		_ = conn.Close()
+	}()
+	return NewConn(c), nil
+}
@@ -0,0 +1,58 @@
+package quic
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestOptionsApplyPerSocket(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var capped []bool
+	ln, err := ListenWithOptions("[::1]:0", Options{
+		ReceiveBufferSize: 1 << 30,
+		BufferSizeHook:    func(send bool, requested, granted int) { capped = append(capped, send) },
+		DisableGSO:        true,
+		DisableECN:        true,
+	})
+	if err != nil {
+		t.Fatalf("ListenWithOptions: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+	// Buffer sizes are only read back on unix platforms.
+	if runtime.GOOS != "windows" && (len(capped) != 1 || capped[0]) {
+		t.Fatalf("BufferSizeHook calls %v, want one for the receive buffer", capped)
+	}
+	for _, env := range []string{"QUIC_GO_DISABLE_GSO", "QUIC_GO_DISABLE_ECN"} {
+		if v, ok := os.LookupEnv(env); ok {
+			t.Fatalf("%s=%q set process-wide", env, v)
+		}
+	}
+
+	client, err := Dial(ctx, ln.AddrString())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer func() { _ = client.CloseWithError(0, "") }()
+	server, err := ln.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	cs, err := client.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+	_, _ = cs.Write([]byte("hi"))
+	ss, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	if _, err := io.ReadFull(ss, make([]byte, 2)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+}
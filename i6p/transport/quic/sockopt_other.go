@@ -0,0 +1,20 @@
+//go:build !unix
+
+package quic
+
+import (
+	"errors"
+	"net"
+)
+
+var errTrafficClassUnsupported = errors.New("quic: DSCP marking is not supported on this platform")
+
+// socketBufferSize is not implemented on this platform, so BufferSizeHook is
+// never called.
+func socketBufferSize(conn *net.UDPConn, send bool) (int, bool) {
+	return 0, false
+}
+
+func setTrafficClass(conn *net.UDPConn, tclass int) error {
+	return errTrafficClassUnsupported
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package quic
+
+import (
+	"net"
+	"runtime"
+	"syscall"
+)
+
+// socketBufferSize reads back the effective SO_RCVBUF or SO_SNDBUF.
+func socketBufferSize(conn *net.UDPConn, send bool) (int, bool) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	opt := syscall.SO_RCVBUF
+	if send {
+		opt = syscall.SO_SNDBUF
+	}
+	var size int
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		size, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt)
+	}); err != nil || serr != nil {
+		return 0, false
+	}
+	if runtime.GOOS == "linux" {
+		// Linux reports twice the requested size to account for bookkeeping.
+		size /= 2
+	}
+	return size, true
+}
+
+// setTrafficClass sets the IPv6 traffic class and, for dual-stack or IPv4
+// sockets, the IPv4 TOS byte.
+func setTrafficClass(conn *net.UDPConn, tclass int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var err6, err4 error
+	if err := raw.Control(func(fd uintptr) {
+		err6 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tclass)
+		err4 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tclass)
+	}); err != nil {
+		return err
+	}
+	// One of the two fails on single-stack sockets; only both failing is an error.
+	if err6 != nil && err4 != nil {
+		return err6
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package quic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrProxyAuth        = errors.New("quic: proxy authentication failed")
+	ErrProxyUnsupported = errors.New("quic: unsupported proxy scheme")
+	ErrProxyProtocol    = errors.New("quic: malformed proxy response")
+)
+
+// Proxy is a SOCKS5 proxy that supports UDP ASSOCIATE (RFC 1928). QUIC packets
+// are relayed through it, so peers on networks without direct IPv6 egress can
+// still dial out.
+type Proxy struct {
+	Addr     string // host:port of the proxy's TCP control endpoint
+	Username string // optional RFC 1929 credentials
+	Password string
+}
+
+// ParseProxyURL parses "socks5://[user:pass@]host:port".
+func ParseProxyURL(s string) (*Proxy, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" {
+		return nil, ErrProxyUnsupported
+	}
+	p := &Proxy{Addr: u.Host}
+	if u.User != nil {
+		p.Username = u.User.Username()
+		p.Password, _ = u.User.Password()
+	}
+	return p, nil
+}
+
+// FixedProxy returns an Options.Proxy function that sends every dial through p.
+func FixedProxy(p *Proxy) func(addr string) (*Proxy, error) {
+	return func(string) (*Proxy, error) { return p, nil }
+}
+
+const (
+	socksVersion      = 5
+	socksAuthNone     = 0x00
+	socksAuthPassword = 0x02
+	socksAuthNoAccept = 0xff
+	socksCmdUDP       = 0x03
+	socksAtypIPv4     = 0x01
+	socksAtypDomain   = 0x03
+	socksAtypIPv6     = 0x04
+)
+
+// associate performs the SOCKS5 handshake and UDP ASSOCIATE on a new control
+// connection, then relays datagrams for udp through the proxy.
+func (p *Proxy) associate(ctx context.Context, udp *net.UDPConn) (*socksPacketConn, error) {
+	var d net.Dialer
+	ctrl, err := d.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = ctrl.SetDeadline(deadline)
+	}
+	relay, err := p.handshake(ctrl)
+	if err != nil {
+		_ = ctrl.Close()
+		return nil, err
+	}
+	_ = ctrl.SetDeadline(time.Time{})
+	if relay.IP.IsUnspecified() {
+		// The relay listens on the proxy's own address.
+		relay.IP = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+
+	pc := &socksPacketConn{udp: udp, ctrl: ctrl, relay: relay}
+	go func() {
+		// The association lasts as long as the control connection.
+		_, _ = io.Copy(io.Discard, ctrl)
+		_ = pc.Close()
+	}()
+	return pc, nil
+}
+
+func (p *Proxy) handshake(rw io.ReadWriter) (*net.UDPAddr, error) {
+	methods := []byte{socksAuthNone}
+	if p.Username != "" {
+		methods = []byte{socksAuthPassword}
+	}
+	if _, err := rw.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return nil, err
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(rw, resp[:]); err != nil {
+		return nil, err
+	}
+	if resp[0] != socksVersion {
+		return nil, ErrProxyProtocol
+	}
+	switch resp[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if len(p.Username) > 255 || len(p.Password) > 255 {
+			return nil, ErrProxyAuth
+		}
+		req := []byte{1, byte(len(p.Username))}
+		req = append(req, p.Username...)
+		req = append(req, byte(len(p.Password)))
+		req = append(req, p.Password...)
+		if _, err := rw.Write(req); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(rw, resp[:]); err != nil {
+			return nil, err
+		}
+		if resp[1] != 0 {
+			return nil, ErrProxyAuth
+		}
+	default:
+		return nil, ErrProxyAuth
+	}
+
+	// UDP ASSOCIATE with an unspecified client address: the proxy accepts
+	// datagrams from whichever port we send from.
+	req := []byte{socksVersion, socksCmdUDP, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0}
+	if _, err := rw.Write(req); err != nil {
+		return nil, err
+	}
+	var hdr [3]byte
+	if _, err := io.ReadFull(rw, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != socksVersion {
+		return nil, ErrProxyProtocol
+	}
+	if hdr[1] != 0 {
+		return nil, fmt.Errorf("quic: proxy refused UDP ASSOCIATE (reply %d)", hdr[1])
+	}
+	host, port, err := readSocksAddr(rw)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+		if err != nil || len(addrs) == 0 {
+			return nil, ErrProxyProtocol
+		}
+		ip = addrs[0].IP
+	}
+	return &net.UDPAddr{IP: ip, Port: port}, nil
+}
+
+func readSocksAddr(r io.Reader) (host string, port int, err error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", 0, err
+	}
+	var addr []byte
+	switch atyp[0] {
+	case socksAtypIPv4:
+		addr = make([]byte, 4)
+	case socksAtypIPv6:
+		addr = make([]byte, 16)
+	case socksAtypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return "", 0, err
+		}
+		addr = make([]byte, l[0])
+	default:
+		return "", 0, ErrProxyProtocol
+	}
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return "", 0, err
+	}
+	var pb [2]byte
+	if _, err := io.ReadFull(r, pb[:]); err != nil {
+		return "", 0, err
+	}
+	if atyp[0] == socksAtypDomain {
+		host = string(addr)
+	} else {
+		host = net.IP(addr).String()
+	}
+	return host, int(binary.BigEndian.Uint16(pb[:])), nil
+}
+
+func appendSocksUDPHeader(b []byte, addr *net.UDPAddr) []byte {
+	b = append(b, 0, 0, 0) // RSV, FRAG
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		b = append(b, socksAtypIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, socksAtypIPv6)
+		b = append(b, addr.IP.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+}
+
+// socksPacketConn is a net.PacketConn whose datagrams travel through a SOCKS5
+// UDP relay. It deliberately does not expose the *net.UDPConn methods quic-go
+// probes for (ReadMsgUDP, SyscallConn), which would bypass the encapsulation.
+type socksPacketConn struct {
+	udp   *net.UDPConn
+	ctrl  net.Conn
+	relay *net.UDPAddr
+	rbuf  []byte // only used by the single quic-go read loop
+}
+
+func (c *socksPacketConn) LocalAddr() net.Addr                { return c.udp.LocalAddr() }
+func (c *socksPacketConn) SetDeadline(t time.Time) error      { return c.udp.SetDeadline(t) }
+func (c *socksPacketConn) SetReadDeadline(t time.Time) error  { return c.udp.SetReadDeadline(t) }
+func (c *socksPacketConn) SetWriteDeadline(t time.Time) error { return c.udp.SetWriteDeadline(t) }
+
+func (c *socksPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("quic: unsupported address type %T", addr)
+	}
+	pkt := appendSocksUDPHeader(make([]byte, 0, 22+len(b)), ua)
+	pkt = append(pkt, b...)
+	if _, err := c.udp.WriteTo(pkt, c.relay); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *socksPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	// Room for the largest header: RSV, FRAG, ATYP, 255-byte domain, port.
+	if len(c.rbuf) < len(b)+262 {
+		c.rbuf = make([]byte, len(b)+262)
+	}
+	buf := c.rbuf
+	for {
+		n, from, err := c.udp.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		ua, ok := from.(*net.UDPAddr)
+		if !ok || ua.Port != c.relay.Port || !ua.IP.Equal(c.relay.IP) {
+			continue // not from our relay
+		}
+		pkt := buf[:n]
+		if len(pkt) < 4 || pkt[2] != 0 {
+			continue // malformed or fragmented; fragments are not supported
+		}
+		r := &sliceReader{b: pkt[3:]}
+		host, port, err := readSocksAddr(r)
+		if err != nil {
+			continue
+		}
+		src := &net.UDPAddr{IP: net.ParseIP(host), Port: port}
+		return copy(b, r.b), src, nil
+	}
+}
+
+func (c *socksPacketConn) Close() error {
+	err := c.udp.Close()
+	_ = c.ctrl.Close()
+	return err
+}
+
+// sliceReader is a minimal io.Reader over a byte slice that exposes the
+// unread remainder.
+type sliceReader struct{ b []byte }
+
+func (r *sliceReader) Read(p []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
+
+// proxyFor resolves the proxy to use for addr, if any.
+func (o Options) proxyFor(addr string) (*Proxy, error) {
+	if o.Proxy == nil {
+		return nil, nil
+	}
+	return o.Proxy(addr)
+}
@@ -0,0 +1,167 @@
+package quic
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// socksServer is a minimal SOCKS5 proxy supporting UDP ASSOCIATE with
+// optional username/password authentication.
+type socksServer struct {
+	ln         net.Listener
+	user, pass string
+}
+
+func newSocksServer(t *testing.T, user, pass string) *socksServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &socksServer{ln: ln, user: user, pass: pass}
+	go s.serve()
+	t.Cleanup(func() { _ = ln.Close() })
+	return s
+}
+
+func (s *socksServer) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *socksServer) handle(c net.Conn) {
+	defer c.Close()
+	buf := make([]byte, 512)
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+		return
+	}
+	if s.user == "" {
+		_, _ = c.Write([]byte{5, socksAuthNone})
+	} else {
+		_, _ = c.Write([]byte{5, socksAuthPassword})
+		if _, err := io.ReadFull(c, buf[:2]); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		_, _ = io.ReadFull(c, user)
+		_, _ = io.ReadFull(c, buf[:1])
+		pass := make([]byte, buf[0])
+		_, _ = io.ReadFull(c, pass)
+		if string(user) != s.user || string(pass) != s.pass {
+			_, _ = c.Write([]byte{1, 1})
+			return
+		}
+		_, _ = c.Write([]byte{1, 0})
+	}
+	if _, err := io.ReadFull(c, buf[:3]); err != nil {
+		return
+	}
+	if _, _, err := readSocksAddr(c); err != nil {
+		return
+	}
+
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		return
+	}
+	defer relay.Close()
+	// The reply carries BND.ADDR in the same encoding as the UDP header.
+	bound := appendSocksUDPHeader(nil, relay.LocalAddr().(*net.UDPAddr))[3:]
+	_, _ = c.Write(append([]byte{5, 0, 0}, bound...))
+
+	go func() {
+		var client *net.UDPAddr
+		pkt := make([]byte, 65535)
+		for {
+			n, from, err := relay.ReadFromUDP(pkt)
+			if err != nil {
+				return
+			}
+			if client == nil || (from.Port == client.Port && from.IP.Equal(client.IP)) {
+				client = from
+				r := &sliceReader{b: pkt[3:n]}
+				host, port, err := readSocksAddr(r)
+				if err != nil {
+					continue
+				}
+				_, _ = relay.WriteToUDP(r.b, &net.UDPAddr{IP: net.ParseIP(host), Port: port})
+				continue
+			}
+			out := appendSocksUDPHeader(nil, from)
+			_, _ = relay.WriteToUDP(append(out, pkt[:n]...), client)
+		}
+	}()
+	_, _ = io.Copy(io.Discard, c)
+}
+
+func TestDialThroughSOCKS5(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ln, err := Listen("[::1]:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+	go func() {
+		c, err := ln.Accept(ctx)
+		if err != nil {
+			return
+		}
+		st, err := c.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(st, st)
+		_ = st.Close()
+	}()
+
+	proxy := newSocksServer(t, "alice", "secret")
+	p, err := ParseProxyURL("socks5://alice:secret@" + proxy.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("ParseProxyURL: %v", err)
+	}
+	conn, err := DialWithOptions(ctx, ln.AddrString(), Options{Proxy: FixedProxy(p)})
+	if err != nil {
+		t.Fatalf("DialWithOptions: %v", err)
+	}
+	defer func() {
+		_ = conn.CloseWithError(0, "")
+	}()
+
+	st, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+	_, _ = st.Write([]byte("via proxy"))
+	_ = st.Close()
+	got, err := io.ReadAll(st)
+	if err != nil || string(got) != "via proxy" {
+		t.Fatalf("echo = %q, %v", got, err)
+	}
+}
+
+func TestSOCKS5AuthFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	proxy := newSocksServer(t, "alice", "secret")
+	p := &Proxy{Addr: proxy.ln.Addr().String(), Username: "alice", Password: "wrong"}
+	_, err := DialWithOptions(ctx, "[::1]:1", Options{Proxy: FixedProxy(p)})
+	if err != ErrProxyAuth {
+		t.Fatalf("expected ErrProxyAuth, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package quic
+
+import (
+	"errors"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+	q "github.com/quic-go/quic-go"
+)
+
+// Stream adapts a quic-go stream to transport.Stream, reporting resets as
+// *transport.StreamError.
+type Stream struct {
+	*q.Stream
+}
+
+var _ transport.Stream = (*Stream)(nil)
+
+// QUIC returns the underlying quic-go stream.
+func (s *Stream) QUIC() *q.Stream { return s.Stream }
+
+func (s *Stream) Read(b []byte) (int, error) {
+	n, err := s.Stream.Read(b)
+	return n, streamError(err)
+}
+
+func (s *Stream) Write(b []byte) (int, error) {
+	n, err := s.Stream.Write(b)
+	return n, streamError(err)
+}
+
+func (s *Stream) CancelRead(code transport.StreamErrorCode) {
+	s.Stream.CancelRead(q.StreamErrorCode(code))
+}
+
+func (s *Stream) CancelWrite(code transport.StreamErrorCode) {
+	s.Stream.CancelWrite(q.StreamErrorCode(code))
+}
+
+func streamError(err error) error {
+	var se *q.StreamError
+	if errors.As(err, &se) {
+		return &transport.StreamError{Code: transport.StreamErrorCode(se.ErrorCode), Remote: se.Remote}
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+)
+
+func TestStreamReset(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ln, err := Listen("[::1]:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+	client, err := Dial(ctx, ln.AddrString())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer func() { _ = client.CloseWithError(0, "") }()
+	server, err := ln.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	cs, err := client.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+	_, _ = cs.Write([]byte("hi"))
+	ss, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	if _, err := io.ReadFull(ss, make([]byte, 2)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	cs.CancelWrite(transport.StreamCancelled)
+	var se *transport.StreamError
+	if _, err := ss.Read(make([]byte, 1)); !errors.As(err, &se) || se.Code != transport.StreamCancelled || !se.Remote {
+		t.Fatalf("Read after peer reset: %v", err)
+	}
+	if _, err := cs.Write([]byte("x")); !errors.As(err, &se) || se.Remote {
+		t.Fatalf("Write after local reset: %v", err)
+	}
+}
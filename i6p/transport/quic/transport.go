@@ -4,11 +4,16 @@ import (
 	"context"
 	"net"
 
+	"github.com/TheusHen/I6P/i6p/transport"
 	q "github.com/quic-go/quic-go"
 )
 
+var _ transport.Listener = (*Listener)(nil)
+
 type Listener struct {
 	inner *q.Listener
+	tr    *q.Transport // set when the listener owns its UDP socket
+	conn  net.PacketConn
 }
 
 func Listen(addr string) (*Listener, error) {
@@ -23,8 +28,12 @@ func Listen(addr string) (*Listener, error) {
 	return &Listener{inner: ln}, nil
 }
 
-func (l *Listener) Accept(ctx context.Context) (*q.Conn, error) {
-	return l.inner.Accept(ctx)
+func (l *Listener) Accept(ctx context.Context) (transport.Conn, error) {
+	c, err := l.inner.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c), nil
 }
 
 func (l *Listener) Addr() net.Addr { return l.inner.Addr() }
@@ -36,12 +45,23 @@ func (l *Listener) AddrString() string {
 	return l.inner.Addr().String()
 }
 
-func (l *Listener) Close() error { return l.inner.Close() }
+func (l *Listener) Close() error {
+	err := l.inner.Close()
+	if l.tr != nil {
+		_ = l.tr.Close()
+		_ = l.conn.Close()
+	}
+	return err
+}
 
-func Dial(ctx context.Context, addr string) (*q.Conn, error) {
+func Dial(ctx context.Context, addr string) (transport.Conn, error) {
 	tlsConf, err := NewClientTLSConfig()
 	if err != nil {
 		return nil, err
 	}
-	return q.DialAddr(ctx, addr, tlsConf, &q.Config{})
+	c, err := q.DialAddr(ctx, addr, tlsConf, &q.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c), nil
 }
@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"fmt"
+	"time"
+)
+
+// StreamErrorCode is the application error code a stream is reset with.
+// WebTransport codes are 32 bits wide; larger codes are truncated.
+type StreamErrorCode uint64
+
+// Stream error codes used by i6p. Applications may use any other value.
+const (
+	StreamNoError   StreamErrorCode = 0x0 // the stream is no longer needed
+	StreamCancelled StreamErrorCode = 0x1 // the request was abandoned, e.g. its context ended
+	StreamRefused   StreamErrorCode = 0x2 // the request was refused before it was processed
+	StreamProtocol  StreamErrorCode = 0x3 // the peer violated the stream's protocol
+	StreamInternal  StreamErrorCode = 0x4 // the stream failed for a local reason
+)
+
+func (c StreamErrorCode) String() string {
+	switch c {
+	case StreamNoError:
+		return "no error"
+	case StreamCancelled:
+		return "cancelled"
+	case StreamRefused:
+		return "refused"
+	case StreamProtocol:
+		return "protocol error"
+	case StreamInternal:
+		return "internal error"
+	default:
+		return fmt.Sprintf("code %#x", uint64(c))
+	}
+}
+
+// StreamError is returned by Read or Write once a direction of the stream
+// was cancelled. Remote tells whether the peer cancelled it.
+type StreamError struct {
+	Code   StreamErrorCode
+	Remote bool
+}
+
+func (e *StreamError) Error() string {
+	if e.Remote {
+		return "transport: stream reset by peer: " + e.Code.String()
+	}
+	return "transport: stream cancelled: " + e.Code.String()
+}
+
+// CloseWrite ends the write direction of st: the peer reads io.EOF once it
+// has read everything written before, and st stays readable. It is st.Close
+// under a name that says so.
+func CloseWrite(st Stream) error { return st.Close() }
+
+// Reset aborts both directions of st with code.
+func Reset(st Stream, code StreamErrorCode) {
+	st.CancelWrite(code)
+	st.CancelRead(code)
+}
+
+// SetTimeout sets the read and write deadlines of st to d from now. A zero
+// d clears them.
+func SetTimeout(st Stream, d time.Duration) error { return st.SetDeadline(deadline(d)) }
+
+// SetReadTimeout sets the read deadline of st to d from now. A zero d
+// clears it.
+func SetReadTimeout(st Stream, d time.Duration) error { return st.SetReadDeadline(deadline(d)) }
+
+// SetWriteTimeout sets the write deadline of st to d from now. A zero d
+// clears it.
+func SetWriteTimeout(st Stream, d time.Duration) error { return st.SetWriteDeadline(deadline(d)) }
+
+func deadline(d time.Duration) time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
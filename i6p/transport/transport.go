@@ -0,0 +1,90 @@
+// Package transport defines the connection abstraction the session layer runs on.
+//
+// QUIC (package transport/quic) is the production backend. Package
+// transport/memory provides an in-process backend for tests and co-located
+// peers that do not need UDP at all.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Stream is a reliable, ordered, bidirectional byte stream.
+// Close ends the write direction only; the peer then reads io.EOF, and the
+// read direction stays usable.
+type Stream interface {
+	io.ReadWriteCloser
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+
+	// CancelRead aborts the read direction: unread data is discarded, local
+	// reads and the peer's writes then fail with a *StreamError for code.
+	CancelRead(code StreamErrorCode)
+	// CancelWrite aborts the write direction: unsent data is discarded,
+	// local writes and the peer's reads then fail with a *StreamError for
+	// code. Close must still be called to release the stream.
+	CancelWrite(code StreamErrorCode)
+}
+
+// Conn is a connection that multiplexes streams between two endpoints.
+type Conn interface {
+	// OpenStreamSync opens a new stream, blocking while the peer's stream limit
+	// is reached.
+	OpenStreamSync(ctx context.Context) (Stream, error)
+	// AcceptStream returns the next stream opened by the peer.
+	AcceptStream(ctx context.Context) (Stream, error)
+
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+
+	// Context is cancelled when the connection is closed by either side.
+	Context() context.Context
+	// CloseWithError closes the connection and all of its streams.
+	CloseWithError(code uint64, msg string) error
+}
+
+// CloseError is the cause of Conn.Context once either side closed the
+// connection with CloseWithError, so the code and message reach the
+// application. Err is the transport's own error, if it has one.
+type CloseError struct {
+	Code    uint64
+	Message string
+	Remote  bool // the peer closed the connection; false if the transport cannot tell
+	Err     error
+}
+
+func (e *CloseError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("transport: connection closed (code %d: %s)", e.Code, e.Message)
+}
+
+func (e *CloseError) Unwrap() error { return e.Err }
+
+// Exporter is implemented by connections secured by TLS 1.3, such as QUIC.
+// ExportKeyingMaterial returns keying material derived from the connection
+// secrets (RFC 8446 section 7.5); both ends get the same bytes.
+type Exporter interface {
+	ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error)
+}
+
+// EarlyConn is implemented by connections that may carry data before their
+// handshake completes, such as QUIC connections using 0-RTT. An attacker can
+// replay such early data, so only idempotent requests may be sent before
+// HandshakeComplete is closed.
+type EarlyConn interface {
+	HandshakeComplete() <-chan struct{}
+}
+
+// Listener accepts incoming connections.
+type Listener interface {
+	Accept(ctx context.Context) (Conn, error)
+	Addr() net.Addr
+	Close() error
+}
@@ -0,0 +1,45 @@
+package webtransport
+
+import (
+	"errors"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+	wt "github.com/quic-go/webtransport-go"
+)
+
+// Stream adapts a WebTransport stream to transport.Stream, reporting resets
+// as *transport.StreamError. Error codes are truncated to 32 bits.
+type Stream struct {
+	*wt.Stream
+}
+
+var _ transport.Stream = (*Stream)(nil)
+
+// WebTransport returns the underlying WebTransport stream.
+func (s *Stream) WebTransport() *wt.Stream { return s.Stream }
+
+func (s *Stream) Read(b []byte) (int, error) {
+	n, err := s.Stream.Read(b)
+	return n, streamError(err)
+}
+
+func (s *Stream) Write(b []byte) (int, error) {
+	n, err := s.Stream.Write(b)
+	return n, streamError(err)
+}
+
+func (s *Stream) CancelRead(code transport.StreamErrorCode) {
+	s.Stream.CancelRead(wt.StreamErrorCode(code))
+}
+
+func (s *Stream) CancelWrite(code transport.StreamErrorCode) {
+	s.Stream.CancelWrite(wt.StreamErrorCode(code))
+}
+
+func streamError(err error) error {
+	var se *wt.StreamError
+	if errors.As(err, &se) {
+		return &transport.StreamError{Code: transport.StreamErrorCode(se.ErrorCode), Remote: se.Remote}
+	}
+	return err
+}
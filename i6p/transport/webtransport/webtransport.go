@@ -0,0 +1,193 @@
+// Package webtransport is a transport backend that accepts WebTransport
+// sessions (HTTP/3), so browser clients can run the I6P session handshake.
+//
+// Browsers only open WebTransport sessions to servers with certificates they
+// trust, so unlike transport/quic the listener needs a real TLS configuration,
+// e.g. from a CA-issued certificate or serverCertificateHashes pinning.
+// Peer identity is still established by the signed HELLO exchange.
+package webtransport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/TheusHen/I6P/i6p/transport"
+	"github.com/quic-go/quic-go/http3"
+	wt "github.com/quic-go/webtransport-go"
+)
+
+var (
+	ErrTLSConfigRequired = errors.New("webtransport: TLS config with a certificate is required")
+	ErrListenerClosed    = errors.New("webtransport: listener closed")
+)
+
+// DefaultPath is the HTTP path sessions are accepted on.
+const DefaultPath = "/i6p"
+
+// Config configures a WebTransport listener.
+type Config struct {
+	// TLSConfig must contain the server certificate. The h3 ALPN is added
+	// automatically.
+	TLSConfig *tls.Config
+	// Path is the URL path clients connect to (default DefaultPath).
+	Path string
+	// CheckOrigin validates the browser's Origin header. The default accepts
+	// only same-origin requests; set it to allow the pages that embed the client.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// Listener accepts WebTransport sessions and exposes them as transport.Conn.
+type Listener struct {
+	server    *wt.Server
+	conn      net.PacketConn
+	sessions  chan *wt.Session
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ transport.Listener = (*Listener)(nil)
+
+// Listen starts an HTTP/3 server on the UDP address addr and accepts
+// WebTransport sessions on cfg.Path.
+func Listen(addr string, cfg Config) (*Listener, error) {
+	if cfg.TLSConfig == nil || (len(cfg.TLSConfig.Certificates) == 0 && cfg.TLSConfig.GetCertificate == nil) {
+		return nil, ErrTLSConfigRequired
+	}
+	path := cfg.Path
+	if path == "" {
+		path = DefaultPath
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{
+		conn:     conn,
+		sessions: make(chan *wt.Session, 16),
+		done:     make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	l.server = &wt.Server{
+		H3: http3.Server{
+			TLSConfig: http3.ConfigureTLSConfig(cfg.TLSConfig.Clone()),
+			Handler:   mux,
+		},
+		CheckOrigin: cfg.CheckOrigin,
+	}
+	mux.HandleFunc(path, l.handle)
+
+	go func() {
+		_ = l.server.Serve(conn)
+	}()
+	return l, nil
+}
+
+func (l *Listener) handle(w http.ResponseWriter, r *http.Request) {
+	sess, err := l.server.Upgrade(w, r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	select {
+	case l.sessions <- sess:
+	case <-l.done:
+		_ = sess.CloseWithError(0, "listener closed")
+	}
+}
+
+func (l *Listener) Accept(ctx context.Context) (transport.Conn, error) {
+	select {
+	case s := <-l.sessions:
+		return &Conn{sess: s}, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *Listener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+func (l *Listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.server.Close()
+		_ = l.conn.Close()
+	})
+	return err
+}
+
+// Dial opens a WebTransport session to url (e.g. "https://host:443/i6p"),
+// for Go clients and tests. The session is closed with the returned Conn.
+func Dial(ctx context.Context, url string, tlsConf *tls.Config) (transport.Conn, error) {
+	d := &wt.Dialer{TLSClientConfig: http3.ConfigureTLSConfig(tlsConf.Clone())}
+	_, sess, err := d.Dial(ctx, url, nil)
+	if err != nil {
+		_ = d.Close()
+		return nil, err
+	}
+	go func() {
+		<-sess.Context().Done()
+		_ = d.Close()
+	}()
+	return &Conn{sess: sess}, nil
+}
+
+// Conn adapts a WebTransport session to transport.Conn.
+type Conn struct {
+	sess *wt.Session
+}
+
+var (
+	_ transport.Conn     = (*Conn)(nil)
+	_ transport.Exporter = (*Conn)(nil)
+)
+
+// Session returns the underlying WebTransport session.
+func (c *Conn) Session() *wt.Session { return c.sess }
+
+func (c *Conn) OpenStreamSync(ctx context.Context) (transport.Stream, error) {
+	st, err := c.sess.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{st}, nil
+}
+
+func (c *Conn) AcceptStream(ctx context.Context) (transport.Stream, error) {
+	st, err := c.sess.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{st}, nil
+}
+
+func (c *Conn) LocalAddr() net.Addr { return c.sess.LocalAddr() }
+
+func (c *Conn) RemoteAddr() net.Addr { return c.sess.RemoteAddr() }
+
+func (c *Conn) Context() context.Context { return c.sess.Context() }
+
+// CloseWithError closes the session. WebTransport error codes are 32 bits wide;
+// larger codes are truncated.
+func (c *Conn) CloseWithError(code uint64, msg string) error {
+	return c.sess.CloseWithError(wt.SessionErrorCode(code), msg)
+}
+
+// ExportKeyingMaterial exports keying material from the TLS session of the
+// underlying QUIC connection, which other WebTransport sessions on it share.
+func (c *Conn) ExportKeyingMaterial(label string, exportContext []byte, length int) ([]byte, error) {
+	cs := c.sess.ConnectionState().TLS
+	return cs.ExportKeyingMaterial(label, exportContext, length)
+}
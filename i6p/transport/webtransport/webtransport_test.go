@@ -0,0 +1,92 @@
+package webtransport
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/TheusHen/I6P/i6p/identity"
+	"github.com/TheusHen/I6P/i6p/session"
+	"github.com/TheusHen/I6P/i6p/transport/quic"
+)
+
+func TestListenRequiresCertificate(t *testing.T) {
+	if _, err := Listen("[::1]:0", Config{}); err != ErrTLSConfigRequired {
+		t.Fatalf("expected ErrTLSConfigRequired, got %v", err)
+	}
+}
+
+func TestHandshakeOverWebTransport(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A self-signed certificate stands in for a CA-issued one.
+	serverTLS, err := quic.NewServerTLSConfig()
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig: %v", err)
+	}
+	ln, err := Listen("[::1]:0", Config{TLSConfig: serverTLS})
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	serverKP, _ := identity.GenerateKeyPair()
+	clientKP, _ := identity.GenerateKeyPair()
+
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		sess, err := session.HandshakeServer(ctx, conn, serverKP, session.HandshakeOptions{})
+		if err != nil {
+			errCh <- err
+			return
+		}
+		st, err := sess.AcceptStream(ctx)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		_, err = io.Copy(st, st)
+		_ = st.Close()
+		errCh <- err
+	}()
+
+	url := "https://" + ln.Addr().String() + DefaultPath
+	conn, err := Dial(ctx, url, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer func() {
+		_ = conn.CloseWithError(0, "")
+	}()
+	sess, err := session.HandshakeClient(ctx, conn, clientKP, session.HandshakeOptions{})
+	if err != nil {
+		t.Fatalf("HandshakeClient: %v", err)
+	}
+	if sess.RemotePeerID() != serverKP.PeerID() {
+		t.Fatalf("unexpected remote peer ID")
+	}
+
+	st, err := sess.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	_, _ = st.Write([]byte("echo"))
+	_ = st.Close()
+	got, err := io.ReadAll(st)
+	if err != nil || string(got) != "echo" {
+		t.Fatalf("echo = %q, %v", got, err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+}